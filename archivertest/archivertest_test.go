@@ -0,0 +1,51 @@
+package archivertest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/worker"
+)
+
+func TestFakeSourceWorker(t *testing.T) {
+	src := NewFakeSource("shop", "orders", 95)
+	ig := &RecordingIngester{}
+	cfg := &config.Config{SourceSplitKey: "id", BatchSize: 10, MaxThread: 2, SourceRetryAttempts: 1}
+	w := worker.NewWorker(cfg, "shop.orders", ig, src)
+	w.Run(context.Background())
+	assert.NoError(t, w.Err())
+
+	assert.Equal(t, 95, ig.Rows())
+	seen := make(map[int64]bool)
+	for _, batch := range ig.Batches() {
+		assert.Equal(t, FakeColumns, batch.Columns)
+		for _, row := range batch.Rows {
+			seen[row[0].(int64)] = true
+		}
+	}
+	assert.Equal(t, 95, len(seen))
+	synced, sourced, ok := w.IsWorkerCorrect(context.Background())
+	assert.True(t, ok, "synced %d of %d rows", synced, sourced)
+}
+
+func TestFakeSourceConditions(t *testing.T) {
+	src := NewFakeSource("shop", "orders", 25)
+	ctx := context.Background()
+	rows, columns, err := src.QueryTableData(ctx, 1, "(id >= 5 and id < 8)")
+	assert.NoError(t, err)
+	assert.Equal(t, FakeColumns, columns)
+	assert.Equal(t, [][]interface{}{FakeRow(5), FakeRow(6), FakeRow(7)}, rows)
+
+	rows, _, err = src.QueryTableData(ctx, 1, "(id >= 1 and id <= 25) AND id > 20 ORDER BY id LIMIT 10")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(rows))
+	assert.Equal(t, int64(21), rows[0][0])
+	assert.Equal(t, 2, len(src.Conditions()))
+
+	tables, err := src.GetTablesAccordingToSourceTableRegex(ctx, "^orders$", []string{"shop"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"shop": {"orders"}}, tables)
+}
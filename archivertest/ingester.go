@@ -0,0 +1,72 @@
+package archivertest
+
+import (
+	"sync"
+
+	"github.com/avast/retry-go"
+
+	"github.com/databendcloud/bend-archiver/ingester"
+)
+
+// IngestedBatch is a batch handed to a RecordingIngester.
+type IngestedBatch struct {
+	Name    ingester.BatchName
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// RecordingIngester is an ingester that keeps the batches it is given
+// instead of staging them in Databend. Err, when set, fails every ingest.
+type RecordingIngester struct {
+	Err error
+
+	mu      sync.Mutex
+	batches []IngestedBatch
+	rows    int
+}
+
+// Batches returns the batches ingested so far, in the order they were
+// ingested.
+func (ig *RecordingIngester) Batches() []IngestedBatch {
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	return append([]IngestedBatch(nil), ig.batches...)
+}
+
+// Rows returns the rows ingested so far.
+func (ig *RecordingIngester) Rows() int {
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	return ig.rows
+}
+
+func (ig *RecordingIngester) IngestData(threadNum int, columns []string, batchJsonData [][]interface{}) error {
+	_, err := ig.IngestBatch(threadNum, ingester.BatchName{}, columns, batchJsonData)
+	return err
+}
+
+func (ig *RecordingIngester) IngestBatch(threadNum int, name ingester.BatchName, columns []string, batchJsonData [][]interface{}) (ingester.StagedBatch, error) {
+	if ig.Err != nil {
+		return ingester.StagedBatch{}, ig.Err
+	}
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	ig.batches = append(ig.batches, IngestedBatch{Name: name, Columns: columns, Rows: batchJsonData})
+	ig.rows += len(batchJsonData)
+	return ingester.StagedBatch{Stage: name.String(), Rows: len(batchJsonData)}, nil
+}
+
+func (ig *RecordingIngester) GetSnapshotID() (string, error) {
+	return "", nil
+}
+
+// GetAllSyncedCount returns the rows ingested so far, as if they were all
+// in the target table.
+func (ig *RecordingIngester) GetAllSyncedCount() (int, error) {
+	return ig.Rows(), nil
+}
+
+// DoRetry runs f once.
+func (ig *RecordingIngester) DoRetry(f retry.RetryableFunc) error {
+	return f()
+}
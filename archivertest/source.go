@@ -0,0 +1,170 @@
+// Package archivertest has a fake source and a recording ingester, to test
+// code that runs bend-archiver workers without a source database or
+// Databend. Unlike utils/testutils, which starts the Postgres the source
+// tests of this repo run against, it needs no server at all.
+package archivertest
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FakeColumns are the columns of the rows of a FakeSource.
+var FakeColumns = []string{"id", "name", "amount", "created_at"}
+
+// fakeEpoch is the created_at of the row with id 0.
+var fakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// FakeRow is the row of a FakeSource with id, the same in every run.
+func FakeRow(id int64) []interface{} {
+	return []interface{}{
+		id,
+		fmt.Sprintf("name-%d", id),
+		float64(id%1000) / 10,
+		fakeEpoch.Add(time.Duration(id) * time.Second).Format("2006-01-02 15:04:05"),
+	}
+}
+
+// FakeSource is a source of tables whose rows have the ids 1 to Rows, made
+// by FakeRow. It serves the ranges and keyset pages of the split key id, so
+// set sourceSplitKey to id, and records the conditions it was asked for.
+type FakeSource struct {
+	Rows int64
+	// BatchSize is returned by AdjustBatchSizeAccordingToSourceDbTable.
+	BatchSize uint64
+	// Tables are the tables found by the discovery methods, by database.
+	Tables map[string][]string
+
+	mu         sync.Mutex
+	conditions []string
+	deleted    bool
+}
+
+// NewFakeSource returns a FakeSource of rows rows in the table db.table.
+func NewFakeSource(db, table string, rows int64) *FakeSource {
+	return &FakeSource{Rows: rows, BatchSize: 1000, Tables: map[string][]string{db: {table}}}
+}
+
+// Conditions returns the conditions of the batches read so far, in the
+// order they were read.
+func (s *FakeSource) Conditions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.conditions...)
+}
+
+// Deleted reports whether DeleteAfterSync was called.
+func (s *FakeSource) Deleted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleted
+}
+
+func (s *FakeSource) AdjustBatchSizeAccordingToSourceDbTable(ctx context.Context) uint64 {
+	return s.BatchSize
+}
+
+func (s *FakeSource) GetSourceReadRowsCount(ctx context.Context) (int, error) {
+	return int(s.Rows), nil
+}
+
+func (s *FakeSource) GetAllSourceReadRowsCount(ctx context.Context) (int, error) {
+	return int(s.Rows), nil
+}
+
+func (s *FakeSource) GetMinMaxSplitKey(ctx context.Context) (uint64, uint64, error) {
+	if s.Rows <= 0 {
+		return 0, 0, nil
+	}
+	return 1, uint64(s.Rows), nil
+}
+
+func (s *FakeSource) GetMinMaxTimeSplitKey(ctx context.Context) (string, string, error) {
+	return "", "", fmt.Errorf("fake source has no time split key, use sourceSplitKey id")
+}
+
+func (s *FakeSource) GetDatabasesAccordingToSourceDbRegex(ctx context.Context, sourceDatabasePattern string) ([]string, error) {
+	re, err := regexp.Compile(sourceDatabasePattern)
+	if err != nil {
+		return nil, err
+	}
+	var dbs []string
+	for db := range s.Tables {
+		if re.MatchString(db) {
+			dbs = append(dbs, db)
+		}
+	}
+	return dbs, nil
+}
+
+func (s *FakeSource) GetTablesAccordingToSourceTableRegex(ctx context.Context, sourceTablePattern string, databases []string) (map[string][]string, error) {
+	re, err := regexp.Compile(sourceTablePattern)
+	if err != nil {
+		return nil, err
+	}
+	dbTables := make(map[string][]string)
+	for _, db := range databases {
+		for _, table := range s.Tables[db] {
+			if re.MatchString(table) {
+				dbTables[db] = append(dbTables[db], table)
+			}
+		}
+	}
+	return dbTables, nil
+}
+
+func (s *FakeSource) GetDbTablesAccordingToSourceDbTables(ctx context.Context) (map[string][]string, error) {
+	return s.Tables, nil
+}
+
+// bound matches the comparisons of the split key id and the limit of a
+// condition, e.g. (id >= 1 and id < 11) or id > 10 ORDER BY id LIMIT 10.
+var bound = regexp.MustCompile(`(?i)\bid\s*(>=|>|<=|<)\s*(\d+)|\bLIMIT\s+(\d+)`)
+
+// QueryTableData returns the rows whose id is within the bounds of
+// conditionSql, at most its LIMIT of them.
+func (s *FakeSource) QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	s.mu.Lock()
+	s.conditions = append(s.conditions, conditionSql)
+	s.mu.Unlock()
+
+	lo, hi, limit := int64(1), s.Rows, int64(-1)
+	for _, m := range bound.FindAllStringSubmatch(conditionSql, -1) {
+		if m[3] != "" {
+			limit, _ = strconv.ParseInt(m[3], 10, 64)
+			continue
+		}
+		n, _ := strconv.ParseInt(m[2], 10, 64)
+		switch m[1] {
+		case ">=":
+			lo = max(lo, n)
+		case ">":
+			lo = max(lo, n+1)
+		case "<=":
+			hi = min(hi, n)
+		case "<":
+			hi = min(hi, n-1)
+		}
+	}
+	var data [][]interface{}
+	for id := lo; id <= hi && (limit < 0 || int64(len(data)) < limit); id++ {
+		data = append(data, FakeRow(id))
+	}
+	return data, FakeColumns, nil
+}
+
+// DeleteAfterSync records that the rows were purged, the source still holds
+// them.
+func (s *FakeSource) DeleteAfterSync(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleted = true
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/source"
+	"github.com/databendcloud/bend-archiver/worker"
+)
+
+// runBench archives synthetic rows into the databendTable of a job config
+// through the whole pipeline and reports the throughput, to compare config
+// changes without touching real data.
+func runBench(args []string) {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	configFile := flags.String("f", "config/conf.json", "Path to the configuration file, its source settings are ignored")
+	rows := flags.Int64("rows", 0, "Rows to generate, overrides benchRows")
+	columns := flags.String("columns", "", "Comma separated name:type[:cardinality] columns, overrides benchColumns")
+	createTable := flags.Bool("create-table", true, "Create databendTable for the generated columns if it does not exist")
+	flags.Parse(args)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGQUIT, syscall.SIGTERM)
+	defer cancel()
+
+	cfg, err := config.LoadBenchConfig(*configFile)
+	if err != nil {
+		panic(err)
+	}
+	if *rows > 0 {
+		cfg.BenchRows = *rows
+	}
+	if *columns != "" {
+		cfg.BenchColumns = strings.Split(*columns, ",")
+	}
+	if err := ingester.ConfigureDatabendTransport(cfg); err != nil {
+		panic(err)
+	}
+	src, err := source.NewBenchSource(cfg)
+	if err != nil {
+		panic(err)
+	}
+	if *createTable {
+		if err := ingester.Exec(cfg, src.CreateTableSQL(cfg.DatabendTable)+ingester.TableOptionsSQL(cfg)); err != nil {
+			panic(err)
+		}
+	}
+	ig := ingester.NewDatabendIngester(cfg)
+	before, err := ig.GetAllSyncedCount()
+	if err != nil {
+		panic(err)
+	}
+
+	startTime := time.Now()
+	w := worker.NewWorker(cfg, "bench", ig, src)
+	w.Run(ctx)
+	elapsed := time.Since(startTime)
+
+	after, err := ig.GetAllSyncedCount()
+	if err != nil {
+		logrus.Errorf("count rows of %s failed: %v", cfg.DatabendTable, err)
+	}
+	ingested := after - before
+	fmt.Printf("bench: %d of %d rows ingested into %s in %s (%.0f rows/s), batchSize %d, maxThread %d\n",
+		ingested, cfg.BenchRows, cfg.DatabendTable, elapsed.Round(time.Millisecond),
+		float64(ingested)/elapsed.Seconds(), cfg.BatchSize, cfg.MaxThread)
+	logPhaseBreakdown()
+}
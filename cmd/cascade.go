@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/errcode"
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/source"
+	"github.com/databendcloud/bend-archiver/worker"
+)
+
+// childTable is a table of sourceChildTables with the config archiving it.
+type childTable struct {
+	cfg *config.Config
+	src source.TableSourcer
+}
+
+// newChildTables opens the child tables of cfg before the parent is
+// archived, so that a child that can't be read, purged or loaded fails the
+// job before anything is done.
+func newChildTables(ctx context.Context, cfg *config.Config) ([]childTable, error) {
+	var children []childTable
+	for i := range cfg.SourceChildTables {
+		childCfg := cfg.ChildConfig(i)
+		src, err := source.NewTableSource(childCfg)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errcode.ErrSourceUnavailable, err)
+		}
+		if err := worker.CheckPurge(ctx, childCfg, src); err != nil {
+			return nil, fmt.Errorf("%w: %w", errcode.ErrPurgeRefused, err)
+		}
+		// the target is counted whole, the condition of the rows is a query of the source
+		count, err := ingester.QueryCount(childCfg, "SELECT count(*) FROM "+childCfg.DatabendTable)
+		if err != nil {
+			return nil, fmt.Errorf("%w: pre-check failed: %w", errcode.ErrTargetUnavailable, err)
+		}
+		if count != 0 {
+			return nil, fmt.Errorf("syncedCount of %s is not 0, already ingested %d rows", childCfg.DatabendTable, count)
+		}
+		children = append(children, childTable{cfg: childCfg, src: src})
+	}
+	return children, nil
+}
+
+// archiveChildTables archives the children of the archived parent rows, a
+// table after the other, and checks their counts like the parent's. The
+// job fails before anything is purged when one of them doesn't match.
+func archiveChildTables(ctx context.Context, children []childTable) error {
+	for _, c := range children {
+		name := fmt.Sprintf("%s.%s", c.cfg.SourceDB, c.cfg.SourceTable)
+		if c.cfg.SourceSplitKey != "" {
+			c.cfg.BatchSize = int64(c.src.AdjustBatchSizeAccordingToSourceDbTable(ctx))
+		}
+		w := worker.NewWorker(c.cfg, name, ingester.NewDatabendIngester(c.cfg), c.src)
+		logrus.Infof("Start worker %s", w.Name)
+		w.Run(ctx)
+		if err := w.Err(); err != nil {
+			return fmt.Errorf("worker %s: %w", name, err)
+		}
+		if _, stopped := w.Stopped(); stopped {
+			return fmt.Errorf("worker %s stopped before the end, nothing was purged", name)
+		}
+		sourceCount, err := c.src.GetSourceReadRowsCount(ctx)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errcode.ErrSourceQuery, err)
+		}
+		targetCount, err := ingester.QueryCount(c.cfg, "SELECT count(*) FROM "+c.cfg.DatabendTable)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errcode.ErrTargetUnavailable, err)
+		}
+		if sourceCount != targetCount {
+			return fmt.Errorf("%w: %s source data count is %d, databend data count is %d", errcode.ErrCountMismatch, name,
+				sourceCount, targetCount)
+		}
+		logrus.Infof("Worker %s finished and data correct, source data count is %d", name, sourceCount)
+	}
+	return nil
+}
+
+// purgeChildTables deletes the archived rows of the child tables, the
+// grandchildren before their parents, while the parent rows their
+// conditions select are still there.
+func purgeChildTables(ctx context.Context, children []childTable) error {
+	for i := len(children) - 1; i >= 0; i-- {
+		c := children[i]
+		if err := c.src.DeleteAfterSync(ctx); err != nil {
+			return fmt.Errorf("purge child table %s: %w", c.cfg.SourceTable, err)
+		}
+		maintainSource(ctx, c.src, c.cfg.PurgeMaintenance)
+	}
+	return nil
+}
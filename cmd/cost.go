@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/errcode"
+	"github.com/databendcloud/bend-archiver/ingester"
+)
+
+// budgetOutcome is the outcome err of the job, failed with BUDGET_EXCEEDED
+// when it stopped at a budget spent with budgetAction abort.
+func budgetOutcome(cfg *config.Config, err error) error {
+	if !cfg.HasBudget() {
+		return err
+	}
+	spent := ingester.BudgetSpent(cfg)
+	if spent == nil {
+		return err
+	}
+	if cfg.BudgetAction == "abort" && (err == nil || errors.Is(err, errcode.ErrPartial)) {
+		return spent
+	}
+	logrus.Warnf("%v, the job paused, the next run goes on", spent)
+	return err
+}
+
+func logRunCost(cfg *config.Config) {
+	cost := ingester.RunCost()
+	if cfg.WarehouseCreditsPerHour > 0 {
+		logrus.Infof("run cost: %d bytes loaded, loads ran %.0fs, about %.3f credits", cost.BytesLoaded, cost.LoadSeconds, cost.Credits)
+		return
+	}
+	logrus.Infof("run cost: %d bytes loaded, loads ran %.0fs", cost.BytesLoaded, cost.LoadSeconds)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/errcode"
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/source"
+	"github.com/databendcloud/bend-archiver/worker"
+)
+
+// diffOptions are the flags of diff and repair.
+type diffOptions struct {
+	configFile, table, target string
+	from, to                  string
+	bucket                    uint64
+	columns                   string
+}
+
+func addDiffFlags(flags *flag.FlagSet) *diffOptions {
+	o := &diffOptions{}
+	flags.StringVar(&o.configFile, "f", "config/conf.json", "Path to the configuration file")
+	flags.StringVar(&o.table, "table", "", "Source db.table to compare, defaults to sourceDB.sourceTable")
+	flags.StringVar(&o.target, "target", "", "Databend table to compare with, defaults to databendTable")
+	flags.StringVar(&o.from, "from", "", "First key or time of the compared range, defaults to the smallest of the source")
+	flags.StringVar(&o.to, "to", "", "Last key or time of the compared range, defaults to the largest of the source")
+	flags.Uint64Var(&o.bucket, "bucket", 0, "Keys of a compared range with sourceSplitKey, defaults to batchSize")
+	flags.StringVar(&o.columns, "columns", "", "Comma separated columns summed up into the checksums, defaults to the split key")
+	return o
+}
+
+// config is the config of the job with the table and target of o.
+func (o *diffOptions) config() *config.Config {
+	cfg := parseConfigWithFile(o.configFile)
+	if o.table != "" {
+		db, name, ok := strings.Cut(o.table, ".")
+		if !ok {
+			fmt.Fprintf(os.Stderr, "-table must be db.table, got %s\n", o.table)
+			os.Exit(1)
+		}
+		cfg.SourceDB, cfg.SourceTable = db, name
+	}
+	if o.target != "" {
+		cfg.DatabendTable = o.target
+	}
+	return cfg
+}
+
+// runDiff compares the row counts and checksums of the ranges of a source
+// table with its archive in Databend and prints the ranges that disagree.
+func runDiff(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	o := addDiffFlags(flags)
+	all := flags.Bool("all", false, "Print every range, not only the ones that disagree")
+	flags.Parse(args)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGQUIT, syscall.SIGTERM, os.Interrupt)
+	defer cancel()
+	code, err := diff(ctx, o.config(), o, *all, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(errcode.CodeOf(err).ExitCode())
+	}
+	os.Exit(code)
+}
+
+// diff compares the ranges of the source of cfg picked by o with the target
+// and prints them to out. It returns the exit code of COUNT_MISMATCH when a
+// range disagrees.
+func diff(ctx context.Context, cfg *config.Config, o *diffOptions, all bool, out io.Writer) (int, error) {
+	c, err := newComparison(ctx, cfg, o)
+	if err != nil {
+		return 0, err
+	}
+	defer c.close()
+	diffs, err := worker.CompareRanges(ctx, cfg, c.src, c.conditions, c.columns)
+	if err != nil {
+		return 0, err
+	}
+	if writeDiffs(out, diffs, all) > 0 {
+		return errcode.CountMismatch.ExitCode(), nil
+	}
+	return 0, nil
+}
+
+// comparison is the source, the ranges and the columns compared by diff
+// and repair.
+type comparison struct {
+	src        source.TableSourcer
+	conditions []string
+	columns    []string
+	close      func()
+}
+
+// newComparison connects to the source and the target of cfg and plans the
+// ranges and columns of o.
+func newComparison(ctx context.Context, cfg *config.Config, o *diffOptions) (*comparison, error) {
+	if cfg.SourceSplitKey == "" && cfg.SourceSplitTimeKey == "" {
+		return nil, fmt.Errorf("%w: diff needs sourceSplitKey or sourceSplitTimeKey", errcode.ErrConfigInvalid)
+	}
+	tunnel, err := source.OpenSSHTunnel(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errcode.ErrSourceUnavailable, err)
+	}
+	c := &comparison{close: func() {}}
+	if tunnel != nil {
+		c.close = func() { tunnel.Close() }
+	}
+	if c.src, err = source.NewTableSource(cfg); err != nil {
+		c.close()
+		return nil, fmt.Errorf("%w: %w", errcode.ErrSourceUnavailable, err)
+	}
+	if err := ingester.ConfigureDatabendTransport(cfg); err != nil {
+		c.close()
+		return nil, fmt.Errorf("%w: %w", errcode.ErrTargetUnavailable, err)
+	}
+	if c.conditions, err = diffConditions(ctx, cfg, c.src, o.from, o.to, o.bucket); err != nil {
+		c.close()
+		return nil, err
+	}
+	c.columns = []string{cfg.SourceSplitKey}
+	if cfg.SourceSplitKey == "" {
+		c.columns = []string{cfg.SourceSplitTimeKey}
+	}
+	if o.columns != "" {
+		c.columns = strings.Split(o.columns, ",")
+		for i := range c.columns {
+			c.columns[i] = strings.TrimSpace(c.columns[i])
+		}
+	}
+	return c, nil
+}
+
+// diffConditions are the ranges from from to to the job would read, the
+// bounds left empty are those of the source.
+func diffConditions(ctx context.Context, cfg *config.Config, src source.SourcePlanner, from, to string, bucket uint64) ([]string, error) {
+	if cfg.SourceSplitKey == "" {
+		if from == "" || to == "" {
+			minKey, maxKey, err := src.GetMinMaxTimeSplitKey(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", errcode.ErrSourceQuery, err)
+			}
+			from, to = orDefault(from, minKey), orDefault(to, maxKey)
+		}
+		if from == "" {
+			return nil, nil
+		}
+		return source.SplitConditionAccordingToTimeSplitKey(cfg, from, to)
+	}
+
+	var minKey, maxKey uint64
+	if from == "" || to == "" {
+		var err error
+		minKey, maxKey, err = src.GetMinMaxSplitKey(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errcode.ErrSourceQuery, err)
+		}
+		if minKey == 0 && maxKey == 0 {
+			return nil, nil
+		}
+	}
+	for _, bound := range []struct {
+		value string
+		key   *uint64
+	}{{from, &minKey}, {to, &maxKey}} {
+		if bound.value == "" {
+			continue
+		}
+		key, err := strconv.ParseUint(bound.value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: -from and -to must be unsigned integers with sourceSplitKey: %w", errcode.ErrConfigInvalid, err)
+		}
+		*bound.key = key
+	}
+	if bucket == 0 {
+		bucket = uint64(cfg.BatchSize)
+	}
+	conditions := source.SplitCondition(cfg.SourceSplitKey, bucket, minKey, maxKey)
+	if n := uint64(len(conditions) - 1); n > 0 && minKey+n*bucket > maxKey && minKey+n*bucket > minKey {
+		// the range past maxKey the job reads at the end has no keys
+		conditions = conditions[:n]
+	}
+	return conditions, nil
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// writeDiffs prints the ranges of diffs that disagree, or all of them, and
+// returns how many disagree.
+func writeDiffs(out io.Writer, diffs []worker.RangeDiff, all bool) int {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RANGE\tSOURCE ROWS\tTARGET ROWS\tCHECKSUM")
+	differ := 0
+	for _, d := range diffs {
+		checksum := "ok"
+		if d.Differs() {
+			differ++
+			if d.SourceChecksum != d.TargetChecksum {
+				checksum = fmt.Sprintf("%016x != %016x", d.SourceChecksum, d.TargetChecksum)
+			}
+		} else if !all {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", d.Condition, d.SourceRows, d.TargetRows, checksum)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "%d of %d ranges disagree\n", differ, len(diffs))
+	return differ
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/source"
+	"github.com/databendcloud/bend-archiver/worker"
+)
+
+type rangePlanner struct {
+	source.SourcePlanner
+	minKey, maxKey uint64
+}
+
+func (p rangePlanner) GetMinMaxSplitKey(ctx context.Context) (uint64, uint64, error) {
+	return p.minKey, p.maxKey, nil
+}
+
+func TestDiffConditions(t *testing.T) {
+	cfg := &config.Config{SourceSplitKey: "id", BatchSize: 100}
+	planner := rangePlanner{minKey: 1, maxKey: 250}
+	conditions, err := diffConditions(context.Background(), cfg, planner, "", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"(id >= 1 and id < 101)", "(id >= 101 and id < 201)", "(id >= 201 and id < 301)"}, conditions)
+
+	conditions, err = diffConditions(context.Background(), cfg, planner, "101", "", 50)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"(id >= 101 and id < 151)", "(id >= 151 and id < 201)", "(id >= 201 and id < 251)"}, conditions)
+
+	_, err = diffConditions(context.Background(), cfg, planner, "abc", "", 0)
+	assert.Error(t, err)
+
+	conditions, err = diffConditions(context.Background(), cfg, rangePlanner{}, "", "", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, conditions)
+}
+
+func TestWriteDiffs(t *testing.T) {
+	diffs := []worker.RangeDiff{
+		{Condition: "(id >= 1 and id < 101)", SourceRows: 100, TargetRows: 100, SourceChecksum: 7, TargetChecksum: 7},
+		{Condition: "(id >= 101 and id < 201)", SourceRows: 100, TargetRows: 98, SourceChecksum: 7, TargetChecksum: 5},
+	}
+	var out bytes.Buffer
+	assert.Equal(t, 1, writeDiffs(&out, diffs, false))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Contains(t, lines[1], "(id >= 101 and id < 201)")
+	assert.Contains(t, lines[1], "0000000000000007 != 0000000000000005")
+	assert.Equal(t, "1 of 2 ranges disagree", lines[2])
+
+	out.Reset()
+	writeDiffs(&out, diffs, true)
+	assert.Contains(t, out.String(), "(id >= 1 and id < 101)")
+}
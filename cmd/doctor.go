@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// doctor prints the outcome of the checks of the doctor subcommand.
+type doctor struct {
+	out    io.Writer
+	failed int
+}
+
+// check reports the outcome of check name, with fix telling how to
+// remediate a failure. It returns whether the check passed.
+func (d *doctor) check(name string, err error, fix string) bool {
+	if err == nil {
+		fmt.Fprintf(d.out, "[ok]   %s\n", name)
+		return true
+	}
+	d.failed++
+	fmt.Fprintf(d.out, "[FAIL] %s: %v\n", name, err)
+	if fix != "" {
+		fmt.Fprintf(d.out, "       fix: %s\n", fix)
+	}
+	return false
+}
+
+func (d *doctor) skip(name, reason string) {
+	fmt.Fprintf(d.out, "[skip] %s: %s\n", name, reason)
+}
+
+// grantFix is the statement granting privilege on the source table of cfg.
+func grantFix(cfg *config.Config, privilege string) string {
+	switch cfg.DatabaseType {
+	case "mysql", "tidb":
+		return fmt.Sprintf("GRANT %s ON %s.%s TO '%s'@'%%'", privilege, cfg.SourceDB, cfg.SourceTable, cfg.SourceUser)
+	case "pg":
+		return fmt.Sprintf("GRANT %s ON %s TO %s, in database %s", privilege, cfg.SourceTable, cfg.SourceUser, cfg.SourceDB)
+	case "mssql":
+		return fmt.Sprintf("GRANT %s ON %s TO %s", privilege, cfg.SourceTable, cfg.SourceUser)
+	case "file":
+		if privilege == "DELETE" {
+			return fmt.Sprintf("give the user running bend-archiver write access to %s", cfg.SourcePath)
+		}
+		return fmt.Sprintf("give the user running bend-archiver read access to %s", cfg.SourcePath)
+	default:
+		return fmt.Sprintf("grant %s on %s to %s", privilege, cfg.SourceTable, cfg.SourceUser)
+	}
+}
+
+// checkSourcePrivileges checks that the user of src can read the source
+// table, and delete from it when the job deletes what it archived.
+func (d *doctor) checkSourcePrivileges(ctx context.Context, cfg *config.Config, src source.Sourcer, name string) {
+	privileges := []string{"SELECT"}
+	if cfg.DeleteAfterSync || cfg.MoveAfterSync != "" {
+		privileges = append(privileges, "DELETE")
+	}
+	pc, ok := src.(source.PrivilegeChecker)
+	if !ok {
+		d.skip(fmt.Sprintf("grants on %s", name), fmt.Sprintf("can't check the grants of %s sources", cfg.DatabaseType))
+		return
+	}
+	for _, privilege := range privileges {
+		granted, err := pc.HasTablePrivilege(ctx, privilege)
+		if err == nil && !granted {
+			err = fmt.Errorf("%s has no %s privilege", cfg.SourceUser, privilege)
+			if cfg.IsFileSource() {
+				err = fmt.Errorf("no %s access", privilege)
+			}
+		}
+		d.check(fmt.Sprintf("%s on %s", privilege, name), err, grantFix(cfg, privilege))
+	}
+}
+
+// runDoctor checks that a job can run: its config, the connection to the
+// source and the grants it needs there, the connection to Databend, the
+// target table and the stage.
+func runDoctor(args []string) {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configFile := flags.String("f", "config/conf.json", "Path to the configuration file")
+	flags.Parse(args)
+
+	d := &doctor{out: os.Stdout}
+	cfg, err := config.ValidateConfigFile(*configFile)
+	if !d.check("config", err, "fix the setting named in the error, see the README") {
+		os.Exit(1)
+	}
+	d.runChecks(context.Background(), cfg)
+	if d.failed > 0 {
+		fmt.Printf("%d checks failed\n", d.failed)
+		os.Exit(1)
+	}
+	fmt.Println("All checks passed")
+}
+
+func (d *doctor) runChecks(ctx context.Context, cfg *config.Config) {
+	tunnel, err := source.OpenSSHTunnel(cfg)
+	if !d.check("ssh tunnel", err, "check sourceSSHHost, sourceSSHUser, sourceSSHKeyFile and sourceSSHKnownHosts") {
+		return
+	}
+	if tunnel != nil {
+		defer tunnel.Close()
+	}
+	src, err := source.NewSource(cfg)
+	if d.check("source connection", err, "check sourceHost, sourcePort, sourceUser and sourcePass (or sourcePath), "+
+		"and that this host can reach the source") {
+		if planner, ok := src.(source.SourcePlanner); ok {
+			d.checkSourceTables(ctx, cfg, planner)
+		} else {
+			d.checkSourcePrivileges(ctx, cfg, src, cfg.SourcePath)
+		}
+	}
+
+	err = ingester.ConfigureDatabendTransport(cfg)
+	if err == nil {
+		err = ingester.Exec(cfg, "SELECT 1")
+	}
+	if !d.check("databend connection", err, "check databendDSN (host, port, user, password, sslmode), "+
+		"databendWarehouse and the databend TLS and proxy settings") {
+		return
+	}
+	features, err := ingester.ConfigureFeatures(cfg)
+	name := "databend features"
+	if err == nil && features.StageType != "" {
+		name = fmt.Sprintf("databend features (%s, %s stage)", features.Version, features.StageType)
+	} else if err == nil {
+		name = fmt.Sprintf("databend features (%s)", features.Version)
+	}
+	d.check(name, err, "create userStage, or set it to ~ for the stage of the Databend user")
+	_, err = ingester.TableColumns(cfg, cfg.DatabendTable)
+	d.check(fmt.Sprintf("target table %s", cfg.DatabendTable), err,
+		"create databendTable with the columns of the source, or grant its user access to it")
+	if cfg.IngestMode == "insert" {
+		d.skip(fmt.Sprintf("stage @%s", cfg.UserStage), "ingestMode insert stages nothing")
+		return
+	}
+	err = ingester.CheckStageAccess(cfg)
+	d.check(fmt.Sprintf("stage @%s", cfg.UserStage), err, fmt.Sprintf(
+		"grant the Databend user WRITE on the stage (GRANT WRITE ON STAGE %s TO ...), "+
+			"and check that this host can reach the object storage of the stage, through databendProxy if needed", cfg.UserStage))
+}
+
+// checkSourceTables checks that tables of the source match the config and
+// the grants on each of them.
+func (d *doctor) checkSourceTables(ctx context.Context, cfg *config.Config, src source.SourcePlanner) {
+	if cfg.SourceSelect != "" {
+		// the grants of the tables of the query are up to the source
+		_, err := src.GetSourceReadRowsCount(ctx)
+		d.check("source query", err, "check that sourceSelect runs on the source and that sourceWhereCondition applies to its columns")
+		return
+	}
+	var dbTables map[string][]string
+	var err error
+	if len(cfg.SourceDbTables) != 0 {
+		dbTables, err = src.GetDbTablesAccordingToSourceDbTables(ctx)
+	} else {
+		var dbs []string
+		dbs, err = src.GetDatabasesAccordingToSourceDbRegex(ctx, fmt.Sprintf("^%s$", cfg.SourceDB))
+		if err == nil {
+			dbTables, err = src.GetTablesAccordingToSourceTableRegex(ctx, fmt.Sprintf("^%s$", cfg.SourceTable), dbs)
+		}
+	}
+	tables := 0
+	for _, t := range dbTables {
+		tables += len(t)
+	}
+	if err == nil && tables == 0 {
+		err = errors.New("no table matches")
+	}
+	if !d.check("source tables", err, "check sourceDB and sourceTable (or sourceDbTables), and that sourceUser can see the tables") {
+		return
+	}
+	for db, tables := range dbTables {
+		for _, table := range tables {
+			cfgCopy := *cfg
+			cfgCopy.SourceDB = db
+			cfgCopy.SourceTable = table
+			tableSrc, err := source.NewSource(&cfgCopy)
+			if err != nil {
+				d.check(fmt.Sprintf("source table %s.%s", db, table), err, "")
+				continue
+			}
+			d.checkSourcePrivileges(ctx, &cfgCopy, tableSrc, fmt.Sprintf("%s.%s", db, table))
+		}
+	}
+}
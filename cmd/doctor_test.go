@@ -0,0 +1,23 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestDoctorCheck(t *testing.T) {
+	out := &bytes.Buffer{}
+	d := &doctor{out: out}
+	assert.True(t, d.check("databend connection", nil, "check databendDSN"))
+	cfg := &config.Config{DatabaseType: "mysql", SourceDB: "shop", SourceTable: "orders", SourceUser: "archiver"}
+	assert.False(t, d.check("DELETE on shop.orders", errors.New("archiver has no DELETE privilege"), grantFix(cfg, "DELETE")))
+	assert.Equal(t, 1, d.failed)
+	assert.Equal(t, "[ok]   databend connection\n"+
+		"[FAIL] DELETE on shop.orders: archiver has no DELETE privilege\n"+
+		"       fix: GRANT DELETE ON shop.orders TO 'archiver'@'%'\n", out.String())
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// heartbeat is written every heartbeatInterval while a job runs. Timestamp
+// shows the process is alive, LastProgressAt that its threads still move:
+// a job whose LastProgressAt is far behind Timestamp is hung.
+type heartbeat struct {
+	Pid            int       `json:"pid"`
+	JobID          string    `json:"jobId"`
+	Tenant         string    `json:"tenant,omitempty"`
+	RunID          string    `json:"runId"`
+	StartedAt      time.Time `json:"startedAt"`
+	Timestamp      time.Time `json:"timestamp"`
+	RowsRead       int64     `json:"rowsRead"`
+	BatchesCopied  int       `json:"batchesCopied"`
+	LastProgressAt time.Time `json:"lastProgressAt"`
+}
+
+type heartbeater struct {
+	cfg    *config.Config
+	client *http.Client
+	last   heartbeat
+}
+
+func newHeartbeater(cfg *config.Config, startedAt time.Time) *heartbeater {
+	return &heartbeater{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		last:   heartbeat{Pid: os.Getpid(), JobID: cfg.JobID, Tenant: cfg.Tenant, RunID: cfg.RunID, StartedAt: startedAt, LastProgressAt: startedAt},
+	}
+}
+
+// next is the heartbeat at now, its progress moves on when rows were read
+// or batches copied since the last one.
+func (h *heartbeater) next(now time.Time, rowsRead int64, batchesCopied int) heartbeat {
+	hb := h.last
+	hb.Timestamp = now
+	if rowsRead != hb.RowsRead || batchesCopied != hb.BatchesCopied {
+		hb.RowsRead, hb.BatchesCopied, hb.LastProgressAt = rowsRead, batchesCopied, now
+	}
+	h.last = hb
+	return hb
+}
+
+func (h *heartbeater) beat() {
+	var rowsRead int64
+	for _, s := range source.LiveStats() {
+		rowsRead += s.Rows
+	}
+	var batchesCopied int
+	for _, s := range ingester.PhaseBreakdown() {
+		if s.Phase == ingester.PhaseCopy {
+			batchesCopied = s.Batches
+		}
+	}
+	hb := h.next(time.Now(), rowsRead, batchesCopied)
+	data, err := json.Marshal(hb)
+	if err != nil {
+		logrus.Errorf("encode heartbeat failed: %v", err)
+		return
+	}
+	if h.cfg.HeartbeatFile != "" {
+		if err := writeHeartbeatFile(h.cfg.HeartbeatFile, data); err != nil {
+			logrus.Warnf("write heartbeat file %s failed: %v", h.cfg.HeartbeatFile, err)
+		}
+	}
+	if h.cfg.HeartbeatURL != "" {
+		if err := h.post(data); err != nil {
+			logrus.Warnf("post heartbeat to %s failed: %v", h.cfg.HeartbeatURL, err)
+		}
+	}
+}
+
+func writeHeartbeatFile(path string, data []byte) error {
+	tmpFile := path + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, path)
+}
+
+func (h *heartbeater) post(data []byte) error {
+	resp, err := h.client.Post(h.cfg.HeartbeatURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// runHeartbeat beats right away and then every heartbeatInterval until ctx
+// is done, when neither heartbeatFile nor heartbeatURL is set it does nothing.
+func runHeartbeat(ctx context.Context, cfg *config.Config, startedAt time.Time) {
+	if cfg.HeartbeatFile == "" && cfg.HeartbeatURL == "" {
+		return
+	}
+	interval, _ := time.ParseDuration(cfg.HeartbeatInterval)
+	h := newHeartbeater(cfg, startedAt)
+	h.beat()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.beat()
+		}
+	}
+}
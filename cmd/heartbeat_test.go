@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestHeartbeatProgress(t *testing.T) {
+	startedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := newHeartbeater(&config.Config{}, startedAt)
+
+	hb := h.next(startedAt.Add(10*time.Second), 100, 1)
+	assert.Equal(t, startedAt.Add(10*time.Second), hb.LastProgressAt)
+
+	// no rows read nor batches copied since, the job may be hung
+	hb = h.next(startedAt.Add(20*time.Second), 100, 1)
+	assert.Equal(t, startedAt.Add(20*time.Second), hb.Timestamp)
+	assert.Equal(t, startedAt.Add(10*time.Second), hb.LastProgressAt)
+
+	hb = h.next(startedAt.Add(30*time.Second), 100, 2)
+	assert.Equal(t, startedAt.Add(30*time.Second), hb.LastProgressAt)
+	assert.Equal(t, 2, hb.BatchesCopied)
+	assert.Equal(t, startedAt, hb.StartedAt)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/errcode"
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// historyEntry is a run of a job in its history.
+type historyEntry struct {
+	JobID      string       `json:"jobId"`
+	Tenant     string       `json:"tenant,omitempty"`
+	RunID      string       `json:"runId"`
+	Status     string       `json:"status"`
+	Code       errcode.Code `json:"code"`
+	Error      string       `json:"error,omitempty"`
+	StartedAt  time.Time    `json:"startedAt"`
+	FinishedAt time.Time    `json:"finishedAt"`
+	Rows       int64        `json:"rows"`
+	Bytes      int64        `json:"bytes"`
+	// Reports are the files the run wrote about itself by kind, e.g. status
+	Reports map[string]string `json:"reports,omitempty"`
+}
+
+func newHistoryEntry(cfg *config.Config, status jobStatus) historyEntry {
+	entry := historyEntry{
+		JobID:      status.JobID,
+		Tenant:     status.Tenant,
+		RunID:      status.RunID,
+		Status:     status.Status,
+		Code:       status.Code,
+		Error:      status.Error,
+		StartedAt:  status.StartedAt,
+		FinishedAt: status.FinishedAt,
+	}
+	for _, s := range source.LiveStats() {
+		entry.Rows += s.Rows
+	}
+	if status.Cost != nil {
+		entry.Bytes = status.Cost.BytesLoaded
+	}
+	reports := map[string]string{
+		"status":     cfg.StatusFile,
+		"log":        cfg.LogFile,
+		"manifest":   cfg.ArchiveManifestFile,
+		"deadLetter": cfg.DeadLetterFile,
+	}
+	for kind, report := range reports {
+		if report != "" {
+			if entry.Reports == nil {
+				entry.Reports = make(map[string]string)
+			}
+			entry.Reports[kind] = report
+		}
+	}
+	return entry
+}
+
+// recordHistory appends the run of status to the historyFile and the
+// historyTable of cfg, a failure is only logged.
+func recordHistory(cfg *config.Config, status jobStatus) {
+	if cfg.HistoryFile == "" && cfg.HistoryTable == "" {
+		return
+	}
+	entry := newHistoryEntry(cfg, status)
+	if cfg.HistoryFile != "" {
+		if err := appendHistoryFile(cfg.HistoryFile, entry); err != nil {
+			logrus.Errorf("write history file %s failed: %v", cfg.HistoryFile, err)
+		}
+	}
+	if cfg.HistoryTable != "" {
+		if err := insertHistory(cfg, entry); err != nil {
+			logrus.Errorf("write history table %s failed: %v", cfg.HistoryTable, err)
+		}
+	}
+}
+
+func appendHistoryFile(path string, entry historyEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readHistoryFile reads the runs of path, of jobID unless it is empty. A
+// missing file has no runs.
+func readHistoryFile(path, jobID string) ([]historyEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		if jobID == "" || entry.JobID == jobID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), "'", `\'`) + "'"
+}
+
+// insertHistory inserts entry into the historyTable of cfg, creating it
+// first. The run is kept as JSON next to the columns it is looked up by.
+func insertHistory(cfg *config.Config, entry historyEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := ingester.Exec(cfg, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (job_id STRING, run_id STRING, started_at TIMESTAMP, run STRING)", cfg.HistoryTable)); err != nil {
+		return err
+	}
+	return ingester.Exec(cfg, fmt.Sprintf("INSERT INTO %s (job_id, run_id, started_at, run) VALUES (%s, %s, %s, %s)", cfg.HistoryTable,
+		quoteString(entry.JobID), quoteString(entry.RunID), quoteString(entry.StartedAt.UTC().Format("2006-01-02 15:04:05.000000")), quoteString(string(data))))
+}
+
+// queryHistory reads the last limit runs of the historyTable of cfg, of
+// jobID unless it is empty.
+func queryHistory(cfg *config.Config, jobID string, limit int) ([]historyEntry, error) {
+	query := "SELECT run FROM " + cfg.HistoryTable
+	if jobID != "" {
+		query += " WHERE job_id = " + quoteString(jobID)
+	}
+	query += fmt.Sprintf(" ORDER BY started_at DESC LIMIT %d", limit)
+	rows, err := ingester.Query(cfg, query)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]historyEntry, 0, len(rows))
+	for _, row := range rows {
+		var entry historyEntry
+		if err := json.Unmarshal([]byte(row[0]), &entry); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.HistoryTable, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// lastRuns are the limit latest of entries, the latest first.
+func lastRuns(entries []historyEntry, limit int) []historyEntry {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedAt.After(entries[j].StartedAt)
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+func writeHistory(out io.Writer, entries []historyEntry) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tRUN\tSTARTED\tDURATION\tSTATUS\tCODE\tROWS\tBYTES\tREPORTS")
+	for _, e := range entries {
+		kinds := make([]string, 0, len(e.Reports))
+		for kind := range e.Reports {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		reports := make([]string, len(kinds))
+		for i, kind := range kinds {
+			reports[i] = kind + "=" + e.Reports[kind]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n", e.JobID, e.RunID, e.StartedAt.Local().Format("2006-01-02 15:04:05"),
+			e.FinishedAt.Sub(e.StartedAt).Round(time.Second), e.Status, e.Code, e.Rows, e.Bytes, strings.Join(reports, " "))
+	}
+	w.Flush()
+}
+
+// runHistory lists the past runs recorded in the historyFile, or else the
+// historyTable, of a job config.
+func runHistory(args []string) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	configFile := flags.String("f", "config/conf.json", "Path to the configuration file")
+	jobID := flags.String("job", "", "List the runs of this jobId only")
+	limit := flags.Int("n", 20, "Number of runs listed, the latest first")
+	asJSON := flags.Bool("json", false, "Print the runs as JSON lines")
+	flags.Parse(args)
+
+	cfg := parseConfigWithFile(*configFile)
+	var entries []historyEntry
+	var err error
+	switch {
+	case cfg.HistoryFile != "":
+		entries, err = readHistoryFile(cfg.HistoryFile, *jobID)
+	case cfg.HistoryTable != "":
+		if err = ingester.ConfigureDatabendTransport(cfg); err == nil {
+			entries, err = queryHistory(cfg, *jobID, *limit)
+		}
+	default:
+		err = fmt.Errorf("no historyFile or historyTable in %s", *configFile)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	entries = lastRuns(entries, *limit)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			enc.Encode(e)
+		}
+		return
+	}
+	writeHistory(os.Stdout, entries)
+}
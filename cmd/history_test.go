@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/errcode"
+)
+
+func TestHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	entries, err := readHistoryFile(path, "")
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	cfg := &config.Config{StatusFile: "/var/run/orders.status.json"}
+	startedAt := time.Date(2026, 10, 1, 2, 0, 0, 0, time.UTC)
+	for i, jobID := range []string{"orders", "users", "orders"} {
+		status := jobStatus{JobID: jobID, RunID: jobID + "-run", Status: "succeeded", Code: errcode.OK,
+			StartedAt: startedAt.Add(time.Duration(i) * time.Hour), FinishedAt: startedAt.Add(time.Duration(i)*time.Hour + time.Minute)}
+		assert.NoError(t, appendHistoryFile(path, newHistoryEntry(cfg, status)))
+	}
+
+	entries, err = readHistoryFile(path, "orders")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	entries = lastRuns(entries, 1)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, startedAt.Add(2*time.Hour), entries[0].StartedAt.UTC())
+	assert.Equal(t, map[string]string{"status": "/var/run/orders.status.json"}, entries[0].Reports)
+
+	var out bytes.Buffer
+	writeHistory(&out, entries)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[1], "orders-run")
+	assert.Contains(t, lines[1], "1m0s")
+	assert.Contains(t, lines[1], "status=/var/run/orders.status.json")
+
+	assert.NoError(t, os.WriteFile(path, []byte("{not json\n"), 0644))
+	_, err = readHistoryFile(path, "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "line 1")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/internal/lineage"
+)
+
+// jobInputs are the inputs of the START lineage event of the job running,
+// nil until it was emitted.
+var jobInputs atomic.Pointer[[]lineage.Dataset]
+
+// startLineage emits the START event of the job reading dbTables, or the
+// files of a file source when it is nil. A lineage backend that is down is
+// logged, the job still runs.
+func startLineage(ctx context.Context, cfg *config.Config, dbTables map[string][]string) {
+	if cfg.OpenLineageURL == "" {
+		return
+	}
+	var inputs []lineage.Dataset
+	if dbTables == nil {
+		inputs = append(inputs, lineage.SourceDataset(cfg, ""))
+	}
+	for db, tables := range dbTables {
+		for _, table := range tables {
+			inputs = append(inputs, lineage.SourceDataset(cfg, db+"."+table))
+		}
+	}
+	jobInputs.Store(&inputs)
+	if err := lineage.Emit(ctx, cfg, lineage.NewRunEvent(cfg, lineage.Start, inputs, "")); err != nil {
+		logrus.Warnf("emit lineage START to %s failed: %v", cfg.OpenLineageURL, err)
+	}
+}
+
+// finishLineage emits the COMPLETE, FAIL or ABORT event of the job of
+// status, once its START was emitted.
+func finishLineage(cfg *config.Config, status jobStatus) {
+	inputs := jobInputs.Load()
+	if cfg.OpenLineageURL == "" || inputs == nil {
+		return
+	}
+	eventType := lineage.Complete
+	switch status.Status {
+	case "failed":
+		eventType = lineage.Fail
+	case "partial":
+		eventType = lineage.Abort
+	}
+	if err := lineage.Emit(context.Background(), cfg, lineage.NewRunEvent(cfg, eventType, *inputs, status.Error)); err != nil {
+		logrus.Warnf("emit lineage %s to %s failed: %v", eventType, cfg.OpenLineageURL, err)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "github.com/sirupsen/logrus"
+
+// jobFields adds the job and run ids, and the tenant when set, to every log
+// entry, so that the logs of concurrent jobs shipped to one place can be told
+// apart.
+type jobFields struct {
+	jobID, runID, tenant string
+}
+
+func (h jobFields) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h jobFields) Fire(entry *logrus.Entry) error {
+	entry.Data["job_id"] = h.jobID
+	entry.Data["run_id"] = h.runID
+	if h.tenant != "" {
+		entry.Data["tenant"] = h.tenant
+	}
+	return nil
+}
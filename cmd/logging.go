@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/internal/sqllog"
+)
+
+// levelWriter writes a formatted log line at the severity of its level,
+// like syslog does.
+type levelWriter interface {
+	WriteLevel(level logrus.Level, line []byte) error
+}
+
+// configureLogging sends the logs of the job to the outputs of cfg, at the
+// levels of logLevel and logLevels, and logs its statements with logSQL. The
+// logs stay on stderr when neither logFile nor logSyslog is set.
+func configureLogging(cfg *config.Config) error {
+	sqllog.Configure(cfg)
+	level := logrus.InfoLevel
+	if cfg.LogLevel != "" {
+		level, _ = logrus.ParseLevel(cfg.LogLevel)
+	}
+	if cfg.LogFile == "" && cfg.LogSyslog == "" && len(cfg.LogLevels) == 0 {
+		logrus.SetLevel(level)
+		return nil
+	}
+	sinks := &logSinks{formatter: logrus.StandardLogger().Formatter, level: level, levels: make(map[string]logrus.Level)}
+	most := level
+	for component, l := range cfg.LogLevels {
+		sinks.levels[component], _ = logrus.ParseLevel(l)
+		if sinks.levels[component] > most {
+			most = sinks.levels[component]
+		}
+	}
+	if cfg.LogFile != "" {
+		interval, _ := time.ParseDuration(cfg.LogRotateInterval)
+		f, err := openRotatingFile(cfg.LogFile, int64(cfg.LogMaxSize)<<20, interval, cfg.LogMaxBackups)
+		if err != nil {
+			return fmt.Errorf("open logFile: %w", err)
+		}
+		sinks.writers = append(sinks.writers, f)
+	} else {
+		sinks.writers = append(sinks.writers, os.Stderr)
+	}
+	if cfg.LogSyslog != "" {
+		w, err := openSyslog(cfg.LogSyslog)
+		if err != nil {
+			return fmt.Errorf("connect to logSyslog %s: %w", cfg.LogSyslog, err)
+		}
+		sinks.syslog = w
+	}
+	// the sinks drop what is below the level of each component
+	logrus.SetLevel(most)
+	logrus.SetReportCaller(len(sinks.levels) > 0)
+	logrus.SetOutput(io.Discard)
+	logrus.AddHook(sinks)
+	// the stats printed with the log package go to the same outputs
+	log.SetFlags(0)
+	log.SetOutput(logrus.StandardLogger().Writer())
+	return nil
+}
+
+// logSinks writes the log entries to the outputs of the job, the entries of
+// a component of logLevels at its level, the others at logLevel.
+type logSinks struct {
+	formatter logrus.Formatter
+	level     logrus.Level
+	levels    map[string]logrus.Level
+
+	mu      sync.Mutex
+	writers []io.Writer
+	syslog  levelWriter
+}
+
+func (s *logSinks) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (s *logSinks) Fire(entry *logrus.Entry) error {
+	level := s.level
+	if l, ok := s.levels[logComponent(entry)]; ok {
+		level = l
+	}
+	if entry.Level > level {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the caller only tells the component apart
+	entry.Caller = nil
+	line, err := s.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	for _, w := range s.writers {
+		w.Write(line)
+	}
+	if s.syslog != nil {
+		s.syslog.WriteLevel(entry.Level, line)
+	}
+	return nil
+}
+
+// logComponent is the package of the code that logged entry, e.g. source
+// for github.com/databendcloud/bend-archiver/source.(*MysqlSource).QueryTableData.
+func logComponent(entry *logrus.Entry) string {
+	if entry.Caller == nil {
+		return ""
+	}
+	name := entry.Caller.Function
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// rotatingFile is a log file moved aside to path.<time> once it reaches
+// maxSize bytes or is older than interval, keeping the last backups of
+// them.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	interval time.Duration
+	backups  int
+	now      func() time.Time
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func openRotatingFile(path string, maxSize int64, interval time.Duration, backups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, interval: interval, backups: backups, now: time.Now}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.opened = f, fi.Size(), r.now()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	full := r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize
+	old := r.interval > 0 && r.now().Sub(r.opened) >= r.interval
+	if full || old {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file aside, removes the backups beyond the last ones, all
+// are kept when backups is 0, and opens a new file.
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	backup := fmt.Sprintf("%s.%s", r.path, r.now().UTC().Format("20060102T150405.000"))
+	if err := os.Rename(r.path, backup); err == nil && r.backups > 0 {
+		backups, _ := filepath.Glob(r.path + ".*")
+		sort.Strings(backups)
+		for len(backups) > r.backups {
+			os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+	return r.open()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/test-go/testify/assert"
+)
+
+func TestLogSinks(t *testing.T) {
+	out := &bytes.Buffer{}
+	sinks := &logSinks{
+		formatter: &logrus.TextFormatter{DisableTimestamp: true},
+		level:     logrus.DebugLevel,
+		levels:    map[string]logrus.Level{"cmd": logrus.WarnLevel},
+		writers:   []io.Writer{out},
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.SetLevel(logrus.DebugLevel)
+	logger.AddHook(sinks)
+
+	// the code of cmd logs at warn
+	logger.SetReportCaller(true)
+	logger.Info("dropped")
+	logger.Warn("kept")
+	// the others at the default level
+	logger.SetReportCaller(false)
+	logger.Debug("default")
+	assert.Equal(t, "level=warning msg=kept\nlevel=debug msg=default\n", out.String())
+}
+
+func TestLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.log")
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	f, err := openRotatingFile(path, 10, time.Hour, 2)
+	assert.NoError(t, err)
+	f.now = func() time.Time { return now }
+	f.opened = now
+
+	write := func(s string) {
+		_, err := f.Write([]byte(s))
+		assert.NoError(t, err)
+		now = now.Add(time.Second)
+	}
+	write("12345\n")
+	write("67890\n") // over 10 bytes, rotated
+	write("abc\n")
+	now = now.Add(time.Hour)
+	write("def\n") // older than an hour, rotated
+	write("ghi\n")
+	write("jklmnopq\n") // rotated, the oldest backup is removed
+
+	backups, _ := filepath.Glob(path + ".*")
+	assert.Equal(t, 2, len(backups))
+	data, _ := os.ReadFile(backups[0])
+	assert.Equal(t, "67890\nabc\n", string(data))
+	data, _ = os.ReadFile(backups[1])
+	assert.Equal(t, "def\nghi\n", string(data))
+	data, _ = os.ReadFile(path)
+	assert.Equal(t, "jklmnopq\n", string(data))
+}
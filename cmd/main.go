@@ -8,18 +8,65 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/errcode"
 	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/internal/hooks"
 	"github.com/databendcloud/bend-archiver/source"
 	"github.com/databendcloud/bend-archiver/worker"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		runBench(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		runRestore(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "retention" {
+		runRetention(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "tier" {
+		runTier(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		runInit(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		runDoctor(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		runValidate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		runHistory(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		runDiff(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "repair" {
+		runRepair(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "sample" {
+		runSample(os.Args[2:])
+		return
+	}
 	go func() {
 		http.ListenAndServe("localhost:6060", nil)
 	}()
@@ -35,6 +82,10 @@ func main() {
 	}()
 
 	configFile := flag.String("f", "", "Path to the configuration file")
+	sampleRows := flag.Int64("sample", 0, "Archive only the first N sampled rows of each table")
+	samplePercent := flag.Float64("sample-percent", 0, "Archive only a deterministic sample of this percentage of the rows")
+	sourceType := flag.String("source", "", "stdin to archive the rows piped to the process instead of the source of the config")
+	format := flag.String("format", "", "Format of the rows piped to stdin, csv or ndjson")
 	flag.Parse()
 
 	if *configFile == "" {
@@ -44,62 +95,238 @@ func main() {
 			os.Exit(1)
 		}
 	}
-	cfg := parseConfigWithFile(*configFile)
+	var cfg *config.Config
+	var err error
+	switch *sourceType {
+	case "":
+		cfg, err = config.ValidateConfigFile(*configFile)
+	case "stdin":
+		cfg, err = config.ValidateStdinConfigFile(*configFile, *format)
+	default:
+		err = fmt.Errorf("%w: unknown -source %q, only stdin is supported", errcode.ErrConfigInvalid, *sourceType)
+	}
+	if err != nil {
+		exitJob(nil, startTime, err)
+	}
+	logrus.AddHook(jobFields{jobID: cfg.JobID, runID: cfg.RunID, tenant: cfg.Tenant})
+	if err := configureLogging(cfg); err != nil {
+		exitJob(cfg, startTime, fmt.Errorf("%w: %w", errcode.ErrConfigInvalid, err))
+	}
+	currentJob.Store(cfg)
+	if *sampleRows > 0 {
+		cfg.SampleRows = *sampleRows
+	}
+	if *samplePercent > 0 {
+		cfg.SamplePercent = *samplePercent
+	}
+	if err := cfg.CheckPartialRun(); err != nil {
+		exitJob(cfg, startTime, fmt.Errorf("%w: %w", errcode.ErrConfigInvalid, err))
+	}
+	statsInterval, _ := time.ParseDuration(cfg.StatsLogInterval)
+	go logLiveStats(ctx, statsInterval)
+	go runHeartbeat(ctx, cfg, startTime)
+	var deadline time.Time
+	if cfg.MaxRuntime != "" {
+		maxRuntime, _ := time.ParseDuration(cfg.MaxRuntime)
+		deadline = startTime.Add(maxRuntime)
+	}
+	hooks.Fire(cfg, hooks.Event{Event: hooks.JobStart, Time: startTime})
+	err = budgetOutcome(cfg, runJob(ctx, cfg, deadline))
+	logStatsSummary()
+	logPhaseBreakdown()
+	logRunCost(cfg)
+	fmt.Println(fmt.Sprintf("end time: %s", time.Now().Format("2006-01-02 15:04:05")))
+	fmt.Println(fmt.Sprintf("total time: %s", time.Since(startTime)))
+	exitJob(cfg, startTime, err)
+}
+
+// runJob archives the tables of cfg, the error it returns carries the
+// errcode of the first failure. No table, range or file is started after
+// deadline when it is set.
+func runJob(ctx context.Context, cfg *config.Config, deadline time.Time) error {
+	tunnel, err := source.OpenSSHTunnel(cfg)
+	if err != nil {
+		return fmt.Errorf("%w: open ssh tunnel: %w", errcode.ErrSourceUnavailable, err)
+	}
+	if tunnel != nil {
+		defer tunnel.Close()
+	}
+	if err := ingester.ConfigureDatabendTransport(cfg); err != nil {
+		return fmt.Errorf("%w: %w", errcode.ErrTargetUnavailable, err)
+	}
+	if _, err := ingester.ConfigureFeatures(cfg); err != nil {
+		return fmt.Errorf("%w: %w", errcode.ErrTargetUnavailable, err)
+	}
+	ingester.ConfigureRetryBudget(cfg)
+	ingester.ConfigureStagePools(cfg)
+	ingester.ConfigureCost(cfg)
+	if cfg.TargetClusterBy != "" || len(cfg.TargetTableOptions) > 0 || len(cfg.TargetBloomIndexColumns) > 0 {
+		if err := ingester.ApplyTableOptions(cfg); err != nil {
+			return fmt.Errorf("%w: set the options of %s: %w", errcode.ErrTargetUnavailable, cfg.DatabendTable, err)
+		}
+	}
 	ig := ingester.NewDatabendIngester(cfg)
 	src, err := source.NewSource(cfg)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("%w: %w", errcode.ErrSourceUnavailable, err)
 	}
 
+	var archiveManifest *worker.ArchiveManifest
+	if cfg.ArchiveManifestFile != "" {
+		archiveManifest = worker.NewArchiveManifest()
+	}
+	quality := worker.NewQuality(cfg)
+	jobQuality.Store(quality)
+	readLatency := worker.NewReadLatency(cfg)
+
+	if cfg.IsFileSource() {
+		// file sources track what was already ingested in the manifest,
+		// so the target table does not need to be empty
+		if err := worker.CheckPurge(ctx, cfg, src); err != nil {
+			return fmt.Errorf("%w: %w", errcode.ErrPurgeRefused, err)
+		}
+		startLineage(ctx, cfg, nil)
+		w := worker.NewWorker(cfg, "dbarchiver", ig, src)
+		w.ArchiveManifest = archiveManifest
+		w.Quality = quality
+		w.ReadLatency = readLatency
+		w.Deadline = deadline
+		w.Run(ctx)
+		finishArchiveManifest(archiveManifest, cfg)
+		conflictsErr := checkKeyConflicts(cfg)
+		createInvertedIndexes(cfg)
+		if err := w.Err(); err != nil {
+			return err
+		}
+		if _, stopped := w.Stopped(); stopped {
+			return &partialRun{resumeFrom: map[string]string{cfg.SourcePath: ""}}
+		}
+		if conflictsErr != nil {
+			return conflictsErr
+		}
+		return verifyTarget(cfg, quality)
+	}
+
+	tableSrc, ok := src.(source.TableSourcer)
+	if !ok {
+		return fmt.Errorf("%w: %s sources have no tables", errcode.ErrConfigInvalid, cfg.DatabaseType)
+	}
 	dbTables := make(map[string][]string)
-	if len(cfg.SourceDbTables) != 0 {
-		dbTables, err = src.GetDbTablesAccordingToSourceDbTables()
+	if cfg.SourceSelect != "" {
+		// the result set of the query is the only table
+		dbTables[cfg.SourceDB] = []string{cfg.SourceTable}
+	} else if len(cfg.SourceDbTables) != 0 {
+		dbTables, err = tableSrc.GetDbTablesAccordingToSourceDbTables(ctx)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("%w: %w", errcode.ErrSourceUnavailable, err)
 		}
 	} else {
 		dbName := fmt.Sprintf("^%s$", cfg.SourceDB)
-		dbs, err := src.GetDatabasesAccordingToSourceDbRegex(dbName)
+		dbs, err := tableSrc.GetDatabasesAccordingToSourceDbRegex(ctx, dbName)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("%w: %w", errcode.ErrSourceUnavailable, err)
 		}
 		tableName := fmt.Sprintf("^%s$", cfg.SourceTable)
-		dbTables, err = src.GetTablesAccordingToSourceTableRegex(tableName, dbs)
+		dbTables, err = tableSrc.GetTablesAccordingToSourceTableRegex(ctx, tableName, dbs)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errcode.ErrSourceUnavailable, err)
+		}
+	}
+
+	startLineage(ctx, cfg, dbTables)
+
+	var checkpoint *worker.Checkpoint
+	if cfg.CheckpointFile != "" {
+		checkpoint, err = worker.LoadCheckpoint(cfg.CheckpointFile)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("%w: load checkpoint %s: %w", errcode.ErrConfigInvalid, cfg.CheckpointFile, err)
 		}
 	}
 
 	w := &worker.Worker{Cfg: cfg, Ig: ig, Src: src, Name: "dbarchiver"}
 	syncedCount, err := w.Ig.GetAllSyncedCount()
-	if err != nil || syncedCount != 0 {
-		if syncedCount != 0 {
-			logrus.Errorf("syncedCount is not 0, already ingested %d rows", syncedCount)
-			return
-		}
-		logrus.Errorf("pre-check failed: %v", err)
-		return
+	if err != nil {
+		return fmt.Errorf("%w: pre-check failed: %w", errcode.ErrTargetUnavailable, err)
 	}
+	resumed := cfg.StartFromKey != "" || (checkpoint != nil && !checkpoint.Empty())
+	if syncedCount != 0 && !cfg.DiffSync && cfg.SoftDeleteColumn == "" && !resumed {
+		// diff sync and incremental runs update the rows already in the
+		// target, resumed runs add to them
+		return fmt.Errorf("syncedCount is not 0, already ingested %d rows", syncedCount)
+	}
+	children, err := newChildTables(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	var workers []*worker.Worker
 	for db, tables := range dbTables {
 		for _, table := range tables {
-			logrus.Infof("Start worker %s.%s", db, table)
 			db := db
 			table := table
 			cfgCopy := *cfg
 			cfgCopy.SourceDB = db
 			cfgCopy.SourceTable = table
 			ig := ingester.NewDatabendIngester(&cfgCopy)
-			src, err := source.NewSource(&cfgCopy)
+			src, err := source.NewTableSource(&cfgCopy)
 			if err != nil {
-				panic(err)
+				return fmt.Errorf("%w: %w", errcode.ErrSourceUnavailable, err)
+			}
+			if err := worker.CheckPurge(ctx, &cfgCopy, src); err != nil {
+				return fmt.Errorf("%w: %w", errcode.ErrPurgeRefused, err)
 			}
 			// adjust batch size according to source db table
-			cfgCopy.BatchSize = int64(src.AdjustBatchSizeAccordingToSourceDbTable())
+			cfgCopy.BatchSize = int64(src.AdjustBatchSizeAccordingToSourceDbTable(ctx))
 			w := worker.NewWorker(&cfgCopy, fmt.Sprintf("%s.%s", db, table), ig, src)
+			w.ArchiveManifest = archiveManifest
+			w.Quality = quality
+			w.ReadLatency = readLatency
+			w.Deadline = deadline
+			w.Checkpoint = checkpoint
+			workers = append(workers, w)
+		}
+	}
+	if cfg.JobMaxThread > 0 {
+		worker.RunSharingThreads(ctx, cfg.JobMaxThread, workers)
+	} else {
+		for _, w := range workers {
+			logrus.Infof("Start worker %s", w.Name)
 			w.Run(ctx)
 		}
 	}
-	targetCount, sourceCount, workerCorrect := w.IsWorkerCorrect()
+	recordBookmarks(workers)
+	finishArchiveManifest(archiveManifest, cfg)
+	var workerErr error
+	resumeFrom := make(map[string]string)
+	for _, w := range workers {
+		if err := w.Err(); err != nil && workerErr == nil {
+			workerErr = fmt.Errorf("worker %s: %w", w.Name, err)
+		}
+		if key, stopped := w.Stopped(); stopped {
+			resumeFrom[w.Name] = key
+		}
+	}
+	conflictsErr := checkKeyConflicts(cfg)
+	createInvertedIndexes(cfg)
+	if len(resumeFrom) > 0 {
+		// the tables left are archived by the next runs, counts can't match
+		if workerErr != nil {
+			return workerErr
+		}
+		return &partialRun{resumeFrom: resumeFrom}
+	}
+	if cfg.IsPartialRun() || cfg.SoftDeleteColumn != "" {
+		// the target only holds part of the source, or also earlier runs,
+		// counts can't match
+		logrus.Infof("Part of %s archived, skip the count check", w.Name)
+		if workerErr != nil {
+			return workerErr
+		}
+		if conflictsErr != nil {
+			return conflictsErr
+		}
+		return verifyTarget(cfg, quality)
+	}
+	targetCount, sourceCount, workerCorrect := w.IsWorkerCorrect(ctx)
 
 	if workerCorrect {
 		logrus.Infof("Worker %s finished and data correct, source data count is %d,"+
@@ -108,16 +335,52 @@ func main() {
 		logrus.Errorf("Worker %s finished and data incorrect, source data count is %d,"+
 			" but databend data count is %d", w.Name, sourceCount, targetCount)
 	}
+	switch {
+	case workerErr != nil:
+		return workerErr
+	case !workerCorrect:
+		return fmt.Errorf("%w: source data count is %d, databend data count is %d", errcode.ErrCountMismatch, sourceCount, targetCount)
+	case conflictsErr != nil:
+		return conflictsErr
+	}
+	if err := verifyTarget(cfg, quality); err != nil {
+		return err
+	}
+	if err := archiveChildTables(ctx, children); err != nil {
+		return err
+	}
 
-	if w.Cfg.DeleteAfterSync && workerCorrect {
-		err := w.Src.DeleteAfterSync()
-		if err != nil {
-			logrus.Errorf("DeleteAfterSync failed: %v, please do it mannually", err)
+	if w.Cfg.DeleteAfterSync {
+		if err := purgeChildTables(ctx, children); err != nil {
+			return fmt.Errorf("%w: %w, please do it mannually", errcode.ErrPurgeFailed, err)
+		}
+		if err := tableSrc.DeleteAfterSync(ctx); err != nil {
+			return fmt.Errorf("%w: %w, please do it mannually", errcode.ErrPurgeFailed, err)
+		}
+		maintainSource(ctx, w.Src, w.Cfg.PurgeMaintenance)
+	}
+	if len(cfg.RetentionPolicies) > 0 {
+		if err := worker.EnforceRetention(cfg, time.Now()); err != nil {
+			logrus.Errorf("enforce retention failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// maintainSource runs the purgeMaintenance operations on the purged source
+// tables. The rows are archived and purged by then, so a failure is logged
+// and the job still succeeds.
+func maintainSource(ctx context.Context, src source.Sourcer, operations []string) {
+	m, ok := src.(source.Maintainer)
+	if !ok {
+		return
+	}
+	for _, operation := range operations {
+		if err := m.Maintain(ctx, operation); err != nil {
+			logrus.Errorf("purge maintenance %s failed: %v", operation, err)
+			return
 		}
 	}
-	endTime := fmt.Sprintf("end time: %s", time.Now().Format("2006-01-02 15:04:05"))
-	fmt.Println(endTime)
-	fmt.Println(fmt.Sprintf("total time: %s", time.Since(startTime)))
 }
 
 func parseConfigWithFile(configFile string) *config.Config {
@@ -127,3 +390,81 @@ func parseConfigWithFile(configFile string) *config.Config {
 	}
 	return cfg
 }
+
+func finishArchiveManifest(m *worker.ArchiveManifest, cfg *config.Config) {
+	if m == nil {
+		return
+	}
+	if err := m.Finish(cfg); err != nil {
+		logrus.Errorf("write archive manifest failed: %v", err)
+		return
+	}
+	logrus.Infof("archive manifest written to %s", cfg.ArchiveManifestFile)
+}
+
+// verifyTarget logs the outcome of the quality rules, refreshes the
+// statistics of the target table with analyzeTarget and runs the
+// verification queries, an errcode.ErrVerification error reports the first
+// rule or query that failed.
+func verifyTarget(cfg *config.Config, quality *worker.Quality) error {
+	var failedRules []string
+	for _, result := range quality.Results() {
+		switch result.Outcome {
+		case "failed":
+			failedRules = append(failedRules, result.Rule)
+			logrus.Errorf("quality rule %s failed: %d of %d rows checked (%.2f%%), e.g. %s", result.Rule, result.Violations, result.Checked, result.Percent, result.Example)
+		case "warned":
+			logrus.Warnf("quality rule %s: %d of %d rows checked (%.2f%%), e.g. %s", result.Rule, result.Violations, result.Checked, result.Percent, result.Example)
+		default:
+			logrus.Infof("quality rule %s passed on %d rows", result.Rule, result.Checked)
+		}
+	}
+	if len(failedRules) > 0 {
+		return fmt.Errorf("%w: quality rules %s failed", errcode.ErrVerification, strings.Join(failedRules, ", "))
+	}
+	if cfg.AnalyzeTarget {
+		if err := ingester.Exec(cfg, "ANALYZE TABLE "+cfg.DatabendTable); err != nil {
+			logrus.Errorf("analyze %s failed: %v", cfg.DatabendTable, err)
+		}
+	}
+	if len(cfg.VerificationQueries) == 0 {
+		return nil
+	}
+	failed, err := worker.Verify(cfg)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errcode.ErrTargetUnavailable, err)
+	}
+	if failed != "" {
+		return fmt.Errorf("%w: %s", errcode.ErrVerification, failed)
+	}
+	logrus.Infof("%d verification queries of %s passed", len(cfg.VerificationQueries), cfg.DatabendTable)
+	return nil
+}
+
+// createInvertedIndexes creates and refreshes the inverted indexes of the
+// target after a load. The rows are loaded by then, so a failure is logged
+// and the job still succeeds.
+func createInvertedIndexes(cfg *config.Config) {
+	if len(cfg.TargetInvertedIndexes) == 0 {
+		return
+	}
+	if err := ingester.CreateInvertedIndexes(cfg); err != nil {
+		logrus.Errorf("inverted indexes of %s: %v", cfg.DatabendTable, err)
+	}
+}
+
+// checkKeyConflicts checks the target table for duplicate
+// conflictCheckKeys, an errcode.ErrKeyConflict error reports them.
+func checkKeyConflicts(cfg *config.Config) error {
+	if len(cfg.ConflictCheckKeys) == 0 {
+		return nil
+	}
+	conflicts, err := worker.CheckKeyConflicts(cfg, cfg.DatabendTable)
+	if err != nil {
+		return fmt.Errorf("%w: check key conflicts of %s: %w", errcode.ErrTargetUnavailable, cfg.DatabendTable, err)
+	}
+	if conflicts != nil {
+		return fmt.Errorf("%w: %s has duplicate %s after the load, %s", errcode.ErrKeyConflict, cfg.DatabendTable, strings.Join(cfg.ConflictCheckKeys, ", "), conflicts)
+	}
+	return nil
+}
@@ -31,9 +31,9 @@ func TestMultipleDbTablesWorkflow(t *testing.T) {
 		testConfig := prepareMySQLMultipleConfig()
 		startTime := time.Now()
 
-		src, err := source.NewSource(testConfig)
+		src, err := source.NewTableSource(testConfig)
 		assert.NoError(t, err)
-		dbTables, err := src.GetDbTablesAccordingToSourceDbTables()
+		dbTables, err := src.GetDbTablesAccordingToSourceDbTables(context.Background())
 		assert.NoError(t, err)
 		for db, tables := range dbTables {
 			for _, table := range tables {
@@ -43,7 +43,7 @@ func TestMultipleDbTablesWorkflow(t *testing.T) {
 				cfgCopy.SourceDB = db
 				cfgCopy.SourceTable = table
 				ig := ingester.NewDatabendIngester(&cfgCopy)
-				src, err := source.NewSource(&cfgCopy)
+				src, err := source.NewTableSource(&cfgCopy)
 				assert.NoError(t, err)
 				w := worker.NewWorker(&cfgCopy, fmt.Sprintf("%s.%s", db, table), ig, src)
 				w.Run(context.Background())
@@ -69,9 +69,9 @@ func TestOracleMultiTableWorkflow(t *testing.T) {
 	testConfig := prepareOracleMultipleConfig()
 	startTime := time.Now()
 
-	src, err := source.NewSource(testConfig)
+	src, err := source.NewTableSource(testConfig)
 	assert.NoError(t, err)
-	dbTables, err := src.GetDbTablesAccordingToSourceDbTables()
+	dbTables, err := src.GetDbTablesAccordingToSourceDbTables(context.Background())
 	assert.NoError(t, err)
 	for db, tables := range dbTables {
 		for _, table := range tables {
@@ -81,7 +81,7 @@ func TestOracleMultiTableWorkflow(t *testing.T) {
 			cfgCopy.SourceDB = db
 			cfgCopy.SourceTable = table
 			ig := ingester.NewDatabendIngester(&cfgCopy)
-			src, err := source.NewSource(&cfgCopy)
+			src, err := source.NewTableSource(&cfgCopy)
 			assert.NoError(t, err)
 			w := worker.NewWorker(&cfgCopy, fmt.Sprintf("%s.%s", db, table), ig, src)
 			w.Run(context.Background())
@@ -102,16 +102,16 @@ func TestMySQLWorkFlow(t *testing.T) {
 		testConfig := prepareTestConfig()
 		startTime := time.Now()
 
-		src, err := source.NewSource(testConfig)
+		src, err := source.NewTableSource(testConfig)
 		if err != nil {
 			panic(err)
 		}
 		wg := sync.WaitGroup{}
-		dbs, err := src.GetDatabasesAccordingToSourceDbRegex(testConfig.SourceDB)
+		dbs, err := src.GetDatabasesAccordingToSourceDbRegex(context.Background(), testConfig.SourceDB)
 		if err != nil {
 			panic(err)
 		}
-		dbTables, err := src.GetTablesAccordingToSourceTableRegex(testConfig.SourceTable, dbs)
+		dbTables, err := src.GetTablesAccordingToSourceTableRegex(context.Background(), testConfig.SourceTable, dbs)
 		if err != nil {
 			panic(err)
 		}
@@ -125,7 +125,7 @@ func TestMySQLWorkFlow(t *testing.T) {
 					cfgCopy.SourceTable = table
 					cfgCopy.SourceDB = db
 					ig := ingester.NewDatabendIngester(&cfgCopy)
-					src, err := source.NewSource(&cfgCopy)
+					src, err := source.NewTableSource(&cfgCopy)
 					assert.NoError(t, err)
 					w := worker.NewWorker(&cfgCopy, fmt.Sprintf("%s.%s", db, table), ig, src)
 					w.Run(context.Background())
@@ -151,17 +151,17 @@ func TestMssqlWorkflow(t *testing.T) {
 	testConfig := prepareSqlServerTestConfig()
 	startTime := time.Now()
 
-	src, err := source.NewSource(testConfig)
+	src, err := source.NewTableSource(testConfig)
 	if err != nil {
 		panic(err)
 	}
 	wg := sync.WaitGroup{}
-	dbs, err := src.GetDatabasesAccordingToSourceDbRegex(testConfig.SourceDB)
+	dbs, err := src.GetDatabasesAccordingToSourceDbRegex(context.Background(), testConfig.SourceDB)
 	if err != nil {
 		panic(err)
 	}
 	log.Printf("dbs: %v", dbs)
-	dbTables, err := src.GetTablesAccordingToSourceTableRegex(testConfig.SourceTable, dbs)
+	dbTables, err := src.GetTablesAccordingToSourceTableRegex(context.Background(), testConfig.SourceTable, dbs)
 	if err != nil {
 		panic(err)
 	}
@@ -176,7 +176,7 @@ func TestMssqlWorkflow(t *testing.T) {
 				cfgCopy.SourceTable = table
 				cfgCopy.SourceDB = db
 				ig := ingester.NewDatabendIngester(&cfgCopy)
-				src, err := source.NewSource(&cfgCopy)
+				src, err := source.NewTableSource(&cfgCopy)
 				assert.NoError(t, err)
 				w := worker.NewWorker(&cfgCopy, fmt.Sprintf("%s.%s", db, table), ig, src)
 				w.Run(context.Background())
@@ -201,17 +201,17 @@ func TestMssqlTimeKeyWorkflow(t *testing.T) {
 	testConfig := prepareSqlServerTimeKeyTestConfig()
 	startTime := time.Now()
 
-	src, err := source.NewSource(testConfig)
+	src, err := source.NewTableSource(testConfig)
 	if err != nil {
 		panic(err)
 	}
 	wg := sync.WaitGroup{}
-	dbs, err := src.GetDatabasesAccordingToSourceDbRegex(testConfig.SourceDB)
+	dbs, err := src.GetDatabasesAccordingToSourceDbRegex(context.Background(), testConfig.SourceDB)
 	if err != nil {
 		panic(err)
 	}
 	log.Printf("dbs: %v", dbs)
-	dbTables, err := src.GetTablesAccordingToSourceTableRegex(testConfig.SourceTable, dbs)
+	dbTables, err := src.GetTablesAccordingToSourceTableRegex(context.Background(), testConfig.SourceTable, dbs)
 	if err != nil {
 		panic(err)
 	}
@@ -226,7 +226,7 @@ func TestMssqlTimeKeyWorkflow(t *testing.T) {
 				cfgCopy.SourceTable = table
 				cfgCopy.SourceDB = db
 				ig := ingester.NewDatabendIngester(&cfgCopy)
-				src, err := source.NewSource(&cfgCopy)
+				src, err := source.NewTableSource(&cfgCopy)
 				assert.NoError(t, err)
 				w := worker.NewWorker(&cfgCopy, fmt.Sprintf("%s.%s", db, table), ig, src)
 				w.Run(context.Background())
@@ -253,16 +253,16 @@ func TestSimpleOracleWorkflow(t *testing.T) {
 	testConfig := prepareOracleTestConfig()
 	startTime := time.Now()
 
-	src, err := source.NewSource(testConfig)
+	src, err := source.NewTableSource(testConfig)
 	if err != nil {
 		panic(err)
 	}
 	wg := sync.WaitGroup{}
-	dbs, err := src.GetDatabasesAccordingToSourceDbRegex(testConfig.SourceDB)
+	dbs, err := src.GetDatabasesAccordingToSourceDbRegex(context.Background(), testConfig.SourceDB)
 	if err != nil {
 		panic(err)
 	}
-	dbTables, err := src.GetTablesAccordingToSourceTableRegex(testConfig.SourceTable, dbs)
+	dbTables, err := src.GetTablesAccordingToSourceTableRegex(context.Background(), testConfig.SourceTable, dbs)
 	if err != nil {
 		panic(err)
 	}
@@ -276,7 +276,7 @@ func TestSimpleOracleWorkflow(t *testing.T) {
 				cfgCopy.SourceTable = table
 				cfgCopy.SourceDB = db
 				ig := ingester.NewDatabendIngester(&cfgCopy)
-				src, err := source.NewSource(&cfgCopy)
+				src, err := source.NewTableSource(&cfgCopy)
 				assert.NoError(t, err)
 				w := worker.NewWorker(&cfgCopy, fmt.Sprintf("%s.%s", db, table), ig, src)
 				w.Run(context.Background())
@@ -28,10 +28,10 @@ func TestMultiplePgTable(t *testing.T) {
 	testConfig := preparePGMultipleConfig()
 	startTime := time.Now()
 
-	src, err := source.NewSource(testConfig)
+	src, err := source.NewTableSource(testConfig)
 	assert.NoError(t, err)
 
-	dbTables, err := src.GetDbTablesAccordingToSourceDbTables()
+	dbTables, err := src.GetDbTablesAccordingToSourceDbTables(context.Background())
 	assert.NoError(t, err)
 	for db, tables := range dbTables {
 		for _, table := range tables {
@@ -41,7 +41,7 @@ func TestMultiplePgTable(t *testing.T) {
 			cfgCopy.SourceDB = db
 			cfgCopy.SourceTable = table
 			ig := ingester.NewDatabendIngester(&cfgCopy)
-			src, err := source.NewSource(&cfgCopy)
+			src, err := source.NewTableSource(&cfgCopy)
 			assert.NoError(t, err)
 			w := worker.NewWorker(&cfgCopy, fmt.Sprintf("%s.%s", db, table), ig, src)
 			w.Run(context.Background())
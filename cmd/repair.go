@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/errcode"
+	"github.com/databendcloud/bend-archiver/worker"
+)
+
+// runRepair compares the ranges of a source table with its archive like
+// diff, then reloads the ranges that disagree.
+func runRepair(args []string) {
+	flags := flag.NewFlagSet("repair", flag.ExitOnError)
+	o := addDiffFlags(flags)
+	dryRun := flags.Bool("dry-run", false, "Only print the ranges that would be repaired")
+	flags.Parse(args)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGQUIT, syscall.SIGTERM, os.Interrupt)
+	defer cancel()
+	code, err := repair(ctx, o.config(), o, *dryRun, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(errcode.CodeOf(err).ExitCode())
+	}
+	os.Exit(code)
+}
+
+// repair reloads the ranges of the source of cfg picked by o that disagree
+// with the target, then compares them again. It returns the exit code of
+// COUNT_MISMATCH when a repaired range still disagrees, e.g. because the
+// source changed meanwhile.
+func repair(ctx context.Context, cfg *config.Config, o *diffOptions, dryRun bool, out io.Writer) (int, error) {
+	c, err := newComparison(ctx, cfg, o)
+	if err != nil {
+		return 0, err
+	}
+	defer c.close()
+	diffs, err := worker.CompareRanges(ctx, cfg, c.src, c.conditions, c.columns)
+	if err != nil {
+		return 0, err
+	}
+	if writeDiffs(out, diffs, false) == 0 || dryRun {
+		return 0, nil
+	}
+	var divergent []string
+	for _, d := range diffs {
+		if d.Differs() {
+			divergent = append(divergent, d.Condition)
+		}
+	}
+	repairs, err := worker.RepairRanges(ctx, cfg, c.src, divergent)
+	for _, r := range repairs {
+		fmt.Fprintf(out, "repaired %s: deleted %d, inserted %d rows\n", r.Condition, r.Deleted, r.Inserted)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	fmt.Fprintln(out, "after the repair:")
+	diffs, err = worker.CompareRanges(ctx, cfg, c.src, divergent, c.columns)
+	if err != nil {
+		return 0, err
+	}
+	if writeDiffs(out, diffs, false) > 0 {
+		return errcode.CountMismatch.ExitCode(), nil
+	}
+	return 0, nil
+}
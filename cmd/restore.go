@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/worker"
+)
+
+// runRestore reloads a previously archived table into a new Databend table
+// from the staged files recorded in the archive manifest of the job, or from
+// Parquet files it was exported to.
+func runRestore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	configFile := flags.String("f", "config/conf.json", "Path to the configuration file of the archive job")
+	manifestFile := flags.String("manifest", "", "Archive manifest of the job, defaults to archiveManifestFile")
+	publicKeyFile := flags.String("public-key", "", "Ed25519 PKIX PEM public key the manifest must be signed with")
+	table := flags.String("table", "", "Archived table to restore, needed when the manifest has several")
+	into := flags.String("into", "", "New table to restore into")
+	parquet := flags.String("parquet", "", "Restore from the Parquet files under this location, e.g. @stage/orders/, instead of the staged files")
+	createTable := flags.Bool("create", true, "Create the new table like the archived one if it does not exist")
+	flags.Parse(args)
+
+	if *into == "" {
+		fmt.Println("restore needs the new table to restore into, set it with -into")
+		os.Exit(1)
+	}
+	cfg := parseConfigWithFile(*configFile)
+	if *manifestFile == "" {
+		*manifestFile = cfg.ArchiveManifestFile
+	}
+	if err := ingester.ConfigureDatabendTransport(cfg); err != nil {
+		panic(err)
+	}
+
+	var publicKey ed25519.PublicKey
+	if *publicKeyFile != "" {
+		var err error
+		publicKey, err = worker.LoadEd25519PublicKey(*publicKeyFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+	var manifest *worker.ArchiveManifest
+	if *manifestFile != "" {
+		var err error
+		manifest, err = worker.ReadArchiveManifest(*manifestFile, publicKey)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var err error
+	switch {
+	case *parquet != "":
+		err = worker.RestoreParquet(cfg, manifest, *parquet, *table, *into, *createTable)
+	case manifest == nil:
+		fmt.Println("restore needs the archive manifest of the job, set it with -manifest or archiveManifestFile")
+		os.Exit(1)
+	default:
+		err = manifest.Restore(cfg, *table, *into, *createTable)
+	}
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("restore: %s restored\n", *into)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os/signal"
+	"syscall"
+
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/worker"
+)
+
+// runRetention enforces the retention policies of a job config on the target,
+// once or every retentionInterval, reloading the schedule and the policies
+// when the config file changes.
+func runRetention(args []string) {
+	flags := flag.NewFlagSet("retention", flag.ExitOnError)
+	configFile := flags.String("f", "config/conf.json", "Path to the configuration file")
+	interval := flags.String("interval", "", "Enforce the policies every interval, overrides retentionInterval")
+	flags.Parse(args)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGQUIT, syscall.SIGTERM)
+	defer cancel()
+
+	cfg := parseConfigWithFile(*configFile)
+	if len(cfg.RetentionPolicies) == 0 {
+		panic("no retentionPolicies in " + *configFile)
+	}
+	if err := configureLogging(cfg); err != nil {
+		panic(err)
+	}
+	if err := ingester.ConfigureDatabendTransport(cfg); err != nil {
+		panic(err)
+	}
+	if err := worker.RunRetention(ctx, cfg, *configFile, *interval); err != nil {
+		panic(err)
+	}
+}
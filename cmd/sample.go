@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/errcode"
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/source"
+	"github.com/databendcloud/bend-archiver/worker"
+)
+
+// runSample prints masked rows of the source as the job converts them, with
+// the form they are staged in, to debug their types and formats before the
+// job runs.
+func runSample(args []string) {
+	flags := flag.NewFlagSet("sample", flag.ExitOnError)
+	configFile := flags.String("f", "config/conf.json", "Path to the configuration file")
+	table := flags.String("table", "", "Source db.table to sample, defaults to sourceDB.sourceTable")
+	n := flags.Int("n", 5, "Number of rows")
+	mask := flags.String("mask", "", "Comma separated columns masked, defaults to logSQLMaskColumns")
+	maskAll := flags.Bool("mask-all", false, "Mask every column but the split keys")
+	flags.Parse(args)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGQUIT, syscall.SIGTERM, os.Interrupt)
+	defer cancel()
+	o := &diffOptions{configFile: *configFile, table: *table}
+	cfg := o.config()
+	masked := cfg.LogSQLMaskColumns
+	if *mask != "" {
+		masked = strings.Split(*mask, ",")
+		for i := range masked {
+			masked[i] = strings.TrimSpace(masked[i])
+		}
+	}
+	if err := sample(ctx, cfg, *n, masked, *maskAll, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(errcode.CodeOf(err).ExitCode())
+	}
+}
+
+func sample(ctx context.Context, cfg *config.Config, n int, masked []string, maskAll bool, out io.Writer) error {
+	tunnel, err := source.OpenSSHTunnel(cfg)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errcode.ErrSourceUnavailable, err)
+	}
+	if tunnel != nil {
+		defer tunnel.Close()
+	}
+	src, err := source.NewTableSource(cfg)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errcode.ErrSourceUnavailable, err)
+	}
+	// the boolean columns of the target are read when it can be reached
+	if err := ingester.ConfigureDatabendTransport(cfg); err != nil {
+		return fmt.Errorf("%w: %w", errcode.ErrTargetUnavailable, err)
+	}
+	columns, rows, err := worker.Sample(ctx, cfg, src, n)
+	if err != nil {
+		return err
+	}
+	writeSample(cfg, out, columns, rows, masked, maskAll)
+	return nil
+}
+
+// writeSample prints each row of rows, column by column with the Go type
+// the source read it as, then its staged form, with the masked columns
+// masked.
+func writeSample(cfg *config.Config, out io.Writer, columns []string, rows [][]interface{}, masked []string, maskAll bool) {
+	types := make([][]string, len(rows))
+	for i, row := range rows {
+		types[i] = make([]string, len(row))
+		for j, v := range row {
+			types[i][j] = fmt.Sprintf("%T", v)
+		}
+	}
+	worker.MaskRows(cfg, columns, rows, masked, maskAll)
+	staged, err := ingester.StagedForm(cfg, columns, rows)
+	for i, row := range rows {
+		fmt.Fprintf(out, "row %d\n", i+1)
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		for j, v := range row {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%v\n", columns[j], types[i][j], v)
+		}
+		w.Flush()
+		if err == nil {
+			fmt.Fprintf(out, "  staged: %s\n", staged[i])
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(out, "serialize the rows failed: %v\n", err)
+	}
+	fmt.Fprintf(out, "%d rows of %s.%s\n", len(rows), cfg.SourceDB, cfg.SourceTable)
+}
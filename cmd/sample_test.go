@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestWriteSample(t *testing.T) {
+	cfg := &config.Config{SourceDB: "shop", SourceTable: "customers", SourceSplitKey: "id"}
+	rows := [][]interface{}{{int64(7), "ada@example.com", 12.5}}
+	var out bytes.Buffer
+	writeSample(cfg, &out, []string{"id", "email", "balance"}, rows, []string{"email"}, false)
+	assert.Equal(t, `row 1
+  id       int64    7
+  email    string   ***
+  balance  float64  12.5
+  staged: {"balance":12.5,"email":"***","id":7}
+1 rows of shop.customers
+`, out.String())
+}
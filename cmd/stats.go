@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// currentJob is the config of the job running, for the job stats.
+var currentJob atomic.Pointer[config.Config]
+
+func init() {
+	// served at /debug/vars of the pprof listener
+	expvar.Publish("job", expvar.Func(func() interface{} {
+		cfg := currentJob.Load()
+		if cfg == nil {
+			return nil
+		}
+		return map[string]string{"jobId": cfg.JobID, "runId": cfg.RunID}
+	}))
+	expvar.Publish("tables", expvar.Func(func() interface{} {
+		return source.LiveStats()
+	}))
+	expvar.Publish("phases", expvar.Func(func() interface{} {
+		return ingester.PhaseBreakdown()
+	}))
+}
+
+// logLiveStats logs the live stats of every table and thread every interval
+// until ctx is done.
+func logLiveStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			logStatsSummary()
+		}
+	}
+}
+
+func logStatsSummary() {
+	stats := source.LiveStats()
+	tables := make([]string, 0, len(stats))
+	for table := range stats {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		s := stats[table]
+		logrus.Infof("stats %s: %d rows, %d bytes, %.0f rows/s, %.0f bytes/s", table, s.Rows, s.Bytes, s.RowsPerSecond, s.BytesPerSecond)
+		threads := make([]int, 0, len(s.Threads))
+		for thread := range s.Threads {
+			threads = append(threads, thread)
+		}
+		sort.Ints(threads)
+		for _, thread := range threads {
+			t := s.Threads[thread]
+			logrus.Infof("stats %s thread-%d: %d rows, %d bytes, %.0f rows/s", table, thread, t.Rows, t.Bytes, t.RowsPerSecond)
+		}
+	}
+}
+
+// logPhaseBreakdown logs the time the batches of the job spent reading,
+// serializing, uploading and copying, and which of them took the longest.
+func logPhaseBreakdown() {
+	breakdown := ingester.PhaseBreakdown()
+	var total time.Duration
+	for _, s := range breakdown {
+		total += s.Total
+	}
+	if total == 0 {
+		return
+	}
+	for _, s := range breakdown {
+		logrus.Infof("phase %-9s %5.1f%%: %d batches, total %s, mean %s, max %s", s.Phase,
+			float64(s.Total)/float64(total)*100, s.Batches, s.Total.Round(time.Millisecond),
+			s.Mean().Round(time.Millisecond), s.Max.Round(time.Millisecond))
+	}
+	logrus.Infof("bottleneck: %s", ingester.Bottleneck(breakdown))
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/errcode"
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/internal/hooks"
+	"github.com/databendcloud/bend-archiver/source"
+	"github.com/databendcloud/bend-archiver/worker"
+)
+
+// jobStatus is the outcome of a job written to statusFile.
+type jobStatus struct {
+	Status     string       `json:"status"` // succeeded, partial or failed
+	JobID      string       `json:"jobId,omitempty"`
+	Tenant     string       `json:"tenant,omitempty"`
+	RunID      string       `json:"runId,omitempty"`
+	Code       errcode.Code `json:"code"`
+	Error      string       `json:"error,omitempty"`
+	StartedAt  time.Time    `json:"startedAt"`
+	FinishedAt time.Time    `json:"finishedAt"`
+	// ResumeFrom is the startFromKey each table left by a partial run
+	// resumes from, empty when it was not started
+	ResumeFrom map[string]string `json:"resumeFrom,omitempty"`
+	// Quality is the outcome of the quality rules on the rows checked
+	Quality []worker.QualityResult `json:"quality,omitempty"`
+	// Cost is the approximate spend of the run on Databend
+	Cost *ingester.Cost `json:"cost,omitempty"`
+	// Bookmarks are where the snapshots of the tables were read on the
+	// source, with sourceBookmark
+	Bookmarks map[string]source.Bookmark `json:"bookmarks,omitempty"`
+}
+
+// jobQuality checks the quality rules of the job running, for its status.
+var jobQuality atomic.Pointer[worker.Quality]
+
+// jobBookmarks are where the job running read its snapshots, for its status.
+var jobBookmarks atomic.Pointer[map[string]source.Bookmark]
+
+// recordBookmarks keeps the bookmarks of the snapshots the workers read.
+func recordBookmarks(workers []*worker.Worker) {
+	bookmarks := make(map[string]source.Bookmark)
+	for _, w := range workers {
+		if b, ok := w.Src.(source.Bookmarker); ok && b.Bookmark() != nil {
+			bookmarks[w.Name] = *b.Bookmark()
+		}
+	}
+	if len(bookmarks) > 0 {
+		jobBookmarks.Store(&bookmarks)
+	}
+}
+
+// partialRun is the outcome of a job stopped by maxRuntime or SIGTERM.
+type partialRun struct {
+	resumeFrom map[string]string
+}
+
+func (p *partialRun) Error() string {
+	return fmt.Sprintf("%v, %d tables left", errcode.ErrPartial, len(p.resumeFrom))
+}
+
+func (p *partialRun) Unwrap() error {
+	return errcode.ErrPartial
+}
+
+func newJobStatus(startedAt time.Time, err error) jobStatus {
+	status := jobStatus{
+		Status:     "succeeded",
+		Code:       errcode.CodeOf(err),
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+	}
+	var partial *partialRun
+	switch {
+	case errors.As(err, &partial):
+		status.Status = "partial"
+		status.ResumeFrom = partial.resumeFrom
+	case err != nil:
+		status.Status = "failed"
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// writeStatusFile writes status to path through a temporary file, so that a
+// reader never sees half of it.
+func writeStatusFile(path string, status jobStatus) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile := path + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, path)
+}
+
+// exitJob logs the outcome of the job with its code, emits its lineage,
+// records it in the dbt sources, fires the job_end hooks, appends it to the
+// history and writes it to the statusFile of cfg (nil when the config could
+// not be loaded), then exits with the exit code of the code.
+func exitJob(cfg *config.Config, startedAt time.Time, err error) {
+	status := newJobStatus(startedAt, err)
+	if cfg != nil {
+		status.JobID, status.RunID, status.Tenant = cfg.JobID, cfg.RunID, cfg.Tenant
+		cost := ingester.RunCost()
+		status.Cost = &cost
+	}
+	status.Quality = jobQuality.Load().Results()
+	if bookmarks := jobBookmarks.Load(); bookmarks != nil {
+		status.Bookmarks = *bookmarks
+	}
+	switch status.Status {
+	case "partial":
+		for table, key := range status.ResumeFrom {
+			switch {
+			case cfg.IsFileSource():
+				logrus.WithField("code", status.Code).Warnf("%s stopped at maxRuntime, the files left are archived by the next run", table)
+				continue
+			case key == "":
+				logrus.WithField("code", status.Code).Warnf("%s stopped at maxRuntime before it started", table)
+				continue
+			}
+			logrus.WithField("code", status.Code).Warnf("%s stopped at maxRuntime, resume with startFromKey %q", table, key)
+		}
+	case "failed":
+		logrus.WithField("code", status.Code).Errorf("job failed: %v", err)
+	default:
+		logrus.WithField("code", status.Code).Info("job succeeded")
+	}
+	if cfg != nil {
+		finishLineage(cfg, status)
+		writeDbtSources(cfg, status)
+		hooks.Fire(cfg, hooks.Event{
+			Event:    hooks.JobEnd,
+			Status:   status.Status,
+			Code:     status.Code,
+			Error:    status.Error,
+			Duration: status.FinishedAt.Sub(startedAt).String(),
+		})
+		recordHistory(cfg, status)
+	}
+	if cfg != nil && cfg.StatusFile != "" {
+		if err := writeStatusFile(cfg.StatusFile, status); err != nil {
+			logrus.Errorf("write status file %s failed: %v", cfg.StatusFile, err)
+		}
+	}
+	os.Exit(status.Code.ExitCode())
+}
+
+// writeDbtSources records the target table in the dbtSourcesFile of cfg
+// after a run that loaded rows, a failed run leaves it as it was.
+func writeDbtSources(cfg *config.Config, status jobStatus) {
+	if cfg.DbtSourcesFile == "" || status.Status == "failed" {
+		return
+	}
+	var rows int64
+	for _, s := range source.LiveStats() {
+		rows += s.Rows
+	}
+	run := worker.DbtRun{Status: status.Status, Rows: rows, FinishedAt: status.FinishedAt}
+	if err := worker.WriteDbtSources(cfg, run); err != nil {
+		logrus.Errorf("write dbt sources %s failed: %v", cfg.DbtSourcesFile, err)
+		return
+	}
+	logrus.Infof("dbt sources written to %s", cfg.DbtSourcesFile)
+}
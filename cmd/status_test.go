@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/errcode"
+)
+
+func TestWriteStatusFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	startedAt := time.Now()
+	err := fmt.Errorf("%w: source data count is 10, databend data count is 9", errcode.ErrCountMismatch)
+	assert.NoError(t, writeStatusFile(path, newJobStatus(startedAt, err)))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var status jobStatus
+	assert.NoError(t, json.Unmarshal(data, &status))
+	assert.Equal(t, "failed", status.Status)
+	assert.Equal(t, errcode.CountMismatch, status.Code)
+	assert.Equal(t, 30, status.Code.ExitCode())
+	assert.Contains(t, status.Error, "databend data count is 9")
+
+	status = newJobStatus(startedAt, nil)
+	assert.Equal(t, "succeeded", status.Status)
+	assert.Equal(t, errcode.OK, status.Code)
+	assert.Equal(t, "", status.Error)
+
+	status = newJobStatus(startedAt, &partialRun{resumeFrom: map[string]string{"shop.orders": "120001"}})
+	assert.Equal(t, "partial", status.Status)
+	assert.Equal(t, errcode.Partial, status.Code)
+	assert.Equal(t, 3, status.Code.ExitCode())
+	assert.Equal(t, map[string]string{"shop.orders": "120001"}, status.ResumeFrom)
+}
@@ -0,0 +1,11 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+)
+
+func openSyslog(address string) (levelWriter, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
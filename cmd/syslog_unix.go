@@ -0,0 +1,49 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"log/syslog"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+// openSyslog connects to the syslog of the host, which journald serves on
+// systemd hosts, for "local", or to the syslog server of a udp:// or tcp://
+// address.
+func openSyslog(address string) (levelWriter, error) {
+	network, raddr := "", ""
+	if address != "local" {
+		u, err := url.Parse(address)
+		if err != nil {
+			return nil, err
+		}
+		network, raddr = u.Scheme, u.Host
+	}
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, "bend-archiver")
+	if err != nil {
+		return nil, err
+	}
+	return syslogWriter{w: w}, nil
+}
+
+func (s syslogWriter) WriteLevel(level logrus.Level, line []byte) error {
+	msg := string(line)
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return s.w.Crit(msg)
+	case logrus.ErrorLevel:
+		return s.w.Err(msg)
+	case logrus.WarnLevel:
+		return s.w.Warning(msg)
+	case logrus.InfoLevel:
+		return s.w.Info(msg)
+	default:
+		return s.w.Debug(msg)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/worker"
+)
+
+// runTier moves the old rows of a Databend table to cheaper storage, the
+// inverse of an archive job.
+func runTier(args []string) {
+	flags := flag.NewFlagSet("tier", flag.ExitOnError)
+	configFile := flags.String("f", "config/conf.json", "Path to the configuration file, only its Databend and tier settings are used")
+	flags.Parse(args)
+
+	cfg, err := config.LoadTierConfig(*configFile)
+	if err != nil {
+		panic(err)
+	}
+	if err := ingester.ConfigureDatabendTransport(cfg); err != nil {
+		panic(err)
+	}
+	startTime := time.Now()
+	rows, err := worker.Tier(cfg, startTime)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("tier: %d rows of %s moved to %s in %s\n", rows, cfg.TierTable, cfg.TierTo, time.Since(startTime).Round(time.Millisecond))
+}
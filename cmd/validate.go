@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func runValidate(args []string) {
+	os.Exit(validate(args, os.Stdout))
+}
+
+// validate checks the config files of args against the JSON Schema of the
+// config, then the settings the schema can't check, like the ones needed
+// together. It returns the exit code, 1 when a file is invalid.
+func validate(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	printSchema := flags.Bool("schema", false, "Print the JSON Schema of the config and exit")
+	flags.Parse(args)
+
+	if *printSchema {
+		out.Write(config.SchemaJSON())
+		return 0
+	}
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"config/conf.json"}
+	}
+	invalid := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(out, "%s: %v\n", file, err)
+			invalid++
+			continue
+		}
+		if problems := config.ValidateDocument(data); len(problems) > 0 {
+			for _, problem := range problems {
+				fmt.Fprintf(out, "%s: %s\n", file, problem)
+			}
+			invalid++
+			continue
+		}
+		if _, err := config.ValidateConfigFile(file); err != nil {
+			fmt.Fprintf(out, "%v\n", err)
+			invalid++
+			continue
+		}
+		fmt.Fprintf(out, "%s: ok\n", file)
+	}
+	if invalid > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.json")
+	assert.NoError(t, os.WriteFile(valid, []byte(`{"databaseType": "mysql", "sourceHost": "127.0.0.1", "sourcePort": 3306,
+		"sourceUser": "root", "sourcePass": "", "sourceDB": "shop", "sourceTable": "orders", "sourceSplitKey": "id",
+		"sourceWhereCondition": "1=1", "databendDSN": "databend://localhost:8000", "databendTable": "archive.orders"}`), 0644))
+	typo := filepath.Join(dir, "typo.json")
+	assert.NoError(t, os.WriteFile(typo, []byte(`{"databaseType": "mysql", "batchSize": "1000", "sourceTabel": "orders"}`), 0644))
+	incomplete := filepath.Join(dir, "incomplete.json")
+	assert.NoError(t, os.WriteFile(incomplete, []byte(`{"databaseType": "mysql"}`), 0644))
+
+	out := &bytes.Buffer{}
+	assert.Equal(t, 0, validate([]string{valid}, out))
+	assert.Equal(t, valid+": ok\n", out.String())
+
+	out.Reset()
+	assert.Equal(t, 1, validate([]string{typo, incomplete}, out))
+	assert.Contains(t, out.String(), typo+": batchSize: must be an integer, got a string\n"+typo+": sourceTabel: unknown setting\n")
+	assert.Contains(t, out.String(), "invalid config "+incomplete+": must set one of sourceSplitKey and sourceSplitTimeKey, or sourceSnapshot\n")
+
+	out.Reset()
+	assert.Equal(t, 0, validate([]string{"-schema"}, out))
+	assert.Equal(t, string(config.SchemaJSON()), out.String())
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ChildTable is a table whose rows reference the rows of the source table,
+// or of an earlier child table, through a foreign key. Its rows referencing
+// the archived rows are archived with them and purged before them.
+type ChildTable struct {
+	Table         string `json:"table"`         // table of sourceDB, e.g. order_items, or schema.table on Postgres
+	ForeignKey    string `json:"foreignKey"`    // column of Table referencing parentKey, e.g. order_id
+	Parent        string `json:"parent"`        // table referenced, sourceTable or the table of an earlier child, default is sourceTable
+	ParentKey     string `json:"parentKey"`     // column of the parent referenced, default is sourceSplitKey of sourceTable
+	SplitKey      string `json:"splitKey"`      // integer key Table is split by, read in one snapshot query when empty
+	DatabendTable string `json:"databendTable"` // default is the table name in the database of databendTable
+}
+
+// checkChildTables rejects child tables whose parent or keys are unknown,
+// and the settings archiving part of the parent rows, their children would
+// be archived and purged with the rest.
+func checkChildTables(cfg *Config) error {
+	switch cfg.DatabaseType {
+	case "", "mysql", "tidb", "pg":
+	default:
+		return fmt.Errorf("sourceChildTables is not supported by the %s source", cfg.DatabaseType)
+	}
+	if cfg.SourceSelect != "" || len(cfg.SourceDbTables) > 0 || cfg.SourceSnapshot {
+		return errors.New("sourceChildTables needs a single sourceTable, without sourceSelect, sourceDbTables or sourceSnapshot")
+	}
+	if cfg.DiffSync || cfg.SoftDeleteColumn != "" || cfg.MaxRuntime != "" || cfg.IsPartialRun() {
+		return errors.New("sourceChildTables can't be used with diffSync, softDeleteColumn, maxRuntime or a partial run")
+	}
+	parents := map[string]bool{cfg.SourceTable: true}
+	targets := map[string]bool{cfg.DatabendTable: true}
+	for i := range cfg.SourceChildTables {
+		c := &cfg.SourceChildTables[i]
+		if c.Table == "" || c.ForeignKey == "" {
+			return fmt.Errorf("sourceChildTables[%d] needs table and foreignKey", i)
+		}
+		if c.Parent == "" {
+			c.Parent = cfg.SourceTable
+		}
+		if !parents[c.Parent] {
+			return fmt.Errorf("parent %q of child table %s must be sourceTable or an earlier child table", c.Parent, c.Table)
+		}
+		if c.ParentKey == "" {
+			if c.Parent != cfg.SourceTable || cfg.SourceSplitKey == "" {
+				return fmt.Errorf("must set parentKey of child table %s", c.Table)
+			}
+			c.ParentKey = cfg.SourceSplitKey
+		}
+		if c.DatabendTable == "" {
+			db, _, _ := strings.Cut(cfg.DatabendTable, ".")
+			c.DatabendTable = db + "." + c.Table[strings.LastIndex(c.Table, ".")+1:]
+		}
+		if parents[c.Table] || targets[c.DatabendTable] {
+			return fmt.Errorf("child table %s or its databendTable %s is archived twice", c.Table, c.DatabendTable)
+		}
+		parents[c.Table] = true
+		targets[c.DatabendTable] = true
+	}
+	return nil
+}
+
+// ChildConfig is the config archiving and purging the rows of the child
+// table i: those whose foreign key is in the parent rows matching
+// sourceWhereCondition, through the chain of their parents.
+func (c *Config) ChildConfig(i int) *Config {
+	child := c.SourceChildTables[i]
+	cfg := *c
+	cfg.SourceTable = child.Table
+	cfg.SourceWhereCondition = c.childCondition(child)
+	cfg.SourceSplitKey = child.SplitKey
+	cfg.SourceSplitTimeKey = ""
+	cfg.SourceSnapshot = child.SplitKey == ""
+	cfg.DatabendTable = child.DatabendTable
+	cfg.SourceChildTables = nil
+	// the settings about the columns and the checks of the parent table
+	cfg.CheckpointFile = ""
+	cfg.ConflictCheckKeys = nil
+	cfg.VerificationQueries = nil
+	cfg.QualityRules = nil
+	cfg.TargetClusterBy = ""
+	cfg.TargetBloomIndexColumns = nil
+	cfg.TargetInvertedIndexes = nil
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1000
+	}
+	return &cfg
+}
+
+// childCondition matches the rows of child referencing the parent rows
+// matching sourceWhereCondition, e.g. order_id IN (SELECT id FROM shop.orders
+// WHERE created_at < '2024-01-01').
+func (c *Config) childCondition(child ChildTable) string {
+	parentCondition := c.SourceWhereCondition
+	if parentCondition == "" {
+		parentCondition = "1=1"
+	}
+	for _, p := range c.SourceChildTables {
+		if p.Table == child.Parent && child.Parent != c.SourceTable {
+			parentCondition = c.childCondition(p)
+			break
+		}
+	}
+	return fmt.Sprintf("%s IN (SELECT %s FROM %s WHERE %s)", child.ForeignKey, child.ParentKey,
+		c.sourceRelation(child.Parent), parentCondition)
+}
+
+// sourceRelation is the name of table of sourceDB in the queries of the
+// source, Postgres connects to sourceDB.
+func (c *Config) sourceRelation(table string) string {
+	if c.DatabaseType == "pg" {
+		return table
+	}
+	return c.SourceDB + "." + table
+}
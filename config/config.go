@@ -3,11 +3,17 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"os/exec"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
 type TimeSplitUnit int
@@ -45,15 +51,27 @@ type Config struct {
 	SourceTable          string   `json:"sourceTable"`
 	SourceDbTables       []string `json:"sourceDbTables"`       // source db tables format: [db1.table1,db2.table2] or [db.*@table.*,mydb.*.table.*]
 	SourceQuery          string   `json:"sourceQuery"`          // select * from table where condition
+	SourceSelect         string   `json:"sourceSelect"`         // archive the result set of this SELECT instead of a table, split by its sourceSplitKey/SourceSplitTimeKey column
 	SourceWhereCondition string   `json:"sourceWhereCondition"` //example: where id > 100 and id < 200 and time > '2023-01-01'
 	SourceSplitKey       string   `json:"sourceSplitKey"`       // primary split key for split table, only for int type
 	// the format of time field must be: 2006-01-02 15:04:05
 	SourceSplitTimeKey string `json:"SourceSplitTimeKey"`           // time field for split table
 	TimeSplitUnit      string `json:"TimeSplitUnit" default:"hour"` // time split unit, default is hour, option is: minute, hour, day
+	// Read the table, a view or sourceSelect without a split key, in one query cut into batches of batchSize rows,
+	// for views and queries with no integer or time column to split by (MySQL/TiDB, Postgres)
+	SourceSnapshot bool `json:"sourceSnapshot"`
+	// Record where the sourceSnapshot of a MySQL/TiDB table was read, its binlog position and GTID set (TiDB: TSO), in
+	// the archive manifest and statusFile, for CDC pipelines to start from where the archive ends
+	SourceBookmark     bool `json:"sourceBookmark"`
+	SourceBookmarkLock bool `json:"sourceBookmarkLock"` // take it under FLUSH TABLES WITH READ LOCK (RELOAD privilege) to make it exact on MySQL
+	// Tables whose rows reference the archived rows through a foreign key, e.g. the line items of the orders, archived
+	// after them into their own databendTable and purged before them with deleteAfterSync (MySQL/TiDB, Postgres)
+	SourceChildTables []ChildTable `json:"sourceChildTables"`
 
 	// Databend configuration
 	DatabendDSN      string `json:"databendDSN" default:"localhost:8000"`
 	DatabendTable    string `json:"databendTable"`
+	JobName          string `json:"jobName"` // job name in the comment of the source queries, default is databendTable
 	BatchSize        int64  `json:"batchSize" default:"1000"`
 	BatchMaxInterval int    `json:"batchMaxInterval" default:"3"` // for rate limit control
 
@@ -62,13 +80,476 @@ type Config struct {
 	CopyForce           bool   `json:"copyForce" default:"false"`
 	DisableVariantCheck bool   `json:"disableVariantCheck" default:"true"`
 	UserStage           string `json:"userStage" default:"~"`
+	StageCompression    string `json:"stageCompression"`  // "gzip" compresses the staged files, default is none
+	VerifyStagedFiles   bool   `json:"verifyStagedFiles"` // check the size and MD5 of every uploaded file in the stage before COPY
+	TempDir             string `json:"tempDir"`           // directory the batch files are written to before upload, default is the system temp dir
+	TempMinFreeMB       int64  `json:"tempMinFreeMB"`     // space that must stay free in tempDir after writing a batch file, default is 100
 	DeleteAfterSync     bool   `json:"deleteAfterSync" default:"false"`
 	MaxThread           int    `json:"maxThread" default:"1"` // only supported with SourceSplitKey (auto increment)
+	JobMaxThread        int    `json:"jobMaxThread"`          // threads shared by the tables of a multi-table job in proportion to their size, 0 runs tables one by one with maxThread each
+	Autotune            bool   `json:"autotune"`              // try batch sizes, thread counts and stage compression on the first key ranges and keep the fastest
+	AutotuneTrial       string `json:"autotuneTrial"`         // duration of one autotune trial, default is 20s
+	DeterministicOrder  bool   `json:"deterministicOrder"`    // order each batch by the split key so repeated runs stage identical files
+	SourceRetryAttempts int    `json:"sourceRetryAttempts"`   // attempts of a batch read on transient source errors, default is 5, 1 disables retries
+	SourceQueryTimeout  string `json:"sourceQueryTimeout"`    // time an attempt of a batch read may take, e.g. 10m, a timed out read is retried, unlimited when empty
+	StopGracePeriod     string `json:"stopGracePeriod"`       // time the batch reads in flight on SIGTERM may take to finish before they are cancelled, default is 25s
+	StatsLogInterval    string `json:"statsLogInterval"`      // interval of the logged per-table and per-thread stats, default is 1m
+	MaxRuntime          string `json:"maxRuntime"`            // stop starting new ranges or files after this long, e.g. 4h, and exit with the key to resume from
+	CheckpointFile      string `json:"checkpointFile"`        // key ranges archived so far, saved after every batch, a restarted job resumes from them
+	// Pools of the staged pipeline: maxThread threads read the batches, which are handed to separate pools
+	// that serialize them and that upload and copy them, so CPU-bound serialization scales apart from the reads
+	SerializeThreads int `json:"serializeThreads"` // batches written, compressed and encrypted at once, 0 serializes on the reading threads
+	UploadThreads    int `json:"uploadThreads"`    // batches uploaded and copied at once, 0 uploads on the reading threads
+	// For deployments that forbid stages, "insert" loads the batches with multi-row INSERT statements of at most
+	// insertChunkBytes each instead of staging them, slower but with no stage or object storage involved
+	IngestMode       string `json:"ingestMode"`       // stage or insert, default is stage
+	InsertChunkBytes int64  `json:"insertChunkBytes"` // size of an INSERT statement, default is 4 MiB
+	// Files staged by concurrent threads within copyGroupWait of each other are loaded by one
+	// COPY INTO ... FILES = (...) of up to copyMaxFiles files, saving the overhead of a statement per file
+	CopyMaxFiles  int    `json:"copyMaxFiles"`  // files of one COPY INTO, 0 or 1 copies every file on its own
+	CopyGroupWait string `json:"copyGroupWait"` // time the first file of a COPY waits for others, default is 1s
+	// Throttling of the loads of the job while the warehouse is busy with the queries of others, e.g. BI dashboards:
+	// the COPY INTO (or INSERT) of a batch waits while more than warehouseMaxQueries other queries run
+	WarehouseMaxQueries   int    `json:"warehouseMaxQueries"`   // 0 never waits
+	WarehouseLoadQuery    string `json:"warehouseLoadQuery"`    // count of the running queries, default counts system.processes
+	WarehouseLoadInterval string `json:"warehouseLoadInterval"` // time between two checks of the load, default is 10s
+	// Approximate cost of the run on Databend Cloud, reported in statusFile: the bytes loaded, and the credits of the
+	// time its COPY INTO and INSERT ran at warehouseCreditsPerHour. Over a budget no new range or file is started and
+	// the job pauses (ends partial, resumed by the next run) or aborts (fails with BUDGET_EXCEEDED)
+	WarehouseCreditsPerHour float64 `json:"warehouseCreditsPerHour"` // credits per hour of the size of databendWarehouse
+	BudgetCredits           float64 `json:"budgetCredits"`           // credits of a run, 0 is unlimited
+	BudgetBytes             int64   `json:"budgetBytes"`             // bytes loaded by a run, 0 is unlimited
+	BudgetAction            string  `json:"budgetAction"`            // pause or abort, default is pause
+	// Retry budget of the whole job, shared by the batch reads and the Databend uploads and copies, so a
+	// systematically failing job gives up with RETRY_BUDGET_EXHAUSTED instead of retrying all night
+	RetryBudget     int    `json:"retryBudget"`     // retries allowed, 0 is unlimited
+	RetryBudgetTime string `json:"retryBudgetTime"` // total time allowed in retries, e.g. 30m, unlimited when empty
+	// Purge of the MySQL source by deleteAfterSync, in small chunks so it neither locks the table nor lags the replicas
+	PurgeChunkRows   int64  `json:"purgeChunkRows"`   // split keys (rows without sourceSplitKey) deleted by one statement, default is batchSize
+	PurgeChunkPause  string `json:"purgeChunkPause"`  // pause between two chunks, default is batchMaxInterval seconds
+	PurgeLowPriority bool   `json:"purgeLowPriority"` // DELETE LOW_PRIORITY, waiting for the readers of the table
+	// Maintenance of the source tables after a verified purge, to reclaim the space of the purged rows
+	PurgeMaintenance []string `json:"purgeMaintenance"` // optimize, vacuum and/or analyze, run in this order, see maintenanceOperations
+	// TLS of the MySQL/Postgres source and of the Databend connection, for servers that require (m)TLS
+	SourceTLSCA           string `json:"sourceTLSCA"`           // PEM CA bundle trusted on top of the system roots
+	SourceTLSCert         string `json:"sourceTLSCert"`         // client certificate for mTLS
+	SourceTLSKey          string `json:"sourceTLSKey"`          // client key for mTLS
+	SourceTLSSkipVerify   bool   `json:"sourceTLSSkipVerify"`   // don't verify the server certificate
+	DatabendTLSCA         string `json:"databendTLSCA"`         // PEM CA bundle trusted on top of the system roots
+	DatabendTLSCert       string `json:"databendTLSCert"`       // client certificate for mTLS
+	DatabendTLSKey        string `json:"databendTLSKey"`        // client key for mTLS
+	DatabendTLSSkipVerify bool   `json:"databendTLSSkipVerify"` // don't verify the server certificate
+	// Session of every Databend connection of the job, they override the params of databendDSN
+	DatabendWarehouse string            `json:"databendWarehouse"` // Databend Cloud warehouse
+	DatabendRole      string            `json:"databendRole"`      // role the archive queries run as
+	DatabendQueryTag  string            `json:"databendQueryTag"`  // query_tag setting, shown in system.query_log
+	DatabendTimezone  string            `json:"databendTimezone"`  // timezone setting, e.g. UTC
+	DatabendSettings  map[string]string `json:"databendSettings"`  // other session settings, e.g. {"max_threads": "8"}
+	// OAuth client credentials for Databend Cloud, the access token replaces the password of databendDSN and is refreshed before it expires
+	DatabendTokenURL     string   `json:"databendTokenURL"`     // token endpoint of the identity provider
+	DatabendClientID     string   `json:"databendClientID"`     // OAuth client id
+	DatabendClientSecret string   `json:"databendClientSecret"` // OAuth client secret, may reference env vars like ${DATABEND_CLIENT_SECRET}
+	DatabendScopes       []string `json:"databendScopes"`       // scopes requested with the token
+	// Outbound proxy of the Databend queries and stage uploads, http://, https:// or socks5://[user:pass@]host:port
+	DatabendProxy string `json:"databendProxy"`
+	// Protocol the queries and stage uploads of the job go through, each driver keeps one pool of connections per target
+	DatabendDriver   string `json:"databendDriver" default:"http"` // http, or a driver registered by the build, e.g. flightsql
+	DatabendMaxConns int    `json:"databendMaxConns"`              // connections of the pool, unlimited when 0
+	// SSH tunnel through a bastion host to a MySQL/Postgres/Oracle/SQL Server source in a private subnet
+	SourceSSHHost       string `json:"sourceSSHHost"`       // bastion host[:port], default port is 22
+	SourceSSHUser       string `json:"sourceSSHUser"`       // user of the bastion host
+	SourceSSHKeyFile    string `json:"sourceSSHKeyFile"`    // private key of sourceSSHUser
+	SourceSSHKnownHosts string `json:"sourceSSHKnownHosts"` // known_hosts file verifying the bastion host key
+	// Connect to sftp sources and SSH bastions without their known_hosts, to any host that answers
+	SourceInsecureSkipHostKeyCheck bool `json:"sourceInsecureSkipHostKeyCheck"`
 	// Oracle
 	OracleSID string `json:"oracleSID"`
+	// Postgres
+	PgStringifyComplexTypes bool `json:"pgStringifyComplexTypes"` // keep arrays, composite and json values in their Postgres text form
+	// Floats of the staged batches, for targets that don't parse every number notation, e.g. DECIMAL columns
+	FloatNotation         string `json:"floatNotation"`         // "plain" never uses scientific notation, default "auto" uses it below 1e-6 and from 1e21
+	FloatPrecision        int    `json:"floatPrecision"`        // digits after the decimal point, rounded, 0 keeps every digit of the value
+	WholeFloatsAsIntegers bool   `json:"wholeFloatsAsIntegers"` // write floats without a fraction as integers, e.g. 3 instead of 3.00 with floatPrecision
+	// Archive manifest for audits
+	ArchiveManifestFile string `json:"archiveManifestFile"` // write a manifest of the staged batches, their hashes and the target snapshots
+	ArchiveManifestKey  string `json:"archiveManifestKey"`  // ed25519 PKCS #8 PEM private key signing the archive manifest
+	// Encrypted archive copies for regulated data: every batch is uploaded encrypted with gpg under stageEncryptionPath,
+	// where it is kept, and its rows are loaded with INSERT instead of COPY INTO, so no plain batch lands in the stage
+	StageEncryptionKeys []string `json:"stageEncryptionKeys"` // armored GPG public keys (gpg --armor --export) the copies are encrypted to
+	StageEncryptionPath string   `json:"stageEncryptionPath"` // path of userStage the copies are kept under, e.g. encrypted/
+	// Sampling archives a deterministic subset of the source to validate a setup before the full run
+	SampleRows    int64   `json:"sampleRows"`    // stop after this many rows
+	SamplePercent float64 `json:"samplePercent"` // keep this percentage of the rows, picked by a hash of the split key
+	// Row ranges of a job, for staged rollouts or resuming approximately by hand
+	MaxRows      int64  `json:"maxRows"`      // stop after this many rows
+	StartFromRow int64  `json:"startFromRow"` // skip the first rows read
+	StartFromKey string `json:"startFromKey"` // lower bound of sourceSplitKey, or of sourceSplitTimeKey as 2006-01-02 15:04:05
+	// Archive metadata columns added to every ingested row, so analysts can tell which run produced which rows:
+	// archive_run_id, archived_at, source_host and/or source_table (the file of file sources), databendTable needs them
+	MetadataColumns []string `json:"metadataColumns"`
+	// Rows larger than MaxRowSize bytes (0 is unlimited) are truncated, written to DeadLetterFile or fail the job
+	MaxRowSize         int64  `json:"maxRowSize"`
+	OversizedRowPolicy string `json:"oversizedRowPolicy"` // fail, truncate or deadletter, default is fail
+	DeadLetterFile     string `json:"deadLetterFile"`     // local NDJSON file of the rows that were not ingested
+	// Batches larger than MaxBatchBytes estimated bytes (0 is unlimited) are staged and loaded in several chunks,
+	// for wide tables whose batches exceed the upload or packet limits
+	MaxBatchBytes int64 `json:"maxBatchBytes"`
+	// Zero dates (0000-00-00) and dates out of the 1000-9999 range of the SQL sources
+	InvalidDatePolicy   string `json:"invalidDatePolicy"`   // null, sentinel or reject, values are kept as is when empty
+	InvalidDateSentinel string `json:"invalidDateSentinel"` // value used by the sentinel policy, default is 1970-01-01 00:00:00
+	// Booleans spelled 1/0, t/f, yes/no, y/n or on/off, e.g. in CSV files or TINYINT(1) columns
+	NormalizeBooleans bool            `json:"normalizeBooleans"` // read those spellings as booleans in the BOOLEAN columns of databendTable
+	BooleanColumns    map[string]bool `json:"booleanColumns"`    // per column override, true normalizes a column whatever its type, false leaves it as is
+
+	// File source configuration, used when databaseType is "file", "sftp" or "ftp"
+	SourcePath         string `json:"sourcePath"`         // directory that holds the files to archive
+	SourceFilePattern  string `json:"sourceFilePattern"`  // regex of file names to pick up, default is all files
+	SourceFormat       string `json:"sourceFormat"`       // csv, ndjson, protobuf or log, default is inferred from the file extension
+	ManifestFile       string `json:"manifestFile"`       // local JSON manifest of already ingested files
+	MoveAfterSync      string `json:"moveAfterSync"`      // move ingested files into this directory instead of deleting them
+	SourceKeyFile      string `json:"sourceKeyFile"`      // sftp private key, sourcePass is used as its passphrase if it is encrypted
+	SourceKnownHosts   string `json:"sourceKnownHosts"`   // sftp known_hosts file verifying the host key of sourceHost
+	FTPExplicitTLS     bool   `json:"ftpExplicitTLS"`     // use FTPS (AUTH TLS) for ftp
+	ProtoDescriptorSet string `json:"protoDescriptorSet"` // FileDescriptorSet of protobuf files (protoc --include_imports --descriptor_set_out)
+	ProtoMessage       string `json:"protoMessage"`       // full name of the message of protobuf files, e.g. events.v1.Event
+	LogPattern         string `json:"logPattern"`         // regex with named groups, or grok pattern, extracting the columns of log files
+	LogTimeField       string `json:"logTimeField"`       // column parsed with logTimeLayout, default is timestamp
+	LogTimeLayout      string `json:"logTimeLayout"`      // Go time layout of logTimeField, the column is kept as is when empty
+	// CSV files of the file sources with padded values or headers that don't make valid column names
+	CSVTrim         map[string]string `json:"csvTrim"`         // whitespace trimmed from the values of a column, or of all with "*": both, leading or trailing
+	CSVHeaderPolicy string            `json:"csvHeaderPolicy"` // suffix, snake_case or quote renames empty, duplicate and reserved column names, kept as is when empty
+
+	// HTTP source configuration, used when databaseType is "http"
+	SourceURLs           []string          `json:"sourceURLs"`           // urls to download, or first pages of a paginated API
+	SourceHTTPHeaders    map[string]string `json:"sourceHTTPHeaders"`    // request headers, values may reference env vars like ${API_TOKEN}
+	SourceRecordsPath    string            `json:"sourceRecordsPath"`    // dot path of the records array in paginated JSON responses
+	SourceNextCursorPath string            `json:"sourceNextCursorPath"` // dot path of the next cursor, enables pagination
+	SourceCursorParam    string            `json:"sourceCursorParam"`    // query param carrying the cursor, the cursor is the next page url when empty
+
+	// Google Sheets source configuration, used when databaseType is "gsheets"
+	SheetIDs              []string `json:"sheetIDs"`              // spreadsheet ids, every tab is archived as a table
+	GoogleCredentialsFile string   `json:"googleCredentialsFile"` // service account key, default is $GOOGLE_APPLICATION_CREDENTIALS
+
+	// Elasticsearch source configuration, used when databaseType is "elasticsearch",
+	// sourceTable is a regex of the indices to archive
+	ElasticsearchURL      string `json:"elasticsearchURL"`      // e.g. https://es.internal:9200, sourceUser/sourcePass are used for basic auth
+	ElasticsearchQuery    string `json:"elasticsearchQuery"`    // query DSL selecting the documents, default is match_all
+	ElasticsearchFlatten  bool   `json:"elasticsearchFlatten"`  // flatten nested objects into a_b columns instead of VARIANT values
+	ElasticsearchIDColumn string `json:"elasticsearchIDColumn"` // column that receives the document _id, omitted when empty
+
+	// Cassandra/ScyllaDB source configuration, used when databaseType is "cassandra" or "scylladb",
+	// sourceHost may list several comma separated contact points and sourceDB is the keyspace
+	CassandraConsistency string `json:"cassandraConsistency"` // read consistency level, default is LOCAL_QUORUM
+	CassandraTokenRanges int    `json:"cassandraTokenRanges"` // number of token ranges the ring is split into, default is 16 per thread
+
+	// InfluxDB source configuration, used when databaseType is "influxdb",
+	// sourceDB is the database (v1) or bucket (v2) and sourceTable a measurement
+	InfluxURL               string `json:"influxURL"`               // e.g. http://influxdb:8086
+	InfluxVersion           int    `json:"influxVersion"`           // 1 for InfluxQL, 2 for Flux, default is 1
+	InfluxToken             string `json:"influxToken"`             // v2 API token, v1 uses sourceUser/sourcePass
+	InfluxOrg               string `json:"influxOrg"`               // v2 organization
+	InfluxStart             string `json:"influxStart"`             // RFC3339 start of the exported range, inclusive
+	InfluxStop              string `json:"influxStop"`              // RFC3339 end of the exported range, exclusive, default is the start of the job
+	InfluxChunk             string `json:"influxChunk"`             // duration of one exported chunk, default is 1h
+	InfluxAggregateWindow   string `json:"influxAggregateWindow"`   // downsample into windows of this duration, e.g. 5m, disabled when empty
+	InfluxAggregateFunction string `json:"influxAggregateFunction"` // aggregate function of a window, default is mean
+
+	// Snowflake source configuration, used when databaseType is "snowflake",
+	// sourceUser is the Snowflake user and sourceDB the database
+	SnowflakeAccount        string `json:"snowflakeAccount"`        // account identifier, e.g. myorg-myaccount
+	SnowflakeURL            string `json:"snowflakeURL"`            // default is https://<account>.snowflakecomputing.com
+	SnowflakeWarehouse      string `json:"snowflakeWarehouse"`      // warehouse running the queries
+	SnowflakeRole           string `json:"snowflakeRole"`           // role of the session, default is the user's default role
+	SnowflakeSchema         string `json:"snowflakeSchema"`         // schema of sourceTable, default is PUBLIC
+	SnowflakePrivateKeyFile string `json:"snowflakePrivateKeyFile"` // PEM private key for key pair authentication
+	SnowflakeToken          string `json:"snowflakeToken"`          // OAuth token, used when no private key is set
+
+	// Hive source configuration, used when databaseType is "hive" (HiveServer2 or the Spark Thrift Server)
+	HiveTransport        string `json:"hiveTransport"`        // "binary" (SASL PLAIN, default port 10000) or "http" (default port 10001)
+	HiveHTTPPath         string `json:"hiveHTTPPath"`         // http endpoint path, default is cliservice
+	HiveTLS              bool   `json:"hiveTLS"`              // connect with TLS
+	HivePartitionPattern string `json:"hivePartitionPattern"` // regex over partition specs like dt=2024-01-01/country=us, all partitions when empty
+
+	// Diff sync of small dimension tables: the source is staged in full, then only the changed rows are
+	// inserted, updated or deleted in databendTable instead of copying the whole table again
+	DiffSync     bool     `json:"diffSync"`
+	DiffSyncKeys []string `json:"diffSyncKeys"` // primary key columns matching the rows of both sides
+
+	// Soft deletes of the source, rows whose softDeleteColumn is true or set (e.g. is_deleted or deleted_at)
+	// are deleted from databendTable by their softDeleteKeys, and archived again with their flag in flag mode
+	SoftDeleteColumn string   `json:"softDeleteColumn"`
+	SoftDeleteKeys   []string `json:"softDeleteKeys"` // key columns of the rows, default is sourceSplitKey
+	SoftDeleteMode   string   `json:"softDeleteMode"` // delete or flag, default is delete
+
+	// Primary key columns of databendTable checked for duplicates after the load, e.g. from an accidental double
+	// run, the offending key ranges are reported and fail the count check
+	ConflictCheckKeys []string `json:"conflictCheckKeys"`
+
+	// Checks of databendTable after the load: ANALYZE TABLE refreshes its statistics, and every verification query
+	// must return no rows or true (1), otherwise the job fails before the purge, e.g. SELECT count(*) = 0 FROM ... WHERE id IS NULL
+	AnalyzeTarget       bool     `json:"analyzeTarget"`
+	VerificationQueries []string `json:"verificationQueries"`
+
+	// Data quality rules checked on a sample of the rows while they are ingested, a rule over its failPercent fails
+	// the job after the load, before the purge, and the results of the rules are written to statusFile
+	QualityRules         []QualityRule `json:"qualityRules"`
+	QualitySamplePercent float64       `json:"qualitySamplePercent"` // percentage of the rows checked, default is 100
+
+	// Layout of databendTable, set with ALTER TABLE before the load and in the DDL of the tables the job creates, so
+	// the archive is query-efficient without a manual step. Compression and storage_format can only be set at creation
+	TargetClusterBy    string            `json:"targetClusterBy"`    // cluster key expressions, e.g. to_yyyymmdd(created_at), user_id
+	TargetTableOptions map[string]string `json:"targetTableOptions"` // e.g. {"compression": "zstd", "row_per_block": "500000"}
+	// Secondary indexes of databendTable, for archives queried by high-cardinality ids or text
+	TargetBloomIndexColumns []string            `json:"targetBloomIndexColumns"` // columns with bloom filters, set with the table options
+	TargetInvertedIndexes   map[string][]string `json:"targetInvertedIndexes"`   // name to text columns, created and refreshed after the load
+
+	// JSON file the outcome of the job is written to, with the error code when it failed
+	StatusFile string `json:"statusFile"`
+
+	// History of the runs of the job, one JSON line per run appended to historyFile and/or a row in historyTable
+	// (db.table, created if missing), listed by the history command
+	HistoryFile  string `json:"historyFile"`
+	HistoryTable string `json:"historyTable"`
+
+	// Heartbeat with the progress of the job, written to heartbeatFile and/or POSTed to heartbeatURL every
+	// heartbeatInterval (default 10s), for supervisors restarting a hung job
+	HeartbeatFile     string `json:"heartbeatFile"`
+	HeartbeatURL      string `json:"heartbeatURL"`
+	HeartbeatInterval string `json:"heartbeatInterval"`
+
+	// Logs of the job, written to stdout, or to logFile rotated at logMaxSize MB (default 100) and every
+	// logRotateInterval, keeping logMaxBackups (default 7) rotated files, and/or to syslog
+	LogFile           string            `json:"logFile"`
+	LogMaxSize        int               `json:"logMaxSize"`
+	LogRotateInterval string            `json:"logRotateInterval"` // e.g. 24h, by size only when empty
+	LogMaxBackups     int               `json:"logMaxBackups"`
+	LogSyslog         string            `json:"logSyslog"` // "local" for the syslog or journald of the host, or udp://host:514, tcp://host:514
+	LogLevel          string            `json:"logLevel"`  // debug, info, warn or error, default is info
+	LogLevels         map[string]string `json:"logLevels"` // level of the logs of a component, source, worker or ingester
+
+	// Debug logs of every statement run on the source and on Databend, with its parameters; credentials, the values
+	// of the logSQLMaskColumns columns and the inserted rows are redacted
+	LogSQL            bool     `json:"logSQL"`
+	LogSQLMaskColumns []string `json:"logSQLMaskColumns"` // e.g. ["email", "ssn"]
+
+	// Hooks run at the lifecycle events of the job with the event as JSON, for ticketing or lineage systems
+	Hooks       []Hook `json:"hooks"`
+	HookTimeout string `json:"hookTimeout"` // time a hook may take, default 30s
+
+	// OpenLineage run events of the job, START then COMPLETE, FAIL or ABORT, POSTed to openLineageURL with the
+	// source tables or files as inputs and databendTable as output
+	OpenLineageURL       string `json:"openLineageURL"`       // e.g. http://marquez:5000/api/v1/lineage
+	OpenLineageNamespace string `json:"openLineageNamespace"` // namespace of the job, default is bend-archiver
+	OpenLineageJobName   string `json:"openLineageJobName"`   // default is databendTable
+	OpenLineageAPIKey    string `json:"openLineageAPIKey"`    // bearer token, may reference env vars like ${OPENLINEAGE_API_KEY}
+
+	// dbt sources file the target table is recorded in after each run that loaded rows, in the shape of dbt source
+	// properties, so that dbt projects can reference it and check its freshness
+	DbtSourcesFile         string `json:"dbtSourcesFile"`         // e.g. models/staging/archive_sources.yml, updated in place
+	DbtSourceName          string `json:"dbtSourceName"`          // default is the database of databendTable
+	DbtLoadedAtField       string `json:"dbtLoadedAtField"`       // default is archived_at when it is in metadataColumns
+	DbtFreshnessWarnAfter  string `json:"dbtFreshnessWarnAfter"`  // Go duration or days like 2d
+	DbtFreshnessErrorAfter string `json:"dbtFreshnessErrorAfter"` // Go duration or days like 2d
+
+	// Alerts when the source reads slow down, e.g. a struggling replica, logged and POSTed to slowReadAlertURL:
+	// the mean read time of the last batches is over slowReadThreshold, or over slowReadFactor times the first ones
+	SlowReadThreshold string  `json:"slowReadThreshold"` // e.g. 30s
+	SlowReadFactor    float64 `json:"slowReadFactor"`    // e.g. 3
+	SlowReadAlertURL  string  `json:"slowReadAlertURL"`  // webhook the alerts and recoveries are POSTed to as JSON
+
+	// Retention of the archived data on the target, enforced after a job and by the retention subcommand
+	RetentionPolicies []RetentionPolicy `json:"retentionPolicies"`
+	RetentionInterval string            `json:"retentionInterval"` // the retention subcommand enforces the policies every interval, e.g. 24h, once when empty
+
+	// Tiering moves the old rows of a Databend table to cheaper storage with the tier subcommand, the inverse of
+	// archiving: they are exported, verified, then deleted from the hot table
+	TierTable      string `json:"tierTable"`      // hot db.table
+	TierTimeColumn string `json:"tierTimeColumn"` // time column of tierTable
+	TierAfter      string `json:"tierAfter"`      // rows older than this are moved, Go duration or days like 90d
+	TierTo         string `json:"tierTo"`         // @stage/path/ the rows are exported to as Parquet, or db.table of an archive database
+
+	// Synthetic source of the bench subcommand, used when databaseType is "bench"
+	BenchRows    int64    `json:"benchRows"`    // rows generated, default is 1000000
+	BenchColumns []string `json:"benchColumns"` // name:type[:cardinality], type is int, float, string, bool or time, all values unique when no cardinality
+
+	// Credentials of the sources and targets of several teams, inline or in profilesFile shared by their jobs: a job
+	// picks its source and target with sourceProfile and targetProfile, and may only pick the profiles of its tenant
+	Tenant        string                       `json:"tenant"`       // team the job runs for, in its logs, heartbeat, status and hook events
+	Profiles      map[string]CredentialProfile `json:"profiles"`     // credential profiles by name
+	ProfilesFile  string                       `json:"profilesFile"` // JSON file of credential profiles by name, e.g. /etc/bend-archiver/profiles.json
+	SourceProfile string                       `json:"sourceProfile"`
+	TargetProfile string                       `json:"targetProfile"`
+
+	// Set when the config is loaded, to tell the logs, staged files and stats of concurrent jobs apart
+	JobID string `json:"-"` // hash of the settings, the same for every run of the job
+	RunID string `json:"-"` // unique to this run
+}
+
+// RetentionPolicy keeps the rows of a target table, or the periodic tables
+// of a target database, for Period.
+type RetentionPolicy struct {
+	Table           string `json:"table"`           // db.table whose rows are deleted once TimeColumn is older than Period
+	TimeColumn      string `json:"timeColumn"`      // time column of Table
+	Database        string `json:"database"`        // database of periodic tables, dropped once the time in their name is older than Period
+	TablePattern    string `json:"tablePattern"`    // regex of the periodic tables, its first group is their time, e.g. ^orders_(\d{6})$
+	TableTimeLayout string `json:"tableTimeLayout"` // Go time layout of that group, e.g. 200601
+	Period          string `json:"period"`          // Go duration or days like 90d
+}
+
+// HookEvents are the events hooks run at.
+var HookEvents = []string{"job_start", "table_complete", "batch_failed", "job_end"}
+
+// Hook runs Command with the JSON of an event on stdin, and/or POSTs it to
+// URL, at Events.
+type Hook struct {
+	Events  []string `json:"events"`  // job_start, table_complete, batch_failed or job_end, every event when empty
+	Command []string `json:"command"` // program and arguments, e.g. ["/opt/hooks/ticket.sh", "--queue", "data"]
+	URL     string   `json:"url"`     // webhook the event is POSTed to
+}
+
+func checkHook(h Hook) error {
+	if len(h.Command) == 0 && h.URL == "" {
+		return errors.New("a hook needs command or url")
+	}
+	for _, event := range h.Events {
+		if !slices.Contains(HookEvents, event) {
+			return fmt.Errorf("unknown hook event %q, it should be one of %s", event, strings.Join(HookEvents, ", "))
+		}
+	}
+	if h.URL != "" {
+		if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("hook url must be http:// or https://, got %q", h.URL)
+		}
+	}
+	return nil
+}
+
+// RetentionPeriod parses Period.
+func (p RetentionPolicy) RetentionPeriod() (time.Duration, error) {
+	d, err := ParsePeriod(p.Period)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention period %q", p.Period)
+	}
+	return d, nil
+}
+
+// ParsePeriod parses a positive Go duration or a number of days like 90d.
+func ParsePeriod(period string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(period, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid period %q", period)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid period %q", period)
+	}
+	return d, nil
+}
+
+func checkRetentionPolicy(p RetentionPolicy) error {
+	if _, err := p.RetentionPeriod(); err != nil {
+		return err
+	}
+	switch {
+	case p.Table != "" && p.TablePattern == "":
+		if p.TimeColumn == "" {
+			return fmt.Errorf("retention policy of %s needs timeColumn", p.Table)
+		}
+	case p.TablePattern != "" && p.Table == "":
+		if p.Database == "" || p.TableTimeLayout == "" {
+			return fmt.Errorf("retention policy of %s needs database and tableTimeLayout", p.TablePattern)
+		}
+		re, err := regexp.Compile(p.TablePattern)
+		if err != nil {
+			return fmt.Errorf("invalid retention tablePattern: %v", err)
+		}
+		if re.NumSubexp() < 1 {
+			return fmt.Errorf("retention tablePattern %s needs a group matching the time of the tables", p.TablePattern)
+		}
+	default:
+		return errors.New("a retention policy needs either table or tablePattern")
+	}
+	return nil
 }
 
 func LoadConfig(configFile string) (*Config, error) {
+	conf, err := decodeConfig(configFile)
+	if err != nil {
+		return conf, err
+	}
+	preCheckConfig(conf)
+
+	return conf, nil
+}
+
+// LoadBenchConfig loads the config of a job for the bench subcommand: the
+// source is replaced by the synthetic bench source, the Databend settings are
+// kept as they are.
+func LoadBenchConfig(configFile string) (*Config, error) {
+	conf, err := decodeConfig(configFile)
+	if err != nil {
+		return conf, err
+	}
+	conf.DatabaseType = "bench"
+	if conf.SourceDB == "" {
+		conf.SourceDB = "bench"
+	}
+	if conf.SourceTable == "" {
+		conf.SourceTable = "bench"
+	}
+	conf.SourceDbTables = nil
+	conf.DeleteAfterSync = false
+	conf.MoveAfterSync = ""
+	preCheckConfig(conf)
+
+	return conf, nil
+}
+
+// LoadStdinConfig loads the config of a job archiving the rows piped to
+// stdin in format (csv or ndjson, sourceFormat when empty), the source
+// settings of the file are ignored.
+func LoadStdinConfig(configFile, format string) (*Config, error) {
+	conf, err := decodeConfig(configFile)
+	if err != nil {
+		return conf, err
+	}
+	conf.DatabaseType = "stdin"
+	if format != "" {
+		conf.SourceFormat = format
+	}
+	conf.SourceDbTables = nil
+	preCheckConfig(conf)
+
+	return conf, nil
+}
+
+// LoadTierConfig loads the config of the tier subcommand, which only reads
+// the Databend and tier settings.
+func LoadTierConfig(configFile string) (*Config, error) {
+	conf, err := decodeConfig(configFile)
+	if err != nil {
+		return conf, err
+	}
+	if conf.DatabendDSN == "" {
+		panic("must set databendDSN")
+	}
+	if conf.TierTable == "" || conf.TierTimeColumn == "" || conf.TierTo == "" {
+		panic("must set tierTable, tierTimeColumn and tierTo")
+	}
+	if _, err := ParsePeriod(conf.TierAfter); err != nil {
+		panic(fmt.Sprintf("invalid tierAfter %q", conf.TierAfter))
+	}
+	if conf.TierTo == conf.TierTable {
+		panic("tierTo must not be tierTable")
+	}
+
+	return conf, nil
+}
+
+func decodeConfig(configFile string) (*Config, error) {
 	conf := Config{}
 
 	f, err := os.Open(configFile)
@@ -82,23 +563,484 @@ func LoadConfig(configFile string) (*Config, error) {
 		fmt.Println("Error decoding JSON:", err)
 		return &conf, err
 	}
-	preCheckConfig(&conf)
-
 	return &conf, nil
 }
 
 func preCheckConfig(cfg *Config) {
+	applyProfiles(cfg)
 	if cfg.UserStage == "" {
 		cfg.UserStage = "~"
 	}
 	if cfg.MaxThread == 0 {
 		cfg.MaxThread = 1
 	}
-	if cfg.SourceSplitKey != "" && cfg.SourceSplitTimeKey != "" {
-		panic("cannot set both sourceSplitKey and sourceSplitTimeKey")
+	if cfg.TempDir != "" {
+		if err := os.MkdirAll(cfg.TempDir, 0o700); err != nil {
+			panic(fmt.Sprintf("tempDir: %v", err))
+		}
+	}
+	if cfg.TempMinFreeMB == 0 {
+		cfg.TempMinFreeMB = 100
+	}
+	if cfg.TempMinFreeMB < 0 {
+		panic("tempMinFreeMB must not be negative")
+	}
+	if cfg.JobMaxThread < 0 {
+		panic("jobMaxThread must not be negative")
+	}
+	if cfg.SourceRetryAttempts <= 0 {
+		cfg.SourceRetryAttempts = 5
+	}
+	switch cfg.InvalidDatePolicy {
+	case "", "null", "reject":
+	case "sentinel":
+		if cfg.InvalidDateSentinel == "" {
+			cfg.InvalidDateSentinel = "1970-01-01 00:00:00"
+		}
+	default:
+		panic(fmt.Sprintf("invalidDatePolicy must be null, sentinel or reject, got %q", cfg.InvalidDatePolicy))
+	}
+	if err := cfg.CheckPartialRun(); err != nil {
+		panic(err)
+	}
+	if _, err := cfg.SourceTLSConfig(); err != nil {
+		panic(fmt.Sprintf("source TLS: %v", err))
+	}
+	if _, err := cfg.DatabendTLSConfig(); err != nil {
+		panic(fmt.Sprintf("databend TLS: %v", err))
+	}
+	if cfg.DatabendTokenURL != "" && cfg.DatabendClientID == "" {
+		panic("must set databendClientID with databendTokenURL")
+	}
+	if cfg.DatabendProxy != "" {
+		proxy, err := url.Parse(cfg.DatabendProxy)
+		if err != nil || proxy.Host == "" {
+			panic(fmt.Sprintf("invalid databendProxy %q", cfg.DatabendProxy))
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			panic(fmt.Sprintf("databendProxy must be an http, https or socks5 url, got %q", cfg.DatabendProxy))
+		}
+	}
+	switch cfg.IngestMode {
+	case "":
+		cfg.IngestMode = "stage"
+	case "stage":
+	case "insert":
+		if len(cfg.StageEncryptionKeys) > 0 || cfg.VerifyStagedFiles || cfg.ArchiveManifestFile != "" {
+			panic("stageEncryptionKeys, verifyStagedFiles and archiveManifestFile need staged batches, they can't be used with ingestMode insert")
+		}
+	default:
+		panic(fmt.Sprintf("ingestMode must be stage or insert, got %q", cfg.IngestMode))
+	}
+	if cfg.InsertChunkBytes == 0 {
+		cfg.InsertChunkBytes = 4 << 20
+	}
+	if cfg.InsertChunkBytes < 0 {
+		panic("insertChunkBytes must not be negative")
+	}
+	if cfg.CopyMaxFiles < 0 {
+		panic("copyMaxFiles must not be negative")
+	}
+	if cfg.WarehouseCreditsPerHour < 0 || cfg.BudgetCredits < 0 || cfg.BudgetBytes < 0 {
+		panic("warehouseCreditsPerHour, budgetCredits and budgetBytes must not be negative")
+	}
+	if cfg.BudgetCredits > 0 && cfg.WarehouseCreditsPerHour == 0 {
+		panic("must set warehouseCreditsPerHour with budgetCredits")
+	}
+	switch cfg.BudgetAction {
+	case "":
+		cfg.BudgetAction = "pause"
+	case "pause", "abort":
+	default:
+		panic(fmt.Sprintf("budgetAction must be pause or abort, got %q", cfg.BudgetAction))
+	}
+	if cfg.WarehouseMaxQueries < 0 {
+		panic("warehouseMaxQueries must not be negative")
+	}
+	if cfg.WarehouseLoadQuery == "" {
+		cfg.WarehouseLoadQuery = "SELECT count(*) FROM system.processes WHERE command = 'Query'"
+	}
+	if cfg.WarehouseLoadInterval == "" {
+		cfg.WarehouseLoadInterval = "10s"
+	}
+	if d, err := time.ParseDuration(cfg.WarehouseLoadInterval); err != nil || d <= 0 {
+		panic(fmt.Sprintf("invalid warehouseLoadInterval %q", cfg.WarehouseLoadInterval))
+	}
+	if cfg.CopyGroupWait == "" {
+		cfg.CopyGroupWait = "1s"
+	}
+	if d, err := time.ParseDuration(cfg.CopyGroupWait); err != nil || d < 0 {
+		panic(fmt.Sprintf("invalid copyGroupWait %q", cfg.CopyGroupWait))
+	}
+	if cfg.DatabendDriver == "" {
+		cfg.DatabendDriver = "http"
+	}
+	if cfg.DatabendMaxConns < 0 {
+		panic("databendMaxConns must not be negative")
+	}
+	if cfg.SourceSSHHost != "" && (cfg.SourceSSHUser == "" || cfg.SourceSSHKeyFile == "") {
+		panic("must set sourceSSHUser and sourceSSHKeyFile with sourceSSHHost")
+	}
+	if cfg.SourceSSHHost != "" && cfg.SourceSSHKnownHosts == "" && !cfg.SourceInsecureSkipHostKeyCheck {
+		panic("must set sourceSSHKnownHosts with sourceSSHHost, or sourceInsecureSkipHostKeyCheck to not verify the bastion")
+	}
+	for column, trim := range cfg.CSVTrim {
+		switch trim {
+		case "both", "leading", "trailing":
+		default:
+			panic(fmt.Sprintf("csvTrim of %s must be both, leading or trailing, got %q", column, trim))
+		}
+	}
+	switch cfg.CSVHeaderPolicy {
+	case "", "suffix", "snake_case", "quote":
+	default:
+		panic(fmt.Sprintf("csvHeaderPolicy must be suffix, snake_case or quote, got %q", cfg.CSVHeaderPolicy))
+	}
+	switch cfg.FloatNotation {
+	case "", "auto", "plain":
+	default:
+		panic(fmt.Sprintf("floatNotation must be auto or plain, got %q", cfg.FloatNotation))
+	}
+	if cfg.FloatPrecision < 0 {
+		panic(fmt.Sprintf("floatPrecision must not be negative, got %d", cfg.FloatPrecision))
+	}
+	switch cfg.StageCompression {
+	case "", "none", "gzip":
+	default:
+		panic(fmt.Sprintf("stageCompression must be none or gzip, got %q", cfg.StageCompression))
+	}
+	if cfg.StatsLogInterval == "" {
+		cfg.StatsLogInterval = "1m"
+	}
+	if d, err := time.ParseDuration(cfg.StatsLogInterval); err != nil || d <= 0 {
+		panic(fmt.Sprintf("invalid statsLogInterval %q", cfg.StatsLogInterval))
+	}
+	if cfg.MaxRuntime != "" {
+		if d, err := time.ParseDuration(cfg.MaxRuntime); err != nil || d <= 0 {
+			panic(fmt.Sprintf("invalid maxRuntime %q", cfg.MaxRuntime))
+		}
+		if cfg.IsSliceSource() || cfg.DiffSync {
+			panic("maxRuntime needs sourceSplitKey, sourceSplitTimeKey or a file source, and can't be used with diffSync")
+		}
+	}
+	if cfg.CheckpointFile != "" {
+		if cfg.SourceSplitKey == "" || cfg.SourceSplitTimeKey != "" || cfg.IsFileSource() || cfg.IsSliceSource() || cfg.DiffSync {
+			panic("checkpointFile needs sourceSplitKey without sourceSplitTimeKey, and can't be used with diffSync")
+		}
+	}
+	if cfg.HeartbeatInterval == "" {
+		cfg.HeartbeatInterval = "10s"
+	}
+	if d, err := time.ParseDuration(cfg.HeartbeatInterval); err != nil || d <= 0 {
+		panic(fmt.Sprintf("invalid heartbeatInterval %q", cfg.HeartbeatInterval))
 	}
-	if cfg.SourceSplitKey == "" && cfg.SourceSplitTimeKey == "" {
-		panic("must set one of sourceSplitKey and sourceSplitTimeKey")
+	checkLogConfig(cfg)
+	if cfg.Autotune {
+		if cfg.SourceSplitKey == "" || cfg.SourceSplitTimeKey != "" {
+			panic("autotune needs sourceSplitKey without sourceSplitTimeKey")
+		}
+		if cfg.AutotuneTrial == "" {
+			cfg.AutotuneTrial = "20s"
+		}
+		if d, err := time.ParseDuration(cfg.AutotuneTrial); err != nil || d <= 0 {
+			panic(fmt.Sprintf("invalid autotuneTrial %q", cfg.AutotuneTrial))
+		}
+	}
+	if cfg.DiffSync {
+		if len(cfg.DiffSyncKeys) == 0 {
+			panic("must set diffSyncKeys with diffSync")
+		}
+		if cfg.IsFileSource() {
+			panic("diffSync is not supported by file sources")
+		}
+		if cfg.DeleteAfterSync {
+			// the next diff sync would delete the rows from the target too
+			panic("cannot set both diffSync and deleteAfterSync")
+		}
+	}
+	if cfg.SoftDeleteColumn != "" {
+		if len(cfg.SoftDeleteKeys) == 0 {
+			if cfg.SourceSplitKey == "" {
+				panic("must set softDeleteKeys or sourceSplitKey with softDeleteColumn")
+			}
+			cfg.SoftDeleteKeys = []string{cfg.SourceSplitKey}
+		}
+		switch cfg.SoftDeleteMode {
+		case "":
+			cfg.SoftDeleteMode = "delete"
+		case "delete", "flag":
+		default:
+			panic(fmt.Sprintf("softDeleteMode must be delete or flag, got %q", cfg.SoftDeleteMode))
+		}
+		if cfg.DeleteAfterSync {
+			// incremental runs skip the count check deleteAfterSync relies on
+			panic("cannot set both softDeleteColumn and deleteAfterSync")
+		}
+	}
+	for _, h := range cfg.Hooks {
+		if err := checkHook(h); err != nil {
+			panic(err.Error())
+		}
+	}
+	if cfg.HookTimeout == "" {
+		cfg.HookTimeout = "30s"
+	}
+	if d, err := time.ParseDuration(cfg.HookTimeout); err != nil || d <= 0 {
+		panic(fmt.Sprintf("invalid hookTimeout %q", cfg.HookTimeout))
+	}
+	if cfg.OpenLineageURL != "" {
+		if u, err := url.Parse(cfg.OpenLineageURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			panic(fmt.Sprintf("openLineageURL must be http:// or https://, got %q", cfg.OpenLineageURL))
+		}
+		if cfg.OpenLineageNamespace == "" {
+			cfg.OpenLineageNamespace = "bend-archiver"
+		}
+	}
+	if cfg.DbtSourcesFile != "" && cfg.DbtLoadedAtField == "" && slices.Contains(cfg.MetadataColumns, "archived_at") {
+		cfg.DbtLoadedAtField = "archived_at"
+	}
+	for _, period := range []string{cfg.DbtFreshnessWarnAfter, cfg.DbtFreshnessErrorAfter} {
+		if period == "" {
+			continue
+		}
+		if _, err := ParsePeriod(period); err != nil {
+			panic(fmt.Sprintf("invalid dbt freshness: %v", err))
+		}
+		if cfg.DbtSourcesFile == "" || cfg.DbtLoadedAtField == "" {
+			panic("dbtFreshnessWarnAfter and dbtFreshnessErrorAfter need dbtSourcesFile and dbtLoadedAtField (or archived_at in metadataColumns)")
+		}
+	}
+	if cfg.SlowReadThreshold != "" {
+		if d, err := time.ParseDuration(cfg.SlowReadThreshold); err != nil || d <= 0 {
+			panic(fmt.Sprintf("invalid slowReadThreshold %q", cfg.SlowReadThreshold))
+		}
+	}
+	if cfg.SlowReadFactor != 0 && cfg.SlowReadFactor <= 1 {
+		panic("slowReadFactor must be greater than 1")
+	}
+	if cfg.SlowReadAlertURL != "" && cfg.SlowReadThreshold == "" && cfg.SlowReadFactor == 0 {
+		panic("must set slowReadThreshold or slowReadFactor with slowReadAlertURL")
+	}
+	for _, column := range cfg.MetadataColumns {
+		switch column {
+		case "archive_run_id", "archived_at", "source_host", "source_table":
+		default:
+			panic(fmt.Sprintf("metadataColumns must be archive_run_id, archived_at, source_host or source_table, got %q", column))
+		}
+	}
+	for _, r := range cfg.QualityRules {
+		if err := checkQualityRule(r); err != nil {
+			panic(err.Error())
+		}
+	}
+	if cfg.QualitySamplePercent < 0 || cfg.QualitySamplePercent > 100 {
+		panic("qualitySamplePercent must be between 0 and 100")
+	}
+	if len(cfg.QualityRules) > 0 && cfg.QualitySamplePercent == 0 {
+		cfg.QualitySamplePercent = 100
+	}
+	for _, p := range cfg.RetentionPolicies {
+		if err := checkRetentionPolicy(p); err != nil {
+			panic(err.Error())
+		}
+	}
+	if cfg.RetentionInterval != "" {
+		if d, err := time.ParseDuration(cfg.RetentionInterval); err != nil || d <= 0 {
+			panic(fmt.Sprintf("invalid retentionInterval %q", cfg.RetentionInterval))
+		}
+	}
+	for _, keyFile := range cfg.StageEncryptionKeys {
+		if _, err := os.Stat(keyFile); err != nil {
+			panic(fmt.Sprintf("stage encryption key: %v", err))
+		}
+	}
+	if (len(cfg.StageEncryptionKeys) > 0) != (strings.Trim(cfg.StageEncryptionPath, "/") != "") {
+		// the copies are kept apart from the batches COPY loads and purges
+		panic("must set both stageEncryptionKeys and stageEncryptionPath")
+	}
+	if len(cfg.StageEncryptionKeys) > 0 {
+		if strings.Contains(cfg.StageEncryptionPath, "..") {
+			panic(fmt.Sprintf("invalid stageEncryptionPath %q", cfg.StageEncryptionPath))
+		}
+		if _, err := exec.LookPath("gpg"); err != nil {
+			panic("stageEncryptionKeys needs the gpg executable: " + err.Error())
+		}
+	}
+	if cfg.SourceQueryTimeout != "" {
+		if d, err := time.ParseDuration(cfg.SourceQueryTimeout); err != nil || d <= 0 {
+			panic(fmt.Sprintf("invalid sourceQueryTimeout %q", cfg.SourceQueryTimeout))
+		}
+	}
+	if cfg.StopGracePeriod == "" {
+		// within the 30s notice of a spot instance
+		cfg.StopGracePeriod = "25s"
+	}
+	if d, err := time.ParseDuration(cfg.StopGracePeriod); err != nil || d < 0 {
+		panic(fmt.Sprintf("invalid stopGracePeriod %q", cfg.StopGracePeriod))
+	}
+	if cfg.SerializeThreads < 0 || cfg.UploadThreads < 0 {
+		panic("serializeThreads and uploadThreads must not be negative")
+	}
+	if cfg.RetryBudget < 0 {
+		panic("retryBudget must not be negative")
+	}
+	if cfg.RetryBudgetTime != "" {
+		if d, err := time.ParseDuration(cfg.RetryBudgetTime); err != nil || d <= 0 {
+			panic(fmt.Sprintf("invalid retryBudgetTime %q", cfg.RetryBudgetTime))
+		}
+	}
+	if cfg.PurgeChunkRows < 0 {
+		panic("purgeChunkRows must not be negative")
+	}
+	if cfg.PurgeChunkPause != "" {
+		if d, err := time.ParseDuration(cfg.PurgeChunkPause); err != nil || d < 0 {
+			panic(fmt.Sprintf("invalid purgeChunkPause %q", cfg.PurgeChunkPause))
+		}
+	}
+	if cfg.MaxBatchBytes < 0 {
+		panic("maxBatchBytes must not be negative")
+	}
+	if cfg.MaxBatchBytes > 0 && cfg.MaxRowSize > cfg.MaxBatchBytes {
+		panic("maxRowSize must not exceed maxBatchBytes")
+	}
+	switch cfg.OversizedRowPolicy {
+	case "", "fail", "truncate":
+	case "deadletter":
+		if cfg.DeadLetterFile == "" {
+			panic("must set deadLetterFile when oversizedRowPolicy is deadletter")
+		}
+	default:
+		panic(fmt.Sprintf("oversizedRowPolicy must be fail, truncate or deadletter, got %q", cfg.OversizedRowPolicy))
+	}
+	switch cfg.DatabaseType {
+	case "http":
+		if len(cfg.SourceURLs) == 0 {
+			panic("must set sourceURLs when databaseType is http")
+		}
+	case "gsheets":
+		if len(cfg.SheetIDs) == 0 {
+			panic("must set sheetIDs when databaseType is gsheets")
+		}
+	case "influxdb":
+		if cfg.InfluxURL == "" || cfg.InfluxStart == "" {
+			panic("must set influxURL and influxStart when databaseType is influxdb")
+		}
+		if cfg.InfluxStop == "" {
+			// every source of the job reads, counts and purges the same range
+			cfg.InfluxStop = time.Now().UTC().Format(time.RFC3339)
+		}
+	case "file", "sftp", "ftp":
+		if cfg.SourcePath == "" {
+			panic("must set sourcePath for file sources")
+		}
+		if cfg.DatabaseType == "sftp" && cfg.SourceKnownHosts == "" && !cfg.SourceInsecureSkipHostKeyCheck {
+			panic("must set sourceKnownHosts for sftp, or sourceInsecureSkipHostKeyCheck to not verify the host")
+		}
+	case "stdin":
+		if cfg.SourceFormat == "" {
+			cfg.SourceFormat = "csv"
+		}
+		if cfg.SourceFormat != "csv" && cfg.SourceFormat != "ndjson" {
+			panic(fmt.Sprintf("sourceFormat of stdin must be csv or ndjson, got %q", cfg.SourceFormat))
+		}
+		if cfg.ManifestFile != "" {
+			panic("manifestFile is not supported with stdin, a stream can't be read twice")
+		}
+	case "bench":
+		if cfg.BenchRows == 0 {
+			cfg.BenchRows = 1000000
+		}
+		if cfg.BenchRows < 0 {
+			panic("benchRows must be positive")
+		}
+	}
+	if cfg.IsFileSource() && (cfg.DeleteAfterSync || cfg.MoveAfterSync != "") && cfg.ManifestFile == "" {
+		// the manifest records the hash and rows of every archived file,
+		// without it what was purged can't be checked against the target
+		panic("must set manifestFile with deleteAfterSync or moveAfterSync for file sources")
+	}
+	if readOnlyDatabaseTypes[cfg.DatabaseType] && (cfg.DeleteAfterSync || cfg.MoveAfterSync != "") {
+		panic(fmt.Sprintf("deleteAfterSync and moveAfterSync are not supported by the %s source", cfg.DatabaseType))
+	}
+	for option := range cfg.TargetTableOptions {
+		if !tableOptionName.MatchString(option) {
+			panic(fmt.Sprintf("invalid targetTableOptions name %q", option))
+		}
+		if strings.EqualFold(option, "bloom_index_columns") && len(cfg.TargetBloomIndexColumns) > 0 {
+			panic("cannot set both targetBloomIndexColumns and the bloom_index_columns of targetTableOptions")
+		}
+	}
+	for name, columns := range cfg.TargetInvertedIndexes {
+		if !tableOptionName.MatchString(name) || len(columns) == 0 {
+			panic(fmt.Sprintf("invalid targetInvertedIndexes %q, it needs a name of letters and underscores and columns", name))
+		}
+	}
+	for _, operation := range cfg.PurgeMaintenance {
+		if !cfg.DeleteAfterSync {
+			panic("must set deleteAfterSync with purgeMaintenance")
+		}
+		if !maintenanceOperations[cfg.DatabaseType][operation] {
+			panic(fmt.Sprintf("purgeMaintenance %q is not supported by the %q source", operation, cfg.DatabaseType))
+		}
+	}
+	if cfg.SourceSelect != "" {
+		switch cfg.DatabaseType {
+		case "", "mysql", "tidb", "pg", "snowflake":
+		default:
+			panic(fmt.Sprintf("sourceSelect is not supported by the %s source", cfg.DatabaseType))
+		}
+		if cfg.DeleteAfterSync || cfg.DiffSync || len(cfg.SourceDbTables) > 0 {
+			panic("sourceSelect can't be used with deleteAfterSync, diffSync or sourceDbTables")
+		}
+		if cfg.SourceTable == "" {
+			// names the result set in logs and stats
+			cfg.SourceTable = "source_select"
+		}
+	}
+	if cfg.SourceBookmark && !cfg.SourceSnapshot {
+		// the ranges of a split key are read at different times
+		panic("sourceBookmark needs sourceSnapshot")
+	}
+	if cfg.SourceBookmarkLock && !cfg.SourceBookmark {
+		panic("must set sourceBookmark with sourceBookmarkLock")
+	}
+	if len(cfg.SourceChildTables) > 0 {
+		if err := checkChildTables(cfg); err != nil {
+			panic(err.Error())
+		}
+	}
+	if cfg.IsFileSource() || cfg.IsSliceSource() {
+		if cfg.BatchSize <= 0 {
+			cfg.BatchSize = 1000
+		}
+		return
+	}
+	if cfg.SourceSnapshot {
+		switch cfg.DatabaseType {
+		case "", "mysql", "tidb", "pg":
+		default:
+			panic(fmt.Sprintf("sourceSnapshot is not supported by the %s source", cfg.DatabaseType))
+		}
+		if cfg.SourceSplitKey != "" || cfg.SourceSplitTimeKey != "" {
+			panic("sourceSnapshot reads without a split key, unset sourceSplitKey and sourceSplitTimeKey")
+		}
+		if cfg.DeleteAfterSync || cfg.DiffSync || cfg.MaxRuntime != "" {
+			panic("sourceSnapshot can't be used with deleteAfterSync, diffSync or maxRuntime, a snapshot can't be resumed")
+		}
+		if cfg.BatchSize <= 0 {
+			cfg.BatchSize = 1000
+		}
+		if cfg.SourceWhereCondition == "" {
+			// the source is counted with it
+			cfg.SourceWhereCondition = "1=1"
+		}
+		if cfg.SourceBookmark && cfg.DatabaseType == "pg" {
+			panic("sourceBookmark is only supported by the mysql and tidb sources")
+		}
+	} else if cfg.SourceSplitKey == "" && cfg.SourceSplitTimeKey == "" {
+		panic("must set one of sourceSplitKey and sourceSplitTimeKey, or sourceSnapshot")
 	}
 	if cfg.SourceSplitTimeKey != "" || cfg.SourceSplitKey != "" {
 		if cfg.SourceWhereCondition == "" {
@@ -121,6 +1063,68 @@ func preCheckConfig(cfg *Config) {
 			panic(err)
 		}
 	}
+	cfg.JobID = jobID(cfg)
+	if cfg.RunID == "" {
+		cfg.RunID = newRunID()
+	}
+}
+
+var fileDatabaseTypes = map[string]bool{
+	"file":    true,
+	"stdin":   true,
+	"sftp":    true,
+	"ftp":     true,
+	"http":    true,
+	"gsheets": true,
+	"bench":   true,
+}
+
+// readOnlyDatabaseTypes can not delete or move what has been archived.
+var readOnlyDatabaseTypes = map[string]bool{
+	"http":    true,
+	"gsheets": true,
+	"bench":   true,
+	"stdin":   true,
+}
+
+var tableOptionName = regexp.MustCompile(`^[A-Za-z_]+$`)
+
+// maintenanceOperations are the purgeMaintenance operations of each source:
+// optimize rebuilds the table (OPTIMIZE TABLE, VACUUM FULL or ALTER INDEX ALL
+// REBUILD), vacuum marks the space of the purged rows reusable and analyze
+// refreshes the statistics.
+var maintenanceOperations = map[string]map[string]bool{
+	"":      {"optimize": true, "analyze": true},
+	"mysql": {"optimize": true, "analyze": true},
+	"tidb":  {"analyze": true},
+	"pg":    {"optimize": true, "vacuum": true, "analyze": true},
+	"mssql": {"optimize": true, "analyze": true},
+}
+
+// IsFileSource reports whether the source reads files instead of database tables.
+func (c *Config) IsFileSource() bool {
+	return fileDatabaseTypes[c.DatabaseType]
+}
+
+var sliceDatabaseTypes = map[string]bool{
+	"elasticsearch": true,
+	"cassandra":     true,
+	"scylladb":      true,
+	"influxdb":      true,
+	"hive":          true,
+	"bench":         true,
+}
+
+// IsSliceSource reports whether the source splits tables into slices on its
+// own, so no split key is needed.
+func (c *Config) IsSliceSource() bool {
+	return sliceDatabaseTypes[c.DatabaseType]
+}
+
+// TargetTable returns the Databend table for the given source table, the
+// {table} placeholder in DatabendTable is replaced by the table name.
+func (c *Config) TargetTable(table string) string {
+	return strings.ReplaceAll(c.DatabendTable, "{table}", table)
 }
 
 func validateSourceSplitTimeKey(value string) error {
@@ -144,6 +1148,53 @@ func checkTimeSplitUnit(unit string) error {
 	return nil
 }
 
+// LogComponents are the components whose logs logLevels may set the level
+// of.
+var LogComponents = []string{"source", "worker", "ingester"}
+
+// checkLogConfig checks the log settings and sets the defaults of the
+// rotation of logFile.
+func checkLogConfig(cfg *Config) {
+	if cfg.LogMaxSize < 0 || cfg.LogMaxBackups < 0 {
+		panic("logMaxSize and logMaxBackups must not be negative")
+	}
+	if cfg.LogFile != "" {
+		if cfg.LogMaxSize == 0 {
+			cfg.LogMaxSize = 100
+		}
+		if cfg.LogMaxBackups == 0 {
+			cfg.LogMaxBackups = 7
+		}
+	}
+	if cfg.LogRotateInterval != "" {
+		if cfg.LogFile == "" {
+			panic("logRotateInterval needs logFile")
+		}
+		if d, err := time.ParseDuration(cfg.LogRotateInterval); err != nil || d <= 0 {
+			panic(fmt.Sprintf("invalid logRotateInterval %q", cfg.LogRotateInterval))
+		}
+	}
+	if cfg.LogSyslog != "" && cfg.LogSyslog != "local" {
+		u, err := url.Parse(cfg.LogSyslog)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			panic(fmt.Sprintf("logSyslog must be local, udp://host:port or tcp://host:port, got %q", cfg.LogSyslog))
+		}
+	}
+	if cfg.LogLevel != "" {
+		if _, err := logrus.ParseLevel(cfg.LogLevel); err != nil {
+			panic(fmt.Sprintf("invalid logLevel %q", cfg.LogLevel))
+		}
+	}
+	for component, level := range cfg.LogLevels {
+		if !slices.Contains(LogComponents, component) {
+			panic(fmt.Sprintf("logLevels has unknown component %q, it should be one of %s", component, strings.Join(LogComponents, ", ")))
+		}
+		if _, err := logrus.ParseLevel(level); err != nil {
+			panic(fmt.Sprintf("invalid logLevels level %q of %s", level, component))
+		}
+	}
+}
+
 func (c *Config) GetTimeRangeBySplitUnit() time.Duration {
 	switch StringToTimeSplitUnit[c.TimeSplitUnit] {
 	case Minute:
@@ -158,3 +1209,33 @@ func (c *Config) GetTimeRangeBySplitUnit() time.Duration {
 		return 0
 	}
 }
+
+// IsPartialRun reports whether only part of the source is archived, a sample
+// or a range of rows.
+func (c *Config) IsPartialRun() bool {
+	return c.SampleRows > 0 || c.SamplePercent > 0 || c.MaxRows > 0 || c.StartFromRow > 0 || c.StartFromKey != ""
+}
+
+// HasBudget reports whether the cost of a run is bounded by budgetCredits or
+// budgetBytes.
+func (c *Config) HasBudget() bool {
+	return c.BudgetCredits > 0 || c.BudgetBytes > 0
+}
+
+// CheckPartialRun rejects sampling and row range options that would drop or
+// move source data that was never archived.
+func (c *Config) CheckPartialRun() error {
+	if c.SampleRows < 0 || c.MaxRows < 0 || c.StartFromRow < 0 || c.SamplePercent < 0 || c.SamplePercent > 100 {
+		return fmt.Errorf("sampleRows, maxRows and startFromRow must be positive and samplePercent between 0 and 100")
+	}
+	if c.IsPartialRun() && (c.DeleteAfterSync || c.MoveAfterSync != "") {
+		return fmt.Errorf("deleteAfterSync and moveAfterSync can not be used when archiving part of the source")
+	}
+	if c.IsPartialRun() && c.CheckpointFile != "" {
+		return fmt.Errorf("checkpointFile can not be used when archiving part of the source")
+	}
+	if c.StartFromKey != "" && (c.IsFileSource() || c.IsSliceSource()) {
+		return fmt.Errorf("startFromKey needs sourceSplitKey or sourceSplitTimeKey")
+	}
+	return nil
+}
@@ -102,3 +102,167 @@ func TestGetTimeRangeBySplitUnit(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckRetentionPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetentionPolicy
+		wantErr bool
+	}{
+		{
+			name:   "Rows of a table",
+			policy: RetentionPolicy{Table: "archive.orders", TimeColumn: "created_at", Period: "90d"},
+		},
+		{
+			name:   "Periodic tables",
+			policy: RetentionPolicy{Database: "archive", TablePattern: `^orders_(\d{6})$`, TableTimeLayout: "200601", Period: "8760h"},
+		},
+		{
+			name:    "Table without time column",
+			policy:  RetentionPolicy{Table: "archive.orders", Period: "90d"},
+			wantErr: true,
+		},
+		{
+			name:    "Pattern without group",
+			policy:  RetentionPolicy{Database: "archive", TablePattern: `^orders_\d{6}$`, TableTimeLayout: "200601", Period: "90d"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid period",
+			policy:  RetentionPolicy{Table: "archive.orders", TimeColumn: "created_at", Period: "3 months"},
+			wantErr: true,
+		},
+		{
+			name:    "Neither table nor pattern",
+			policy:  RetentionPolicy{Period: "90d"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRetentionPolicy(tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkRetentionPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	period, err := RetentionPolicy{Period: "90d"}.RetentionPeriod()
+	if err != nil || period != 90*24*time.Hour {
+		t.Errorf("RetentionPeriod() = %v, %v", period, err)
+	}
+}
+
+func TestCheckLogConfig(t *testing.T) {
+	cfg := &Config{LogFile: "job.log", LogLevels: map[string]string{"source": "debug"}}
+	checkLogConfig(cfg)
+	if cfg.LogMaxSize != 100 || cfg.LogMaxBackups != 7 {
+		t.Errorf("logMaxSize = %d, logMaxBackups = %d, want 100 and 7", cfg.LogMaxSize, cfg.LogMaxBackups)
+	}
+
+	tests := []struct {
+		name      string
+		cfg       Config
+		wantPanic bool
+	}{
+		{name: "Syslog server", cfg: Config{LogSyslog: "udp://syslog.example.com:514"}},
+		{name: "Rotation without file", cfg: Config{LogRotateInterval: "24h"}, wantPanic: true},
+		{name: "Syslog without scheme", cfg: Config{LogSyslog: "syslog.example.com:514"}, wantPanic: true},
+		{name: "Invalid level", cfg: Config{LogLevel: "loud"}, wantPanic: true},
+		{name: "Unknown component", cfg: Config{LogLevels: map[string]string{"cmd": "debug"}}, wantPanic: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tt.wantPanic {
+					t.Errorf("checkLogConfig() panic = %v, wantPanic %v", r, tt.wantPanic)
+				}
+			}()
+			checkLogConfig(&tt.cfg)
+		})
+	}
+}
+
+func TestCheckHook(t *testing.T) {
+	tests := []struct {
+		name    string
+		hook    Hook
+		wantErr bool
+	}{
+		{name: "Command", hook: Hook{Events: []string{"job_end"}, Command: []string{"/opt/hooks/ticket.sh"}}},
+		{name: "Webhook of every event", hook: Hook{URL: "https://lineage.example.com/events"}},
+		{name: "Nothing to run", hook: Hook{Events: []string{"job_end"}}, wantErr: true},
+		{name: "Unknown event", hook: Hook{Events: []string{"table_start"}, URL: "https://lineage.example.com/events"}, wantErr: true},
+		{name: "Not http", hook: Hook{URL: "lineage.example.com/events"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkHook(tt.hook); (err != nil) != tt.wantErr {
+				t.Errorf("checkHook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChildTables(t *testing.T) {
+	cfg := &Config{
+		SourceDB:             "shop",
+		SourceTable:          "orders",
+		SourceSplitKey:       "id",
+		SourceWhereCondition: "created_at < '2024-01-01'",
+		DatabendTable:        "archive.orders",
+		DeleteAfterSync:      true,
+		SourceChildTables: []ChildTable{
+			{Table: "order_items", ForeignKey: "order_id", SplitKey: "id"},
+			{Table: "item_options", ForeignKey: "item_id", Parent: "order_items", ParentKey: "id", DatabendTable: "archive.options"},
+		},
+	}
+	if err := checkChildTables(cfg); err != nil {
+		t.Fatalf("checkChildTables() error = %v", err)
+	}
+
+	items := cfg.ChildConfig(0)
+	want := "order_id IN (SELECT id FROM shop.orders WHERE created_at < '2024-01-01')"
+	if items.SourceWhereCondition != want || items.DatabendTable != "archive.order_items" || items.SourceSnapshot {
+		t.Errorf("ChildConfig(0) = %q into %s, snapshot %v", items.SourceWhereCondition, items.DatabendTable, items.SourceSnapshot)
+	}
+	options := cfg.ChildConfig(1)
+	want = "item_id IN (SELECT id FROM shop.order_items WHERE " + want + ")"
+	if options.SourceWhereCondition != want || options.DatabendTable != "archive.options" || !options.SourceSnapshot || !options.DeleteAfterSync {
+		t.Errorf("ChildConfig(1) = %q into %s, snapshot %v", options.SourceWhereCondition, options.DatabendTable, options.SourceSnapshot)
+	}
+	if cfg.SourceTable != "orders" || len(options.SourceChildTables) != 0 {
+		t.Errorf("ChildConfig changed the parent config or kept the child tables")
+	}
+
+	tests := []struct {
+		name  string
+		cfg   Config
+		child ChildTable
+	}{
+		{name: "Unknown parent", cfg: Config{SourceTable: "orders", SourceSplitKey: "id"}, child: ChildTable{Table: "order_items", ForeignKey: "order_id", Parent: "customers"}},
+		{name: "No parent key", cfg: Config{SourceTable: "orders", SourceSplitTimeKey: "created_at"}, child: ChildTable{Table: "order_items", ForeignKey: "order_id"}},
+		{name: "No foreign key", cfg: Config{SourceTable: "orders", SourceSplitKey: "id"}, child: ChildTable{Table: "order_items"}},
+		{name: "Partial run", cfg: Config{SourceTable: "orders", SourceSplitKey: "id", MaxRows: 10}, child: ChildTable{Table: "order_items", ForeignKey: "order_id"}},
+		{name: "Unsupported source", cfg: Config{DatabaseType: "mssql", SourceTable: "orders", SourceSplitKey: "id"}, child: ChildTable{Table: "order_items", ForeignKey: "order_id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.SourceChildTables = []ChildTable{tt.child}
+			if err := checkChildTables(&tt.cfg); err == nil {
+				t.Errorf("checkChildTables() accepted %+v", tt.child)
+			}
+		})
+	}
+}
+
+func TestInfluxStop(t *testing.T) {
+	cfg := &Config{DatabaseType: "influxdb", InfluxURL: "http://influxdb:8086", InfluxStart: "2024-01-01T00:00:00Z",
+		SourceDB: "telegraf", SourceTable: "cpu", DatabendTable: "archive.cpu"}
+	preCheckConfig(cfg)
+	stop, err := time.Parse(time.RFC3339, cfg.InfluxStop)
+	if err != nil || time.Since(stop) > time.Minute {
+		t.Errorf("influxStop = %q, want the start of the job", cfg.InfluxStop)
+	}
+}
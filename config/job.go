@@ -0,0 +1,32 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// jobID is a hash of the settings of cfg. startFromKey is left out, so that
+// a resumed run belongs to the same job.
+func jobID(cfg *Config) string {
+	c := *cfg
+	c.StartFromKey, c.JobID, c.RunID = "", "", ""
+	data, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// newRunID is the start time of the run with a random suffix, so that runs
+// started in the same second differ too.
+func newRunID() string {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		panic(err)
+	}
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix)
+}
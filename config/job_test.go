@@ -0,0 +1,37 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJobID(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "conf.json")
+	load := func(content string) *Config {
+		if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadConfig(configFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+	first := load(`{"databaseType": "mysql", "sourceSplitKey": "id", "sourceWhereCondition": "1=1", "databendTable": "archive.orders"}`)
+	again := load(`{"databaseType": "mysql", "sourceSplitKey": "id", "sourceWhereCondition": "1=1", "databendTable": "archive.orders"}`)
+	if first.JobID == "" || first.JobID != again.JobID {
+		t.Errorf("JobID = %q then %q, want the same id", first.JobID, again.JobID)
+	}
+	if first.RunID == "" || first.RunID == again.RunID {
+		t.Errorf("RunID = %q then %q, want different ids", first.RunID, again.RunID)
+	}
+	resumed := load(`{"databaseType": "mysql", "sourceSplitKey": "id", "sourceWhereCondition": "1=1", "databendTable": "archive.orders", "startFromKey": "1000"}`)
+	if resumed.JobID != first.JobID {
+		t.Errorf("JobID of a resumed run = %q, want %q", resumed.JobID, first.JobID)
+	}
+	other := load(`{"databaseType": "mysql", "sourceSplitKey": "id", "sourceWhereCondition": "1=1", "databendTable": "archive.orders_v2"}`)
+	if other.JobID == first.JobID {
+		t.Errorf("JobID of another job = %q, want a different id", other.JobID)
+	}
+}
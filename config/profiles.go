@@ -0,0 +1,101 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// CredentialProfile is a named source or Databend target with its
+// credentials, shared by the jobs of several teams. A job picks one with
+// sourceProfile or targetProfile, and may only pick the profiles of its
+// tenant.
+type CredentialProfile struct {
+	Tenant string `json:"tenant"` // team whose jobs may use the profile, every job when empty
+
+	// source, for sourceProfile
+	SourceHost string `json:"sourceHost"`
+	SourcePort int    `json:"sourcePort"`
+	SourceUser string `json:"sourceUser"`
+	SourcePass string `json:"sourcePass"`
+	SSLMode    string `json:"sslMode"`
+
+	// target, for targetProfile
+	DatabendDSN          string `json:"databendDSN"`
+	DatabendWarehouse    string `json:"databendWarehouse"`
+	DatabendRole         string `json:"databendRole"`
+	DatabendTokenURL     string `json:"databendTokenURL"`
+	DatabendClientID     string `json:"databendClientID"`
+	DatabendClientSecret string `json:"databendClientSecret"`
+}
+
+// applyProfiles sets the source and target settings of cfg from its
+// sourceProfile and targetProfile, they can't be set by the job too. The
+// profiles are dropped from cfg then, a job only keeps the credentials it
+// uses.
+func applyProfiles(cfg *Config) {
+	profiles := make(map[string]CredentialProfile)
+	if cfg.ProfilesFile != "" {
+		data, err := os.ReadFile(cfg.ProfilesFile)
+		if err != nil {
+			panic(fmt.Sprintf("read profilesFile: %v", err))
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&profiles); err != nil {
+			panic(fmt.Sprintf("invalid profilesFile %s: %v", cfg.ProfilesFile, err))
+		}
+	}
+	for name, p := range cfg.Profiles {
+		if _, ok := profiles[name]; ok {
+			panic(fmt.Sprintf("profile %q is defined in both profiles and profilesFile", name))
+		}
+		profiles[name] = p
+	}
+	applyProfile(cfg, profiles, "sourceProfile", cfg.SourceProfile, "Source", "SSLMode")
+	applyProfile(cfg, profiles, "targetProfile", cfg.TargetProfile, "Databend")
+	cfg.Profiles, cfg.ProfilesFile = nil, ""
+}
+
+// applyProfile copies the fields of profile name starting with one of
+// prefixes to the settings of cfg of the same name.
+func applyProfile(cfg *Config, profiles map[string]CredentialProfile, setting, name string, prefixes ...string) {
+	if name == "" {
+		return
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		panic(fmt.Sprintf("%s %q is not defined in profiles or profilesFile", setting, name))
+	}
+	if profile.Tenant != "" && profile.Tenant != cfg.Tenant {
+		panic(fmt.Sprintf("%s %q belongs to tenant %q, the job runs for tenant %q", setting, name, profile.Tenant, cfg.Tenant))
+	}
+	src, dst := reflect.ValueOf(profile), reflect.ValueOf(cfg).Elem()
+	copied := 0
+	for i := 0; i < src.NumField(); i++ {
+		field := src.Type().Field(i)
+		if !hasAnyPrefix(field.Name, prefixes) || src.Field(i).IsZero() {
+			continue
+		}
+		if !dst.FieldByName(field.Name).IsZero() {
+			panic(fmt.Sprintf("%s is set by %s %q, remove it from the job", field.Tag.Get("json"), setting, name))
+		}
+		dst.FieldByName(field.Name).Set(src.Field(i))
+		copied++
+	}
+	if copied == 0 {
+		panic(fmt.Sprintf("%s %q has no %s settings", setting, name, strings.TrimSuffix(setting, "Profile")))
+	}
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyProfiles(t *testing.T) {
+	profilesFile := filepath.Join(t.TempDir(), "profiles.json")
+	if err := os.WriteFile(profilesFile, []byte(`{
+		"payments-mysql": {"tenant": "payments", "sourceHost": "mysql.payments", "sourcePort": 3306, "sourceUser": "archiver", "sourcePass": "pw"},
+		"shared-databend": {"databendDSN": "databend://archiver:pw@databend:8000", "databendRole": "archiver"}
+	}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		Tenant:        "payments",
+		ProfilesFile:  profilesFile,
+		Profiles:      map[string]CredentialProfile{"search-mysql": {Tenant: "search", SourceHost: "mysql.search", SourceUser: "archiver"}},
+		SourceProfile: "payments-mysql",
+		TargetProfile: "shared-databend",
+		SourceDB:      "pay",
+	}
+	applyProfiles(cfg)
+	if cfg.SourceHost != "mysql.payments" || cfg.SourcePort != 3306 || cfg.SourcePass != "pw" || cfg.SourceDB != "pay" {
+		t.Errorf("source = %s:%d %s/%s %s", cfg.SourceHost, cfg.SourcePort, cfg.SourceUser, cfg.SourcePass, cfg.SourceDB)
+	}
+	if cfg.DatabendDSN != "databend://archiver:pw@databend:8000" || cfg.DatabendRole != "archiver" {
+		t.Errorf("target = %s as %s", cfg.DatabendDSN, cfg.DatabendRole)
+	}
+	if cfg.Profiles != nil || cfg.ProfilesFile != "" {
+		t.Errorf("the profiles of the other jobs are kept: %v %s", cfg.Profiles, cfg.ProfilesFile)
+	}
+
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "Unknown profile", cfg: Config{SourceProfile: "payments-mysql"}},
+		{name: "Profile of another tenant", cfg: Config{Tenant: "search", ProfilesFile: profilesFile, SourceProfile: "payments-mysql"}},
+		{name: "Profile of a tenant for a job without", cfg: Config{ProfilesFile: profilesFile, SourceProfile: "payments-mysql"}},
+		{name: "Setting of the profile set by the job", cfg: Config{Tenant: "payments", ProfilesFile: profilesFile, SourceProfile: "payments-mysql", SourcePass: "other"}},
+		{name: "Target profile as source", cfg: Config{ProfilesFile: profilesFile, SourceProfile: "shared-databend"}},
+		{name: "Defined twice", cfg: Config{ProfilesFile: profilesFile, Profiles: map[string]CredentialProfile{"shared-databend": {}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("applyProfiles() did not panic")
+				}
+			}()
+			applyProfiles(&tt.cfg)
+		})
+	}
+}
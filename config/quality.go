@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// QualityRule is a data quality rule checked on the rows sampled while they
+// are ingested, see qualitySamplePercent.
+type QualityRule struct {
+	Name        string   `json:"name"`        // name in the logs and the status file, default is type(columns)
+	Type        string   `json:"type"`        // not_null, unique, range or regex
+	Columns     []string `json:"columns"`     // checked columns, unique checks their combination
+	Min         *float64 `json:"min"`         // range: lowest value allowed
+	Max         *float64 `json:"max"`         // range: highest value allowed
+	Pattern     string   `json:"pattern"`     // regex: the values must match it
+	WarnPercent *float64 `json:"warnPercent"` // violations in more than this percentage of the checked rows are logged, default is 0
+	FailPercent *float64 `json:"failPercent"` // violations in more than this percentage of the checked rows fail the job, never when unset
+}
+
+func checkQualityRule(r QualityRule) error {
+	if len(r.Columns) == 0 {
+		return fmt.Errorf("quality rule %s needs columns", r.Type)
+	}
+	switch r.Type {
+	case "not_null", "unique":
+	case "range":
+		if r.Min == nil && r.Max == nil {
+			return fmt.Errorf("range quality rule of %v needs min or max", r.Columns)
+		}
+	case "regex":
+		if _, err := regexp.Compile(r.Pattern); err != nil || r.Pattern == "" {
+			return fmt.Errorf("regex quality rule of %v needs a valid pattern: %v", r.Columns, err)
+		}
+	default:
+		return fmt.Errorf("quality rule type must be not_null, unique, range or regex, got %q", r.Type)
+	}
+	for _, p := range []*float64{r.WarnPercent, r.FailPercent} {
+		if p != nil && (*p < 0 || *p > 100) {
+			return fmt.Errorf("quality rule %s of %v: percentages must be between 0 and 100", r.Type, r.Columns)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/databendcloud/bend-archiver/errcode"
+)
+
+// reloadableSettings are the settings the retention loop, the only long
+// running command, applies when its config file changes: its schedule and
+// policies. The others, like the source and target of the job, need a
+// restart.
+var reloadableSettings = map[string]bool{
+	"retentionPolicies": true,
+	"retentionInterval": true,
+}
+
+// Reload loads configFile again and applies its reloadable settings to cfg.
+// It returns the settings it applied, and the ones that changed but need a
+// restart, cfg keeps their current values. An invalid config file is an
+// error and leaves cfg as it is.
+func Reload(cfg *Config, configFile string) (applied, rejected []string, err error) {
+	next, err := ValidateConfigFile(configFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	cur, nv := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < cur.NumField(); i++ {
+		name := strings.Split(cur.Type().Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || reflect.DeepEqual(cur.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		if !reloadableSettings[name] {
+			rejected = append(rejected, name)
+			continue
+		}
+		cur.Field(i).Set(nv.Field(i))
+		applied = append(applied, name)
+	}
+	return applied, rejected, nil
+}
+
+// ValidateConfigFile loads configFile like LoadConfig, returning invalid
+// settings as an errcode.ErrConfigInvalid error instead of panicking.
+func ValidateConfigFile(configFile string) (*Config, error) {
+	return validateConfig(configFile, LoadConfig)
+}
+
+// ValidateStdinConfigFile is ValidateConfigFile for LoadStdinConfig.
+func ValidateStdinConfigFile(configFile, format string) (*Config, error) {
+	return validateConfig(configFile, func(configFile string) (*Config, error) {
+		return LoadStdinConfig(configFile, format)
+	})
+}
+
+func validateConfig(configFile string, load func(string) (*Config, error)) (cfg *Config, err error) {
+	defer func() {
+		// preCheckConfig panics on invalid settings
+		if r := recover(); r != nil {
+			cfg, err = nil, fmt.Errorf("%w %s: %v", errcode.ErrConfigInvalid, configFile, r)
+		}
+	}()
+	cfg, err = load(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %w", errcode.ErrConfigInvalid, configFile, err)
+	}
+	return cfg, nil
+}
+
+// FileWatcher notices changes of a file by its modification time and size.
+type FileWatcher struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func NewFileWatcher(path string) *FileWatcher {
+	fw := &FileWatcher{path: path}
+	fw.Changed()
+	return fw
+}
+
+// Changed reports whether the file changed since the last call.
+func (fw *FileWatcher) Changed() bool {
+	info, err := os.Stat(fw.path)
+	if err != nil {
+		// being replaced, e.g. by an editor or a config map update
+		return false
+	}
+	if info.ModTime().Equal(fw.modTime) && info.Size() == fw.size {
+		return false
+	}
+	fw.modTime, fw.size = info.ModTime(), info.Size()
+	return true
+}
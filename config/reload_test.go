@@ -0,0 +1,75 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "conf.json")
+	write := func(content string) {
+		if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"databaseType": "mysql", "sourceSplitKey": "id", "sourceWhereCondition": "1=1",
+		"databendTable": "archive.orders", "retentionInterval": "24h"}`)
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw := NewFileWatcher(configFile)
+	if fw.Changed() {
+		t.Errorf("Changed() = true before any change")
+	}
+
+	write(`{"databaseType": "mysql", "sourceSplitKey": "id", "sourceWhereCondition": "1=1",
+		"databendTable": "archive.orders_v2", "retentionInterval": "1h", "batchMaxInterval": 5}`)
+	// the modification time may not change within the resolution of the file system
+	os.Chtimes(configFile, time.Now(), time.Now().Add(time.Second))
+	if !fw.Changed() {
+		t.Errorf("Changed() = false after a change")
+	}
+	applied, rejected, err := Reload(cfg, configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(applied, []string{"retentionInterval"}) {
+		t.Errorf("applied = %v", applied)
+	}
+	if !reflect.DeepEqual(rejected, []string{"databendTable", "batchMaxInterval"}) {
+		t.Errorf("rejected = %v", rejected)
+	}
+	if cfg.RetentionInterval != "1h" || cfg.BatchMaxInterval == 5 || cfg.DatabendTable != "archive.orders" {
+		t.Errorf("reloaded config = %+v", cfg)
+	}
+
+	write(`{"databaseType": "mysql", "sourceWhereCondition": "1=1", "databendTable": "archive.orders"}`)
+	if _, _, err := Reload(cfg, configFile); err == nil {
+		t.Errorf("Reload() of an invalid config succeeded")
+	}
+	if cfg.RetentionInterval != "1h" {
+		t.Errorf("invalid reload changed the config")
+	}
+}
+
+func TestValidateStdinConfigFile(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "conf.json")
+	content := `{"databaseType": "mysql", "sourceTable": "orders", "databendTable": "archive.orders"}`
+	if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ValidateStdinConfigFile(configFile, "ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DatabaseType != "stdin" || cfg.SourceFormat != "ndjson" || !cfg.IsFileSource() || cfg.BatchSize != 1000 {
+		t.Errorf("stdin config = %+v", cfg)
+	}
+	if _, err := ValidateStdinConfigFile(configFile, "parquet"); err == nil {
+		t.Errorf("ValidateStdinConfigFile() accepted parquet")
+	}
+}
@@ -0,0 +1,206 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// schemaEnums are the values allowed for the items of the settings with a
+// fixed set of values, the keys of the maps for logLevels.
+var schemaEnums = map[string][]string{
+	"logLevels": LogComponents,
+	"events":    HookEvents,
+}
+
+// Schema is the JSON Schema of the config files: every setting with its
+// type, and no other. config.schema.json is its published copy.
+func Schema() map[string]interface{} {
+	schema := typeSchema("", reflect.TypeOf(Config{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["$id"] = "https://github.com/databendcloud/bend-archiver/config/config.schema.json"
+	schema["title"] = "bend-archiver config"
+	return schema
+}
+
+// SchemaJSON is Schema indented, as published in config.schema.json.
+func SchemaJSON() []byte {
+	data, err := json.MarshalIndent(Schema(), "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return append(data, '\n')
+}
+
+func typeSchema(name string, t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		items := typeSchema("", t.Elem())
+		if enum, ok := schemaEnums[name]; ok {
+			items["enum"] = enum
+		}
+		return map[string]interface{}{"type": "array", "items": items}
+	case reflect.Map:
+		schema := map[string]interface{}{"type": "object", "additionalProperties": typeSchema("", t.Elem())}
+		if enum, ok := schemaEnums[name]; ok {
+			schema["propertyNames"] = map[string]interface{}{"enum": enum}
+		}
+		return schema
+	case reflect.Pointer:
+		// optional, null when unset
+		schema := typeSchema(name, t.Elem())
+		schema["type"] = []string{schema["type"].(string), "null"}
+		return schema
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || key == "-" {
+				continue
+			}
+			if key == "" {
+				key = field.Name
+			}
+			// the settings are documented in lowerCamelCase, the loader
+			// matches keys case-insensitively, e.g. for SourceSplitTimeKey
+			key = strings.ToLower(key[:1]) + key[1:]
+			property := typeSchema(key, field.Type)
+			if def := field.Tag.Get("default"); def != "" {
+				property["default"] = def
+			}
+			properties[key] = property
+		}
+		return map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+	default:
+		panic(fmt.Sprintf("no JSON Schema for %s", t))
+	}
+}
+
+// ValidateDocument checks the config document data against Schema, it
+// returns the problems found with their path, e.g. hooks[0].evnts: unknown
+// setting.
+func ValidateDocument(data []byte) []string {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return []string{fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	var problems []string
+	validateValue(Schema(), doc, "", &problems)
+	return problems
+}
+
+func validateValue(schema map[string]interface{}, value interface{}, path string, problems *[]string) {
+	at := path
+	if at == "" {
+		at = "document"
+	}
+	problem := func(format string, args ...interface{}) {
+		*problems = append(*problems, at+": "+fmt.Sprintf(format, args...))
+	}
+	if enum, ok := schema["enum"].([]string); ok {
+		if s, ok := value.(string); ok && !slices.Contains(enum, s) {
+			problem("%q is not one of %s", s, strings.Join(enum, ", "))
+			return
+		}
+	}
+	kind := schema["type"]
+	if kinds, ok := kind.([]string); ok {
+		if value == nil && slices.Contains(kinds, "null") {
+			return
+		}
+		kind = kinds[0]
+	}
+	switch kind {
+	case "string":
+		if _, ok := value.(string); !ok {
+			problem("must be a string, got %s", jsonType(value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problem("must be true or false, got %s", jsonType(value))
+		}
+	case "integer":
+		if n, ok := value.(json.Number); !ok {
+			problem("must be an integer, got %s", jsonType(value))
+		} else if _, err := n.Int64(); err != nil {
+			problem("must be an integer, got %s", n)
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			problem("must be a number, got %s", jsonType(value))
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			problem("must be an array, got %s", jsonType(value))
+			return
+		}
+		for i, item := range items {
+			validateValue(schema["items"].(map[string]interface{}), item, fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			problem("must be an object, got %s", jsonType(value))
+			return
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		properties, _ := schema["properties"].(map[string]interface{})
+		for _, key := range keys {
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			if names, ok := schema["propertyNames"].(map[string]interface{}); ok && !slices.Contains(names["enum"].([]string), key) {
+				problem("unknown key %q, it should be one of %s", key, strings.Join(names["enum"].([]string), ", "))
+				continue
+			}
+			if property, ok := properties[key]; ok {
+				validateValue(property.(map[string]interface{}), object[key], keyPath, problems)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case map[string]interface{}:
+				validateValue(additional, object[key], keyPath, problems)
+			default:
+				*problems = append(*problems, keyPath+": unknown setting")
+			}
+		}
+	}
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case json.Number:
+		return "a number"
+	case []interface{}:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSchemaFile(t *testing.T) {
+	published, err := os.ReadFile("config.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(published, SchemaJSON()) {
+		t.Errorf("config.schema.json is out of date, run bend-archiver validate -schema > config/config.schema.json")
+	}
+}
+
+func TestValidateDocument(t *testing.T) {
+	examples, _ := filepath.Glob("*.json")
+	for _, example := range examples {
+		if example == "config.schema.json" {
+			continue
+		}
+		data, err := os.ReadFile(example)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if problems := ValidateDocument(data); len(problems) > 0 {
+			t.Errorf("%s: %s", example, strings.Join(problems, "; "))
+		}
+	}
+
+	tests := []struct {
+		name string
+		doc  string
+		want []string
+	}{
+		{name: "Valid", doc: `{"databaseType": "mysql", "batchSize": 1000, "logLevels": {"source": "debug"}, "qualityRules": [{"name": "r", "min": null}]}`},
+		{name: "Unknown setting", doc: `{"databaseTyp": "mysql"}`, want: []string{"databaseTyp: unknown setting"}},
+		{name: "Wrong type", doc: `{"batchSize": "1000", "deleteAfterSync": 1}`, want: []string{"batchSize: must be an integer, got a string", "deleteAfterSync: must be true or false, got a number"}},
+		{name: "Not an integer", doc: `{"batchSize": 1.5}`, want: []string{"batchSize: must be an integer, got 1.5"}},
+		{name: "Nested", doc: `{"hooks": [{"events": ["job_end", "job_done"], "urll": "x"}]}`, want: []string{`hooks[0].events[1]: "job_done" is not one of job_start, table_complete, batch_failed, job_end`, "hooks[0].urll: unknown setting"}},
+		{name: "Unknown key of a map", doc: `{"logLevels": {"cmd": "debug"}}`, want: []string{`logLevels: unknown key "cmd", it should be one of source, worker, ingester`}},
+		{name: "Not an object", doc: `[]`, want: []string{"document: must be an object, got an array"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ValidateDocument([]byte(tt.doc))
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("ValidateDocument() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// SourceTLSConfig is the TLS config of the MySQL source connection, nil when
+// no TLS option is set.
+func (c *Config) SourceTLSConfig() (*tls.Config, error) {
+	return loadTLSConfig(c.SourceTLSCA, c.SourceTLSCert, c.SourceTLSKey, c.SourceTLSSkipVerify)
+}
+
+// DatabendTLSConfig is the TLS config of the Databend connection, nil when no
+// TLS option is set.
+func (c *Config) DatabendTLSConfig() (*tls.Config, error) {
+	return loadTLSConfig(c.DatabendTLSCA, c.DatabendTLSCert, c.DatabendTLSKey, c.DatabendTLSSkipVerify)
+}
+
+// loadTLSConfig trusts the PEM CA bundle caFile on top of the system roots
+// and presents the client certificate certFile/keyFile for mTLS.
+func loadTLSConfig(caFile, certFile, keyFile string, skipVerify bool) (*tls.Config, error) {
+	if caFile == "" && certFile == "" && keyFile == "" && !skipVerify {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: skipVerify}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read CA bundle failed")
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in CA bundle %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("client certificate and key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate failed")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,103 @@
+// Package errcode defines the typed errors of a job. Each has a stable code,
+// surfaced in the logs, the status file and the exit code, so that
+// automation can react to failures without parsing messages.
+package errcode
+
+import "errors"
+
+// Code identifies a kind of failure, codes never change once released.
+type Code string
+
+const (
+	OK                Code = "OK"
+	Unknown           Code = "UNKNOWN"
+	ConfigInvalid     Code = "CONFIG_INVALID"
+	Partial           Code = "PARTIAL"
+	RetryBudget       Code = "RETRY_BUDGET_EXHAUSTED"
+	BudgetExceeded    Code = "BUDGET_EXCEEDED"
+	SourceUnavailable Code = "SOURCE_UNAVAILABLE"
+	SourceQuery       Code = "SOURCE_QUERY_FAILED"
+	SchemaMismatch    Code = "SCHEMA_MISMATCH"
+	TargetUnavailable Code = "TARGET_UNAVAILABLE"
+	StageUpload       Code = "STAGE_UPLOAD_FAILED"
+	CopyFailed        Code = "COPY_FAILED"
+	CountMismatch     Code = "COUNT_MISMATCH"
+	KeyConflict       Code = "KEY_CONFLICT"
+	Verification      Code = "VERIFICATION_FAILED"
+	PurgeRefused      Code = "PURGE_REFUSED"
+	PurgeFailed       Code = "PURGE_FAILED"
+)
+
+// exitCodes are the process exit codes of the codes, 1 is Unknown.
+var exitCodes = map[Code]int{
+	OK:                0,
+	Unknown:           1,
+	ConfigInvalid:     2,
+	Partial:           3,
+	RetryBudget:       4,
+	BudgetExceeded:    5,
+	SourceUnavailable: 10,
+	SourceQuery:       11,
+	SchemaMismatch:    12,
+	TargetUnavailable: 20,
+	StageUpload:       21,
+	CopyFailed:        22,
+	CountMismatch:     30,
+	KeyConflict:       31,
+	Verification:      32,
+	PurgeRefused:      40,
+	PurgeFailed:       41,
+}
+
+func (c Code) ExitCode() int {
+	if exitCode, ok := exitCodes[c]; ok {
+		return exitCode
+	}
+	return 1
+}
+
+// Error is an error with a code, use errors.Is with the Err variables to
+// tell them apart.
+type Error struct {
+	Code Code
+	msg  string
+}
+
+func New(code Code, msg string) *Error {
+	return &Error{Code: code, msg: msg}
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+var (
+	ErrConfigInvalid     = New(ConfigInvalid, "invalid config")
+	ErrPartial           = New(Partial, "job stopped before the end")
+	ErrRetryBudget       = New(RetryBudget, "retry budget exhausted")
+	ErrBudgetExceeded    = New(BudgetExceeded, "cost budget exceeded")
+	ErrSourceUnavailable = New(SourceUnavailable, "source unavailable")
+	ErrSourceQuery       = New(SourceQuery, "source query failed")
+	ErrSchemaMismatch    = New(SchemaMismatch, "schema mismatch")
+	ErrTargetUnavailable = New(TargetUnavailable, "databend unavailable")
+	ErrStageUpload       = New(StageUpload, "upload stage failed")
+	ErrCopyFailed        = New(CopyFailed, "copy into failed")
+	ErrCountMismatch     = New(CountMismatch, "source and target counts differ")
+	ErrKeyConflict       = New(KeyConflict, "duplicate keys in the target")
+	ErrVerification      = New(Verification, "verification query failed")
+	ErrPurgeRefused      = New(PurgeRefused, "purge refused")
+	ErrPurgeFailed       = New(PurgeFailed, "purge failed")
+)
+
+// CodeOf is the code of the first Error in the chain of err, OK when err is
+// nil and Unknown when it has none.
+func CodeOf(err error) Code {
+	if err == nil {
+		return OK
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return Unknown
+}
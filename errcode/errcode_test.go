@@ -0,0 +1,20 @@
+package errcode
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/test-go/testify/assert"
+)
+
+func TestCodeOf(t *testing.T) {
+	assert.Equal(t, OK, CodeOf(nil))
+	assert.Equal(t, Unknown, CodeOf(errors.New("boom")))
+	assert.Equal(t, CopyFailed, CodeOf(errors.Wrap(ErrCopyFailed, "code: 1006")))
+	err := fmt.Errorf("%w: %w", ErrSourceUnavailable, errors.New("server has gone away"))
+	assert.Equal(t, SourceUnavailable, CodeOf(err))
+	assert.True(t, errors.Is(err, ErrSourceUnavailable))
+	assert.Equal(t, 10, CodeOf(err).ExitCode())
+	assert.Equal(t, 1, Code("NEW").ExitCode())
+}
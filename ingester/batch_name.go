@@ -0,0 +1,84 @@
+package ingester
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// BatchName identifies a batch by what it holds, so that it is staged under
+// the same name by every run and every attempt, and a COPY without force
+// skips it once loaded: the table and the source range or file it was read
+// from, the part of that source when it is read in several batches and the
+// chunk of a batch split by maxBatchBytes.
+type BatchName struct {
+	Table  string
+	Source string
+	Part   int
+	Chunk  int
+}
+
+// maxSlug is the length of the readable part of a source in a stage name,
+// the hash of the whole source keeps longer ones apart.
+const maxSlug = 64
+
+func (n BatchName) String() string {
+	sum := sha256.Sum256([]byte(n.Source))
+	name := fmt.Sprintf("%s-%s", slug(n.Source, maxSlug), hex.EncodeToString(sum[:4]))
+	if n.Part > 0 {
+		name = fmt.Sprintf("%s-p%d", name, n.Part)
+	}
+	if n.Chunk > 0 {
+		name = fmt.Sprintf("%s-c%d", name, n.Chunk)
+	}
+	return name
+}
+
+// slug keeps the letters, digits, dots and dashes of s, every other run of
+// characters becomes one underscore, e.g. id_1_and_id_11 for
+// (id >= 1 and id < 11).
+func slug(s string, max int) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range s {
+		if r < 128 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-') {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	result := strings.Trim(b.String(), "_")
+	if len(result) > max {
+		result = strings.TrimRight(result[:max], "_")
+	}
+	return result
+}
+
+// stagePath is the path fileName is uploaded to in the user stage:
+// batch/<jobId>/<table>/<batch name> with the extensions of fileName, under
+// stageEncryptionPath instead of batch for the encrypted copies. A retried
+// upload overwrites the file of the attempt before. Files without a name,
+// like probes, are named by the run and the upload time.
+func stagePath(cfg *config.Config, name BatchName, fileName string, now time.Time) string {
+	base := filepath.Base(fileName)
+	dir := "batch"
+	if strings.HasSuffix(base, ".gpg") {
+		dir = strings.Trim(cfg.StageEncryptionPath, "/")
+	}
+	if name.Table == "" {
+		return path.Join(dir, cfg.JobID, cfg.RunID, fmt.Sprintf("%d-%s", now.Unix(), base))
+	}
+	ext := ""
+	if i := strings.Index(base, "."); i >= 0 {
+		ext = base[i:]
+	}
+	return path.Join(dir, cfg.JobID, slug(name.Table, maxSlug), name.String()+ext)
+}
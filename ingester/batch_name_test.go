@@ -0,0 +1,37 @@
+package ingester
+
+import (
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestStagePath(t *testing.T) {
+	now := time.Unix(1718000000, 0)
+	cfg := &config.Config{JobID: "3f2a9c1b7e04", RunID: "20240610T061320-a1b2c3"}
+	name := BatchName{Table: "shop.orders", Source: "(id >= 1 and id < 11)"}
+	assert.Equal(t, "batch/3f2a9c1b7e04/shop.orders/id_1_and_id_11-4a6269d8.ndjson.gz",
+		stagePath(cfg, name, "/tmp/databend-ingest-1-2.ndjson.gz", now))
+	// the same batch gets the same name in every run
+	other := &config.Config{JobID: "3f2a9c1b7e04", RunID: "20240611T061320-d4e5f6"}
+	assert.Equal(t, stagePath(cfg, name, "/tmp/a.ndjson", now), stagePath(other, name, "/tmp/b.ndjson", now.Add(time.Hour)))
+
+	name = BatchName{Table: "dbarchiver", Source: "/data/in/orders 2024.csv", Part: 3}
+	assert.Regexp(t, `^batch/3f2a9c1b7e04/dbarchiver/data_in_orders_2024\.csv-[0-9a-f]{8}-p3\.ndjson$`, stagePath(cfg, name, "/tmp/x.ndjson", now))
+
+	assert.Equal(t, "batch/3f2a9c1b7e04/20240610T061320-a1b2c3/1718000000-probe.ndjson", stagePath(cfg, BatchName{}, "/tmp/probe.ndjson", now))
+	assert.Equal(t, "batch/1718000000-probe.ndjson", stagePath(&config.Config{}, BatchName{}, "/tmp/probe.ndjson", now))
+
+	// the encrypted copies are kept apart
+	cfg.StageEncryptionPath = "/encrypted/"
+	assert.Equal(t, "encrypted/3f2a9c1b7e04/shop.orders/id_1_and_id_11-4a6269d8.ndjson.gpg",
+		stagePath(cfg, BatchName{Table: "shop.orders", Source: "(id >= 1 and id < 11)"}, "/tmp/x.ndjson.gpg", now))
+}
+
+func TestSlug(t *testing.T) {
+	assert.Equal(t, "t_2024-01-01_00_00_00_and_t_2024-01-02_00_00_00", slug("(t >= '2024-01-01 00:00:00' and t < '2024-01-02 00:00:00')", 64))
+	assert.Equal(t, "id_1", slug("(id >= 1 and id < 11)", 5))
+}
@@ -0,0 +1,122 @@
+package ingester
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// booleanSpellings are the values read as booleans, case-insensitively.
+var booleanSpellings = map[string]bool{
+	"true": true, "t": true, "yes": true, "y": true, "1": true, "on": true,
+	"false": false, "f": false, "no": false, "n": false, "0": false, "off": false,
+}
+
+// booleanColumns are the lower case names of the columns whose values are
+// normalized to booleans: the BOOLEAN columns of the target table when
+// cfg.NormalizeBooleans is set, with the overrides of cfg.BooleanColumns.
+// The target columns are only read once per ingester.
+func (ig *databendIngester) booleanColumns() (map[string]bool, error) {
+	cfg := ig.databendIngesterCfg
+	if !cfg.NormalizeBooleans && len(cfg.BooleanColumns) == 0 {
+		return nil, nil
+	}
+	ig.boolMu.Lock()
+	defer ig.boolMu.Unlock()
+	if ig.boolColumns != nil {
+		return ig.boolColumns, nil
+	}
+	columns := make(map[string]bool)
+	if cfg.NormalizeBooleans {
+		types, err := targetColumnTypes(cfg)
+		if err != nil {
+			return nil, err
+		}
+		for column, typ := range types {
+			if strings.Contains(strings.ToUpper(typ), "BOOLEAN") {
+				columns[column] = true
+			}
+		}
+	}
+	for column, normalize := range cfg.BooleanColumns {
+		columns[strings.ToLower(column)] = normalize
+	}
+	ig.boolColumns = columns
+	return columns, nil
+}
+
+// targetColumnTypes maps the lower case column names of the target table of
+// cfg to their Databend types.
+func targetColumnTypes(cfg *config.Config) (map[string]string, error) {
+	db, err := openDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	database, table := "default", cfg.DatabendTable
+	if parts := strings.SplitN(table, ".", 2); len(parts) == 2 {
+		database, table = parts[0], parts[1]
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT name, type FROM system.columns WHERE database = '%s' AND table = '%s'", database, table))
+	if err != nil {
+		return nil, fmt.Errorf("read the columns of %s: %w", cfg.DatabendTable, err)
+	}
+	defer rows.Close()
+	types := make(map[string]string)
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, err
+		}
+		types[strings.ToLower(name)] = typ
+	}
+	return types, rows.Err()
+}
+
+// normalizeBooleans replaces the values of the boolColumns of rows spelled
+// as booleans with true or false, in place. Other values are left for the
+// COPY to reject.
+func normalizeBooleans(boolColumns map[string]bool, columns []string, rows [][]interface{}) {
+	var idx []int
+	for i, column := range columns {
+		if boolColumns[strings.ToLower(column)] {
+			idx = append(idx, i)
+		}
+	}
+	if len(idx) == 0 {
+		return
+	}
+	for _, row := range rows {
+		if len(row) == 0 {
+			continue
+		}
+		for _, i := range idx {
+			if b, ok := parseBoolean(row[i]); ok {
+				row[i] = b
+			}
+		}
+	}
+}
+
+func parseBoolean(v interface{}) (bool, bool) {
+	var s string
+	switch v := v.(type) {
+	case bool:
+		return v, true
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case json.Number:
+		s = v.String()
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		s = fmt.Sprint(v)
+	case float32, float64:
+		s = fmt.Sprint(v)
+	default:
+		return false, false
+	}
+	b, ok := booleanSpellings[strings.ToLower(strings.TrimSpace(s))]
+	return b, ok
+}
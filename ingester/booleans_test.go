@@ -0,0 +1,40 @@
+package ingester
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestNormalizeBooleans(t *testing.T) {
+	rows := [][]interface{}{
+		{"1", "Y", int64(0), "x"},
+		nil,
+		{"off", " yes ", json.Number("1"), "t"},
+		{"maybe", nil, true, "f"},
+	}
+	normalizeBooleans(map[string]bool{"active": true, "verified": true, "deleted": true}, []string{"Active", "verified", "deleted", "name"}, rows)
+	assert.Equal(t, [][]interface{}{
+		{true, true, false, "x"},
+		nil,
+		{false, true, true, "t"},
+		// values that are no boolean are left for the COPY to reject
+		{"maybe", nil, true, "f"},
+	}, rows)
+}
+
+func TestBooleanColumns(t *testing.T) {
+	// the target is only read with normalizeBooleans
+	ig := &databendIngester{databendIngesterCfg: &config.Config{BooleanColumns: map[string]bool{"Active": true, "flag": false}}}
+	columns, err := ig.booleanColumns()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"active": true, "flag": false}, columns)
+
+	ig = &databendIngester{databendIngesterCfg: &config.Config{}}
+	columns, err = ig.booleanColumns()
+	assert.NoError(t, err)
+	assert.Nil(t, columns)
+}
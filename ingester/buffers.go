@@ -0,0 +1,50 @@
+package ingester
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+// Every batch is hashed, maybe compressed, and uploaded; the buffers of
+// these steps are pooled so that long jobs reuse them instead of allocating
+// them again for each batch.
+var (
+	copyBufferPool = sync.Pool{New: func() interface{} {
+		buf := make([]byte, 256<<10)
+		return &buf
+	}}
+	// a gzip.Writer holds hundreds of KB of compressor state
+	gzipWriterPool   = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	uploadReaderPool = sync.Pool{New: func() interface{} { return bufio.NewReaderSize(nil, 256<<10) }}
+)
+
+// copyBuffer is io.Copy through a pooled buffer.
+func copyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+// uploadBody reads an upload through a pooled buffer. The HTTP transport
+// closes the body once it is done with it, even on errors, which puts the
+// buffer back.
+type uploadBody struct {
+	*bufio.Reader
+	once sync.Once
+}
+
+func newUploadBody(r io.Reader) *uploadBody {
+	reader := uploadReaderPool.Get().(*bufio.Reader)
+	reader.Reset(r)
+	return &uploadBody{Reader: reader}
+}
+
+func (b *uploadBody) Close() error {
+	b.once.Do(func() {
+		b.Reader.Reset(nil)
+		uploadReaderPool.Put(b.Reader)
+	})
+	return nil
+}
@@ -0,0 +1,37 @@
+package ingester
+
+import (
+	"compress/gzip"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// gzipFile compresses fileName into fileName.gz and removes the plain file,
+// COPY INTO detects the compression from the extension.
+func gzipFile(fileName string) (string, error) {
+	defer os.Remove(fileName)
+	in, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	gzName := fileName + ".gz"
+	out, err := os.OpenFile(gzName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	w := gzipWriterPool.Get().(*gzip.Writer)
+	defer gzipWriterPool.Put(w)
+	w.Reset(out)
+	if _, err := copyBuffer(w, in); err != nil {
+		os.Remove(gzName)
+		return "", errors.Wrap(err, "compress batch file failed")
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(gzName)
+		return "", errors.Wrap(err, "compress batch file failed")
+	}
+	return gzName, nil
+}
@@ -0,0 +1,32 @@
+package ingester
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestGzipFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "batch.ndjson")
+	plain := []byte(`{"id":1,"name":"a"}` + "\n" + `{"id":2,"name":"b"}` + "\n")
+	assert.NoError(t, os.WriteFile(fileName, plain, 0o600))
+
+	gzName, err := gzipFile(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, fileName+".gz", gzName)
+	_, err = os.Stat(fileName)
+	assert.True(t, os.IsNotExist(err))
+
+	f, err := os.Open(gzName)
+	assert.NoError(t, err)
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, data)
+}
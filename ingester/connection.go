@@ -0,0 +1,203 @@
+package ingester
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+
+	godatabend "github.com/datafuselabs/databend-go"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// openDB returns the pool of connections of the databendDriver of cfg to
+// the target Databend, with the session settings of the job, authenticating
+// with an OAuth access token instead of the DSN password when
+// cfg.DatabendTokenURL is set. The pool is shared, it isn't closed.
+func openDB(cfg *config.Config) (*sql.DB, error) {
+	driver, err := driverFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return driver.DB(cfg)
+}
+
+// Exec runs query on the target Databend of cfg, e.g. the DDL of a table.
+func Exec(cfg *config.Config, query string) error {
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(query)
+	return err
+}
+
+// QueryCount runs a count query on the target Databend of cfg.
+func QueryCount(cfg *config.Config, query string) (int, error) {
+	db, err := openDB(cfg)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	if err := db.QueryRow(query).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Query runs query on the target Databend of cfg and returns its rows as
+// strings, NULLs are empty.
+func Query(cfg *config.Config, query string) ([][]string, error) {
+	db, err := openDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result [][]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make([]string, len(columns))
+		for i, v := range values {
+			row[i] = v.String
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// TableColumns returns the column names of table on the target Databend of
+// cfg.
+func TableColumns(cfg *config.Config, table string) ([]string, error) {
+	db, err := openDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s LIMIT 0", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
+
+// ListTables returns the names of the tables of database on the target
+// Databend of cfg.
+func ListTables(cfg *config.Config, database string) ([]string, error) {
+	db, err := openDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT name FROM system.tables WHERE database = '%s'", strings.ReplaceAll(database, "'", "''")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// parseDatabendDSN parses cfg.DatabendDSN, the warehouse, role and session
+// settings of cfg override the ones of the DSN.
+func parseDatabendDSN(cfg *config.Config) (*godatabend.Config, error) {
+	databendConfig, err := godatabend.ParseDSN(cfg.DatabendDSN)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DatabendTokenURL != "" {
+		// databend-go prefers basic auth whenever the DSN has a user
+		databendConfig.User, databendConfig.Password = "", ""
+		databendConfig.AccessTokenLoader = oauthTokenLoaderFor(cfg)
+	}
+	if cfg.DatabendWarehouse != "" {
+		databendConfig.Warehouse = cfg.DatabendWarehouse
+	}
+	if cfg.DatabendRole != "" {
+		databendConfig.Role = cfg.DatabendRole
+	}
+	for k, v := range cfg.DatabendSettings {
+		databendConfig.Params[k] = v
+	}
+	if cfg.DatabendQueryTag != "" {
+		databendConfig.Params["query_tag"] = cfg.DatabendQueryTag
+	}
+	if cfg.DatabendTimezone != "" {
+		databendConfig.Params["timezone"] = cfg.DatabendTimezone
+	}
+	return databendConfig, nil
+}
+
+var (
+	oauthTokenLoadersMu sync.Mutex
+	oauthTokenLoaders   = make(map[string]*oauthTokenLoader)
+)
+
+// oauthTokenLoaderFor shares one loader, and so one token, between all the
+// connections of a job.
+func oauthTokenLoaderFor(cfg *config.Config) *oauthTokenLoader {
+	key := cfg.DatabendTokenURL + "\x00" + cfg.DatabendClientID
+	oauthTokenLoadersMu.Lock()
+	defer oauthTokenLoadersMu.Unlock()
+	if l, ok := oauthTokenLoaders[key]; ok {
+		return l
+	}
+	l := &oauthTokenLoader{config: &clientcredentials.Config{
+		ClientID:     cfg.DatabendClientID,
+		ClientSecret: os.ExpandEnv(cfg.DatabendClientSecret),
+		TokenURL:     cfg.DatabendTokenURL,
+		Scopes:       cfg.DatabendScopes,
+	}}
+	oauthTokenLoaders[key] = l
+	return l
+}
+
+// oauthTokenLoader gets access tokens with the OAuth client credentials flow,
+// they are refreshed shortly before they expire.
+type oauthTokenLoader struct {
+	config *clientcredentials.Config
+	mu     sync.Mutex
+	source oauth2.TokenSource
+}
+
+// LoadAccessToken implements godatabend.AccessTokenLoader, forceRotate is set
+// when Databend rejected the current token.
+func (l *oauthTokenLoader) LoadAccessToken(ctx context.Context, forceRotate bool) (string, error) {
+	l.mu.Lock()
+	if l.source == nil || forceRotate {
+		l.source = l.config.TokenSource(context.Background())
+	}
+	source := l.source
+	l.mu.Unlock()
+	token, err := source.Token()
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
@@ -0,0 +1,76 @@
+package ingester
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	godatabend "github.com/datafuselabs/databend-go"
+)
+
+// copyGroup is the files of a COPY INTO of several files, the first thread
+// to stage one copies them all once the group is full or copyGroupWait
+// passed, the others wait for its outcome.
+type copyGroup struct {
+	paths []string
+	full  chan struct{} // closed when the group has copyMaxFiles files
+	done  chan struct{} // closed when the group is copied
+	err   error
+}
+
+// copyGroups are the groups taking files, by target table and stage.
+var copyGroups = newCopyGroupSet((*databendIngester).copyFiles)
+
+type copyGroupSet struct {
+	mu        sync.Mutex
+	open      map[string]*copyGroup
+	copyFiles func(ig *databendIngester, name string, paths []string) error
+}
+
+func newCopyGroupSet(copyFiles func(ig *databendIngester, name string, paths []string) error) *copyGroupSet {
+	return &copyGroupSet{open: make(map[string]*copyGroup), copyFiles: copyFiles}
+}
+
+// copy adds the file at stage to the open group of the target table of ig
+// and returns when the group is copied, with its error.
+func (s *copyGroupSet) copy(ig *databendIngester, stage *godatabend.StageLocation) error {
+	cfg := ig.databendIngesterCfg
+	key := cfg.DatabendTable + "\x00" + stage.Name
+	s.mu.Lock()
+	g, ok := s.open[key]
+	if !ok {
+		g = &copyGroup{full: make(chan struct{}), done: make(chan struct{})}
+		s.open[key] = g
+	}
+	g.paths = append(g.paths, stage.Path)
+	if len(g.paths) >= cfg.CopyMaxFiles {
+		delete(s.open, key)
+		close(g.full)
+	}
+	s.mu.Unlock()
+	if ok {
+		<-g.done
+		return g.err
+	}
+
+	wait, _ := time.ParseDuration(cfg.CopyGroupWait)
+	timer := time.NewTimer(wait)
+	select {
+	case <-g.full:
+	case <-timer.C:
+	}
+	timer.Stop()
+	s.mu.Lock()
+	if s.open[key] == g {
+		delete(s.open, key)
+	}
+	paths := g.paths
+	s.mu.Unlock()
+	if len(paths) > 1 {
+		logrus.Infof("copy %d staged files into %s at once", len(paths), cfg.DatabendTable)
+	}
+	g.err = s.copyFiles(ig, stage.Name, paths)
+	close(g.done)
+	return g.err
+}
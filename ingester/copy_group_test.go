@@ -0,0 +1,68 @@
+package ingester
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	godatabend "github.com/datafuselabs/databend-go"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestCopyIntoStatement(t *testing.T) {
+	cfg := &config.Config{DatabendTable: "archive.orders", CopyPurge: true}
+	assert.Equal(t, "COPY INTO archive.orders FROM @~/batch/a.ndjson FILE_FORMAT = (type = NDJSON missing_field_as = FIELD_DEFAULT COMPRESSION = AUTO) "+
+		"PURGE = true FORCE = false DISABLE_VARIANT_CHECK = false", copyIntoStatement(cfg, "~", []string{"batch/a.ndjson"}))
+	assert.Equal(t, "COPY INTO archive.orders FROM @archive_stage FILES = ('batch/a.ndjson', 'batch/b.ndjson') FILE_FORMAT = (type = NDJSON missing_field_as = FIELD_DEFAULT COMPRESSION = AUTO) "+
+		"PURGE = true FORCE = false DISABLE_VARIANT_CHECK = false", copyIntoStatement(cfg, "archive_stage", []string{"batch/a.ndjson", "batch/b.ndjson"}))
+}
+
+func TestCopyGroups(t *testing.T) {
+	var mu sync.Mutex
+	var copies [][]string
+	set := newCopyGroupSet(func(ig *databendIngester, name string, paths []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		copies = append(copies, append([]string(nil), paths...))
+		if len(copies) == 2 {
+			return errors.New("copy failed")
+		}
+		return nil
+	})
+	// the first group fills up, the second is copied after the wait
+	ig := &databendIngester{databendIngesterCfg: &config.Config{DatabendTable: "t", CopyMaxFiles: 3, CopyGroupWait: "1h"}}
+	copyAll := func(ig *databendIngester, paths ...string) []error {
+		errs := make([]error, len(paths))
+		var wg sync.WaitGroup
+		for i, p := range paths {
+			wg.Add(1)
+			go func(i int, p string) {
+				defer wg.Done()
+				errs[i] = set.copy(ig, &godatabend.StageLocation{Name: "~", Path: p})
+			}(i, p)
+		}
+		wg.Wait()
+		return errs
+	}
+	assert.Equal(t, []error{nil, nil, nil}, copyAll(ig, "a", "b", "c"))
+
+	ig = &databendIngester{databendIngesterCfg: &config.Config{DatabendTable: "t", CopyMaxFiles: 3, CopyGroupWait: "200ms"}}
+	errs := copyAll(ig, "d", "e")
+	for _, err := range errs {
+		assert.EqualError(t, err, "copy failed")
+	}
+	// every file was copied once, the ones copied together failed together
+	assert.Len(t, copies, 2)
+	var all []string
+	for _, c := range copies {
+		all = append(all, c...)
+	}
+	sort.Strings(all)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, all)
+	assert.Len(t, copies[0], 3, fmt.Sprint(copies))
+}
@@ -0,0 +1,69 @@
+package ingester
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/errcode"
+)
+
+// Cost is the approximate cost of the run on Databend Cloud, credits are
+// only known with warehouseCreditsPerHour.
+type Cost struct {
+	BytesLoaded int64   `json:"bytesLoaded"` // NDJSON bytes of the batches, or of their INSERT statements
+	LoadSeconds float64 `json:"loadSeconds"` // time the COPY INTO and INSERT of the job ran
+	Credits     float64 `json:"credits"`     // loadSeconds at warehouseCreditsPerHour
+}
+
+var runCost struct {
+	mu             sync.Mutex
+	bytes          int64
+	loadTime       time.Duration
+	creditsPerHour float64
+}
+
+// ConfigureCost prices the loads of the job at warehouseCreditsPerHour of
+// cfg and resets the cost of the run.
+func ConfigureCost(cfg *config.Config) {
+	runCost.mu.Lock()
+	defer runCost.mu.Unlock()
+	runCost.bytes, runCost.loadTime, runCost.creditsPerHour = 0, 0, cfg.WarehouseCreditsPerHour
+}
+
+func addLoadBytes(n int) {
+	runCost.mu.Lock()
+	defer runCost.mu.Unlock()
+	runCost.bytes += int64(n)
+}
+
+func addLoadTime(d time.Duration) {
+	runCost.mu.Lock()
+	defer runCost.mu.Unlock()
+	runCost.loadTime += d
+}
+
+// RunCost is the cost of the run so far.
+func RunCost() Cost {
+	runCost.mu.Lock()
+	defer runCost.mu.Unlock()
+	return Cost{
+		BytesLoaded: runCost.bytes,
+		LoadSeconds: runCost.loadTime.Seconds(),
+		Credits:     runCost.loadTime.Hours() * runCost.creditsPerHour,
+	}
+}
+
+// BudgetSpent returns the error of the budget of cfg the run used up, nil
+// while it is within its budget.
+func BudgetSpent(cfg *config.Config) error {
+	cost := RunCost()
+	switch {
+	case cfg.BudgetCredits > 0 && cost.Credits >= cfg.BudgetCredits:
+		return fmt.Errorf("%w: %.2f credits of budgetCredits %v", errcode.ErrBudgetExceeded, cost.Credits, cfg.BudgetCredits)
+	case cfg.BudgetBytes > 0 && cost.BytesLoaded >= cfg.BudgetBytes:
+		return fmt.Errorf("%w: %d bytes loaded of budgetBytes %d", errcode.ErrBudgetExceeded, cost.BytesLoaded, cfg.BudgetBytes)
+	}
+	return nil
+}
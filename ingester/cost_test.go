@@ -0,0 +1,53 @@
+package ingester
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/errcode"
+)
+
+func TestRunCost(t *testing.T) {
+	cfg := &config.Config{WarehouseCreditsPerHour: 2}
+	ConfigureCost(cfg)
+	defer ConfigureCost(&config.Config{})
+
+	addLoadBytes(100)
+	addLoadBytes(50)
+	addLoadTime(90 * time.Minute)
+	cost := RunCost()
+	assert.Equal(t, int64(150), cost.BytesLoaded)
+	assert.Equal(t, 5400.0, cost.LoadSeconds)
+	assert.Equal(t, 3.0, cost.Credits)
+
+	ConfigureCost(cfg)
+	assert.Equal(t, Cost{}, RunCost())
+}
+
+func TestBudgetSpent(t *testing.T) {
+	defer ConfigureCost(&config.Config{})
+
+	cfg := &config.Config{WarehouseCreditsPerHour: 1, BudgetCredits: 0.5, BudgetBytes: 1000}
+	ConfigureCost(cfg)
+	assert.NoError(t, BudgetSpent(cfg))
+
+	addLoadBytes(999)
+	addLoadTime(29 * time.Minute)
+	assert.NoError(t, BudgetSpent(cfg))
+
+	addLoadTime(time.Minute)
+	err := BudgetSpent(cfg)
+	assert.True(t, errors.Is(err, errcode.ErrBudgetExceeded))
+	assert.Contains(t, err.Error(), "budgetCredits")
+
+	ConfigureCost(cfg)
+	addLoadBytes(1000)
+	err = BudgetSpent(cfg)
+	assert.True(t, errors.Is(err, errcode.ErrBudgetExceeded))
+	assert.Contains(t, err.Error(), "budgetBytes")
+	assert.Equal(t, 5, errcode.CodeOf(err).ExitCode())
+}
@@ -0,0 +1,138 @@
+package ingester
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	godatabend "github.com/datafuselabs/databend-go"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/internal/faults"
+	"github.com/databendcloud/bend-archiver/internal/sqllog"
+)
+
+// Driver is a protocol the job talks to Databend with, picked by
+// databendDriver. A driver multiplexes the queries and stage uploads of the
+// whole job over its own connections.
+type Driver interface {
+	// DB is the pool of connections to the target of cfg, shared by every
+	// caller with the same target and open until the job ends.
+	DB(cfg *config.Config) (*sql.DB, error)
+	// Upload stages the size bytes of input at stage of the target of cfg.
+	Upload(cfg *config.Config, stage *godatabend.StageLocation, input io.Reader, size int64) error
+}
+
+var drivers = struct {
+	mu     sync.Mutex
+	byName map[string]Driver
+}{byName: map[string]Driver{"http": newHTTPDriver()}}
+
+// RegisterDriver makes driver available as databendDriver name, e.g. from a
+// build with a flight SQL driver.
+func RegisterDriver(name string, driver Driver) {
+	drivers.mu.Lock()
+	defer drivers.mu.Unlock()
+	drivers.byName[name] = driver
+}
+
+// driverFor is the driver of cfg.
+func driverFor(cfg *config.Config) (Driver, error) {
+	name := cfg.DatabendDriver
+	if name == "" {
+		name = "http"
+	}
+	drivers.mu.Lock()
+	defer drivers.mu.Unlock()
+	if driver, ok := drivers.byName[name]; ok {
+		return driver, nil
+	}
+	names := make([]string, 0, len(drivers.byName))
+	for n := range drivers.byName {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown databendDriver %q, this build has %s", name, strings.Join(names, ", "))
+}
+
+// httpDriver sends the queries to the HTTP handler of Databend with
+// databend-go, over the keep-alive connections of net/http, and uploads the
+// batches by presigned URL, or through the Databend API when the stage
+// can't presign.
+type httpDriver struct {
+	mu    sync.Mutex
+	pools map[string]*sql.DB
+}
+
+func newHTTPDriver() *httpDriver {
+	return &httpDriver{pools: make(map[string]*sql.DB)}
+}
+
+func (d *httpDriver) DB(cfg *config.Config) (*sql.DB, error) {
+	databendConfig, err := parseDatabendDSN(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// the settings parseDatabendDSN reads
+	key := fmt.Sprintf("%q %q %q %q %q %v %q %q %d", cfg.DatabendDSN, cfg.DatabendWarehouse, cfg.DatabendRole,
+		cfg.DatabendQueryTag, cfg.DatabendTimezone, cfg.DatabendSettings, cfg.DatabendTokenURL, cfg.DatabendClientID, cfg.DatabendMaxConns)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if db, ok := d.pools[key]; ok {
+		return db, nil
+	}
+	db := sqllog.OpenDB(databendConfig, "databend")
+	if cfg.DatabendMaxConns > 0 {
+		db.SetMaxOpenConns(cfg.DatabendMaxConns)
+		db.SetMaxIdleConns(cfg.DatabendMaxConns)
+	}
+	d.pools[key] = db
+	return db, nil
+}
+
+func (d *httpDriver) Upload(cfg *config.Config, stage *godatabend.StageLocation, input io.Reader, size int64) error {
+	databendConfig, err := parseDatabendDSN(cfg)
+	if err != nil {
+		return err
+	}
+	apiClient := godatabend.NewAPIClientFromConfig(databendConfig)
+	if !CurrentFeatures().Presign {
+		if err := faults.Inject(faults.Upload); err != nil {
+			return errors.Wrap(ErrUploadStageFailed, err.Error())
+		}
+		uploadByAPI := time.Now()
+		body := newUploadBody(input)
+		err := apiClient.UploadToStageByAPI(context.Background(), stage, body.Reader)
+		body.Close()
+		if err != nil {
+			return errors.Wrap(ErrUploadStageFailed, err.Error())
+		}
+		logrus.Infof("upload by api cost: %v ms", time.Since(uploadByAPI).Milliseconds())
+		return nil
+	}
+
+	presignedStartTime := time.Now()
+	presigned, err := apiClient.GetPresignedURL(context.Background(), stage)
+	if err != nil {
+		return errors.Wrap(ErrGetPresignUrl, err.Error())
+	}
+	logrus.Infof("get presigned url cost: %v ms", time.Since(presignedStartTime).Milliseconds())
+
+	if err := faults.Inject(faults.Upload); err != nil {
+		return errors.Wrap(ErrUploadStageFailed, err.Error())
+	}
+	uploadByPresignedUrl := time.Now()
+	if err := uploadToStageByPresignURL(presigned, newUploadBody(input), size); err != nil {
+		return errors.Wrap(ErrUploadStageFailed, err.Error())
+	}
+	logrus.Infof("upload by presigned url cost: %v ms", time.Since(uploadByPresignedUrl).Milliseconds())
+	return nil
+}
@@ -0,0 +1,42 @@
+package ingester
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// encryptFile encrypts fileName to the armored GPG public keys of cfg, e.g.
+// exported with gpg --armor --export, into fileName.gpg and removes the
+// plain file. It runs the gpg executable with a throwaway home, so neither
+// its keyring nor its trust database are used.
+func encryptFile(cfg *config.Config, fileName string) (string, error) {
+	if len(cfg.StageEncryptionKeys) == 0 {
+		return "", errors.New("no stage encryption recipients")
+	}
+	defer os.Remove(fileName)
+	home, err := os.MkdirTemp("", "bend-archiver-gpg-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(home)
+	encryptedName := fileName + ".gpg"
+	args := []string{"--homedir", home, "--batch", "--yes", "--no-tty", "--quiet", "--output", encryptedName}
+	for _, keyFile := range cfg.StageEncryptionKeys {
+		args = append(args, "--recipient-file", keyFile)
+	}
+	args = append(args, "--encrypt", fileName)
+	var stderr bytes.Buffer
+	cmd := exec.Command("gpg", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(encryptedName)
+		return "", errors.Wrapf(err, "encrypt batch file failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	return encryptedName, nil
+}
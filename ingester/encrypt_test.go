@@ -0,0 +1,49 @@
+package ingester
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// gpg runs the gpg executable with the keyring of home.
+func gpg(t *testing.T, home string, args ...string) []byte {
+	out, err := exec.Command("gpg", append([]string{"--homedir", home, "--batch", "--quiet"}, args...)...).Output()
+	assert.NoError(t, err)
+	return out
+}
+
+func TestEncryptFile(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	dir := t.TempDir()
+	home := filepath.Join(dir, "gnupg")
+	assert.NoError(t, os.Mkdir(home, 0o700))
+	gpg(t, home, "--passphrase", "", "--quick-gen-key", "archiver <archiver@example.com>", "future-default", "default", "never")
+	keyFile := filepath.Join(dir, "archiver.asc")
+	assert.NoError(t, os.WriteFile(keyFile, gpg(t, home, "--armor", "--export", "archiver@example.com"), 0o600))
+
+	fileName := filepath.Join(dir, "batch.ndjson")
+	plain := []byte(`{"id":1,"ssn":"123-45-6789"}` + "\n")
+	assert.NoError(t, os.WriteFile(fileName, plain, 0o600))
+
+	encrypted, err := encryptFile(&config.Config{StageEncryptionKeys: []string{keyFile}}, fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, fileName+".gpg", encrypted)
+	_, err = os.Stat(fileName)
+	assert.True(t, os.IsNotExist(err))
+
+	data, err := os.ReadFile(encrypted)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "123-45-6789")
+	assert.Equal(t, plain, gpg(t, home, "--decrypt", encrypted))
+
+	_, err = encryptFile(&config.Config{}, encrypted)
+	assert.EqualError(t, err, "no stage encryption recipients")
+}
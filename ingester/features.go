@@ -0,0 +1,193 @@
+package ingester
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+
+	godatabend "github.com/datafuselabs/databend-go"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// Features are the capabilities of the target Databend the job has a
+// fallback for, all of them are assumed until ConfigureFeatures.
+type Features struct {
+	Version         string // as reported by version(), e.g. v1.2.680-nightly
+	MergeInto       bool   // MERGE INTO, diff sync deletes and inserts the changed rows otherwise
+	Transactions    bool   // BEGIN and COMMIT, diff sync runs its statements one by one otherwise
+	Presign         bool   // PRESIGN UPLOAD, batches are uploaded through the Databend API otherwise
+	InvertedIndexes bool   // CREATE INVERTED INDEX, targetInvertedIndexes is skipped otherwise
+	StageType       string // type of userStage: User, Internal or External
+}
+
+var allFeatures = Features{MergeInto: true, Transactions: true, Presign: true, InvertedIndexes: true}
+
+var features atomic.Pointer[Features]
+
+// CurrentFeatures are the features of the target Databend of the job.
+func CurrentFeatures() Features {
+	if f := features.Load(); f != nil {
+		return *f
+	}
+	return allFeatures
+}
+
+// minVersions are the first releases with the features only told apart by
+// the version, the features of a version that can't be parsed are assumed.
+var minVersions = struct {
+	mergeInto, transactions, invertedIndexes [3]int
+}{
+	mergeInto:       [3]int{1, 2, 300}, // before, behind enable_experimental_merge_into
+	transactions:    [3]int{1, 2, 400},
+	invertedIndexes: [3]int{1, 2, 400},
+}
+
+var versionPattern = regexp.MustCompile(`v?(\d+)\.(\d+)\.(\d+)`)
+
+// parseVersion returns the major, minor and patch numbers of the version()
+// of Databend, e.g. DatabendQuery v1.2.680-nightly-5a1d2c3(rust-1.81.0).
+func parseVersion(version string) ([3]int, bool) {
+	m := versionPattern.FindStringSubmatch(version)
+	if m == nil {
+		return [3]int{}, false
+	}
+	var v [3]int
+	for i := range v {
+		v[i], _ = strconv.Atoi(m[i+1])
+	}
+	return v, true
+}
+
+func atLeast(v, min [3]int) bool {
+	for i := range v {
+		if v[i] != min[i] {
+			return v[i] > min[i]
+		}
+	}
+	return true
+}
+
+// featuresOf are the features of Databend version, but the ones probed.
+func featuresOf(version string) Features {
+	f := allFeatures
+	f.Version = version
+	if v, ok := parseVersion(version); ok {
+		f.MergeInto = atLeast(v, minVersions.mergeInto)
+		f.Transactions = atLeast(v, minVersions.transactions)
+		f.InvertedIndexes = atLeast(v, minVersions.invertedIndexes)
+	} else {
+		logrus.Warnf("unknown Databend version %q, assuming it has every feature", version)
+	}
+	return f
+}
+
+// DetectFeatures queries the version of the target Databend of cfg and
+// probes the stage of the job: whether it exists, its type and whether
+// uploads to it can be presigned, which depends on its storage too.
+func DetectFeatures(cfg *config.Config) (Features, error) {
+	db, err := openDB(cfg)
+	if err != nil {
+		return Features{}, err
+	}
+	var version string
+	if err := db.QueryRow("SELECT version()").Scan(&version); err != nil {
+		return Features{}, fmt.Errorf("query the Databend version: %w", err)
+	}
+	f := featuresOf(version)
+	if cfg.IngestMode == "insert" {
+		// no stage is used
+		f.Presign = false
+		return f, nil
+	}
+
+	f.StageType = "User"
+	if cfg.UserStage != "~" {
+		err := db.QueryRow(fmt.Sprintf("SELECT stage_type FROM system.stages WHERE name = '%s'",
+			strings.ReplaceAll(cfg.UserStage, "'", "''"))).Scan(&f.StageType)
+		if err == sql.ErrNoRows {
+			return Features{}, fmt.Errorf("userStage %s does not exist", cfg.UserStage)
+		}
+		if err != nil {
+			return Features{}, fmt.Errorf("query the type of userStage %s: %w", cfg.UserStage, err)
+		}
+	}
+	// presigning creates no file
+	probe := &godatabend.StageLocation{Name: cfg.UserStage, Path: "bend-archiver-probe"}
+	if _, err := db.Exec("PRESIGN UPLOAD " + probe.String()); err != nil {
+		logrus.Infof("PRESIGN UPLOAD on %s failed: %v", probe, err)
+		f.Presign = false
+	}
+	return f, nil
+}
+
+// ConfigureFeatures detects the features of the target Databend of cfg for
+// the rest of the job, and warns about the settings of cfg that fall back
+// or are skipped on it.
+func ConfigureFeatures(cfg *config.Config) (Features, error) {
+	f, err := DetectFeatures(cfg)
+	if err != nil {
+		return Features{}, err
+	}
+	features.Store(&f)
+	logrus.Infof("Databend %s: MERGE INTO %v, transactions %v, presigned uploads %v, inverted indexes %v, %s stage %s",
+		f.Version, f.MergeInto, f.Transactions, f.Presign, f.InvertedIndexes, f.StageType, cfg.UserStage)
+	for _, warning := range f.warnings(cfg) {
+		logrus.Warn(warning)
+	}
+	return f, nil
+}
+
+// warnings are the settings of cfg f doesn't support as configured.
+func (f Features) warnings(cfg *config.Config) []string {
+	var warnings []string
+	if cfg.DiffSync && !f.MergeInto {
+		warnings = append(warnings, fmt.Sprintf("diffSync: Databend %s has no MERGE INTO, the changed rows are deleted and inserted again", f.Version))
+	}
+	if cfg.DiffSync && !f.Transactions {
+		warnings = append(warnings, fmt.Sprintf("diffSync: Databend %s has no transactions, readers may see %s half synced", f.Version, cfg.DatabendTable))
+	}
+	if len(cfg.TargetInvertedIndexes) > 0 && !f.InvertedIndexes {
+		warnings = append(warnings, fmt.Sprintf("targetInvertedIndexes: Databend %s has no inverted indexes, they are not created", f.Version))
+	}
+	if cfg.IngestMode == "insert" && !f.Transactions {
+		warnings = append(warnings, fmt.Sprintf("ingestMode insert: Databend %s has no transactions, a batch failing midway is inserted again in part", f.Version))
+	}
+	if !f.Presign && cfg.IngestMode != "insert" {
+		warnings = append(warnings, fmt.Sprintf("userStage: uploads to %s can't be presigned, batches are uploaded through the Databend API", cfg.UserStage))
+	}
+	return warnings
+}
+
+// ExecAll runs queries on the target Databend of cfg in one transaction, or
+// one by one when it has no transactions.
+func ExecAll(cfg *config.Config, queries ...string) error {
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	if !CurrentFeatures().Transactions {
+		for _, query := range queries {
+			if _, err := db.Exec(query); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,57 @@
+package ingester
+
+import (
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestParseVersion(t *testing.T) {
+	v, ok := parseVersion("DatabendQuery v1.2.680-nightly-5a1d2c3(rust-1.81.0-nightly-2024-11-18T22:02:07.123Z)")
+	assert.True(t, ok)
+	assert.Equal(t, [3]int{1, 2, 680}, v)
+	v, ok = parseVersion("v1.1.30")
+	assert.True(t, ok)
+	assert.Equal(t, [3]int{1, 1, 30}, v)
+	_, ok = parseVersion("nightly")
+	assert.False(t, ok)
+}
+
+func TestFeaturesOf(t *testing.T) {
+	f := featuresOf("DatabendQuery v1.2.680-nightly")
+	assert.True(t, f.MergeInto && f.Transactions && f.InvertedIndexes && f.Presign)
+
+	f = featuresOf("DatabendQuery v1.2.350")
+	assert.True(t, f.MergeInto)
+	assert.False(t, f.Transactions)
+	assert.False(t, f.InvertedIndexes)
+
+	f = featuresOf("DatabendQuery v1.1.90")
+	assert.False(t, f.MergeInto)
+	assert.False(t, f.Transactions)
+
+	// unknown versions are assumed to have every feature
+	f = featuresOf("DatabendQuery dev")
+	assert.True(t, f.MergeInto && f.Transactions && f.InvertedIndexes)
+}
+
+func TestFeaturesWarnings(t *testing.T) {
+	cfg := &config.Config{DatabendTable: "dim.region", UserStage: "~", DiffSync: true,
+		TargetInvertedIndexes: map[string][]string{"idx": {"name"}}}
+	assert.Empty(t, allFeatures.warnings(cfg))
+
+	f := featuresOf("v1.1.90")
+	f.Presign = false
+	warnings := f.warnings(cfg)
+	assert.Len(t, warnings, 4)
+	assert.Contains(t, warnings[0], "no MERGE INTO")
+	assert.Contains(t, warnings[1], "no transactions")
+	assert.Contains(t, warnings[2], "targetInvertedIndexes")
+	assert.Contains(t, warnings[3], "through the Databend API")
+
+	cfg.DiffSync, cfg.TargetInvertedIndexes = false, nil
+	f.Presign = true
+	assert.Empty(t, f.warnings(cfg))
+}
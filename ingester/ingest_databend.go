@@ -1,15 +1,16 @@
 package ingester
 
 import (
-	"bufio"
-	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/avast/retry-go"
@@ -19,23 +20,49 @@ import (
 	godatabend "github.com/datafuselabs/databend-go"
 
 	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/errcode"
+	"github.com/databendcloud/bend-archiver/internal/faults"
 	"github.com/databendcloud/bend-archiver/source"
 )
 
 var (
-	ErrUploadStageFailed = errors.New("upload stage failed")
-	ErrCopyIntoFailed    = errors.New("copy into failed")
-	ErrGetPresignUrl     = errors.New("failed to get presigned url")
+	ErrUploadStageFailed = errcode.ErrStageUpload
+	ErrCopyIntoFailed    = errcode.ErrCopyFailed
+	ErrGetPresignUrl     = errcode.New(errcode.StageUpload, "failed to get presigned url")
 )
 
+// schemaErrorMessages are parts of the messages of the Databend errors about
+// the columns of the target table, retrying won't fix them.
+var schemaErrorMessages = []string{
+	"unknown column",
+	"number of columns",
+	"column count",
+	"cannot cast",
+	"type mismatch",
+}
+
+// copyError classifies an error of a COPY INTO or INSERT of a batch.
+func copyError(err error) error {
+	msg := strings.ToLower(err.Error())
+	for _, s := range schemaErrorMessages {
+		if strings.Contains(msg, s) {
+			return errors.Wrap(errcode.ErrSchemaMismatch, err.Error())
+		}
+	}
+	return errors.Wrap(ErrCopyIntoFailed, err.Error())
+}
+
 type databendIngester struct {
 	databendIngesterCfg *config.Config
 	statsRecorder       *DatabendIngesterStatsRecorder
+	boolMu              sync.Mutex
+	boolColumns         map[string]bool
 }
 
 type DatabendIngester interface {
 	IngestData(threadNum int, columns []string, batchJsonData [][]interface{}) error
-	uploadToStage(fileName string) (*godatabend.StageLocation, error)
+	IngestBatch(threadNum int, name BatchName, columns []string, batchJsonData [][]interface{}) (StagedBatch, error)
+	GetSnapshotID() (string, error)
 	GetAllSyncedCount() (int, error)
 	DoRetry(f retry.RetryableFunc) error
 }
@@ -49,13 +76,15 @@ func NewDatabendIngester(cfg *config.Config) DatabendIngester {
 }
 
 func (ig *databendIngester) GetAllSyncedCount() (int, error) {
-	db, err := sql.Open("databend", ig.databendIngesterCfg.DatabendDSN)
-	defer db.Close()
+	db, err := openDB(ig.databendIngesterCfg)
 	if err != nil {
 		return 0, err
 	}
-	rows, err := db.Query(fmt.Sprintf("SELECT count(*) FROM %s WHERE %s",
-		ig.databendIngesterCfg.DatabendTable, ig.databendIngesterCfg.SourceWhereCondition))
+	query := fmt.Sprintf("SELECT count(*) FROM %s", ig.databendIngesterCfg.DatabendTable)
+	if ig.databendIngesterCfg.SourceWhereCondition != "" {
+		query += " WHERE " + ig.databendIngesterCfg.SourceWhereCondition
+	}
+	rows, err := db.Query(query)
 	if err != nil {
 		return 0, err
 	}
@@ -71,39 +100,173 @@ func (ig *databendIngester) GetAllSyncedCount() (int, error) {
 	return 0, nil
 }
 
+// StagedBatch describes a batch file that was copied into the target table,
+// or the batch inserted with ingestMode insert.
+type StagedBatch struct {
+	Stage  string
+	SHA256 string
+	Bytes  int
+	Rows   int
+}
+
 func (ig *databendIngester) IngestData(threadNum int, columns []string, batchData [][]interface{}) error {
-	l := logrus.WithFields(logrus.Fields{"ingest_databend": "IngestData"})
+	_, err := ig.IngestBatch(threadNum, BatchName{}, columns, batchData)
+	return err
+}
+
+// IngestBatch stages batchData as one NDJSON file named after name and
+// copies it into the target table, in two stages each bounded by its own
+// pool: serializing the file, then uploading and copying it. With ingestMode
+// insert the batch is inserted instead, its Stage is empty.
+func (ig *databendIngester) IngestBatch(threadNum int, name BatchName, columns []string, batchData [][]interface{}) (StagedBatch, error) {
 	startTime := time.Now()
 
 	if len(batchData) == 0 {
-		return nil
+		return StagedBatch{}, nil
+	}
+	var batch StagedBatch
+	if ig.databendIngesterCfg.IngestMode == "insert" {
+		bytesSize, err := ig.insertBatch(threadNum, columns, batchData)
+		if err != nil {
+			return StagedBatch{}, err
+		}
+		batch.Bytes = bytesSize
+	} else {
+		fileName, sum, bytesSize, err := ig.serializeBatch(columns, batchData)
+		if err != nil {
+			return StagedBatch{}, err
+		}
+		stage, err := ig.loadBatch(threadNum, name, fileName, columns, batchData)
+		if err != nil {
+			return StagedBatch{}, err
+		}
+		batch = StagedBatch{Stage: stage.String(), SHA256: sum, Bytes: bytesSize}
+	}
+	addLoadBytes(batch.Bytes)
+	ig.statsRecorder.RecordMetric(batch.Bytes, len(batchData))
+	stats := ig.statsRecorder.Stats(time.Since(startTime))
+	log.Printf("thread-%d: ingest %d rows (%f rows/s), %d bytes (%f bytes/s)", threadNum,
+		len(batchData), stats.RowsPerSecondd, batch.Bytes, stats.BytesPerSecond)
+
+	for _, row := range batchData {
+		if len(row) > 0 {
+			batch.Rows++
+		}
 	}
+	return batch, nil
+}
 
-	fileName, bytesSize, err := source.GenerateJSONFile(columns, batchData)
+// serializeBatch writes batchData to an NDJSON file, compressed and
+// encrypted as configured, in the serialize pool. It returns the file, the
+// SHA-256 and the size of the NDJSON.
+func (ig *databendIngester) serializeBatch(columns []string, batchData [][]interface{}) (string, string, int, error) {
+	l := logrus.WithFields(logrus.Fields{"ingest_databend": "IngestData"})
+	release := acquireStage(stagePools.serialize)
+	defer release()
+
+	if err := limitRowSizes(ig.databendIngesterCfg, columns, batchData); err != nil {
+		return "", "", 0, retry.Unrecoverable(err)
+	}
+	boolColumns, err := ig.booleanColumns()
+	if err != nil {
+		return "", "", 0, err
+	}
+	normalizeBooleans(boolColumns, columns, batchData)
+
+	serializeStartTime := time.Now()
+	fileName, bytesSize, err := source.GenerateJSONFile(ig.databendIngesterCfg, columns, batchData)
 	if err != nil {
 		l.Errorf("generate NDJson file failed: %v\n", err)
-		return err
+		return "", "", 0, err
+	}
+	sum, err := fileSHA256(fileName)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	if ig.databendIngesterCfg.StageCompression == "gzip" {
+		fileName, err = gzipFile(fileName)
+		if err != nil {
+			return "", "", 0, err
+		}
 	}
 
-	stage, err := ig.uploadToStage(fileName)
+	if len(ig.databendIngesterCfg.StageEncryptionKeys) > 0 {
+		fileName, err = encryptFile(ig.databendIngesterCfg, fileName)
+		if err != nil {
+			return "", "", 0, retry.Unrecoverable(err)
+		}
+	}
+
+	RecordPhase(PhaseSerialize, time.Since(serializeStartTime))
+	return fileName, sum, bytesSize, nil
+}
+
+// loadBatch uploads fileName to the stage under name and copies it into the
+// target table, in the upload pool. An encrypted file is the archive copy of
+// the batch, kept under stageEncryptionPath: COPY can't read it, batchData is
+// inserted instead.
+func (ig *databendIngester) loadBatch(threadNum int, name BatchName, fileName string, columns []string, batchData [][]interface{}) (*godatabend.StageLocation, error) {
+	l := logrus.WithFields(logrus.Fields{"ingest_databend": "IngestData"})
+	release := acquireStage(stagePools.upload)
+	defer release()
+
+	uploadStartTime := time.Now()
+	stage, err := ig.uploadToStage(fileName, name)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	RecordPhase(PhaseUpload, time.Since(uploadStartTime))
 
 	copyIntoStartTime := time.Now()
-	err = ig.copyInto(stage)
+	if len(ig.databendIngesterCfg.StageEncryptionKeys) > 0 {
+		// no plain batch is staged, the copy is never loaded nor purged
+		_, err = ig.insertRows(columns, batchData)
+	} else {
+		err = ig.copyInto(stage)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
+	RecordPhase(PhaseCopy, time.Since(copyIntoStartTime))
 	l.Infof("thread-%d: copy into cost: %v ms", threadNum, time.Since(copyIntoStartTime).Milliseconds())
-	ig.statsRecorder.RecordMetric(bytesSize, len(batchData))
-	stats := ig.statsRecorder.Stats(time.Since(startTime))
-	log.Printf("thread-%d: ingest %d rows (%f rows/s), %d bytes (%f bytes/s)", threadNum,
-		len(batchData), stats.RowsPerSecondd, bytesSize, stats.BytesPerSecond)
-	return nil
+	return stage, nil
+}
+
+func fileSHA256(fileName string) (string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := copyBuffer(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// GetSnapshotID returns the id of the latest snapshot of the target table,
+// which pins the table as it was right after the archive.
+func (ig *databendIngester) GetSnapshotID() (string, error) {
+	db, err := openDB(ig.databendIngesterCfg)
+	if err != nil {
+		return "", err
+	}
+	database, table := "default", ig.databendIngesterCfg.DatabendTable
+	if parts := strings.SplitN(table, ".", 2); len(parts) == 2 {
+		database, table = parts[0], parts[1]
+	}
+	var snapshotID string
+	err = db.QueryRow(fmt.Sprintf("SELECT snapshot_id FROM FUSE_SNAPSHOT('%s', '%s') ORDER BY timestamp DESC LIMIT 1",
+		database, table)).Scan(&snapshotID)
+	if err != nil {
+		return "", err
+	}
+	return snapshotID, nil
 }
 
-func (ig *databendIngester) uploadToStage(fileName string) (*godatabend.StageLocation, error) {
+func (ig *databendIngester) uploadToStage(fileName string, name BatchName) (*godatabend.StageLocation, error) {
 	defer func() {
 		err := os.RemoveAll(fileName)
 		if err != nil {
@@ -111,47 +274,52 @@ func (ig *databendIngester) uploadToStage(fileName string) (*godatabend.StageLoc
 		}
 	}()
 
-	databendConfig, err := godatabend.ParseDSN(ig.databendIngesterCfg.DatabendDSN)
+	driver, err := driverFor(ig.databendIngesterCfg)
 	if err != nil {
 		return nil, err
 	}
-	apiClient := godatabend.NewAPIClientFromConfig(databendConfig)
 	fi, err := os.Stat(fileName)
 	if err != nil {
 		return nil, errors.Wrap(err, "get batch file size failed")
 	}
 	size := fi.Size()
+	var md5sum string
+	if ig.databendIngesterCfg.VerifyStagedFiles {
+		if md5sum, err = fileMD5(fileName); err != nil {
+			return nil, errors.Wrap(err, "hash batch file failed")
+		}
+	}
 
 	f, err := os.Open(fileName)
 	if err != nil {
 		return nil, errors.Wrap(err, "open batch file failed")
 	}
 	defer f.Close()
-	input := bufio.NewReader(f)
 	stage := &godatabend.StageLocation{
 		Name: ig.databendIngesterCfg.UserStage,
-		Path: fmt.Sprintf("batch/%d-%s", time.Now().Unix(), filepath.Base(fileName)),
+		Path: stagePath(ig.databendIngesterCfg, name, fileName, time.Now()),
 	}
 
-	presignedStartTime := time.Now()
-	presigned, err := apiClient.GetPresignedURL(context.Background(), stage)
-	if err != nil {
-		return nil, errors.Wrap(ErrGetPresignUrl, err.Error())
+	if err := driver.Upload(ig.databendIngesterCfg, stage, f, size); err != nil {
+		return nil, err
 	}
-	logrus.Infof("get presigned url cost: %v ms", time.Since(presignedStartTime).Milliseconds())
 
-	uploadByPresignedUrl := time.Now()
-	if err := ig.UploadToStageByPresignURL(presigned, input, size); err != nil {
-		return nil, errors.Wrap(ErrUploadStageFailed, err.Error())
+	if ig.databendIngesterCfg.VerifyStagedFiles {
+		if err := ig.verifyStagedFile(stage, size, md5sum); err != nil {
+			return nil, err
+		}
 	}
-	logrus.Infof("upload by presigned url cost: %v ms", time.Since(uploadByPresignedUrl).Milliseconds())
-
 	return stage, nil
 }
 
-func (ig *databendIngester) UploadToStageByPresignURL(presignedResp *godatabend.PresignedResponse, input *bufio.Reader, size int64) error {
+// uploadToStageByPresignURL PUTs size bytes of input to the presigned URL,
+// input is closed when it implements io.Closer.
+func uploadToStageByPresignURL(presignedResp *godatabend.PresignedResponse, input io.Reader, size int64) error {
 	req, err := http.NewRequest("PUT", presignedResp.URL, input)
 	if err != nil {
+		if c, ok := input.(io.Closer); ok {
+			c.Close()
+		}
 		return err
 	}
 	for k, v := range presignedResp.Headers {
@@ -160,7 +328,8 @@ func (ig *databendIngester) UploadToStageByPresignURL(presignedResp *godatabend.
 	req.ContentLength = size
 	// TODO: configurable timeout
 	httpClient := &http.Client{
-		Timeout: time.Second * 120,
+		Timeout:   time.Second * 120,
+		Transport: stageTransport,
 	}
 	resp, err := httpClient.Do(req)
 	if err != nil {
@@ -179,21 +348,53 @@ func (ig *databendIngester) UploadToStageByPresignURL(presignedResp *godatabend.
 	return nil
 }
 
+// copyInto copies the staged file into the target table, along with the
+// files staged at the same time by other threads when copyMaxFiles is set.
 func (ig *databendIngester) copyInto(stage *godatabend.StageLocation) error {
-	copyIntoSQL := fmt.Sprintf("COPY INTO %s FROM %s FILE_FORMAT = (type = NDJSON missing_field_as = FIELD_DEFAULT COMPRESSION = AUTO) "+
-		"PURGE = %v FORCE = %v DISABLE_VARIANT_CHECK = %v", ig.databendIngesterCfg.DatabendTable, stage.String(),
-		ig.databendIngesterCfg.CopyPurge, ig.databendIngesterCfg.CopyForce, ig.databendIngesterCfg.DisableVariantCheck)
-	db, err := sql.Open("databend", ig.databendIngesterCfg.DatabendDSN)
+	if ig.databendIngesterCfg.CopyMaxFiles > 1 {
+		return copyGroups.copy(ig, stage)
+	}
+	return ig.copyFiles(stage.Name, []string{stage.Path})
+}
+
+// copyFiles copies the files at paths of stage name into the target table
+// with one COPY INTO.
+func (ig *databendIngester) copyFiles(name string, paths []string) error {
+	copyIntoSQL := copyIntoStatement(ig.databendIngesterCfg, name, paths)
+	if err := faults.Inject(faults.Copy); err != nil {
+		return errors.Wrap(ErrCopyIntoFailed, err.Error())
+	}
+	db, err := openDB(ig.databendIngesterCfg)
 	if err != nil {
 		logrus.Errorf("init db error: %v", err)
 		return err
 	}
-	if err := execute(db, copyIntoSQL); err != nil {
-		return errors.Wrap(ErrCopyIntoFailed, err.Error())
+	waitForWarehouse(ig.databendIngesterCfg)
+	loadsInFlight.Add(1)
+	defer loadsInFlight.Add(-1)
+	startTime := time.Now()
+	err = execute(db, copyIntoSQL)
+	addLoadTime(time.Since(startTime))
+	if err != nil {
+		return copyError(err)
 	}
 	return nil
 }
 
+func copyIntoStatement(cfg *config.Config, name string, paths []string) string {
+	from := (&godatabend.StageLocation{Name: name, Path: paths[0]}).String()
+	if len(paths) > 1 {
+		quoted := make([]string, len(paths))
+		for i, p := range paths {
+			quoted[i] = "'" + strings.ReplaceAll(p, "'", "''") + "'"
+		}
+		from = fmt.Sprintf("@%s FILES = (%s)", name, strings.Join(quoted, ", "))
+	}
+	return fmt.Sprintf("COPY INTO %s FROM %s FILE_FORMAT = (type = NDJSON missing_field_as = FIELD_DEFAULT COMPRESSION = AUTO) "+
+		"PURGE = %v FORCE = %v DISABLE_VARIANT_CHECK = %v", cfg.DatabendTable, from,
+		cfg.CopyPurge, cfg.CopyForce, cfg.DisableVariantCheck)
+}
+
 func execute(db *sql.DB, sql string) error {
 	_, err := db.Exec(sql)
 	if err != nil {
@@ -208,8 +409,9 @@ func (ig *databendIngester) DoRetry(f retry.RetryableFunc) error {
 	maxDelay := 60 * time.Minute
 	maxAttempts := 500
 	attempt := 0
+	var loop RetryLoop
 
-	return retry.Do(
+	err := retry.Do(
 		func() error {
 			err := f()
 			if err != nil {
@@ -229,7 +431,7 @@ func (ig *databendIngester) DoRetry(f retry.RetryableFunc) error {
 			if errors.Is(err, ErrUploadStageFailed) ||
 				errors.Is(err, ErrCopyIntoFailed) ||
 				errors.Is(err, ErrGetPresignUrl) {
-				return true
+				return loop.Retry()
 			}
 			return false
 		}),
@@ -238,4 +440,5 @@ func (ig *databendIngester) DoRetry(f retry.RetryableFunc) error {
 		retry.DelayType(retry.BackOffDelay),
 		retry.Attempts(uint(maxAttempts)),
 	)
+	return loop.Done(err)
 }
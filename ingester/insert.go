@@ -0,0 +1,165 @@
+package ingester
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// insertStatements renders rows as multi-row INSERT statements into the
+// target table of cfg of at most insertChunkBytes each, unless a single row
+// is larger. Empty rows are skipped.
+func insertStatements(cfg *config.Config, columns []string, rows [][]interface{}) ([]string, error) {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = source.QuoteIdentifier(column)
+	}
+	prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", cfg.DatabendTable, strings.Join(quoted, ", "))
+	var statements []string
+	var query, row strings.Builder
+	for _, values := range rows {
+		if len(values) == 0 {
+			continue
+		}
+		row.Reset()
+		row.WriteByte('(')
+		for i, v := range values {
+			if i > 0 {
+				row.WriteString(", ")
+			}
+			literal, err := sqlLiteral(source.FormatFloat(cfg, v))
+			if err != nil {
+				return nil, err
+			}
+			row.WriteString(literal)
+		}
+		row.WriteByte(')')
+		if query.Len() > 0 && cfg.InsertChunkBytes > 0 && int64(query.Len()+2+row.Len()) > cfg.InsertChunkBytes {
+			statements = append(statements, query.String())
+			query.Reset()
+		}
+		if query.Len() == 0 {
+			query.WriteString(prefix)
+		} else {
+			query.WriteString(", ")
+		}
+		query.WriteString(row.String())
+	}
+	if query.Len() > 0 {
+		statements = append(statements, query.String())
+	}
+	return statements, nil
+}
+
+// insertRows loads rows with INSERT statements over the Databend connection,
+// used instead of COPY INTO with ingestMode insert, and when the staged files
+// are encrypted since COPY can't read them. The statements of a batch run in
+// one transaction when Databend has them, so a retried batch isn't inserted
+// twice in part. It returns the bytes of the statements.
+func (ig *databendIngester) insertRows(columns []string, rows [][]interface{}) (int, error) {
+	statements, err := insertStatements(ig.databendIngesterCfg, columns, rows)
+	if err != nil {
+		return 0, retry.Unrecoverable(err)
+	}
+	if len(statements) == 0 {
+		return 0, nil
+	}
+	size := 0
+	for _, statement := range statements {
+		size += len(statement)
+	}
+	waitForWarehouse(ig.databendIngesterCfg)
+	loadsInFlight.Add(1)
+	defer loadsInFlight.Add(-1)
+	startTime := time.Now()
+	// not logged on failure, the statements hold the plain rows
+	err = ExecAll(ig.databendIngesterCfg, statements...)
+	addLoadTime(time.Since(startTime))
+	if err != nil {
+		return 0, copyError(err)
+	}
+	return size, nil
+}
+
+// insertBatch loads batchData with INSERT statements in the upload pool,
+// for ingestMode insert: nothing is staged.
+func (ig *databendIngester) insertBatch(threadNum int, columns []string, batchData [][]interface{}) (int, error) {
+	if err := limitRowSizes(ig.databendIngesterCfg, columns, batchData); err != nil {
+		return 0, retry.Unrecoverable(err)
+	}
+	boolColumns, err := ig.booleanColumns()
+	if err != nil {
+		return 0, err
+	}
+	normalizeBooleans(boolColumns, columns, batchData)
+
+	release := acquireStage(stagePools.upload)
+	defer release()
+	insertStartTime := time.Now()
+	size, err := ig.insertRows(columns, batchData)
+	if err != nil {
+		return 0, err
+	}
+	RecordPhase(PhaseCopy, time.Since(insertStartTime))
+	logrus.Infof("thread-%d: insert cost: %v ms", threadNum, time.Since(insertStartTime).Milliseconds())
+	return size, nil
+}
+
+// sqlLiteral renders a value read from a source as a Databend literal,
+// objects and arrays are inserted as JSON strings into VARIANT columns.
+func sqlLiteral(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "NULL", nil
+	case bool:
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	case *big.Int:
+		return v.String(), nil
+	case string:
+		return quoteString(v), nil
+	case []byte:
+		return quoteString(string(v)), nil
+	case time.Time:
+		return quoteString(v.Format("2006-01-02 15:04:05.999999")), nil
+	case json.RawMessage:
+		return quoteString(string(v)), nil
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", errors.Wrapf(err, "unsupported value %T", v)
+		}
+		return quoteString(string(data)), nil
+	}
+}
+
+func quoteString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+}
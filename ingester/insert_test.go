@@ -0,0 +1,58 @@
+package ingester
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestInsertStatements(t *testing.T) {
+	cfg := &config.Config{DatabendTable: "archive.orders"}
+	rows := [][]interface{}{{int64(1), "a"}, {}, {int64(2), nil}, {int64(3), "it's"}}
+	statements, err := insertStatements(cfg, []string{"id", "name"}, rows)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"INSERT INTO archive.orders (id, name) VALUES (1, 'a'), (2, NULL), (3, 'it\\'s')"}, statements)
+
+	// 53 bytes with the first row, 64 with the second
+	cfg.InsertChunkBytes = 64
+	statements, err = insertStatements(cfg, []string{"id", "name"}, rows)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"INSERT INTO archive.orders (id, name) VALUES (1, 'a'), (2, NULL)",
+		"INSERT INTO archive.orders (id, name) VALUES (3, 'it\\'s')",
+	}, statements)
+
+	// a row larger than the chunk still gets its statement
+	cfg.InsertChunkBytes = 10
+	statements, err = insertStatements(cfg, []string{"id", "name"}, rows)
+	assert.NoError(t, err)
+	assert.Len(t, statements, 3)
+
+	statements, err = insertStatements(cfg, []string{"id"}, [][]interface{}{{}})
+	assert.NoError(t, err)
+	assert.Empty(t, statements)
+}
+
+func TestSQLLiteral(t *testing.T) {
+	for _, c := range []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, "NULL"},
+		{true, "TRUE"},
+		{int64(-3), "-3"},
+		{uint64(18446744073709551615), "18446744073709551615"},
+		{1.5, "1.5"},
+		{json.Number("12.30"), "12.30"},
+		{`it's a \ path`, `'it\'s a \\ path'`},
+		{json.RawMessage(`{"a":1}`), `'{"a":1}'`},
+		{[]interface{}{int64(1), "b"}, `'[1,"b"]'`},
+	} {
+		literal, err := sqlLiteral(c.value)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, literal)
+	}
+}
@@ -0,0 +1,84 @@
+package ingester
+
+import (
+	"sync"
+	"time"
+)
+
+// Phase is a step every batch goes through on its way to the target table.
+type Phase string
+
+const (
+	PhaseRead      Phase = "read"      // querying the source
+	PhaseSerialize Phase = "serialize" // writing, compressing and encrypting the batch file
+	PhaseUpload    Phase = "upload"    // uploading the batch file to the stage
+	PhaseCopy      Phase = "copy"      // COPY INTO the target table
+)
+
+// Phases are the phases of a batch in order.
+var Phases = []Phase{PhaseRead, PhaseSerialize, PhaseUpload, PhaseCopy}
+
+// PhaseStats aggregates the timings of a phase over the batches of a job.
+type PhaseStats struct {
+	Phase   Phase         `json:"phase"`
+	Batches int           `json:"batches"`
+	Total   time.Duration `json:"total"`
+	Max     time.Duration `json:"max"`
+}
+
+func (s PhaseStats) Mean() time.Duration {
+	if s.Batches == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Batches)
+}
+
+var phaseTimings = struct {
+	mu    sync.Mutex
+	stats map[Phase]*PhaseStats
+}{stats: make(map[Phase]*PhaseStats)}
+
+// RecordPhase adds the time a batch spent in phase.
+func RecordPhase(phase Phase, d time.Duration) {
+	phaseTimings.mu.Lock()
+	defer phaseTimings.mu.Unlock()
+	s, ok := phaseTimings.stats[phase]
+	if !ok {
+		s = &PhaseStats{Phase: phase}
+		phaseTimings.stats[phase] = s
+	}
+	s.Batches++
+	s.Total += d
+	if d > s.Max {
+		s.Max = d
+	}
+}
+
+// PhaseBreakdown is the aggregate of every phase so far, in the order of
+// Phases.
+func PhaseBreakdown() []PhaseStats {
+	phaseTimings.mu.Lock()
+	defer phaseTimings.mu.Unlock()
+	result := make([]PhaseStats, 0, len(Phases))
+	for _, phase := range Phases {
+		s := PhaseStats{Phase: phase}
+		if recorded, ok := phaseTimings.stats[phase]; ok {
+			s = *recorded
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+// Bottleneck is the phase the batches spent the most time in, empty when
+// none was recorded.
+func Bottleneck(breakdown []PhaseStats) Phase {
+	var bottleneck Phase
+	var longest time.Duration
+	for _, s := range breakdown {
+		if s.Total > longest {
+			bottleneck, longest = s.Phase, s.Total
+		}
+	}
+	return bottleneck
+}
@@ -0,0 +1,29 @@
+package ingester
+
+import (
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestPhaseBreakdown(t *testing.T) {
+	RecordPhase(PhaseRead, 30*time.Millisecond)
+	RecordPhase(PhaseRead, 10*time.Millisecond)
+	RecordPhase(PhaseUpload, 50*time.Millisecond)
+
+	breakdown := PhaseBreakdown()
+	assert.Equal(t, len(Phases), len(breakdown))
+	read := breakdown[0]
+	assert.Equal(t, PhaseRead, read.Phase)
+	assert.True(t, read.Batches >= 2)
+	assert.True(t, read.Max >= 30*time.Millisecond)
+	assert.Equal(t, read.Total/time.Duration(read.Batches), read.Mean())
+
+	assert.Equal(t, PhaseUpload, Bottleneck([]PhaseStats{
+		{Phase: PhaseRead, Total: 40 * time.Millisecond},
+		{Phase: PhaseUpload, Total: 50 * time.Millisecond},
+		{Phase: PhaseCopy},
+	}))
+	assert.Equal(t, Phase(""), Bottleneck(nil))
+}
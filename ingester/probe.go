@@ -0,0 +1,29 @@
+package ingester
+
+import (
+	"os"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// CheckStageAccess stages a small probe file the way batches are staged,
+// then removes it, to tell whether a job can stage its batches.
+func CheckStageAccess(cfg *config.Config) error {
+	f, err := os.CreateTemp(cfg.TempDir, "bend-archiver-probe-*.ndjson")
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString("{}\n")
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	ig := &databendIngester{databendIngesterCfg: cfg, statsRecorder: NewDatabendIntesterStatsRecorder()}
+	// uploadToStage removes the local file
+	stage, err := ig.uploadToStage(f.Name(), BatchName{})
+	if err != nil {
+		return err
+	}
+	return Exec(cfg, "REMOVE "+stage.String())
+}
@@ -0,0 +1,37 @@
+package ingester
+
+import (
+	"fmt"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// CopyStagedFiles loads staged batch files into table again, they are only
+// kept in the stage when the job ran with copyPurge false. COPY skips the
+// files it already loaded into table, so an interrupted restore can be run
+// again.
+func CopyStagedFiles(cfg *config.Config, table string, stages []string) error {
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	for _, stage := range stages {
+		copyIntoSQL := fmt.Sprintf("COPY INTO %s FROM %s FILE_FORMAT = (type = NDJSON missing_field_as = FIELD_DEFAULT COMPRESSION = AUTO) "+
+			"PURGE = false DISABLE_VARIANT_CHECK = %v", table, stage, cfg.DisableVariantCheck)
+		if err := execute(db, copyIntoSQL); err != nil {
+			return fmt.Errorf("restore %s: %w", stage, err)
+		}
+	}
+	return nil
+}
+
+// CopyParquet loads the Parquet files under location, e.g. an export at
+// @archive_stage/orders/, into table.
+func CopyParquet(cfg *config.Config, table, location string) error {
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	return execute(db, fmt.Sprintf("COPY INTO %s FROM %s PATTERN = '.*[.]parquet' FILE_FORMAT = (type = PARQUET) PURGE = false",
+		table, location))
+}
@@ -0,0 +1,80 @@
+package ingester
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/errcode"
+)
+
+// retryBudget bounds the retries of the whole job, both in number and in
+// the time spent retrying, unlimited until ConfigureRetryBudget.
+var retryBudget = struct {
+	mu         sync.Mutex
+	maxRetries int
+	maxTime    time.Duration
+	retries    int
+	elapsed    time.Duration
+	exhausted  error
+}{}
+
+// ConfigureRetryBudget sets the retry budget of the job to retryBudget and
+// retryBudgetTime of cfg and resets what was spent.
+func ConfigureRetryBudget(cfg *config.Config) {
+	retryBudget.mu.Lock()
+	defer retryBudget.mu.Unlock()
+	retryBudget.maxRetries = cfg.RetryBudget
+	retryBudget.maxTime, _ = time.ParseDuration(cfg.RetryBudgetTime)
+	retryBudget.retries, retryBudget.elapsed, retryBudget.exhausted = 0, 0, nil
+}
+
+// RetryLoop spends the retry budget of the job on the retries of one
+// operation. The time from its first failure to its end counts as time
+// spent retrying.
+type RetryLoop struct {
+	last time.Time // zero until the first failure
+	err  error
+}
+
+// Retry reports whether the budget allows another attempt after a failed
+// one, once it is exhausted no loop of the job retries anymore.
+func (l *RetryLoop) Retry() bool {
+	now := time.Now()
+	retryBudget.mu.Lock()
+	defer retryBudget.mu.Unlock()
+	if !l.last.IsZero() {
+		retryBudget.elapsed += now.Sub(l.last)
+	}
+	l.last = now
+	if retryBudget.exhausted == nil {
+		switch {
+		case retryBudget.maxRetries > 0 && retryBudget.retries >= retryBudget.maxRetries:
+			retryBudget.exhausted = fmt.Errorf("%w: %d retries", errcode.ErrRetryBudget, retryBudget.retries)
+		case retryBudget.maxTime > 0 && retryBudget.elapsed >= retryBudget.maxTime:
+			retryBudget.exhausted = fmt.Errorf("%w: %v spent retrying", errcode.ErrRetryBudget, retryBudget.elapsed.Round(time.Second))
+		}
+	}
+	if retryBudget.exhausted != nil {
+		l.err = retryBudget.exhausted
+		return false
+	}
+	retryBudget.retries++
+	return true
+}
+
+// Done ends the loop, err is its outcome. It returns err with the verdict
+// of the budget when the budget stopped the retries.
+func (l *RetryLoop) Done(err error) error {
+	if !l.last.IsZero() {
+		retryBudget.mu.Lock()
+		retryBudget.elapsed += time.Since(l.last)
+		retryBudget.mu.Unlock()
+		l.last = time.Time{}
+	}
+	if err != nil && l.err != nil {
+		return fmt.Errorf("%w, last error: %w", l.err, err)
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+package ingester
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// limitRowSizes applies cfg.OversizedRowPolicy to the rows whose estimated
+// staged size exceeds cfg.MaxRowSize. Rows are changed in place: long strings
+// are truncated, and dead-lettered rows are emptied so they are neither
+// staged nor written twice when the batch is retried.
+func limitRowSizes(cfg *config.Config, columns []string, rows [][]interface{}) error {
+	if cfg.MaxRowSize <= 0 {
+		return nil
+	}
+	deadLettered := 0
+	for i, row := range rows {
+		size := rowSize(row)
+		if size <= cfg.MaxRowSize {
+			continue
+		}
+		switch cfg.OversizedRowPolicy {
+		case "truncate":
+			if !truncateRow(row, size-cfg.MaxRowSize) {
+				return fmt.Errorf("row of %d bytes exceeds maxRowSize %d even with its strings truncated", size, cfg.MaxRowSize)
+			}
+		case "deadletter":
+			reason := fmt.Sprintf("row of %d bytes exceeds maxRowSize %d", size, cfg.MaxRowSize)
+			if err := writeDeadLetter(cfg.DeadLetterFile, cfg.DatabendTable, reason, columns, row); err != nil {
+				return err
+			}
+			rows[i] = nil
+			deadLettered++
+		default:
+			return fmt.Errorf("row of %d bytes exceeds maxRowSize %d", size, cfg.MaxRowSize)
+		}
+	}
+	if deadLettered > 0 {
+		logrus.Warnf("%d oversized rows written to dead letter file %s", deadLettered, cfg.DeadLetterFile)
+	}
+	return nil
+}
+
+// rowSize estimates the bytes a row takes in the staged file.
+func rowSize(row []interface{}) int64 {
+	var size int64
+	for _, v := range row {
+		switch v := v.(type) {
+		case string:
+			size += int64(len(v))
+		case []byte:
+			size += int64(len(v))
+		case json.RawMessage:
+			size += int64(len(v))
+		case map[string]interface{}, []interface{}:
+			data, _ := json.Marshal(v)
+			size += int64(len(data))
+		default:
+			size += 8
+		}
+	}
+	return size
+}
+
+// SplitBatch splits rows into chunks of at most maxBytes estimated staged
+// bytes, a row larger than maxBytes is a chunk of its own. Rows are returned
+// as one chunk when maxBytes is 0.
+func SplitBatch(rows [][]interface{}, maxBytes int64) [][][]interface{} {
+	if maxBytes <= 0 {
+		return [][][]interface{}{rows}
+	}
+	var chunks [][][]interface{}
+	start, size := 0, int64(0)
+	for i, row := range rows {
+		n := rowSize(row)
+		if i > start && size+n > maxBytes {
+			chunks = append(chunks, rows[start:i])
+			start, size = i, 0
+		}
+		size += n
+	}
+	return append(chunks, rows[start:])
+}
+
+// truncateRow cuts excess bytes off the longest strings of row, it reports
+// false if the strings are too short to do so.
+func truncateRow(row []interface{}, excess int64) bool {
+	for excess > 0 {
+		longest := -1
+		for i, v := range row {
+			if s, ok := v.(string); ok && s != "" && (longest < 0 || len(s) > len(row[longest].(string))) {
+				longest = i
+			}
+		}
+		if longest < 0 {
+			return false
+		}
+		s := row[longest].(string)
+		keep := int64(len(s)) - excess
+		if keep < 0 {
+			keep = 0
+		}
+		// don't split a multi-byte character
+		for keep > 0 && !utf8.RuneStart(s[keep]) {
+			keep--
+		}
+		excess -= int64(len(s)) - keep
+		row[longest] = s[:keep]
+	}
+	return true
+}
+
+var deadLetterMu sync.Mutex
+
+type deadLetter struct {
+	Table  string                 `json:"table"`
+	Reason string                 `json:"reason"`
+	Row    map[string]interface{} `json:"row"`
+}
+
+// writeDeadLetter appends a row that can't be ingested to the NDJSON dead
+// letter file, shared by all threads.
+func writeDeadLetter(path, table, reason string, columns []string, row []interface{}) error {
+	record := deadLetter{Table: table, Reason: reason, Row: make(map[string]interface{}, len(columns))}
+	for i, column := range columns {
+		record.Row[column] = row[i]
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
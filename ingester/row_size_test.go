@@ -0,0 +1,52 @@
+package ingester
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestLimitRowSizesTruncate(t *testing.T) {
+	cfg := &config.Config{MaxRowSize: 20, OversizedRowPolicy: "truncate"}
+	rows := [][]interface{}{
+		{int64(1), "short"},
+		{int64(2), strings.Repeat("é", 10) + "abc", "x"},
+	}
+	assert.NoError(t, limitRowSizes(cfg, []string{"id", "a", "b"}, rows))
+	assert.Equal(t, []interface{}{int64(1), "short"}, rows[0])
+	assert.Equal(t, []interface{}{int64(2), strings.Repeat("é", 5), "x"}, rows[1])
+	assert.True(t, rowSize(rows[1]) <= 20)
+
+	cfg.OversizedRowPolicy = "fail"
+	err := limitRowSizes(cfg, []string{"id", "a"}, [][]interface{}{{int64(1), strings.Repeat("a", 30)}})
+	assert.EqualError(t, err, "row of 38 bytes exceeds maxRowSize 20")
+}
+
+func TestLimitRowSizesDeadLetter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.ndjson")
+	cfg := &config.Config{MaxRowSize: 10, OversizedRowPolicy: "deadletter", DeadLetterFile: path, DatabendTable: "db.t"}
+	rows := [][]interface{}{{"ok"}, {"much too long"}}
+	assert.NoError(t, limitRowSizes(cfg, []string{"a"}, rows))
+	assert.Equal(t, [][]interface{}{{"ok"}, nil}, rows)
+
+	// a retried batch does not write the row again
+	assert.NoError(t, limitRowSizes(cfg, []string{"a"}, rows))
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"table":"db.t","reason":"row of 13 bytes exceeds maxRowSize 10","row":{"a":"much too long"}}`+"\n", string(data))
+}
+
+func TestSplitBatch(t *testing.T) {
+	rows := [][]interface{}{{"aaaa"}, {"bbbb"}, {"cccccccccc"}, {"d"}, {"e"}}
+	assert.Equal(t, [][][]interface{}{rows}, SplitBatch(rows, 0))
+	assert.Equal(t, [][][]interface{}{
+		{{"aaaa"}, {"bbbb"}},
+		{{"cccccccccc"}},
+		{{"d"}, {"e"}},
+	}, SplitBatch(rows, 8))
+}
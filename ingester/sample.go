@@ -0,0 +1,36 @@
+package ingester
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// StagedForm is each row of rows as the job loads it into the target of
+// cfg: its NDJSON line, or its INSERT statement with ingestMode insert.
+// Booleans are normalized when the target columns can be read.
+func StagedForm(cfg *config.Config, columns []string, rows [][]interface{}) ([]string, error) {
+	ig := &databendIngester{databendIngesterCfg: cfg}
+	if boolColumns, err := ig.booleanColumns(); err == nil {
+		normalizeBooleans(boolColumns, columns, rows)
+	}
+	forms := make([]string, len(rows))
+	for i, row := range rows {
+		if cfg.IngestMode == "insert" {
+			statements, err := insertStatements(cfg, columns, [][]interface{}{row})
+			if err != nil {
+				return nil, err
+			}
+			forms[i] = strings.Join(statements, "\n")
+			continue
+		}
+		var buf bytes.Buffer
+		if _, err := source.EncodeNDJSON(cfg, &buf, columns, [][]interface{}{row}); err != nil {
+			return nil, err
+		}
+		forms[i] = strings.TrimSuffix(buf.String(), "\n")
+	}
+	return forms, nil
+}
@@ -0,0 +1,23 @@
+package ingester
+
+import (
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestStagedForm(t *testing.T) {
+	cfg := &config.Config{DatabendTable: "archive.orders", BooleanColumns: map[string]bool{"paid": true}, FloatPrecision: 2}
+	columns := []string{"id", "name", "paid", "amount"}
+	rows := [][]interface{}{{int64(1), "it's", "Y", 9.5}}
+	forms, err := StagedForm(cfg, columns, rows)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"amount":9.50,"id":1,"name":"it's","paid":true}`}, forms)
+
+	cfg.IngestMode = "insert"
+	forms, err = StagedForm(cfg, columns, rows)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"INSERT INTO archive.orders (id, name, paid, amount) VALUES (1, 'it\\'s', TRUE, 9.50)"}, forms)
+}
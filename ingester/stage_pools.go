@@ -0,0 +1,37 @@
+package ingester
+
+import (
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// stagePools bound how many batches of the job are serialized, and uploaded
+// and copied, at once, whatever thread read them. A nil pool is unbounded,
+// as every pool is until ConfigureStagePools.
+var stagePools struct {
+	serialize chan struct{}
+	upload    chan struct{}
+}
+
+// ConfigureStagePools sizes the serialize and upload pools of the job by
+// serializeThreads and uploadThreads of cfg, 0 leaves a pool unbounded.
+func ConfigureStagePools(cfg *config.Config) {
+	stagePools.serialize = newStagePool(cfg.SerializeThreads)
+	stagePools.upload = newStagePool(cfg.UploadThreads)
+}
+
+func newStagePool(threads int) chan struct{} {
+	if threads <= 0 {
+		return nil
+	}
+	return make(chan struct{}, threads)
+}
+
+// acquireStage waits for a free thread of pool and returns the func that
+// frees it.
+func acquireStage(pool chan struct{}) func() {
+	if pool == nil {
+		return func() {}
+	}
+	pool <- struct{}{}
+	return func() { <-pool }
+}
@@ -0,0 +1,94 @@
+package ingester
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// createOnlyOptions are the table options Databend only takes when a table
+// is created.
+var createOnlyOptions = map[string]bool{"compression": true, "storage_format": true}
+
+// TableOptionsSQL is the cluster key and the options of cfg appended to the
+// CREATE TABLE of a table the job creates.
+func TableOptionsSQL(cfg *config.Config) string {
+	var b strings.Builder
+	if cfg.TargetClusterBy != "" {
+		fmt.Fprintf(&b, " CLUSTER BY (%s)", cfg.TargetClusterBy)
+	}
+	for _, option := range tableOptions(cfg, true) {
+		b.WriteString(" " + option)
+	}
+	return b.String()
+}
+
+// ApplyTableOptions sets the cluster key and the options of cfg on the
+// existing databendTable, the options only taken at creation are skipped.
+// Bloom filters are set here, before the load, so that every block loaded
+// gets them.
+func ApplyTableOptions(cfg *config.Config) error {
+	if cfg.TargetClusterBy != "" {
+		if err := Exec(cfg, fmt.Sprintf("ALTER TABLE %s CLUSTER BY (%s)", cfg.DatabendTable, cfg.TargetClusterBy)); err != nil {
+			return err
+		}
+	}
+	for option := range cfg.TargetTableOptions {
+		if createOnlyOptions[strings.ToLower(option)] {
+			logrus.Warnf("table option %s of %s is only set when the table is created", option, cfg.DatabendTable)
+		}
+	}
+	if options := tableOptions(cfg, false); len(options) > 0 {
+		return Exec(cfg, fmt.Sprintf("ALTER TABLE %s SET OPTIONS (%s)", cfg.DatabendTable, strings.Join(options, ", ")))
+	}
+	return nil
+}
+
+// tableOptions are the name = 'value' options of cfg sorted by name, with
+// the options only taken at creation when create is true.
+func tableOptions(cfg *config.Config, create bool) []string {
+	var options []string
+	if len(cfg.TargetBloomIndexColumns) > 0 {
+		options = append(options, "bloom_index_columns = "+quoteString(strings.Join(cfg.TargetBloomIndexColumns, ",")))
+	}
+	for name, value := range cfg.TargetTableOptions {
+		if !create && createOnlyOptions[strings.ToLower(name)] {
+			continue
+		}
+		options = append(options, fmt.Sprintf("%s = %s", name, quoteString(value)))
+	}
+	sort.Strings(options)
+	return options
+}
+
+// CreateInvertedIndexes creates the inverted indexes of cfg on databendTable
+// if they don't exist and refreshes them, so that they cover the rows just
+// loaded.
+func CreateInvertedIndexes(cfg *config.Config) error {
+	if !CurrentFeatures().InvertedIndexes {
+		// warned about by ConfigureFeatures
+		return nil
+	}
+	names := make([]string, 0, len(cfg.TargetInvertedIndexes))
+	for name := range cfg.TargetInvertedIndexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		columns := cfg.TargetInvertedIndexes[name]
+		if err := Exec(cfg, fmt.Sprintf("CREATE INVERTED INDEX IF NOT EXISTS %s ON %s (%s)", name, cfg.DatabendTable, strings.Join(columns, ", "))); err != nil {
+			return fmt.Errorf("create inverted index %s: %w", name, err)
+		}
+		startTime := time.Now()
+		if err := Exec(cfg, fmt.Sprintf("REFRESH INVERTED INDEX %s ON %s", name, cfg.DatabendTable)); err != nil {
+			return fmt.Errorf("refresh inverted index %s: %w", name, err)
+		}
+		logrus.Infof("inverted index %s of %s refreshed in %v", name, cfg.DatabendTable, time.Since(startTime).Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package ingester
+
+import (
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestTableOptionsSQL(t *testing.T) {
+	cfg := &config.Config{
+		TargetClusterBy:    "to_yyyymmdd(created_at), user_id",
+		TargetTableOptions: map[string]string{"row_per_block": "500000", "compression": "zstd", "bloom_index_columns": "user_id"},
+	}
+	assert.Equal(t, " CLUSTER BY (to_yyyymmdd(created_at), user_id) bloom_index_columns = 'user_id' compression = 'zstd' row_per_block = '500000'",
+		TableOptionsSQL(cfg))
+	// compression can't be altered
+	assert.Equal(t, []string{"bloom_index_columns = 'user_id'", "row_per_block = '500000'"}, tableOptions(cfg, false))
+	assert.Equal(t, "", TableOptionsSQL(&config.Config{}))
+
+	cfg = &config.Config{TargetBloomIndexColumns: []string{"user_id", "order_no"}, TargetTableOptions: map[string]string{"block_per_segment": "500"}}
+	assert.Equal(t, []string{"block_per_segment = '500'", "bloom_index_columns = 'user_id,order_no'"}, tableOptions(cfg, false))
+}
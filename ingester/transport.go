@@ -0,0 +1,72 @@
+package ingester
+
+import (
+	"net/http"
+	"net/url"
+
+	godatabend "github.com/datafuselabs/databend-go"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// defaultTransport is http.DefaultTransport before it is wrapped.
+var defaultTransport = http.DefaultTransport
+
+// stageTransport sends the stage uploads, the default transport when nil.
+var stageTransport http.RoundTripper
+
+// ConfigureDatabendTransport makes the requests to the Databend host of
+// cfg.DatabendDSN use the Databend TLS config and proxy. databend-go ignores
+// its tls_config param and sends queries through http.DefaultTransport, so
+// the default transport is wrapped; other hosts keep the default settings,
+// except the presigned stage urls which go through the proxy too.
+func ConfigureDatabendTransport(cfg *config.Config) error {
+	tlsConfig, err := cfg.DatabendTLSConfig()
+	if err != nil {
+		return err
+	}
+	var proxy *url.URL
+	if cfg.DatabendProxy != "" {
+		proxy, err = url.Parse(cfg.DatabendProxy)
+		if err != nil {
+			return err
+		}
+	}
+	if tlsConfig == nil && proxy == nil {
+		return nil
+	}
+	databendConfig, err := godatabend.ParseDSN(cfg.DatabendDSN)
+	if err != nil {
+		return err
+	}
+	transport := defaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+		uploads := defaultTransport.(*http.Transport).Clone()
+		uploads.Proxy = http.ProxyURL(proxy)
+		stageTransport = uploads
+	}
+	http.DefaultTransport = &hostTransport{
+		host:      databendConfig.Host,
+		transport: transport,
+		fallback:  defaultTransport,
+	}
+	return nil
+}
+
+// hostTransport sends the requests to host through transport.
+type hostTransport struct {
+	host      string
+	transport http.RoundTripper
+	fallback  http.RoundTripper
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == t.host {
+		return t.transport.RoundTrip(req)
+	}
+	return t.fallback.RoundTrip(req)
+}
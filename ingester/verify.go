@@ -0,0 +1,95 @@
+package ingester
+
+import (
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	godatabend "github.com/datafuselabs/databend-go"
+	"github.com/pkg/errors"
+)
+
+func fileMD5(fileName string) (string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := copyBuffer(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyStagedFile lists the uploaded file in the stage and checks that it
+// has the size and MD5 of the local one, so a truncated upload fails the
+// batch before COPY loads part of it.
+func (ig *databendIngester) verifyStagedFile(stage *godatabend.StageLocation, size int64, md5sum string) error {
+	db, err := openDB(ig.databendIngesterCfg)
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(fmt.Sprintf("LIST %s", stage.String()))
+	if err != nil {
+		return errors.Wrap(err, "list staged file failed")
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	found := false
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		listed := make(map[string]string, len(columns))
+		for i, column := range columns {
+			listed[strings.ToLower(column)] = values[i].String
+		}
+		// the name is relative to the stage or to the listed path
+		name := listed["name"]
+		if name == "" || !strings.HasSuffix(stage.Path, name) && !strings.HasSuffix(name, stage.Path) {
+			continue
+		}
+		found = true
+		if err := checkStagedFile(listed, size, md5sum); err != nil {
+			return errors.Wrapf(ErrUploadStageFailed, "%s: %v", stage.String(), err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if !found {
+		return errors.Wrapf(ErrUploadStageFailed, "%s is not in the stage", stage.String())
+	}
+	return nil
+}
+
+// checkStagedFile compares a row of LIST with the local file. The md5 is the
+// ETag of S3 like storages, it is skipped when the storage reports none or
+// the ETag of a multipart upload, which is not an MD5 of the content.
+func checkStagedFile(listed map[string]string, size int64, md5sum string) error {
+	stagedSize, err := strconv.ParseInt(listed["size"], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid staged size %q", listed["size"])
+	}
+	if stagedSize != size {
+		return fmt.Errorf("staged %d bytes, uploaded %d", stagedSize, size)
+	}
+	stagedMD5 := strings.ToLower(strings.Trim(listed["md5"], `"`))
+	if stagedMD5 != "" && !strings.Contains(stagedMD5, "-") && stagedMD5 != md5sum {
+		return fmt.Errorf("staged md5 %s, uploaded %s", stagedMD5, md5sum)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package ingester
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestCheckStagedFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "batch.ndjson")
+	assert.NoError(t, os.WriteFile(fileName, []byte("{\"id\":1}\n"), 0o600))
+	sum, err := fileMD5(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, "0390b9c2b5c00b92c777809268f9127e", sum)
+
+	assert.NoError(t, checkStagedFile(map[string]string{"size": "9", "md5": `"` + sum + `"`}, 9, sum))
+	// no md5 from the storage, or the ETag of a multipart upload
+	assert.NoError(t, checkStagedFile(map[string]string{"size": "9", "md5": ""}, 9, sum))
+	assert.NoError(t, checkStagedFile(map[string]string{"size": "9", "md5": "9b2cf535f27731c974343645a3985328-2"}, 9, sum))
+
+	assert.EqualError(t, checkStagedFile(map[string]string{"size": "4", "md5": sum}, 9, sum), "staged 4 bytes, uploaded 9")
+	assert.Error(t, checkStagedFile(map[string]string{"size": "9", "md5": "00000000000000000000000000000000"}, 9, sum))
+}
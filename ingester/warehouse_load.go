@@ -0,0 +1,67 @@
+package ingester
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// warehouseLoad is the last load of the warehouse, shared by the threads of
+// the job so that one of them checks it per warehouseLoadInterval.
+var warehouseLoad struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	queries   int       // running queries of others
+	waiting   time.Time // since when the loads wait, zero when they don't
+}
+
+// loadsInFlight are the COPY INTO and INSERT of the job running, they are
+// not part of the load of others.
+var loadsInFlight atomic.Int64
+
+// countQueries runs the warehouseLoadQuery, replaced by the tests.
+var countQueries = QueryCount
+
+// waitForWarehouse waits while the warehouse of cfg runs more than
+// warehouseMaxQueries queries of others, a load that can't be checked
+// doesn't hold back the job.
+func waitForWarehouse(cfg *config.Config) {
+	if cfg.WarehouseMaxQueries == 0 {
+		return
+	}
+	interval, _ := time.ParseDuration(cfg.WarehouseLoadInterval)
+	for warehouseBusy(cfg, interval, time.Now()) {
+		time.Sleep(interval)
+	}
+}
+
+func warehouseBusy(cfg *config.Config, interval time.Duration, now time.Time) bool {
+	warehouseLoad.mu.Lock()
+	defer warehouseLoad.mu.Unlock()
+	if now.Sub(warehouseLoad.checkedAt) >= interval {
+		n, err := countQueries(cfg, cfg.WarehouseLoadQuery)
+		if err != nil {
+			logrus.Warnf("check the load of the warehouse failed: %v", err)
+			n = 0
+		}
+		// the load query itself runs too
+		warehouseLoad.queries = n - 1 - int(loadsInFlight.Load())
+		warehouseLoad.checkedAt = now
+	}
+	busy := warehouseLoad.queries > cfg.WarehouseMaxQueries
+	switch {
+	case busy && warehouseLoad.waiting.IsZero():
+		logrus.Warnf("warehouse busy with %d queries of others (warehouseMaxQueries %d), loads of %s wait",
+			warehouseLoad.queries, cfg.WarehouseMaxQueries, cfg.DatabendTable)
+		warehouseLoad.waiting = now
+	case !busy && !warehouseLoad.waiting.IsZero():
+		logrus.Infof("warehouse load down to %d queries of others, loads resume after %v",
+			warehouseLoad.queries, now.Sub(warehouseLoad.waiting).Round(time.Second))
+		warehouseLoad.waiting = time.Time{}
+	}
+	return busy
+}
@@ -0,0 +1,63 @@
+package ingester
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestWarehouseBusy(t *testing.T) {
+	defer func() {
+		countQueries = QueryCount
+		warehouseLoad.checkedAt, warehouseLoad.queries, warehouseLoad.waiting = time.Time{}, 0, time.Time{}
+	}()
+	running := 0
+	checks := 0
+	var err error
+	countQueries = func(cfg *config.Config, query string) (int, error) {
+		checks++
+		return running, err
+	}
+	cfg := &config.Config{WarehouseMaxQueries: 5, WarehouseLoadQuery: "SELECT 1"}
+	start := time.Now()
+
+	// 5 running queries of others besides the load query and one load of the job
+	running = 7
+	loadsInFlight.Add(1)
+	assert.False(t, warehouseBusy(cfg, time.Minute, start))
+	loadsInFlight.Add(-1)
+
+	running = 8
+	// checked once per interval
+	assert.False(t, warehouseBusy(cfg, time.Minute, start.Add(30*time.Second)))
+	assert.Equal(t, 1, checks)
+	assert.True(t, warehouseBusy(cfg, time.Minute, start.Add(time.Minute)))
+	assert.Equal(t, 2, checks)
+
+	// an unknown load doesn't hold the job back
+	err = errors.New("unknown column command")
+	assert.False(t, warehouseBusy(cfg, time.Minute, start.Add(2*time.Minute)))
+	assert.True(t, warehouseLoad.waiting.IsZero())
+}
+
+func TestWaitForWarehouse(t *testing.T) {
+	defer func() {
+		countQueries = QueryCount
+		warehouseLoad.checkedAt, warehouseLoad.queries, warehouseLoad.waiting = time.Time{}, 0, time.Time{}
+	}()
+	loads := []int{20, 20, 3}
+	countQueries = func(cfg *config.Config, query string) (int, error) {
+		n := loads[0]
+		loads = loads[1:]
+		return n, nil
+	}
+	waitForWarehouse(&config.Config{WarehouseMaxQueries: 5, WarehouseLoadQuery: "SELECT 1", WarehouseLoadInterval: "1ms"})
+	assert.Empty(t, loads)
+
+	// off without warehouseMaxQueries
+	waitForWarehouse(&config.Config{})
+}
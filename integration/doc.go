@@ -0,0 +1,7 @@
+// Package integration runs the bend-archiver binary against the databases
+// of docker-compose.yml, one job per source of the matrix, and checks what
+// it archived in Databend. The tests are built with the integration tag:
+//
+//	docker compose -f integration/docker-compose.yml up -d --wait
+//	go test -tags integration -v ./integration
+package integration
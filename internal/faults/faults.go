@@ -0,0 +1,123 @@
+// Package faults injects failures into the stage uploads, the COPY INTO
+// statements and the source reads of a job, to test its retries and
+// checkpoints. It is off unless BEND_ARCHIVER_FAULTS is set, e.g.
+// upload=0.1,copy=0.05,query=0.2 fails a tenth of the uploads, one COPY in
+// twenty and a fifth of the reads. BEND_ARCHIVER_FAULT_SEED seeds the draws,
+// so that a run fails the same calls as the previous one with the same
+// seed and the same order of calls.
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Point is where a fault is injected.
+type Point string
+
+const (
+	Upload Point = "upload"
+	Copy   Point = "copy"
+	Query  Point = "query"
+)
+
+// ErrInjected is the error of an injected fault, it is retried like the
+// transient errors of its point.
+var ErrInjected = errors.New("injected fault")
+
+var state = struct {
+	sync.Mutex
+	rates map[Point]float64
+	calls map[Point]map[int]bool
+	count map[Point]int
+	rand  *rand.Rand
+}{
+	rates: make(map[Point]float64),
+	calls: make(map[Point]map[int]bool),
+	count: make(map[Point]int),
+	rand:  rand.New(rand.NewSource(1)),
+}
+
+func init() {
+	spec := os.Getenv("BEND_ARCHIVER_FAULTS")
+	if spec == "" {
+		return
+	}
+	seed := int64(1)
+	if s := os.Getenv("BEND_ARCHIVER_FAULT_SEED"); s != "" {
+		var err error
+		if seed, err = strconv.ParseInt(s, 10, 64); err != nil {
+			panic(fmt.Sprintf("BEND_ARCHIVER_FAULT_SEED %q is not a number", s))
+		}
+	}
+	if err := Configure(spec, seed); err != nil {
+		panic(fmt.Sprintf("BEND_ARCHIVER_FAULTS: %v", err))
+	}
+	logrus.Warnf("fault injection is on: %s, seed %d", spec, seed)
+}
+
+// Configure sets the rate of the faults of each point of spec, like
+// upload=0.1,copy=0.05, and seeds their draws. The points left out of spec
+// don't fail.
+func Configure(spec string, seed int64) error {
+	rates := make(map[Point]float64)
+	for _, part := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		point := Point(name)
+		if !ok || (point != Upload && point != Copy && point != Query) {
+			return fmt.Errorf("%q is not upload=, copy= or query= a rate", part)
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return fmt.Errorf("the rate of %s must be between 0 and 1, got %q", point, value)
+		}
+		rates[point] = rate
+	}
+	Reset()
+	state.Lock()
+	defer state.Unlock()
+	state.rates = rates
+	state.rand = rand.New(rand.NewSource(seed))
+	return nil
+}
+
+// FailCalls fails the calls of point with the given numbers, counted from 1
+// since the last Reset, for tests that need a failure at an exact step.
+func FailCalls(point Point, calls ...int) {
+	state.Lock()
+	defer state.Unlock()
+	if state.calls[point] == nil {
+		state.calls[point] = make(map[int]bool)
+	}
+	for _, n := range calls {
+		state.calls[point][n] = true
+	}
+}
+
+// Reset turns every fault off and restarts the counts of calls.
+func Reset() {
+	state.Lock()
+	defer state.Unlock()
+	state.rates = make(map[Point]float64)
+	state.calls = make(map[Point]map[int]bool)
+	state.count = make(map[Point]int)
+}
+
+// Inject counts a call of point and returns ErrInjected when it is to fail.
+func Inject(point Point) error {
+	state.Lock()
+	defer state.Unlock()
+	state.count[point]++
+	n := state.count[point]
+	if state.calls[point][n] || (state.rates[point] > 0 && state.rand.Float64() < state.rates[point]) {
+		return fmt.Errorf("%w: %s call %d", ErrInjected, point, n)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package faults
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestConfigure(t *testing.T) {
+	defer Reset()
+	assert.Error(t, Configure("upload", 1))
+	assert.Error(t, Configure("stage=0.1", 1))
+	assert.Error(t, Configure("copy=2", 1))
+
+	draws := func() []bool {
+		assert.NoError(t, Configure("upload=0.5, copy=0", 7))
+		var failed []bool
+		for i := 0; i < 20; i++ {
+			failed = append(failed, Inject(Upload) != nil)
+			assert.NoError(t, Inject(Copy))
+			assert.NoError(t, Inject(Query))
+		}
+		return failed
+	}
+	first := draws()
+	assert.Contains(t, first, true)
+	assert.Contains(t, first, false)
+	// the same seed fails the same calls
+	assert.Equal(t, first, draws())
+}
+
+func TestFailCalls(t *testing.T) {
+	Reset()
+	defer Reset()
+	FailCalls(Copy, 2, 3)
+	assert.NoError(t, Inject(Copy))
+	err := Inject(Copy)
+	assert.True(t, errors.Is(err, ErrInjected))
+	assert.EqualError(t, err, "injected fault: copy call 2")
+	assert.Error(t, Inject(Copy))
+	assert.NoError(t, Inject(Copy))
+	assert.NoError(t, Inject(Upload))
+}
@@ -0,0 +1,117 @@
+// Package hooks runs the hooks of a job at its lifecycle events: a command
+// gets the JSON of the event on stdin, a webhook gets it POSTed. A hook that
+// fails or times out is logged, it never fails the job.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/errcode"
+)
+
+const (
+	JobStart      = "job_start"
+	TableComplete = "table_complete"
+	BatchFailed   = "batch_failed"
+	JobEnd        = "job_end"
+)
+
+// Event is the payload of a hook.
+type Event struct {
+	Event    string       `json:"event"`
+	JobID    string       `json:"jobId,omitempty"`
+	Tenant   string       `json:"tenant,omitempty"`
+	RunID    string       `json:"runId,omitempty"`
+	Time     time.Time    `json:"time"`
+	Source   string       `json:"source"`           // databaseType of the job
+	Target   string       `json:"target,omitempty"` // Databend table
+	Table    string       `json:"table,omitempty"`  // db.table or path of the source, for table_complete and batch_failed
+	Batch    string       `json:"batch,omitempty"`  // range or file of the failed batch
+	Rows     int64        `json:"rows,omitempty"`   // rows ingested of the table
+	Status   string       `json:"status,omitempty"` // succeeded, partial or failed, for table_complete and job_end
+	Code     errcode.Code `json:"code,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Duration string       `json:"duration,omitempty"` // of the job, for job_end
+}
+
+// Fire runs the hooks of cfg at event, one after the other, each for at most
+// hookTimeout. The job fields of event are set from cfg.
+func Fire(cfg *config.Config, event Event) {
+	if len(cfg.Hooks) == 0 {
+		return
+	}
+	event.JobID, event.RunID, event.Tenant, event.Source = cfg.JobID, cfg.RunID, cfg.Tenant, cfg.DatabaseType
+	if event.Target == "" {
+		event.Target = cfg.DatabendTable
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		logrus.Errorf("encode %s hook event failed: %v", event.Event, err)
+		return
+	}
+	timeout, _ := time.ParseDuration(cfg.HookTimeout)
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	for _, h := range cfg.Hooks {
+		if len(h.Events) > 0 && !slices.Contains(h.Events, event.Event) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if len(h.Command) > 0 {
+			if err := runCommand(ctx, h.Command, event.Event, data); err != nil {
+				logrus.Warnf("%s hook %s failed: %v", event.Event, strings.Join(h.Command, " "), err)
+			}
+		}
+		if h.URL != "" {
+			if err := post(ctx, h.URL, data); err != nil {
+				logrus.Warnf("%s hook %s failed: %v", event.Event, h.URL, err)
+			}
+		}
+		cancel()
+	}
+}
+
+// runCommand runs command with data on stdin and the event in
+// BEND_ARCHIVER_EVENT, its output goes to the logs.
+func runCommand(ctx context.Context, command []string, event string, data []byte) error {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(cmd.Environ(), "BEND_ARCHIVER_EVENT="+event)
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		logrus.Infof("%s hook %s: %s", event, command[0], bytes.TrimSpace(out))
+	}
+	return err
+}
+
+func post(ctx context.Context, url string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return nil
+}
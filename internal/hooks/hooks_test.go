@@ -0,0 +1,75 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/errcode"
+)
+
+func TestFire(t *testing.T) {
+	var posted []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var event Event
+		if err := json.Unmarshal(data, &event); err != nil {
+			t.Errorf("decode posted event: %v", err)
+		}
+		posted = append(posted, event)
+	}))
+	defer server.Close()
+	out := filepath.Join(t.TempDir(), "event.json")
+	cfg := &config.Config{
+		JobID:         "job",
+		RunID:         "run",
+		DatabaseType:  "mysql",
+		DatabendTable: "db.orders",
+		Hooks: []config.Hook{
+			{Events: []string{JobEnd}, Command: []string{"sh", "-c", `cat > "$0"; printf '\n%s\n' "$BEND_ARCHIVER_EVENT" >> "$0"`, out}},
+			{URL: server.URL},
+		},
+	}
+
+	Fire(cfg, Event{Event: TableComplete, Table: "src.orders", Rows: 10, Status: "succeeded", Code: errcode.OK})
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("the job_end command ran at table_complete")
+	}
+	Fire(cfg, Event{Event: JobEnd, Status: "failed", Code: errcode.CopyFailed, Error: "copy failed"})
+
+	if len(posted) != 2 || posted[0].Event != TableComplete || posted[0].Rows != 10 || posted[1].Event != JobEnd {
+		t.Fatalf("posted %+v, want the table_complete and job_end events", posted)
+	}
+	if posted[0].JobID != "job" || posted[0].RunID != "run" || posted[0].Source != "mysql" || posted[0].Target != "db.orders" {
+		t.Errorf("the job fields of %+v are not set", posted[0])
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event Event
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&event); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+	if event.Event != JobEnd || event.Code != errcode.CopyFailed || event.Error != "copy failed" {
+		t.Errorf("command got %+v", event)
+	}
+	if want := "\n" + JobEnd + "\n"; string(data[len(data)-len(want):]) != want {
+		t.Errorf("BEND_ARCHIVER_EVENT of the command is not %s: %s", JobEnd, data)
+	}
+}
+
+func TestFireFailingHook(t *testing.T) {
+	cfg := &config.Config{
+		HookTimeout: "100ms",
+		Hooks:       []config.Hook{{Command: []string{"sleep", "10"}}, {Command: []string{"false"}}},
+	}
+	// the hooks are logged, the caller goes on
+	Fire(cfg, Event{Event: JobStart})
+}
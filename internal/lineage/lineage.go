@@ -0,0 +1,151 @@
+// Package lineage emits the OpenLineage run events of a job, so that its
+// source tables or files and its Databend table show up in the lineage graph
+// of Marquez or any other OpenLineage backend. Datasets are named after the
+// OpenLineage naming conventions, e.g. mysql://host:3306 and db.table.
+package lineage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	godatabend "github.com/datafuselabs/databend-go"
+	"github.com/google/uuid"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+const (
+	Start    = "START"
+	Complete = "COMPLETE"
+	Fail     = "FAIL"
+	Abort    = "ABORT"
+)
+
+const (
+	producer  = "https://github.com/databendcloud/bend-archiver"
+	schemaURL = "https://openlineage.io/spec/2-0-2/OpenLineage.json#/definitions/RunEvent"
+	facetURL  = "https://openlineage.io/spec/facets/1-0-1/ErrorMessageRunFacet.json#/$defs/ErrorMessageRunFacet"
+)
+
+// Dataset is an input or output of a run.
+type Dataset struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// RunEvent is an OpenLineage run event.
+type RunEvent struct {
+	EventType string    `json:"eventType"`
+	EventTime time.Time `json:"eventTime"`
+	Run       struct {
+		RunID  string                 `json:"runId"`
+		Facets map[string]interface{} `json:"facets,omitempty"`
+	} `json:"run"`
+	Job struct {
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	} `json:"job"`
+	Inputs    []Dataset `json:"inputs"`
+	Outputs   []Dataset `json:"outputs"`
+	Producer  string    `json:"producer"`
+	SchemaURL string    `json:"schemaURL"`
+}
+
+// NewRunEvent is the event of eventType of the run of cfg, reading inputs
+// into the target table. errMessage, when set, is the error of a FAIL.
+func NewRunEvent(cfg *config.Config, eventType string, inputs []Dataset, errMessage string) RunEvent {
+	event := RunEvent{
+		EventType: eventType,
+		EventTime: time.Now().UTC(),
+		Inputs:    inputs,
+		Outputs:   []Dataset{TargetDataset(cfg)},
+		Producer:  producer,
+		SchemaURL: schemaURL,
+	}
+	// OpenLineage run ids are UUIDs, the same one for every event of a run
+	event.Run.RunID = uuid.NewSHA1(uuid.NameSpaceURL, []byte(producer+"/runs/"+cfg.RunID)).String()
+	if errMessage != "" {
+		event.Run.Facets = map[string]interface{}{
+			"errorMessage": map[string]string{
+				"_producer":           producer,
+				"_schemaURL":          facetURL,
+				"message":             errMessage,
+				"programmingLanguage": "go",
+			},
+		}
+	}
+	event.Job.Namespace = cfg.OpenLineageNamespace
+	event.Job.Name = cfg.OpenLineageJobName
+	if event.Job.Name == "" {
+		event.Job.Name = cfg.DatabendTable
+	}
+	return event
+}
+
+// schemes are the OpenLineage namespace schemes of the database types that
+// differ from their name.
+var schemes = map[string]string{
+	"pg":    "postgres",
+	"mssql": "sqlserver",
+}
+
+// SourceDataset is the source table of cfg, db.table, or its files.
+func SourceDataset(cfg *config.Config, table string) Dataset {
+	scheme := cfg.DatabaseType
+	if s, ok := schemes[scheme]; ok {
+		scheme = s
+	}
+	host := cfg.SourceHost
+	if host == "" {
+		// local files
+		host, _ = os.Hostname()
+	} else if cfg.SourcePort != 0 && !strings.Contains(host, ",") {
+		host = fmt.Sprintf("%s:%d", host, cfg.SourcePort)
+	}
+	if cfg.IsFileSource() {
+		return Dataset{Namespace: scheme + "://" + host, Name: cfg.SourcePath}
+	}
+	return Dataset{Namespace: scheme + "://" + host, Name: table}
+}
+
+// TargetDataset is the Databend table of cfg.
+func TargetDataset(cfg *config.Config) Dataset {
+	host := "unknown"
+	if databendConfig, err := godatabend.ParseDSN(cfg.DatabendDSN); err == nil {
+		host = databendConfig.Host
+	}
+	return Dataset{Namespace: "databend://" + host, Name: cfg.DatabendTable}
+}
+
+// Emit POSTs event to the openLineageURL of cfg.
+func Emit(ctx context.Context, cfg *config.Config, event RunEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.OpenLineageURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.OpenLineageAPIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+os.ExpandEnv(cfg.OpenLineageAPIKey))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return nil
+}
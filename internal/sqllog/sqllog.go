@@ -0,0 +1,302 @@
+// Package sqllog logs the statements run on the SQL sources and on Databend,
+// with their parameters, once Configure turns it on with logSQL. Credentials
+// and the values of the logSQLMaskColumns columns are redacted.
+package sqllog
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// Redacted replaces what is left out of the logged statements.
+const Redacted = "***"
+
+var settings struct {
+	enabled bool
+	masks   []*mask
+}
+
+// mask finds the values of a masked column in a statement: the literals and
+// lists compared to it, and the placeholders of its parameters.
+type mask struct {
+	literal *regexp.Regexp
+	list    *regexp.Regexp
+	param   *regexp.Regexp
+}
+
+// Configure turns the logging of the statements of the databases opened
+// from now on on or off, by logSQL of cfg.
+func Configure(cfg *config.Config) {
+	settings.enabled = cfg.LogSQL
+	settings.masks = nil
+	for _, column := range cfg.LogSQLMaskColumns {
+		name := "[`\"\\[]?" + regexp.QuoteMeta(column) + "[`\"\\]]?"
+		settings.masks = append(settings.masks, &mask{
+			literal: regexp.MustCompile(`(?i)(\b` + name + `\s*(?:=|<>|!=|<=|>=|<|>|\bLIKE\b)\s*)('(?:[^']|'')*'|-?\d+(?:\.\d+)?)`),
+			list:    regexp.MustCompile(`(?i)(\b` + name + `\s+(?:NOT\s+)?IN\s*)\([^)]*\)`),
+			param:   regexp.MustCompile(`(?i)\b` + name + `\s*(?:=|<>|!=|<=|>=|<|>|\bLIKE\b)\s*(\?|\$\d+|:\d+|@p\d+)`),
+		})
+	}
+}
+
+// Open opens a database like sql.Open, its statements are logged when
+// Configure turned the logging on.
+func Open(driverName, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil || !settings.enabled {
+		return db, err
+	}
+	d := db.Driver()
+	db.Close()
+	var c driver.Connector = dsnConnector{driver: d, dsn: dsn}
+	if dc, ok := d.(driver.DriverContext); ok {
+		if c, err = dc.OpenConnector(dsn); err != nil {
+			return nil, err
+		}
+	}
+	return sql.OpenDB(connector{Connector: c, name: driverName}), nil
+}
+
+// OpenDB opens a database like sql.OpenDB, its statements are logged when
+// Configure turned the logging on.
+func OpenDB(c driver.Connector, name string) *sql.DB {
+	if !settings.enabled {
+		return sql.OpenDB(c)
+	}
+	return sql.OpenDB(connector{Connector: c, name: name})
+}
+
+// credentials are the secrets statements may hold, like the keys of a stage
+// or the password of a user.
+var credentials = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`(?i)(\b(?:password|access_key_id|secret_access_key|session_token|security_token|master_key|aws_key_id|aws_secret_key|secret|token)\s*=\s*)(?:'(?:[^']|'')*'|"[^"]*")`), "${1}'" + Redacted + "'"},
+	{regexp.MustCompile(`(?i)(\bIDENTIFIED\s+BY\s+)(?:'(?:[^']|'')*'|"[^"]*")`), "${1}'" + Redacted + "'"},
+	{regexp.MustCompile(`(://[^/\s:@']+:)[^@\s']+(@)`), "${1}" + Redacted + "${2}"},
+	{regexp.MustCompile(`(?i)([?&](?:x-amz-signature|x-amz-credential|x-amz-security-token|sig|signature)=)[^&\s']+`), "${1}" + Redacted},
+}
+
+// insertValues finds the rows of INSERT ... VALUES statements.
+var insertValues = regexp.MustCompile(`(?is)^(\s*(?:/\*.*?\*/\s*)?INSERT\s.*?\bVALUES\b).*$`)
+
+// Redact returns statement without its credentials, the values of the
+// masked columns and the rows it inserts, and args without the parameters
+// of the masked columns.
+func Redact(statement string, args []interface{}) (string, []interface{}) {
+	masked := make(map[int]bool)
+	for _, m := range settings.masks {
+		for _, loc := range m.param.FindAllStringSubmatchIndex(statement, -1) {
+			masked[paramIndex(statement, loc[2], loc[3])] = true
+		}
+	}
+	if len(masked) > 0 {
+		args = append([]interface{}(nil), args...)
+		for i := range args {
+			if masked[i] {
+				args[i] = Redacted
+			}
+		}
+	}
+	for _, c := range credentials {
+		statement = c.re.ReplaceAllString(statement, c.repl)
+	}
+	for _, m := range settings.masks {
+		statement = m.literal.ReplaceAllString(statement, "${1}'"+Redacted+"'")
+		statement = m.list.ReplaceAllString(statement, "${1}("+Redacted+")")
+	}
+	if loc := insertValues.FindStringSubmatchIndex(statement); loc != nil && loc[3] < len(statement) {
+		statement = fmt.Sprintf("%s %s (%d bytes of rows)", statement[:loc[3]], Redacted, len(statement)-loc[3])
+	}
+	return statement, args
+}
+
+// paramIndex is the index in the args of the placeholder at
+// statement[start:end]: $n, :n and @pn are numbered, ? is counted.
+func paramIndex(statement string, start, end int) int {
+	placeholder := statement[start:end]
+	if placeholder != "?" {
+		n, _ := strconv.Atoi(strings.TrimLeft(placeholder, "$:@pP"))
+		return n - 1
+	}
+	return strings.Count(statement[:start], "?")
+}
+
+func logStatement(name, statement string, args []driver.NamedValue) {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	statement, values = Redact(statement, values)
+	entry := logrus.WithField("db", name)
+	if len(values) > 0 {
+		entry = entry.WithField("args", fmt.Sprint(values...))
+	}
+	entry.Infof("sql: %s", statement)
+}
+
+// connector opens connections whose statements are logged.
+type connector struct {
+	driver.Connector
+	name string
+}
+
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: cn, name: c.name}, nil
+}
+
+// dsnConnector is the connector of drivers without one.
+type dsnConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+func (c dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// conn logs the statements run on a connection, prepared or not, and
+// forwards the optional interfaces of the driver.
+type conn struct {
+	driver.Conn
+	name string
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	s, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: s, name: c.name, query: query}, nil
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	pc, ok := c.Conn.(driver.ConnPrepareContext)
+	if !ok {
+		return c.Prepare(query)
+	}
+	s, err := pc.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: s, name: c.name, query: query}, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		// logged by the prepared statement
+		return nil, driver.ErrSkip
+	}
+	rows, err := qc.QueryContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		logStatement(c.name, query, args)
+	}
+	return rows, err
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	result, err := ec.ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		logStatement(c.name, query, args)
+	}
+	return result, err
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if bc, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return bc.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) || opts.ReadOnly {
+		return nil, fmt.Errorf("the %s driver does not support transaction options", c.name)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// stmt logs the runs of a prepared statement.
+type stmt struct {
+	driver.Stmt
+	name  string
+	query string
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	logStatement(s.name, s.query, args)
+	if sc, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return sc.ExecContext(ctx, args)
+	}
+	return s.Stmt.Exec(values(args))
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	logStatement(s.name, s.query, args)
+	if sc, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return sc.QueryContext(ctx, args)
+	}
+	return s.Stmt.Query(values(args))
+}
+
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	vs := make([]driver.Value, len(args))
+	for i, arg := range args {
+		vs[i] = arg.Value
+	}
+	return vs
+}
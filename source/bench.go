@@ -0,0 +1,204 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+var errSplitKeyNotSupportedByBench = errors.New("split keys are not supported by the bench source")
+
+var defaultBenchColumns = []string{"id:int", "name:string:1000", "amount:float", "created_at:time:86400"}
+
+// benchEpoch is the first value of the time columns.
+var benchEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type benchColumn struct {
+	name        string
+	typ         string
+	cardinality uint64
+}
+
+// BenchSource generates BenchRows synthetic rows for the bench subcommand.
+// Values are derived from the row number, so every run generates the same
+// rows and the threads need no shared state.
+type BenchSource struct {
+	cfg           *config.Config
+	columns       []benchColumn
+	statsRecorder *DatabendSourceStatsRecorder
+}
+
+func NewBenchSource(cfg *config.Config) (*BenchSource, error) {
+	specs := cfg.BenchColumns
+	if len(specs) == 0 {
+		specs = defaultBenchColumns
+	}
+	columns, err := parseBenchColumns(specs)
+	if err != nil {
+		return nil, err
+	}
+	return &BenchSource{
+		cfg:           cfg,
+		columns:       columns,
+		statsRecorder: NewDatabendIntesterStatsRecorder(),
+	}, nil
+}
+
+// parseBenchColumns parses name:type[:cardinality] column specs.
+func parseBenchColumns(specs []string) ([]benchColumn, error) {
+	var columns []benchColumn
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid bench column %q, should be name:type[:cardinality]", spec)
+		}
+		column := benchColumn{name: parts[0], typ: parts[1]}
+		switch column.typ {
+		case "int", "float", "string", "bool", "time":
+		default:
+			return nil, fmt.Errorf("invalid type of bench column %q, should be int, float, string, bool or time", spec)
+		}
+		if len(parts) == 3 {
+			cardinality, err := strconv.ParseUint(parts[2], 10, 64)
+			if err != nil || cardinality == 0 {
+				return nil, fmt.Errorf("invalid cardinality of bench column %q", spec)
+			}
+			column.cardinality = cardinality
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// CreateTableSQL is the DDL of a Databend table the generated rows fit in.
+func (s *BenchSource) CreateTableSQL(table string) string {
+	types := map[string]string{
+		"int":    "BIGINT",
+		"float":  "DOUBLE",
+		"string": "VARCHAR",
+		"bool":   "BOOLEAN",
+		"time":   "TIMESTAMP",
+	}
+	var columns []string
+	for _, column := range s.columns {
+		columns = append(columns, fmt.Sprintf("%s %s", column.name, types[column.typ]))
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(columns, ", "))
+}
+
+// value is the value of column in row n, one of cardinality values picked by
+// a hash of n, or n itself when the column has no cardinality.
+func (c benchColumn) value(col int, n uint64) interface{} {
+	v := n
+	if c.cardinality > 0 {
+		v = mix(n*31+uint64(col)) % c.cardinality
+	}
+	switch c.typ {
+	case "int":
+		return int64(v)
+	case "float":
+		return float64(v) / 100
+	case "string":
+		return fmt.Sprintf("%s-%d", c.name, v)
+	case "bool":
+		return v%2 == 1
+	default:
+		return benchEpoch.Add(time.Duration(v) * time.Second).Format("2006-01-02 15:04:05")
+	}
+}
+
+// mix is the splitmix64 finalizer, it spreads consecutive row numbers over
+// the values of a column.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// ReadSlice generates the rows of the slice-th of slices contiguous ranges of
+// row numbers, in batches of BatchSize rows.
+func (s *BenchSource) ReadSlice(ctx context.Context, slice, slices int, fn func(columns []string, rows [][]interface{}) error) error {
+	total := uint64(s.cfg.BenchRows)
+	start := total * uint64(slice) / uint64(slices)
+	end := total * uint64(slice+1) / uint64(slices)
+	columns := make([]string, len(s.columns))
+	for i, column := range s.columns {
+		columns[i] = column.name
+	}
+	startTime := time.Now()
+	for n := start; n < end; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batchEnd := n + uint64(s.cfg.BatchSize)
+		if batchEnd > end {
+			batchEnd = end
+		}
+		rows := make([][]interface{}, 0, batchEnd-n)
+		for ; n < batchEnd; n++ {
+			row := make([]interface{}, len(s.columns))
+			for i, column := range s.columns {
+				// row numbers start at 1 like an auto increment key
+				row[i] = column.value(i, n+1)
+			}
+			rows = append(rows, row)
+		}
+		s.statsRecorder.RecordBatch(s.cfg, slice, rows)
+		stats := s.statsRecorder.Stats(time.Since(startTime))
+		log.Printf("thread-%d: generated %d rows (%f rows/s)", slice, len(rows), stats.RowsPerSecondd)
+		if err := fn(columns, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BenchSource) AdjustBatchSizeAccordingToSourceDbTable(ctx context.Context) uint64 {
+	return uint64(s.cfg.BatchSize)
+}
+
+func (s *BenchSource) GetSourceReadRowsCount(ctx context.Context) (int, error) {
+	return int(s.cfg.BenchRows), nil
+}
+
+func (s *BenchSource) GetMinMaxSplitKey(ctx context.Context) (uint64, uint64, error) {
+	return 0, 0, errSplitKeyNotSupportedByBench
+}
+
+func (s *BenchSource) GetMinMaxTimeSplitKey(ctx context.Context) (string, string, error) {
+	return "", "", errSplitKeyNotSupportedByBench
+}
+
+func (s *BenchSource) DeleteAfterSync(ctx context.Context) error {
+	return nil
+}
+
+func (s *BenchSource) QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+	return nil, nil, errSplitKeyNotSupportedByBench
+}
+
+func (s *BenchSource) GetDatabasesAccordingToSourceDbRegex(ctx context.Context, sourceDatabasePattern string) ([]string, error) {
+	return []string{s.cfg.SourceDB}, nil
+}
+
+func (s *BenchSource) GetTablesAccordingToSourceTableRegex(ctx context.Context, sourceTablePattern string, databases []string) (map[string][]string, error) {
+	return map[string][]string{s.cfg.SourceDB: {s.cfg.SourceTable}}, nil
+}
+
+func (s *BenchSource) GetAllSourceReadRowsCount(ctx context.Context) (int, error) {
+	return s.GetSourceReadRowsCount(ctx)
+}
+
+func (s *BenchSource) GetDbTablesAccordingToSourceDbTables(ctx context.Context) (map[string][]string, error) {
+	return map[string][]string{s.cfg.SourceDB: {s.cfg.SourceTable}}, nil
+}
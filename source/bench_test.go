@@ -0,0 +1,47 @@
+package source
+
+import (
+	"context"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestBenchSource(t *testing.T) {
+	cfg := &config.Config{BenchRows: 10, BatchSize: 4, BenchColumns: []string{"id:int", "tier:string:3", "at:time:60", "ok:bool"}}
+	src, err := NewBenchSource(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE IF NOT EXISTS db.bench (id BIGINT, tier VARCHAR, at TIMESTAMP, ok BOOLEAN)",
+		src.CreateTableSQL("db.bench"))
+
+	read := func() [][]interface{} {
+		var all [][]interface{}
+		for slice := 0; slice < 3; slice++ {
+			err := src.ReadSlice(context.Background(), slice, 3, func(columns []string, rows [][]interface{}) error {
+				assert.Equal(t, []string{"id", "tier", "at", "ok"}, columns)
+				assert.True(t, len(rows) <= 4)
+				all = append(all, rows...)
+				return nil
+			})
+			assert.NoError(t, err)
+		}
+		return all
+	}
+	rows := read()
+	assert.Equal(t, 10, len(rows))
+	tiers := make(map[interface{}]bool)
+	for i, row := range rows {
+		assert.Equal(t, int64(i+1), row[0])
+		tiers[row[1]] = true
+		assert.True(t, row[2].(string) < "2024-01-01 00:01:00")
+	}
+	assert.True(t, len(tiers) <= 3)
+	assert.Equal(t, rows, read())
+
+	for _, spec := range []string{"id", "id:uuid", "id:int:0", ":int"} {
+		_, err := NewBenchSource(&config.Config{BenchColumns: []string{spec}})
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,136 @@
+package source
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bookmark is the position of the binlog of a MySQL source, or the TSO of a
+// TiDB one, a snapshot was read at, for a CDC pipeline to start from where
+// the archive ends.
+type Bookmark struct {
+	File     string    `json:"file,omitempty"`     // binlog file
+	Position uint64    `json:"position,omitempty"` // offset in file
+	GTIDSet  string    `json:"gtidSet,omitempty"`  // executed GTID set, empty without gtid_mode
+	TSO      uint64    `json:"tso,omitempty"`      // TiDB timestamp of the snapshot
+	Exact    bool      `json:"exact"`              // false when changes after it may be in the snapshot already
+	ReadAt   time.Time `json:"readAt"`
+}
+
+func (b Bookmark) String() string {
+	var s string
+	if b.TSO != 0 {
+		s = fmt.Sprintf("TSO %d", b.TSO)
+	} else {
+		s = fmt.Sprintf("binlog %s:%d", b.File, b.Position)
+		if b.GTIDSet != "" {
+			s += ", GTID set " + b.GTIDSet
+		}
+	}
+	if !b.Exact {
+		s += " (read before the snapshot)"
+	}
+	return s
+}
+
+// Bookmarker is implemented by the sources that record the Bookmark of
+// their snapshot with sourceBookmark, nil until it is read.
+type Bookmarker interface {
+	Bookmark() *Bookmark
+}
+
+// startBookmarkedSnapshot starts a consistent snapshot transaction on conn
+// and returns where it was started. TiDB tells the timestamp of the
+// snapshot; MySQL can only tell the position of the binlog exactly while no
+// transaction commits, under FLUSH TABLES WITH READ LOCK with
+// sourceBookmarkLock, otherwise it is read just before the snapshot starts,
+// and a CDC pipeline starting from it may replay changes the archive has.
+func (s *MysqlSource) startBookmarkedSnapshot(ctx context.Context, conn *sql.Conn) (*Bookmark, error) {
+	b := &Bookmark{ReadAt: time.Now().UTC()}
+	if s.cfg.DatabaseType == "tidb" {
+		if _, err := conn.ExecContext(ctx, "START TRANSACTION WITH CONSISTENT SNAPSHOT"); err != nil {
+			return nil, err
+		}
+		if err := conn.QueryRowContext(ctx, "SELECT @@tidb_current_ts").Scan(&b.TSO); err != nil {
+			return nil, fmt.Errorf("read the TSO of the snapshot: %w", err)
+		}
+		b.Exact = true
+		return b, nil
+	}
+	if s.cfg.SourceBookmarkLock {
+		if _, err := conn.ExecContext(ctx, "FLUSH TABLES WITH READ LOCK"); err != nil {
+			return nil, fmt.Errorf("FLUSH TABLES WITH READ LOCK, it needs the RELOAD privilege: %w", err)
+		}
+		// START TRANSACTION doesn't release the global read lock
+		defer conn.ExecContext(context.WithoutCancel(ctx), "UNLOCK TABLES")
+		b.Exact = true
+	}
+	rows, err := conn.QueryContext(ctx, "SHOW MASTER STATUS")
+	if err != nil {
+		// MySQL 8.4 only knows the new name
+		rows, err = conn.QueryContext(ctx, "SHOW BINARY LOG STATUS")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read the binlog position: %w", err)
+	}
+	err = scanBinlogStatus(rows, b)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "START TRANSACTION WITH CONSISTENT SNAPSHOT"); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// scanBinlogStatus reads the File, Position and Executed_Gtid_Set of the
+// row of SHOW MASTER STATUS into b, older servers have no GTID column.
+func scanBinlogStatus(rows *sql.Rows, b *Bookmark) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return errors.New("binary logging is disabled on the source, there is no position to record")
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return err
+	}
+	return binlogStatus(columns, values, b)
+}
+
+func binlogStatus(columns []string, values []sql.NullString, b *Bookmark) error {
+	for i, column := range columns {
+		switch column {
+		case "File":
+			b.File = values[i].String
+		case "Position":
+			position, err := strconv.ParseUint(values[i].String, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid binlog position %q", values[i].String)
+			}
+			b.Position = position
+		case "Executed_Gtid_Set":
+			// long sets are wrapped over lines
+			b.GTIDSet = strings.ReplaceAll(values[i].String, "\n", "")
+		}
+	}
+	if b.File == "" {
+		return errors.New("binary logging is disabled on the source, there is no position to record")
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package source
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestBinlogStatus(t *testing.T) {
+	columns := []string{"File", "Position", "Binlog_Do_DB", "Binlog_Ignore_DB", "Executed_Gtid_Set"}
+	values := []sql.NullString{{String: "binlog.000042", Valid: true}, {String: "1234", Valid: true}, {}, {},
+		{String: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5,\n4f22ab58-71ca-11e1-9e33-c80aa9429562:1-3", Valid: true}}
+	var b Bookmark
+	assert.NoError(t, binlogStatus(columns, values, &b))
+	assert.Equal(t, "binlog.000042", b.File)
+	assert.Equal(t, uint64(1234), b.Position)
+	assert.Equal(t, "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5,4f22ab58-71ca-11e1-9e33-c80aa9429562:1-3", b.GTIDSet)
+	assert.Equal(t, "binlog binlog.000042:1234, GTID set 3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5,"+
+		"4f22ab58-71ca-11e1-9e33-c80aa9429562:1-3 (read before the snapshot)", b.String())
+
+	// servers without GTIDs
+	b = Bookmark{Exact: true}
+	assert.NoError(t, binlogStatus(columns[:4], values[:4], &b))
+	assert.Equal(t, "binlog binlog.000042:1234", b.String())
+
+	// binary logging disabled
+	assert.Error(t, binlogStatus(columns, make([]sql.NullString, len(columns)), &Bookmark{}))
+	assert.Equal(t, "TSO 449537362051203073", Bookmark{TSO: 449537362051203073, Exact: true}.String())
+}
@@ -0,0 +1,374 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/gocql/gocql"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/inf.v0"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+var errSplitKeyNotSupportedByCassandra = errors.New("split keys are not supported by the cassandra source")
+
+// CassandraSource archives Cassandra/ScyllaDB tables. The token ring is cut
+// into CassandraTokenRanges ranges that are spread over the threads, and
+// every range is paged through on its own so a failed page is retried
+// without reading the range again.
+type CassandraSource struct {
+	cfg           *config.Config
+	session       *gocql.Session
+	consistency   gocql.Consistency
+	statsRecorder *DatabendSourceStatsRecorder
+}
+
+func NewCassandraSource(cfg *config.Config) (*CassandraSource, error) {
+	stats := NewDatabendIntesterStatsRecorder()
+	consistencyName := cfg.CassandraConsistency
+	if consistencyName == "" {
+		consistencyName = "LOCAL_QUORUM"
+	}
+	consistency, err := gocql.ParseConsistencyWrapper(consistencyName)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CassandraTokenRanges <= 0 {
+		cfg.CassandraTokenRanges = 16 * cfg.MaxThread
+	}
+
+	hosts := strings.Split(cfg.SourceHost, ",")
+	for i := range hosts {
+		hosts[i] = strings.TrimSpace(hosts[i])
+	}
+	cluster := gocql.NewCluster(hosts...)
+	if cfg.SourcePort != 0 {
+		cluster.Port = cfg.SourcePort
+	}
+	if cfg.SourceUser != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{Username: cfg.SourceUser, Password: cfg.SourcePass}
+	}
+	cluster.Consistency = consistency
+	cluster.Timeout = 30 * time.Second
+	session, err := cluster.CreateSession()
+	if err != nil {
+		logrus.Errorf("failed to connect to cassandra: %v", err)
+		return nil, err
+	}
+	return &CassandraSource{
+		cfg:           cfg,
+		session:       session,
+		consistency:   consistency,
+		statsRecorder: stats,
+	}, nil
+}
+
+func (s *CassandraSource) AdjustBatchSizeAccordingToSourceDbTable(ctx context.Context) uint64 {
+	return uint64(s.cfg.BatchSize)
+}
+
+// GetSourceReadRowsCount counts range by range, a single COUNT(*) over a
+// large table would time out.
+func (s *CassandraSource) GetSourceReadRowsCount(ctx context.Context) (int, error) {
+	tokenExpr, err := s.tokenExpr()
+	if err != nil {
+		return 0, err
+	}
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s >= ? AND %s <= ?%s",
+		s.tableName(), tokenExpr, tokenExpr, s.whereCondition())
+	total := 0
+	for _, r := range tokenRanges(s.cfg.CassandraTokenRanges) {
+		var count int64
+		err := s.session.Query(query, r[0], r[1]).WithContext(ctx).Consistency(s.consistency).Scan(&count)
+		if err != nil {
+			return 0, err
+		}
+		total += int(count)
+	}
+	return total, nil
+}
+
+func (s *CassandraSource) GetMinMaxSplitKey(ctx context.Context) (uint64, uint64, error) {
+	return 0, 0, errSplitKeyNotSupportedByCassandra
+}
+
+func (s *CassandraSource) GetMinMaxTimeSplitKey(ctx context.Context) (string, string, error) {
+	return "", "", errSplitKeyNotSupportedByCassandra
+}
+
+// DeleteAfterSync truncates the archived tables. CQL can not delete by an
+// arbitrary filter, so it refuses to run when sourceWhereCondition is set.
+func (s *CassandraSource) DeleteAfterSync(ctx context.Context) error {
+	if !s.cfg.DeleteAfterSync {
+		return nil
+	}
+	if s.cfg.SourceWhereCondition != "" {
+		return errors.New("deleteAfterSync with sourceWhereCondition is not supported by the cassandra source")
+	}
+	dbTables, err := s.GetDbTablesAccordingToSourceDbTables(ctx)
+	if err != nil {
+		return err
+	}
+	for db, tables := range dbTables {
+		for _, table := range tables {
+			query := fmt.Sprintf("TRUNCATE %s.%s", quoteCQLIdentifier(db), quoteCQLIdentifier(table))
+			if err := s.session.Query(query).WithContext(ctx).Consistency(gocql.All).Exec(); err != nil {
+				return err
+			}
+			logrus.Infof("truncated archived table %s.%s", db, table)
+		}
+	}
+	return nil
+}
+
+func (s *CassandraSource) QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+	return nil, nil, errSplitKeyNotSupportedByCassandra
+}
+
+// ReadSlice reads every token range whose index modulo slices is slice.
+func (s *CassandraSource) ReadSlice(ctx context.Context, slice, slices int, fn func(columns []string, rows [][]interface{}) error) error {
+	ranges := tokenRanges(s.cfg.CassandraTokenRanges)
+	for i := slice; i < len(ranges); i += slices {
+		if err := s.readTokenRange(ctx, slice, ranges[i], fn); err != nil {
+			return errors.Wrapf(err, "read token range [%d, %d] failed", ranges[i][0], ranges[i][1])
+		}
+	}
+	return nil
+}
+
+func (s *CassandraSource) readTokenRange(ctx context.Context, threadNum int, r [2]int64, fn func(columns []string, rows [][]interface{}) error) error {
+	tokenExpr, err := s.tokenExpr()
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("SELECT * FROM %s WHERE %s >= ? AND %s <= ?%s",
+		s.tableName(), tokenExpr, tokenExpr, s.whereCondition())
+
+	var pageState []byte
+	for {
+		startTime := time.Now()
+		var columns []string
+		var rows [][]interface{}
+		var nextPageState []byte
+		err := retry.Do(
+			func() error {
+				iter := s.session.Query(query, r[0], r[1]).
+					WithContext(ctx).
+					Consistency(s.consistency).
+					PageSize(int(s.cfg.BatchSize)).
+					PageState(pageState).
+					Iter()
+				nextPageState = iter.PageState()
+				columns = columns[:0]
+				for _, column := range iter.Columns() {
+					columns = append(columns, column.Name)
+				}
+				rows = rows[:0]
+				for {
+					record := make(map[string]interface{}, len(columns))
+					if !iter.MapScan(record) {
+						break
+					}
+					row := make([]interface{}, len(columns))
+					for i, column := range columns {
+						row[i] = cassandraValue(record[column])
+					}
+					rows = append(rows, row)
+				}
+				return iter.Close()
+			},
+			retry.Context(ctx),
+			retry.Attempts(5),
+			retry.Delay(time.Second),
+			retry.DelayType(retry.BackOffDelay),
+			retry.LastErrorOnly(true),
+			retry.OnRetry(func(n uint, err error) {
+				logrus.Warnf("thread-%d: retry page of token range [%d, %d] (%d): %v", threadNum, r[0], r[1], n+1, err)
+			}),
+		)
+		if err != nil {
+			return err
+		}
+		if len(rows) > 0 {
+			s.statsRecorder.RecordBatch(s.cfg, threadNum, rows)
+			stats := s.statsRecorder.Stats(time.Since(startTime))
+			log.Printf("thread-%d: extract %d rows (%f rows/s)", threadNum, len(rows), stats.RowsPerSecondd)
+			if err := fn(columns, rows); err != nil {
+				return err
+			}
+		}
+		if len(nextPageState) == 0 {
+			return nil
+		}
+		pageState = nextPageState
+	}
+}
+
+func (s *CassandraSource) GetDatabasesAccordingToSourceDbRegex(ctx context.Context, sourceDatabasePattern string) ([]string, error) {
+	iter := s.session.Query("SELECT keyspace_name FROM system_schema.keyspaces").WithContext(ctx).Iter()
+	var databases []string
+	var keyspace string
+	for iter.Scan(&keyspace) {
+		if strings.HasPrefix(keyspace, "system") {
+			continue
+		}
+		match, err := regexp.MatchString(sourceDatabasePattern, keyspace)
+		if err != nil {
+			iter.Close()
+			return nil, err
+		}
+		if match {
+			databases = append(databases, keyspace)
+		}
+	}
+	return databases, iter.Close()
+}
+
+func (s *CassandraSource) GetTablesAccordingToSourceTableRegex(ctx context.Context, sourceTablePattern string, databases []string) (map[string][]string, error) {
+	dbTables := make(map[string][]string)
+	for _, database := range databases {
+		iter := s.session.Query("SELECT table_name FROM system_schema.tables WHERE keyspace_name = ?", database).WithContext(ctx).Iter()
+		var tables []string
+		var table string
+		for iter.Scan(&table) {
+			match, err := regexp.MatchString(sourceTablePattern, table)
+			if err != nil {
+				iter.Close()
+				return nil, err
+			}
+			if match {
+				tables = append(tables, table)
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+		dbTables[database] = tables
+	}
+	return dbTables, nil
+}
+
+func (s *CassandraSource) GetAllSourceReadRowsCount(ctx context.Context) (int, error) {
+	allCount := 0
+	dbTables, err := s.GetDbTablesAccordingToSourceDbTables(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for db, tables := range dbTables {
+		s.cfg.SourceDB = db
+		for _, table := range tables {
+			s.cfg.SourceTable = table
+			count, err := s.GetSourceReadRowsCount(ctx)
+			if err != nil {
+				return 0, err
+			}
+			allCount += count
+		}
+	}
+	return allCount, nil
+}
+
+func (s *CassandraSource) GetDbTablesAccordingToSourceDbTables(ctx context.Context) (map[string][]string, error) {
+	allDbTables := make(map[string][]string)
+	for _, sourceDbTable := range s.cfg.SourceDbTables {
+		dbTable := strings.Split(sourceDbTable, "@")
+		if len(dbTable) != 2 {
+			return nil, fmt.Errorf("invalid sourceDbTable: %s, should be a@b format", sourceDbTable)
+		}
+		dbs, err := s.GetDatabasesAccordingToSourceDbRegex(ctx, dbTable[0])
+		if err != nil {
+			return nil, fmt.Errorf("get databases according to sourceDbRegex failed: %v", err)
+		}
+		dbTables, err := s.GetTablesAccordingToSourceTableRegex(ctx, dbTable[1], dbs)
+		if err != nil {
+			return nil, fmt.Errorf("get tables according to sourceTableRegex failed: %v", err)
+		}
+		for db, tables := range dbTables {
+			allDbTables[db] = append(allDbTables[db], tables...)
+		}
+	}
+	if s.cfg.SourceDB != "" && s.cfg.SourceTable != "" {
+		allDbTables[s.cfg.SourceDB] = append(allDbTables[s.cfg.SourceDB], s.cfg.SourceTable)
+	}
+	return allDbTables, nil
+}
+
+func (s *CassandraSource) tableName() string {
+	return quoteCQLIdentifier(s.cfg.SourceDB) + "." + quoteCQLIdentifier(s.cfg.SourceTable)
+}
+
+// tokenExpr returns token(<partition key columns>) of the source table.
+func (s *CassandraSource) tokenExpr() (string, error) {
+	keyspace, err := s.session.KeyspaceMetadata(s.cfg.SourceDB)
+	if err != nil {
+		return "", err
+	}
+	table, ok := keyspace.Tables[s.cfg.SourceTable]
+	if !ok {
+		return "", fmt.Errorf("table %s.%s not found", s.cfg.SourceDB, s.cfg.SourceTable)
+	}
+	keys := make([]string, len(table.PartitionKey))
+	for i, column := range table.PartitionKey {
+		keys[i] = quoteCQLIdentifier(column.Name)
+	}
+	return fmt.Sprintf("token(%s)", strings.Join(keys, ", ")), nil
+}
+
+func (s *CassandraSource) whereCondition() string {
+	if s.cfg.SourceWhereCondition == "" {
+		return ""
+	}
+	return " AND " + s.cfg.SourceWhereCondition + " ALLOW FILTERING"
+}
+
+// tokenRanges cuts the Murmur3Partitioner ring into n contiguous inclusive
+// ranges covering every token.
+func tokenRanges(n int) [][2]int64 {
+	if n <= 0 {
+		n = 1
+	}
+	step := math.MaxUint64 / uint64(n)
+	ranges := make([][2]int64, n)
+	for i := 0; i < n; i++ {
+		// tokens are offset by 1<<63 so the arithmetic can be done unsigned
+		start := int64(uint64(i)*step + 1<<63)
+		end := int64(uint64(i+1)*step - 1 + 1<<63)
+		if i == n-1 {
+			end = math.MaxInt64
+		}
+		ranges[i] = [2]int64{start, end}
+	}
+	return ranges
+}
+
+// cassandraValue converts the values gocql scans into ones that marshal to
+// JSON as Databend expects.
+func cassandraValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case *inf.Dec:
+		if value == nil {
+			return nil
+		}
+		return value.String()
+	case *big.Int:
+		if value == nil {
+			return nil
+		}
+		return value.String()
+	default:
+		return v
+	}
+}
+
+func quoteCQLIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,22 @@
+package source
+
+import (
+	"math"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestTokenRanges(t *testing.T) {
+	ranges := tokenRanges(4)
+	assert.Equal(t, 4, len(ranges))
+	assert.Equal(t, int64(math.MinInt64), ranges[0][0])
+	assert.Equal(t, int64(math.MaxInt64), ranges[3][1])
+	for i := 1; i < len(ranges); i++ {
+		assert.Equal(t, ranges[i-1][1]+1, ranges[i][0])
+		assert.True(t, ranges[i][0] <= ranges[i][1])
+	}
+
+	ranges = tokenRanges(1)
+	assert.Equal(t, [][2]int64{{math.MinInt64, math.MaxInt64}}, ranges)
+}
@@ -0,0 +1,231 @@
+package source
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// ValueConverter handles one column type of a SQL source: it provides the
+// destination rows.Scan fills and converts the scanned value into the value
+// staged for Databend.
+type ValueConverter interface {
+	NewScanArg() interface{}
+	Convert(arg interface{}) (interface{}, error)
+}
+
+// ConverterFuncs adapts a scan destination constructor and a conversion
+// function to ValueConverter.
+type ConverterFuncs struct {
+	NewArg     func() interface{}
+	ConvertArg func(arg interface{}) (interface{}, error)
+}
+
+func (c ConverterFuncs) NewScanArg() interface{} { return c.NewArg() }
+
+func (c ConverterFuncs) Convert(arg interface{}) (interface{}, error) { return c.ConvertArg(arg) }
+
+var (
+	nullInt64Converter = ConverterFuncs{
+		NewArg: func() interface{} { return new(sql.NullInt64) },
+		ConvertArg: func(arg interface{}) (interface{}, error) {
+			if v := arg.(*sql.NullInt64); v.Valid {
+				return v.Int64, nil
+			}
+			return nil, nil
+		},
+	}
+	nullUint64Converter = ConverterFuncs{
+		NewArg: func() interface{} { return new(NullUint64) },
+		ConvertArg: func(arg interface{}) (interface{}, error) {
+			if v := arg.(*NullUint64); v.Valid {
+				return v.Uint64, nil
+			}
+			return nil, nil
+		},
+	}
+	nullFloat64Converter = ConverterFuncs{
+		NewArg: func() interface{} { return new(sql.NullFloat64) },
+		ConvertArg: func(arg interface{}) (interface{}, error) {
+			if v := arg.(*sql.NullFloat64); v.Valid {
+				return v.Float64, nil
+			}
+			return nil, nil
+		},
+	}
+	nullStringConverter = ConverterFuncs{
+		NewArg: func() interface{} { return new(sql.NullString) },
+		ConvertArg: func(arg interface{}) (interface{}, error) {
+			if v := arg.(*sql.NullString); v.Valid {
+				return v.String, nil
+			}
+			return nil, nil
+		},
+	}
+	nullTimeConverter = ConverterFuncs{
+		NewArg: func() interface{} { return new(sql.NullTime) },
+		ConvertArg: func(arg interface{}) (interface{}, error) {
+			if v := arg.(*sql.NullTime); v.Valid {
+				return v.Time, nil
+			}
+			return nil, nil
+		},
+	}
+	nullBoolConverter = ConverterFuncs{
+		NewArg: func() interface{} { return new(sql.NullBool) },
+		ConvertArg: func(arg interface{}) (interface{}, error) {
+			if v := arg.(*sql.NullBool); v.Valid {
+				return v.Bool, nil
+			}
+			return nil, nil
+		},
+	}
+	// nullBoolIntConverter stages booleans as 1/0, the target databend bool
+	// is int8.
+	nullBoolIntConverter = ConverterFuncs{
+		NewArg: func() interface{} { return new(sql.NullBool) },
+		ConvertArg: func(arg interface{}) (interface{}, error) {
+			v := arg.(*sql.NullBool)
+			switch {
+			case !v.Valid:
+				return nil, nil
+			case v.Bool:
+				return 1, nil
+			default:
+				return 0, nil
+			}
+		},
+	}
+	// bitConverter reads BIT(n) columns, sent as big-endian bytes, as numbers.
+	bitConverter = ConverterFuncs{
+		NewArg: func() interface{} { return new(sql.RawBytes) },
+		ConvertArg: func(arg interface{}) (interface{}, error) {
+			v := *arg.(*sql.RawBytes)
+			if v == nil {
+				return nil, nil
+			}
+			if len(v) > 8 {
+				return nil, fmt.Errorf("BIT value of %d bytes overflows uint64", len(v))
+			}
+			var n uint64
+			for _, b := range v {
+				n = n<<8 | uint64(b)
+			}
+			return n, nil
+		},
+	}
+	rawBytesConverter = ConverterFuncs{
+		NewArg: func() interface{} { return new(sql.RawBytes) },
+		ConvertArg: func(arg interface{}) (interface{}, error) {
+			return string(*arg.(*sql.RawBytes)), nil
+		},
+	}
+)
+
+var (
+	convertersMu sync.RWMutex
+	// valueConverters maps a source family (mysql, pg, oracle, mssql) and a
+	// column DatabaseTypeName to its converter. Types not listed are scanned
+	// as raw bytes and staged as strings.
+	valueConverters = map[string]map[string]ValueConverter{}
+)
+
+// RegisterValueConverter sets the converter of the columns of type typeName
+// read by the sources of the given family, replacing any previous one.
+func RegisterValueConverter(family, typeName string, c ValueConverter) {
+	convertersMu.Lock()
+	defer convertersMu.Unlock()
+	if valueConverters[family] == nil {
+		valueConverters[family] = make(map[string]ValueConverter)
+	}
+	valueConverters[family][typeName] = c
+}
+
+func registerValueConverters(family string, c ValueConverter, typeNames ...string) {
+	for _, typeName := range typeNames {
+		RegisterValueConverter(family, typeName, c)
+	}
+}
+
+func lookupValueConverter(family, typeName string) (ValueConverter, bool) {
+	convertersMu.RLock()
+	defer convertersMu.RUnlock()
+	c, ok := valueConverters[family][typeName]
+	return c, ok
+}
+
+func init() {
+	registerValueConverters("mysql", nullInt64Converter, "INT", "SMALLINT", "TINYINT", "MEDIUMINT", "BIGINT",
+		"UNSIGNED INT", "UNSIGNED TINYINT", "UNSIGNED SMALLINT", "UNSIGNED MEDIUMINT", "YEAR")
+	registerValueConverters("mysql", nullUint64Converter, "UNSIGNED BIGINT")
+	registerValueConverters("mysql", bitConverter, "BIT")
+	registerValueConverters("mysql", nullFloat64Converter, "FLOAT", "DOUBLE", "DECIMAL")
+	registerValueConverters("mysql", nullStringConverter, "CHAR", "VARCHAR", "TEXT", "TINYTEXT", "MEDIUMTEXT", "LONGTEXT",
+		"DATE", "TIME", "DATETIME", "TIMESTAMP", "ENUM", "SET")
+	registerValueConverters("mysql", nullBoolConverter, "BOOL", "BOOLEAN")
+
+	registerValueConverters("pg", nullInt64Converter, "INT", "SMALLINT", "TINYINT", "MEDIUMINT", "BIGINT", "INT4", "INT8",
+		"UNSIGNED INT", "UNSIGNED TINYINT", "UNSIGNED MEDIUMINT", "UNSIGNED BIGINT")
+	registerValueConverters("pg", nullFloat64Converter, "FLOAT", "DOUBLE", "FLOAT8", "DECIMAL", "NUMERIC")
+	registerValueConverters("pg", nullStringConverter, "CHAR", "VARCHAR", "TEXT", "TINYTEXT", "MEDIUMTEXT", "LONGTEXT",
+		"DATE", "TIME", "DATETIME", "TIMESTAMP")
+	registerValueConverters("pg", nullBoolIntConverter, "BOOL", "BOOLEAN")
+
+	registerValueConverters("oracle", nullFloat64Converter, "NUMBER", "INTEGER", "FLOAT", "BINARY_FLOAT", "BINARY_DOUBLE")
+	registerValueConverters("oracle", nullStringConverter, "CHAR", "NCHAR", "VARCHAR", "VARCHAR2", "NVARCHAR2", "CLOB", "NCLOB",
+		"IntervalYM_DTY", "IntervalDS_DTY")
+	registerValueConverters("oracle", nullTimeConverter, "DATE", "TimeStampDTY", "TimeStampTZ_DTY", "TimeStampLTZ_DTY")
+	registerValueConverters("oracle", rawBytesConverter, "RAW", "LONG", "LongRaw", "OCIBlobLocator", "IBDouble")
+
+	registerValueConverters("mssql", nullInt64Converter, "TINYINT", "SMALLINT", "INT", "BIGINT")
+	registerValueConverters("mssql", nullFloat64Converter, "REAL", "FLOAT", "DECIMAL", "NUMERIC", "MONEY", "SMALLMONEY")
+	registerValueConverters("mssql", nullStringConverter, "CHAR", "VARCHAR", "TEXT", "NCHAR", "NVARCHAR", "NTEXT",
+		"DATE", "TIME", "DATETIME", "DATETIME2", "SMALLDATETIME", "DATETIMEOFFSET", "UNIQUEIDENTIFIER")
+	registerValueConverters("mssql", nullBoolIntConverter, "BIT")
+	registerValueConverters("mssql", rawBytesConverter, "BINARY", "VARBINARY", "IMAGE")
+}
+
+// rowScanner scans the rows of a query with the converters registered for
+// the types of its columns.
+type rowScanner struct {
+	args       []interface{}
+	converters []ValueConverter
+}
+
+func newRowScanner(family string, columnTypes []*sql.ColumnType) *rowScanner {
+	s := &rowScanner{
+		args:       make([]interface{}, len(columnTypes)),
+		converters: make([]ValueConverter, len(columnTypes)),
+	}
+	for i, columnType := range columnTypes {
+		c, ok := lookupValueConverter(family, columnType.DatabaseTypeName())
+		if !ok {
+			c = rawBytesConverter
+		}
+		s.converters[i] = c
+		s.args[i] = c.NewScanArg()
+	}
+	return s
+}
+
+// setConverter replaces the converter of the i-th column.
+func (s *rowScanner) setConverter(i int, c ValueConverter) {
+	s.converters[i] = c
+	s.args[i] = c.NewScanArg()
+}
+
+// scan reads the current row of rows, the returned slice is newly allocated.
+func (s *rowScanner) scan(rows *sql.Rows) ([]interface{}, error) {
+	if err := rows.Scan(s.args...); err != nil {
+		return nil, err
+	}
+	row := make([]interface{}, len(s.args))
+	for i, arg := range s.args {
+		v, err := s.converters[i].Convert(arg)
+		if err != nil {
+			return nil, err
+		}
+		row[i] = v
+	}
+	return row, nil
+}
@@ -0,0 +1,120 @@
+package source
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+// fakeDriver serves a single result set whose columns report the given
+// database type names.
+type fakeDriver struct {
+	columns []string
+	types   []string
+	rows    [][]driver.Value
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return &fakeStmt{c.d}, nil }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+type fakeStmt struct{ d *fakeDriver }
+
+func (s *fakeStmt) Close() error                               { return nil }
+func (s *fakeStmt) NumInput() int                              { return -1 }
+func (s *fakeStmt) Exec([]driver.Value) (driver.Result, error) { return nil, driver.ErrSkip }
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error)  { return &fakeRows{d: s.d}, nil }
+
+type fakeRows struct {
+	d *fakeDriver
+	i int
+}
+
+func (r *fakeRows) Columns() []string                           { return r.d.columns }
+func (r *fakeRows) Close() error                                { return nil }
+func (r *fakeRows) ColumnTypeDatabaseTypeName(index int) string { return r.d.types[index] }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.d.rows) {
+		return io.EOF
+	}
+	copy(dest, r.d.rows[r.i])
+	r.i++
+	return nil
+}
+
+func TestRowScannerConverters(t *testing.T) {
+	sql.Register("fake-convert", &fakeDriver{
+		columns: []string{"id", "flag", "tags", "blob"},
+		types:   []string{"BIGINT", "BOOL", "SET", "GEOMETRY"},
+		rows: [][]driver.Value{
+			{int64(1), true, []byte("a,b"), []byte("xy")},
+			{nil, nil, nil, []byte("")},
+		},
+	})
+	RegisterValueConverter("test", "BIGINT", nullInt64Converter)
+	RegisterValueConverter("test", "BOOL", nullBoolIntConverter)
+	RegisterValueConverter("test", "SET", ConverterFuncs{
+		NewArg: func() interface{} { return new(sql.NullString) },
+		ConvertArg: func(arg interface{}) (interface{}, error) {
+			if v := arg.(*sql.NullString); v.Valid {
+				return strings.Split(v.String, ","), nil
+			}
+			return nil, nil
+		},
+	})
+
+	db, err := sql.Open("fake-convert", "")
+	assert.NoError(t, err)
+	defer db.Close()
+	rows, err := db.Query("SELECT")
+	assert.NoError(t, err)
+	defer rows.Close()
+	columnTypes, err := rows.ColumnTypes()
+	assert.NoError(t, err)
+
+	scanner := newRowScanner("test", columnTypes)
+	var result [][]interface{}
+	for rows.Next() {
+		row, err := scanner.scan(rows)
+		assert.NoError(t, err)
+		result = append(result, row)
+	}
+	assert.Equal(t, [][]interface{}{
+		{int64(1), 1, []string{"a", "b"}, "xy"},
+		{nil, nil, nil, ""},
+	}, result)
+}
+
+func TestMysqlBitAndUnsignedConverters(t *testing.T) {
+	bit, ok := lookupValueConverter("mysql", "BIT")
+	assert.True(t, ok)
+	v, err := bit.Convert(&sql.RawBytes{0x01, 0x05})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(261), v)
+	v, err = bit.Convert(new(sql.RawBytes))
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+
+	unsigned, ok := lookupValueConverter("mysql", "UNSIGNED BIGINT")
+	assert.True(t, ok)
+	arg := unsigned.NewScanArg()
+	assert.NoError(t, arg.(sql.Scanner).Scan([]byte("18446744073709551615")))
+	v, err = unsigned.Convert(arg)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(18446744073709551615), v)
+
+	enum, ok := lookupValueConverter("mysql", "ENUM")
+	assert.True(t, ok)
+	v, err = enum.Convert(&sql.NullString{})
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+}
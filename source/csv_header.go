@@ -0,0 +1,94 @@
+package source
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// reservedWords are the Databend keywords that can't name a column without
+// quotes.
+var reservedWords = map[string]bool{
+	"all": true, "and": true, "as": true, "between": true, "by": true, "case": true, "column": true,
+	"create": true, "database": true, "default": true, "delete": true, "distinct": true, "drop": true,
+	"else": true, "end": true, "false": true, "from": true, "group": true, "having": true, "in": true,
+	"insert": true, "into": true, "is": true, "join": true, "key": true, "like": true, "limit": true,
+	"not": true, "null": true, "offset": true, "on": true, "or": true, "order": true, "primary": true,
+	"select": true, "table": true, "then": true, "to": true, "true": true, "union": true, "update": true,
+	"user": true, "values": true, "when": true, "where": true, "with": true,
+}
+
+var (
+	bareIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	nonWordRun     = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// QuoteIdentifier is name as it is written in a query: quoted when it is a
+// reserved word or not a bare identifier, as is otherwise so that Databend
+// still folds its case.
+func QuoteIdentifier(name string) string {
+	if bareIdentifier.MatchString(name) && !reservedWords[strings.ToLower(name)] {
+		return name
+	}
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// sanitizeColumns renames the columns of a CSV header by policy: every
+// policy names empty columns column_<n> and suffixes duplicates with _<n>,
+// suffix also appends _ to reserved words, snake_case lower cases the names
+// and replaces other characters than letters and digits with _ first, and
+// quote leaves reserved words and other characters to QuoteIdentifier.
+func sanitizeColumns(policy string, columns []string) []string {
+	result := make([]string, len(columns))
+	seen := make(map[string]int)
+	for i, column := range columns {
+		name := strings.TrimSpace(column)
+		if policy == "snake_case" {
+			name = strings.Trim(nonWordRun.ReplaceAllString(strings.ToLower(name), "_"), "_")
+		}
+		if name == "" {
+			name = fmt.Sprintf("column_%d", i+1)
+		}
+		if policy != "quote" && reservedWords[strings.ToLower(name)] {
+			name += "_"
+		}
+		key := strings.ToLower(name)
+		seen[key]++
+		for n := seen[key]; n > 1; n++ {
+			candidate := fmt.Sprintf("%s_%d", name, n)
+			if seen[strings.ToLower(candidate)] == 0 {
+				name = candidate
+				seen[strings.ToLower(candidate)]++
+				break
+			}
+		}
+		result[i] = name
+	}
+	return result
+}
+
+// sanitizeCSV wraps fn to rename the columns of the CSV file path by policy,
+// the renamed columns are logged once.
+func sanitizeCSV(policy, path string, fn func(columns []string, rows [][]interface{}) error) func(columns []string, rows [][]interface{}) error {
+	if policy == "" {
+		return fn
+	}
+	var sanitized []string
+	return func(columns []string, rows [][]interface{}) error {
+		if sanitized == nil {
+			sanitized = sanitizeColumns(policy, columns)
+			var renamed []string
+			for i, column := range columns {
+				if sanitized[i] != column {
+					renamed = append(renamed, fmt.Sprintf("%q -> %q", column, sanitized[i]))
+				}
+			}
+			if len(renamed) > 0 {
+				logrus.Warnf("csv header of %s renamed: %s", path, strings.Join(renamed, ", "))
+			}
+		}
+		return fn(sanitized, rows)
+	}
+}
@@ -0,0 +1,34 @@
+package source
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestSanitizeColumns(t *testing.T) {
+	header := []string{"id", "", "Order", "First Name", "id", "id_2", " "}
+	assert.Equal(t, []string{"id", "column_2", "Order_", "First Name", "id_2", "id_2_2", "column_7"}, sanitizeColumns("suffix", header))
+	assert.Equal(t, []string{"id", "column_2", "order_", "first_name", "id_2", "id_2_2", "column_7"}, sanitizeColumns("snake_case", header))
+	assert.Equal(t, []string{"id", "column_2", "Order", "First Name", "id_2", "id_2_2", "column_7"}, sanitizeColumns("quote", header))
+}
+
+func TestSanitizeCSV(t *testing.T) {
+	input := "id,id,select\n1,2,3\n4,5,6\n"
+	var batches int
+	fn := sanitizeCSV("suffix", "orders.csv", func(columns []string, rows [][]interface{}) error {
+		assert.Equal(t, []string{"id", "id_2", "select_"}, columns)
+		batches++
+		return nil
+	})
+	assert.NoError(t, ReadFileBatches(strings.NewReader(input), "csv", 1, fn))
+	assert.Equal(t, 2, batches)
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	assert.Equal(t, "CreatedAt", QuoteIdentifier("CreatedAt"))
+	assert.Equal(t, "`order`", QuoteIdentifier("order"))
+	assert.Equal(t, "`First Name`", QuoteIdentifier("First Name"))
+	assert.Equal(t, "`a``b`", QuoteIdentifier("a`b"))
+}
@@ -0,0 +1,94 @@
+package source
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// dateTypeNames are the column types whose values are checked against the
+// range Databend accepts.
+var dateTypeNames = map[string]bool{
+	"DATE":           true,
+	"DATETIME":       true,
+	"DATETIME2":      true,
+	"SMALLDATETIME":  true,
+	"DATETIMEOFFSET": true,
+	"TIMESTAMP":      true,
+	"TIMESTAMPTZ":    true,
+	// oracle
+	"TimeStampDTY":     true,
+	"TimeStampTZ_DTY":  true,
+	"TimeStampLTZ_DTY": true,
+}
+
+// applyInvalidDatePolicy makes the date columns of scanner replace zero
+// dates (0000-00-00), impossible dates and years outside 1000-9999 according
+// to cfg.InvalidDatePolicy, so they don't fail the COPY of a whole batch.
+func applyInvalidDatePolicy(cfg *config.Config, scanner *rowScanner, columns []string, columnTypes []*sql.ColumnType) {
+	if cfg.InvalidDatePolicy == "" {
+		return
+	}
+	for i, columnType := range columnTypes {
+		if !dateTypeNames[columnType.DatabaseTypeName()] {
+			continue
+		}
+		scanner.setConverter(i, &dateConverter{
+			ValueConverter: scanner.converters[i],
+			column:         columns[i],
+			policy:         cfg.InvalidDatePolicy,
+			sentinel:       cfg.InvalidDateSentinel,
+		})
+	}
+}
+
+type dateConverter struct {
+	ValueConverter
+	column   string
+	policy   string
+	sentinel string
+}
+
+func (c *dateConverter) Convert(arg interface{}) (interface{}, error) {
+	v, err := c.ValueConverter.Convert(arg)
+	if err != nil || v == nil {
+		return v, err
+	}
+	var valid bool
+	switch t := v.(type) {
+	case string:
+		valid = isValidDate(t)
+	case time.Time:
+		valid = t.Year() >= 1000 && t.Year() <= 9999
+	default:
+		return v, nil
+	}
+	if valid {
+		return v, nil
+	}
+	switch c.policy {
+	case "null":
+		return nil, nil
+	case "sentinel":
+		return c.sentinel, nil
+	default:
+		return nil, fmt.Errorf("column %s has invalid date %v", c.column, v)
+	}
+}
+
+// isValidDate checks the date part of a date or timestamp in its text form,
+// e.g. 2024-01-31 or 2024-01-31 12:00:00.
+func isValidDate(s string) bool {
+	if len(s) < 10 {
+		return false
+	}
+	t, err := time.Parse("2006-01-02", s[:10])
+	if err != nil {
+		// zero dates, 2024-02-30 or infinity
+		return false
+	}
+	return t.Year() >= 1000 && (len(s) == 10 || strings.ContainsAny(s[10:11], " T"))
+}
@@ -0,0 +1,48 @@
+package source
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestIsValidDate(t *testing.T) {
+	assert.True(t, isValidDate("2024-02-29"))
+	assert.True(t, isValidDate("2024-02-29 12:00:00"))
+	assert.True(t, isValidDate("2024-02-29T12:00:00Z"))
+	assert.False(t, isValidDate("0000-00-00"))
+	assert.False(t, isValidDate("0000-00-00 00:00:00"))
+	assert.False(t, isValidDate("2023-02-29"))
+	assert.False(t, isValidDate("0999-12-31"))
+	assert.False(t, isValidDate("infinity"))
+}
+
+func TestDateConverterPolicies(t *testing.T) {
+	zero := &sql.NullString{String: "0000-00-00 00:00:00", Valid: true}
+
+	c := &dateConverter{ValueConverter: nullStringConverter, column: "created_at", policy: "null"}
+	v, err := c.Convert(zero)
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+
+	c.policy = "sentinel"
+	c.sentinel = "1970-01-01 00:00:00"
+	v, err = c.Convert(zero)
+	assert.NoError(t, err)
+	assert.Equal(t, "1970-01-01 00:00:00", v)
+
+	v, err = c.Convert(&sql.NullString{String: "2024-01-01 00:00:00", Valid: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-01-01 00:00:00", v)
+
+	c.policy = "reject"
+	_, err = c.Convert(zero)
+	assert.EqualError(t, err, "column created_at has invalid date 0000-00-00 00:00:00")
+
+	c = &dateConverter{ValueConverter: nullTimeConverter, column: "at", policy: "null"}
+	v, err = c.Convert(&sql.NullTime{Time: time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC), Valid: true})
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+}
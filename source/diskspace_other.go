@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package source
+
+// freeBytes is unknown on this platform, the free space check is skipped.
+func freeBytes(dir string) (int64, error) {
+	return -1, nil
+}
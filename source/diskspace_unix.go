@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package source
+
+import "syscall"
+
+// freeBytes is the space available to unprivileged users in the file system
+// of dir.
+func freeBytes(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
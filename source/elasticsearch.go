@@ -0,0 +1,317 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+var errSplitKeyNotSupportedByElasticsearch = errors.New("split keys are not supported by the elasticsearch source")
+
+const elasticsearchScrollKeepAlive = "5m"
+
+// ElasticsearchSource archives the documents of Elasticsearch indices. An
+// index is treated as a table and read with a sliced scroll, one slice per
+// thread.
+type ElasticsearchSource struct {
+	cfg           *config.Config
+	client        *http.Client
+	statsRecorder *DatabendSourceStatsRecorder
+}
+
+type esSearchResponse struct {
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []struct {
+			ID     string                 `json:"_id"`
+			Source map[string]interface{} `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+func NewElasticsearchSource(cfg *config.Config) (*ElasticsearchSource, error) {
+	stats := NewDatabendIntesterStatsRecorder()
+	if cfg.ElasticsearchURL == "" {
+		return nil, errors.New("elasticsearchURL must be set for the elasticsearch source")
+	}
+	return &ElasticsearchSource{
+		cfg:           cfg,
+		client:        &http.Client{Timeout: 5 * time.Minute},
+		statsRecorder: stats,
+	}, nil
+}
+
+func (s *ElasticsearchSource) AdjustBatchSizeAccordingToSourceDbTable(ctx context.Context) uint64 {
+	return uint64(s.cfg.BatchSize)
+}
+
+func (s *ElasticsearchSource) GetSourceReadRowsCount(ctx context.Context) (int, error) {
+	body := map[string]interface{}{}
+	if err := s.setQuery(body); err != nil {
+		return 0, err
+	}
+	var resp struct {
+		Count int `json:"count"`
+	}
+	if err := s.request(ctx, http.MethodPost, "/"+s.cfg.SourceTable+"/_count", body, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
+
+func (s *ElasticsearchSource) GetMinMaxSplitKey(ctx context.Context) (uint64, uint64, error) {
+	return 0, 0, errSplitKeyNotSupportedByElasticsearch
+}
+
+func (s *ElasticsearchSource) GetMinMaxTimeSplitKey(ctx context.Context) (string, string, error) {
+	return "", "", errSplitKeyNotSupportedByElasticsearch
+}
+
+// DeleteAfterSync drops the archived indices, or only deletes the matching
+// documents when elasticsearchQuery is set.
+func (s *ElasticsearchSource) DeleteAfterSync(ctx context.Context) error {
+	if !s.cfg.DeleteAfterSync {
+		return nil
+	}
+	dbTables, err := s.GetDbTablesAccordingToSourceDbTables(ctx)
+	if err != nil {
+		return err
+	}
+	for _, indices := range dbTables {
+		for _, index := range indices {
+			if s.cfg.ElasticsearchQuery == "" {
+				err = s.request(ctx, http.MethodDelete, "/"+index, nil, nil)
+			} else {
+				body := map[string]interface{}{}
+				if err := s.setQuery(body); err != nil {
+					return err
+				}
+				err = s.request(ctx, http.MethodPost, "/"+index+"/_delete_by_query", body, nil)
+			}
+			if err != nil {
+				return err
+			}
+			logrus.Infof("deleted archived documents of index %s", index)
+		}
+	}
+	return nil
+}
+
+func (s *ElasticsearchSource) QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+	return nil, nil, errSplitKeyNotSupportedByElasticsearch
+}
+
+// ReadSlice scrolls through one slice of the index in batches of BatchSize
+// documents.
+func (s *ElasticsearchSource) ReadSlice(ctx context.Context, slice, slices int, fn func(columns []string, rows [][]interface{}) error) error {
+	body := map[string]interface{}{
+		"size": s.cfg.BatchSize,
+		"sort": []string{"_doc"},
+	}
+	if err := s.setQuery(body); err != nil {
+		return err
+	}
+	if slices > 1 {
+		body["slice"] = map[string]interface{}{"id": slice, "max": slices}
+	}
+
+	var resp esSearchResponse
+	path := fmt.Sprintf("/%s/_search?scroll=%s", s.cfg.SourceTable, elasticsearchScrollKeepAlive)
+	if err := s.request(ctx, http.MethodPost, path, body, &resp); err != nil {
+		return err
+	}
+	defer func() {
+		if resp.ScrollID != "" {
+			_ = s.request(ctx, http.MethodDelete, "/_search/scroll", map[string]interface{}{"scroll_id": resp.ScrollID}, nil)
+		}
+	}()
+
+	for len(resp.Hits.Hits) > 0 {
+		startTime := time.Now()
+		records := make([]map[string]interface{}, 0, len(resp.Hits.Hits))
+		for _, hit := range resp.Hits.Hits {
+			record := hit.Source
+			if s.cfg.ElasticsearchFlatten {
+				record = make(map[string]interface{})
+				flattenDocument("", hit.Source, record)
+			}
+			if s.cfg.ElasticsearchIDColumn != "" {
+				record[s.cfg.ElasticsearchIDColumn] = hit.ID
+			}
+			records = append(records, record)
+		}
+		columns, rows := alignRecords(records)
+		s.statsRecorder.RecordBatch(s.cfg, slice, rows)
+		stats := s.statsRecorder.Stats(time.Since(startTime))
+		log.Printf("thread-%d: extract %d rows (%f rows/s)", slice, len(rows), stats.RowsPerSecondd)
+		if err := fn(columns, rows); err != nil {
+			return err
+		}
+
+		scrollID := resp.ScrollID
+		resp = esSearchResponse{}
+		scroll := map[string]interface{}{"scroll": elasticsearchScrollKeepAlive, "scroll_id": scrollID}
+		if err := s.request(ctx, http.MethodPost, "/_search/scroll", scroll, &resp); err != nil {
+			resp.ScrollID = scrollID
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDatabasesAccordingToSourceDbRegex returns sourceDB as is, Elasticsearch
+// has no databases.
+func (s *ElasticsearchSource) GetDatabasesAccordingToSourceDbRegex(ctx context.Context, sourceDatabasePattern string) ([]string, error) {
+	return []string{s.cfg.SourceDB}, nil
+}
+
+// GetTablesAccordingToSourceTableRegex returns the indices matching the
+// pattern, hidden indices (starting with a dot) are skipped.
+func (s *ElasticsearchSource) GetTablesAccordingToSourceTableRegex(ctx context.Context, sourceTablePattern string, databases []string) (map[string][]string, error) {
+	var indices []struct {
+		Index string `json:"index"`
+	}
+	if err := s.request(ctx, http.MethodGet, "/_cat/indices?format=json&h=index", nil, &indices); err != nil {
+		return nil, err
+	}
+	pattern, err := regexp.Compile(sourceTablePattern)
+	if err != nil {
+		return nil, err
+	}
+	var tables []string
+	for _, index := range indices {
+		if strings.HasPrefix(index.Index, ".") || !pattern.MatchString(index.Index) {
+			continue
+		}
+		tables = append(tables, index.Index)
+	}
+	dbTables := make(map[string][]string)
+	for _, database := range databases {
+		dbTables[database] = tables
+	}
+	return dbTables, nil
+}
+
+func (s *ElasticsearchSource) GetAllSourceReadRowsCount(ctx context.Context) (int, error) {
+	allCount := 0
+	dbTables, err := s.GetDbTablesAccordingToSourceDbTables(ctx)
+	if err != nil {
+		return 0, err
+	}
+	table := s.cfg.SourceTable
+	defer func() { s.cfg.SourceTable = table }()
+	for _, indices := range dbTables {
+		for _, index := range indices {
+			s.cfg.SourceTable = index
+			count, err := s.GetSourceReadRowsCount(ctx)
+			if err != nil {
+				return 0, err
+			}
+			allCount += count
+		}
+	}
+	return allCount, nil
+}
+
+func (s *ElasticsearchSource) GetDbTablesAccordingToSourceDbTables(ctx context.Context) (map[string][]string, error) {
+	allDbTables := make(map[string][]string)
+	patterns := make([]string, 0, len(s.cfg.SourceDbTables)+1)
+	for _, sourceDbTable := range s.cfg.SourceDbTables {
+		dbTable := strings.Split(sourceDbTable, "@")
+		if len(dbTable) != 2 {
+			return nil, fmt.Errorf("invalid sourceDbTable: %s, should be a@b format", sourceDbTable)
+		}
+		patterns = append(patterns, dbTable[1])
+	}
+	if s.cfg.SourceTable != "" {
+		patterns = append(patterns, fmt.Sprintf("^%s$", s.cfg.SourceTable))
+	}
+	for _, pattern := range patterns {
+		dbTables, err := s.GetTablesAccordingToSourceTableRegex(ctx, pattern, []string{s.cfg.SourceDB})
+		if err != nil {
+			return nil, err
+		}
+		for db, tables := range dbTables {
+			allDbTables[db] = append(allDbTables[db], tables...)
+		}
+	}
+	return allDbTables, nil
+}
+
+func (s *ElasticsearchSource) setQuery(body map[string]interface{}) error {
+	if s.cfg.ElasticsearchQuery == "" {
+		return nil
+	}
+	var query interface{}
+	if err := json.Unmarshal([]byte(s.cfg.ElasticsearchQuery), &query); err != nil {
+		return errors.Wrap(err, "invalid elasticsearchQuery")
+	}
+	body["query"] = query
+	return nil
+}
+
+func (s *ElasticsearchSource) request(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(s.cfg.ElasticsearchURL, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.cfg.SourceUser != "" {
+		req.SetBasicAuth(s.cfg.SourceUser, s.cfg.SourcePass)
+	}
+	for k, v := range s.cfg.SourceHTTPHeaders {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("elasticsearch %s %s failed, status code: %d, body: %s", method, path, resp.StatusCode, string(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	return decoder.Decode(out)
+}
+
+// flattenDocument copies doc into out, nested objects become columns named
+// after their path joined by underscores.
+func flattenDocument(prefix string, doc map[string]interface{}, out map[string]interface{}) {
+	for k, v := range doc {
+		key := k
+		if prefix != "" {
+			key = prefix + "_" + k
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenDocument(key, nested, out)
+			continue
+		}
+		out[key] = v
+	}
+}
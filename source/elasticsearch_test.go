@@ -0,0 +1,58 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestElasticsearchReadSlice(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/logs/_search":
+			var body map[string]interface{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, map[string]interface{}{"id": float64(1), "max": float64(2)}, body["slice"])
+			fmt.Fprint(w, `{"_scroll_id": "s1", "hits": {"hits": [
+				{"_id": "a", "_source": {"msg": "hello", "user": {"name": "bob"}}},
+				{"_id": "b", "_source": {"msg": "world", "level": 3}}]}}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/_search/scroll":
+			fmt.Fprint(w, `{"_scroll_id": "s1", "hits": {"hits": []}}`)
+		case r.Method == http.MethodDelete && r.URL.Path == "/_search/scroll":
+			fmt.Fprint(w, `{}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		ElasticsearchURL:      server.URL,
+		ElasticsearchFlatten:  true,
+		ElasticsearchIDColumn: "doc_id",
+		SourceTable:           "logs",
+		BatchSize:             100,
+	}
+	src, err := NewElasticsearchSource(cfg)
+	assert.NoError(t, err)
+
+	var columns []string
+	var rows [][]interface{}
+	err = src.ReadSlice(context.Background(), 1, 2, func(c []string, r [][]interface{}) error {
+		columns = c
+		rows = append(rows, r...)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"doc_id", "level", "msg", "user_name"}, columns)
+	assert.Equal(t, 2, len(rows))
+	assert.Equal(t, []interface{}{"a", nil, "hello", "bob"}, rows[0])
+	assert.Equal(t, []interface{}{"b", json.Number("3"), "world", nil}, rows[1])
+}
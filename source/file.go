@@ -0,0 +1,330 @@
+package source
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+var ErrNotSupportedByFileSource = errors.New("not supported by file source")
+
+// FileInfo describes a file offered by a file source.
+type FileInfo struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Table   string // table name used for the {table} placeholder of databendTable
+}
+
+// FileSourcer is implemented by sources that archive files instead of tables.
+// The worker lists the files, streams each of them through ReadBatches and
+// moves or removes them after a successful ingest when MoveAfterSync or
+// DeleteAfterSync is set.
+type FileSourcer interface {
+	ListFiles(ctx context.Context) ([]FileInfo, error)
+	OpenFile(ctx context.Context, file FileInfo) (io.ReadCloser, error)
+	ReadBatches(ctx context.Context, r io.Reader, file FileInfo, fn func(columns []string, rows [][]interface{}) error) error
+	RemoveFile(ctx context.Context, file FileInfo) error
+	MoveFile(ctx context.Context, file FileInfo, dir string) error
+}
+
+// fileSource holds what all file sources share.
+type fileSource struct {
+	cfg *config.Config
+}
+
+func (s *fileSource) filePattern() (*regexp.Regexp, error) {
+	if s.cfg.SourceFilePattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(s.cfg.SourceFilePattern)
+}
+
+// TableNameFromPath derives a table name from a file name or url, e.g.
+// "/drop/Orders 2024.csv" becomes "orders_2024".
+func TableNameFromPath(p string) string {
+	if strings.Contains(p, "://") {
+		if u, err := url.Parse(p); err == nil {
+			p = u.Path
+		}
+	}
+	base := path.Base(filepath.ToSlash(p))
+	return SanitizeTableName(strings.TrimSuffix(base, path.Ext(base)))
+}
+
+// SanitizeTableName lower-cases name and replaces everything but letters,
+// digits and underscores, so it can be used as an unquoted identifier.
+func SanitizeTableName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	table := b.String()
+	if table == "" || (table[0] >= '0' && table[0] <= '9') {
+		table = "_" + table
+	}
+	return table
+}
+
+// ReadBatches decodes a file opened with OpenFile in its FileFormat, calling
+// fn with at most BatchSize rows at a time, until ctx is done.
+func (s *fileSource) ReadBatches(ctx context.Context, r io.Reader, file FileInfo, fn func(columns []string, rows [][]interface{}) error) error {
+	r = contextReader{ctx: ctx, r: r}
+	switch format := FileFormat(s.cfg, file.Path); format {
+	case "protobuf":
+		md, err := LoadProtobufMessage(s.cfg.ProtoDescriptorSet, s.cfg.ProtoMessage)
+		if err != nil {
+			return err
+		}
+		return ReadProtobufBatches(r, md, int(s.cfg.BatchSize), fn)
+	case "log":
+		p, err := NewLogParser(s.cfg)
+		if err != nil {
+			return err
+		}
+		return p.ReadBatches(r, int(s.cfg.BatchSize), fn)
+	case "csv":
+		return ReadFileBatches(r, format, int(s.cfg.BatchSize), trimCSV(s.cfg.CSVTrim, sanitizeCSV(s.cfg.CSVHeaderPolicy, file.Path, fn)))
+	default:
+		return ReadFileBatches(r, format, int(s.cfg.BatchSize), fn)
+	}
+}
+
+// contextReader fails the reads of r once ctx is done, so that a file is not
+// read to its end after the job was stopped.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// trimCSV wraps fn to trim the whitespace of the values of the columns of
+// trim, by column name or "*" for every column.
+func trimCSV(trim map[string]string, fn func(columns []string, rows [][]interface{}) error) func(columns []string, rows [][]interface{}) error {
+	if len(trim) == 0 {
+		return fn
+	}
+	return func(columns []string, rows [][]interface{}) error {
+		for i, column := range columns {
+			mode, ok := trim[column]
+			if !ok {
+				mode = trim["*"]
+			}
+			var trimFunc func(string) string
+			switch mode {
+			case "both":
+				trimFunc = strings.TrimSpace
+			case "leading":
+				trimFunc = func(s string) string { return strings.TrimLeftFunc(s, unicode.IsSpace) }
+			case "trailing":
+				trimFunc = func(s string) string { return strings.TrimRightFunc(s, unicode.IsSpace) }
+			default:
+				continue
+			}
+			for _, row := range rows {
+				if s, ok := row[i].(string); ok {
+					row[i] = trimFunc(s)
+				}
+			}
+		}
+		return fn(columns, rows)
+	}
+}
+
+func sortFiles(files []FileInfo) {
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+}
+
+// DeleteAfterSync is a no-op, files are removed or moved one by one by the
+// worker right after they have been ingested.
+func (s *fileSource) DeleteAfterSync(ctx context.Context) error {
+	return nil
+}
+
+// FileFormat returns the format of the given file, either from the config or
+// from the file extension.
+func FileFormat(cfg *config.Config, path string) string {
+	if cfg.SourceFormat != "" {
+		return strings.ToLower(cfg.SourceFormat)
+	}
+	if strings.Contains(path, "://") {
+		if u, err := url.Parse(path); err == nil {
+			path = u.Path
+		}
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".ndjson", ".jsonl":
+		return "ndjson"
+	case ".pb", ".binpb":
+		return "protobuf"
+	case ".log":
+		return "log"
+	default:
+		return "csv"
+	}
+}
+
+// ReadFileBatches decodes r in the given format and calls fn with at most
+// batchSize rows at a time.
+func ReadFileBatches(r io.Reader, format string, batchSize int, fn func(columns []string, rows [][]interface{}) error) error {
+	switch format {
+	case "csv":
+		return readCSVBatches(r, batchSize, fn)
+	case "ndjson":
+		return readNDJSONBatches(r, batchSize, fn)
+	default:
+		return fmt.Errorf("unsupported file format: %s", format)
+	}
+}
+
+func readCSVBatches(r io.Reader, batchSize int, fn func(columns []string, rows [][]interface{}) error) error {
+	reader := csv.NewReader(r)
+	columns, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "read csv header failed")
+	}
+	// editors on Windows start UTF-8 files with a byte order mark
+	columns[0] = strings.TrimPrefix(columns[0], "\ufeff")
+
+	var rows [][]interface{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "read csv record failed")
+		}
+		row := make([]interface{}, len(record))
+		for i, v := range record {
+			row[i] = v
+		}
+		rows = append(rows, row)
+		if len(rows) >= batchSize {
+			if err := fn(columns, rows); err != nil {
+				return err
+			}
+			rows = nil
+		}
+	}
+	if len(rows) > 0 {
+		return fn(columns, rows)
+	}
+	return nil
+}
+
+// readNDJSONBatches reads newline delimited JSON objects, a top-level JSON
+// array of objects is accepted as well.
+func readNDJSONBatches(r io.Reader, batchSize int, fn func(columns []string, rows [][]interface{}) error) error {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(br)
+	decoder.UseNumber()
+	inArray := first == '['
+	if inArray {
+		if _, err := decoder.Token(); err != nil {
+			return errors.Wrap(err, "decode json array failed")
+		}
+	}
+
+	var records []map[string]interface{}
+	flush := func() error {
+		if len(records) == 0 {
+			return nil
+		}
+		columns, rows := alignRecords(records)
+		records = nil
+		return fn(columns, rows)
+	}
+	for !inArray || decoder.More() {
+		record := make(map[string]interface{})
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "decode json record failed")
+		}
+		records = append(records, record)
+		if len(records) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, br.UnreadByte()
+	}
+}
+
+// alignRecords turns a batch of JSON objects into rows sharing the union of
+// their keys as columns.
+func alignRecords(records []map[string]interface{}) ([]string, [][]interface{}) {
+	seen := make(map[string]struct{})
+	var columns []string
+	for _, record := range records {
+		for k := range record {
+			if _, ok := seen[k]; !ok {
+				seen[k] = struct{}{}
+				columns = append(columns, k)
+			}
+		}
+	}
+	sort.Strings(columns)
+
+	rows := make([][]interface{}, 0, len(records))
+	for _, record := range records {
+		row := make([]interface{}, len(columns))
+		for i, column := range columns {
+			row[i] = record[column]
+		}
+		rows = append(rows, row)
+	}
+	return columns, rows
+}
@@ -0,0 +1,102 @@
+package source
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestReadFileBatchesCSV(t *testing.T) {
+	input := "id,name\n1,a\n2,b\n3,\"c,d\"\n"
+	var batches [][][]interface{}
+	err := ReadFileBatches(strings.NewReader(input), "csv", 2, func(columns []string, rows [][]interface{}) error {
+		assert.Equal(t, []string{"id", "name"}, columns)
+		batches = append(batches, rows)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(batches))
+	assert.Equal(t, []interface{}{"3", "c,d"}, batches[1][0])
+}
+
+func TestReadFileBatchesCSVWithBOM(t *testing.T) {
+	input := "\ufeffid,name\n1,a\n"
+	err := ReadFileBatches(strings.NewReader(input), "csv", 10, func(columns []string, rows [][]interface{}) error {
+		assert.Equal(t, []string{"id", "name"}, columns)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestTrimCSV(t *testing.T) {
+	input := "id,name,note\n 1 , a ,\t x \n"
+	var rows [][]interface{}
+	fn := trimCSV(map[string]string{"*": "both", "name": "leading", "note": "trailing"}, func(columns []string, r [][]interface{}) error {
+		rows = r
+		return nil
+	})
+	assert.NoError(t, ReadFileBatches(strings.NewReader(input), "csv", 10, fn))
+	assert.Equal(t, [][]interface{}{{"1", "a ", "\t x"}}, rows)
+}
+
+func TestReadFileBatchesNDJSON(t *testing.T) {
+	input := "{\"id\": 1, \"name\": \"a\"}\n\n{\"id\": 2, \"extra\": true}\n"
+	var columns []string
+	var rows [][]interface{}
+	err := ReadFileBatches(strings.NewReader(input), "ndjson", 10, func(c []string, r [][]interface{}) error {
+		columns = c
+		rows = r
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"extra", "id", "name"}, columns)
+	assert.Equal(t, 2, len(rows))
+	assert.Nil(t, rows[0][0])
+	assert.Equal(t, true, rows[1][0])
+}
+
+func TestFileFormat(t *testing.T) {
+	cfg := &config.Config{}
+	assert.Equal(t, "ndjson", FileFormat(cfg, "/data/a.ndjson"))
+	assert.Equal(t, "csv", FileFormat(cfg, "https://example.com/export.csv?page=2"))
+	assert.Equal(t, "csv", FileFormat(cfg, "/data/a.csv"))
+	cfg.SourceFormat = "NDJSON"
+	assert.Equal(t, "ndjson", FileFormat(cfg, "/data/a.txt"))
+}
+
+func TestReadFileBatchesJSONArray(t *testing.T) {
+	input := "  [{\"id\": 1}, {\"id\": 2},\n {\"id\": 3}]"
+	count := 0
+	err := ReadFileBatches(strings.NewReader(input), "ndjson", 2, func(columns []string, rows [][]interface{}) error {
+		assert.Equal(t, []string{"id"}, columns)
+		count += len(rows)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestTableNameFromPath(t *testing.T) {
+	assert.Equal(t, "orders_2024", TableNameFromPath("/drop/Orders 2024.csv"))
+	assert.Equal(t, "export", TableNameFromPath("https://example.com/v1/export.ndjson?page=1"))
+	assert.Equal(t, "_2024_q1", SanitizeTableName("2024-Q1"))
+}
+
+func TestLocalFileSourceHasTablePrivilege(t *testing.T) {
+	dir := t.TempDir()
+	src, err := NewLocalFileSource(&config.Config{SourcePath: dir})
+	assert.NoError(t, err)
+	for _, privilege := range []string{"SELECT", "DELETE"} {
+		granted, err := src.HasTablePrivilege(context.Background(), privilege)
+		assert.NoError(t, err)
+		assert.True(t, granted, privilege)
+	}
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+}
@@ -0,0 +1,121 @@
+package source
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// FTPSource archives the CSV/NDJSON files of a remote directory over FTP or
+// FTPS. A control connection serves one transfer at a time, so mu is held
+// from OpenFile until the returned reader is closed.
+type FTPSource struct {
+	fileSource
+	conn *ftp.ServerConn
+	mu   sync.Mutex
+}
+
+func NewFTPSource(cfg *config.Config) (*FTPSource, error) {
+	port := cfg.SourcePort
+	if port == 0 {
+		port = 21
+	}
+	options := []ftp.DialOption{ftp.DialWithTimeout(30 * time.Second)}
+	if cfg.FTPExplicitTLS {
+		options = append(options, ftp.DialWithExplicitTLS(&tls.Config{ServerName: cfg.SourceHost}))
+	}
+	conn, err := ftp.Dial(net.JoinHostPort(cfg.SourceHost, strconv.Itoa(port)), options...)
+	if err != nil {
+		logrus.Errorf("failed to dial ftp host: %v", err)
+		return nil, err
+	}
+	if err := conn.Login(cfg.SourceUser, cfg.SourcePass); err != nil {
+		conn.Quit()
+		return nil, err
+	}
+	return &FTPSource{
+		fileSource: fileSource{cfg: cfg},
+		conn:       conn,
+	}, nil
+}
+
+func (s *FTPSource) ListFiles(ctx context.Context) ([]FileInfo, error) {
+	s.mu.Lock()
+	entries, err := s.conn.List(s.cfg.SourcePath)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := s.filePattern()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []FileInfo
+	for _, entry := range entries {
+		if entry.Type != ftp.EntryTypeFile {
+			continue
+		}
+		if pattern != nil && !pattern.MatchString(entry.Name) {
+			continue
+		}
+		files = append(files, FileInfo{
+			Path:    path.Join(s.cfg.SourcePath, entry.Name),
+			Size:    int64(entry.Size),
+			ModTime: entry.Time,
+			Table:   TableNameFromPath(entry.Name),
+		})
+	}
+	sortFiles(files)
+	return files, nil
+}
+
+func (s *FTPSource) OpenFile(ctx context.Context, file FileInfo) (io.ReadCloser, error) {
+	s.mu.Lock()
+	resp, err := s.conn.Retr(file.Path)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	return &ftpFileReader{Response: resp, unlock: s.mu.Unlock}, nil
+}
+
+func (s *FTPSource) RemoveFile(ctx context.Context, file FileInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.Delete(file.Path)
+}
+
+func (s *FTPSource) MoveFile(ctx context.Context, file FileInfo, dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.Rename(file.Path, path.Join(dir, path.Base(file.Path)))
+}
+
+func (s *FTPSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.Quit()
+}
+
+type ftpFileReader struct {
+	*ftp.Response
+	unlock func()
+	once   sync.Once
+}
+
+func (r *ftpFileReader) Close() error {
+	err := r.Response.Close()
+	r.once.Do(r.unlock)
+	return err
+}
@@ -0,0 +1,637 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+var errSplitKeyNotSupportedByHive = errors.New("split keys are not supported by the hive source")
+
+const (
+	// columnar result sets need HIVE_CLI_SERVICE_PROTOCOL_V6 (Hive 0.13)
+	hiveProtocolV6  int32 = 5
+	hiveProtocolV10 int32 = 9
+
+	hiveTypeArray   int32 = 10
+	hiveTypeMap     int32 = 11
+	hiveTypeStruct  int32 = 12
+	hiveTypeDecimal int32 = 15
+
+	// partitions per COUNT(*) query
+	hiveCountPartitions = 100
+)
+
+// HiveSource archives Hive tables over HiveServer2 or the Spark Thrift Server,
+// one partition at a time. The partitions matching HivePartitionPattern are
+// spread over the threads, every thread using its own session.
+type HiveSource struct {
+	cfg           *config.Config
+	statsRecorder *DatabendSourceStatsRecorder
+
+	mu         sync.Mutex
+	partitions map[string][]string
+}
+
+// hiveSession is a session opened for one operation of the source, its
+// calls stop once the ctx of that operation is done.
+type hiveSession struct {
+	ctx    context.Context
+	stop   func() bool
+	client *thriftClient
+	handle thriftStruct
+}
+
+func NewHiveSource(cfg *config.Config) (*HiveSource, error) {
+	stats := NewDatabendIntesterStatsRecorder()
+	if cfg.HivePartitionPattern != "" {
+		if _, err := regexp.Compile(cfg.HivePartitionPattern); err != nil {
+			return nil, errors.Wrap(err, "invalid hivePartitionPattern")
+		}
+	}
+	return &HiveSource{
+		cfg:           cfg,
+		statsRecorder: stats,
+		partitions:    make(map[string][]string),
+	}, nil
+}
+
+func (s *HiveSource) AdjustBatchSizeAccordingToSourceDbTable(ctx context.Context) uint64 {
+	return uint64(s.cfg.BatchSize)
+}
+
+func (s *HiveSource) GetSourceReadRowsCount(ctx context.Context) (int, error) {
+	sess, err := s.openSession(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer sess.close()
+	partitions, partitioned, err := s.tablePartitions(sess)
+	if err != nil {
+		return 0, err
+	}
+	var predicates []string
+	if !partitioned {
+		predicates = []string{""}
+	}
+	for i := 0; i < len(partitions); i += hiveCountPartitions {
+		var specs []string
+		for _, partition := range partitions[i:min(i+hiveCountPartitions, len(partitions))] {
+			specs = append(specs, "("+partitionPredicate(partition)+")")
+		}
+		predicates = append(predicates, strings.Join(specs, " OR "))
+	}
+
+	total := 0
+	for _, predicate := range predicates {
+		query := fmt.Sprintf("SELECT COUNT(*) FROM %s%s", s.tableName(), s.whereClause(predicate))
+		err := sess.query(query, 1, func(columns []string, rows [][]interface{}) error {
+			count, err := toUint64(rows[0][0])
+			total += int(count)
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+func (s *HiveSource) GetMinMaxSplitKey(ctx context.Context) (uint64, uint64, error) {
+	return 0, 0, errSplitKeyNotSupportedByHive
+}
+
+func (s *HiveSource) GetMinMaxTimeSplitKey(ctx context.Context) (string, string, error) {
+	return "", "", errSplitKeyNotSupportedByHive
+}
+
+// DeleteAfterSync drops the archived partitions. Unpartitioned tables are
+// truncated, which is refused when sourceWhereCondition is set.
+func (s *HiveSource) DeleteAfterSync(ctx context.Context) error {
+	if !s.cfg.DeleteAfterSync {
+		return nil
+	}
+	dbTables, err := s.GetDbTablesAccordingToSourceDbTables(ctx)
+	if err != nil {
+		return err
+	}
+	sess, err := s.openSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.close()
+	db, table := s.cfg.SourceDB, s.cfg.SourceTable
+	defer func() { s.cfg.SourceDB, s.cfg.SourceTable = db, table }()
+	for db, tables := range dbTables {
+		for _, table := range tables {
+			s.cfg.SourceDB, s.cfg.SourceTable = db, table
+			partitions, partitioned, err := s.tablePartitions(sess)
+			if err != nil {
+				return err
+			}
+			if !partitioned {
+				if s.cfg.SourceWhereCondition != "" {
+					return errors.New("deleteAfterSync with sourceWhereCondition is not supported for unpartitioned hive tables")
+				}
+				if err := sess.exec("TRUNCATE TABLE " + s.tableName()); err != nil {
+					return err
+				}
+				logrus.Infof("truncated archived table %s.%s", db, table)
+				continue
+			}
+			for _, partition := range partitions {
+				spec := strings.ReplaceAll(partitionPredicate(partition), " AND ", ", ")
+				if err := sess.exec(fmt.Sprintf("ALTER TABLE %s DROP IF EXISTS PARTITION (%s)", s.tableName(), spec)); err != nil {
+					return err
+				}
+				logrus.Infof("dropped archived partition %s of %s.%s", partition, db, table)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *HiveSource) QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+	return nil, nil, errSplitKeyNotSupportedByHive
+}
+
+// ReadSlice archives every partition whose index modulo slices is slice, an
+// unpartitioned table is read by slice 0 alone.
+func (s *HiveSource) ReadSlice(ctx context.Context, slice, slices int, fn func(columns []string, rows [][]interface{}) error) error {
+	sess, err := s.openSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.close()
+	partitions, partitioned, err := s.tablePartitions(sess)
+	if err != nil {
+		return err
+	}
+	predicates := make([]string, 0, len(partitions))
+	for _, partition := range partitions {
+		predicates = append(predicates, partitionPredicate(partition))
+	}
+	if !partitioned {
+		predicates = []string{""}
+	}
+	for i := slice; i < len(predicates); i += slices {
+		if partitioned {
+			logrus.Infof("thread-%d: archiving partition %s of %s", slice, partitions[i], s.tableName())
+		}
+		query := fmt.Sprintf("SELECT * FROM %s%s", s.tableName(), s.whereClause(predicates[i]))
+		startTime := time.Now()
+		err := sess.query(query, s.cfg.BatchSize, func(columns []string, rows [][]interface{}) error {
+			s.statsRecorder.RecordBatch(s.cfg, slice, rows)
+			stats := s.statsRecorder.Stats(time.Since(startTime))
+			log.Printf("thread-%d: extract %d rows (%f rows/s)", slice, len(rows), stats.RowsPerSecondd)
+			return fn(columns, rows)
+		})
+		if err != nil {
+			if partitioned {
+				return errors.Wrapf(err, "archive partition %s failed", partitions[i])
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *HiveSource) GetDatabasesAccordingToSourceDbRegex(ctx context.Context, sourceDatabasePattern string) ([]string, error) {
+	sess, err := s.openSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.close()
+	var databases []string
+	err = sess.query("SHOW DATABASES", 10000, func(columns []string, rows [][]interface{}) error {
+		for _, row := range rows {
+			database := fmt.Sprint(row[0])
+			match, err := regexp.MatchString(sourceDatabasePattern, database)
+			if err != nil {
+				return err
+			}
+			if match {
+				databases = append(databases, database)
+			}
+		}
+		return nil
+	})
+	return databases, err
+}
+
+func (s *HiveSource) GetTablesAccordingToSourceTableRegex(ctx context.Context, sourceTablePattern string, databases []string) (map[string][]string, error) {
+	sess, err := s.openSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.close()
+	dbTables := make(map[string][]string)
+	for _, database := range databases {
+		var tables []string
+		err := sess.query("SHOW TABLES IN "+database, 10000, func(columns []string, rows [][]interface{}) error {
+			// Hive returns tab_name, Spark returns namespace, tableName, isTemporary
+			nameIndex := 0
+			for i, column := range columns {
+				if column == "tableName" || column == "tab_name" {
+					nameIndex = i
+				}
+			}
+			for _, row := range rows {
+				table := fmt.Sprint(row[nameIndex])
+				match, err := regexp.MatchString(sourceTablePattern, table)
+				if err != nil {
+					return err
+				}
+				if match {
+					tables = append(tables, table)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		dbTables[database] = tables
+	}
+	return dbTables, nil
+}
+
+func (s *HiveSource) GetAllSourceReadRowsCount(ctx context.Context) (int, error) {
+	allCount := 0
+	dbTables, err := s.GetDbTablesAccordingToSourceDbTables(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for db, tables := range dbTables {
+		s.cfg.SourceDB = db
+		for _, table := range tables {
+			s.cfg.SourceTable = table
+			count, err := s.GetSourceReadRowsCount(ctx)
+			if err != nil {
+				return 0, err
+			}
+			allCount += count
+		}
+	}
+	return allCount, nil
+}
+
+func (s *HiveSource) GetDbTablesAccordingToSourceDbTables(ctx context.Context) (map[string][]string, error) {
+	allDbTables := make(map[string][]string)
+	for _, sourceDbTable := range s.cfg.SourceDbTables {
+		dbTable := strings.Split(sourceDbTable, "@")
+		if len(dbTable) != 2 {
+			return nil, fmt.Errorf("invalid sourceDbTable: %s, should be a@b format", sourceDbTable)
+		}
+		dbs, err := s.GetDatabasesAccordingToSourceDbRegex(ctx, dbTable[0])
+		if err != nil {
+			return nil, fmt.Errorf("get databases according to sourceDbRegex failed: %v", err)
+		}
+		dbTables, err := s.GetTablesAccordingToSourceTableRegex(ctx, dbTable[1], dbs)
+		if err != nil {
+			return nil, fmt.Errorf("get tables according to sourceTableRegex failed: %v", err)
+		}
+		for db, tables := range dbTables {
+			allDbTables[db] = append(allDbTables[db], tables...)
+		}
+	}
+	if s.cfg.SourceDB != "" && s.cfg.SourceTable != "" {
+		allDbTables[s.cfg.SourceDB] = append(allDbTables[s.cfg.SourceDB], s.cfg.SourceTable)
+	}
+	return allDbTables, nil
+}
+
+func (s *HiveSource) tableName() string {
+	return fmt.Sprintf("`%s`.`%s`", s.cfg.SourceDB, s.cfg.SourceTable)
+}
+
+func (s *HiveSource) whereClause(predicate string) string {
+	var conditions []string
+	if predicate != "" {
+		conditions = append(conditions, "("+predicate+")")
+	}
+	if s.cfg.SourceWhereCondition != "" {
+		conditions = append(conditions, "("+s.cfg.SourceWhereCondition+")")
+	}
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// tablePartitions returns the partitions of the source table matching
+// HivePartitionPattern, and whether the table is partitioned at all.
+func (s *HiveSource) tablePartitions(sess *hiveSession) ([]string, bool, error) {
+	key := s.tableName()
+	s.mu.Lock()
+	partitions, ok := s.partitions[key]
+	s.mu.Unlock()
+	if ok {
+		return partitions, partitions != nil, nil
+	}
+
+	var pattern *regexp.Regexp
+	if s.cfg.HivePartitionPattern != "" {
+		pattern = regexp.MustCompile(s.cfg.HivePartitionPattern)
+	}
+	partitioned := true
+	err := sess.query("SHOW PARTITIONS "+key, 10000, func(columns []string, rows [][]interface{}) error {
+		for _, row := range rows {
+			partition := fmt.Sprint(row[0])
+			if pattern == nil || pattern.MatchString(partition) {
+				partitions = append(partitions, partition)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Hive: "... is not a partitioned table", Spark: "... not allowed on a table that is not partitioned"
+		if !strings.Contains(err.Error(), "not a partitioned") && !strings.Contains(err.Error(), "not partitioned") {
+			return nil, false, err
+		}
+		partitioned = false
+		partitions = nil
+	} else if partitions == nil {
+		partitions = []string{}
+	}
+	s.mu.Lock()
+	s.partitions[key] = partitions
+	s.mu.Unlock()
+	return partitions, partitioned, nil
+}
+
+// partitionPredicate turns a partition spec like "dt=2024-01-01/country=us"
+// into "dt='2024-01-01' AND country='us'".
+func partitionPredicate(partition string) string {
+	var conditions []string
+	for _, part := range strings.Split(partition, "/") {
+		key, value, _ := strings.Cut(part, "=")
+		// Hive escapes special characters of partition values like urls
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		conditions = append(conditions, fmt.Sprintf("`%s`='%s'", key, strings.ReplaceAll(value, "'", "\\'")))
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+func (s *HiveSource) openSession(ctx context.Context) (*hiveSession, error) {
+	var transport thriftTransport
+	port := s.cfg.SourcePort
+	if s.cfg.HiveTransport == "http" {
+		if port == 0 {
+			port = 10001
+		}
+		scheme := "http"
+		if s.cfg.HiveTLS {
+			scheme = "https"
+		}
+		path := s.cfg.HiveHTTPPath
+		if path == "" {
+			path = "cliservice"
+		}
+		transport = &httpTransport{
+			url:      fmt.Sprintf("%s://%s/%s", scheme, net.JoinHostPort(s.cfg.SourceHost, strconv.Itoa(port)), strings.TrimLeft(path, "/")),
+			user:     s.cfg.SourceUser,
+			password: s.cfg.SourcePass,
+			client:   &http.Client{},
+		}
+	} else {
+		if port == 0 {
+			port = 10000
+		}
+		t, err := dialSASLTransport(ctx, net.JoinHostPort(s.cfg.SourceHost, strconv.Itoa(port)), s.cfg.HiveTLS, s.cfg.SourceUser, s.cfg.SourcePass)
+		if err != nil {
+			logrus.Errorf("failed to connect to hive: %v", err)
+			return nil, err
+		}
+		transport = t
+	}
+	client := &thriftClient{transport: transport}
+	// closing the connection interrupts a call in progress over tcp
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	req := thriftStruct{1: hiveProtocolV10}
+	if s.cfg.SourceUser != "" {
+		req[2] = s.cfg.SourceUser
+		req[3] = s.cfg.SourcePass
+	}
+	resp, err := client.call(ctx, "OpenSession", req)
+	if err == nil {
+		err = hiveStatusError(resp)
+	}
+	if err != nil {
+		stop()
+		client.Close()
+		return nil, err
+	}
+	if version, _ := resp[2].(int32); version < hiveProtocolV6 {
+		stop()
+		client.Close()
+		return nil, fmt.Errorf("hive server protocol version %d is too old, columnar results need HiveServer2 0.13 or later", version)
+	}
+	handle, ok := resp[3].(thriftStruct)
+	if !ok {
+		stop()
+		client.Close()
+		return nil, errors.New("hive server returned no session handle")
+	}
+	return &hiveSession{ctx: ctx, stop: stop, client: client, handle: handle}, nil
+}
+
+func (sess *hiveSession) close() {
+	sess.stop()
+	_, _ = sess.client.call(sess.ctx, "CloseSession", thriftStruct{1: sess.handle})
+	sess.client.Close()
+}
+
+// call calls method in the session, the error of a call interrupted by
+// the end of its ctx is that of the ctx.
+func (sess *hiveSession) call(method string, req thriftStruct) (thriftStruct, error) {
+	resp, err := sess.client.call(sess.ctx, method, req)
+	if err != nil && sess.ctx.Err() != nil {
+		return nil, sess.ctx.Err()
+	}
+	return resp, err
+}
+
+func (sess *hiveSession) execute(statement string) (thriftStruct, error) {
+	resp, err := sess.call("ExecuteStatement", thriftStruct{1: sess.handle, 2: statement})
+	if err != nil {
+		return nil, err
+	}
+	if err := hiveStatusError(resp); err != nil {
+		return nil, err
+	}
+	op, ok := resp[2].(thriftStruct)
+	if !ok {
+		return nil, errors.New("hive server returned no operation handle")
+	}
+	return op, nil
+}
+
+func (sess *hiveSession) exec(statement string) error {
+	op, err := sess.execute(statement)
+	if err != nil {
+		return err
+	}
+	_, _ = sess.call("CloseOperation", thriftStruct{1: op})
+	return nil
+}
+
+// query runs statement and calls fn with every fetched block of at most
+// batchSize rows.
+func (sess *hiveSession) query(statement string, batchSize int64, fn func(columns []string, rows [][]interface{}) error) error {
+	op, err := sess.execute(statement)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = sess.call("CloseOperation", thriftStruct{1: op})
+	}()
+	if hasResultSet, _ := op[3].(bool); !hasResultSet {
+		return nil
+	}
+
+	meta, err := sess.call("GetResultSetMetadata", thriftStruct{1: op})
+	if err != nil {
+		return err
+	}
+	if err := hiveStatusError(meta); err != nil {
+		return err
+	}
+	columns, types := hiveSchema(meta[2])
+
+	for {
+		resp, err := sess.call("FetchResults", thriftStruct{1: op, 2: int32(0), 3: batchSize})
+		if err != nil {
+			return err
+		}
+		if err := hiveStatusError(resp); err != nil {
+			return err
+		}
+		rowSet, _ := resp[3].(thriftStruct)
+		rows, err := decodeHiveColumns(rowSet, types)
+		if err != nil {
+			return err
+		}
+		// hasMoreRows is not reliable across servers, stop on an empty block
+		if len(rows) == 0 {
+			return nil
+		}
+		if err := fn(columns, rows); err != nil {
+			return err
+		}
+	}
+}
+
+func hiveStatusError(resp thriftStruct) error {
+	status, _ := resp[1].(thriftStruct)
+	code, _ := status[1].(int32)
+	// SUCCESS_STATUS and SUCCESS_WITH_INFO_STATUS
+	if code == 0 || code == 1 {
+		return nil
+	}
+	message, _ := status[5].(string)
+	return fmt.Errorf("hive status %d: %s", code, message)
+}
+
+// hiveSchema returns the column names, without the "table." prefix Hive adds,
+// and the TTypeId of every column. Complex types are reported by the kind of
+// their first type entry.
+func hiveSchema(v interface{}) ([]string, []int32) {
+	schema, _ := v.(thriftStruct)
+	descs, _ := schema[1].([]interface{})
+	columns := make([]string, len(descs))
+	types := make([]int32, len(descs))
+	for i, d := range descs {
+		desc, _ := d.(thriftStruct)
+		name, _ := desc[1].(string)
+		if idx := strings.LastIndex(name, "."); idx >= 0 {
+			name = name[idx+1:]
+		}
+		columns[i] = name
+		typeDesc, _ := desc[2].(thriftStruct)
+		entries, _ := typeDesc[1].([]interface{})
+		if len(entries) == 0 {
+			continue
+		}
+		entry, _ := entries[0].(thriftStruct)
+		switch {
+		case entry[1] != nil:
+			primitive, _ := entry[1].(thriftStruct)
+			types[i], _ = primitive[1].(int32)
+		case entry[2] != nil:
+			types[i] = hiveTypeArray
+		case entry[3] != nil:
+			types[i] = hiveTypeMap
+		default:
+			types[i] = hiveTypeStruct
+		}
+	}
+	return columns, types
+}
+
+// decodeHiveColumns turns the columnar TRowSet into rows. Every TColumn is a
+// union whose only field holds the values and a bitmap of nulls.
+func decodeHiveColumns(rowSet thriftStruct, types []int32) ([][]interface{}, error) {
+	columns, _ := rowSet[3].([]interface{})
+	if len(columns) == 0 {
+		return nil, nil
+	}
+	values := make([][]interface{}, len(columns))
+	nulls := make([]string, len(columns))
+	for i, c := range columns {
+		column, _ := c.(thriftStruct)
+		for _, v := range column {
+			typed, _ := v.(thriftStruct)
+			values[i], _ = typed[1].([]interface{})
+			nulls[i], _ = typed[2].(string)
+		}
+	}
+	numRows := len(values[0])
+	rows := make([][]interface{}, numRows)
+	for r := 0; r < numRows; r++ {
+		row := make([]interface{}, len(columns))
+		for c := range columns {
+			if r >= len(values[c]) || (r/8 < len(nulls[c]) && nulls[c][r/8]&(1<<(r%8)) != 0) {
+				continue
+			}
+			row[c] = hiveValue(types, c, values[c][r])
+		}
+		rows[r] = row
+	}
+	return rows, nil
+}
+
+func hiveValue(types []int32, c int, v interface{}) interface{} {
+	if c >= len(types) {
+		return v
+	}
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	switch types[c] {
+	case hiveTypeDecimal:
+		return json.Number(s)
+	case hiveTypeArray, hiveTypeMap, hiveTypeStruct:
+		if json.Valid([]byte(s)) {
+			return json.RawMessage(s)
+		}
+	}
+	return s
+}
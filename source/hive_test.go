@@ -0,0 +1,67 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestThriftStructRoundTrip(t *testing.T) {
+	req := thriftStruct{
+		1: hiveProtocolV10,
+		2: "alice",
+		3: thriftStruct{1: "guid", 2: "secret"},
+		4: map[string]string{"hive.exec.dynamic.partition": "true"},
+		5: int64(1000),
+		6: true,
+	}
+	buf := &bytes.Buffer{}
+	assert.NoError(t, encodeThriftStruct(buf, req))
+
+	r := &thriftReader{r: bufio.NewReader(buf)}
+	decoded, err := r.readStruct()
+	assert.NoError(t, err)
+	assert.Equal(t, hiveProtocolV10, decoded[1])
+	assert.Equal(t, "alice", decoded[2])
+	assert.Equal(t, thriftStruct{1: "guid", 2: "secret"}, decoded[3])
+	assert.Equal(t, map[interface{}]interface{}{"hive.exec.dynamic.partition": "true"}, decoded[4])
+	assert.Equal(t, int64(1000), decoded[5])
+	assert.Equal(t, true, decoded[6])
+}
+
+func TestDecodeHiveColumns(t *testing.T) {
+	rowSet := thriftStruct{
+		1: int64(0),
+		2: []interface{}{},
+		3: []interface{}{
+			thriftStruct{5: thriftStruct{1: []interface{}{int64(1), int64(2), int64(3)}, 2: "\x02"}},
+			thriftStruct{7: thriftStruct{1: []interface{}{"1.50", "2.00", ""}, 2: "\x04"}},
+			thriftStruct{7: thriftStruct{1: []interface{}{`{"a":1}`, "[]", "x"}, 2: ""}},
+		},
+	}
+	rows, err := decodeHiveColumns(rowSet, []int32{4, hiveTypeDecimal, hiveTypeMap})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]interface{}{
+		{int64(1), json.Number("1.50"), json.RawMessage(`{"a":1}`)},
+		{nil, json.Number("2.00"), json.RawMessage(`[]`)},
+		{int64(3), nil, "x"},
+	}, rows)
+}
+
+func TestHiveSchema(t *testing.T) {
+	schema := thriftStruct{1: []interface{}{
+		thriftStruct{1: "t.id", 2: thriftStruct{1: []interface{}{thriftStruct{1: thriftStruct{1: int32(4)}}}}, 3: int32(1)},
+		thriftStruct{1: "t.tags", 2: thriftStruct{1: []interface{}{thriftStruct{2: thriftStruct{1: int32(1)}}}}, 3: int32(2)},
+	}}
+	columns, types := hiveSchema(schema)
+	assert.Equal(t, []string{"id", "tags"}, columns)
+	assert.Equal(t, []int32{4, hiveTypeArray}, types)
+}
+
+func TestPartitionPredicate(t *testing.T) {
+	assert.Equal(t, "`dt`='2024-01-01' AND `country`='us'", partitionPredicate("dt=2024-01-01/country=us"))
+	assert.Equal(t, "`path`='a/b'", partitionPredicate("path=a%2Fb"))
+}
@@ -0,0 +1,461 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A minimal Thrift binary protocol client, just enough of TCLIService to run
+// queries on HiveServer2 and the Spark Thrift Server. Requests are built and
+// responses decoded as thriftStruct, a map from field id to value, where
+// values are bool, int8, int16, int32, int64, float64, string, []interface{},
+// map[interface{}]interface{} or nested thriftStruct.
+
+type thriftStruct map[int16]interface{}
+
+const (
+	thriftStop   = 0
+	thriftBool   = 2
+	thriftByte   = 3
+	thriftDouble = 4
+	thriftI16    = 6
+	thriftI32    = 8
+	thriftI64    = 10
+	thriftString = 11
+	thriftRecord = 12
+	thriftMap    = 13
+	thriftSet    = 14
+	thriftList   = 15
+
+	thriftCall      = 1
+	thriftException = 3
+	thriftVersion1  = 0x80010000
+)
+
+func encodeThriftStruct(w *bytes.Buffer, s thriftStruct) error {
+	ids := make([]int, 0, len(s))
+	for id := range s {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		v := s[int16(id)]
+		t, err := thriftType(v)
+		if err != nil {
+			return errors.Wrapf(err, "field %d", id)
+		}
+		w.WriteByte(t)
+		_ = binary.Write(w, binary.BigEndian, int16(id))
+		if err := encodeThriftValue(w, v); err != nil {
+			return err
+		}
+	}
+	w.WriteByte(thriftStop)
+	return nil
+}
+
+func thriftType(v interface{}) (byte, error) {
+	switch v.(type) {
+	case bool:
+		return thriftBool, nil
+	case int8:
+		return thriftByte, nil
+	case int16:
+		return thriftI16, nil
+	case int32:
+		return thriftI32, nil
+	case int64:
+		return thriftI64, nil
+	case float64:
+		return thriftDouble, nil
+	case string:
+		return thriftString, nil
+	case thriftStruct:
+		return thriftRecord, nil
+	case map[string]string:
+		return thriftMap, nil
+	default:
+		return 0, fmt.Errorf("unsupported thrift value %T", v)
+	}
+}
+
+func encodeThriftValue(w *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			w.WriteByte(1)
+		} else {
+			w.WriteByte(0)
+		}
+	case int8:
+		w.WriteByte(byte(v))
+	case int16, int32, int64:
+		_ = binary.Write(w, binary.BigEndian, v)
+	case float64:
+		_ = binary.Write(w, binary.BigEndian, math.Float64bits(v))
+	case string:
+		_ = binary.Write(w, binary.BigEndian, int32(len(v)))
+		w.WriteString(v)
+	case thriftStruct:
+		return encodeThriftStruct(w, v)
+	case map[string]string:
+		w.WriteByte(thriftString)
+		w.WriteByte(thriftString)
+		_ = binary.Write(w, binary.BigEndian, int32(len(v)))
+		for k, val := range v {
+			_ = encodeThriftValue(w, k)
+			_ = encodeThriftValue(w, val)
+		}
+	default:
+		return fmt.Errorf("unsupported thrift value %T", v)
+	}
+	return nil
+}
+
+type thriftReader struct {
+	r *bufio.Reader
+}
+
+func (r *thriftReader) readN(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r.r, buf)
+	return buf, err
+}
+
+func (r *thriftReader) readI32() (int32, error) {
+	buf, err := r.readN(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.BigEndian.Uint32(buf)), nil
+}
+
+func (r *thriftReader) readString() (string, error) {
+	n, err := r.readI32()
+	if err != nil {
+		return "", err
+	}
+	if n < 0 {
+		return "", fmt.Errorf("negative thrift string length %d", n)
+	}
+	buf, err := r.readN(int(n))
+	return string(buf), err
+}
+
+func (r *thriftReader) readStruct() (thriftStruct, error) {
+	s := make(thriftStruct)
+	for {
+		t, err := r.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if t == thriftStop {
+			return s, nil
+		}
+		buf, err := r.readN(2)
+		if err != nil {
+			return nil, err
+		}
+		v, err := r.readValue(t)
+		if err != nil {
+			return nil, err
+		}
+		s[int16(binary.BigEndian.Uint16(buf))] = v
+	}
+}
+
+func (r *thriftReader) readValue(t byte) (interface{}, error) {
+	switch t {
+	case thriftBool:
+		b, err := r.r.ReadByte()
+		return b != 0, err
+	case thriftByte:
+		b, err := r.r.ReadByte()
+		return int8(b), err
+	case thriftI16:
+		buf, err := r.readN(2)
+		if err != nil {
+			return nil, err
+		}
+		return int16(binary.BigEndian.Uint16(buf)), nil
+	case thriftI32:
+		return r.readI32()
+	case thriftI64:
+		buf, err := r.readN(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint64(buf)), nil
+	case thriftDouble:
+		buf, err := r.readN(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(buf)), nil
+	case thriftString:
+		return r.readString()
+	case thriftRecord:
+		return r.readStruct()
+	case thriftList, thriftSet:
+		elemType, err := r.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		n, err := r.readI32()
+		if err != nil {
+			return nil, err
+		}
+		list := make([]interface{}, 0, n)
+		for i := int32(0); i < n; i++ {
+			v, err := r.readValue(elemType)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case thriftMap:
+		header, err := r.readN(2)
+		if err != nil {
+			return nil, err
+		}
+		n, err := r.readI32()
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := int32(0); i < n; i++ {
+			k, err := r.readValue(header[0])
+			if err != nil {
+				return nil, err
+			}
+			v, err := r.readValue(header[1])
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unknown thrift type %d", t)
+	}
+}
+
+// thriftTransport sends one encoded call and returns a reader positioned at
+// the reply.
+type thriftTransport interface {
+	roundTrip(ctx context.Context, call []byte) (*bufio.Reader, error)
+	Close() error
+}
+
+// saslTransport speaks the SASL PLAIN framed transport HiveServer2 uses in
+// binary mode, every message is prefixed by its length.
+type saslTransport struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+const (
+	saslStart    = 1
+	saslOK       = 2
+	saslComplete = 5
+)
+
+func dialSASLTransport(ctx context.Context, address string, useTLS bool, user, password string) (*saslTransport, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if useTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{}}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := &saslTransport{conn: conn, r: bufio.NewReader(conn)}
+	if user == "" {
+		user = "anonymous"
+	}
+	if password == "" {
+		password = "anonymous"
+	}
+	if err := t.writeSASL(saslStart, []byte("PLAIN")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := t.writeSASL(saslOK, []byte("\x00"+user+"\x00"+password)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	status, err := t.r.ReadByte()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	payload, err := t.readFrame()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if status != saslComplete {
+		conn.Close()
+		return nil, fmt.Errorf("sasl handshake failed, status %d: %s", status, string(payload))
+	}
+	return t, nil
+}
+
+func (t *saslTransport) writeSASL(status byte, payload []byte) error {
+	buf := make([]byte, 5, 5+len(payload))
+	buf[0] = status
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(payload)))
+	_, err := t.conn.Write(append(buf, payload...))
+	return err
+}
+
+func (t *saslTransport) readFrame() ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(t.r, header); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header))
+	_, err := io.ReadFull(t.r, payload)
+	return payload, err
+}
+
+func (t *saslTransport) roundTrip(ctx context.Context, call []byte) (*bufio.Reader, error) {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(call)))
+	if _, err := t.conn.Write(append(header, call...)); err != nil {
+		return nil, err
+	}
+	// a reply may span several frames, read frames until the message is whole
+	return bufio.NewReader(&saslFrameReader{t: t}), nil
+}
+
+func (t *saslTransport) Close() error {
+	return t.conn.Close()
+}
+
+type saslFrameReader struct {
+	t   *saslTransport
+	buf []byte
+}
+
+func (r *saslFrameReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		frame, err := r.t.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = frame
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// httpTransport posts every call to the HTTP endpoint HiveServer2 serves in
+// http transport mode.
+type httpTransport struct {
+	url      string
+	user     string
+	password string
+	client   *http.Client
+}
+
+func (t *httpTransport) roundTrip(ctx context.Context, call []byte) (*bufio.Reader, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(call))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-thrift")
+	if t.user != "" {
+		req.SetBasicAuth(t.user, t.password)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, errors.Errorf("thrift http request failed, status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+	return bufio.NewReader(bytes.NewReader(body)), nil
+}
+
+func (t *httpTransport) Close() error {
+	return nil
+}
+
+type thriftClient struct {
+	transport thriftTransport
+	seqID     int32
+}
+
+// call invokes method with req as its only argument and returns the success
+// field of the result.
+func (c *thriftClient) call(ctx context.Context, method string, req thriftStruct) (thriftStruct, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	seqID := atomic.AddInt32(&c.seqID, 1)
+	buf := &bytes.Buffer{}
+	_ = binary.Write(buf, binary.BigEndian, uint32(thriftVersion1|thriftCall))
+	_ = encodeThriftValue(buf, method)
+	_ = binary.Write(buf, binary.BigEndian, seqID)
+	if err := encodeThriftStruct(buf, thriftStruct{1: req}); err != nil {
+		return nil, err
+	}
+	br, err := c.transport.roundTrip(ctx, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	r := &thriftReader{r: br}
+	version, err := r.readI32()
+	if err != nil {
+		return nil, err
+	}
+	if uint32(version)&0xffff0000 != thriftVersion1 {
+		return nil, fmt.Errorf("bad thrift message version %x", uint32(version))
+	}
+	if _, err := r.readString(); err != nil {
+		return nil, err
+	}
+	if _, err := r.readI32(); err != nil {
+		return nil, err
+	}
+	result, err := r.readStruct()
+	if err != nil {
+		return nil, err
+	}
+	if version&0xff == thriftException {
+		return nil, fmt.Errorf("thrift %s failed: %v", method, result[1])
+	}
+	success, ok := result[0].(thriftStruct)
+	if !ok {
+		return nil, fmt.Errorf("thrift %s returned no result", method)
+	}
+	return success, nil
+}
+
+func (c *thriftClient) Close() error {
+	return c.transport.Close()
+}
@@ -0,0 +1,227 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// HTTPSource archives CSV/JSON documents served over HTTP(S). Every entry of
+// SourceURLs is one file; when SourceNextCursorPath is set every entry is
+// the first page of a paginated JSON API instead, and the records of all
+// pages are streamed as NDJSON.
+type HTTPSource struct {
+	fileSource
+	client *http.Client
+}
+
+func NewHTTPSource(cfg *config.Config) (*HTTPSource, error) {
+	if cfg.SourceNextCursorPath != "" {
+		cfg.SourceFormat = "ndjson"
+	}
+	// the header timeout is set on a copy of the default transport, or on a
+	// plain one when the default was replaced, other clients keep theirs
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if defaultTransport, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport = defaultTransport.Clone()
+	}
+	transport.ResponseHeaderTimeout = 60 * time.Second
+	return &HTTPSource{
+		fileSource: fileSource{cfg: cfg},
+		client:     &http.Client{Transport: transport},
+	}, nil
+}
+
+// ListFiles returns the configured urls. Size and ModTime are taken from a
+// HEAD request when the server answers it, otherwise the manifest falls back
+// to comparing content hashes.
+func (s *HTTPSource) ListFiles(ctx context.Context) ([]FileInfo, error) {
+	var files []FileInfo
+	for _, u := range s.cfg.SourceURLs {
+		file := FileInfo{Path: u, Size: -1, Table: TableNameFromPath(u)}
+		if s.cfg.SourceNextCursorPath == "" {
+			if resp, err := s.do(ctx, http.MethodHead, u); err == nil {
+				resp.Body.Close()
+				file.Size = resp.ContentLength
+				if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+					file.ModTime = t
+				}
+			}
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func (s *HTTPSource) OpenFile(ctx context.Context, file FileInfo) (io.ReadCloser, error) {
+	if s.cfg.SourceNextCursorPath == "" {
+		resp, err := s.do(ctx, http.MethodGet, file.Path)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body, nil
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(s.streamPages(ctx, file.Path, pw))
+	}()
+	return pr, nil
+}
+
+func (s *HTTPSource) RemoveFile(ctx context.Context, file FileInfo) error {
+	return ErrNotSupportedByFileSource
+}
+
+func (s *HTTPSource) MoveFile(ctx context.Context, file FileInfo, dir string) error {
+	return ErrNotSupportedByFileSource
+}
+
+// streamPages follows the cursor from pageURL on and writes the records of
+// every page to w, one JSON object per line. A page without records ends
+// the pagination, and a cursor leading back to a page already fetched is an
+// error, so an API echoing its cursor can't loop forever.
+func (s *HTTPSource) streamPages(ctx context.Context, pageURL string, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	fetched := make(map[string]bool)
+	for pageURL != "" {
+		if fetched[pageURL] {
+			return fmt.Errorf("the cursor of the page before leads back to %s, already fetched", pageURL)
+		}
+		fetched[pageURL] = true
+		resp, err := s.do(ctx, http.MethodGet, pageURL)
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		var doc interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil {
+			return errors.Wrapf(err, "decode page %s failed", pageURL)
+		}
+		records, _ := lookupJSONPath(doc, s.cfg.SourceRecordsPath)
+		items, ok := records.([]interface{})
+		if !ok {
+			return fmt.Errorf("records path %q of page %s is not an array", s.cfg.SourceRecordsPath, pageURL)
+		}
+		for _, item := range items {
+			if err := encoder.Encode(item); err != nil {
+				return err
+			}
+		}
+		logrus.Infof("fetched %d records from %s", len(items), pageURL)
+		if len(items) == 0 {
+			return nil
+		}
+
+		cursor, _ := lookupJSONPath(doc, s.cfg.SourceNextCursorPath)
+		pageURL, err = nextPageURL(pageURL, cursor, s.cfg.SourceCursorParam)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// do sends the request, retrying network errors, 429 and 5xx responses.
+func (s *HTTPSource) do(ctx context.Context, method, u string) (*http.Response, error) {
+	var resp *http.Response
+	err := retry.Do(
+		func() error {
+			req, err := http.NewRequestWithContext(ctx, method, u, nil)
+			if err != nil {
+				return retry.Unrecoverable(err)
+			}
+			for k, v := range s.cfg.SourceHTTPHeaders {
+				req.Header.Set(k, os.ExpandEnv(v))
+			}
+			r, err := s.client.Do(req)
+			if err != nil {
+				return err
+			}
+			if r.StatusCode >= 400 {
+				msg, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+				r.Body.Close()
+				err := errors.Errorf("%s %s failed, status code: %d, body: %s", method, u, r.StatusCode, string(msg))
+				if r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= 500 {
+					return err
+				}
+				return retry.Unrecoverable(err)
+			}
+			resp = r
+			return nil
+		},
+		retry.Context(ctx),
+		retry.Attempts(5),
+		retry.Delay(time.Second),
+		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
+	)
+	return resp, err
+}
+
+// lookupJSONPath walks a dot separated path like "data.items" into doc, an
+// empty path returns doc itself.
+func lookupJSONPath(doc interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return doc, true
+	}
+	current := doc
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = obj[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// nextPageURL builds the url of the page after current. A cursor is either
+// passed in cursorParam or, when cursorParam is empty, is the (possibly
+// relative) url of the next page itself.
+func nextPageURL(current string, cursor interface{}, cursorParam string) (string, error) {
+	if cursor == nil {
+		return "", nil
+	}
+	next := fmt.Sprint(cursor)
+	if next == "" {
+		return "", nil
+	}
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+	if cursorParam == "" {
+		ref, err := url.Parse(next)
+		if err != nil {
+			return "", err
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+	query := base.Query()
+	query.Set(cursorParam, next)
+	base.RawQuery = query.Encode()
+	return base.String(), nil
+}
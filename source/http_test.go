@@ -0,0 +1,103 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestHTTPSourcePagination(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			fmt.Fprint(w, `{"data": {"items": [{"id": 1}, {"id": 2}]}, "next": "c2"}`)
+		case "c2":
+			fmt.Fprint(w, `{"data": {"items": [{"id": 3}]}, "next": null}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		SourceURLs:           []string{server.URL + "/export"},
+		SourceHTTPHeaders:    map[string]string{"Authorization": "Bearer token"},
+		SourceRecordsPath:    "data.items",
+		SourceNextCursorPath: "next",
+		SourceCursorParam:    "cursor",
+	}
+	src, err := NewHTTPSource(cfg)
+	assert.NoError(t, err)
+	files, err := src.ListFiles(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(files))
+
+	r, err := src.OpenFile(context.Background(), files[0])
+	assert.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n", string(data))
+	assert.Equal(t, "ndjson", FileFormat(cfg, files[0].Path))
+}
+
+func TestHTTPSourceRepeatedCursor(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			fmt.Fprint(w, `{"items": [{"id": 1}], "next": "c2"}`)
+		case "c2":
+			fmt.Fprint(w, `{"items": [{"id": 2}], "next": "c3"}`)
+		case "c3":
+			if r.URL.Query().Get("empty") != "" {
+				// the last page echoes its cursor
+				fmt.Fprint(w, `{"items": [], "next": "c3"}`)
+				return
+			}
+			fmt.Fprint(w, `{"items": [{"id": 3}], "next": "c2"}`)
+		}
+	}))
+	defer server.Close()
+
+	read := func(path string) (string, error) {
+		src, err := NewHTTPSource(&config.Config{SourceURLs: []string{path}, SourceRecordsPath: "items",
+			SourceNextCursorPath: "next", SourceCursorParam: "cursor"})
+		assert.NoError(t, err)
+		r, err := src.OpenFile(context.Background(), FileInfo{Path: path})
+		assert.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		return string(data), err
+	}
+	// an empty page ends the pagination
+	data, err := read(server.URL + "/export?empty=1")
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"id\":1}\n{\"id\":2}\n", data)
+
+	// a cursor leading back to a page is an error, not a loop
+	_, err = read(server.URL + "/export")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "already fetched")
+}
+
+func TestNextPageURL(t *testing.T) {
+	next, err := nextPageURL("https://api.example.com/v1/rows?limit=10", "/v1/rows?page=2", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://api.example.com/v1/rows?page=2", next)
+
+	next, err = nextPageURL("https://api.example.com/v1/rows?limit=10", "abc", "after")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://api.example.com/v1/rows?after=abc&limit=10", next)
+
+	next, err = nextPageURL("https://api.example.com/v1/rows", nil, "after")
+	assert.NoError(t, err)
+	assert.Equal(t, "", next)
+}
@@ -0,0 +1,536 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+var errSplitKeyNotSupportedByInfluxDB = errors.New("split keys are not supported by the influxdb source")
+
+// InfluxDBSource exports a measurement between InfluxStart and InfluxStop in
+// chunks of InfluxChunk, spread over the threads. InfluxQL is used for v1
+// and Flux for v2; with InfluxAggregateWindow set every chunk is downsampled
+// by the server before it is ingested.
+type InfluxDBSource struct {
+	cfg           *config.Config
+	client        *http.Client
+	start         time.Time
+	stop          time.Time
+	chunk         time.Duration
+	statsRecorder *DatabendSourceStatsRecorder
+}
+
+func NewInfluxDBSource(cfg *config.Config) (*InfluxDBSource, error) {
+	stats := NewDatabendIntesterStatsRecorder()
+	start, err := time.Parse(time.RFC3339, cfg.InfluxStart)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid influxStart")
+	}
+	// the config check sets it to the start of the job by default
+	stop, err := time.Parse(time.RFC3339, cfg.InfluxStop)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid influxStop")
+	}
+	chunk := time.Hour
+	if cfg.InfluxChunk != "" {
+		chunk, err = time.ParseDuration(cfg.InfluxChunk)
+		if err != nil || chunk <= 0 {
+			return nil, fmt.Errorf("invalid influxChunk: %s", cfg.InfluxChunk)
+		}
+	}
+	if cfg.InfluxVersion == 0 {
+		cfg.InfluxVersion = 1
+	}
+	if cfg.InfluxAggregateFunction == "" {
+		cfg.InfluxAggregateFunction = "mean"
+	}
+	return &InfluxDBSource{
+		cfg:           cfg,
+		client:        &http.Client{Timeout: 10 * time.Minute},
+		start:         start,
+		stop:          stop,
+		chunk:         chunk,
+		statsRecorder: stats,
+	}, nil
+}
+
+func (s *InfluxDBSource) AdjustBatchSizeAccordingToSourceDbTable(ctx context.Context) uint64 {
+	return uint64(s.cfg.BatchSize)
+}
+
+// GetSourceReadRowsCount counts the rows of the chunk queries on the
+// server, so downsampled exports are verified against what was ingested
+// rather than the raw points. InfluxQL counts the values of every field, the
+// rows are those of the field found in most of them.
+func (s *InfluxDBSource) GetSourceReadRowsCount(ctx context.Context) (int, error) {
+	count := 0
+	for _, chunk := range timeChunks(s.start, s.stop, s.chunk) {
+		var records []map[string]interface{}
+		err := retry.Do(
+			func() error {
+				var err error
+				if s.cfg.InfluxVersion == 2 {
+					records, err = s.queryFlux(ctx, s.fluxCountQuery(chunk[0], chunk[1]))
+				} else {
+					records, err = s.queryInfluxQL(ctx, s.cfg.SourceDB, s.influxQLCountQuery(chunk[0], chunk[1]))
+				}
+				return err
+			},
+			retry.Context(ctx),
+			retry.Attempts(5),
+			retry.Delay(time.Second),
+			retry.DelayType(retry.BackOffDelay),
+			retry.LastErrorOnly(true),
+		)
+		if err != nil {
+			return 0, err
+		}
+		n, err := influxCount(records)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// influxCount reads the count of the rows from the result of a count query:
+// the largest count column of InfluxQL, or the _time count of Flux.
+func influxCount(records []map[string]interface{}) (int, error) {
+	count := 0
+	for _, record := range records {
+		for column, value := range record {
+			if column == "time" {
+				continue
+			}
+			n, err := strconv.ParseInt(fmt.Sprint(value), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid count %s of %v", column, value)
+			}
+			count = max(count, int(n))
+		}
+	}
+	return count, nil
+}
+
+func (s *InfluxDBSource) GetMinMaxSplitKey(ctx context.Context) (uint64, uint64, error) {
+	return 0, 0, errSplitKeyNotSupportedByInfluxDB
+}
+
+func (s *InfluxDBSource) GetMinMaxTimeSplitKey(ctx context.Context) (string, string, error) {
+	return "", "", errSplitKeyNotSupportedByInfluxDB
+}
+
+// DeleteAfterSync deletes the exported time range of the archived
+// measurements.
+func (s *InfluxDBSource) DeleteAfterSync(ctx context.Context) error {
+	if !s.cfg.DeleteAfterSync {
+		return nil
+	}
+	dbTables, err := s.GetDbTablesAccordingToSourceDbTables(ctx)
+	if err != nil {
+		return err
+	}
+	for db, measurements := range dbTables {
+		for _, measurement := range measurements {
+			if s.cfg.InfluxVersion == 2 {
+				body := map[string]string{
+					"start":     s.start.Format(time.RFC3339Nano),
+					"stop":      s.stop.Format(time.RFC3339Nano),
+					"predicate": fmt.Sprintf("_measurement=%s", strconv.Quote(measurement)),
+				}
+				data, _ := json.Marshal(body)
+				u := fmt.Sprintf("/api/v2/delete?org=%s&bucket=%s", url.QueryEscape(s.cfg.InfluxOrg), url.QueryEscape(db))
+				err = s.request(ctx, http.MethodPost, u, bytes.NewReader(data), nil)
+			} else {
+				q := fmt.Sprintf("DELETE FROM %s WHERE time >= '%s' AND time < '%s'", quoteInfluxIdentifier(measurement),
+					s.start.Format(time.RFC3339Nano), s.stop.Format(time.RFC3339Nano))
+				err = s.request(ctx, http.MethodPost, s.influxQLPath(db, q), nil, nil)
+			}
+			if err != nil {
+				return err
+			}
+			logrus.Infof("deleted archived points of %s.%s", db, measurement)
+		}
+	}
+	return nil
+}
+
+func (s *InfluxDBSource) QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+	return nil, nil, errSplitKeyNotSupportedByInfluxDB
+}
+
+// ReadSlice exports every chunk whose index modulo slices is slice, in
+// batches of BatchSize rows.
+func (s *InfluxDBSource) ReadSlice(ctx context.Context, slice, slices int, fn func(columns []string, rows [][]interface{}) error) error {
+	chunks := timeChunks(s.start, s.stop, s.chunk)
+	for i := slice; i < len(chunks); i += slices {
+		startTime := time.Now()
+		records, err := s.queryChunk(ctx, chunks[i][0], chunks[i][1])
+		if err != nil {
+			return errors.Wrapf(err, "read chunk [%s, %s) failed", chunks[i][0].Format(time.RFC3339), chunks[i][1].Format(time.RFC3339))
+		}
+		for len(records) > 0 {
+			n := min(len(records), int(s.cfg.BatchSize))
+			columns, rows := alignRecords(records[:n])
+			records = records[n:]
+			s.statsRecorder.RecordBatch(s.cfg, slice, rows)
+			stats := s.statsRecorder.Stats(time.Since(startTime))
+			log.Printf("thread-%d: extract %d rows (%f rows/s)", slice, len(rows), stats.RowsPerSecondd)
+			if err := fn(columns, rows); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetDatabasesAccordingToSourceDbRegex returns sourceDB as is, the database or
+// bucket is always named explicitly.
+func (s *InfluxDBSource) GetDatabasesAccordingToSourceDbRegex(ctx context.Context, sourceDatabasePattern string) ([]string, error) {
+	return []string{s.cfg.SourceDB}, nil
+}
+
+func (s *InfluxDBSource) GetTablesAccordingToSourceTableRegex(ctx context.Context, sourceTablePattern string, databases []string) (map[string][]string, error) {
+	pattern, err := regexp.Compile(sourceTablePattern)
+	if err != nil {
+		return nil, err
+	}
+	dbTables := make(map[string][]string)
+	for _, database := range databases {
+		var records []map[string]interface{}
+		var key string
+		if s.cfg.InfluxVersion == 2 {
+			flux := fmt.Sprintf("import \"influxdata/influxdb/schema\"\nschema.measurements(bucket: %s)", strconv.Quote(database))
+			records, err = s.queryFlux(ctx, flux)
+			key = "_value"
+		} else {
+			records, err = s.queryInfluxQL(ctx, database, "SHOW MEASUREMENTS")
+			key = "name"
+		}
+		if err != nil {
+			return nil, err
+		}
+		var tables []string
+		for _, record := range records {
+			name := fmt.Sprint(record[key])
+			if pattern.MatchString(name) {
+				tables = append(tables, name)
+			}
+		}
+		dbTables[database] = tables
+	}
+	return dbTables, nil
+}
+
+func (s *InfluxDBSource) GetAllSourceReadRowsCount(ctx context.Context) (int, error) {
+	allCount := 0
+	dbTables, err := s.GetDbTablesAccordingToSourceDbTables(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for db, tables := range dbTables {
+		s.cfg.SourceDB = db
+		for _, table := range tables {
+			s.cfg.SourceTable = table
+			count, err := s.GetSourceReadRowsCount(ctx)
+			if err != nil {
+				return 0, err
+			}
+			allCount += count
+		}
+	}
+	return allCount, nil
+}
+
+func (s *InfluxDBSource) GetDbTablesAccordingToSourceDbTables(ctx context.Context) (map[string][]string, error) {
+	allDbTables := make(map[string][]string)
+	for _, sourceDbTable := range s.cfg.SourceDbTables {
+		dbTable := strings.Split(sourceDbTable, "@")
+		if len(dbTable) != 2 {
+			return nil, fmt.Errorf("invalid sourceDbTable: %s, should be a@b format", sourceDbTable)
+		}
+		dbTables, err := s.GetTablesAccordingToSourceTableRegex(ctx, dbTable[1], []string{dbTable[0]})
+		if err != nil {
+			return nil, fmt.Errorf("get tables according to sourceTableRegex failed: %v", err)
+		}
+		for db, tables := range dbTables {
+			allDbTables[db] = append(allDbTables[db], tables...)
+		}
+	}
+	if s.cfg.SourceDB != "" && s.cfg.SourceTable != "" {
+		allDbTables[s.cfg.SourceDB] = append(allDbTables[s.cfg.SourceDB], s.cfg.SourceTable)
+	}
+	return allDbTables, nil
+}
+
+// queryChunk returns the rows of the measurement in [start, end), retrying
+// the whole query since nothing of it has been ingested yet.
+func (s *InfluxDBSource) queryChunk(ctx context.Context, start, end time.Time) ([]map[string]interface{}, error) {
+	var records []map[string]interface{}
+	err := retry.Do(
+		func() error {
+			var err error
+			if s.cfg.InfluxVersion == 2 {
+				records, err = s.queryFlux(ctx, s.fluxQuery(start, end))
+			} else {
+				records, err = s.queryInfluxQL(ctx, s.cfg.SourceDB, s.influxQLQuery(start, end))
+			}
+			return err
+		},
+		retry.Context(ctx),
+		retry.Attempts(5),
+		retry.Delay(time.Second),
+		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
+	)
+	return records, err
+}
+
+func (s *InfluxDBSource) influxQLQuery(start, end time.Time) string {
+	where := fmt.Sprintf("time >= '%s' AND time < '%s'", start.Format(time.RFC3339Nano), end.Format(time.RFC3339Nano))
+	if s.cfg.SourceWhereCondition != "" {
+		where += fmt.Sprintf(" AND (%s)", s.cfg.SourceWhereCondition)
+	}
+	measurement := quoteInfluxIdentifier(s.cfg.SourceTable)
+	if s.cfg.InfluxAggregateWindow == "" {
+		return fmt.Sprintf("SELECT * FROM %s WHERE %s", measurement, where)
+	}
+	return fmt.Sprintf("SELECT %s(*) FROM %s WHERE %s GROUP BY time(%s), * fill(none)",
+		s.cfg.InfluxAggregateFunction, measurement, where, s.cfg.InfluxAggregateWindow)
+}
+
+// influxQLCountQuery counts the values of the fields of the rows of the
+// chunk query.
+func (s *InfluxDBSource) influxQLCountQuery(start, end time.Time) string {
+	return fmt.Sprintf("SELECT count(*) FROM (%s)", s.influxQLQuery(start, end))
+}
+
+func (s *InfluxDBSource) fluxQuery(start, end time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "from(bucket: %s)\n", strconv.Quote(s.cfg.SourceDB))
+	fmt.Fprintf(&b, "  |> range(start: %s, stop: %s)\n", start.Format(time.RFC3339Nano), end.Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "  |> filter(fn: (r) => r._measurement == %s)\n", strconv.Quote(s.cfg.SourceTable))
+	if s.cfg.SourceWhereCondition != "" {
+		fmt.Fprintf(&b, "  |> filter(fn: (r) => %s)\n", s.cfg.SourceWhereCondition)
+	}
+	if s.cfg.InfluxAggregateWindow != "" {
+		fmt.Fprintf(&b, "  |> aggregateWindow(every: %s, fn: %s, createEmpty: false)\n",
+			s.cfg.InfluxAggregateWindow, s.cfg.InfluxAggregateFunction)
+	}
+	b.WriteString("  |> pivot(rowKey: [\"_time\"], columnKey: [\"_field\"], valueColumn: \"_value\")\n")
+	b.WriteString("  |> drop(columns: [\"_start\", \"_stop\", \"_measurement\"])")
+	return b.String()
+}
+
+// fluxCountQuery counts the rows of the chunk query in a single table.
+func (s *InfluxDBSource) fluxCountQuery(start, end time.Time) string {
+	return s.fluxQuery(start, end) + "\n  |> group()\n  |> count(column: \"_time\")\n  |> keep(columns: [\"_time\"])"
+}
+
+func (s *InfluxDBSource) influxQLPath(db, q string) string {
+	return fmt.Sprintf("/query?db=%s&q=%s", url.QueryEscape(db), url.QueryEscape(q))
+}
+
+func (s *InfluxDBSource) queryInfluxQL(ctx context.Context, db, q string) ([]map[string]interface{}, error) {
+	var records []map[string]interface{}
+	err := s.request(ctx, http.MethodGet, s.influxQLPath(db, q), nil, func(r io.Reader) error {
+		var err error
+		records, err = parseInfluxQLResponse(r)
+		return err
+	})
+	return records, err
+}
+
+func (s *InfluxDBSource) queryFlux(ctx context.Context, flux string) ([]map[string]interface{}, error) {
+	body := map[string]interface{}{
+		"query":   flux,
+		"type":    "flux",
+		"dialect": map[string]interface{}{"header": true, "annotations": []string{"datatype"}},
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	var records []map[string]interface{}
+	u := "/api/v2/query?org=" + url.QueryEscape(s.cfg.InfluxOrg)
+	err = s.request(ctx, http.MethodPost, u, bytes.NewReader(data), func(r io.Reader) error {
+		var err error
+		records, err = parseFluxCSV(r)
+		return err
+	})
+	return records, err
+}
+
+func (s *InfluxDBSource) request(ctx context.Context, method, path string, body io.Reader, fn func(r io.Reader) error) error {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(s.cfg.InfluxURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	if s.cfg.InfluxVersion == 2 {
+		req.Header.Set("Authorization", "Token "+s.cfg.InfluxToken)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/csv")
+	} else if s.cfg.SourceUser != "" {
+		req.SetBasicAuth(s.cfg.SourceUser, s.cfg.SourcePass)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("influxdb %s %s failed, status code: %d, body: %s", method, path, resp.StatusCode, string(msg))
+	}
+	if fn == nil {
+		return nil
+	}
+	return fn(resp.Body)
+}
+
+// parseInfluxQLResponse flattens the series of a v1 /query response into
+// records, the tags of grouped series become columns.
+func parseInfluxQLResponse(r io.Reader) ([]map[string]interface{}, error) {
+	var resp struct {
+		Results []struct {
+			Series []struct {
+				Name    string            `json:"name"`
+				Tags    map[string]string `json:"tags"`
+				Columns []string          `json:"columns"`
+				Values  [][]interface{}   `json:"values"`
+			} `json:"series"`
+			Error string `json:"error"`
+		} `json:"results"`
+		Error string `json:"error"`
+	}
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	var records []map[string]interface{}
+	for _, result := range resp.Results {
+		if result.Error != "" {
+			return nil, errors.New(result.Error)
+		}
+		for _, series := range result.Series {
+			for _, values := range series.Values {
+				record := make(map[string]interface{}, len(series.Columns)+len(series.Tags))
+				for k, v := range series.Tags {
+					record[k] = v
+				}
+				for i, column := range series.Columns {
+					if i < len(values) {
+						record[column] = values[i]
+					}
+				}
+				records = append(records, record)
+			}
+		}
+	}
+	return records, nil
+}
+
+// parseFluxCSV reads the annotated CSV of a v2 query. Every table starts with
+// a #datatype annotation followed by its header, values are converted
+// according to the annotation.
+func parseFluxCSV(r io.Reader) ([]map[string]interface{}, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	var datatypes, header []string
+	var records []map[string]interface{}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) > 0 && row[0] == "#datatype" {
+			datatypes = row
+			header = nil
+			continue
+		}
+		if header == nil {
+			header = row
+			if len(header) > 0 && header[0] == "error" {
+				row, _ = reader.Read()
+				return nil, fmt.Errorf("flux query failed: %s", strings.Join(row, ", "))
+			}
+			continue
+		}
+		record := make(map[string]interface{}, len(header))
+		for i, column := range header {
+			if i == 0 || column == "result" || column == "table" || i >= len(row) {
+				continue
+			}
+			datatype := ""
+			if i < len(datatypes) {
+				datatype = datatypes[i]
+			}
+			value, err := fluxValue(datatype, row[i])
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse column %s", column)
+			}
+			record[column] = value
+		}
+		records = append(records, record)
+	}
+}
+
+func fluxValue(datatype, value string) (interface{}, error) {
+	if value == "" {
+		return nil, nil
+	}
+	switch datatype {
+	case "double":
+		return strconv.ParseFloat(value, 64)
+	case "long":
+		return strconv.ParseInt(value, 10, 64)
+	case "unsignedLong":
+		return strconv.ParseUint(value, 10, 64)
+	case "boolean":
+		return strconv.ParseBool(value)
+	default:
+		return value, nil
+	}
+}
+
+// timeChunks cuts [start, stop) into consecutive ranges of at most chunk.
+func timeChunks(start, stop time.Time, chunk time.Duration) [][2]time.Time {
+	var chunks [][2]time.Time
+	for t := start; t.Before(stop); t = t.Add(chunk) {
+		end := t.Add(chunk)
+		if end.After(stop) {
+			end = stop
+		}
+		chunks = append(chunks, [2]time.Time{t, end})
+	}
+	return chunks
+}
+
+func quoteInfluxIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `\"`) + `"`
+}
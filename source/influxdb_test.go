@@ -0,0 +1,79 @@
+package source
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestParseInfluxQLResponse(t *testing.T) {
+	body := `{"results": [{"series": [
+		{"name": "cpu", "tags": {"host": "a"}, "columns": ["time", "mean_usage"], "values": [["2024-01-01T00:00:00Z", 1.5]]},
+		{"name": "cpu", "tags": {"host": "b"}, "columns": ["time", "mean_usage"], "values": [["2024-01-01T00:00:00Z", 2]]}]}]}`
+	records, err := parseInfluxQLResponse(strings.NewReader(body))
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{"host": "a", "time": "2024-01-01T00:00:00Z", "mean_usage": json.Number("1.5")},
+		{"host": "b", "time": "2024-01-01T00:00:00Z", "mean_usage": json.Number("2")},
+	}, records)
+
+	_, err = parseInfluxQLResponse(strings.NewReader(`{"results": [{"error": "database not found: x"}]}`))
+	assert.EqualError(t, err, "database not found: x")
+}
+
+func TestParseFluxCSV(t *testing.T) {
+	body := "#datatype,string,long,dateTime:RFC3339,string,double,boolean\r\n" +
+		",result,table,_time,host,usage,up\r\n" +
+		",_result,0,2024-01-01T00:00:00Z,a,1.5,true\r\n" +
+		",_result,0,2024-01-01T00:05:00Z,a,,false\r\n" +
+		"\r\n" +
+		"#datatype,string,long,dateTime:RFC3339,string,long\r\n" +
+		",result,table,_time,host,count\r\n" +
+		",_result,1,2024-01-01T00:00:00Z,b,7\r\n"
+	records, err := parseFluxCSV(strings.NewReader(body))
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{"_time": "2024-01-01T00:00:00Z", "host": "a", "usage": 1.5, "up": true},
+		{"_time": "2024-01-01T00:05:00Z", "host": "a", "usage": nil, "up": false},
+		{"_time": "2024-01-01T00:00:00Z", "host": "b", "count": int64(7)},
+	}, records)
+}
+
+func TestTimeChunks(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	chunks := timeChunks(start, start.Add(150*time.Minute), time.Hour)
+	assert.Equal(t, 3, len(chunks))
+	assert.Equal(t, start.Add(2*time.Hour), chunks[2][0])
+	assert.Equal(t, start.Add(150*time.Minute), chunks[2][1])
+}
+
+func TestInfluxCount(t *testing.T) {
+	s := &InfluxDBSource{cfg: &config.Config{SourceTable: "cpu", InfluxAggregateWindow: "5m", InfluxAggregateFunction: "mean"}}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, `SELECT count(*) FROM (SELECT mean(*) FROM "cpu" WHERE time >= '2024-01-01T00:00:00Z' AND time < '2024-01-01T01:00:00Z' GROUP BY time(5m), * fill(none))`,
+		s.influxQLCountQuery(start, start.Add(time.Hour)))
+	assert.True(t, strings.HasSuffix(s.fluxCountQuery(start, start.Add(time.Hour)), "|> group()\n  |> count(column: \"_time\")\n  |> keep(columns: [\"_time\"])"))
+
+	body := `{"results": [{"series": [{"name": "cpu", "columns": ["time", "count_mean_usage", "count_mean_idle"],
+		"values": [["1970-01-01T00:00:00Z", 12, 10]]}]}]}`
+	records, err := parseInfluxQLResponse(strings.NewReader(body))
+	assert.NoError(t, err)
+	count, err := influxCount(records)
+	assert.NoError(t, err)
+	assert.Equal(t, 12, count)
+
+	records, err = parseFluxCSV(strings.NewReader("#datatype,string,long,long\r\n,result,table,_time\r\n,_result,0,7\r\n"))
+	assert.NoError(t, err)
+	count, err = influxCount(records)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, count)
+
+	count, err = influxCount(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
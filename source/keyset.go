@@ -0,0 +1,82 @@
+package source
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// KeysetPage is the condition of the page of at most n rows that follows
+// the split key last (the first page when last is empty), in split key
+// order. Unlike LIMIT/OFFSET the source seeks to last in the split key
+// index, so every page costs the same however deep it is into condition.
+func KeysetPage(cfg *config.Config, condition, last string, n int64) string {
+	if last != "" {
+		condition = fmt.Sprintf("%s AND %s > %s", condition, cfg.SourceSplitKey, last)
+	}
+	if cfg.DatabaseType == "oracle" {
+		return fmt.Sprintf("%s ORDER BY %s FETCH FIRST %d ROWS ONLY", condition, cfg.SourceSplitKey, n)
+	}
+	return fmt.Sprintf("%s ORDER BY %s LIMIT %d", condition, cfg.SourceSplitKey, n)
+}
+
+// LastKey is the split key of the last row of data as a SQL literal, for
+// the next KeysetPage.
+func LastKey(cfg *config.Config, columns []string, data [][]interface{}) (string, error) {
+	keyIdx := -1
+	for i, column := range columns {
+		if strings.EqualFold(column, cfg.SourceSplitKey) {
+			keyIdx = i
+		}
+	}
+	if keyIdx < 0 {
+		return "", fmt.Errorf("split key %s is not a column of %s", cfg.SourceSplitKey, cfg.SourceTable)
+	}
+	if len(data) == 0 {
+		return "", nil
+	}
+	return KeyLiteral(data[len(data)-1][keyIdx])
+}
+
+// KeyLiteral is the key value v as a SQL literal.
+func KeyLiteral(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case int:
+		return strconv.Itoa(v), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case json.Number:
+		return v.String(), nil
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'", nil
+	case []byte:
+		return "'" + strings.ReplaceAll(string(v), "'", "''") + "'", nil
+	case time.Time:
+		return "'" + v.Format("2006-01-02 15:04:05.999999") + "'", nil
+	default:
+		return "", fmt.Errorf("unsupported split key value %v (%T)", v, v)
+	}
+}
+
+// selectBatchSQL is the query reading the rows of table matching
+// conditionSql and the source where condition, conditionSql may end with
+// the ORDER BY clause of a page, e.g. from KeysetPage.
+func selectBatchSQL(cfg *config.Config, table, conditionSql string) string {
+	condition, page := conditionSql, orderBySplitKey(cfg)
+	if i := strings.Index(conditionSql, " ORDER BY "); i >= 0 {
+		condition, page = conditionSql[:i], conditionSql[i:]
+	}
+	execSql := fmt.Sprintf("SELECT * FROM %s WHERE %s", table, condition)
+	if cfg.SourceWhereCondition != "" && cfg.SourceSplitKey != "" {
+		execSql = fmt.Sprintf("%s AND %s", execSql, cfg.SourceWhereCondition)
+	}
+	return execSql + page
+}
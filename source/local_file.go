@@ -0,0 +1,101 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// LocalFileSource archives the CSV/NDJSON files of a local directory.
+type LocalFileSource struct {
+	fileSource
+}
+
+func NewLocalFileSource(cfg *config.Config) (*LocalFileSource, error) {
+	fi, err := os.Stat(cfg.SourcePath)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("sourcePath %s is not a directory", cfg.SourcePath)
+	}
+	return &LocalFileSource{
+		fileSource: fileSource{cfg: cfg},
+	}, nil
+}
+
+// HasTablePrivilege tells whether the files of SourcePath can be read
+// (SELECT), or deleted and moved (DELETE) by creating a probe file there.
+func (s *LocalFileSource) HasTablePrivilege(ctx context.Context, privilege string) (bool, error) {
+	switch strings.ToUpper(privilege) {
+	case "SELECT":
+		f, err := os.Open(s.cfg.SourcePath)
+		if err != nil {
+			return false, nil
+		}
+		defer f.Close()
+		_, err = f.Readdirnames(1)
+		return err == nil || err == io.EOF, nil
+	case "DELETE":
+		f, err := os.CreateTemp(s.cfg.SourcePath, ".bend-archiver-probe-*")
+		if err != nil {
+			return false, nil
+		}
+		f.Close()
+		return true, os.Remove(f.Name())
+	default:
+		return false, fmt.Errorf("unknown privilege %s", privilege)
+	}
+}
+
+// ListFiles returns the regular files in SourcePath that match
+// SourceFilePattern, sorted by name.
+func (s *LocalFileSource) ListFiles(ctx context.Context) ([]FileInfo, error) {
+	entries, err := os.ReadDir(s.cfg.SourcePath)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := s.filePattern()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []FileInfo
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if pattern != nil && !pattern.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, FileInfo{
+			Path:    filepath.Join(s.cfg.SourcePath, entry.Name()),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Table:   TableNameFromPath(entry.Name()),
+		})
+	}
+	sortFiles(files)
+	return files, nil
+}
+
+func (s *LocalFileSource) OpenFile(ctx context.Context, file FileInfo) (io.ReadCloser, error) {
+	return os.Open(file.Path)
+}
+
+func (s *LocalFileSource) RemoveFile(ctx context.Context, file FileInfo) error {
+	return os.Remove(file.Path)
+}
+
+func (s *LocalFileSource) MoveFile(ctx context.Context, file FileInfo, dir string) error {
+	return os.Rename(file.Path, filepath.Join(dir, filepath.Base(file.Path)))
+}
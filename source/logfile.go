@@ -0,0 +1,236 @@
+package source
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// grokPatterns are the patterns %{NAME} and %{NAME:column} refer to in
+// logPattern, a subset of the Logstash library.
+var grokPatterns = map[string]string{
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"INT":               `[+-]?\d+`,
+	"POSINT":            `\b[1-9]\d*\b`,
+	"NUMBER":            `[+-]?(?:\d+(?:\.\d+)?|\.\d+)`,
+	"QUOTEDSTRING":      `"(?:[^"\\]|\\.)*"`,
+	"IP":                `(?:\d{1,3}\.){3}\d{1,3}|[0-9A-Fa-f:]*:[0-9A-Fa-f:.]+`,
+	"HOSTNAME":          `\b[0-9A-Za-z][0-9A-Za-z.-]*\b`,
+	"IPORHOST":          `(?:%{IP}|%{HOSTNAME})`,
+	"USER":              `[\w.@+-]+`,
+	"PROG":              `[\w._/%-]+`,
+	"LOGLEVEL":          `(?i:trace|debug|info|notice|warn(?:ing)?|err(?:or)?|crit(?:ical)?|alert|fatal|severe|emerg(?:ency)?|panic)`,
+	"TIMESTAMP_ISO8601": `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}(?::\d{2}(?:[.,]\d+)?)?(?:Z|[+-]\d{2}:?\d{2})?`,
+	"SYSLOGTIMESTAMP":   `[A-Z][a-z]{2} +\d{1,2} \d{2}:\d{2}:\d{2}`,
+	"HTTPDATE":          `\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}`,
+	"SYSLOGLINE":        `%{SYSLOGTIMESTAMP:timestamp} %{IPORHOST:host} %{PROG:program}(?:\[%{POSINT:pid:int}\])?: %{GREEDYDATA:message}`,
+	"COMMONAPACHELOG":   `%{IPORHOST:client} %{USER:ident} %{USER:auth} \[%{HTTPDATE:timestamp}\] "%{WORD:method} %{NOTSPACE:request} HTTP/%{NUMBER:http_version}" %{INT:status:int} (?:%{INT:bytes:int}|-)`,
+}
+
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::(\w+))?(?::(int|float))?\}`)
+
+// LogParser extracts columns from the lines of plain text logs with a regex,
+// whose named groups become the columns, or a grok pattern.
+type LogParser struct {
+	re         *regexp.Regexp
+	columns    []string
+	types      map[string]string
+	timeField  string
+	timeLayout string
+}
+
+func NewLogParser(cfg *config.Config) (*LogParser, error) {
+	if cfg.LogPattern == "" {
+		return nil, errors.New("logPattern must be set for log files")
+	}
+	types := make(map[string]string)
+	expr, err := expandGrok(cfg.LogPattern, types, 0)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid logPattern")
+	}
+	var columns []string
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			columns = append(columns, name)
+		}
+	}
+	if len(columns) == 0 {
+		return nil, errors.New("logPattern has no named groups")
+	}
+	timeField := cfg.LogTimeField
+	if timeField == "" {
+		timeField = "timestamp"
+	}
+	return &LogParser{
+		re:         re,
+		columns:    columns,
+		types:      types,
+		timeField:  timeField,
+		timeLayout: cfg.LogTimeLayout,
+	}, nil
+}
+
+// expandGrok replaces %{NAME:column:type} references by the pattern they
+// name, captured in a group called column when it is given.
+func expandGrok(pattern string, types map[string]string, depth int) (string, error) {
+	if depth > 10 {
+		return "", errors.New("grok patterns nested too deep")
+	}
+	var err error
+	expanded := grokReference.ReplaceAllStringFunc(pattern, func(ref string) string {
+		m := grokReference.FindStringSubmatch(ref)
+		sub, ok := grokPatterns[m[1]]
+		if !ok {
+			err = fmt.Errorf("unknown grok pattern %s", m[1])
+			return ref
+		}
+		sub, subErr := expandGrok(sub, types, depth+1)
+		if subErr != nil {
+			err = subErr
+			return ref
+		}
+		if m[2] == "" {
+			return "(?:" + sub + ")"
+		}
+		if m[3] != "" {
+			types[m[2]] = m[3]
+		}
+		return "(?P<" + m[2] + ">" + sub + ")"
+	})
+	return expanded, err
+}
+
+// ReadBatches parses r line by line. Lines that do not match are skipped and
+// counted in a warning, so one garbled line does not fail a whole file.
+func (p *LogParser) ReadBatches(r io.Reader, batchSize int, fn func(columns []string, rows [][]interface{}) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var rows [][]interface{}
+	skipped := 0
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		row, ok := p.parseLine(line)
+		if !ok {
+			skipped++
+			continue
+		}
+		rows = append(rows, row)
+		if len(rows) >= batchSize {
+			if err := fn(p.columns, rows); err != nil {
+				return err
+			}
+			rows = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "read log line failed")
+	}
+	if skipped > 0 {
+		logrus.Warnf("skipped %d log lines not matching logPattern", skipped)
+	}
+	if len(rows) > 0 {
+		return fn(p.columns, rows)
+	}
+	return nil
+}
+
+func (p *LogParser) parseLine(line string) ([]interface{}, bool) {
+	m := p.re.FindStringSubmatchIndex(line)
+	if m == nil {
+		return nil, false
+	}
+	row := make([]interface{}, 0, len(p.columns))
+	for i, name := range p.re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if m[2*i] < 0 {
+			row = append(row, nil)
+			continue
+		}
+		row = append(row, p.value(name, line[m[2*i]:m[2*i+1]]))
+	}
+	return row, true
+}
+
+func (p *LogParser) value(name, s string) interface{} {
+	switch {
+	case name == "fields":
+		return parseKeyValues(s)
+	case name == p.timeField && p.timeLayout != "":
+		t, err := time.Parse(p.timeLayout, s)
+		if err != nil {
+			return s
+		}
+		// syslog timestamps have no year
+		if t.Year() == 0 {
+			t = t.AddDate(time.Now().Year(), 0, 0)
+		}
+		return t.UTC().Format("2006-01-02 15:04:05.999999")
+	case p.types[name] == "int":
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+	case p.types[name] == "float":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
+
+// parseKeyValues parses logfmt style key=value pairs, values may be quoted.
+func parseKeyValues(s string) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		eq := strings.IndexAny(s, "= ")
+		if eq < 0 || s[eq] == ' ' {
+			// a bare word, keep it as a flag
+			end := len(s)
+			if eq >= 0 {
+				end = eq
+			}
+			fields[s[:end]] = true
+			s = s[end:]
+			continue
+		}
+		key := s[:eq]
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			if unquoted, err := strconv.QuotedPrefix(s); err == nil {
+				value, _ = strconv.Unquote(unquoted)
+				s = s[len(unquoted):]
+				fields[key] = value
+				continue
+			}
+		}
+		end := strings.IndexByte(s, ' ')
+		if end < 0 {
+			end = len(s)
+		}
+		value, s = s[:end], s[end:]
+		fields[key] = value
+	}
+	return fields
+}
@@ -0,0 +1,52 @@
+package source
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestLogParserSyslog(t *testing.T) {
+	p, err := NewLogParser(&config.Config{LogPattern: "%{SYSLOGLINE}", LogTimeLayout: "Jan _2 15:04:05"})
+	assert.NoError(t, err)
+
+	input := "Mar  7 09:01:02 web-1 sshd[4242]: Accepted publickey for deploy\n" +
+		"garbage\n" +
+		"Mar 17 10:00:00 web-1 CRON: job done\n"
+	var rows [][]interface{}
+	var columns []string
+	err = p.ReadBatches(strings.NewReader(input), 10, func(c []string, r [][]interface{}) error {
+		columns = c
+		rows = append(rows, r...)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"timestamp", "host", "program", "pid", "message"}, columns)
+	year := time.Now().Year()
+	assert.Equal(t, [][]interface{}{
+		{fmt.Sprintf("%d-03-07 09:01:02", year), "web-1", "sshd", int64(4242), "Accepted publickey for deploy"},
+		{fmt.Sprintf("%d-03-17 10:00:00", year), "web-1", "CRON", nil, "job done"},
+	}, rows)
+}
+
+func TestLogParserRegexFields(t *testing.T) {
+	p, err := NewLogParser(&config.Config{
+		LogPattern: `%{TIMESTAMP_ISO8601:ts} (?P<level>\w+) (?P<message>[^|]*?) \| (?P<fields>.*)`,
+	})
+	assert.NoError(t, err)
+
+	row, ok := p.parseLine(`2024-01-01T00:00:00Z WARN disk low | host=db1 used="91 %" dry`)
+	assert.True(t, ok)
+	assert.Equal(t, []interface{}{
+		"2024-01-01T00:00:00Z", "WARN", "disk low",
+		map[string]interface{}{"host": "db1", "used": "91 %", "dry": true},
+	}, row)
+
+	_, err = NewLogParser(&config.Config{LogPattern: "%{NOPE:x}"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,35 @@
+package source
+
+import (
+	"context"
+	"fmt"
+)
+
+// Maintainer is implemented by sources that can reclaim the space of the
+// purged rows of their tables, operation is one of the purgeMaintenance
+// operations the source supports.
+type Maintainer interface {
+	Maintain(ctx context.Context, operation string) error
+}
+
+// maintenanceSQL is the statement of operation on table for the source
+// databaseType.
+func maintenanceSQL(databaseType, operation, table string) (string, error) {
+	switch databaseType + " " + operation {
+	case " optimize", "mysql optimize":
+		return "OPTIMIZE TABLE " + table, nil
+	case " analyze", "mysql analyze", "tidb analyze":
+		return "ANALYZE TABLE " + table, nil
+	case "pg optimize":
+		return "VACUUM FULL " + table, nil
+	case "pg vacuum":
+		return "VACUUM " + table, nil
+	case "pg analyze":
+		return "ANALYZE " + table, nil
+	case "mssql optimize":
+		return "ALTER INDEX ALL ON " + table + " REBUILD", nil
+	case "mssql analyze":
+		return "UPDATE STATISTICS " + table, nil
+	}
+	return "", fmt.Errorf("purgeMaintenance %q is not supported by the %q source", operation, databaseType)
+}
@@ -1,6 +1,7 @@
 package source
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"fmt"
@@ -10,24 +11,38 @@ import (
 	"strings"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
 
 	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/internal/sqllog"
 )
 
 type MysqlSource struct {
 	db            *sql.DB
 	cfg           *config.Config
 	statsRecorder *DatabendSourceStatsRecorder
+	bookmark      *Bookmark
 }
 
 func NewMysqlSource(cfg *config.Config) (*MysqlSource, error) {
 	stats := NewDatabendIntesterStatsRecorder()
-	db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%d)/mysql",
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/mysql",
 		cfg.SourceUser,
 		cfg.SourcePass,
 		cfg.SourceHost,
-		cfg.SourcePort))
+		cfg.SourcePort)
+	tlsConfig, err := cfg.SourceTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		if err := mysql.RegisterTLSConfig("bend-archiver", tlsConfig); err != nil {
+			return nil, err
+		}
+		dsn += "?tls=bend-archiver"
+	}
+	db, err := sqllog.Open("mysql", dsn)
 	if err != nil {
 		logrus.Errorf("failed to open db: %v", err)
 		return nil, err
@@ -42,12 +57,12 @@ func NewMysqlSource(cfg *config.Config) (*MysqlSource, error) {
 
 // AdjustBatchSizeAccordingToSourceDbTable has a concept called s,  s = (maxKey - minKey) / sourceTableRowCount
 // if s == 1 it means the data is uniform in the table, if s is much bigger than 1, it means the data is not uniform in the table
-func (s *MysqlSource) AdjustBatchSizeAccordingToSourceDbTable() uint64 {
-	minSplitKey, maxSplitKey, err := s.GetMinMaxSplitKey()
+func (s *MysqlSource) AdjustBatchSizeAccordingToSourceDbTable(ctx context.Context) uint64 {
+	minSplitKey, maxSplitKey, err := s.GetMinMaxSplitKey(ctx)
 	if err != nil {
 		return uint64(s.cfg.BatchSize)
 	}
-	sourceTableRowCount, err := s.GetSourceReadRowsCount()
+	sourceTableRowCount, err := s.GetSourceReadRowsCount(ctx)
 	if err != nil {
 		return uint64(s.cfg.BatchSize)
 	}
@@ -64,9 +79,10 @@ func (s *MysqlSource) AdjustBatchSizeAccordingToSourceDbTable() uint64 {
 	}
 }
 
-func (s *MysqlSource) GetSourceReadRowsCount() (int, error) {
-	row := s.db.QueryRow(fmt.Sprintf("SELECT count(*) FROM %s.%s WHERE %s", s.cfg.SourceDB,
-		s.cfg.SourceTable, s.cfg.SourceWhereCondition))
+func (s *MysqlSource) GetSourceReadRowsCount(ctx context.Context) (int, error) {
+	table := sourceRelation(s.cfg, fmt.Sprintf("%s.%s", s.cfg.SourceDB, s.cfg.SourceTable))
+	row := s.db.QueryRowContext(ctx, tagSQL(s.cfg, "count", fmt.Sprintf("SELECT count(*) FROM %s WHERE %s", table,
+		s.cfg.SourceWhereCondition)))
 	var rowCount int
 	err := row.Scan(&rowCount)
 	if err != nil {
@@ -76,12 +92,41 @@ func (s *MysqlSource) GetSourceReadRowsCount() (int, error) {
 	return rowCount, nil
 }
 
-func (s *MysqlSource) GetMinMaxSplitKey() (uint64, uint64, error) {
-	query := fmt.Sprintf("SELECT MIN(%s), MAX(%s) FROM %s.%s WHERE %s",
+// HasTablePrivilege looks for privilege in the global, database and table
+// grants of the current user, privileges granted through roles are not seen.
+func (s *MysqlSource) HasTablePrivilege(ctx context.Context, privilege string) (bool, error) {
+	grantee := "CONCAT('''', SUBSTRING_INDEX(CURRENT_USER(), '@', 1), '''@''', SUBSTRING_INDEX(CURRENT_USER(), '@', -1), '''')"
+	query := fmt.Sprintf(`SELECT COUNT(*) FROM (
+		SELECT PRIVILEGE_TYPE FROM information_schema.USER_PRIVILEGES WHERE GRANTEE = %[1]s
+		UNION ALL SELECT PRIVILEGE_TYPE FROM information_schema.SCHEMA_PRIVILEGES WHERE GRANTEE = %[1]s AND TABLE_SCHEMA = ?
+		UNION ALL SELECT PRIVILEGE_TYPE FROM information_schema.TABLE_PRIVILEGES WHERE GRANTEE = %[1]s AND TABLE_SCHEMA = ? AND TABLE_NAME = ?
+	) AS p WHERE PRIVILEGE_TYPE = ?`, grantee)
+	var count int
+	err := s.db.QueryRowContext(ctx, query, s.cfg.SourceDB, s.cfg.SourceDB, s.cfg.SourceTable, strings.ToUpper(privilege)).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetAvgRowWidth is the average row length of the table in the InnoDB
+// statistics, in bytes.
+func (s *MysqlSource) GetAvgRowWidth(ctx context.Context) (int, error) {
+	var width sql.NullInt64
+	err := s.db.QueryRowContext(ctx, "SELECT AVG_ROW_LENGTH FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?",
+		s.cfg.SourceDB, s.cfg.SourceTable).Scan(&width)
+	if err != nil {
+		return 0, err
+	}
+	return int(width.Int64), nil
+}
+
+func (s *MysqlSource) GetMinMaxSplitKey(ctx context.Context) (uint64, uint64, error) {
+	query := fmt.Sprintf("SELECT MIN(%s), MAX(%s) FROM %s WHERE %s",
 		s.cfg.SourceSplitKey, s.cfg.SourceSplitKey,
-		s.cfg.SourceDB, s.cfg.SourceTable, s.cfg.SourceWhereCondition)
+		sourceRelation(s.cfg, fmt.Sprintf("%s.%s", s.cfg.SourceDB, s.cfg.SourceTable)), s.cfg.SourceWhereCondition)
 
-	rows, err := s.db.Query(query)
+	rows, err := s.db.QueryContext(ctx, tagSQL(s.cfg, "min-max", query))
 	if err != nil {
 		return 0, 0, err
 	}
@@ -113,31 +158,38 @@ func (s *MysqlSource) GetMinMaxSplitKey() (uint64, uint64, error) {
 	return min64, max64, nil
 }
 
-func (s *MysqlSource) GetMinMaxTimeSplitKey() (string, string, error) {
-	rows, err := s.db.Query(fmt.Sprintf("select min(%s), max(%s) from %s.%s WHERE %s", s.cfg.SourceSplitTimeKey,
-		s.cfg.SourceSplitTimeKey, s.cfg.SourceDB, s.cfg.SourceTable, s.cfg.SourceWhereCondition))
+func (s *MysqlSource) GetMinMaxTimeSplitKey(ctx context.Context) (string, string, error) {
+	table := sourceRelation(s.cfg, fmt.Sprintf("%s.%s", s.cfg.SourceDB, s.cfg.SourceTable))
+	rows, err := s.db.QueryContext(ctx, tagSQL(s.cfg, "min-max", fmt.Sprintf("select min(%s), max(%s) from %s WHERE %s", s.cfg.SourceSplitTimeKey,
+		s.cfg.SourceSplitTimeKey, table, s.cfg.SourceWhereCondition)))
 	if err != nil {
 		return "", "", err
 	}
 	defer rows.Close()
 
-	var minSplitKey, maxSplitKey string
+	// MIN and MAX are NULL on an empty table
+	var minSplitKey, maxSplitKey sql.NullString
 	for rows.Next() {
 		err = rows.Scan(&minSplitKey, &maxSplitKey)
 		if err != nil {
 			return "", "", err
 		}
 	}
-	return minSplitKey, maxSplitKey, nil
+	if !minSplitKey.Valid || !maxSplitKey.Valid {
+		return "", "", nil
+	}
+	return minSplitKey.String, maxSplitKey.String, nil
 }
 
-func (s *MysqlSource) DeleteAfterSync() error {
+// DeleteAfterSync purges the archived rows of every source table in small
+// chunks, see purgeTable.
+func (s *MysqlSource) DeleteAfterSync(ctx context.Context) error {
 	logrus.Infof("DeleteAfterSync: %v", s.cfg.DeleteAfterSync)
 	if !s.cfg.DeleteAfterSync {
 		return nil
 	}
 
-	dbTables, err := s.GetDbTablesAccordingToSourceDbTables()
+	dbTables, err := s.GetDbTablesAccordingToSourceDbTables(ctx)
 	if err != nil {
 		return err
 	}
@@ -146,28 +198,145 @@ func (s *MysqlSource) DeleteAfterSync() error {
 
 	for db, tables := range dbTables {
 		for _, table := range tables {
-			count, err := s.GetSourceReadRowsCount()
+			if err := s.purgeTable(ctx, fmt.Sprintf("%s.%s", db, table)); err != nil {
+				return fmt.Errorf("purge %s.%s: %w", db, table, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// purgeTable deletes the rows of table matching sourceWhereCondition, one
+// range of purgeChunkRows split keys per statement (purgeChunkRows rows
+// without a split key) with a pause in between, so that every statement
+// holds its locks briefly and writes a small binlog event the replicas
+// apply quickly. Progress is logged after every chunk, the purge stops
+// when ctx is done.
+func (s *MysqlSource) purgeTable(ctx context.Context, table string) error {
+	chunkRows := s.cfg.PurgeChunkRows
+	if chunkRows == 0 {
+		chunkRows = s.cfg.BatchSize
+	}
+	pause := time.Duration(s.cfg.BatchMaxInterval) * time.Second
+	if s.cfg.PurgeChunkPause != "" {
+		pause, _ = time.ParseDuration(s.cfg.PurgeChunkPause)
+	}
+	var total int64
+	if err := s.db.QueryRowContext(ctx, tagSQL(s.cfg, "count", fmt.Sprintf("SELECT count(*) FROM %s WHERE %s", table,
+		s.cfg.SourceWhereCondition))).Scan(&total); err != nil {
+		return err
+	}
+	if total == 0 {
+		return nil
+	}
+
+	startTime := time.Now()
+	var deleted int64
+	purge := func(query string) (int64, error) {
+		result, err := s.db.ExecContext(ctx, tagSQL(s.cfg, "delete", query))
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		deleted += n
+		logrus.Infof("purge %s: deleted %d of %d rows (%.1f%%) in %v", table, deleted, total,
+			100*float64(deleted)/float64(total), time.Since(startTime).Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(pause):
+		}
+		return n, nil
+	}
+
+	if s.cfg.SourceSplitKey == "" {
+		for {
+			n, err := purge(mysqlDeleteSQL(s.cfg, table, "", chunkRows))
 			if err != nil {
-				log.Printf("Error getting row count for table %s.%s: %v", db, table, err)
-				continue
+				return err
 			}
+			if n < chunkRows {
+				return nil
+			}
+		}
+	}
+	// chunks start at the next key left, sparse keys don't yield empty chunks
+	next := func(after string) (sql.NullInt64, error) {
+		var key sql.NullInt64
+		err := s.db.QueryRowContext(ctx, tagSQL(s.cfg, "min-max", fmt.Sprintf("SELECT MIN(%s) FROM %s WHERE (%s)%s",
+			s.cfg.SourceSplitKey, table, s.cfg.SourceWhereCondition, after))).Scan(&key)
+		return key, err
+	}
+	first, err := next("")
+	for err == nil && first.Valid {
+		last := first.Int64 + chunkRows - 1
+		keys := fmt.Sprintf("%s BETWEEN %d AND %d", s.cfg.SourceSplitKey, first.Int64, last)
+		if _, err := purge(mysqlDeleteSQL(s.cfg, table, keys, 0)); err != nil {
+			return err
+		}
+		first, err = next(fmt.Sprintf(" AND %s > %d", s.cfg.SourceSplitKey, last))
+	}
+	return err
+}
 
-			// Delete in batches
-			for count > 0 {
-				limit := min(int(s.cfg.BatchSize), count)
-				query := fmt.Sprintf("DELETE FROM %s.%s WHERE %s LIMIT %d", db, table, s.cfg.SourceWhereCondition, limit)
-				_, err := s.db.Exec(query)
-				if err != nil {
-					log.Printf("Error deleting rows from table %s.%s: %v", db, table, err)
-					break
+// mysqlDeleteSQL deletes the rows of table matching sourceWhereCondition
+// and keys, at most limit of them when limit isn't 0.
+func mysqlDeleteSQL(cfg *config.Config, table, keys string, limit int64) string {
+	query := "DELETE "
+	if cfg.PurgeLowPriority {
+		query += "LOW_PRIORITY "
+	}
+	query += fmt.Sprintf("FROM %s WHERE (%s)", table, cfg.SourceWhereCondition)
+	if keys != "" {
+		query += " AND " + keys
+	}
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	return query
+}
+
+// Maintain runs operation on every source table. OPTIMIZE and ANALYZE
+// report failures as messages of their result, not as errors.
+func (s *MysqlSource) Maintain(ctx context.Context, operation string) error {
+	dbTables, err := s.GetDbTablesAccordingToSourceDbTables(ctx)
+	if err != nil {
+		return err
+	}
+	for db, tables := range dbTables {
+		for _, table := range tables {
+			query, err := maintenanceSQL(s.cfg.DatabaseType, operation, fmt.Sprintf("%s.%s", db, table))
+			if err != nil {
+				return err
+			}
+			startTime := time.Now()
+			rows, err := s.db.QueryContext(ctx, tagSQL(s.cfg, operation, query))
+			if err != nil {
+				return err
+			}
+			var messages []string
+			for rows.Next() {
+				var name, op, msgType, msgText sql.NullString
+				if err := rows.Scan(&name, &op, &msgType, &msgText); err != nil {
+					rows.Close()
+					return err
+				}
+				if strings.EqualFold(msgType.String, "error") {
+					rows.Close()
+					return fmt.Errorf("%s: %s", query, msgText.String)
 				}
-				count -= limit
-				log.Printf("Deleted %d rows from table %s.%s\n", limit, db, table)
-				time.Sleep(time.Duration(s.cfg.BatchMaxInterval) * time.Second)
+				messages = append(messages, msgText.String)
+			}
+			if err := rows.Close(); err != nil {
+				return err
 			}
+			logrus.Infof("%s in %v: %s", query, time.Since(startTime).Round(time.Second), strings.Join(messages, "; "))
 		}
 	}
-
 	return nil
 }
 
@@ -179,14 +348,10 @@ func min(a, b int) int {
 	return b
 }
 
-func (s *MysqlSource) QueryTableData(threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+func (s *MysqlSource) QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
 	startTime := time.Now()
-	execSql := fmt.Sprintf("SELECT * FROM %s.%s WHERE %s", s.cfg.SourceDB,
-		s.cfg.SourceTable, conditionSql)
-	if s.cfg.SourceWhereCondition != "" && s.cfg.SourceSplitKey != "" {
-		execSql = fmt.Sprintf("%s AND %s", execSql, s.cfg.SourceWhereCondition)
-	}
-	rows, err := s.db.Query(execSql)
+	execSql := selectBatchSQL(s.cfg, sourceRelation(s.cfg, fmt.Sprintf("%s.%s", s.cfg.SourceDB, s.cfg.SourceTable)), conditionSql)
+	rows, err := s.db.QueryContext(ctx, tagSQL(s.cfg, conditionSql, execSql))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -201,102 +366,64 @@ func (s *MysqlSource) QueryTableData(threadNum int, conditionSql string) ([][]in
 		return nil, nil, err
 	}
 
-	scanArgs := make([]interface{}, len(columns))
-	for i, columnType := range columnTypes {
-		switch columnType.DatabaseTypeName() {
-		case "INT", "SMALLINT", "TINYINT", "MEDIUMINT", "BIGINT":
-			scanArgs[i] = new(sql.NullInt64)
-		case "UNSIGNED BIGINT":
-			scanArgs[i] = new(NullUint64)
-		case "UNSIGNED INT", "UNSIGNED TINYINT", "UNSIGNED MEDIUMINT":
-			scanArgs[i] = new(sql.NullInt64)
-		case "FLOAT", "DOUBLE":
-			scanArgs[i] = new(sql.NullFloat64)
-		case "DECIMAL":
-			scanArgs[i] = new(sql.NullFloat64)
-		case "CHAR", "VARCHAR", "TEXT", "TINYTEXT", "MEDIUMTEXT", "LONGTEXT":
-			scanArgs[i] = new(sql.NullString)
-		case "DATE", "TIME", "DATETIME", "TIMESTAMP":
-			scanArgs[i] = new(sql.NullString) // or use time.Time
-		case "BOOL", "BOOLEAN":
-			scanArgs[i] = new(sql.NullBool)
-		default:
-			scanArgs[i] = new(sql.RawBytes)
-		}
-	}
-
+	scanner := s.rowScanner(columns, columnTypes)
 	var result [][]interface{}
-	//rowCount, err := s.GetRowsCountByConditionSql(conditionSql)
-	//if err != nil {
-	//	return nil, nil, err
-	//}
-	//result := make([][]interface{}, rowCount)
 	for rows.Next() {
-		err = rows.Scan(scanArgs...)
+		row, err := scanner.scan(rows)
 		if err != nil {
 			return nil, nil, err
 		}
-
-		row := make([]interface{}, len(columns))
-		for i, v := range scanArgs {
-			switch v := v.(type) {
-			case *int:
-				row[i] = *v
-			case *string:
-				row[i] = *v
-			case *sql.NullString:
-				if v.Valid {
-					row[i] = v.String
-				} else {
-					row[i] = nil
-				}
-			case *bool:
-				row[i] = *v
-			case *sql.NullInt64:
-				if v.Valid {
-					row[i] = v.Int64
-				} else {
-					row[i] = nil
-				}
-			case *sql.NullFloat64:
-				if v.Valid {
-					row[i] = v.Float64
-				} else {
-					row[i] = nil
-				}
-			case *NullUint64:
-				if v.Valid {
-					row[i] = v.Uint64
-				} else {
-					row[i] = nil
-				}
-			case *sql.NullBool:
-				if v.Valid {
-					row[i] = v.Bool
-				} else {
-					row[i] = nil
-				}
-			case *float64:
-				row[i] = *v
-			case *sql.RawBytes:
-				row[i] = string(*v)
-			}
-		}
 		result = append(result, row)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, nil, err
 	}
-	s.statsRecorder.RecordMetric(len(result))
+	s.statsRecorder.RecordBatch(s.cfg, threadNum, result)
 	stats := s.statsRecorder.Stats(time.Since(startTime))
 	log.Printf("thread-%d: extract %d rows (%f rows/s)", threadNum, len(result)+1, stats.RowsPerSecondd)
 
 	return result, columns, nil
 }
 
-func (s *MysqlSource) GetDatabasesAccordingToSourceDbRegex(sourceDatabasePattern string) ([]string, error) {
-	rows, err := s.db.Query("SHOW DATABASES")
+func (s *MysqlSource) rowScanner(columns []string, columnTypes []*sql.ColumnType) *rowScanner {
+	scanner := newRowScanner("mysql", columnTypes)
+	applyInvalidDatePolicy(s.cfg, scanner, columns, columnTypes)
+	return scanner
+}
+
+// ReadSnapshot reads the source table, view or sourceSelect in one query.
+func (s *MysqlSource) ReadSnapshot(ctx context.Context, fn func(columns []string, rows [][]interface{}) error) error {
+	table := sourceRelation(s.cfg, fmt.Sprintf("%s.%s", s.cfg.SourceDB, s.cfg.SourceTable))
+	// the bookmark and the snapshot are read on the same connection
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if s.cfg.SourceBookmark {
+		bookmark, err := s.startBookmarkedSnapshot(ctx, conn)
+		if err != nil {
+			return err
+		}
+		defer conn.ExecContext(context.WithoutCancel(ctx), "ROLLBACK")
+		s.bookmark = bookmark
+	}
+	rows, err := conn.QueryContext(ctx, tagSQL(s.cfg, "snapshot", snapshotSQL(s.cfg, table)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	return readBatches(s.cfg, rows, s.rowScanner, s.statsRecorder, fn)
+}
+
+// Bookmark is where the last snapshot was read with sourceBookmark.
+func (s *MysqlSource) Bookmark() *Bookmark {
+	return s.bookmark
+}
+
+func (s *MysqlSource) GetDatabasesAccordingToSourceDbRegex(ctx context.Context, sourceDatabasePattern string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SHOW DATABASES")
 	if err != nil {
 		return nil, err
 	}
@@ -321,10 +448,10 @@ func (s *MysqlSource) GetDatabasesAccordingToSourceDbRegex(sourceDatabasePattern
 	return databases, nil
 }
 
-func (s *MysqlSource) GetTablesAccordingToSourceTableRegex(sourceTablePattern string, databases []string) (map[string][]string, error) {
+func (s *MysqlSource) GetTablesAccordingToSourceTableRegex(ctx context.Context, sourceTablePattern string, databases []string) (map[string][]string, error) {
 	dbTables := make(map[string][]string)
 	for _, database := range databases {
-		rows, err := s.db.Query(fmt.Sprintf("SHOW TABLES FROM %s", database))
+		rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SHOW TABLES FROM %s", database))
 		if err != nil {
 			return nil, err
 		}
@@ -350,10 +477,10 @@ func (s *MysqlSource) GetTablesAccordingToSourceTableRegex(sourceTablePattern st
 	return dbTables, nil
 }
 
-func (s *MysqlSource) GetAllSourceReadRowsCount() (int, error) {
+func (s *MysqlSource) GetAllSourceReadRowsCount(ctx context.Context) (int, error) {
 	allCount := 0
 
-	dbTables, err := s.GetDbTablesAccordingToSourceDbTables()
+	dbTables, err := s.GetDbTablesAccordingToSourceDbTables(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -361,7 +488,7 @@ func (s *MysqlSource) GetAllSourceReadRowsCount() (int, error) {
 		s.cfg.SourceDB = db
 		for _, table := range tables {
 			s.cfg.SourceTable = table
-			count, err := s.GetSourceReadRowsCount()
+			count, err := s.GetSourceReadRowsCount(ctx)
 			if err != nil {
 				return 0, err
 			}
@@ -372,7 +499,7 @@ func (s *MysqlSource) GetAllSourceReadRowsCount() (int, error) {
 		return allCount, nil
 	}
 	if len(dbTables) == 0 && s.cfg.SourceTable != "" {
-		count, err := s.GetSourceReadRowsCount()
+		count, err := s.GetSourceReadRowsCount(ctx)
 		if err != nil {
 			return 0, err
 		}
@@ -382,18 +509,18 @@ func (s *MysqlSource) GetAllSourceReadRowsCount() (int, error) {
 	return allCount, nil
 }
 
-func (s *MysqlSource) GetDbTablesAccordingToSourceDbTables() (map[string][]string, error) {
+func (s *MysqlSource) GetDbTablesAccordingToSourceDbTables(ctx context.Context) (map[string][]string, error) {
 	allDbTables := make(map[string][]string)
 	for _, sourceDbTable := range s.cfg.SourceDbTables {
 		dbTable := strings.Split(sourceDbTable, "@") // because `.` in regex is a special character, so use `@` to split
 		if len(dbTable) != 2 {
 			return nil, fmt.Errorf("invalid sourceDbTable: %s, should be a.b format", sourceDbTable)
 		}
-		dbs, err := s.GetDatabasesAccordingToSourceDbRegex(dbTable[0])
+		dbs, err := s.GetDatabasesAccordingToSourceDbRegex(ctx, dbTable[0])
 		if err != nil {
 			return nil, fmt.Errorf("get databases according to sourceDbRegex failed: %v", err)
 		}
-		dbTables, err := s.GetTablesAccordingToSourceTableRegex(dbTable[1], dbs)
+		dbTables, err := s.GetTablesAccordingToSourceTableRegex(ctx, dbTable[1], dbs)
 		if err != nil {
 			return nil, fmt.Errorf("get tables according to sourceTableRegex failed: %v", err)
 		}
@@ -0,0 +1,42 @@
+package source
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// formatsFloats reports whether cfg changes how floats are written.
+func formatsFloats(cfg *config.Config) bool {
+	return cfg.FloatNotation == "plain" || cfg.FloatPrecision > 0 || cfg.WholeFloatsAsIntegers
+}
+
+// FormatFloat is v as a number formatted by the float settings of cfg, v
+// itself when it is not a finite float. The decimal point is always a dot.
+func FormatFloat(cfg *config.Config, v interface{}) interface{} {
+	var f float64
+	bitSize := 64
+	switch v := v.(type) {
+	case float64:
+		f = v
+	case float32:
+		f, bitSize = float64(v), 32
+	default:
+		return v
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return v
+	}
+	if cfg.WholeFloatsAsIntegers && f == math.Trunc(f) {
+		return json.Number(strconv.FormatFloat(f, 'f', 0, bitSize))
+	}
+	if cfg.FloatPrecision > 0 {
+		return json.Number(strconv.FormatFloat(f, 'f', cfg.FloatPrecision, bitSize))
+	}
+	if cfg.FloatNotation == "plain" {
+		return json.Number(strconv.FormatFloat(f, 'f', -1, bitSize))
+	}
+	return v
+}
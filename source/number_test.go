@@ -0,0 +1,46 @@
+package source
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestFormatFloat(t *testing.T) {
+	auto := &config.Config{}
+	assert.Equal(t, 1e-7, FormatFloat(auto, 1e-7))
+	assert.Equal(t, "x", FormatFloat(auto, "x"))
+
+	plain := &config.Config{FloatNotation: "plain"}
+	assert.Equal(t, json.Number("0.0000001"), FormatFloat(plain, 1e-7))
+	assert.Equal(t, json.Number("1000000000000000000000"), FormatFloat(plain, 1e21))
+	assert.Equal(t, json.Number("0.1"), FormatFloat(plain, float32(0.1)))
+	assert.True(t, math.IsNaN(FormatFloat(plain, math.NaN()).(float64)))
+
+	fixed := &config.Config{FloatPrecision: 2}
+	assert.Equal(t, json.Number("3.14"), FormatFloat(fixed, 3.14159))
+	assert.Equal(t, json.Number("3.00"), FormatFloat(fixed, 3.0))
+	assert.Equal(t, json.Number("0.00"), FormatFloat(fixed, 1e-7))
+
+	whole := &config.Config{FloatPrecision: 2, WholeFloatsAsIntegers: true}
+	assert.Equal(t, json.Number("3"), FormatFloat(whole, 3.0))
+	assert.Equal(t, json.Number("3.50"), FormatFloat(whole, 3.5))
+	assert.Equal(t, int64(3), FormatFloat(whole, int64(3)))
+}
+
+func TestGenerateJSONFilePlainFloats(t *testing.T) {
+	cfg := &config.Config{FloatNotation: "plain"}
+	fileName, _, err := GenerateJSONFile(cfg, []string{"amount"}, [][]interface{}{{1.5e21}, {2.5e-8}})
+	assert.NoError(t, err)
+	defer os.Remove(fileName)
+
+	data, err := os.ReadFile(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"amount":1500000000000000000000}`, `{"amount":0.000000025}`}, strings.Fields(string(data)))
+}
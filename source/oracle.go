@@ -1,6 +1,7 @@
 package source
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -15,6 +16,7 @@ import (
 	_ "github.com/sijms/go-ora/v2"
 
 	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/internal/sqllog"
 )
 
 type OracleSource struct {
@@ -23,12 +25,12 @@ type OracleSource struct {
 	statsRecorder *DatabendSourceStatsRecorder
 }
 
-func (p *OracleSource) AdjustBatchSizeAccordingToSourceDbTable() uint64 {
-	minSplitKey, maxSplitKey, err := p.GetMinMaxSplitKey()
+func (p *OracleSource) AdjustBatchSizeAccordingToSourceDbTable(ctx context.Context) uint64 {
+	minSplitKey, maxSplitKey, err := p.GetMinMaxSplitKey(ctx)
 	if err != nil {
 		return uint64(p.cfg.BatchSize)
 	}
-	sourceTableRowCount, err := p.GetSourceReadRowsCount()
+	sourceTableRowCount, err := p.GetSourceReadRowsCount(ctx)
 	if err != nil {
 		return uint64(p.cfg.BatchSize)
 	}
@@ -59,7 +61,7 @@ func NewOracleSource(cfg *config.Config) (*OracleSource, error) {
 	}
 	connStr := go_ora.BuildUrl(cfg.SourceHost, cfg.SourcePort, cfg.SourceDB, cfg.SourceUser, cfg.SourcePass, params)
 
-	db, err := sql.Open("oracle", connStr)
+	db, err := sqllog.Open("oracle", connStr)
 	if err != nil {
 		logrus.Errorf("failed to open oracle db: %v", err)
 		return nil, err
@@ -87,7 +89,7 @@ func (p *OracleSource) SwitchDatabase() error {
 	connStr := go_ora.BuildUrl(p.cfg.SourceHost, p.cfg.SourcePort, p.cfg.SourceDB, p.cfg.SourceUser, p.cfg.SourcePass, params)
 
 	// Open a new connection to the new database
-	db, err := sql.Open("oracle", connStr)
+	db, err := sqllog.Open("oracle", connStr)
 	if err != nil {
 		return err
 	}
@@ -96,13 +98,13 @@ func (p *OracleSource) SwitchDatabase() error {
 	p.db = db
 	return nil
 }
-func (p *OracleSource) GetSourceReadRowsCount() (int, error) {
+func (p *OracleSource) GetSourceReadRowsCount(ctx context.Context) (int, error) {
 	err := p.SwitchDatabase()
 	if err != nil {
 		return 0, err
 	}
-	row := p.db.QueryRow(fmt.Sprintf("SELECT count(*) FROM %s.%s WHERE %s",
-		p.cfg.SourceDB, p.cfg.SourceTable, p.cfg.SourceWhereCondition))
+	row := p.db.QueryRowContext(ctx, tagSQL(p.cfg, "count", fmt.Sprintf("SELECT count(*) FROM %s.%s WHERE %s",
+		p.cfg.SourceDB, p.cfg.SourceTable, p.cfg.SourceWhereCondition)))
 	var rowCount int
 	err = row.Scan(&rowCount)
 	if err != nil {
@@ -111,7 +113,7 @@ func (p *OracleSource) GetSourceReadRowsCount() (int, error) {
 	return rowCount, nil
 }
 
-func (p *OracleSource) GetMinMaxSplitKey() (uint64, uint64, error) {
+func (p *OracleSource) GetMinMaxSplitKey(ctx context.Context) (uint64, uint64, error) {
 	err := p.SwitchDatabase()
 	if err != nil {
 		return 0, 0, err
@@ -121,7 +123,7 @@ func (p *OracleSource) GetMinMaxSplitKey() (uint64, uint64, error) {
 		p.cfg.SourceSplitKey, p.cfg.SourceSplitKey,
 		p.cfg.SourceDB, p.cfg.SourceTable, p.cfg.SourceWhereCondition)
 
-	rows, err := p.db.Query(query)
+	rows, err := p.db.QueryContext(ctx, tagSQL(p.cfg, "min-max", query))
 	if err != nil {
 		return 0, 0, err
 	}
@@ -152,36 +154,40 @@ func (p *OracleSource) GetMinMaxSplitKey() (uint64, uint64, error) {
 	return min64, max64, nil
 }
 
-func (p *OracleSource) GetMinMaxTimeSplitKey() (string, string, error) {
+func (p *OracleSource) GetMinMaxTimeSplitKey(ctx context.Context) (string, string, error) {
 	err := p.SwitchDatabase()
 	if err != nil {
 		return "", "", err
 	}
-	rows, err := p.db.Query(fmt.Sprintf("select min(%s), max(%s) from %s.%s WHERE %s", p.cfg.SourceSplitTimeKey,
-		p.cfg.SourceSplitTimeKey, p.cfg.SourceDB, p.cfg.SourceTable, p.cfg.SourceWhereCondition))
+	rows, err := p.db.QueryContext(ctx, tagSQL(p.cfg, "min-max", fmt.Sprintf("select min(%s), max(%s) from %s.%s WHERE %s", p.cfg.SourceSplitTimeKey,
+		p.cfg.SourceSplitTimeKey, p.cfg.SourceDB, p.cfg.SourceTable, p.cfg.SourceWhereCondition)))
 	if err != nil {
 		return "", "", err
 	}
 	defer rows.Close()
 
-	var minSplitKey, maxSplitKey string
+	// MIN and MAX are NULL on an empty table
+	var minSplitKey, maxSplitKey sql.NullString
 	for rows.Next() {
 		err = rows.Scan(&minSplitKey, &maxSplitKey)
 		if err != nil {
 			return "", "", err
 		}
 	}
-	return minSplitKey, maxSplitKey, nil
+	if !minSplitKey.Valid || !maxSplitKey.Valid {
+		return "", "", nil
+	}
+	return minSplitKey.String, maxSplitKey.String, nil
 }
 
-func (p *OracleSource) DeleteAfterSync() error {
+func (p *OracleSource) DeleteAfterSync(ctx context.Context) error {
 	err := p.SwitchDatabase()
 	if err != nil {
 		return err
 	}
 	if p.cfg.DeleteAfterSync {
-		_, err := p.db.Exec(fmt.Sprintf("delete from %s.%s where %s",
-			p.cfg.SourceDB, p.cfg.SourceTable, p.cfg.SourceWhereCondition))
+		_, err := p.db.ExecContext(ctx, tagSQL(p.cfg, "delete", fmt.Sprintf("delete from %s.%s where %s",
+			p.cfg.SourceDB, p.cfg.SourceTable, p.cfg.SourceWhereCondition)))
 		if err != nil {
 			return err
 		}
@@ -189,18 +195,14 @@ func (p *OracleSource) DeleteAfterSync() error {
 	return nil
 }
 
-func (p *OracleSource) QueryTableData(threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+func (p *OracleSource) QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
 	startTime := time.Now()
 	err := p.SwitchDatabase()
 	if err != nil {
 		return nil, nil, err
 	}
-	execSql := fmt.Sprintf("SELECT * FROM %s.%s WHERE %s",
-		p.cfg.SourceDB, p.cfg.SourceTable, conditionSql)
-	if p.cfg.SourceWhereCondition != "" && p.cfg.SourceSplitKey != "" {
-		execSql = fmt.Sprintf("%s AND %s", execSql, p.cfg.SourceWhereCondition)
-	}
-	rows, err := p.db.Query(execSql)
+	execSql := selectBatchSQL(p.cfg, fmt.Sprintf("%s.%s", p.cfg.SourceDB, p.cfg.SourceTable), conditionSql)
+	rows, err := p.db.QueryContext(ctx, tagSQL(p.cfg, conditionSql, execSql))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -215,102 +217,29 @@ func (p *OracleSource) QueryTableData(threadNum int, conditionSql string) ([][]i
 		return nil, nil, err
 	}
 
-	scanArgs := make([]interface{}, len(columns))
-	for i, columnType := range columnTypes {
-		switch columnType.DatabaseTypeName() {
-		case "NUMBER", "INTEGER", "FLOAT", "BINARY_FLOAT", "BINARY_DOUBLE":
-			scanArgs[i] = new(sql.NullFloat64)
-		case "CHAR", "NCHAR", "VARCHAR", "VARCHAR2", "NVARCHAR2", "CLOB", "NCLOB":
-			scanArgs[i] = new(sql.NullString)
-		case "DATE", "TimeStampDTY", "TimeStampTZ_DTY", "TimeStampLTZ_DTY":
-			scanArgs[i] = new(sql.NullTime)
-		case "IntervalYM_DTY", "IntervalDS_DTY":
-			scanArgs[i] = new(sql.NullString)
-		case "RAW", "LONG", "LongRaw", "OCIBlobLocator", "IBDouble":
-			scanArgs[i] = new(sql.RawBytes)
-		default:
-			log.Printf("Unsupported column type: %s", columnType.DatabaseTypeName())
-			scanArgs[i] = new(sql.RawBytes)
-		}
-	}
-
+	scanner := newRowScanner("oracle", columnTypes)
+	applyInvalidDatePolicy(p.cfg, scanner, columns, columnTypes)
 	var result [][]interface{}
-	//rowCount, err := s.GetRowsCountByConditionSql(conditionSql)
-	//if err != nil {
-	//	return nil, nil, err
-	//}
-	//result := make([][]interface{}, rowCount)
 	for rows.Next() {
-		err = rows.Scan(scanArgs...)
+		row, err := scanner.scan(rows)
 		if err != nil {
 			return nil, nil, err
 		}
-
-		row := make([]interface{}, len(columns))
-		for i, v := range scanArgs {
-			switch v := v.(type) {
-			case *int:
-				row[i] = *v
-			case *string:
-				row[i] = *v
-			case *sql.NullString:
-				if v.Valid {
-					row[i] = v.String
-				} else {
-					row[i] = nil
-				}
-			case *bool:
-				row[i] = *v
-			case *sql.NullInt64:
-				if v.Valid {
-					row[i] = v.Int64
-				} else {
-					row[i] = nil
-				}
-			case *sql.NullFloat64:
-				if v.Valid {
-					row[i] = v.Float64
-				} else {
-					row[i] = nil
-				}
-			case *sql.NullTime:
-				if v.Valid {
-					row[i] = v.Time
-				} else {
-					row[i] = nil
-				}
-			case *sql.NullBool:
-				if v.Valid {
-					//row[i] = v.Bool
-					if v.Bool {
-						row[i] = 1
-					} else {
-						row[i] = 0
-					}
-				} else {
-					row[i] = nil
-				}
-			case *float64:
-				row[i] = *v
-			case *sql.RawBytes:
-				row[i] = string(*v)
-			}
-		}
 		result = append(result, row)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, nil, err
 	}
-	p.statsRecorder.RecordMetric(len(result))
+	p.statsRecorder.RecordBatch(p.cfg, threadNum, result)
 	stats := p.statsRecorder.Stats(time.Since(startTime))
 	log.Printf("thread-%d: extract %d rows (%f rows/s)", threadNum, len(result)+1, stats.RowsPerSecondd)
 
 	return result, columns, nil
 }
 
-func (p *OracleSource) GetDatabasesAccordingToSourceDbRegex(sourceDatabasePattern string) ([]string, error) {
-	rows, err := p.db.Query("SELECT username AS schema_name FROM all_users")
+func (p *OracleSource) GetDatabasesAccordingToSourceDbRegex(ctx context.Context, sourceDatabasePattern string) ([]string, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT username AS schema_name FROM all_users")
 	if err != nil {
 		return nil, err
 	}
@@ -334,7 +263,7 @@ func (p *OracleSource) GetDatabasesAccordingToSourceDbRegex(sourceDatabasePatter
 	return databases, nil
 }
 
-func (p *OracleSource) GetTablesAccordingToSourceTableRegex(sourceTablePattern string, databases []string) (map[string][]string, error) {
+func (p *OracleSource) GetTablesAccordingToSourceTableRegex(ctx context.Context, sourceTablePattern string, databases []string) (map[string][]string, error) {
 	dbTables := make(map[string][]string)
 	for _, database := range databases {
 		p.cfg.SourceDB = database
@@ -342,7 +271,7 @@ func (p *OracleSource) GetTablesAccordingToSourceTableRegex(sourceTablePattern s
 		if err != nil {
 			return nil, err
 		}
-		rows, err := p.db.Query(fmt.Sprintf("SELECT table_name FROM ALL_TABLES WHERE OWNER = '%s'", database))
+		rows, err := p.db.QueryContext(ctx, fmt.Sprintf("SELECT table_name FROM ALL_TABLES WHERE OWNER = '%s'", database))
 		if err != nil {
 			return nil, err
 		}
@@ -368,10 +297,10 @@ func (p *OracleSource) GetTablesAccordingToSourceTableRegex(sourceTablePattern s
 	return dbTables, nil
 }
 
-func (p *OracleSource) GetAllSourceReadRowsCount() (int, error) {
+func (p *OracleSource) GetAllSourceReadRowsCount(ctx context.Context) (int, error) {
 	allCount := 0
 
-	dbTables, err := p.GetDbTablesAccordingToSourceDbTables()
+	dbTables, err := p.GetDbTablesAccordingToSourceDbTables(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -379,7 +308,7 @@ func (p *OracleSource) GetAllSourceReadRowsCount() (int, error) {
 		p.cfg.SourceDB = db
 		for _, table := range tables {
 			p.cfg.SourceTable = table
-			count, err := p.GetSourceReadRowsCount()
+			count, err := p.GetSourceReadRowsCount(ctx)
 			if err != nil {
 				return 0, err
 			}
@@ -390,18 +319,18 @@ func (p *OracleSource) GetAllSourceReadRowsCount() (int, error) {
 	return allCount, nil
 }
 
-func (p *OracleSource) GetDbTablesAccordingToSourceDbTables() (map[string][]string, error) {
+func (p *OracleSource) GetDbTablesAccordingToSourceDbTables(ctx context.Context) (map[string][]string, error) {
 	allDbTables := make(map[string][]string)
 	for _, sourceDbTable := range p.cfg.SourceDbTables {
 		dbTable := strings.Split(sourceDbTable, "@") // because `.` in regex is a special character, so use `@` to split
 		if len(dbTable) != 2 {
 			return nil, fmt.Errorf("invalid sourceDbTable: %s, should be a.b format", sourceDbTable)
 		}
-		dbs, err := p.GetDatabasesAccordingToSourceDbRegex(dbTable[0])
+		dbs, err := p.GetDatabasesAccordingToSourceDbRegex(ctx, dbTable[0])
 		if err != nil {
 			return nil, fmt.Errorf("get databases according to sourceDbRegex failed: %v", err)
 		}
-		dbTables, err := p.GetTablesAccordingToSourceTableRegex(dbTable[1], dbs)
+		dbTables, err := p.GetTablesAccordingToSourceTableRegex(ctx, dbTable[1], dbs)
 		if err != nil {
 			return nil, fmt.Errorf("get tables according to sourceTableRegex failed: %v", err)
 		}
@@ -1,9 +1,11 @@
 package source
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -12,6 +14,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/internal/sqllog"
 )
 
 type PostgresSource struct {
@@ -20,12 +23,12 @@ type PostgresSource struct {
 	statsRecorder *DatabendSourceStatsRecorder
 }
 
-func (p *PostgresSource) AdjustBatchSizeAccordingToSourceDbTable() uint64 {
-	minSplitKey, maxSplitKey, err := p.GetMinMaxSplitKey()
+func (p *PostgresSource) AdjustBatchSizeAccordingToSourceDbTable(ctx context.Context) uint64 {
+	minSplitKey, maxSplitKey, err := p.GetMinMaxSplitKey(ctx)
 	if err != nil {
 		return uint64(p.cfg.BatchSize)
 	}
-	sourceTableRowCount, err := p.GetSourceReadRowsCount()
+	sourceTableRowCount, err := p.GetSourceReadRowsCount(ctx)
 	if err != nil {
 		return uint64(p.cfg.BatchSize)
 	}
@@ -49,14 +52,16 @@ func NewPostgresSource(cfg *config.Config) (*PostgresSource, error) {
 	//verify-ca - Always SSL (verify that the certificate presented by the server was signed by a trusted CA)
 	//verify-full - Always SSL (verify that the certification presented by the server was signed by a trusted CA and the server host name matches the one in the certificate)
 	if cfg.SSLMode == "" {
-		cfg.SSLMode = "disable"
+		switch {
+		case cfg.SourceTLSSkipVerify:
+			cfg.SSLMode = "require"
+		case cfg.SourceTLSCA != "" || cfg.SourceTLSCert != "":
+			cfg.SSLMode = "verify-full"
+		default:
+			cfg.SSLMode = "disable"
+		}
 	}
-	db, err := sql.Open("postgres", fmt.Sprintf("postgres://%s:%s@%s:%d/postgres?sslmode=%s",
-		cfg.SourceUser,
-		cfg.SourcePass,
-		cfg.SourceHost,
-		cfg.SourcePort,
-		cfg.SSLMode))
+	db, err := sqllog.Open("postgres", postgresDSN(cfg, "postgres"))
 	if err != nil {
 		logrus.Errorf("failed to open postgres db: %v", err)
 		return nil, err
@@ -76,13 +81,7 @@ func (p *PostgresSource) SwitchDatabase() error {
 	}
 
 	// Open a new connection to the new database
-	db, err := sql.Open("postgres", fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
-		p.cfg.SourceUser,
-		p.cfg.SourcePass,
-		p.cfg.SourceHost,
-		p.cfg.SourcePort,
-		p.cfg.SourceDB,
-		p.cfg.SSLMode))
+	db, err := sqllog.Open("postgres", postgresDSN(p.cfg, p.cfg.SourceDB))
 	if err != nil {
 		return err
 	}
@@ -91,13 +90,33 @@ func (p *PostgresSource) SwitchDatabase() error {
 	p.db = db
 	return nil
 }
-func (p *PostgresSource) GetSourceReadRowsCount() (int, error) {
+
+// postgresDSN is the lib/pq url of database, with the CA bundle and client
+// certificate of the source TLS config.
+func postgresDSN(cfg *config.Config, database string) string {
+	dsn := fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
+		cfg.SourceUser,
+		cfg.SourcePass,
+		cfg.SourceHost,
+		cfg.SourcePort,
+		database,
+		cfg.SSLMode)
+	// with a root cert lib/pq verifies the server even in require mode
+	if cfg.SourceTLSCA != "" && !cfg.SourceTLSSkipVerify {
+		dsn += "&sslrootcert=" + url.QueryEscape(cfg.SourceTLSCA)
+	}
+	if cfg.SourceTLSCert != "" {
+		dsn += "&sslcert=" + url.QueryEscape(cfg.SourceTLSCert) + "&sslkey=" + url.QueryEscape(cfg.SourceTLSKey)
+	}
+	return dsn
+}
+func (p *PostgresSource) GetSourceReadRowsCount(ctx context.Context) (int, error) {
 	err := p.SwitchDatabase()
 	if err != nil {
 		return 0, err
 	}
-	row := p.db.QueryRow(fmt.Sprintf("SELECT count(*) FROM %s WHERE %s",
-		p.cfg.SourceTable, p.cfg.SourceWhereCondition))
+	row := p.db.QueryRowContext(ctx, tagSQL(p.cfg, "count", fmt.Sprintf("SELECT count(*) FROM %s WHERE %s",
+		sourceRelation(p.cfg, p.cfg.SourceTable), p.cfg.SourceWhereCondition)))
 	var rowCount int
 	err = row.Scan(&rowCount)
 	if err != nil {
@@ -107,16 +126,45 @@ func (p *PostgresSource) GetSourceReadRowsCount() (int, error) {
 	return rowCount, nil
 }
 
-func (p *PostgresSource) GetMinMaxSplitKey() (uint64, uint64, error) {
+func (p *PostgresSource) HasTablePrivilege(ctx context.Context, privilege string) (bool, error) {
+	err := p.SwitchDatabase()
+	if err != nil {
+		return false, err
+	}
+	var granted bool
+	err = p.db.QueryRowContext(ctx, "SELECT has_table_privilege($1, $2)", p.cfg.SourceTable, privilege).Scan(&granted)
+	if err != nil {
+		return false, err
+	}
+	return granted, nil
+}
+
+// GetAvgRowWidth is the size of the table over its rows estimate in
+// pg_class, in bytes, both as of the last VACUUM or ANALYZE.
+func (p *PostgresSource) GetAvgRowWidth(ctx context.Context) (int, error) {
+	err := p.SwitchDatabase()
+	if err != nil {
+		return 0, err
+	}
+	var width float64
+	err = p.db.QueryRowContext(ctx, "SELECT CASE WHEN reltuples > 0 THEN relpages * 8192 / reltuples ELSE 0 END FROM pg_class WHERE oid = $1::regclass",
+		p.cfg.SourceTable).Scan(&width)
+	if err != nil {
+		return 0, err
+	}
+	return int(width), nil
+}
+
+func (p *PostgresSource) GetMinMaxSplitKey(ctx context.Context) (uint64, uint64, error) {
 	err := p.SwitchDatabase()
 	if err != nil {
 		return 0, 0, err
 	}
 
 	query := fmt.Sprintf("SELECT COALESCE(MIN(%s), 0), COALESCE(MAX(%s), 0) FROM %s WHERE %s",
-		p.cfg.SourceSplitKey, p.cfg.SourceSplitKey, p.cfg.SourceTable, p.cfg.SourceWhereCondition)
+		p.cfg.SourceSplitKey, p.cfg.SourceSplitKey, sourceRelation(p.cfg, p.cfg.SourceTable), p.cfg.SourceWhereCondition)
 
-	rows, err := p.db.Query(query)
+	rows, err := p.db.QueryContext(ctx, tagSQL(p.cfg, "min-max", query))
 	if err != nil {
 		return 0, 0, err
 	}
@@ -149,36 +197,40 @@ func (p *PostgresSource) GetMinMaxSplitKey() (uint64, uint64, error) {
 	return min64, max64, nil
 }
 
-func (p *PostgresSource) GetMinMaxTimeSplitKey() (string, string, error) {
+func (p *PostgresSource) GetMinMaxTimeSplitKey(ctx context.Context) (string, string, error) {
 	err := p.SwitchDatabase()
 	if err != nil {
 		return "", "", err
 	}
-	rows, err := p.db.Query(fmt.Sprintf("select min(%s), max(%s) from %s WHERE %s", p.cfg.SourceSplitTimeKey,
-		p.cfg.SourceSplitTimeKey, p.cfg.SourceTable, p.cfg.SourceWhereCondition))
+	rows, err := p.db.QueryContext(ctx, tagSQL(p.cfg, "min-max", fmt.Sprintf("select min(%s), max(%s) from %s WHERE %s", p.cfg.SourceSplitTimeKey,
+		p.cfg.SourceSplitTimeKey, sourceRelation(p.cfg, p.cfg.SourceTable), p.cfg.SourceWhereCondition)))
 	if err != nil {
 		return "", "", err
 	}
 	defer rows.Close()
 
-	var minSplitKey, maxSplitKey string
+	// MIN and MAX are NULL on an empty table
+	var minSplitKey, maxSplitKey sql.NullString
 	for rows.Next() {
 		err = rows.Scan(&minSplitKey, &maxSplitKey)
 		if err != nil {
 			return "", "", err
 		}
 	}
-	return minSplitKey, maxSplitKey, nil
+	if !minSplitKey.Valid || !maxSplitKey.Valid {
+		return "", "", nil
+	}
+	return minSplitKey.String, maxSplitKey.String, nil
 }
 
-func (p *PostgresSource) DeleteAfterSync() error {
+func (p *PostgresSource) DeleteAfterSync(ctx context.Context) error {
 	err := p.SwitchDatabase()
 	if err != nil {
 		return err
 	}
 	if p.cfg.DeleteAfterSync {
-		_, err := p.db.Exec(fmt.Sprintf("delete from %s where %s",
-			p.cfg.SourceTable, p.cfg.SourceWhereCondition))
+		_, err := p.db.ExecContext(ctx, tagSQL(p.cfg, "delete", fmt.Sprintf("delete from %s where %s",
+			p.cfg.SourceTable, p.cfg.SourceWhereCondition)))
 		if err != nil {
 			return err
 		}
@@ -186,18 +238,31 @@ func (p *PostgresSource) DeleteAfterSync() error {
 	return nil
 }
 
-func (p *PostgresSource) QueryTableData(threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+// Maintain runs operation on the source table.
+func (p *PostgresSource) Maintain(ctx context.Context, operation string) error {
+	if err := p.SwitchDatabase(); err != nil {
+		return err
+	}
+	query, err := maintenanceSQL(p.cfg.DatabaseType, operation, p.cfg.SourceTable)
+	if err != nil {
+		return err
+	}
+	startTime := time.Now()
+	if _, err := p.db.ExecContext(ctx, tagSQL(p.cfg, operation, query)); err != nil {
+		return err
+	}
+	logrus.Infof("%s in %v", query, time.Since(startTime).Round(time.Second))
+	return nil
+}
+
+func (p *PostgresSource) QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
 	startTime := time.Now()
 	err := p.SwitchDatabase()
 	if err != nil {
 		return nil, nil, err
 	}
-	execSql := fmt.Sprintf("SELECT * FROM %s WHERE %s",
-		p.cfg.SourceTable, conditionSql)
-	if p.cfg.SourceWhereCondition != "" && p.cfg.SourceSplitKey != "" {
-		execSql = fmt.Sprintf("%s AND %s", execSql, p.cfg.SourceWhereCondition)
-	}
-	rows, err := p.db.Query(execSql)
+	execSql := selectBatchSQL(p.cfg, sourceRelation(p.cfg, p.cfg.SourceTable), conditionSql)
+	rows, err := p.db.QueryContext(ctx, tagSQL(p.cfg, conditionSql, execSql))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -212,100 +277,54 @@ func (p *PostgresSource) QueryTableData(threadNum int, conditionSql string) ([][
 		return nil, nil, err
 	}
 
-	scanArgs := make([]interface{}, len(columns))
-	for i, columnType := range columnTypes {
-		fmt.Printf("%s\n", columnType.DatabaseTypeName())
-		switch columnType.DatabaseTypeName() {
-		case "INT", "SMALLINT", "TINYINT", "MEDIUMINT", "BIGINT", "INT4", "INT8":
-			scanArgs[i] = new(sql.NullInt64)
-		case "UNSIGNED INT", "UNSIGNED TINYINT", "UNSIGNED MEDIUMINT", "UNSIGNED BIGINT":
-			scanArgs[i] = new(sql.NullInt64)
-		case "FLOAT", "DOUBLE", "FLOAT8":
-			scanArgs[i] = new(sql.NullFloat64)
-		case "DECIMAL", "NUMERIC":
-			scanArgs[i] = new(sql.NullFloat64)
-		case "CHAR", "VARCHAR", "TEXT", "TINYTEXT", "MEDIUMTEXT", "LONGTEXT":
-			scanArgs[i] = new(sql.NullString)
-		case "DATE", "TIME", "DATETIME", "TIMESTAMP":
-			scanArgs[i] = new(sql.NullString) // or use time.Time
-		case "BOOL", "BOOLEAN":
-			scanArgs[i] = new(sql.NullBool)
-		default:
-			scanArgs[i] = new(sql.RawBytes)
-		}
-	}
-
+	scanner := p.rowScanner(columns, columnTypes)
 	var result [][]interface{}
-	//rowCount, err := s.GetRowsCountByConditionSql(conditionSql)
-	//if err != nil {
-	//	return nil, nil, err
-	//}
-	//result := make([][]interface{}, rowCount)
 	for rows.Next() {
-		err = rows.Scan(scanArgs...)
+		row, err := scanner.scan(rows)
 		if err != nil {
 			return nil, nil, err
 		}
-
-		row := make([]interface{}, len(columns))
-		for i, v := range scanArgs {
-			switch v := v.(type) {
-			case *int:
-				row[i] = *v
-			case *string:
-				row[i] = *v
-			case *sql.NullString:
-				if v.Valid {
-					row[i] = v.String
-				} else {
-					row[i] = nil
-				}
-			case *bool:
-				row[i] = *v
-			case *sql.NullInt64:
-				if v.Valid {
-					row[i] = v.Int64
-				} else {
-					row[i] = nil
-				}
-			case *sql.NullFloat64:
-				if v.Valid {
-					row[i] = v.Float64
-				} else {
-					row[i] = nil
-				}
-			case *sql.NullBool:
-				if v.Valid {
-					//row[i] = v.Bool
-					if v.Bool {
-						row[i] = 1
-					} else {
-						row[i] = 0
-					}
-				} else {
-					row[i] = nil
-				}
-			case *float64:
-				row[i] = *v
-			case *sql.RawBytes:
-				row[i] = string(*v)
-			}
-		}
 		result = append(result, row)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, nil, err
 	}
-	p.statsRecorder.RecordMetric(len(result))
+	p.statsRecorder.RecordBatch(p.cfg, threadNum, result)
 	stats := p.statsRecorder.Stats(time.Since(startTime))
 	log.Printf("thread-%d: extract %d rows (%f rows/s)", threadNum, len(result)+1, stats.RowsPerSecondd)
 
 	return result, columns, nil
 }
 
-func (p *PostgresSource) GetDatabasesAccordingToSourceDbRegex(sourceDatabasePattern string) ([]string, error) {
-	rows, err := p.db.Query("SELECT datname FROM pg_database")
+func (p *PostgresSource) rowScanner(columns []string, columnTypes []*sql.ColumnType) *rowScanner {
+	scanner := newRowScanner("pg", columnTypes)
+	if p.cfg.PgStringifyComplexTypes {
+		for i, columnType := range columnTypes {
+			if isPgComplexType(columnType.DatabaseTypeName()) {
+				scanner.setConverter(i, nullStringConverter)
+			}
+		}
+	}
+	applyInvalidDatePolicy(p.cfg, scanner, columns, columnTypes)
+	return scanner
+}
+
+// ReadSnapshot reads the source table, view or sourceSelect in one query.
+func (p *PostgresSource) ReadSnapshot(ctx context.Context, fn func(columns []string, rows [][]interface{}) error) error {
+	if err := p.SwitchDatabase(); err != nil {
+		return err
+	}
+	rows, err := p.db.QueryContext(ctx, tagSQL(p.cfg, "snapshot", snapshotSQL(p.cfg, sourceRelation(p.cfg, p.cfg.SourceTable))))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	return readBatches(p.cfg, rows, p.rowScanner, p.statsRecorder, fn)
+}
+
+func (p *PostgresSource) GetDatabasesAccordingToSourceDbRegex(ctx context.Context, sourceDatabasePattern string) ([]string, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT datname FROM pg_database")
 	if err != nil {
 		return nil, err
 	}
@@ -329,7 +348,7 @@ func (p *PostgresSource) GetDatabasesAccordingToSourceDbRegex(sourceDatabasePatt
 	return databases, nil
 }
 
-func (p *PostgresSource) GetTablesAccordingToSourceTableRegex(sourceTablePattern string, databases []string) (map[string][]string, error) {
+func (p *PostgresSource) GetTablesAccordingToSourceTableRegex(ctx context.Context, sourceTablePattern string, databases []string) (map[string][]string, error) {
 	dbTables := make(map[string][]string)
 	for _, database := range databases {
 		p.cfg.SourceDB = database
@@ -337,7 +356,7 @@ func (p *PostgresSource) GetTablesAccordingToSourceTableRegex(sourceTablePattern
 		if err != nil {
 			return nil, err
 		}
-		rows, err := p.db.Query(fmt.Sprintf("SELECT tablename FROM pg_catalog.pg_tables WHERE schemaname != 'pg_catalog' AND schemaname != 'information_schema'"))
+		rows, err := p.db.QueryContext(ctx, fmt.Sprintf("SELECT tablename FROM pg_catalog.pg_tables WHERE schemaname != 'pg_catalog' AND schemaname != 'information_schema'"))
 		if err != nil {
 			return nil, err
 		}
@@ -363,10 +382,10 @@ func (p *PostgresSource) GetTablesAccordingToSourceTableRegex(sourceTablePattern
 	return dbTables, nil
 }
 
-func (p *PostgresSource) GetAllSourceReadRowsCount() (int, error) {
+func (p *PostgresSource) GetAllSourceReadRowsCount(ctx context.Context) (int, error) {
 	allCount := 0
 
-	dbTables, err := p.GetDbTablesAccordingToSourceDbTables()
+	dbTables, err := p.GetDbTablesAccordingToSourceDbTables(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -374,7 +393,7 @@ func (p *PostgresSource) GetAllSourceReadRowsCount() (int, error) {
 		p.cfg.SourceDB = db
 		for _, table := range tables {
 			p.cfg.SourceTable = table
-			count, err := p.GetSourceReadRowsCount()
+			count, err := p.GetSourceReadRowsCount(ctx)
 			if err != nil {
 				return 0, err
 			}
@@ -385,18 +404,18 @@ func (p *PostgresSource) GetAllSourceReadRowsCount() (int, error) {
 	return allCount, nil
 }
 
-func (p *PostgresSource) GetDbTablesAccordingToSourceDbTables() (map[string][]string, error) {
+func (p *PostgresSource) GetDbTablesAccordingToSourceDbTables(ctx context.Context) (map[string][]string, error) {
 	allDbTables := make(map[string][]string)
 	for _, sourceDbTable := range p.cfg.SourceDbTables {
 		dbTable := strings.Split(sourceDbTable, "@") // because `.` in regex is a special character, so use `@` to split
 		if len(dbTable) != 2 {
 			return nil, fmt.Errorf("invalid sourceDbTable: %s, should be a.b format", sourceDbTable)
 		}
-		dbs, err := p.GetDatabasesAccordingToSourceDbRegex(dbTable[0])
+		dbs, err := p.GetDatabasesAccordingToSourceDbRegex(ctx, dbTable[0])
 		if err != nil {
 			return nil, fmt.Errorf("get databases according to sourceDbRegex failed: %v", err)
 		}
-		dbTables, err := p.GetTablesAccordingToSourceTableRegex(dbTable[1], dbs)
+		dbTables, err := p.GetTablesAccordingToSourceTableRegex(ctx, dbTable[1], dbs)
 		if err != nil {
 			return nil, fmt.Errorf("get tables according to sourceTableRegex failed: %v", err)
 		}
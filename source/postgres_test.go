@@ -1,6 +1,7 @@
 package source
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"testing"
@@ -81,7 +82,7 @@ $$;`)
 func TestPostgresSource_GetDbTablesAccordingToSourceDbTables(t *testing.T) {
 	postgresSourceTest, tearDownFunc := setupPostgresSourceTest()
 	defer tearDownFunc()
-	tables, err := postgresSourceTest.postgresSource.GetDbTablesAccordingToSourceDbTables()
+	tables, err := postgresSourceTest.postgresSource.GetDbTablesAccordingToSourceDbTables(context.Background())
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(tables))
 	assert.Equal(t, []string{"test_table"}, tables["mydb"])
@@ -90,7 +91,7 @@ func TestPostgresSource_GetDbTablesAccordingToSourceDbTables(t *testing.T) {
 func TestPostgresSource_GetTablesAccordingToSourceTableRegex(t *testing.T) {
 	postgresSourceTest, tearDownFunc := setupPostgresSourceTest()
 	defer tearDownFunc()
-	tables, err := postgresSourceTest.postgresSource.GetTablesAccordingToSourceTableRegex("test_table", []string{"mydb"})
+	tables, err := postgresSourceTest.postgresSource.GetTablesAccordingToSourceTableRegex(context.Background(), "test_table", []string{"mydb"})
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(tables))
 	assert.Equal(t, "test_table", tables["mydb"][0])
@@ -99,7 +100,7 @@ func TestPostgresSource_GetTablesAccordingToSourceTableRegex(t *testing.T) {
 func TestPostgresSource_GetDatabasesAccordingToSourceDbRegex(t *testing.T) {
 	postgresSourceTest, tearDownFunc := setupPostgresSourceTest()
 	defer tearDownFunc()
-	dbs, err := postgresSourceTest.postgresSource.GetDatabasesAccordingToSourceDbRegex("mydb")
+	dbs, err := postgresSourceTest.postgresSource.GetDatabasesAccordingToSourceDbRegex(context.Background(), "mydb")
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(dbs))
 	assert.Equal(t, "mydb", dbs[0])
@@ -108,7 +109,7 @@ func TestPostgresSource_GetDatabasesAccordingToSourceDbRegex(t *testing.T) {
 func TestPostgresSource_GetSourceReadRowsCount(t *testing.T) {
 	postgresSourceTest, tearDownFunc := setupPostgresSourceTest()
 	defer tearDownFunc()
-	count, err := postgresSourceTest.postgresSource.GetSourceReadRowsCount()
+	count, err := postgresSourceTest.postgresSource.GetSourceReadRowsCount(context.Background())
 	assert.NoError(t, err)
 	assert.Equal(t, 2, count)
 }
@@ -116,7 +117,7 @@ func TestPostgresSource_GetSourceReadRowsCount(t *testing.T) {
 func TestPostgresSource_GetAllSourceReadRowsCount(t *testing.T) {
 	postgresSourceTest, tearDownFunc := setupPostgresSourceTest()
 	defer tearDownFunc()
-	count, err := postgresSourceTest.postgresSource.GetAllSourceReadRowsCount()
+	count, err := postgresSourceTest.postgresSource.GetAllSourceReadRowsCount(context.Background())
 	assert.NoError(t, err)
 	assert.Equal(t, 2, count)
 }
@@ -124,7 +125,7 @@ func TestPostgresSource_GetAllSourceReadRowsCount(t *testing.T) {
 func TestPostgresSource_QueryTableData(t *testing.T) {
 	postgresSourceTest, tearDownFunc := setupPostgresSourceTest()
 	defer tearDownFunc()
-	data, columns, err := postgresSourceTest.postgresSource.QueryTableData(1, "id > 0")
+	data, columns, err := postgresSourceTest.postgresSource.QueryTableData(context.Background(), 1, "id > 0")
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(data))
 	assert.Equal(t, 3, len(columns))
@@ -137,7 +138,7 @@ func TestPostgresSource_QueryTableData(t *testing.T) {
 func TestPostgresSource_GetMinMaxSplitKey(t *testing.T) {
 	postgresSourceTest, tearDownFunc := setupPostgresSourceTest()
 	defer tearDownFunc()
-	min, max, err := postgresSourceTest.postgresSource.GetMinMaxSplitKey()
+	min, max, err := postgresSourceTest.postgresSource.GetMinMaxSplitKey(context.Background())
 	assert.NoError(t, err)
 	assert.Equal(t, uint64(1), min)
 	assert.Equal(t, uint64(2), max)
@@ -146,7 +147,7 @@ func TestPostgresSource_GetMinMaxSplitKey(t *testing.T) {
 func TestPostgresSource_GetMinMaxTimeSplitKey(t *testing.T) {
 	postgresSourceTest, tearDownFunc := setupPostgresSourceTest()
 	defer tearDownFunc()
-	min, max, err := postgresSourceTest.postgresSource.GetMinMaxTimeSplitKey()
+	min, max, err := postgresSourceTest.postgresSource.GetMinMaxTimeSplitKey(context.Background())
 	assert.NoError(t, err)
 	assert.NotEmpty(t, min)
 	assert.NotEmpty(t, max)
@@ -156,7 +157,7 @@ func TestPostgresSource_DeleteAfterSync(t *testing.T) {
 	postgresSourceTest, tearDownFunc := setupPostgresSourceTest()
 	defer tearDownFunc()
 	postgresSourceTest.postgresSource.cfg.DeleteAfterSync = true
-	err := postgresSourceTest.postgresSource.DeleteAfterSync()
+	err := postgresSourceTest.postgresSource.DeleteAfterSync(context.Background())
 	assert.NoError(t, err)
 }
 
@@ -170,6 +171,6 @@ func TestPostgresSource_SwitchDatabase(t *testing.T) {
 func TestPostgresSource_AdjustBatchSizeAccordingToSourceDbTable(t *testing.T) {
 	postgresSourceTest, tearDownFunc := setupPostgresSourceTest()
 	defer tearDownFunc()
-	batchSize := postgresSourceTest.postgresSource.AdjustBatchSizeAccordingToSourceDbTable()
+	batchSize := postgresSourceTest.postgresSource.AdjustBatchSizeAccordingToSourceDbTable(context.Background())
 	assert.Equal(t, uint64(2), batchSize)
 }
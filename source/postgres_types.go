@@ -0,0 +1,280 @@
+package source
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// pgArrayElements lists the array types (lib/pq names them after the element
+// type with a leading underscore) and how their elements are converted.
+var pgArrayElements = map[string]func(string) interface{}{
+	"_INT2":        pgInt,
+	"_INT4":        pgInt,
+	"_INT8":        pgInt,
+	"_OID":         pgInt,
+	"_FLOAT4":      pgNumber,
+	"_FLOAT8":      pgNumber,
+	"_NUMERIC":     pgNumber,
+	"_BOOL":        pgBool,
+	"_JSON":        pgJSON,
+	"_JSONB":       pgJSON,
+	"_TEXT":        pgText,
+	"_VARCHAR":     pgText,
+	"_BPCHAR":      pgText,
+	"_NAME":        pgText,
+	"_UUID":        pgText,
+	"_DATE":        pgText,
+	"_TIME":        pgText,
+	"_TIMESTAMP":   pgText,
+	"_TIMESTAMPTZ": pgText,
+	"_INET":        pgText,
+	"_CIDR":        pgText,
+}
+
+func init() {
+	for typeName, elem := range pgArrayElements {
+		RegisterValueConverter("pg", typeName, pgArrayConverter(elem))
+	}
+	registerValueConverters("pg", pgJSONConverter, "JSON", "JSONB")
+	// lib/pq has no name for user defined types, composite values are
+	// recognized by their parentheses
+	registerValueConverters("pg", pgCompositeConverter, "", "RECORD")
+}
+
+// isPgComplexType reports whether a column is converted from its Postgres
+// text form into an ARRAY or VARIANT value.
+func isPgComplexType(typeName string) bool {
+	switch typeName {
+	case "JSON", "JSONB", "", "RECORD":
+		return true
+	}
+	return strings.HasPrefix(typeName, "_")
+}
+
+func pgArrayConverter(elem func(string) interface{}) ValueConverter {
+	return ConverterFuncs{
+		NewArg: func() interface{} { return new(sql.NullString) },
+		ConvertArg: func(arg interface{}) (interface{}, error) {
+			v := arg.(*sql.NullString)
+			if !v.Valid {
+				return nil, nil
+			}
+			array, err := parsePgArray(v.String, elem)
+			if err != nil {
+				// keep what can't be parsed in its text form
+				return v.String, nil
+			}
+			return array, nil
+		},
+	}
+}
+
+var pgJSONConverter = ConverterFuncs{
+	NewArg: func() interface{} { return new(sql.NullString) },
+	ConvertArg: func(arg interface{}) (interface{}, error) {
+		if v := arg.(*sql.NullString); v.Valid {
+			return pgJSON(v.String), nil
+		}
+		return nil, nil
+	},
+}
+
+var pgCompositeConverter = ConverterFuncs{
+	NewArg: func() interface{} { return new(sql.NullString) },
+	ConvertArg: func(arg interface{}) (interface{}, error) {
+		v := arg.(*sql.NullString)
+		if !v.Valid {
+			return nil, nil
+		}
+		if fields, err := parsePgComposite(v.String); err == nil {
+			return fields, nil
+		}
+		return v.String, nil
+	},
+}
+
+func pgInt(s string) interface{} {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	return s
+}
+
+// pgNumber keeps the exact digits of numeric values, NaN and infinities are
+// not valid JSON numbers and stay strings.
+func pgNumber(s string) interface{} {
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return json.Number(s)
+	}
+	return s
+}
+
+func pgBool(s string) interface{} {
+	switch s {
+	case "t":
+		return true
+	case "f":
+		return false
+	}
+	return s
+}
+
+func pgJSON(s string) interface{} {
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	return s
+}
+
+func pgText(s string) interface{} {
+	return s
+}
+
+// parsePgArray parses the text form of an array, e.g. {1,2,NULL} or
+// {{"a b",c},{d,e}}, into nested slices.
+func parsePgArray(s string, elem func(string) interface{}) ([]interface{}, error) {
+	// skip explicit bounds, e.g. [0:1]={1,2}
+	if strings.HasPrefix(s, "[") {
+		i := strings.Index(s, "=")
+		if i < 0 {
+			return nil, errors.New("invalid array bounds")
+		}
+		s = s[i+1:]
+	}
+	p := &pgArrayParser{s: s, elem: elem}
+	array, err := p.array()
+	if err != nil {
+		return nil, err
+	}
+	if p.i != len(s) {
+		return nil, errors.Errorf("unexpected %q after array", s[p.i:])
+	}
+	return array, nil
+}
+
+type pgArrayParser struct {
+	s    string
+	i    int
+	elem func(string) interface{}
+}
+
+func (p *pgArrayParser) array() ([]interface{}, error) {
+	if p.i >= len(p.s) || p.s[p.i] != '{' {
+		return nil, errors.New("array must start with {")
+	}
+	p.i++
+	values := []interface{}{}
+	if p.i < len(p.s) && p.s[p.i] == '}' {
+		p.i++
+		return values, nil
+	}
+	for {
+		if p.i >= len(p.s) {
+			return nil, errors.New("unterminated array")
+		}
+		switch p.s[p.i] {
+		case '{':
+			nested, err := p.array()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, nested)
+		case '"':
+			text, err := p.quoted()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, p.elem(text))
+		default:
+			end := strings.IndexAny(p.s[p.i:], ",}")
+			if end < 0 {
+				return nil, errors.New("unterminated array")
+			}
+			text := strings.TrimSpace(p.s[p.i : p.i+end])
+			p.i += end
+			if strings.EqualFold(text, "NULL") {
+				values = append(values, nil)
+			} else {
+				values = append(values, p.elem(text))
+			}
+		}
+		if p.i >= len(p.s) {
+			return nil, errors.New("unterminated array")
+		}
+		sep := p.s[p.i]
+		p.i++
+		if sep == '}' {
+			return values, nil
+		}
+		if sep != ',' {
+			return nil, errors.Errorf("unexpected %q in array", sep)
+		}
+	}
+}
+
+func (p *pgArrayParser) quoted() (string, error) {
+	var b strings.Builder
+	for p.i++; p.i < len(p.s); p.i++ {
+		switch c := p.s[p.i]; c {
+		case '\\':
+			p.i++
+			if p.i < len(p.s) {
+				b.WriteByte(p.s[p.i])
+			}
+		case '"':
+			p.i++
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", errors.New("unterminated quoted array element")
+}
+
+// parsePgComposite parses the text form of a row value, e.g. (1,"a b",), into
+// its fields, empty unquoted fields are NULL.
+func parsePgComposite(s string) ([]interface{}, error) {
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		return nil, errors.New("composite value must be in parentheses")
+	}
+	s = s[1 : len(s)-1]
+	fields := []interface{}{}
+	var b strings.Builder
+	quoted, inQuotes := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inQuotes && c == '"' && i+1 < len(s) && s[i+1] == '"':
+			b.WriteByte('"')
+			i++
+		case c == '"':
+			inQuotes = !inQuotes
+			quoted = true
+		case c == '\\' && i+1 < len(s):
+			i++
+			b.WriteByte(s[i])
+		case c == ',' && !inQuotes:
+			fields = append(fields, pgCompositeField(b.String(), quoted))
+			b.Reset()
+			quoted = false
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if inQuotes {
+		return nil, errors.New("unterminated quoted composite field")
+	}
+	return append(fields, pgCompositeField(b.String(), quoted)), nil
+}
+
+func pgCompositeField(s string, quoted bool) interface{} {
+	if s == "" && !quoted {
+		return nil
+	}
+	return s
+}
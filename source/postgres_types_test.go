@@ -0,0 +1,51 @@
+package source
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestDecodePgArray(t *testing.T) {
+	array, err := parsePgArray(`{{1,2},{NULL,4}}`, pgInt)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		[]interface{}{int64(1), int64(2)},
+		[]interface{}{nil, int64(4)},
+	}, array)
+
+	array, err = parsePgArray(`{"a b","say \"hi\"",NULL,"NULL",c}`, pgText)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"a b", `say "hi"`, nil, "NULL", "c"}, array)
+
+	array, err = parsePgArray(`[0:1]={1.50,NaN}`, pgNumber)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{json.Number("1.50"), "NaN"}, array)
+
+	array, err = parsePgArray(`{}`, pgBool)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{}, array)
+
+	_, err = parsePgArray(`{1,2`, pgInt)
+	assert.Error(t, err)
+
+	v, err := pgArrayConverter(pgBool).Convert(&sql.NullString{String: "{t,f}", Valid: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{true, false}, v)
+}
+
+func TestDecodePgComposite(t *testing.T) {
+	fields, err := parsePgComposite(`(1,"a, ""b""",,"")`)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"1", `a, "b"`, nil, ""}, fields)
+
+	v, err := pgCompositeConverter.Convert(&sql.NullString{String: "happy", Valid: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "happy", v)
+
+	v, err = pgJSONConverter.Convert(&sql.NullString{String: `{"a":1}`, Valid: true})
+	assert.NoError(t, err)
+	assert.Equal(t, json.RawMessage(`{"a":1}`), v)
+}
@@ -0,0 +1,141 @@
+package source
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// LoadProtobufMessage returns the descriptor of the message named name from a
+// FileDescriptorSet, as written by protoc --include_imports --descriptor_set_out.
+func LoadProtobufMessage(descriptorSet, name string) (protoreflect.MessageDescriptor, error) {
+	if descriptorSet == "" || name == "" {
+		return nil, errors.New("protoDescriptorSet and protoMessage must be set for protobuf files")
+	}
+	data, err := os.ReadFile(descriptorSet)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, errors.Wrap(err, "parse descriptor set failed")
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, errors.Wrap(err, "load descriptor set failed")
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "find message %s failed", name)
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", name)
+	}
+	return md, nil
+}
+
+// ReadProtobufBatches decodes length-delimited (varint size prefixed)
+// messages of type md. Every top-level field is a column, nested messages,
+// repeated fields and maps become VARIANT values.
+func ReadProtobufBatches(r io.Reader, md protoreflect.MessageDescriptor, batchSize int, fn func(columns []string, rows [][]interface{}) error) error {
+	fields := md.Fields()
+	columns := make([]string, fields.Len())
+	for i := 0; i < fields.Len(); i++ {
+		columns[i] = string(fields.Get(i).Name())
+	}
+
+	br := bufio.NewReader(r)
+	options := protodelim.UnmarshalOptions{MaxSize: -1}
+	var rows [][]interface{}
+	for {
+		msg := dynamicpb.NewMessage(md)
+		err := options.UnmarshalFrom(br, msg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "read protobuf message failed")
+		}
+		row := make([]interface{}, fields.Len())
+		for i := 0; i < fields.Len(); i++ {
+			row[i] = protobufField(msg, fields.Get(i))
+		}
+		rows = append(rows, row)
+		if len(rows) >= batchSize {
+			if err := fn(columns, rows); err != nil {
+				return err
+			}
+			rows = nil
+		}
+	}
+	if len(rows) > 0 {
+		return fn(columns, rows)
+	}
+	return nil
+}
+
+func protobufField(msg protoreflect.Message, fd protoreflect.FieldDescriptor) interface{} {
+	if fd.HasPresence() && !msg.Has(fd) {
+		return nil
+	}
+	v := msg.Get(fd)
+	switch {
+	case fd.IsList():
+		list := v.List()
+		values := make([]interface{}, list.Len())
+		for i := 0; i < list.Len(); i++ {
+			values[i] = protobufValue(fd, list.Get(i))
+		}
+		return values
+	case fd.IsMap():
+		values := make(map[string]interface{}, v.Map().Len())
+		v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+			values[k.String()] = protobufValue(fd.MapValue(), mv)
+			return true
+		})
+		return values
+	default:
+		return protobufValue(fd, v)
+	}
+}
+
+// protobufValue converts a singular value, enums become their names and
+// google.protobuf.Timestamp an RFC 3339 string.
+func protobufValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return int32(v.Enum())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		m := v.Message()
+		if m.Descriptor().FullName() == "google.protobuf.Timestamp" {
+			fields := m.Descriptor().Fields()
+			seconds := m.Get(fields.ByName("seconds")).Int()
+			nanos := m.Get(fields.ByName("nanos")).Int()
+			return time.Unix(seconds, nanos).UTC().Format(time.RFC3339Nano)
+		}
+		values := make(map[string]interface{})
+		m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+			values[string(fd.Name())] = protobufField(m, fd)
+			return true
+		})
+		return values
+	case protoreflect.BytesKind:
+		return v.Bytes()
+	default:
+		return v.Interface()
+	}
+}
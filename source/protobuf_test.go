@@ -0,0 +1,99 @@
+package source
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func writeEventDescriptorSet(t *testing.T) string {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Type:   typ.Enum(),
+			Label:  label.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("events.proto"),
+		Package:    proto.String("events.v1"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Level"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("INFO"), Number: proto.Int32(0)},
+				{Name: proto.String("ERROR"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Event"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, optional, ""),
+				field("level", 2, descriptorpb.FieldDescriptorProto_TYPE_ENUM, optional, ".events.v1.Level"),
+				field("tags", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_LABEL_REPEATED, ""),
+				field("at", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".google.protobuf.Timestamp"),
+			},
+		}},
+	}
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto),
+		file,
+	}}
+	data, err := proto.Marshal(set)
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "events.binpb")
+	assert.NoError(t, os.WriteFile(path, data, 0o644))
+	return path
+}
+
+func TestReadProtobufBatches(t *testing.T) {
+	md, err := LoadProtobufMessage(writeEventDescriptorSet(t), "events.v1.Event")
+	assert.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 3; i++ {
+		msg := dynamicpb.NewMessage(md)
+		msg.Set(md.Fields().ByName("id"), protoreflect.ValueOfInt64(int64(i)))
+		msg.Set(md.Fields().ByName("level"), protoreflect.ValueOfEnum(protoreflect.EnumNumber(i%2)))
+		if i == 1 {
+			tags := msg.Mutable(md.Fields().ByName("tags")).List()
+			tags.Append(protoreflect.ValueOfString("a"))
+			msg.Set(md.Fields().ByName("at"), protoreflect.ValueOfMessage(timestamppb.New(at).ProtoReflect()))
+		}
+		_, err := protodelim.MarshalTo(buf, msg)
+		assert.NoError(t, err)
+	}
+
+	var batches [][][]interface{}
+	var columns []string
+	err = ReadProtobufBatches(buf, md, 2, func(c []string, rows [][]interface{}) error {
+		columns = c
+		batches = append(batches, rows)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id", "level", "tags", "at"}, columns)
+	assert.Equal(t, 2, len(batches))
+	assert.Equal(t, []interface{}{int64(1), "ERROR", []interface{}{"a"}, "2024-01-01T00:00:00Z"}, batches[0][0])
+	assert.Equal(t, []interface{}{int64(2), "INFO", []interface{}{}, nil}, batches[0][1])
+	assert.Equal(t, int64(3), batches[1][0][0])
+}
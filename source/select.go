@@ -0,0 +1,19 @@
+package source
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// sourceRelation is what the SQL sources count, split and read: table, or
+// the result set of sourceSelect when it is set, so that the conditions of
+// the batches apply to the columns of the query.
+func sourceRelation(cfg *config.Config, table string) string {
+	if cfg.SourceSelect == "" {
+		return table
+	}
+	query := strings.TrimSuffix(strings.TrimSpace(cfg.SourceSelect), ";")
+	return fmt.Sprintf("(%s) source_select", query)
+}
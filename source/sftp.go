@@ -0,0 +1,148 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// SFTPSource archives the CSV/NDJSON files of a remote directory over SFTP.
+type SFTPSource struct {
+	fileSource
+	sshClient *ssh.Client
+	client    *sftp.Client
+}
+
+func NewSFTPSource(cfg *config.Config) (*SFTPSource, error) {
+	sshConfig, err := sshClientConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	port := cfg.SourcePort
+	if port == 0 {
+		port = 22
+	}
+	sshClient, err := ssh.Dial("tcp", net.JoinHostPort(cfg.SourceHost, strconv.Itoa(port)), sshConfig)
+	if err != nil {
+		logrus.Errorf("failed to dial sftp host: %v", err)
+		return nil, err
+	}
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, err
+	}
+	return &SFTPSource{
+		fileSource: fileSource{cfg: cfg},
+		sshClient:  sshClient,
+		client:     client,
+	}, nil
+}
+
+func sshClientConfig(cfg *config.Config) (*ssh.ClientConfig, error) {
+	return newSSHClientConfig(cfg.SourceHost, cfg.SourceUser, cfg.SourcePass, cfg.SourceKeyFile, cfg.SourceKnownHosts,
+		cfg.SourceInsecureSkipHostKeyCheck)
+}
+
+// newSSHClientConfig authenticates with keyFile, whose passphrase is password
+// if it is encrypted, or with password when there is no key. The host key is
+// verified with knownHosts, without it only when skipHostKeyCheck is set.
+func newSSHClientConfig(host, user, password, keyFile, knownHosts string, skipHostKeyCheck bool) (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+	if keyFile != "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if _, ok := err.(*ssh.PassphraseMissingError); ok {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(password))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse private key %s failed: %w", keyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	} else {
+		auth = append(auth, ssh.Password(password))
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	switch {
+	case knownHosts != "":
+		callback, err := knownhosts.New(knownHosts)
+		if err != nil {
+			return nil, err
+		}
+		hostKeyCallback = callback
+	case skipHostKeyCheck:
+		logrus.Warnf("sourceInsecureSkipHostKeyCheck is set, the host key of %s will not be verified", host)
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, fmt.Errorf("no known_hosts to verify the host key of %s", host)
+	}
+
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}, nil
+}
+
+func (s *SFTPSource) ListFiles(ctx context.Context) ([]FileInfo, error) {
+	entries, err := s.client.ReadDir(s.cfg.SourcePath)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := s.filePattern()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []FileInfo
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		if pattern != nil && !pattern.MatchString(entry.Name()) {
+			continue
+		}
+		files = append(files, FileInfo{
+			Path:    path.Join(s.cfg.SourcePath, entry.Name()),
+			Size:    entry.Size(),
+			ModTime: entry.ModTime(),
+			Table:   TableNameFromPath(entry.Name()),
+		})
+	}
+	sortFiles(files)
+	return files, nil
+}
+
+func (s *SFTPSource) OpenFile(ctx context.Context, file FileInfo) (io.ReadCloser, error) {
+	return s.client.Open(file.Path)
+}
+
+func (s *SFTPSource) RemoveFile(ctx context.Context, file FileInfo) error {
+	return s.client.Remove(file.Path)
+}
+
+func (s *SFTPSource) MoveFile(ctx context.Context, file FileInfo, dir string) error {
+	return s.client.Rename(file.Path, path.Join(dir, path.Base(file.Path)))
+}
+
+func (s *SFTPSource) Close() error {
+	s.client.Close()
+	return s.sshClient.Close()
+}
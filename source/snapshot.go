@@ -0,0 +1,66 @@
+package source
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// snapshotSQL selects the rows of table matching sourceWhereCondition.
+func snapshotSQL(cfg *config.Config, table string) string {
+	query := "SELECT * FROM " + table
+	if cfg.SourceWhereCondition != "" {
+		query = fmt.Sprintf("%s WHERE %s", query, cfg.SourceWhereCondition)
+	}
+	return query
+}
+
+// readBatches scans rows with the scanner of newScanner and calls fn with
+// every batchSize of them as they come, so a snapshot is never held in
+// memory whole.
+func readBatches(cfg *config.Config, rows *sql.Rows, newScanner func([]string, []*sql.ColumnType) *rowScanner,
+	stats *DatabendSourceStatsRecorder, fn func(columns []string, rows [][]interface{}) error) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	scanner := newScanner(columns, columnTypes)
+	batch := make([][]interface{}, 0, cfg.BatchSize)
+	startTime := time.Now()
+	flush := func() error {
+		stats.RecordBatch(cfg, 0, batch)
+		log.Printf("snapshot: extract %d rows (%f rows/s)", len(batch), stats.Stats(time.Since(startTime)).RowsPerSecondd)
+		if err := fn(columns, batch); err != nil {
+			return err
+		}
+		batch = make([][]interface{}, 0, cfg.BatchSize)
+		startTime = time.Now()
+		return nil
+	}
+	for rows.Next() {
+		row, err := scanner.scan(rows)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, row)
+		if int64(len(batch)) >= cfg.BatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return flush()
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package source
+
+import (
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestSnapshotSQL(t *testing.T) {
+	cfg := &config.Config{}
+	assert.Equal(t, "SELECT * FROM shop.order_totals", snapshotSQL(cfg, "shop.order_totals"))
+	cfg.SourceWhereCondition = "region = 'eu'"
+	assert.Equal(t, "SELECT * FROM shop.order_totals WHERE region = 'eu'", snapshotSQL(cfg, "shop.order_totals"))
+	cfg.SourceSelect = "SELECT region, sum(total) AS total FROM orders GROUP BY region"
+	assert.Equal(t, "SELECT * FROM (SELECT region, sum(total) AS total FROM orders GROUP BY region) source_select WHERE region = 'eu'",
+		snapshotSQL(cfg, sourceRelation(cfg, "shop.orders")))
+}
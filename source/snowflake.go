@@ -0,0 +1,577 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// SnowflakeSource reads Snowflake tables through the SQL REST API, fetching
+// every partition of a result set. It splits tables by key or time like the
+// other SQL sources.
+type SnowflakeSource struct {
+	cfg           *config.Config
+	client        *http.Client
+	baseURL       string
+	privateKey    *rsa.PrivateKey
+	statsRecorder *DatabendSourceStatsRecorder
+
+	mu        sync.Mutex
+	jwt       string
+	jwtExpire time.Time
+}
+
+type snowflakeColumn struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Scale int    `json:"scale"`
+}
+
+type snowflakeResponse struct {
+	Code              string `json:"code"`
+	Message           string `json:"message"`
+	StatementHandle   string `json:"statementHandle"`
+	ResultSetMetaData struct {
+		PartitionInfo []struct {
+			RowCount int `json:"rowCount"`
+		} `json:"partitionInfo"`
+		RowType []snowflakeColumn `json:"rowType"`
+	} `json:"resultSetMetaData"`
+	Data [][]*string `json:"data"`
+}
+
+func NewSnowflakeSource(cfg *config.Config) (*SnowflakeSource, error) {
+	stats := NewDatabendIntesterStatsRecorder()
+	if cfg.SnowflakeAccount == "" {
+		return nil, errors.New("snowflakeAccount must be set for the snowflake source")
+	}
+	if cfg.SnowflakeSchema == "" {
+		cfg.SnowflakeSchema = "PUBLIC"
+	}
+	baseURL := cfg.SnowflakeURL
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://%s.snowflakecomputing.com", strings.ToLower(cfg.SnowflakeAccount))
+	}
+	s := &SnowflakeSource{
+		cfg:           cfg,
+		client:        &http.Client{Timeout: 10 * time.Minute},
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		statsRecorder: stats,
+	}
+	if cfg.SnowflakePrivateKeyFile != "" {
+		key, err := loadRSAPrivateKey(cfg.SnowflakePrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		s.privateKey = key
+	} else if cfg.SnowflakeToken == "" {
+		return nil, errors.New("snowflakePrivateKeyFile or snowflakeToken must be set for the snowflake source")
+	}
+	return s, nil
+}
+
+func (s *SnowflakeSource) AdjustBatchSizeAccordingToSourceDbTable(ctx context.Context) uint64 {
+	minSplitKey, maxSplitKey, err := s.GetMinMaxSplitKey(ctx)
+	if err != nil {
+		return uint64(s.cfg.BatchSize)
+	}
+	sourceTableRowCount, err := s.GetSourceReadRowsCount(ctx)
+	if err != nil || sourceTableRowCount == 0 {
+		return uint64(s.cfg.BatchSize)
+	}
+	rangeSize := maxSplitKey - minSplitKey + 1
+	switch {
+	case int64(sourceTableRowCount) <= s.cfg.BatchSize:
+		return rangeSize
+	case rangeSize/uint64(sourceTableRowCount) >= 10:
+		return uint64(s.cfg.BatchSize * 5)
+	default:
+		return uint64(s.cfg.BatchSize)
+	}
+}
+
+func (s *SnowflakeSource) GetSourceReadRowsCount(ctx context.Context) (int, error) {
+	_, rows, err := s.query(ctx, tagSQL(s.cfg, "count", fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", sourceRelation(s.cfg, s.tableName()), s.cfg.SourceWhereCondition)))
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	count, err := toUint64(rows[0][0])
+	return int(count), err
+}
+
+func (s *SnowflakeSource) GetMinMaxSplitKey(ctx context.Context) (uint64, uint64, error) {
+	_, rows, err := s.query(ctx, tagSQL(s.cfg, "min-max", fmt.Sprintf("SELECT MIN(%s), MAX(%s) FROM %s WHERE %s",
+		s.cfg.SourceSplitKey, s.cfg.SourceSplitKey, sourceRelation(s.cfg, s.tableName()), s.cfg.SourceWhereCondition)))
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(rows) == 0 || rows[0][0] == nil || rows[0][1] == nil {
+		return 0, 0, nil
+	}
+	min64, err := toUint64(rows[0][0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to convert min value: %w", err)
+	}
+	max64, err := toUint64(rows[0][1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to convert max value: %w", err)
+	}
+	return min64, max64, nil
+}
+
+func (s *SnowflakeSource) GetMinMaxTimeSplitKey(ctx context.Context) (string, string, error) {
+	_, rows, err := s.query(ctx, tagSQL(s.cfg, "min-max", fmt.Sprintf("SELECT TO_VARCHAR(MIN(%s), 'YYYY-MM-DD HH24:MI:SS'), TO_VARCHAR(MAX(%s), 'YYYY-MM-DD HH24:MI:SS') FROM %s WHERE %s",
+		s.cfg.SourceSplitTimeKey, s.cfg.SourceSplitTimeKey, sourceRelation(s.cfg, s.tableName()), s.cfg.SourceWhereCondition)))
+	if err != nil {
+		return "", "", err
+	}
+	if len(rows) == 0 || rows[0][0] == nil || rows[0][1] == nil {
+		return "", "", nil
+	}
+	return fmt.Sprint(rows[0][0]), fmt.Sprint(rows[0][1]), nil
+}
+
+func (s *SnowflakeSource) DeleteAfterSync(ctx context.Context) error {
+	if !s.cfg.DeleteAfterSync {
+		return nil
+	}
+	dbTables, err := s.GetDbTablesAccordingToSourceDbTables(ctx)
+	if err != nil {
+		return err
+	}
+	for db, tables := range dbTables {
+		for _, table := range tables {
+			query := fmt.Sprintf("DELETE FROM %s.%s.%s WHERE %s", db, s.cfg.SnowflakeSchema, table, s.cfg.SourceWhereCondition)
+			if _, _, err := s.query(ctx, tagSQL(s.cfg, "delete", query)); err != nil {
+				return err
+			}
+			logrus.Infof("deleted archived rows of %s.%s", db, table)
+		}
+	}
+	return nil
+}
+
+func (s *SnowflakeSource) QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+	startTime := time.Now()
+	execSql := selectBatchSQL(s.cfg, sourceRelation(s.cfg, s.tableName()), conditionSql)
+	columns, result, err := s.query(ctx, tagSQL(s.cfg, conditionSql, execSql))
+	if err != nil {
+		return nil, nil, err
+	}
+	s.statsRecorder.RecordBatch(s.cfg, threadNum, result)
+	stats := s.statsRecorder.Stats(time.Since(startTime))
+	log.Printf("thread-%d: extract %d rows (%f rows/s)", threadNum, len(result), stats.RowsPerSecondd)
+	return result, columns, nil
+}
+
+func (s *SnowflakeSource) GetDatabasesAccordingToSourceDbRegex(ctx context.Context, sourceDatabasePattern string) ([]string, error) {
+	columns, rows, err := s.query(ctx, "SHOW DATABASES")
+	if err != nil {
+		return nil, err
+	}
+	return matchShowResult(columns, rows, sourceDatabasePattern)
+}
+
+func (s *SnowflakeSource) GetTablesAccordingToSourceTableRegex(ctx context.Context, sourceTablePattern string, databases []string) (map[string][]string, error) {
+	dbTables := make(map[string][]string)
+	for _, database := range databases {
+		columns, rows, err := s.query(ctx, fmt.Sprintf("SHOW TABLES IN SCHEMA %s.%s", database, s.cfg.SnowflakeSchema))
+		if err != nil {
+			return nil, err
+		}
+		tables, err := matchShowResult(columns, rows, sourceTablePattern)
+		if err != nil {
+			return nil, err
+		}
+		dbTables[database] = tables
+	}
+	return dbTables, nil
+}
+
+func (s *SnowflakeSource) GetAllSourceReadRowsCount(ctx context.Context) (int, error) {
+	allCount := 0
+	dbTables, err := s.GetDbTablesAccordingToSourceDbTables(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for db, tables := range dbTables {
+		s.cfg.SourceDB = db
+		for _, table := range tables {
+			s.cfg.SourceTable = table
+			count, err := s.GetSourceReadRowsCount(ctx)
+			if err != nil {
+				return 0, err
+			}
+			allCount += count
+		}
+	}
+	return allCount, nil
+}
+
+func (s *SnowflakeSource) GetDbTablesAccordingToSourceDbTables(ctx context.Context) (map[string][]string, error) {
+	allDbTables := make(map[string][]string)
+	for _, sourceDbTable := range s.cfg.SourceDbTables {
+		dbTable := strings.Split(sourceDbTable, "@")
+		if len(dbTable) != 2 {
+			return nil, fmt.Errorf("invalid sourceDbTable: %s, should be a@b format", sourceDbTable)
+		}
+		dbs, err := s.GetDatabasesAccordingToSourceDbRegex(ctx, dbTable[0])
+		if err != nil {
+			return nil, fmt.Errorf("get databases according to sourceDbRegex failed: %v", err)
+		}
+		dbTables, err := s.GetTablesAccordingToSourceTableRegex(ctx, dbTable[1], dbs)
+		if err != nil {
+			return nil, fmt.Errorf("get tables according to sourceTableRegex failed: %v", err)
+		}
+		for db, tables := range dbTables {
+			allDbTables[db] = append(allDbTables[db], tables...)
+		}
+	}
+	if s.cfg.SourceDB != "" && s.cfg.SourceTable != "" {
+		allDbTables[s.cfg.SourceDB] = append(allDbTables[s.cfg.SourceDB], s.cfg.SourceTable)
+	}
+	return allDbTables, nil
+}
+
+func (s *SnowflakeSource) tableName() string {
+	return fmt.Sprintf("%s.%s.%s", s.cfg.SourceDB, s.cfg.SnowflakeSchema, s.cfg.SourceTable)
+}
+
+// query runs the statement and returns all partitions of its result set,
+// converted with snowflakeValue.
+func (s *SnowflakeSource) query(ctx context.Context, statement string) ([]string, [][]interface{}, error) {
+	body := map[string]interface{}{
+		"statement": statement,
+		"timeout":   3600,
+		"database":  s.cfg.SourceDB,
+		"schema":    s.cfg.SnowflakeSchema,
+	}
+	if s.cfg.SnowflakeWarehouse != "" {
+		body["warehouse"] = s.cfg.SnowflakeWarehouse
+	}
+	if s.cfg.SnowflakeRole != "" {
+		body["role"] = s.cfg.SnowflakeRole
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	var resp snowflakeResponse
+	status, err := s.do(ctx, http.MethodPost, "/api/v2/statements", data, &resp)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 202 means the statement is still running
+	handle := resp.StatementHandle
+	for status == http.StatusAccepted {
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+		resp = snowflakeResponse{}
+		status, err = s.do(ctx, http.MethodGet, "/api/v2/statements/"+handle, nil, &resp)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	columns := make([]string, len(resp.ResultSetMetaData.RowType))
+	for i, column := range resp.ResultSetMetaData.RowType {
+		columns[i] = snowflakeColumnName(column.Name)
+	}
+	rows := make([][]interface{}, 0, len(resp.Data))
+	appendRows := func(data [][]*string) error {
+		for _, values := range data {
+			row := make([]interface{}, len(values))
+			for i, value := range values {
+				v, err := snowflakeValue(resp.ResultSetMetaData.RowType[i], value)
+				if err != nil {
+					return errors.Wrapf(err, "convert column %s", columns[i])
+				}
+				row[i] = v
+			}
+			rows = append(rows, row)
+		}
+		return nil
+	}
+	if err := appendRows(resp.Data); err != nil {
+		return nil, nil, err
+	}
+	for partition := 1; partition < len(resp.ResultSetMetaData.PartitionInfo); partition++ {
+		var page snowflakeResponse
+		path := fmt.Sprintf("/api/v2/statements/%s?partition=%d", handle, partition)
+		if _, err := s.do(ctx, http.MethodGet, path, nil, &page); err != nil {
+			return nil, nil, err
+		}
+		if err := appendRows(page.Data); err != nil {
+			return nil, nil, err
+		}
+	}
+	return columns, rows, nil
+}
+
+// do sends the request, retrying network errors, 429 and 5xx responses, and
+// returns the status code.
+func (s *SnowflakeSource) do(ctx context.Context, method, path string, body []byte, out *snowflakeResponse) (int, error) {
+	status := 0
+	err := retry.Do(
+		func() error {
+			var reader io.Reader
+			if body != nil {
+				reader = bytes.NewReader(body)
+			}
+			req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, reader)
+			if err != nil {
+				return retry.Unrecoverable(err)
+			}
+			if err := s.authorize(req); err != nil {
+				return retry.Unrecoverable(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", "application/json")
+			resp, err := s.client.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode >= 400 {
+				msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+				err := errors.Errorf("snowflake %s %s failed, status code: %d, body: %s", method, path, resp.StatusCode, string(msg))
+				if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+					return err
+				}
+				return retry.Unrecoverable(err)
+			}
+			status = resp.StatusCode
+			return json.NewDecoder(resp.Body).Decode(out)
+		},
+		retry.Context(ctx),
+		retry.Attempts(5),
+		retry.Delay(time.Second),
+		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
+	)
+	return status, err
+}
+
+func (s *SnowflakeSource) authorize(req *http.Request) error {
+	if s.privateKey == nil {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.SnowflakeToken)
+		req.Header.Set("X-Snowflake-Authorization-Token-Type", "OAUTH")
+		return nil
+	}
+	token, err := s.keyPairJWT()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Snowflake-Authorization-Token-Type", "KEYPAIR_JWT")
+	return nil
+}
+
+// keyPairJWT returns a JWT signed with the private key, renewed shortly
+// before the one hour Snowflake accepts expires.
+func (s *SnowflakeSource) keyPairJWT() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.jwt != "" && time.Now().Before(s.jwtExpire) {
+		return s.jwt, nil
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&s.privateKey.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	fingerprint := sha256.Sum256(publicKey)
+	account := strings.ToUpper(strings.SplitN(s.cfg.SnowflakeAccount, ".", 2)[0])
+	qualifiedUser := account + "." + strings.ToUpper(s.cfg.SourceUser)
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss": qualifiedUser + ".SHA256:" + base64.StdEncoding.EncodeToString(fingerprint[:]),
+		"sub": qualifiedUser,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	})
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	s.jwt = signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+	s.jwtExpire = now.Add(55 * time.Minute)
+	return s.jwt, nil
+}
+
+func loadRSAPrivateKey(file string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", file)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse private key failed, encrypted keys are not supported")
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key in %s is not an RSA key", file)
+	}
+	return rsaKey, nil
+}
+
+func matchShowResult(columns []string, rows [][]interface{}, pattern string) ([]string, error) {
+	nameIndex := -1
+	for i, column := range columns {
+		if column == "name" {
+			nameIndex = i
+		}
+	}
+	if nameIndex < 0 {
+		return nil, errors.New("no name column in SHOW result")
+	}
+	var names []string
+	for _, row := range rows {
+		name := fmt.Sprint(row[nameIndex])
+		match, err := regexp.MatchString(pattern, name)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// snowflakeColumnName lower-cases unquoted (all upper case) identifiers, so
+// they match the lower-cased columns of Databend.
+func snowflakeColumnName(name string) string {
+	if strings.ToUpper(name) == name {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// snowflakeValue converts a value of the REST API, which serializes every
+// type as a string, into what Databend expects:
+//   - NUMBER keeps its exact digits, FLOAT becomes a float
+//   - DATE and TIME are formatted, they are sent as days and seconds
+//   - TIMESTAMP_TZ ("<epoch> <offset+1440>") keeps its offset
+//   - VARIANT, OBJECT and ARRAY are passed as JSON to land in VARIANT columns
+func snowflakeValue(column snowflakeColumn, value *string) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	v := *value
+	switch column.Type {
+	case "fixed":
+		if column.Scale == 0 {
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return i, nil
+			}
+		}
+		return json.Number(v), nil
+	case "real":
+		return strconv.ParseFloat(v, 64)
+	case "boolean":
+		return strconv.ParseBool(v)
+	case "date":
+		days, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return time.Unix(days*86400, 0).UTC().Format("2006-01-02"), nil
+	case "time":
+		t, err := parseEpoch(v)
+		if err != nil {
+			return nil, err
+		}
+		return t.UTC().Format("15:04:05.999999999"), nil
+	case "timestamp_ntz", "timestamp_ltz":
+		t, err := parseEpoch(v)
+		if err != nil {
+			return nil, err
+		}
+		return t.UTC().Format("2006-01-02 15:04:05.999999999"), nil
+	case "timestamp_tz":
+		epoch, offset, ok := strings.Cut(v, " ")
+		t, err := parseEpoch(epoch)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			minutes, err := strconv.Atoi(offset)
+			if err != nil {
+				return nil, err
+			}
+			t = t.In(time.FixedZone("", (minutes-1440)*60))
+		}
+		return t.Format(time.RFC3339Nano), nil
+	case "variant", "object", "array":
+		if json.Valid([]byte(v)) {
+			return json.RawMessage(v), nil
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
+
+// parseEpoch parses "<seconds>.<fraction>" without losing nanoseconds.
+func parseEpoch(v string) (time.Time, error) {
+	secs, frac, _ := strings.Cut(v, ".")
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nsec int64
+	if frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		n, err := strconv.ParseInt(frac, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		nsec = n * int64(math.Pow10(9-len(frac)))
+		if strings.HasPrefix(secs, "-") {
+			nsec = -nsec
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
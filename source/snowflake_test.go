@@ -0,0 +1,70 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestSnowflakeQueryTableData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v2/statements":
+			var body map[string]interface{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, "/* bend-archiver job=ORDERS batch=(ID >= 1 and ID < 3) */ SELECT * FROM DB.PUBLIC.ORDERS WHERE (ID >= 1 and ID < 3)",
+				body["statement"])
+			fmt.Fprint(w, `{"statementHandle": "h1", "resultSetMetaData": {
+				"partitionInfo": [{"rowCount": 1}, {"rowCount": 1}],
+				"rowType": [{"name": "ID", "type": "fixed", "scale": 0}, {"name": "amount", "type": "fixed", "scale": 2},
+					{"name": "CREATED_AT", "type": "timestamp_tz"}, {"name": "ATTRS", "type": "variant"}]},
+				"data": [["1", "10.50", "1700000000.123000000 1500", "{\"a\": 1}"]]}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v2/statements/h1":
+			assert.Equal(t, "1", r.URL.Query().Get("partition"))
+			fmt.Fprint(w, `{"data": [["2", null, null, "[1,2]"]]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		SnowflakeAccount: "myorg-myaccount",
+		SnowflakeURL:     server.URL,
+		SnowflakeToken:   "token",
+		SourceDB:         "DB",
+		SourceTable:      "ORDERS",
+	}
+	src, err := NewSnowflakeSource(cfg)
+	assert.NoError(t, err)
+	rows, columns, err := src.QueryTableData(context.Background(), 1, "(ID >= 1 and ID < 3)")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id", "amount", "created_at", "attrs"}, columns)
+	assert.Equal(t, [][]interface{}{
+		{int64(1), json.Number("10.50"), "2023-11-14T23:13:20.123+01:00", json.RawMessage(`{"a": 1}`)},
+		{int64(2), nil, nil, json.RawMessage(`[1,2]`)},
+	}, rows)
+}
+
+func TestSnowflakeValue(t *testing.T) {
+	value := func(s string) *string { return &s }
+	v, err := snowflakeValue(snowflakeColumn{Type: "date"}, value("19723"))
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-01-01", v)
+
+	v, err = snowflakeValue(snowflakeColumn{Type: "timestamp_ntz"}, value("1704067200.500000000"))
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-01-01 00:00:00.5", v)
+
+	v, err = snowflakeValue(snowflakeColumn{Type: "fixed", Scale: 0}, value("123456789012345678901234567890"))
+	assert.NoError(t, err)
+	assert.Equal(t, json.Number("123456789012345678901234567890"), v)
+}
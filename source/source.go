@@ -1,31 +1,92 @@
 package source
 
 import (
-	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	_ "github.com/denisenkom/go-mssqldb"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
 	"github.com/databendcloud/bend-archiver/config"
 )
 
+// Sourcer is a source of a job. Every source cleans up what the job archived,
+// what else it can do is told by the interfaces it implements: sources of
+// tables are a TableSourcer, sources of files a FileSourcer, and either may
+// implement the optional interfaces below.
 type Sourcer interface {
-	AdjustBatchSizeAccordingToSourceDbTable() uint64
-	GetSourceReadRowsCount() (int, error)
-	GetMinMaxSplitKey() (uint64, uint64, error)
-	GetMinMaxTimeSplitKey() (string, string, error)
-	DeleteAfterSync() error
-	QueryTableData(threadNum int, conditionSql string) ([][]interface{}, []string, error)
-	GetDatabasesAccordingToSourceDbRegex(sourceDatabasePattern string) ([]string, error)
-	GetTablesAccordingToSourceTableRegex(sourceTablePattern string, databases []string) (map[string][]string, error)
-	GetAllSourceReadRowsCount() (int, error)
-	GetDbTablesAccordingToSourceDbTables() (map[string][]string, error)
+	SourceCleaner
+}
+
+// SourcePlanner is implemented by sources of tables, which the job finds,
+// counts and splits into ranges before reading them.
+type SourcePlanner interface {
+	AdjustBatchSizeAccordingToSourceDbTable(ctx context.Context) uint64
+	GetSourceReadRowsCount(ctx context.Context) (int, error)
+	GetMinMaxSplitKey(ctx context.Context) (uint64, uint64, error)
+	GetMinMaxTimeSplitKey(ctx context.Context) (string, string, error)
+	GetDatabasesAccordingToSourceDbRegex(ctx context.Context, sourceDatabasePattern string) ([]string, error)
+	GetTablesAccordingToSourceTableRegex(ctx context.Context, sourceTablePattern string, databases []string) (map[string][]string, error)
+	GetAllSourceReadRowsCount(ctx context.Context) (int, error)
+	GetDbTablesAccordingToSourceDbTables(ctx context.Context) (map[string][]string, error)
+}
+
+// SourceReader is implemented by sources that read the rows of a table
+// matching a condition, one range planned by the job.
+type SourceReader interface {
+	QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error)
+}
+
+// SourceCleaner is implemented by sources that delete what the job archived
+// once it is verified in the target, with deleteAfterSync.
+type SourceCleaner interface {
+	DeleteAfterSync(ctx context.Context) error
+}
+
+// TableSourcer is implemented by sources of tables, planned, read and
+// cleaned up by the job.
+type TableSourcer interface {
+	SourcePlanner
+	SourceReader
+	SourceCleaner
+}
+
+// SliceSourcer is implemented by sources that split a table into a number of
+// slices read in parallel, instead of ranges of a split key.
+type SliceSourcer interface {
+	ReadSlice(ctx context.Context, slice, slices int, fn func(columns []string, rows [][]interface{}) error) error
+}
+
+// SnapshotReader is implemented by the SQL sources that read a table, a view
+// or sourceSelect without a split key, with sourceSnapshot: in one query,
+// whose rows are consistent with each other, cut into batches.
+type SnapshotReader interface {
+	ReadSnapshot(ctx context.Context, fn func(columns []string, rows [][]interface{}) error) error
+}
+
+// RowWidther is implemented by sources that know the average row size of a
+// table from their statistics, used with row counts to size the tables of a
+// job.
+type RowWidther interface {
+	GetAvgRowWidth(ctx context.Context) (int, error)
+}
+
+// PrivilegeChecker is implemented by sources that can tell whether their
+// user has a privilege, like SELECT or DELETE, on the source table, or on the
+// directory of a file source.
+type PrivilegeChecker interface {
+	HasTablePrivilege(ctx context.Context, privilege string) (bool, error)
 }
 
 func NewSource(cfg *config.Config) (Sourcer, error) {
@@ -40,11 +101,49 @@ func NewSource(cfg *config.Config) (Sourcer, error) {
 		return NewOracleSource(cfg)
 	case "mssql":
 		return NewSqlServerSource(cfg)
+	case "file":
+		return NewLocalFileSource(cfg)
+	case "sftp":
+		return NewSFTPSource(cfg)
+	case "ftp":
+		return NewFTPSource(cfg)
+	case "http":
+		return NewHTTPSource(cfg)
+	case "gsheets":
+		return NewGoogleSheetsSource(cfg)
+	case "elasticsearch":
+		return NewElasticsearchSource(cfg)
+	case "cassandra", "scylladb":
+		return NewCassandraSource(cfg)
+	case "influxdb":
+		return NewInfluxDBSource(cfg)
+	case "bench":
+		return NewBenchSource(cfg)
+	case "stdin":
+		return NewStdinSource(cfg)
+	case "snowflake":
+		return NewSnowflakeSource(cfg)
+	case "hive":
+		return NewHiveSource(cfg)
 	default:
 		return NewMysqlSource(cfg)
 	}
 }
 
+// NewTableSource returns the source of the tables of cfg, sources of files
+// have none.
+func NewTableSource(cfg *config.Config) (TableSourcer, error) {
+	src, err := NewSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ts, ok := src.(TableSourcer)
+	if !ok {
+		return nil, fmt.Errorf("%s sources have no tables", cfg.DatabaseType)
+	}
+	return ts, nil
+}
+
 func SlimCondition(maxThread int, minSplitKey, maxSplitKey uint64) [][]uint64 {
 	var conditions [][]uint64
 	if minSplitKey > maxSplitKey {
@@ -66,7 +165,9 @@ func SlimCondition(maxThread int, minSplitKey, maxSplitKey uint64) [][]uint64 {
 func SplitCondition(sourceSplitKey string, batchSize, minSplitKey, maxSplitKey uint64) []string {
 	var conditions []string
 	for {
-		if minSplitKey >= maxSplitKey {
+		// unsigned BIGINT keys may be close enough to the max uint64 that the
+		// next bound overflows
+		if minSplitKey >= maxSplitKey || minSplitKey+batchSize < minSplitKey {
 			conditions = append(conditions, fmt.Sprintf("(%s >= %d and %s <= %d)", sourceSplitKey, minSplitKey, sourceSplitKey, maxSplitKey))
 			break
 		}
@@ -87,7 +188,8 @@ func SplitConditionAccordingMaxGoRoutine(sourceSplitKey string, batchSize, minSp
 		}
 
 		for {
-			if (minSplitKey + batchSize - 1) >= maxSplitKey {
+			// compare distances, minSplitKey + batchSize may overflow
+			if batchSize-1 >= maxSplitKey-minSplitKey {
 				if minSplitKey > allMax {
 					return
 				}
@@ -98,7 +200,7 @@ func SplitConditionAccordingMaxGoRoutine(sourceSplitKey string, batchSize, minSp
 				}
 				break
 			}
-			if (minSplitKey + batchSize - 1) >= allMax {
+			if minSplitKey >= allMax || batchSize-1 >= allMax-minSplitKey {
 				conditions <- fmt.Sprintf("(%s >= %d and %s <= %d)", sourceSplitKey, minSplitKey, sourceSplitKey, allMax)
 				return
 			}
@@ -161,58 +263,130 @@ func SplitConditionAccordingToTimeSplitKey(cfg *config.Config, minTimeSplitKey,
 	return conditions, nil
 }
 
-func GenerateJSONFile(columns []string, data [][]interface{}) (string, int, error) {
+// orderBySplitKey returns the ORDER BY clause that makes the rows of a split
+// key range come in the same order on every run, when deterministicOrder is
+// set.
+func orderBySplitKey(cfg *config.Config) string {
+	if !cfg.DeterministicOrder || cfg.SourceSplitKey == "" {
+		return ""
+	}
+	return " ORDER BY " + cfg.SourceSplitKey
+}
+
+// tagSQL prefixes statement with a comment naming the job and the batch it
+// reads, so DBAs can find (and kill) the archiver queries in the process list
+// of the source, e.g. /* bend-archiver job=orders batch=(id >= 1 and id < 1001) */.
+func tagSQL(cfg *config.Config, batch, statement string) string {
+	job := cfg.JobName
+	if job == "" {
+		job = cfg.DatabendTable
+	}
+	if job == "" {
+		job = cfg.SourceTable
+	}
+	comment := fmt.Sprintf("bend-archiver job=%s batch=%s", job, batch)
+	// neither end the comment early nor span several lines of the process list
+	comment = strings.NewReplacer("*/", "* /", "\n", " ", "\r", " ").Replace(comment)
+	return "/* " + comment + " */ " + statement
+}
+
+// GenerateJSONFile writes the rows of a batch as an NDJSON file in the temp
+// dir of cfg.
+func GenerateJSONFile(cfg *config.Config, columns []string, data [][]interface{}) (string, int, error) {
 	l := logrus.WithFields(logrus.Fields{"tardatabend": "IngestData"})
-	var batchJsonData []string
+	buf := ndjsonBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		// a batch of huge rows should not stay in memory for the rest of the job
+		if buf.Cap() <= maxPooledBuffer {
+			ndjsonBufferPool.Put(buf)
+		}
+	}()
+	invalidUTF8, err := EncodeNDJSON(cfg, buf, columns, data)
+	if err != nil {
+		return "", 0, err
+	}
+	if invalidUTF8 > 0 {
+		l.Warnf("%d values are not valid UTF-8, invalid bytes are staged as U+FFFD", invalidUTF8)
+	}
 
+	fileName, err := writeNDJsonFile(cfg, buf.Bytes())
+	if err != nil {
+		l.Errorf("generate NDJson file failed: %v\n", err)
+		return "", 0, err
+	}
+	return fileName, buf.Len(), nil
+}
+
+// EncodeNDJSON writes the rows of data to w as NDJSON, one object per line
+// the way they are staged. It returns the number of values that are not
+// valid UTF-8.
+func EncodeNDJSON(cfg *config.Config, w io.Writer, columns []string, data [][]interface{}) (int, error) {
+	encoder := json.NewEncoder(w)
+	// encoding/json escapes newlines, quotes and NUL bytes, but silently
+	// replaces invalid UTF-8 with U+FFFD
+	invalidUTF8 := 0
+	formatFloats := formatsFloats(cfg)
+	rowMap := make(map[string]interface{}, len(columns))
 	for _, row := range data {
 		if len(row) == 0 {
 			continue
 		}
-		rowMap := make(map[string]interface{})
 		for i, column := range columns {
 			rowMap[column] = row[i]
+			if formatFloats {
+				rowMap[column] = FormatFloat(cfg, row[i])
+			}
+			if s, ok := row[i].(string); ok && !utf8.ValidString(s) {
+				invalidUTF8++
+			}
 		}
-		jsonData, err := json.Marshal(rowMap)
-		if err != nil {
-			return "", 0, err
+		// one object per line, like json.Marshal and a newline
+		if err := encoder.Encode(rowMap); err != nil {
+			return invalidUTF8, err
 		}
-		batchJsonData = append(batchJsonData, string(jsonData))
-	}
-
-	fileName, bytesSize, err := generateNDJsonFile(batchJsonData)
-	if err != nil {
-		l.Errorf("generate NDJson file failed: %v\n", err)
-		return "", 0, err
 	}
-	return fileName, bytesSize, nil
+	return invalidUTF8, nil
 }
 
-func generateNDJsonFile(batchJsonData []string) (string, int, error) {
+// ndjsonBufferPool holds the buffers batches are serialized into, so long
+// jobs reuse them instead of allocating a batch worth of bytes per batch.
+var ndjsonBufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+const maxPooledBuffer = 64 << 20
+
+func writeNDJsonFile(cfg *config.Config, data []byte) (string, error) {
+	dir := cfg.TempDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if err := checkFreeSpace(dir, int64(len(data)), cfg.TempMinFreeMB<<20); err != nil {
+		return "", err
+	}
 	fileName := fmt.Sprintf("databend-ingest-%d-", time.Now().UnixNano())
-	outputFile, err := os.CreateTemp("/tmp", fileName+"*.ndjson")
+	outputFile, err := os.CreateTemp(dir, fileName+"*.ndjson")
 	if err != nil {
-		return "", 0, err
+		return "", err
 	}
 	defer outputFile.Close()
-
-	// Create a buffered writer for the Ndjson file
-	writer := bufio.NewWriter(outputFile)
-	bytesSum := 0
-
-	for _, data := range batchJsonData {
-		n, err := writer.WriteString(data + "\n")
-		if err != nil {
-			return "", 0, err
-		}
-		bytesSum += n
+	if _, err := outputFile.Write(data); err != nil {
+		return "", err
 	}
-	// Flush any remaining data to the NDJson file
-	err = writer.Flush()
+	return outputFile.Name(), nil
+}
+
+// checkFreeSpace fails when writing size bytes would leave less than
+// minFree bytes in dir, instead of letting a small tmpfs fill up.
+func checkFreeSpace(dir string, size, minFree int64) error {
+	free, err := freeBytes(dir)
 	if err != nil {
-		return "", 0, err
+		return errors.Wrapf(err, "check free space of %s failed", dir)
+	}
+	if free >= 0 && free-size < minFree {
+		return fmt.Errorf("not enough space in %s for a %d MB batch file: %d MB free, %d MB must stay free; set tempDir to a larger disk or lower batchSize",
+			dir, size>>20, free>>20, minFree>>20)
 	}
-	return outputFile.Name(), bytesSum, err
+	return nil
 }
 
 func parseTimeDynamic(timeStr string) (time.Time, error) {
@@ -1,9 +1,13 @@
 package source
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"os"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/test-go/testify/assert"
@@ -187,3 +191,174 @@ func TestMatchDatabase(t *testing.T) {
 	}
 	assert.Equal(t, targetDbs, res)
 }
+
+func TestSplitConditionNearMaxUint64(t *testing.T) {
+	maxKey := uint64(math.MaxUint64)
+	conditions := SplitCondition("id", 10, maxKey-15, maxKey)
+	assert.Equal(t, []string{
+		fmt.Sprintf("(id >= %d and id < %d)", maxKey-15, maxKey-5),
+		fmt.Sprintf("(id >= %d and id <= %d)", maxKey-5, maxKey),
+	}, conditions)
+
+	var got []string
+	for condition := range SplitConditionAccordingMaxGoRoutine("id", 10, maxKey-15, maxKey, maxKey) {
+		got = append(got, condition)
+	}
+	assert.Equal(t, []string{
+		fmt.Sprintf("(id >= %d and id < %d)", maxKey-15, maxKey-6),
+		fmt.Sprintf("(id >= %d and id <= %d)", maxKey-6, maxKey),
+	}, got)
+}
+
+func TestGenerateJSONFileEscaping(t *testing.T) {
+	values := []interface{}{"line1\nline2\r\n", `a "quoted", comma`, "tab\tnul\x00end", "bad\xffutf8"}
+	fileName, _, err := GenerateJSONFile(&config.Config{}, []string{"a", "b", "c", "d"}, [][]interface{}{values, {1, 2, 3, 4}})
+	assert.NoError(t, err)
+	defer os.Remove(fileName)
+
+	data, err := os.ReadFile(fileName)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	assert.Equal(t, 2, len(lines))
+
+	var row map[string]string
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &row))
+	assert.Equal(t, values[0], row["a"])
+	assert.Equal(t, values[1], row["b"])
+	assert.Equal(t, values[2], row["c"])
+	assert.Equal(t, "bad�utf8", row["d"])
+}
+
+func TestGenerateJSONFileReusesBuffers(t *testing.T) {
+	columns := []string{"id", "note"}
+	for _, batch := range [][][]interface{}{
+		{{int64(1), "<first & long batch>"}, {int64(2), "x"}, {int64(3), "y"}},
+		{{int64(4), "z"}},
+	} {
+		fileName, size, err := GenerateJSONFile(&config.Config{TempDir: t.TempDir()}, columns, batch)
+		assert.NoError(t, err)
+		data, err := os.ReadFile(fileName)
+		os.Remove(fileName)
+		assert.NoError(t, err)
+
+		// same bytes as one json.Marshal per row
+		var expected []byte
+		for _, row := range batch {
+			line, err := json.Marshal(map[string]interface{}{"id": row[0], "note": row[1]})
+			assert.NoError(t, err)
+			expected = append(append(expected, line...), '\n')
+		}
+		assert.Equal(t, string(expected), string(data))
+		assert.Equal(t, len(expected), size)
+	}
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	free, err := freeBytes(dir)
+	assert.NoError(t, err)
+	if free < 0 {
+		t.Skip("free space is unknown on this platform")
+	}
+	assert.NoError(t, checkFreeSpace(dir, 1<<20, 0))
+	err = checkFreeSpace(dir, 1<<20, free)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not enough space in "+dir)
+
+	_, _, err = GenerateJSONFile(&config.Config{TempDir: dir, TempMinFreeMB: free>>20 + 1}, []string{"id"}, [][]interface{}{{1}})
+	assert.Error(t, err)
+	entries, _ := os.ReadDir(dir)
+	assert.Equal(t, 0, len(entries))
+}
+
+func TestOrderBySplitKey(t *testing.T) {
+	cfg := &config.Config{SourceSplitKey: "id"}
+	assert.Equal(t, "", orderBySplitKey(cfg))
+	cfg.DeterministicOrder = true
+	assert.Equal(t, " ORDER BY id", orderBySplitKey(cfg))
+	cfg.SourceSplitKey = ""
+	assert.Equal(t, "", orderBySplitKey(cfg))
+}
+
+func TestTagSQL(t *testing.T) {
+	cfg := &config.Config{SourceTable: "orders", DatabendTable: "archive.orders"}
+	assert.Equal(t, "/* bend-archiver job=archive.orders batch=count */ SELECT count(*) FROM orders",
+		tagSQL(cfg, "count", "SELECT count(*) FROM orders"))
+	cfg.JobName = "orders-2024"
+	assert.Equal(t, "/* bend-archiver job=orders-2024 batch=(note = '* /  ') */ SELECT 1",
+		tagSQL(cfg, "(note = '*/\r\n')", "SELECT 1"))
+}
+
+func TestKeysetPage(t *testing.T) {
+	cfg := &config.Config{SourceTable: "orders", SourceSplitKey: "id", SourceWhereCondition: "status = 'done'"}
+	condition := "(created_at >= '2024-01-01 00:00:00' and created_at < '2024-01-02 00:00:00')"
+	page := KeysetPage(cfg, condition, "", 100)
+	assert.Equal(t, condition+" ORDER BY id LIMIT 100", page)
+	assert.Equal(t, "SELECT * FROM db.orders WHERE "+condition+" AND status = 'done' ORDER BY id LIMIT 100",
+		selectBatchSQL(cfg, "db.orders", page))
+
+	last, err := LastKey(cfg, []string{"ID", "note"}, [][]interface{}{{int64(7), "a"}, {int64(9), "b"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "9", last)
+	assert.Equal(t, condition+" AND id > 9 ORDER BY id LIMIT 100", KeysetPage(cfg, condition, last, 100))
+
+	cfg.DatabaseType = "oracle"
+	assert.Equal(t, condition+" AND id > 'o''1' ORDER BY id FETCH FIRST 100 ROWS ONLY",
+		KeysetPage(cfg, condition, "'o''1'", 100))
+
+	last, err = LastKey(cfg, []string{"id"}, [][]interface{}{{"o'1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "'o''1'", last)
+	_, err = LastKey(cfg, []string{"note"}, [][]interface{}{{"a"}})
+	assert.Error(t, err)
+}
+
+func TestSourceRelation(t *testing.T) {
+	cfg := &config.Config{SourceSplitKey: "id", SourceWhereCondition: "1=1"}
+	assert.Equal(t, "db.orders", sourceRelation(cfg, "db.orders"))
+
+	cfg.SourceSelect = " SELECT o.id, o.total, c.name FROM orders o JOIN customers c ON c.id = o.customer_id; "
+	assert.Equal(t, "SELECT * FROM (SELECT o.id, o.total, c.name FROM orders o JOIN customers c ON c.id = o.customer_id) source_select"+
+		" WHERE (id >= 1 and id < 100) AND 1=1",
+		selectBatchSQL(cfg, sourceRelation(cfg, "db.orders"), "(id >= 1 and id < 100)"))
+}
+
+func TestMysqlDeleteSQL(t *testing.T) {
+	cfg := &config.Config{SourceWhereCondition: "created_at < '2024-01-01' OR archived = 1"}
+	assert.Equal(t, "DELETE FROM shop.orders WHERE (created_at < '2024-01-01' OR archived = 1) AND id BETWEEN 1 AND 1000",
+		mysqlDeleteSQL(cfg, "shop.orders", "id BETWEEN 1 AND 1000", 0))
+	cfg.PurgeLowPriority = true
+	assert.Equal(t, "DELETE LOW_PRIORITY FROM shop.orders WHERE (created_at < '2024-01-01' OR archived = 1) LIMIT 500",
+		mysqlDeleteSQL(cfg, "shop.orders", "", 500))
+}
+
+func TestMaintenanceSQL(t *testing.T) {
+	query, err := maintenanceSQL("mysql", "optimize", "shop.orders")
+	assert.NoError(t, err)
+	assert.Equal(t, "OPTIMIZE TABLE shop.orders", query)
+	query, err = maintenanceSQL("pg", "optimize", "orders")
+	assert.NoError(t, err)
+	assert.Equal(t, "VACUUM FULL orders", query)
+	query, err = maintenanceSQL("mssql", "analyze", "[shop].[dbo].[orders]")
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE STATISTICS [shop].[dbo].[orders]", query)
+	_, err = maintenanceSQL("tidb", "optimize", "shop.orders")
+	assert.Error(t, err)
+}
+
+func TestNewTableSource(t *testing.T) {
+	src, err := NewTableSource(&config.Config{DatabaseType: "bench", BenchRows: 10})
+	assert.NoError(t, err)
+	_, ok := src.(SliceSourcer)
+	assert.True(t, ok)
+
+	cfg := &config.Config{DatabaseType: "file", SourcePath: t.TempDir()}
+	file, err := NewSource(cfg)
+	assert.NoError(t, err)
+	_, ok = file.(SourcePlanner)
+	assert.False(t, ok)
+	_, ok = file.(FileSourcer)
+	assert.True(t, ok)
+	_, err = NewTableSource(cfg)
+	assert.Error(t, err)
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/internal/sqllog"
 )
 
 type SQLServerSource struct {
@@ -24,7 +25,7 @@ type SQLServerSource struct {
 func NewSqlServerSource(cfg *config.Config) (*SQLServerSource, error) {
 	stats := NewDatabendIntesterStatsRecorder()
 	encodedPassword := url.QueryEscape(cfg.SourcePass)
-	db, err := sql.Open("mssql", fmt.Sprintf("sqlserver://%s:%s@%s:%d?database=%s&encrypt=disable",
+	db, err := sqllog.Open("mssql", fmt.Sprintf("sqlserver://%s:%s@%s:%d?database=%s&encrypt=disable",
 		cfg.SourceUser,
 		encodedPassword,
 		cfg.SourceHost,
@@ -40,7 +41,7 @@ func NewSqlServerSource(cfg *config.Config) (*SQLServerSource, error) {
 	}, nil
 }
 
-func (s *SQLServerSource) GetSourceReadRowsCount() (int, error) {
+func (s *SQLServerSource) GetSourceReadRowsCount(ctx context.Context) (int, error) {
 	// SQL Server table name contains schema，格式为 schema.table
 	tableName := s.cfg.SourceTable
 	if !strings.Contains(tableName, ".") {
@@ -53,7 +54,7 @@ func (s *SQLServerSource) GetSourceReadRowsCount() (int, error) {
 		query += " WHERE " + s.cfg.SourceWhereCondition
 	}
 
-	row := s.db.QueryRow(query)
+	row := s.db.QueryRowContext(ctx, tagSQL(s.cfg, "count", query))
 	var rowCount int
 	err := row.Scan(&rowCount)
 	if err != nil {
@@ -63,7 +64,7 @@ func (s *SQLServerSource) GetSourceReadRowsCount() (int, error) {
 	return rowCount, nil
 }
 
-func (s *SQLServerSource) GetMinMaxSplitKey() (uint64, uint64, error) {
+func (s *SQLServerSource) GetMinMaxSplitKey(ctx context.Context) (uint64, uint64, error) {
 	tableName := s.cfg.SourceTable
 	if !strings.Contains(tableName, ".") {
 		tableName = "dbo." + tableName
@@ -79,7 +80,7 @@ func (s *SQLServerSource) GetMinMaxSplitKey() (uint64, uint64, error) {
 		query += " WHERE " + s.cfg.SourceWhereCondition
 	}
 
-	rows, err := s.db.Query(query)
+	rows, err := s.db.QueryContext(ctx, tagSQL(s.cfg, "min-max", query))
 	if err != nil {
 		return 0, 0, err
 	}
@@ -114,12 +115,12 @@ func (s *SQLServerSource) GetMinMaxSplitKey() (uint64, uint64, error) {
 	return min64, max64, nil
 }
 
-func (s *SQLServerSource) AdjustBatchSizeAccordingToSourceDbTable() uint64 {
-	minSplitKey, maxSplitKey, err := s.GetMinMaxSplitKey()
+func (s *SQLServerSource) AdjustBatchSizeAccordingToSourceDbTable(ctx context.Context) uint64 {
+	minSplitKey, maxSplitKey, err := s.GetMinMaxSplitKey(ctx)
 	if err != nil {
 		return uint64(s.cfg.BatchSize)
 	}
-	sourceTableRowCount, err := s.GetSourceReadRowsCount()
+	sourceTableRowCount, err := s.GetSourceReadRowsCount(ctx)
 	if err != nil {
 		return uint64(s.cfg.BatchSize)
 	}
@@ -136,7 +137,7 @@ func (s *SQLServerSource) AdjustBatchSizeAccordingToSourceDbTable() uint64 {
 	}
 }
 
-func (s *SQLServerSource) GetMinMaxTimeSplitKey() (string, string, error) {
+func (s *SQLServerSource) GetMinMaxTimeSplitKey(ctx context.Context) (string, string, error) {
 	parts := strings.Split(s.cfg.SourceTable, ".")
 	var tableName string
 	if len(parts) == 2 {
@@ -159,7 +160,7 @@ func (s *SQLServerSource) GetMinMaxTimeSplitKey() (string, string, error) {
 		query += " WHERE " + s.cfg.SourceWhereCondition
 	}
 
-	rows, err := s.db.Query(query)
+	rows, err := s.db.QueryContext(ctx, tagSQL(s.cfg, "min-max", query))
 	if err != nil {
 		return "", "", fmt.Errorf("executing query: %w", err)
 	}
@@ -186,7 +187,21 @@ func (s *SQLServerSource) GetMinMaxTimeSplitKey() (string, string, error) {
 	return minSplitKey.String, maxSplitKey.String, nil
 }
 
-func (s *SQLServerSource) DeleteAfterSync() error {
+func (s *SQLServerSource) HasTablePrivilege(ctx context.Context, privilege string) (bool, error) {
+	tableName := s.cfg.SourceTable
+	if !strings.Contains(tableName, ".") {
+		tableName = "dbo." + tableName
+	}
+	var granted sql.NullInt64
+	query := fmt.Sprintf("SELECT HAS_PERMS_BY_NAME('%s', 'OBJECT', '%s')",
+		strings.ReplaceAll(tableName, "'", "''"), strings.ReplaceAll(privilege, "'", "''"))
+	if err := s.db.QueryRowContext(ctx, query).Scan(&granted); err != nil {
+		return false, err
+	}
+	return granted.Int64 == 1, nil
+}
+
+func (s *SQLServerSource) DeleteAfterSync(ctx context.Context) error {
 	if !s.cfg.DeleteAfterSync {
 		return nil
 	}
@@ -207,7 +222,7 @@ func (s *SQLServerSource) DeleteAfterSync() error {
 		query += " WHERE " + s.cfg.SourceWhereCondition
 	}
 
-	_, err := s.db.Exec(query)
+	_, err := s.db.ExecContext(ctx, tagSQL(s.cfg, "delete", query))
 	if err != nil {
 		return fmt.Errorf("executing delete query: %w", err)
 	}
@@ -215,7 +230,26 @@ func (s *SQLServerSource) DeleteAfterSync() error {
 	return nil
 }
 
-func (s *SQLServerSource) QueryTableData(threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+// Maintain runs operation on the source table.
+func (s *SQLServerSource) Maintain(ctx context.Context, operation string) error {
+	parts := strings.Split(s.cfg.SourceTable, ".")
+	tableName := fmt.Sprintf("[%s].[dbo].[%s]", s.cfg.SourceDB, s.cfg.SourceTable)
+	if len(parts) == 2 {
+		tableName = fmt.Sprintf("[%s].[%s].[%s]", s.cfg.SourceDB, parts[0], parts[1])
+	}
+	query, err := maintenanceSQL(s.cfg.DatabaseType, operation, tableName)
+	if err != nil {
+		return err
+	}
+	startTime := time.Now()
+	if _, err := s.db.ExecContext(ctx, tagSQL(s.cfg, operation, query)); err != nil {
+		return fmt.Errorf("executing %s: %w", operation, err)
+	}
+	logrus.Infof("%s in %v", query, time.Since(startTime).Round(time.Second))
+	return nil
+}
+
+func (s *SQLServerSource) QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
 	startTime := time.Now()
 
 	parts := strings.Split(s.cfg.SourceTable, ".")
@@ -238,7 +272,7 @@ func (s *SQLServerSource) QueryTableData(threadNum int, conditionSql string) ([]
 		baseQuery = fmt.Sprintf("%s AND %s", baseQuery, s.cfg.SourceWhereCondition)
 	}
 
-	rows, err := s.db.Query(baseQuery)
+	rows, err := s.db.QueryContext(ctx, tagSQL(s.cfg, conditionSql, baseQuery))
 	if err != nil {
 		return nil, nil, fmt.Errorf("executing base query: %w", err)
 	}
@@ -257,33 +291,15 @@ func (s *SQLServerSource) QueryTableData(threadNum int, conditionSql string) ([]
 	rows.Close()
 
 	// scan value
-	scanArgs := make([]interface{}, len(columns))
-	for i, columnType := range columnTypes {
-		switch columnType.DatabaseTypeName() {
-		case "TINYINT", "SMALLINT", "INT", "BIGINT":
-			scanArgs[i] = new(sql.NullInt64)
-		case "REAL", "FLOAT":
-			scanArgs[i] = new(sql.NullFloat64)
-		case "DECIMAL", "NUMERIC", "MONEY", "SMALLMONEY":
-			scanArgs[i] = new(sql.NullFloat64)
-		case "CHAR", "VARCHAR", "TEXT", "NCHAR", "NVARCHAR", "NTEXT":
-			scanArgs[i] = new(sql.NullString)
-		case "DATE", "TIME", "DATETIME", "DATETIME2", "SMALLDATETIME", "DATETIMEOFFSET":
-			scanArgs[i] = new(sql.NullString)
-		case "BIT":
-			scanArgs[i] = new(sql.NullBool)
-		case "BINARY", "VARBINARY", "IMAGE":
-			scanArgs[i] = new(sql.RawBytes)
-		case "UNIQUEIDENTIFIER":
-			scanArgs[i] = new(sql.NullString)
-		default:
-			scanArgs[i] = new(sql.RawBytes)
-		}
-	}
+	scanner := newRowScanner("mssql", columnTypes)
+	applyInvalidDatePolicy(s.cfg, scanner, columns, columnTypes)
 
 	const batchSize = 10000
 	var result [][]interface{}
 	offset := 0
+	// with a split key, pages follow the last key read instead of skipping a
+	// growing OFFSET, which SQL Server has to read through on every page
+	lastKey := ""
 
 	for {
 		query := fmt.Sprintf(`
@@ -297,23 +313,31 @@ func (s *SQLServerSource) QueryTableData(threadNum int, conditionSql string) ([]
 		if s.cfg.SourceWhereCondition != "" && s.cfg.SourceSplitKey != "" {
 			query = fmt.Sprintf("%s AND %s", query, s.cfg.SourceWhereCondition)
 		}
+		if lastKey != "" {
+			query = fmt.Sprintf("%s AND %s > %s", query, s.cfg.SourceSplitKey, lastKey)
+		}
 
 		// page
+		order := "(SELECT NULL)"
+		if s.cfg.SourceSplitKey != "" {
+			order = s.cfg.SourceSplitKey
+		}
 		query = fmt.Sprintf(`
             SELECT *
             FROM (
                 %s
             ) AS t
-            ORDER BY (SELECT NULL)
+            ORDER BY %s
             OFFSET %d ROWS
             FETCH NEXT %d ROWS ONLY`,
 			query,
+			order,
 			offset,
 			batchSize)
 
 		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 		defer cancel()
-		rows, err := s.db.QueryContext(ctx, query)
+		rows, err := s.db.QueryContext(ctx, tagSQL(s.cfg, conditionSql, query))
 
 		if err != nil {
 			return nil, nil, fmt.Errorf("executing batch query at offset %d: %w", offset, err)
@@ -321,53 +345,11 @@ func (s *SQLServerSource) QueryTableData(threadNum int, conditionSql string) ([]
 
 		rowCount := 0
 		for rows.Next() {
-			err = rows.Scan(scanArgs...)
+			row, err := scanner.scan(rows)
 			if err != nil {
 				rows.Close()
 				return nil, nil, fmt.Errorf("scanning row at offset %d: %w", offset, err)
 			}
-
-			row := make([]interface{}, len(columns))
-			for i, v := range scanArgs {
-				switch v := v.(type) {
-				case *sql.NullString:
-					if v.Valid {
-						row[i] = v.String
-					} else {
-						row[i] = nil
-					}
-				case *sql.NullInt64:
-					if v.Valid {
-						row[i] = v.Int64
-					} else {
-						row[i] = nil
-					}
-				case *sql.NullFloat64:
-					if v.Valid {
-						row[i] = v.Float64
-					} else {
-						row[i] = nil
-					}
-				case *sql.NullBool:
-					if v.Valid {
-						if v.Bool {
-							row[i] = 1 // target databend bool is int8
-						} else {
-							row[i] = 0
-						}
-					} else {
-						row[i] = nil
-					}
-				case *sql.RawBytes:
-					if v != nil {
-						row[i] = string(*v)
-					} else {
-						row[i] = nil
-					}
-				default:
-					row[i] = v
-				}
-			}
 			result = append(result, row)
 			rowCount++
 		}
@@ -383,19 +365,26 @@ func (s *SQLServerSource) QueryTableData(threadNum int, conditionSql string) ([]
 			break
 		}
 
-		offset += batchSize
+		if s.cfg.SourceSplitKey != "" {
+			lastKey, err = LastKey(s.cfg, columns, result)
+			if err != nil {
+				return nil, nil, err
+			}
+		} else {
+			offset += batchSize
+		}
 
 		log.Printf("thread-%d: processed %d rows so far", threadNum, len(result))
 	}
 
-	s.statsRecorder.RecordMetric(len(result))
+	s.statsRecorder.RecordBatch(s.cfg, threadNum, result)
 	stats := s.statsRecorder.Stats(time.Since(startTime))
 	log.Printf("thread-%d: extract total %d rows (%f rows/s)", threadNum, len(result), stats.RowsPerSecondd)
 
 	return result, columns, nil
 }
 
-func (s *SQLServerSource) GetDatabasesAccordingToSourceDbRegex(sourceDatabasePattern string) ([]string, error) {
+func (s *SQLServerSource) GetDatabasesAccordingToSourceDbRegex(ctx context.Context, sourceDatabasePattern string) ([]string, error) {
 	// SQL Server use system view to get databases
 	query := `
         SELECT name 
@@ -405,7 +394,7 @@ func (s *SQLServerSource) GetDatabasesAccordingToSourceDbRegex(sourceDatabasePat
         AND HAS_DBACCESS(name) = 1
         ORDER BY name`
 
-	rows, err := s.db.Query(query)
+	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("querying databases: %w", err)
 	}
@@ -436,7 +425,7 @@ func (s *SQLServerSource) GetDatabasesAccordingToSourceDbRegex(sourceDatabasePat
 	return databases, nil
 }
 
-func (s *SQLServerSource) GetTablesAccordingToSourceTableRegex(sourceTablePattern string, databases []string) (map[string][]string, error) {
+func (s *SQLServerSource) GetTablesAccordingToSourceTableRegex(ctx context.Context, sourceTablePattern string, databases []string) (map[string][]string, error) {
 	dbTables := make(map[string][]string)
 
 	baseQuery := `
@@ -450,12 +439,12 @@ func (s *SQLServerSource) GetTablesAccordingToSourceTableRegex(sourceTablePatter
 
 	for _, database := range databases {
 		// switch db
-		_, err := s.db.Exec(fmt.Sprintf("USE [%s]", database))
+		_, err := s.db.ExecContext(ctx, fmt.Sprintf("USE [%s]", database))
 		if err != nil {
 			return nil, fmt.Errorf("switching to database %s: %w", database, err)
 		}
 
-		rows, err := s.db.Query(baseQuery)
+		rows, err := s.db.QueryContext(ctx, baseQuery)
 		if err != nil {
 			return nil, fmt.Errorf("querying tables in database %s: %w", database, err)
 		}
@@ -498,16 +487,16 @@ func (s *SQLServerSource) GetTablesAccordingToSourceTableRegex(sourceTablePatter
 	return dbTables, nil
 }
 
-func (s *SQLServerSource) GetAllSourceReadRowsCount() (int, error) {
+func (s *SQLServerSource) GetAllSourceReadRowsCount(ctx context.Context) (int, error) {
 	allCount := 0
 
-	dbTables, err := s.GetDbTablesAccordingToSourceDbTables()
+	dbTables, err := s.GetDbTablesAccordingToSourceDbTables(ctx)
 	if err != nil {
 		return 0, fmt.Errorf("getting database tables: %w", err)
 	}
 
 	for db, tables := range dbTables {
-		_, err := s.db.Exec(fmt.Sprintf("USE [%s]", db))
+		_, err := s.db.ExecContext(ctx, fmt.Sprintf("USE [%s]", db))
 		if err != nil {
 			return 0, fmt.Errorf("switching to database %s: %w", db, err)
 		}
@@ -521,7 +510,7 @@ func (s *SQLServerSource) GetAllSourceReadRowsCount() (int, error) {
 			}
 			s.cfg.SourceTable = table
 
-			count, err := s.GetSourceReadRowsCount()
+			count, err := s.GetSourceReadRowsCount(ctx)
 			if err != nil {
 				return 0, fmt.Errorf("getting row count for %s.%s: %w", db, table, err)
 			}
@@ -530,7 +519,7 @@ func (s *SQLServerSource) GetAllSourceReadRowsCount() (int, error) {
 	}
 
 	if allCount == 0 && len(dbTables) == 0 && s.cfg.SourceTable != "" {
-		count, err := s.GetSourceReadRowsCount()
+		count, err := s.GetSourceReadRowsCount(ctx)
 		if err != nil {
 			return 0, fmt.Errorf("getting row count for single table %s: %w", s.cfg.SourceTable, err)
 		}
@@ -540,7 +529,7 @@ func (s *SQLServerSource) GetAllSourceReadRowsCount() (int, error) {
 	return allCount, nil
 }
 
-func (s *SQLServerSource) GetDbTablesAccordingToSourceDbTables() (map[string][]string, error) {
+func (s *SQLServerSource) GetDbTablesAccordingToSourceDbTables(ctx context.Context) (map[string][]string, error) {
 	allDbTables := make(map[string][]string)
 
 	for _, sourceDbTable := range s.cfg.SourceDbTables {
@@ -549,7 +538,7 @@ func (s *SQLServerSource) GetDbTablesAccordingToSourceDbTables() (map[string][]s
 			return nil, fmt.Errorf("invalid sourceDbTable: %s, should be database@schema.table format", sourceDbTable)
 		}
 
-		dbs, err := s.GetDatabasesAccordingToSourceDbRegex(dbTable[0])
+		dbs, err := s.GetDatabasesAccordingToSourceDbRegex(ctx, dbTable[0])
 		if err != nil {
 			return nil, fmt.Errorf("get databases according to sourceDbRegex failed: %w", err)
 		}
@@ -560,7 +549,7 @@ func (s *SQLServerSource) GetDbTablesAccordingToSourceDbTables() (map[string][]s
 		}
 
 		// match table
-		dbTables, err := s.GetTablesAccordingToSourceTableRegex(dbTable[1], dbs)
+		dbTables, err := s.GetTablesAccordingToSourceTableRegex(ctx, dbTable[1], dbs)
 		if err != nil {
 			return nil, fmt.Errorf("get tables according to sourceTableRegex failed: %w", err)
 		}
@@ -0,0 +1,94 @@
+package source
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// SSHTunnel forwards a local port to the source database through a bastion
+// host.
+type SSHTunnel struct {
+	client   *ssh.Client
+	listener net.Listener
+	target   string
+}
+
+// OpenSSHTunnel connects to cfg.SourceSSHHost and points cfg.SourceHost and
+// cfg.SourcePort to a local port forwarded to the source database, so the
+// sources connect through the tunnel unchanged. It returns nil when no
+// bastion host is configured.
+func OpenSSHTunnel(cfg *config.Config) (*SSHTunnel, error) {
+	if cfg.SourceSSHHost == "" {
+		return nil, nil
+	}
+	switch cfg.DatabaseType {
+	case "", "mysql", "tidb", "pg", "oracle", "mssql":
+	default:
+		return nil, fmt.Errorf("sourceSSHHost is not supported for %s sources", cfg.DatabaseType)
+	}
+	bastion := cfg.SourceSSHHost
+	if _, _, err := net.SplitHostPort(bastion); err != nil {
+		bastion = net.JoinHostPort(bastion, "22")
+	}
+	sshConfig, err := newSSHClientConfig(bastion, cfg.SourceSSHUser, "", cfg.SourceSSHKeyFile, cfg.SourceSSHKnownHosts,
+		cfg.SourceInsecureSkipHostKeyCheck)
+	if err != nil {
+		return nil, err
+	}
+	client, err := ssh.Dial("tcp", bastion, sshConfig)
+	if err != nil {
+		return nil, fmt.Errorf("dial ssh bastion %s failed: %w", bastion, err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	t := &SSHTunnel{
+		client:   client,
+		listener: listener,
+		target:   net.JoinHostPort(cfg.SourceHost, strconv.Itoa(cfg.SourcePort)),
+	}
+	go t.serve()
+	logrus.Infof("ssh tunnel to %s through %s listening on %s", t.target, bastion, listener.Addr())
+	cfg.SourceHost = "127.0.0.1"
+	cfg.SourcePort = listener.Addr().(*net.TCPAddr).Port
+	return t, nil
+}
+
+func (t *SSHTunnel) serve() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.forward(conn)
+	}
+}
+
+func (t *SSHTunnel) forward(local net.Conn) {
+	defer local.Close()
+	remote, err := t.client.Dial("tcp", t.target)
+	if err != nil {
+		logrus.Errorf("ssh tunnel to %s failed: %v", t.target, err)
+		return
+	}
+	defer remote.Close()
+	go func() {
+		_, _ = io.Copy(remote, local)
+		remote.Close()
+	}()
+	_, _ = io.Copy(local, remote)
+}
+
+func (t *SSHTunnel) Close() error {
+	t.listener.Close()
+	return t.client.Close()
+}
@@ -0,0 +1,147 @@
+package source
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// startBastion runs an ssh server that accepts clientKey and forwards
+// direct-tcpip channels, it returns its address and its known_hosts line.
+func startBastion(t *testing.T, clientKey ssh.PublicKey) (string, string) {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	assert.NoError(t, err)
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, serverConfig)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for newChannel := range channels {
+					var target struct {
+						Host     string
+						Port     uint32
+						OrigHost string
+						OrigPort uint32
+					}
+					if newChannel.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newChannel.ExtraData(), &target) != nil {
+						newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+						continue
+					}
+					remote, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+					if err != nil {
+						newChannel.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					channel, channelRequests, _ := newChannel.Accept()
+					go ssh.DiscardRequests(channelRequests)
+					go func() {
+						_, _ = io.Copy(remote, channel)
+						remote.Close()
+					}()
+					go func() {
+						_, _ = io.Copy(channel, remote)
+						channel.Close()
+					}()
+				}
+			}()
+		}
+	}()
+	addr := listener.Addr().String()
+	return addr, knownhosts.Line([]string{addr}, hostSigner.PublicKey())
+}
+
+func TestOpenSSHTunnel(t *testing.T) {
+	// echo server standing in for the database
+	database, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer database.Close()
+	go func() {
+		for {
+			conn, err := database.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(clientPub)
+	assert.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	assert.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600))
+
+	bastion, knownHost := startBastion(t, sshPub)
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	assert.NoError(t, os.WriteFile(knownHosts, []byte(knownHost+"\n"), 0o600))
+	cfg := &config.Config{
+		DatabaseType:     "pg",
+		SourceHost:       "127.0.0.1",
+		SourcePort:       database.Addr().(*net.TCPAddr).Port,
+		SourceSSHHost:    bastion,
+		SourceSSHUser:    "archiver",
+		SourceSSHKeyFile: keyFile,
+	}
+	// the bastion is not verified without known_hosts
+	_, err = OpenSSHTunnel(cfg)
+	assert.Error(t, err)
+
+	cfg.SourceSSHKnownHosts = knownHosts
+	tunnel, err := OpenSSHTunnel(cfg)
+	assert.NoError(t, err)
+	defer tunnel.Close()
+	assert.NotEqual(t, database.Addr().(*net.TCPAddr).Port, cfg.SourcePort)
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(cfg.SourceHost, strconv.Itoa(cfg.SourcePort)))
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	assert.NoError(t, err)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(reply))
+
+	_, err = OpenSSHTunnel(&config.Config{DatabaseType: "http", SourceSSHHost: "bastion"})
+	assert.EqualError(t, err, "sourceSSHHost is not supported for http sources")
+}
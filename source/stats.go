@@ -1,10 +1,13 @@
 package source
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
 	timeseries "github.com/codesuki/go-time-series"
+
+	"github.com/databendcloud/bend-archiver/config"
 )
 
 type DatabendSourceStatsRecorder struct {
@@ -51,3 +54,152 @@ func (stats *DatabendSourceStatsRecorder) calcPerSecond(ts *timeseries.TimeSerie
 
 	return float64(amount) / duration.Seconds()
 }
+
+// liveStatsWindow is the window of the moving average throughput of the
+// live stats.
+const liveStatsWindow = time.Minute
+
+// ThroughputStats are the cumulative rows and bytes read by a table or a
+// thread, and their moving average throughput over the last minute.
+type ThroughputStats struct {
+	Rows           int64   `json:"rows"`
+	Bytes          int64   `json:"bytes"`
+	RowsPerSecond  float64 `json:"rowsPerSecond"`
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+}
+
+// TableStats are the live stats of a table and of each of its threads.
+type TableStats struct {
+	ThroughputStats
+	Threads map[int]ThroughputStats `json:"threads"`
+}
+
+type throughput struct {
+	start time.Time
+	rows  int64
+	bytes int64
+	// recent rows and bytes, for the moving average
+	recentRows  *timeseries.TimeSeries
+	recentBytes *timeseries.TimeSeries
+}
+
+func newThroughput() *throughput {
+	recentRows, err := timeseries.NewTimeSeries()
+	if err != nil {
+		panic(err)
+	}
+	recentBytes, err := timeseries.NewTimeSeries()
+	if err != nil {
+		panic(err)
+	}
+	return &throughput{start: time.Now(), recentRows: recentRows, recentBytes: recentBytes}
+}
+
+func (t *throughput) record(rows, bytes int) {
+	t.rows += int64(rows)
+	t.bytes += int64(bytes)
+	t.recentRows.Increase(rows)
+	t.recentBytes.Increase(bytes)
+}
+
+func (t *throughput) stats(now time.Time) ThroughputStats {
+	// the window is shorter than a minute during the first minute
+	window := now.Sub(t.start)
+	if window > liveStatsWindow {
+		window = liveStatsWindow
+	}
+	s := ThroughputStats{Rows: t.rows, Bytes: t.bytes}
+	if window <= 0 {
+		return s
+	}
+	if rows, err := t.recentRows.Range(now.Add(-window), now); err == nil {
+		s.RowsPerSecond = float64(rows) / window.Seconds()
+	}
+	if bytes, err := t.recentBytes.Range(now.Add(-window), now); err == nil {
+		s.BytesPerSecond = float64(bytes) / window.Seconds()
+	}
+	return s
+}
+
+type tableThroughput struct {
+	*throughput
+	threads map[int]*throughput
+}
+
+// liveStats are the live stats of the tables of the job, shared by the
+// recorders of their sources.
+var liveStats = struct {
+	mu     sync.Mutex
+	tables map[string]*tableThroughput
+}{tables: make(map[string]*tableThroughput)}
+
+// RecordBatch records the rows thread read from the table of cfg, in the
+// rows/s of the recorder and in the live stats of the table and thread.
+func (stats *DatabendSourceStatsRecorder) RecordBatch(cfg *config.Config, thread int, rows [][]interface{}) {
+	stats.RecordMetric(len(rows))
+	table := cfg.SourceTable
+	if cfg.SourceDB != "" {
+		table = cfg.SourceDB + "." + table
+	}
+	bytes := 0
+	for _, row := range rows {
+		bytes += rowBytes(row)
+	}
+
+	liveStats.mu.Lock()
+	defer liveStats.mu.Unlock()
+	t, ok := liveStats.tables[table]
+	if !ok {
+		t = &tableThroughput{throughput: newThroughput(), threads: make(map[int]*throughput)}
+		liveStats.tables[table] = t
+	}
+	th, ok := t.threads[thread]
+	if !ok {
+		th = newThroughput()
+		t.threads[thread] = th
+	}
+	t.record(len(rows), bytes)
+	th.record(len(rows), bytes)
+}
+
+// LiveStats are the live stats of the tables read so far, by db.table.
+func LiveStats() map[string]TableStats {
+	liveStats.mu.Lock()
+	defer liveStats.mu.Unlock()
+	now := time.Now()
+	result := make(map[string]TableStats, len(liveStats.tables))
+	for table, t := range liveStats.tables {
+		s := TableStats{ThroughputStats: t.stats(now), Threads: make(map[int]ThroughputStats, len(t.threads))}
+		for thread, th := range t.threads {
+			s.Threads[thread] = th.stats(now)
+		}
+		result[table] = s
+	}
+	return result
+}
+
+// rowBytes estimates the bytes of a row as read from the source, without
+// the cost of encoding it.
+func rowBytes(row []interface{}) int {
+	n := 0
+	for _, v := range row {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			n += len(v)
+		case []byte:
+			n += len(v)
+		case bool, int8, uint8:
+			n++
+		case int16, uint16:
+			n += 2
+		case int32, uint32, float32:
+			n += 4
+		case int, int64, uint, uint64, float64, time.Time:
+			n += 8
+		default:
+			n += len(fmt.Sprint(v))
+		}
+	}
+	return n
+}
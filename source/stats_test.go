@@ -0,0 +1,28 @@
+package source
+
+import (
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestLiveStats(t *testing.T) {
+	cfg := &config.Config{SourceDB: "shop", SourceTable: "stats_orders"}
+	recorder := NewDatabendIntesterStatsRecorder()
+	recorder.RecordBatch(cfg, 0, [][]interface{}{{int64(1), "ab"}, {int64(2), nil}})
+	recorder.RecordBatch(cfg, 1, [][]interface{}{{int64(3), []byte("abc")}})
+	recorder.RecordBatch(cfg, 1, [][]interface{}{{int64(4), time.Now()}})
+
+	stats, ok := LiveStats()["shop.stats_orders"]
+	assert.True(t, ok)
+	assert.Equal(t, int64(4), stats.Rows)
+	assert.Equal(t, int64(8+2+8+8+3+8+8), stats.Bytes)
+	assert.True(t, stats.RowsPerSecond > 0)
+	assert.Equal(t, 2, len(stats.Threads))
+	assert.Equal(t, int64(2), stats.Threads[0].Rows)
+	assert.Equal(t, int64(2), stats.Threads[1].Rows)
+	assert.Equal(t, int64(8+3+8+8), stats.Threads[1].Bytes)
+}
@@ -0,0 +1,49 @@
+package source
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// stdinPath is the path of the single file of StdinSource.
+const stdinPath = "-"
+
+// StdinSource archives the CSV/NDJSON rows piped to the process, e.g.
+// mysqldump | transform | bend-archiver. The rows are read a batch at a time
+// and not before the previous batch was ingested, so a fast writer blocks on
+// the full pipe instead of filling the memory.
+type StdinSource struct {
+	fileSource
+	in io.Reader
+}
+
+func NewStdinSource(cfg *config.Config) (*StdinSource, error) {
+	return &StdinSource{
+		fileSource: fileSource{cfg: cfg},
+		in:         os.Stdin,
+	}, nil
+}
+
+// ListFiles returns stdin as the only file, its table is sourceTable.
+func (s *StdinSource) ListFiles(ctx context.Context) ([]FileInfo, error) {
+	table := s.cfg.SourceTable
+	if table == "" {
+		table = "stdin"
+	}
+	return []FileInfo{{Path: stdinPath, Table: table}}, nil
+}
+
+func (s *StdinSource) OpenFile(ctx context.Context, file FileInfo) (io.ReadCloser, error) {
+	return io.NopCloser(s.in), nil
+}
+
+func (s *StdinSource) RemoveFile(ctx context.Context, file FileInfo) error {
+	return ErrNotSupportedByFileSource
+}
+
+func (s *StdinSource) MoveFile(ctx context.Context, file FileInfo, dir string) error {
+	return ErrNotSupportedByFileSource
+}
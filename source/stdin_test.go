@@ -0,0 +1,35 @@
+package source
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestStdinSource(t *testing.T) {
+	cfg := &config.Config{DatabaseType: "stdin", SourceTable: "orders", SourceFormat: "csv", BatchSize: 2}
+	s, err := NewStdinSource(cfg)
+	assert.NoError(t, err)
+	s.in = strings.NewReader("id,name\n1,a\n2,b\n3,c\n")
+
+	files, err := s.ListFiles(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []FileInfo{{Path: "-", Table: "orders"}}, files)
+	r, err := s.OpenFile(context.Background(), files[0])
+	assert.NoError(t, err)
+	defer r.Close()
+
+	var batches []int
+	err = s.ReadBatches(context.Background(), r, files[0], func(columns []string, rows [][]interface{}) error {
+		assert.Equal(t, []string{"id", "name"}, columns)
+		batches = append(batches, len(rows))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, batches)
+	assert.Error(t, s.RemoveFile(context.Background(), files[0]))
+}
@@ -0,0 +1,128 @@
+package worker
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// AllocateThreads splits budget threads between tables in proportion to
+// their sizes: every table gets one thread, the rest are shared by size and
+// what rounding leaves goes to the largest table. With more tables than
+// threads every table gets one and they wait for each other.
+func AllocateThreads(budget int, sizes []int64) []int {
+	threads := make([]int, len(sizes))
+	var total float64
+	largest := 0
+	for i, size := range sizes {
+		threads[i] = 1
+		total += float64(size)
+		if size > sizes[largest] {
+			largest = i
+		}
+	}
+	spare := budget - len(sizes)
+	if spare <= 0 || len(sizes) == 0 {
+		return threads
+	}
+	left := spare
+	for i, size := range sizes {
+		n := spare / len(sizes)
+		if total > 0 {
+			n = int(float64(spare) * float64(size) / total)
+		}
+		threads[i] += n
+		left -= n
+	}
+	threads[largest] += left
+	return threads
+}
+
+// tableSize estimates the bytes the worker reads as its row count times the
+// average row width, or just the row count when the source has no width.
+func (w *Worker) tableSize(ctx context.Context) int64 {
+	planner, err := w.planner()
+	if err != nil {
+		logrus.Warnf("count rows of %s failed: %v", w.Name, err)
+		return 0
+	}
+	rows, err := planner.GetSourceReadRowsCount(ctx)
+	if err != nil {
+		logrus.Warnf("count rows of %s failed: %v", w.Name, err)
+		return 0
+	}
+	width := 1
+	if rw, ok := w.Src.(source.RowWidther); ok {
+		width, err = rw.GetAvgRowWidth(ctx)
+		if err != nil {
+			logrus.Warnf("get row width of %s failed: %v", w.Name, err)
+		}
+		if width <= 0 {
+			width = 1
+		}
+	}
+	return int64(rows) * int64(width)
+}
+
+// threadBudget hands out the threads of a job to the tables running at the
+// same time.
+type threadBudget struct {
+	mu     sync.Mutex
+	tokens chan struct{}
+}
+
+func (b *threadBudget) acquire(n int) {
+	// one table at a time, so that two tables can't each hold part of the
+	// threads they wait for
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := 0; i < n; i++ {
+		b.tokens <- struct{}{}
+	}
+}
+
+func (b *threadBudget) release(n int) {
+	for i := 0; i < n; i++ {
+		<-b.tokens
+	}
+}
+
+// RunSharingThreads runs the workers of the tables of a job at the same
+// time, within budget threads overall. Each table gets threads in
+// proportion to its size, largest tables first; time split tables always
+// read with one thread.
+func RunSharingThreads(ctx context.Context, budget int, workers []*Worker) {
+	sizes := make([]int64, len(workers))
+	for i, w := range workers {
+		sizes[i] = w.tableSize(ctx)
+	}
+	threads := AllocateThreads(budget, sizes)
+	order := make([]int, len(workers))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return sizes[order[a]] > sizes[order[b]] })
+
+	b := &threadBudget{tokens: make(chan struct{}, budget)}
+	wg := &sync.WaitGroup{}
+	for _, i := range order {
+		w := workers[i]
+		if w.Cfg.SourceSplitTimeKey != "" {
+			threads[i] = 1
+		}
+		w.Cfg.MaxThread = threads[i]
+		logrus.Infof("Worker %s: size %d, %d threads", w.Name, sizes[i], threads[i])
+		b.acquire(threads[i])
+		wg.Add(1)
+		go func(w *Worker) {
+			defer wg.Done()
+			defer b.release(w.Cfg.MaxThread)
+			w.Run(ctx)
+		}(w)
+	}
+	wg.Wait()
+}
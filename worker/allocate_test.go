@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+func TestAllocateThreads(t *testing.T) {
+	assert.Equal(t, []int{6, 1, 1}, AllocateThreads(8, []int64{900, 60, 40}))
+	assert.Equal(t, []int{4, 4}, AllocateThreads(8, []int64{500, 500}))
+	assert.Equal(t, []int{3, 2, 2}, AllocateThreads(7, []int64{0, 0, 0}))
+	assert.Equal(t, []int{1, 1, 1}, AllocateThreads(2, []int64{1, 1, 1}))
+	assert.Equal(t, []int{3}, AllocateThreads(3, []int64{10}))
+}
+
+// sizedSource is a table of rows rows of width bytes, it records the threads
+// its worker ran with.
+type sizedSource struct {
+	source.TableSourcer
+	rows, width int
+	mu          *sync.Mutex
+	threads     map[string]int
+	name        string
+	cfg         *config.Config
+}
+
+func (s *sizedSource) GetSourceReadRowsCount(ctx context.Context) (int, error) {
+	return s.rows, nil
+}
+
+func (s *sizedSource) GetAvgRowWidth(ctx context.Context) (int, error) {
+	return s.width, nil
+}
+
+func (s *sizedSource) GetMinMaxSplitKey(ctx context.Context) (uint64, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.threads[s.name] = s.cfg.MaxThread
+	return 0, 0, nil
+}
+
+func TestRunSharingThreads(t *testing.T) {
+	mu := &sync.Mutex{}
+	threads := make(map[string]int)
+	var workers []*Worker
+	for _, table := range []struct {
+		name        string
+		rows, width int
+	}{
+		{"big", 1000000, 200},
+		{"narrow", 1000000, 10},
+		{"small", 1000, 200},
+	} {
+		cfg := &config.Config{SourceSplitKey: "id", MaxThread: 1}
+		src := &sizedSource{rows: table.rows, width: table.width, mu: mu, threads: threads, name: table.name, cfg: cfg}
+		workers = append(workers, &Worker{Name: table.name, Cfg: cfg, Src: src})
+	}
+	RunSharingThreads(context.Background(), 8, workers)
+	assert.Equal(t, map[string]int{"big": 6, "narrow": 1, "small": 1}, threads)
+}
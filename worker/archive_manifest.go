@@ -0,0 +1,188 @@
+package worker
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// ArchiveManifest records what a job archived: every staged batch with the
+// source range or file it came from, its row count and SHA-256, and the
+// snapshot of each target table once the job is done. Signed with an
+// Ed25519 key, it lets auditors prove the archive matches the source at
+// archive time.
+type ArchiveManifest struct {
+	mu          sync.Mutex
+	JobID       string            `json:"job_id,omitempty"`
+	RunID       string            `json:"run_id,omitempty"`
+	StartedAt   time.Time         `json:"started_at"`
+	FinishedAt  time.Time         `json:"finished_at"`
+	Rows        int               `json:"rows"`
+	Batches     []ArchiveBatch    `json:"batches"`
+	EmptyTables []string          `json:"empty_tables,omitempty"` // tables archived with no batch, they had no rows
+	SnapshotIDs map[string]string `json:"snapshot_ids"`
+	// Bookmarks are where the snapshots of the tables were read on the
+	// source, with sourceBookmark
+	Bookmarks map[string]source.Bookmark `json:"bookmarks,omitempty"`
+	PublicKey string                     `json:"public_key,omitempty"`
+	Signature string                     `json:"signature,omitempty"`
+}
+
+type ArchiveBatch struct {
+	Table  string `json:"table"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Stage  string `json:"stage"`
+	Rows   int    `json:"rows"`
+	Bytes  int    `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+func NewArchiveManifest() *ArchiveManifest {
+	return &ArchiveManifest{StartedAt: time.Now().UTC(), SnapshotIDs: make(map[string]string)}
+}
+
+// Record adds a staged batch, it is safe for concurrent use.
+func (m *ArchiveManifest) Record(table, source, target string, batch ingester.StagedBatch) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Batches = append(m.Batches, ArchiveBatch{
+		Table:  table,
+		Source: source,
+		Target: target,
+		Stage:  batch.Stage,
+		Rows:   batch.Rows,
+		Bytes:  batch.Bytes,
+		SHA256: batch.SHA256,
+	})
+	m.Rows += batch.Rows
+}
+
+// RecordEmpty adds a table that had no rows to archive, it is safe for
+// concurrent use.
+func (m *ArchiveManifest) RecordEmpty(table string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.EmptyTables = append(m.EmptyTables, table)
+}
+
+// RecordBookmark adds where the snapshot of table was read, it is safe for
+// concurrent use.
+func (m *ArchiveManifest) RecordBookmark(table string, b source.Bookmark) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Bookmarks == nil {
+		m.Bookmarks = make(map[string]source.Bookmark)
+	}
+	m.Bookmarks[table] = b
+}
+
+// Finish records the latest snapshot of every target table and writes the
+// manifest to cfg.ArchiveManifestFile, signed when cfg.ArchiveManifestKey is
+// set.
+func (m *ArchiveManifest) Finish(cfg *config.Config) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.JobID, m.RunID = cfg.JobID, cfg.RunID
+	m.FinishedAt = time.Now().UTC()
+	sort.Slice(m.Batches, func(i, j int) bool {
+		a, b := m.Batches[i], m.Batches[j]
+		if a.Table != b.Table {
+			return a.Table < b.Table
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.SHA256 < b.SHA256
+	})
+	sort.Strings(m.EmptyTables)
+	for _, batch := range m.Batches {
+		if _, ok := m.SnapshotIDs[batch.Target]; ok {
+			continue
+		}
+		cfgCopy := *cfg
+		cfgCopy.DatabendTable = batch.Target
+		snapshotID, err := ingester.NewDatabendIngester(&cfgCopy).GetSnapshotID()
+		if err != nil {
+			logrus.Errorf("get snapshot of %s failed: %v", batch.Target, err)
+		}
+		m.SnapshotIDs[batch.Target] = snapshotID
+	}
+
+	if cfg.ArchiveManifestKey != "" {
+		key, err := loadEd25519Key(cfg.ArchiveManifestKey)
+		if err != nil {
+			return err
+		}
+		m.PublicKey = base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+		m.Signature = ""
+		payload, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cfg.ArchiveManifestFile, data, 0o644)
+}
+
+// VerifyArchiveManifest checks the signature of a manifest written by Finish
+// against the trusted public key of the archive job.
+func VerifyArchiveManifest(data []byte, publicKey ed25519.PublicKey) error {
+	var m ArchiveManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil || len(signature) == 0 {
+		return errors.New("manifest is not signed")
+	}
+	m.Signature = ""
+	payload, err := json.Marshal(&m)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, payload, signature) {
+		return errors.New("manifest signature does not match")
+	}
+	return nil
+}
+
+// loadEd25519Key reads a PKCS #8 PEM private key, e.g. generated with
+// openssl genpkey -algorithm ed25519.
+func loadEd25519Key(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM key in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse archive manifest key failed")
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("archive manifest key must be an ed25519 key, got %T", key)
+	}
+	return edKey, nil
+}
@@ -0,0 +1,45 @@
+package worker
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+)
+
+func TestArchiveManifestSignature(t *testing.T) {
+	dir := t.TempDir()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	assert.NoError(t, err)
+	keyFile := filepath.Join(dir, "key.pem")
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+
+	m := NewArchiveManifest()
+	m.Record("db.t", "(id >= 10 and id < 20)", "archive.t", ingester.StagedBatch{Stage: "@~/b2", SHA256: "bb", Rows: 10, Bytes: 100})
+	m.Record("db.t", "(id >= 0 and id < 10)", "archive.t", ingester.StagedBatch{Stage: "@~/b1", SHA256: "aa", Rows: 10, Bytes: 90})
+	// the snapshot is normally read from the target table
+	m.SnapshotIDs["archive.t"] = "snap-1"
+
+	cfg := &config.Config{ArchiveManifestFile: filepath.Join(dir, "manifest.json"), ArchiveManifestKey: keyFile}
+	assert.NoError(t, m.Finish(cfg))
+	assert.Equal(t, 20, m.Rows)
+	assert.Equal(t, "(id >= 0 and id < 10)", m.Batches[0].Source)
+
+	data, err := os.ReadFile(cfg.ArchiveManifestFile)
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyArchiveManifest(data, public))
+
+	tampered := strings.Replace(string(data), `"rows": 20`, `"rows": 21`, 1)
+	assert.EqualError(t, VerifyArchiveManifest([]byte(tampered), public), "manifest signature does not match")
+}
@@ -0,0 +1,175 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tuning is one combination of the settings autotune tries.
+type tuning struct {
+	batchSize   int64
+	maxThread   int
+	compression string
+}
+
+func (t tuning) String() string {
+	return fmt.Sprintf("batchSize %d, maxThread %d, stageCompression %s", t.batchSize, t.maxThread, t.compression)
+}
+
+// autotune ingests the first key ranges of the table in trials of
+// AutotuneTrial each, tuning the thread count, then the batch size, then the
+// stage compression, and keeps the combination that ingested the most rows
+// per second for the rest of the job. It returns the key to go on from, and
+// done when the trials already read up to maxSplitKey.
+func (w *Worker) autotune(ctx context.Context, minSplitKey, maxSplitKey uint64) (uint64, bool, error) {
+	trial, err := time.ParseDuration(w.Cfg.AutotuneTrial)
+	if err != nil {
+		return 0, false, err
+	}
+	best := tuning{batchSize: w.Cfg.BatchSize, maxThread: w.Cfg.MaxThread, compression: w.Cfg.StageCompression}
+	if best.compression == "" {
+		best.compression = "none"
+	}
+	phases := []func(t tuning) []tuning{
+		func(t tuning) []tuning {
+			var candidates []tuning
+			for _, n := range distinct(max(t.maxThread/2, 1), t.maxThread, t.maxThread*2) {
+				candidates = append(candidates, tuning{t.batchSize, n, t.compression})
+			}
+			return candidates
+		},
+		func(t tuning) []tuning {
+			var candidates []tuning
+			for _, n := range distinct(int(max(t.batchSize/2, 1)), int(t.batchSize), int(t.batchSize*2)) {
+				candidates = append(candidates, tuning{int64(n), t.maxThread, t.compression})
+			}
+			return candidates
+		},
+		func(t tuning) []tuning {
+			return []tuning{{t.batchSize, t.maxThread, "none"}, {t.batchSize, t.maxThread, "gzip"}}
+		},
+	}
+
+	next := minSplitKey
+	done := false
+	var bestSpeed float64
+	for _, candidates := range phases {
+		phaseBest, phaseSpeed := best, -1.0
+		for _, t := range candidates(best) {
+			var speed float64
+			speed, next, done = w.runTrial(ctx, t, next, maxSplitKey, trial)
+			logrus.Infof("autotune %s: %.0f rows/s", t, speed)
+			if speed > phaseSpeed {
+				phaseBest, phaseSpeed = t, speed
+			}
+			if done {
+				break
+			}
+		}
+		best, bestSpeed = phaseBest, phaseSpeed
+		if done {
+			break
+		}
+	}
+	w.useTuning(best)
+	logrus.Infof("Worker %s: autotune chose %s (%.0f rows/s)", w.Name, best, bestSpeed)
+	return next, done, nil
+}
+
+// runTrial ingests ranges of t.batchSize keys from next on with t.maxThread
+// threads until trial is over. It returns the rows ingested per second, the
+// key to go on from and whether the range up to maxSplitKey has been read.
+func (w *Worker) runTrial(ctx context.Context, t tuning, next, maxSplitKey uint64, trial time.Duration) (float64, uint64, bool) {
+	w.useTuning(t)
+	startTime := time.Now()
+	rows := atomic.LoadInt64(&w.ingestedRows)
+	next, done := w.runRanges(ctx, t.maxThread, t.batchSize, next, maxSplitKey, startTime.Add(trial))
+	speed := float64(atomic.LoadInt64(&w.ingestedRows)-rows) / time.Since(startTime).Seconds()
+	return speed, next, done
+}
+
+// runRanges ingests ranges of batchSize keys from next on with threads
+// threads, handing them out in key order, until deadline (if set), the
+// budget of the job is spent or ctx is done. It returns the key to go on from, every key below it has
+// been ingested once the threads are done, and whether the range up to
+// maxSplitKey has been read. With a Checkpoint the ranges it records are
+// skipped, and every range ingested is recorded.
+func (w *Worker) runRanges(ctx context.Context, threads int, batchSize int64, next, maxSplitKey uint64, deadline time.Time) (uint64, bool) {
+	key := w.Cfg.SourceSplitKey
+	done := false
+	mu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	wg.Add(threads)
+	for i := 0; i < threads; i++ {
+		go func(idx int) {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				if done || ctx.Err() != nil || (!deadline.IsZero() && time.Now().After(deadline)) || w.budgetSpent() || w.limitReached() {
+					mu.Unlock()
+					return
+				}
+				first, last := next, maxSplitKey
+				if w.Checkpoint != nil {
+					first, last = w.Checkpoint.skip(w.Name, next, maxSplitKey)
+				}
+				// the keys may overflow near the max uint64
+				if first > maxSplitKey || first < next {
+					done = true
+					mu.Unlock()
+					return
+				}
+				if hi := first + uint64(batchSize) - 1; hi >= first && hi < last {
+					last = hi
+				}
+				var condition string
+				if last == maxSplitKey {
+					condition = fmt.Sprintf("(%s >= %d and %s <= %d)", key, first, key, maxSplitKey)
+					done = true
+				} else {
+					condition = fmt.Sprintf("(%s >= %d and %s < %d)", key, first, key, last+1)
+					next = last + 1
+				}
+				mu.Unlock()
+				complete := func(err error) {
+					if err != nil || w.Checkpoint == nil || w.limitReached() {
+						return
+					}
+					if err := w.Checkpoint.Complete(w.Name, first, last); err != nil {
+						logrus.Errorf("Thread %d, save checkpoint failed: %v", idx, err)
+					}
+				}
+				if err := w.readBatch(ctx, idx, condition, complete); err != nil {
+					logrus.Errorf("Thread %d, stepBatchWithCondition failed: %v", idx, err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	w.pipeline.wait()
+	return next, done || w.limitReached()
+}
+
+// useTuning applies t to the config shared with the ingester.
+func (w *Worker) useTuning(t tuning) {
+	w.Cfg.BatchSize = t.batchSize
+	w.Cfg.MaxThread = t.maxThread
+	w.Cfg.StageCompression = t.compression
+}
+
+func distinct(values ...int) []int {
+	var result []int
+	seen := make(map[int]bool)
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/archivertest"
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// rangeSource has one row per key, it records the keys it was asked for.
+type rangeSource struct {
+	source.TableSourcer
+	mu   sync.Mutex
+	read map[uint64]int
+}
+
+func (s *rangeSource) QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+	var lo, hi uint64
+	var op string
+	fmt.Sscanf(conditionSql, "(id >= %d and id %s %d)", &lo, &op, &hi)
+	if op == "<" {
+		hi--
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var data [][]interface{}
+	for id := lo; id <= hi; id++ {
+		s.read[id]++
+		data = append(data, []interface{}{id})
+	}
+	return data, []string{"id"}, nil
+}
+
+func TestAutotune(t *testing.T) {
+	src := &rangeSource{read: make(map[uint64]int)}
+	cfg := &config.Config{SourceSplitKey: "id", BatchSize: 10, MaxThread: 2, SourceRetryAttempts: 1, AutotuneTrial: "1ms"}
+	w := NewWorker(cfg, "orders", &archivertest.RecordingIngester{}, src)
+
+	next, done, err := w.autotune(context.Background(), 1, 1000000)
+	assert.NoError(t, err)
+	assert.False(t, done)
+	assert.True(t, next > 1)
+	for id := uint64(1); id < next; id++ {
+		assert.Equal(t, 1, src.read[id], "key %d", id)
+	}
+	assert.Equal(t, int(next-1), len(src.read))
+	assert.Contains(t, []int{1, 2, 4}, cfg.MaxThread)
+	assert.Contains(t, []int64{5, 10, 20, 40}, cfg.BatchSize)
+	assert.Contains(t, []string{"none", "gzip"}, cfg.StageCompression)
+
+	// trials stop at the end of the range
+	src = &rangeSource{read: make(map[uint64]int)}
+	cfg = &config.Config{SourceSplitKey: "id", BatchSize: 10, MaxThread: 1, SourceRetryAttempts: 1, AutotuneTrial: "1s"}
+	w = NewWorker(cfg, "orders", &archivertest.RecordingIngester{}, src)
+	_, done, err = w.autotune(context.Background(), 1, 25)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, 25, len(src.read))
+}
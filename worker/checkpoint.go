@@ -0,0 +1,141 @@
+package worker
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Checkpoint records the split key ranges the tables of a job have archived,
+// it is saved after every batch so that a job stopped at any point, e.g. a
+// preempted spot instance, resumes without reading those ranges again.
+type Checkpoint struct {
+	path string
+	mu   sync.Mutex
+
+	Tables map[string]*TableCheckpoint `json:"tables"`
+}
+
+// TableCheckpoint is the progress of one table: every key below Next, and
+// the keys of the Done ranges above it, have been archived.
+type TableCheckpoint struct {
+	Next uint64      `json:"next"`
+	Done [][2]uint64 `json:"done,omitempty"` // [first, last] keys of ranges archived out of order
+}
+
+// LoadCheckpoint reads the checkpoint at path, a missing file yields an
+// empty checkpoint.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	c := &Checkpoint{
+		path:   path,
+		Tables: make(map[string]*TableCheckpoint),
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if c.Tables == nil {
+		c.Tables = make(map[string]*TableCheckpoint)
+	}
+	return c, nil
+}
+
+// Empty reports whether no table has archived anything yet.
+func (c *Checkpoint) Empty() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.Tables) == 0
+}
+
+// start is the first key of table to archive from minKey on, the table
+// has no keys below minKey.
+func (c *Checkpoint) start(table string, minKey uint64) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.Tables[table]
+	if !ok {
+		t = &TableCheckpoint{Next: minKey}
+		c.Tables[table] = t
+	}
+	if t.Next < minKey {
+		t.Next = minKey
+	}
+	return t.Next
+}
+
+// skip returns the first key from key on that table has not archived, and
+// the last key of the range starting there that it has not archived either
+// (max when the table archived nothing after it).
+func (c *Checkpoint) skip(table string, key, max uint64) (uint64, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.Tables[table]
+	if !ok {
+		return key, max
+	}
+	for _, r := range t.Done {
+		switch {
+		case r[1] < key:
+		case r[0] <= key:
+			key = r[1] + 1
+		case r[0] <= max:
+			return key, r[0] - 1
+		}
+	}
+	return key, max
+}
+
+// Complete records that table, started with start, archived the keys first
+// to last and saves the checkpoint.
+func (c *Checkpoint) Complete(table string, first, last uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.Tables[table]
+	t.Done = append(t.Done, [2]uint64{first, last})
+	sort.Slice(t.Done, func(i, j int) bool { return t.Done[i][0] < t.Done[j][0] })
+	// ranges adjacent to next are archived in order now
+	for len(t.Done) > 0 && t.Done[0][0] <= t.Next {
+		if t.Done[0][1] >= t.Next {
+			t.Next = t.Done[0][1] + 1
+		}
+		t.Done = t.Done[1:]
+	}
+	if len(t.Done) == 0 {
+		t.Done = nil
+	}
+	return c.save()
+}
+
+// save writes the checkpoint atomically and syncs it, so that neither a
+// crash nor a power loss leaves a truncated file.
+func (c *Checkpoint) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
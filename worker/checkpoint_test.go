@@ -0,0 +1,113 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/archivertest"
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestCheckpointComplete(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "checkpoint.json")
+	c, err := LoadCheckpoint(file)
+	assert.NoError(t, err)
+	assert.True(t, c.Empty())
+	assert.Equal(t, uint64(1), c.start("t", 1))
+
+	assert.NoError(t, c.Complete("t", 11, 20))
+	assert.NoError(t, c.Complete("t", 31, 40))
+	assert.Equal(t, uint64(1), c.Tables["t"].Next)
+	assert.NoError(t, c.Complete("t", 1, 10))
+
+	c, err = LoadCheckpoint(file)
+	assert.NoError(t, err)
+	assert.Equal(t, &TableCheckpoint{Next: 21, Done: [][2]uint64{{31, 40}}}, c.Tables["t"])
+	assert.Equal(t, uint64(21), c.start("t", 1))
+	first, last := c.skip("t", 21, 100)
+	assert.Equal(t, []uint64{21, 30}, []uint64{first, last})
+	first, last = c.skip("t", 31, 100)
+	assert.Equal(t, []uint64{41, 100}, []uint64{first, last})
+}
+
+// preemptingSource calls preempt when the source is asked for its at-th
+// batch, like a spot instance reclaimed in the middle of the job.
+type preemptingSource struct {
+	*slowRangeSource
+	mu      sync.Mutex
+	batches int
+	at      int
+	preempt func()
+}
+
+func (s *preemptingSource) QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+	s.mu.Lock()
+	s.batches++
+	if s.batches == s.at {
+		s.preempt()
+	}
+	s.mu.Unlock()
+	return s.slowRangeSource.QueryTableData(ctx, threadNum, conditionSql)
+}
+
+// runPreempted runs a worker over src with the checkpoint in file until it
+// is done or preempted.
+func runPreempted(t *testing.T, ctx context.Context, file string, threads int, src *preemptingSource) *Worker {
+	checkpoint, err := LoadCheckpoint(file)
+	assert.NoError(t, err)
+	cfg := &config.Config{SourceSplitKey: "id", BatchSize: 10, MaxThread: threads, SourceRetryAttempts: 1}
+	w := NewWorker(cfg, "shop.orders", &archivertest.RecordingIngester{}, src)
+	w.Checkpoint = checkpoint
+	w.Run(ctx)
+	assert.NoError(t, w.Err())
+	return w
+}
+
+func TestPreemption(t *testing.T) {
+	// SIGTERM: the batches in flight are finished and recorded
+	file := filepath.Join(t.TempDir(), "checkpoint.json")
+	read := &rangeSource{read: make(map[uint64]int)}
+	ctx, cancel := context.WithCancel(context.Background())
+	src := &preemptingSource{slowRangeSource: &slowRangeSource{rangeSource: read, min: 1, max: 500}, at: 7, preempt: cancel}
+	w := runPreempted(t, ctx, file, 3, src)
+	_, stopped := w.Stopped()
+	assert.True(t, stopped)
+	assert.True(t, len(read.read) < 500)
+
+	src = &preemptingSource{slowRangeSource: &slowRangeSource{rangeSource: read, min: 1, max: 500}}
+	w = runPreempted(t, context.Background(), file, 3, src)
+	_, stopped = w.Stopped()
+	assert.False(t, stopped)
+	assert.Equal(t, 500, len(read.read))
+	for id := uint64(1); id <= 500; id++ {
+		assert.Equal(t, 1, read.read[id], "key %d", id)
+	}
+
+	// killed right away: the batch in flight was not recorded, it is the
+	// only one read again
+	file = filepath.Join(t.TempDir(), "checkpoint.json")
+	read = &rangeSource{read: make(map[uint64]int)}
+	ctx, cancel = context.WithCancel(context.Background())
+	var saved []byte
+	src = &preemptingSource{slowRangeSource: &slowRangeSource{rangeSource: read, min: 1, max: 500}, at: 5, preempt: func() {
+		saved, _ = os.ReadFile(file)
+		cancel()
+	}}
+	runPreempted(t, ctx, file, 1, src)
+	assert.NoError(t, os.WriteFile(file, saved, 0644))
+
+	src = &preemptingSource{slowRangeSource: &slowRangeSource{rangeSource: read, min: 1, max: 500}}
+	runPreempted(t, context.Background(), file, 1, src)
+	for id := uint64(1); id <= 500; id++ {
+		want := 1
+		if id > 40 && id <= 50 {
+			want = 2
+		}
+		assert.Equal(t, want, read.read[id], "key %d", id)
+	}
+}
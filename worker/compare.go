@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// RangeDiff is the comparison of a range of the source table with the same
+// range of databendTable.
+type RangeDiff struct {
+	Condition      string // the range, as the job reads it
+	SourceRows     int
+	TargetRows     int
+	SourceChecksum uint64 // of the compared columns of every row, in any order
+	TargetChecksum uint64
+}
+
+// Differs reports whether the range is not the same in the source and the
+// target.
+func (d RangeDiff) Differs() bool {
+	return d.SourceRows != d.TargetRows || d.SourceChecksum != d.TargetChecksum
+}
+
+// CompareRanges compares the ranges of conditions of the source table of cfg
+// with databendTable: the rows of each range are counted and the values of
+// columns summed up into a checksum on both sides. The rows of the source
+// are read and hashed here, Databend computes the checksum of its rows. The
+// columns must be read the same from both, e.g. integers and strings.
+func CompareRanges(ctx context.Context, cfg *config.Config, src source.SourceReader, conditions []string, columns []string) ([]RangeDiff, error) {
+	encoded := make([]string, len(columns))
+	for i, column := range columns {
+		// the encoding of compareValue
+		v := fmt.Sprintf("to_string(%s)", source.QuoteIdentifier(column))
+		encoded[i] = fmt.Sprintf("if(%s IS NULL, 'N', concat(to_string(octet_length(%s)), ':', %s))", v, v, v)
+	}
+	diffs := make([]RangeDiff, 0, len(conditions))
+	for _, condition := range conditions {
+		if err := ctx.Err(); err != nil {
+			return diffs, err
+		}
+		d := RangeDiff{Condition: condition}
+		rows, sourceColumns, err := src.QueryTableData(ctx, 0, condition)
+		if err != nil {
+			return diffs, fmt.Errorf("read %s from the source: %w", condition, err)
+		}
+		indexes, err := columnIndexes(sourceColumns, columns)
+		if err != nil {
+			return diffs, err
+		}
+		values := make([]string, len(columns))
+		for _, row := range rows {
+			for i, index := range indexes {
+				values[i] = compareValue(cfg, row[index])
+			}
+			d.SourceChecksum += rowChecksum(values)
+		}
+		d.SourceRows = len(rows)
+
+		target, err := ingester.Query(cfg, fmt.Sprintf("SELECT count(*), coalesce(sum(crc32(concat(%s))), 0) FROM %s WHERE %s",
+			strings.Join(encoded, ", "), cfg.DatabendTable, condition))
+		if err != nil {
+			return diffs, fmt.Errorf("read %s from %s: %w", condition, cfg.DatabendTable, err)
+		}
+		if len(target) != 1 || len(target[0]) != 2 {
+			return diffs, fmt.Errorf("checksum of %s in %s returned %v", condition, cfg.DatabendTable, target)
+		}
+		if d.TargetRows, err = strconv.Atoi(target[0][0]); err != nil {
+			return diffs, fmt.Errorf("invalid count %q of %s in %s", target[0][0], condition, cfg.DatabendTable)
+		}
+		if d.TargetChecksum, err = strconv.ParseUint(target[0][1], 10, 64); err != nil {
+			return diffs, fmt.Errorf("invalid checksum %q of %s in %s", target[0][1], condition, cfg.DatabendTable)
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs, nil
+}
+
+func columnIndexes(sourceColumns, columns []string) ([]int, error) {
+	indexes := make([]int, len(columns))
+	for i, column := range columns {
+		indexes[i] = -1
+		for j, sourceColumn := range sourceColumns {
+			if strings.EqualFold(sourceColumn, column) {
+				indexes[i] = j
+				break
+			}
+		}
+		if indexes[i] < 0 {
+			return nil, fmt.Errorf("the source has no column %s", column)
+		}
+	}
+	return indexes, nil
+}
+
+// compareValue encodes v of the source like CompareRanges has Databend
+// encode its values: NULL is N, any other value its length in bytes, a colon
+// and the value as Databend returns it, so that NULL and an empty string
+// differ and the values of a row can't run into each other.
+func compareValue(cfg *config.Config, v interface{}) string {
+	var s string
+	switch v := source.FormatFloat(cfg, v).(type) {
+	case nil:
+		return "N"
+	case []byte:
+		s = string(v)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		s = fmt.Sprint(v)
+	}
+	return strconv.Itoa(len(s)) + ":" + s
+}
+
+// rowChecksum is the CRC-32 of the encoded values of a row, the crc32 of
+// Databend. The checksum of a range is the sum of those of its rows so that
+// it doesn't depend on their order.
+func rowChecksum(values []string) uint64 {
+	return uint64(crc32.ChecksumIEEE([]byte(strings.Join(values, ""))))
+}
@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"encoding/json"
+	"hash/crc32"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestRowChecksum(t *testing.T) {
+	cfg := &config.Config{}
+	row := func(values ...interface{}) uint64 {
+		encoded := make([]string, len(values))
+		for i, v := range values {
+			encoded[i] = compareValue(cfg, v)
+		}
+		return rowChecksum(encoded)
+	}
+	a, b := row(int64(1), "alice"), row(int64(2), "bob")
+	assert.Equal(t, a+b, b+a)
+	assert.NotEqual(t, row("1a", "b"), row("1", "ab"))
+	assert.NotEqual(t, row(int64(1), nil), row(int64(1), ""))
+	// crc32('1:12:ab') of Databend
+	assert.Equal(t, uint64(crc32.ChecksumIEEE([]byte("1:12:ab"))), row(int64(1), "ab"))
+
+	d := RangeDiff{SourceRows: 2, TargetRows: 2, SourceChecksum: a + b, TargetChecksum: b + a}
+	assert.False(t, d.Differs())
+	d.TargetChecksum = a + a
+	assert.True(t, d.Differs())
+}
+
+func TestCompareValue(t *testing.T) {
+	cfg := &config.Config{}
+	assert.Equal(t, "N", compareValue(cfg, nil))
+	assert.Equal(t, "0:", compareValue(cfg, ""))
+	assert.Equal(t, "2:42", compareValue(cfg, int64(42)))
+	assert.Equal(t, "5:alice", compareValue(cfg, []byte("alice")))
+	assert.Equal(t, "3:0.1", compareValue(cfg, 0.1))
+	assert.Equal(t, "9:100000000", compareValue(cfg, 1e8))
+	cfg.FloatPrecision = 2
+	assert.Equal(t, "4:0.10", compareValue(cfg, 0.1))
+	assert.Equal(t, "1:7", compareValue(cfg, json.Number("7")))
+	assert.Equal(t, "6:héllo", compareValue(cfg, "héllo"))
+}
+
+func TestColumnIndexes(t *testing.T) {
+	indexes, err := columnIndexes([]string{"ID", "name", "amount"}, []string{"id", "amount"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 2}, indexes)
+	_, err = columnIndexes([]string{"id"}, []string{"email"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,82 @@
+package worker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+)
+
+// maxReportedConflicts bounds the duplicate keys read for the report.
+const maxReportedConflicts = 1000
+
+// KeyConflicts are the duplicate primary keys of a target table.
+type KeyConflicts struct {
+	Keys   int      // distinct keys found more than once
+	Rows   int      // rows with such a key
+	Ranges []string // the first duplicate keys, consecutive integer keys merged into ranges
+}
+
+func (c *KeyConflicts) String() string {
+	return fmt.Sprintf("%d keys in %d rows: %s", c.Keys, c.Rows, strings.Join(c.Ranges, ", "))
+}
+
+// CheckKeyConflicts looks for rows of table sharing the same
+// ConflictCheckKeys, it returns nil when there are none.
+func CheckKeyConflicts(cfg *config.Config, table string) (*KeyConflicts, error) {
+	keys := strings.Join(cfg.ConflictCheckKeys, ", ")
+	duplicates := fmt.Sprintf("SELECT %s, count(*) AS c FROM %s GROUP BY %s HAVING count(*) > 1", keys, table, keys)
+	summary, err := ingester.Query(cfg, fmt.Sprintf("SELECT count(*), coalesce(sum(c), 0) FROM (%s) AS d", duplicates))
+	if err != nil {
+		return nil, err
+	}
+	if len(summary) == 0 || summary[0][0] == "0" {
+		return nil, nil
+	}
+	conflicts := &KeyConflicts{}
+	conflicts.Keys, _ = strconv.Atoi(summary[0][0])
+	conflicts.Rows, _ = strconv.Atoi(summary[0][1])
+	rows, err := ingester.Query(cfg, fmt.Sprintf("SELECT %s FROM (%s) AS d ORDER BY %s LIMIT %d", keys, duplicates, keys, maxReportedConflicts))
+	if err != nil {
+		return nil, err
+	}
+	conflicts.Ranges = keyRanges(rows)
+	if conflicts.Keys > len(rows) {
+		conflicts.Ranges = append(conflicts.Ranges, fmt.Sprintf("and %d more keys", conflicts.Keys-len(rows)))
+	}
+	return conflicts, nil
+}
+
+// keyRanges formats sorted keys, merging runs of consecutive integer keys of
+// a single key column into first-last ranges.
+func keyRanges(keys [][]string) []string {
+	var ranges []string
+	for i := 0; i < len(keys); i++ {
+		if len(keys[i]) != 1 {
+			ranges = append(ranges, "("+strings.Join(keys[i], ", ")+")")
+			continue
+		}
+		first, err := strconv.ParseInt(keys[i][0], 10, 64)
+		if err != nil {
+			ranges = append(ranges, keys[i][0])
+			continue
+		}
+		last := first
+		for i+1 < len(keys) && len(keys[i+1]) == 1 {
+			next, err := strconv.ParseInt(keys[i+1][0], 10, 64)
+			if err != nil || next != last+1 {
+				break
+			}
+			last = next
+			i++
+		}
+		if last == first {
+			ranges = append(ranges, strconv.FormatInt(first, 10))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", first, last))
+		}
+	}
+	return ranges
+}
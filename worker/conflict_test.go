@@ -0,0 +1,15 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestKeyRanges(t *testing.T) {
+	keys := [][]string{{"1"}, {"2"}, {"3"}, {"7"}, {"9"}, {"10"}, {"abc"}}
+	assert.Equal(t, []string{"1-3", "7", "9-10", "abc"}, keyRanges(keys))
+	keys = [][]string{{"1", "us"}, {"2", "eu"}}
+	assert.Equal(t, []string{"(1, us)", "(2, eu)"}, keyRanges(keys))
+	assert.Equal(t, 0, len(keyRanges(nil)))
+}
@@ -0,0 +1,161 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// DbtRun is the outcome of a run recorded in the dbt sources file.
+type DbtRun struct {
+	Status     string
+	Rows       int64
+	FinishedAt time.Time
+}
+
+// dbtSources is a dbt properties file, the keys it does not know are kept
+// as they are.
+type dbtSources struct {
+	Version int                    `yaml:"version"`
+	Sources []dbtSource            `yaml:"sources"`
+	Extra   map[string]interface{} `yaml:",inline"`
+}
+
+type dbtSource struct {
+	Name   string                 `yaml:"name"`
+	Schema string                 `yaml:"schema,omitempty"`
+	Tables []dbtTable             `yaml:"tables"`
+	Extra  map[string]interface{} `yaml:",inline"`
+}
+
+type dbtFreshness struct {
+	WarnAfter  *dbtPeriod `yaml:"warn_after,omitempty"`
+	ErrorAfter *dbtPeriod `yaml:"error_after,omitempty"`
+}
+
+type dbtPeriod struct {
+	Count  int64  `yaml:"count"`
+	Period string `yaml:"period"`
+}
+
+type dbtTable struct {
+	Name          string                 `yaml:"name"`
+	Description   string                 `yaml:"description,omitempty"`
+	LoadedAtField string                 `yaml:"loaded_at_field,omitempty"`
+	Freshness     *dbtFreshness          `yaml:"freshness,omitempty"`
+	Meta          map[string]interface{} `yaml:"meta,omitempty"`
+	Extra         map[string]interface{} `yaml:",inline"`
+}
+
+// WriteDbtSources records the target table of cfg, with its freshness and
+// its last run, as a table of a dbt source in the dbtSourcesFile of cfg. The
+// other sources and tables of the file, and the keys added to them by hand,
+// are kept.
+func WriteDbtSources(cfg *config.Config, run DbtRun) error {
+	sources := dbtSources{Version: 2}
+	data, err := os.ReadFile(cfg.DbtSourcesFile)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, &sources); err != nil {
+			return fmt.Errorf("parse %s: %w", cfg.DbtSourcesFile, err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	schema, table, ok := strings.Cut(cfg.DatabendTable, ".")
+	if !ok {
+		schema, table = "default", cfg.DatabendTable
+	}
+	name := cfg.DbtSourceName
+	if name == "" {
+		name = schema
+	}
+	var src *dbtSource
+	for i := range sources.Sources {
+		if sources.Sources[i].Name == name {
+			src = &sources.Sources[i]
+		}
+	}
+	if src == nil {
+		sources.Sources = append(sources.Sources, dbtSource{Name: name})
+		src = &sources.Sources[len(sources.Sources)-1]
+	}
+	src.Schema = schema
+
+	var t *dbtTable
+	for i := range src.Tables {
+		if src.Tables[i].Name == table {
+			t = &src.Tables[i]
+		}
+	}
+	if t == nil {
+		src.Tables = append(src.Tables, dbtTable{Name: table})
+		t = &src.Tables[len(src.Tables)-1]
+	}
+	if t.Description == "" {
+		t.Description = fmt.Sprintf("Archived by bend-archiver from %s %s", cfg.DatabaseType, dbtSourceOf(cfg))
+	}
+	if cfg.DbtLoadedAtField != "" {
+		t.LoadedAtField = cfg.DbtLoadedAtField
+	}
+	if cfg.DbtFreshnessWarnAfter != "" || cfg.DbtFreshnessErrorAfter != "" {
+		t.Freshness = &dbtFreshness{
+			WarnAfter:  toDbtPeriod(cfg.DbtFreshnessWarnAfter),
+			ErrorAfter: toDbtPeriod(cfg.DbtFreshnessErrorAfter),
+		}
+	}
+	if t.Meta == nil {
+		t.Meta = make(map[string]interface{})
+	}
+	t.Meta["bend_archiver"] = map[string]interface{}{
+		"job_id":        cfg.JobID,
+		"run_id":        cfg.RunID,
+		"source":        dbtSourceOf(cfg),
+		"status":        run.Status,
+		"rows":          run.Rows,
+		"last_run_at":   run.FinishedAt.UTC().Format(time.RFC3339),
+		"database_type": cfg.DatabaseType,
+	}
+
+	data, err = yaml.Marshal(sources)
+	if err != nil {
+		return err
+	}
+	tmpFile := cfg.DbtSourcesFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, cfg.DbtSourcesFile)
+}
+
+// dbtSourceOf is the source of cfg, its path or db.table (patterns for a
+// job of several tables).
+func dbtSourceOf(cfg *config.Config) string {
+	if cfg.IsFileSource() {
+		return cfg.SourcePath
+	}
+	return cfg.SourceDB + "." + cfg.SourceTable
+}
+
+// toDbtPeriod is period, validated by the config, in the largest dbt period
+// that counts it exactly, nil when it is empty.
+func toDbtPeriod(period string) *dbtPeriod {
+	if period == "" {
+		return nil
+	}
+	d, _ := config.ParsePeriod(period)
+	switch {
+	case d%(24*time.Hour) == 0:
+		return &dbtPeriod{Count: int64(d / (24 * time.Hour)), Period: "day"}
+	case d%time.Hour == 0:
+		return &dbtPeriod{Count: int64(d / time.Hour), Period: "hour"}
+	default:
+		return &dbtPeriod{Count: int64((d + time.Minute - 1) / time.Minute), Period: "minute"}
+	}
+}
@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+	"gopkg.in/yaml.v3"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestWriteDbtSources(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sources.yml")
+	// a table of the file written by hand, with its own tests
+	assert.NoError(t, os.WriteFile(file, []byte(`version: 2
+sources:
+  - name: archive
+    schema: archive
+    tables:
+      - name: customers
+        columns:
+          - name: id
+            tests: [unique]
+`), 0644))
+	cfg := &config.Config{
+		DatabaseType:           "mysql",
+		SourceDB:               "shop",
+		SourceTable:            "orders",
+		DatabendTable:          "archive.orders",
+		DbtSourcesFile:         file,
+		DbtLoadedAtField:       "archived_at",
+		DbtFreshnessWarnAfter:  "36h",
+		DbtFreshnessErrorAfter: "2d",
+		JobID:                  "job",
+		RunID:                  "run1",
+	}
+	finishedAt := time.Date(2024, 7, 1, 2, 13, 4, 0, time.UTC)
+	assert.NoError(t, WriteDbtSources(cfg, DbtRun{Status: "succeeded", Rows: 100, FinishedAt: finishedAt}))
+	cfg.RunID = "run2"
+	assert.NoError(t, WriteDbtSources(cfg, DbtRun{Status: "partial", Rows: 40, FinishedAt: finishedAt.Add(time.Hour)}))
+
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	var sources dbtSources
+	assert.NoError(t, yaml.Unmarshal(data, &sources))
+	assert.Equal(t, 2, sources.Version)
+	assert.Len(t, sources.Sources, 1)
+	tables := sources.Sources[0].Tables
+	assert.Len(t, tables, 2)
+	assert.Equal(t, "customers", tables[0].Name)
+	assert.NotNil(t, tables[0].Extra["columns"])
+
+	orders := tables[1]
+	assert.Equal(t, "orders", orders.Name)
+	assert.Equal(t, "archived_at", orders.LoadedAtField)
+	assert.Equal(t, &dbtPeriod{Count: 36, Period: "hour"}, orders.Freshness.WarnAfter)
+	assert.Equal(t, &dbtPeriod{Count: 2, Period: "day"}, orders.Freshness.ErrorAfter)
+	run := orders.Meta["bend_archiver"].(map[string]interface{})
+	assert.Equal(t, "run2", run["run_id"])
+	assert.Equal(t, "partial", run["status"])
+	assert.Equal(t, 40, run["rows"])
+	assert.Equal(t, "2024-07-01T03:13:04Z", run["last_run_at"])
+	assert.Equal(t, "shop.orders", run["source"])
+}
@@ -0,0 +1,130 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/ingester"
+)
+
+// diffSyncQueries are the statements of a diff sync of target from staging,
+// a full copy of the source.
+type diffSyncQueries struct {
+	inserted string // rows only in staging
+	updated  string // rows whose hash differs
+	deleted  string // rows only in target
+	merge    string
+	delete   string
+	// without MERGE INTO, the changed rows are deleted and inserted again
+	deleteChanged string
+	insert        string
+}
+
+func newDiffSyncQueries(target, staging string, keys, columns []string) diffSyncQueries {
+	var on []string
+	for _, key := range keys {
+		on = append(on, fmt.Sprintf("t.%s = s.%s", key, key))
+	}
+	match := strings.Join(on, " AND ")
+	changed := fmt.Sprintf("%s <> %s", rowHash("t", columns), rowHash("s", columns))
+	onlyInTarget := fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s AS s WHERE %s)", staging, match)
+	return diffSyncQueries{
+		inserted: fmt.Sprintf("SELECT count(*) FROM %s AS s WHERE NOT EXISTS (SELECT 1 FROM %s AS t WHERE %s)", staging, target, match),
+		updated:  fmt.Sprintf("SELECT count(*) FROM %s AS s JOIN %s AS t ON %s WHERE %s", staging, target, match, changed),
+		deleted:  fmt.Sprintf("SELECT count(*) FROM %s AS t WHERE %s", target, onlyInTarget),
+		merge: fmt.Sprintf("MERGE INTO %s AS t USING %s AS s ON %s WHEN MATCHED AND %s THEN UPDATE * WHEN NOT MATCHED THEN INSERT *",
+			target, staging, match, changed),
+		delete: fmt.Sprintf("DELETE FROM %s AS t WHERE %s", target, onlyInTarget),
+		deleteChanged: fmt.Sprintf("DELETE FROM %s AS t WHERE EXISTS (SELECT 1 FROM %s AS s WHERE %s AND %s)",
+			target, staging, match, changed),
+		insert: fmt.Sprintf("INSERT INTO %s SELECT * FROM %s AS s WHERE NOT EXISTS (SELECT 1 FROM %s AS t WHERE %s)",
+			target, staging, target, match),
+	}
+}
+
+// statements are the statements applying the diff to target on a Databend
+// with features.
+func (q diffSyncQueries) statements(features ingester.Features) []string {
+	if features.MergeInto {
+		return []string{q.delete, q.merge}
+	}
+	// the staging table is created LIKE target, its columns are in the same order
+	return []string{q.delete, q.deleteChanged, q.insert}
+}
+
+// rowHash is the MD5 of the columns of the row of table alias, NULLs are
+// hashed apart from empty strings.
+func rowHash(alias string, columns []string) string {
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		values[i] = fmt.Sprintf("coalesce(to_string(%s.%s), char(0))", alias, column)
+	}
+	return fmt.Sprintf("md5(concat_ws(char(31), %s))", strings.Join(values, ", "))
+}
+
+// diffSync stages the whole source into a copy of the target table, then
+// only inserts, updates and deletes the rows of the target that changed,
+// matching the rows by DiffSyncKeys and comparing their hashes.
+func (w *Worker) diffSync(ctx context.Context) error {
+	target := w.Cfg.DatabendTable
+	staging := target + "_diff_staging"
+	if err := ingester.Exec(w.Cfg, fmt.Sprintf("DROP TABLE IF EXISTS %s", staging)); err != nil {
+		return err
+	}
+	if err := ingester.Exec(w.Cfg, fmt.Sprintf("CREATE TABLE %s LIKE %s", staging, target)); err != nil {
+		return err
+	}
+	defer func() {
+		if err := ingester.Exec(w.Cfg, fmt.Sprintf("DROP TABLE IF EXISTS %s", staging)); err != nil {
+			logrus.Warnf("drop %s failed: %v", staging, err)
+		}
+	}()
+
+	cfgCopy := *w.Cfg
+	cfgCopy.DiffSync = false
+	cfgCopy.DatabendTable = staging
+	stagingIg := ingester.NewDatabendIngester(&cfgCopy)
+	// not Run, the table is complete once the diff is applied to target
+	inner := NewWorker(&cfgCopy, w.Name, stagingIg, w.Src)
+	inner.step(ctx)
+	if err := inner.Err(); err != nil {
+		return fmt.Errorf("stage the source into %s: %w", staging, err)
+	}
+
+	// a partial copy of the source would delete the rows it missed
+	planner, err := w.planner()
+	if err != nil {
+		return err
+	}
+	sourceRows, err := planner.GetSourceReadRowsCount(ctx)
+	if err != nil {
+		return err
+	}
+	stagedRows, err := stagingIg.GetAllSyncedCount()
+	if err != nil {
+		return err
+	}
+	if stagedRows != sourceRows {
+		return fmt.Errorf("staged %d of the %d source rows into %s, %s is left as is", stagedRows, sourceRows, staging, target)
+	}
+
+	columns, err := ingester.TableColumns(w.Cfg, target)
+	if err != nil {
+		return err
+	}
+	q := newDiffSyncQueries(target, staging, w.Cfg.DiffSyncKeys, columns)
+	var counts [3]int
+	for i, query := range []string{q.inserted, q.updated, q.deleted} {
+		if counts[i], err = ingester.QueryCount(w.Cfg, query); err != nil {
+			return err
+		}
+	}
+	if err := ingester.ExecAll(w.Cfg, q.statements(ingester.CurrentFeatures())...); err != nil {
+		return err
+	}
+	logrus.Infof("Worker %s: diff sync of %s inserted %d, updated %d and deleted %d rows", w.Name, target, counts[0], counts[1], counts[2])
+	return nil
+}
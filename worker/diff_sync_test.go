@@ -0,0 +1,29 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/ingester"
+)
+
+func TestDiffSyncQueries(t *testing.T) {
+	q := newDiffSyncQueries("dim.region", "dim.region_diff_staging", []string{"id"}, []string{"id", "name"})
+	hash := func(alias string) string {
+		return "md5(concat_ws(char(31), coalesce(to_string(" + alias + ".id), char(0)), coalesce(to_string(" + alias + ".name), char(0))))"
+	}
+	assert.Equal(t, "MERGE INTO dim.region AS t USING dim.region_diff_staging AS s ON t.id = s.id WHEN MATCHED AND "+
+		hash("t")+" <> "+hash("s")+" THEN UPDATE * WHEN NOT MATCHED THEN INSERT *", q.merge)
+	assert.Equal(t, "DELETE FROM dim.region AS t WHERE NOT EXISTS (SELECT 1 FROM dim.region_diff_staging AS s WHERE t.id = s.id)", q.delete)
+	assert.Equal(t, "SELECT count(*) FROM dim.region_diff_staging AS s WHERE NOT EXISTS (SELECT 1 FROM dim.region AS t WHERE t.id = s.id)", q.inserted)
+
+	assert.Equal(t, []string{q.delete, q.merge}, q.statements(ingester.Features{MergeInto: true}))
+	assert.Equal(t, "DELETE FROM dim.region AS t WHERE EXISTS (SELECT 1 FROM dim.region_diff_staging AS s WHERE t.id = s.id AND "+
+		hash("t")+" <> "+hash("s")+")", q.deleteChanged)
+	assert.Equal(t, "INSERT INTO dim.region SELECT * FROM dim.region_diff_staging AS s WHERE NOT EXISTS (SELECT 1 FROM dim.region AS t WHERE t.id = s.id)", q.insert)
+	assert.Equal(t, []string{q.delete, q.deleteChanged, q.insert}, q.statements(ingester.Features{}))
+
+	q = newDiffSyncQueries("dim.price", "dim.price_diff_staging", []string{"sku", "region"}, []string{"sku", "region", "price"})
+	assert.Equal(t, "SELECT count(*) FROM dim.price AS t WHERE NOT EXISTS (SELECT 1 FROM dim.price_diff_staging AS s WHERE t.sku = s.sku AND t.region = s.region)", q.deleted)
+}
@@ -0,0 +1,41 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/archivertest"
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// emptySource is a table without rows, its split key bounds are NULL.
+type emptySource struct {
+	source.TableSourcer
+}
+
+func (s *emptySource) GetMinMaxSplitKey(ctx context.Context) (uint64, uint64, error) {
+	return 0, 0, nil
+}
+
+func (s *emptySource) GetMinMaxTimeSplitKey(ctx context.Context) (string, string, error) {
+	return "", "", nil
+}
+
+func TestSkipEmptyTable(t *testing.T) {
+	for _, cfg := range []*config.Config{
+		{SourceSplitKey: "id", BatchSize: 10, MaxThread: 2, SourceRetryAttempts: 1},
+		{SourceSplitTimeKey: "t", TimeSplitUnit: "hour", BatchSize: 10, MaxThread: 1, SourceRetryAttempts: 1},
+	} {
+		ig := &archivertest.RecordingIngester{}
+		w := NewWorker(cfg, "shop.orders", ig, &emptySource{})
+		w.ArchiveManifest = NewArchiveManifest()
+		w.Run(context.Background())
+		assert.NoError(t, w.Err())
+		assert.Equal(t, 0, ig.Rows())
+		assert.Equal(t, []string{"shop.orders"}, w.ArchiveManifest.EmptyTables)
+		assert.Empty(t, w.ArchiveManifest.Batches)
+	}
+}
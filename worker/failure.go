@@ -0,0 +1,43 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/databendcloud/bend-archiver/errcode"
+)
+
+// fail records err as the error of the worker, the first one wins since the
+// later ones usually follow from it. It returns err.
+func (w *Worker) fail(err error) error {
+	if err == nil {
+		return nil
+	}
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+	return err
+}
+
+// Err is the first error the worker ran into, nil when every batch was
+// ingested.
+func (w *Worker) Err() error {
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+	return w.err
+}
+
+// sourceError codes an error reading the source, as unavailable when it is
+// worth retrying and as a failed query otherwise, unless the retry budget
+// stopped the retries.
+func sourceError(err error) error {
+	if errors.Is(err, errcode.ErrRetryBudget) {
+		return err
+	}
+	if isTransientSourceError(err) {
+		return fmt.Errorf("%w: %w", errcode.ErrSourceUnavailable, err)
+	}
+	return fmt.Errorf("%w: %w", errcode.ErrSourceQuery, err)
+}
@@ -0,0 +1,27 @@
+package worker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/errcode"
+)
+
+func TestWorkerFail(t *testing.T) {
+	w := &Worker{}
+	assert.NoError(t, w.Err())
+	assert.NoError(t, w.fail(nil))
+
+	first := sourceError(mysql.ErrInvalidConn)
+	assert.Equal(t, first, w.fail(first))
+	w.fail(errcode.ErrCopyFailed)
+	assert.Equal(t, first, w.Err())
+	assert.Equal(t, errcode.SourceUnavailable, errcode.CodeOf(w.Err()))
+	assert.True(t, errors.Is(w.Err(), mysql.ErrInvalidConn))
+
+	err := sourceError(errors.New("Error 1146: Table 'db.orders' doesn't exist"))
+	assert.Equal(t, errcode.SourceQuery, errcode.CodeOf(err))
+}
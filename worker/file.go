@@ -0,0 +1,161 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+func (w *Worker) stepFiles(ctx context.Context, fs source.FileSourcer) error {
+	files, err := fs.ListFiles(ctx)
+	if err != nil {
+		return err
+	}
+	var manifest *FileManifest
+	if w.Cfg.ManifestFile != "" {
+		manifest, err = LoadFileManifest(w.Cfg.ManifestFile)
+		if err != nil {
+			return err
+		}
+	}
+	logrus.Infof("found %d files to archive", len(files))
+
+	fileCh := make(chan source.FileInfo)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < w.Cfg.MaxThread; i++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			for file := range fileCh {
+				if err := w.stepFile(ctx, idx, fs, manifest, file); err != nil {
+					logrus.Errorf("Thread %d, ingest file %s failed: %v", idx, file.Path, w.fail(err))
+				}
+			}
+		}(i)
+	}
+	for _, file := range files {
+		if w.deadlineReached() {
+			// the manifest tells the next run which files are left
+			w.stop("")
+			break
+		}
+		fileCh <- file
+	}
+	close(fileCh)
+	wg.Wait()
+	return nil
+}
+
+// stepFile archives file, and moves or removes it. A file being archived
+// when ctx is done is archived to its end, its manifest entry is what lets
+// the next run skip it.
+func (w *Worker) stepFile(ctx context.Context, threadNum int, fs source.FileSourcer, manifest *FileManifest, file source.FileInfo) error {
+	ctx = context.WithoutCancel(ctx)
+	if w.limitReached() {
+		return nil
+	}
+	if manifest != nil {
+		processed, err := w.isFileProcessed(ctx, fs, manifest, file)
+		if err != nil {
+			return err
+		}
+		if processed {
+			logrus.Infof("skip file %s, already ingested", file.Path)
+			return nil
+		}
+	}
+
+	rows, sum, err := w.ingestFile(ctx, threadNum, fs, file)
+	if err != nil && !errors.Is(err, errLimitReached) {
+		return err
+	}
+	logrus.Infof("thread-%d: ingested %d rows from file %s", threadNum, rows, file.Path)
+	if w.Cfg.IsPartialRun() {
+		// the file is only partly archived, keep it for the full run
+		return nil
+	}
+
+	if manifest != nil {
+		manifest.Record(file, sum, rows)
+		if err := manifest.Save(); err != nil {
+			return err
+		}
+	}
+	switch {
+	case w.Cfg.MoveAfterSync != "":
+		if err := fs.MoveFile(ctx, file, w.Cfg.MoveAfterSync); err != nil {
+			return err
+		}
+	case w.Cfg.DeleteAfterSync:
+		if err := fs.RemoveFile(ctx, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isFileProcessed trusts size and mtime first, and falls back to comparing
+// the content hash when only the mtime changed (e.g. the file was touched).
+func (w *Worker) isFileProcessed(ctx context.Context, fs source.FileSourcer, manifest *FileManifest, file source.FileInfo) (bool, error) {
+	if manifest.Unchanged(file) {
+		return true, nil
+	}
+	entry, ok := manifest.Lookup(file)
+	if !ok || entry.Size != file.Size || entry.SHA256 == "" {
+		return false, nil
+	}
+	r, err := fs.OpenFile(ctx, file)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return false, err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != entry.SHA256 {
+		return false, nil
+	}
+	manifest.Record(file, entry.SHA256, entry.Rows)
+	return true, manifest.Save()
+}
+
+func (w *Worker) ingestFile(ctx context.Context, threadNum int, fs source.FileSourcer, file source.FileInfo) (int, string, error) {
+	r, err := fs.OpenFile(ctx, file)
+	if err != nil {
+		return 0, "", err
+	}
+	defer r.Close()
+
+	ig := w.Ig
+	target := w.Cfg.TargetTable(file.Table)
+	if target != w.Cfg.DatabendTable {
+		cfgCopy := *w.Cfg
+		cfgCopy.DatabendTable = target
+		ig = ingester.NewDatabendIngester(&cfgCopy)
+	}
+
+	h := sha256.New()
+	total, part := 0, 0
+	timer := newReadTimer()
+	err = fs.ReadBatches(ctx, io.TeeReader(r, h), file, func(columns []string, data [][]interface{}) error {
+		timer.read(w.ReadLatency, w.Name, file.Path)
+		defer timer.reset()
+		part++
+		rows, err := w.ingest(ig, target, file.Path, part, threadNum, columns, data)
+		total += rows
+		return err
+	})
+	if err != nil {
+		return total, "", err
+	}
+	return total, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,105 @@
+package worker
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// FileManifest records the files that have already been ingested by a file
+// source, so repeated runs over the same directory only pick up new or
+// changed files even when DeleteAfterSync is disabled.
+type FileManifest struct {
+	path string
+	mu   sync.Mutex
+
+	Files map[string]FileManifestEntry `json:"files"`
+}
+
+type FileManifestEntry struct {
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"modTime"`
+	SHA256     string    `json:"sha256"`
+	Rows       int       `json:"rows"`
+	IngestedAt time.Time `json:"ingestedAt"`
+}
+
+// LoadFileManifest reads the manifest at path, a missing file yields an
+// empty manifest.
+func LoadFileManifest(path string) (*FileManifest, error) {
+	m := &FileManifest{
+		path:  path,
+		Files: make(map[string]FileManifestEntry),
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.Files == nil {
+		m.Files = make(map[string]FileManifestEntry)
+	}
+	return m, nil
+}
+
+// Lookup returns the entry recorded for file.
+func (m *FileManifest) Lookup(file source.FileInfo) (FileManifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.Files[file.Path]
+	return entry, ok
+}
+
+// Unchanged reports whether file has the same size and mtime as recorded,
+// files without a known mtime are never considered unchanged.
+func (m *FileManifest) Unchanged(file source.FileInfo) bool {
+	if file.ModTime.IsZero() {
+		return false
+	}
+	entry, ok := m.Lookup(file)
+	return ok && entry.Size == file.Size && entry.ModTime.Equal(file.ModTime)
+}
+
+func (m *FileManifest) Record(file source.FileInfo, sha256 string, rows int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Files[file.Path] = FileManifestEntry{
+		Size:       file.Size,
+		ModTime:    file.ModTime,
+		SHA256:     sha256,
+		Rows:       rows,
+		IngestedAt: time.Now(),
+	}
+}
+
+// Save writes the manifest atomically, so a crash never leaves a truncated file.
+func (m *FileManifest) Save() error {
+	m.mu.Lock()
+	data, err := json.MarshalIndent(m, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
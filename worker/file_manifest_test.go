@@ -0,0 +1,30 @@
+package worker
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+func TestFileManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	m, err := LoadFileManifest(path)
+	assert.NoError(t, err)
+
+	file := source.FileInfo{Path: "/data/a.csv", Size: 10, ModTime: time.Unix(1700000000, 0)}
+	assert.False(t, m.Unchanged(file))
+	m.Record(file, "abc", 3)
+	assert.NoError(t, m.Save())
+
+	m, err = LoadFileManifest(path)
+	assert.NoError(t, err)
+	assert.True(t, m.Unchanged(file))
+
+	changed := file
+	changed.Size = 11
+	assert.False(t, m.Unchanged(changed))
+}
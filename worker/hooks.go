@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"sync/atomic"
+
+	"github.com/databendcloud/bend-archiver/errcode"
+	"github.com/databendcloud/bend-archiver/internal/hooks"
+)
+
+// table is the source of the worker in the hook events, the path of a file
+// source or db.table.
+func (w *Worker) table() string {
+	if w.Cfg.IsFileSource() {
+		return w.Cfg.SourcePath
+	}
+	return w.Cfg.SourceDB + "." + w.Cfg.SourceTable
+}
+
+// tableComplete fires the table_complete hooks once the worker is done.
+func (w *Worker) tableComplete() {
+	event := hooks.Event{
+		Event:  hooks.TableComplete,
+		Target: w.Cfg.DatabendTable,
+		Table:  w.table(),
+		Rows:   atomic.LoadInt64(&w.ingestedRows),
+		Status: "succeeded",
+		Code:   errcode.OK,
+	}
+	if err := w.Err(); err != nil {
+		event.Status, event.Code, event.Error = "failed", errcode.CodeOf(err), err.Error()
+	} else if _, stopped := w.Stopped(); stopped {
+		event.Status, event.Code = "partial", errcode.Partial
+	}
+	hooks.Fire(w.Cfg, event)
+}
+
+// batchFailed fires the batch_failed hooks for the batch of source, a range
+// or a file, that failed with err once retried.
+func (w *Worker) batchFailed(source string, err error) {
+	hooks.Fire(w.Cfg, hooks.Event{
+		Event:  hooks.BatchFailed,
+		Target: w.Cfg.DatabendTable,
+		Table:  w.table(),
+		Batch:  source,
+		Code:   errcode.CodeOf(err),
+		Error:  err.Error(),
+	})
+}
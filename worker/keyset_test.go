@@ -0,0 +1,27 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/archivertest"
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+)
+
+func TestStepBatchWithKeyset(t *testing.T) {
+	src := archivertest.NewFakeSource("shop", "orders", 25)
+	ig := &archivertest.RecordingIngester{}
+	w := &Worker{Cfg: &config.Config{SourceSplitKey: "id", SourceRetryAttempts: 1}, Src: src, Ig: ig}
+	assert.NoError(t, w.stepBatchWithKeyset(context.Background(), "(t >= 'a' and t < 'b')", 10))
+	assert.Equal(t, 25, ig.Rows())
+	assert.Equal(t, []string{
+		"(t >= 'a' and t < 'b') ORDER BY id LIMIT 10",
+		"(t >= 'a' and t < 'b') AND id > 10 ORDER BY id LIMIT 10",
+		"(t >= 'a' and t < 'b') AND id > 20 ORDER BY id LIMIT 10",
+	}, src.Conditions())
+	// every page is staged under its own name
+	assert.Equal(t, ingester.BatchName{Source: src.Conditions()[1]}, ig.Batches()[1].Name)
+}
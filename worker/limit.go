@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/ingester"
+)
+
+// errLimitReached stops reading once maxRows (or sampleRows) rows have been
+// ingested.
+var errLimitReached = errors.New("row limit reached")
+
+// ingest stages one batch into the target table with ig, after dropping the
+// rows excluded by sampling, startFromRow and maxRows, and records it in the
+// archive manifest under source, the range or file it was read from. part
+// numbers the batches of a source read in several, from 1, 0 otherwise. It
+// returns how many rows were ingested, and errLimitReached once the row limit
+// is reached.
+func (w *Worker) ingest(ig ingester.DatabendIngester, target, source string, part, threadNum int, columns []string, data [][]interface{}) (int, error) {
+	if w.limitReached() {
+		return 0, errLimitReached
+	}
+	data = w.limit(columns, data)
+	if w.Cfg.SoftDeleteColumn != "" {
+		var err error
+		if data, err = w.propagateSoftDeletes(ig, target, columns, data); err != nil {
+			w.batchFailed(source, err)
+			return 0, w.fail(err)
+		}
+	}
+	w.Quality.Check(columns, data)
+	if len(w.Cfg.MetadataColumns) > 0 {
+		var err error
+		if columns, data, err = w.addMetadata(source, columns, data, time.Now()); err != nil {
+			return 0, w.fail(err)
+		}
+	}
+	if len(data) > 0 {
+		chunks := ingester.SplitBatch(data, w.Cfg.MaxBatchBytes)
+		for i, chunk := range chunks {
+			var batch ingester.StagedBatch
+			name := ingester.BatchName{Table: w.Name, Source: source, Part: part}
+			if len(chunks) > 1 {
+				name.Chunk = i + 1
+			}
+			attempts := 0
+			err := ig.DoRetry(
+				func() error {
+					var err error
+					attempts++
+					batch, err = ig.IngestBatch(threadNum, name, columns, chunk)
+					return err
+				})
+			if attempts > 1 {
+				logrus.Infof("Worker %s: batch %s took %d attempts", w.Name, name, attempts)
+			}
+			if err != nil {
+				w.batchFailed(source, err)
+				return 0, w.fail(err)
+			}
+			if w.ArchiveManifest != nil {
+				w.ArchiveManifest.Record(w.Name, source, target, batch)
+			}
+			atomic.AddInt64(&w.ingestedRows, int64(len(chunk)))
+		}
+	}
+	if w.limitReached() {
+		return len(data), errLimitReached
+	}
+	return len(data), nil
+}
+
+// limit keeps samplePercent of the rows, chosen by a hash of the split key
+// (or of the whole row) so that every run picks the same rows, skips the
+// first startFromRow rows and stops at the row limit. With several threads
+// the skipped and last rows are only approximately the first and last ones.
+func (w *Worker) limit(columns []string, data [][]interface{}) [][]interface{} {
+	if w.Cfg.SamplePercent > 0 && w.Cfg.SamplePercent < 100 {
+		keyIdx := -1
+		for i, column := range columns {
+			if w.Cfg.SourceSplitKey != "" && column == w.Cfg.SourceSplitKey {
+				keyIdx = i
+			}
+		}
+		var kept [][]interface{}
+		for _, row := range data {
+			h := fnv.New64a()
+			if keyIdx >= 0 {
+				fmt.Fprint(h, row[keyIdx])
+			} else {
+				fmt.Fprint(h, row...)
+			}
+			if float64(h.Sum64()%10000) < w.Cfg.SamplePercent*100 {
+				kept = append(kept, row)
+			}
+		}
+		data = kept
+	}
+	if w.Cfg.StartFromRow > 0 && atomic.LoadInt64(&w.skippedRows) < w.Cfg.StartFromRow {
+		skipped := atomic.AddInt64(&w.skippedRows, int64(len(data)))
+		if skip := int64(len(data)) - (skipped - w.Cfg.StartFromRow); skip > 0 {
+			if skip > int64(len(data)) {
+				skip = int64(len(data))
+			}
+			data = data[skip:]
+		}
+	}
+	if limit := w.rowLimit(); limit > 0 {
+		total := atomic.AddInt64(&w.limitedRows, int64(len(data)))
+		if over := total - limit; over > 0 {
+			keep := int64(len(data)) - over
+			if keep < 0 {
+				keep = 0
+			}
+			data = data[:keep]
+		}
+	}
+	return data
+}
+
+// rowLimit is the smallest of sampleRows and maxRows, 0 is unlimited.
+func (w *Worker) rowLimit() int64 {
+	limit := w.Cfg.MaxRows
+	if w.Cfg.SampleRows > 0 && (limit == 0 || w.Cfg.SampleRows < limit) {
+		limit = w.Cfg.SampleRows
+	}
+	return limit
+}
+
+func (w *Worker) limitReached() bool {
+	limit := w.rowLimit()
+	return limit > 0 && atomic.LoadInt64(&w.limitedRows) >= limit
+}
@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/archivertest"
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestSamplePercentIsDeterministic(t *testing.T) {
+	var data [][]interface{}
+	for i := 0; i < 10000; i++ {
+		data = append(data, []interface{}{int64(i), "x"})
+	}
+	columns := []string{"id", "name"}
+	w := &Worker{Cfg: &config.Config{SourceSplitKey: "id", SamplePercent: 10}}
+
+	first := w.limit(columns, data)
+	assert.InDelta(t, 1000, len(first), 150)
+	assert.Equal(t, first, w.limit(columns, data))
+
+	// the key decides, not the other columns
+	changed := [][]interface{}{{first[0][0], "y"}}
+	assert.Equal(t, 1, len(w.limit(columns, changed)))
+}
+
+func TestSampleRows(t *testing.T) {
+	w := &Worker{Cfg: &config.Config{SampleRows: 5}}
+	batch := [][]interface{}{{1}, {2}, {3}}
+	assert.Equal(t, 3, len(w.limit([]string{"id"}, batch)))
+	assert.False(t, w.limitReached())
+	assert.Equal(t, 2, len(w.limit([]string{"id"}, batch)))
+	assert.True(t, w.limitReached())
+	assert.Equal(t, 0, len(w.limit([]string{"id"}, batch)))
+}
+
+func TestStartFromRowAndMaxRows(t *testing.T) {
+	w := &Worker{Cfg: &config.Config{StartFromRow: 4, MaxRows: 3}}
+	batch := [][]interface{}{{1}, {2}, {3}}
+	assert.Equal(t, 0, len(w.limit([]string{"id"}, batch)))
+	assert.Equal(t, [][]interface{}{{2}, {3}}, w.limit([]string{"id"}, batch))
+	assert.False(t, w.limitReached())
+	assert.Equal(t, [][]interface{}{{1}}, w.limit([]string{"id"}, batch))
+	assert.True(t, w.limitReached())
+
+	w = &Worker{Cfg: &config.Config{SampleRows: 5, MaxRows: 2}}
+	assert.Equal(t, int64(2), w.rowLimit())
+}
+
+func TestIngestSplitsWideBatches(t *testing.T) {
+	ig := &archivertest.RecordingIngester{}
+	w := &Worker{Name: "shop.orders", Cfg: &config.Config{MaxBatchBytes: 16}}
+	batch := [][]interface{}{{"aaaaaaaa"}, {"bbbbbbbb"}, {"cccccccc"}}
+	n, err := w.ingest(ig, "archive.orders", "(id >= 1 and id < 4)", 0, 0, []string{"name"}, batch)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 3, ig.Rows())
+	batches := ig.Batches()
+	assert.Equal(t, 2, len(batches))
+	assert.Equal(t, []int{1, 2}, []int{batches[0].Name.Chunk, batches[1].Name.Chunk})
+}
@@ -0,0 +1,49 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// addMetadata appends the metadataColumns to columns and to every row of
+// data, which is copied: archived_at is now, source_table the file a row
+// was read from with file sources.
+func (w *Worker) addMetadata(source string, columns []string, data [][]interface{}, now time.Time) ([]string, [][]interface{}, error) {
+	values := make([]interface{}, len(w.Cfg.MetadataColumns))
+	for i, column := range w.Cfg.MetadataColumns {
+		if columnIndex(columns, column) >= 0 {
+			return nil, nil, fmt.Errorf("metadata column %s is a column of %s", column, w.Name)
+		}
+		switch column {
+		case "archive_run_id":
+			values[i] = w.Cfg.RunID
+		case "archived_at":
+			values[i] = now.UTC().Format("2006-01-02 15:04:05.000000")
+		case "source_host":
+			host := w.Cfg.SourceHost
+			if host == "" {
+				// local files
+				host, _ = os.Hostname()
+			}
+			values[i] = host
+		case "source_table":
+			if w.Cfg.IsFileSource() {
+				values[i] = source
+			} else {
+				values[i] = w.Name
+			}
+		}
+	}
+	withMetadata := make([]string, 0, len(columns)+len(values))
+	withMetadata = append(append(withMetadata, columns...), w.Cfg.MetadataColumns...)
+	rows := make([][]interface{}, len(data))
+	for i, row := range data {
+		if len(row) == 0 {
+			continue
+		}
+		rows[i] = make([]interface{}, 0, len(row)+len(values))
+		rows[i] = append(append(rows[i], row...), values...)
+	}
+	return withMetadata, rows, nil
+}
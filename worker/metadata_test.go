@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestAddMetadata(t *testing.T) {
+	cfg := &config.Config{
+		SourceHost:      "db1.internal",
+		RunID:           "20240610T061320-a1b2c3",
+		MetadataColumns: []string{"archive_run_id", "archived_at", "source_host", "source_table"},
+	}
+	w := &Worker{Name: "shop.orders", Cfg: cfg}
+	now := time.Date(2024, 6, 10, 6, 13, 20, 0, time.UTC)
+	data := [][]interface{}{{int64(1), "a"}}
+	columns, rows, err := w.addMetadata("(id >= 1 and id < 11)", []string{"id", "name"}, data, now)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id", "name", "archive_run_id", "archived_at", "source_host", "source_table"}, columns)
+	assert.Equal(t, []interface{}{int64(1), "a", "20240610T061320-a1b2c3", "2024-06-10 06:13:20.000000", "db1.internal", "shop.orders"}, rows[0])
+	assert.Equal(t, 2, len(data[0]))
+
+	// files
+	cfg.DatabaseType, cfg.MetadataColumns = "sftp", []string{"source_table"}
+	_, rows, err = w.addMetadata("/in/orders.csv", []string{"id", "name"}, data, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "/in/orders.csv", rows[0][2])
+
+	_, _, err = w.addMetadata("", []string{"id", "source_table"}, data, now)
+	assert.Error(t, err)
+}
@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"sync"
+)
+
+// pipeline is the pool of ingest threads of a worker: the reading threads
+// hand it the batches they read and go on reading while it serializes and
+// uploads them, in the serialize and upload pools of the ingester. A nil
+// pipeline ingests on the reading threads.
+type pipeline struct {
+	tasks   chan func()
+	pending sync.WaitGroup
+}
+
+// newPipeline starts the ingest threads of the worker, enough to keep the
+// serialize and upload pools busy at once, or returns nil when neither pool
+// is configured.
+func (w *Worker) newPipeline() *pipeline {
+	threads := w.Cfg.SerializeThreads + w.Cfg.UploadThreads
+	if threads == 0 {
+		return nil
+	}
+	p := &pipeline{tasks: make(chan func())}
+	for i := 0; i < threads; i++ {
+		go func() {
+			for task := range p.tasks {
+				task()
+				p.pending.Done()
+			}
+		}()
+	}
+	return p
+}
+
+// run runs task on an ingest thread, waiting while all of them are busy so
+// that the reads stay at most one batch per reading thread ahead.
+func (p *pipeline) run(task func()) {
+	if p == nil {
+		task()
+		return
+	}
+	p.pending.Add(1)
+	p.tasks <- task
+}
+
+// wait waits for the tasks run so far.
+func (p *pipeline) wait() {
+	if p != nil {
+		p.pending.Wait()
+	}
+}
+
+// close waits for the tasks run so far and stops the ingest threads.
+func (p *pipeline) close() {
+	if p != nil {
+		p.pending.Wait()
+		close(p.tasks)
+	}
+}
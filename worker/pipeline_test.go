@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/archivertest"
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+)
+
+// slowIngester takes a while to ingest a batch and records how many batches
+// it ingested at once at most.
+type slowIngester struct {
+	archivertest.RecordingIngester
+	mu      sync.Mutex
+	running int
+	most    int
+}
+
+func (ig *slowIngester) IngestBatch(threadNum int, name ingester.BatchName, columns []string, batch [][]interface{}) (ingester.StagedBatch, error) {
+	ig.mu.Lock()
+	ig.running++
+	if ig.running > ig.most {
+		ig.most = ig.running
+	}
+	ig.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	ig.mu.Lock()
+	ig.running--
+	ig.mu.Unlock()
+	return ig.RecordingIngester.IngestBatch(threadNum, name, columns, batch)
+}
+
+func TestStagedPipeline(t *testing.T) {
+	read := &rangeSource{read: make(map[uint64]int)}
+	src := &slowRangeSource{rangeSource: read, min: 1, max: 300}
+	cfg := &config.Config{SourceSplitKey: "id", BatchSize: 10, MaxThread: 1, SerializeThreads: 2, UploadThreads: 2, SourceRetryAttempts: 1}
+	ig := &slowIngester{}
+	checkpoint, err := LoadCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"))
+	assert.NoError(t, err)
+	w := NewWorker(cfg, "shop.orders", ig, src)
+	w.Checkpoint = checkpoint
+	w.Run(context.Background())
+	assert.NoError(t, w.Err())
+
+	// one thread reads, the batches are ingested by several
+	assert.Equal(t, 300, ig.Rows())
+	assert.True(t, ig.most > 1 && ig.most <= 4, "at most %d batches ingested at once", ig.most)
+	for id := uint64(1); id <= 300; id++ {
+		assert.Equal(t, 1, read.read[id], "key %d", id)
+	}
+	// the ranges are recorded once ingested
+	assert.Equal(t, &TableCheckpoint{Next: 301}, checkpoint.Tables["shop.orders"])
+}
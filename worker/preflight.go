@@ -0,0 +1,38 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// CheckPurge refuses a job with deleteAfterSync or moveAfterSync whose
+// source user may not delete what the job archives, which would only fail
+// once everything is archived. Sources that can't tell are let through with
+// a warning.
+func CheckPurge(ctx context.Context, cfg *config.Config, src source.Sourcer) error {
+	if !cfg.DeleteAfterSync && cfg.MoveAfterSync == "" {
+		return nil
+	}
+	what := fmt.Sprintf("%s.%s", cfg.SourceDB, cfg.SourceTable)
+	if cfg.IsFileSource() {
+		what = cfg.SourcePath
+	}
+	pc, ok := src.(source.PrivilegeChecker)
+	if !ok {
+		logrus.Warnf("can't check that %s sources may delete %s, make sure sourceUser may", cfg.DatabaseType, what)
+		return nil
+	}
+	granted, err := pc.HasTablePrivilege(ctx, "DELETE")
+	if err != nil {
+		return fmt.Errorf("check the DELETE privilege on %s failed, refusing to purge what can't be checked: %w", what, err)
+	}
+	if !granted {
+		return fmt.Errorf("%s may not delete %s, grant it DELETE or disable deleteAfterSync and moveAfterSync", cfg.SourceUser, what)
+	}
+	return nil
+}
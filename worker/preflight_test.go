@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/archivertest"
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+type grantSource struct {
+	source.TableSourcer
+	granted bool
+	err     error
+}
+
+func (s *grantSource) HasTablePrivilege(ctx context.Context, privilege string) (bool, error) {
+	return s.granted, s.err
+}
+
+func TestCheckPurge(t *testing.T) {
+	cfg := &config.Config{SourceDB: "shop", SourceTable: "orders", SourceUser: "archiver"}
+	assert.NoError(t, CheckPurge(context.Background(), cfg, &grantSource{}))
+
+	cfg.DeleteAfterSync = true
+	assert.NoError(t, CheckPurge(context.Background(), cfg, &grantSource{granted: true}))
+	assert.Error(t, CheckPurge(context.Background(), cfg, &grantSource{}))
+	assert.Error(t, CheckPurge(context.Background(), cfg, &grantSource{granted: true, err: errors.New("access denied")}))
+	// sources that can't tell are let through
+	assert.NoError(t, CheckPurge(context.Background(), cfg, archivertest.NewFakeSource("shop", "orders", 0)))
+}
@@ -0,0 +1,235 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// Quality checks the quality rules of a job on a sample of the rows its
+// workers ingest.
+type Quality struct {
+	mu      sync.Mutex
+	percent float64
+	rows    uint64 // rows offered to the sample so far
+	rules   []*qualityRule
+}
+
+type qualityRule struct {
+	config.QualityRule
+	pattern    *regexp.Regexp
+	seen       map[uint64]struct{} // hashes of the unique keys checked so far
+	checked    int64
+	violations int64
+	example    string
+}
+
+// QualityResult is the outcome of a rule, in the status file.
+type QualityResult struct {
+	Rule       string  `json:"rule"`
+	Checked    int64   `json:"checked"`
+	Violations int64   `json:"violations"`
+	Percent    float64 `json:"percent"`
+	Outcome    string  `json:"outcome"`           // passed, warned or failed
+	Example    string  `json:"example,omitempty"` // values of the first violation
+}
+
+// NewQuality returns the checker of the quality rules of cfg, nil when it
+// has none.
+func NewQuality(cfg *config.Config) *Quality {
+	if len(cfg.QualityRules) == 0 {
+		return nil
+	}
+	q := &Quality{percent: cfg.QualitySamplePercent}
+	for _, rule := range cfg.QualityRules {
+		r := &qualityRule{QualityRule: rule}
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("%s(%s)", r.Type, strings.Join(r.Columns, ", "))
+		}
+		switch r.Type {
+		case "regex":
+			r.pattern = regexp.MustCompile(r.Pattern)
+		case "unique":
+			r.seen = make(map[uint64]struct{})
+		}
+		q.rules = append(q.rules, r)
+	}
+	return q
+}
+
+// Check checks the sampled rows of a batch, evenly spread over the rows of
+// the job. Rules whose columns are not all in the batch are skipped.
+func (q *Quality) Check(columns []string, rows [][]interface{}) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	indexes := make([][]int, len(q.rules))
+	for i, r := range q.rules {
+		for _, column := range r.Columns {
+			idx := columnIndex(columns, column)
+			if idx < 0 {
+				indexes[i] = nil
+				break
+			}
+			indexes[i] = append(indexes[i], idx)
+		}
+	}
+	values := make([]interface{}, 0, len(columns))
+	for _, row := range rows {
+		n := q.rows
+		q.rows++
+		if len(row) == 0 || uint64(float64(n+1)*q.percent/100) == uint64(float64(n)*q.percent/100) {
+			continue
+		}
+		for i, r := range q.rules {
+			if indexes[i] == nil {
+				continue
+			}
+			values = values[:0]
+			for _, idx := range indexes[i] {
+				values = append(values, row[idx])
+			}
+			r.check(values)
+		}
+	}
+}
+
+func (r *qualityRule) check(values []interface{}) {
+	r.checked++
+	if r.violated(values) {
+		if r.violations == 0 {
+			r.example = formatValues(values)
+		}
+		r.violations++
+	}
+}
+
+func (r *qualityRule) violated(values []interface{}) bool {
+	switch r.Type {
+	case "not_null":
+		for _, v := range values {
+			if v == nil {
+				return true
+			}
+		}
+	case "unique":
+		h := fnv.New64a()
+		for _, v := range values {
+			h.Write([]byte(formatValue(v)))
+			h.Write([]byte{0})
+		}
+		key := h.Sum64()
+		if _, ok := r.seen[key]; ok {
+			return true
+		}
+		r.seen[key] = struct{}{}
+	case "range":
+		for _, v := range values {
+			if v == nil {
+				continue
+			}
+			f, ok := toFloat(v)
+			if !ok || r.Min != nil && f < *r.Min || r.Max != nil && f > *r.Max {
+				return true
+			}
+		}
+	case "regex":
+		for _, v := range values {
+			if v != nil && !r.pattern.MatchString(formatValue(v)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Results are the outcomes of the rules so far.
+func (q *Quality) Results() []QualityResult {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	results := make([]QualityResult, 0, len(q.rules))
+	for _, r := range q.rules {
+		result := QualityResult{Rule: r.Name, Checked: r.checked, Violations: r.violations, Example: r.example, Outcome: "passed"}
+		if r.checked > 0 {
+			result.Percent = 100 * float64(r.violations) / float64(r.checked)
+		}
+		warn := 0.0
+		if r.WarnPercent != nil {
+			warn = *r.WarnPercent
+		}
+		switch {
+		case r.FailPercent != nil && result.Percent > *r.FailPercent:
+			result.Outcome = "failed"
+		case r.violations > 0 && result.Percent > warn:
+			result.Outcome = "warned"
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case nil:
+		return "NULL"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func formatValues(values []interface{}) string {
+	formatted := make([]string, len(values))
+	for i, v := range values {
+		formatted[i] = formatValue(v)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string, []byte:
+		f, err := strconv.ParseFloat(strings.TrimSpace(formatValue(v)), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
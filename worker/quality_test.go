@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestQualityRules(t *testing.T) {
+	one, ten := 1.0, 10.0
+	cfg := &config.Config{
+		QualitySamplePercent: 100,
+		QualityRules: []config.QualityRule{
+			{Type: "not_null", Columns: []string{"email"}, FailPercent: &ten},
+			{Type: "unique", Columns: []string{"id"}},
+			{Name: "amount", Type: "range", Columns: []string{"amount"}, Min: new(float64), WarnPercent: &ten},
+			{Type: "regex", Columns: []string{"email"}, Pattern: `@`, FailPercent: &one},
+			{Type: "not_null", Columns: []string{"missing"}},
+		},
+	}
+	q := NewQuality(cfg)
+	columns := []string{"id", "email", "amount"}
+	var rows [][]interface{}
+	for i := 0; i < 20; i++ {
+		rows = append(rows, []interface{}{int64(i), "a@b.c", "12.5"})
+	}
+	rows[3][1] = nil
+	rows[4][0] = int64(3)
+	rows[5][2] = int64(-1)
+	rows[6][1] = "nobody"
+	q.Check(columns, rows)
+
+	results := q.Results()
+	assert.Equal(t, QualityResult{Rule: "not_null(email)", Checked: 20, Violations: 1, Percent: 5, Outcome: "warned", Example: "NULL"}, results[0])
+	assert.Equal(t, QualityResult{Rule: "unique(id)", Checked: 20, Violations: 1, Percent: 5, Outcome: "warned", Example: "3"}, results[1])
+	assert.Equal(t, "passed", results[2].Outcome)
+	assert.Equal(t, int64(1), results[2].Violations)
+	assert.Equal(t, QualityResult{Rule: "regex(email)", Checked: 20, Violations: 1, Percent: 5, Outcome: "failed", Example: "nobody"}, results[3])
+	assert.Equal(t, int64(0), results[4].Checked)
+}
+
+func TestQualitySample(t *testing.T) {
+	q := NewQuality(&config.Config{QualitySamplePercent: 10, QualityRules: []config.QualityRule{{Type: "not_null", Columns: []string{"id"}}}})
+	rows := make([][]interface{}, 1000)
+	for i := range rows {
+		rows[i] = []interface{}{i}
+	}
+	q.Check([]string{"id"}, rows[:500])
+	q.Check([]string{"id"}, rows[500:])
+	assert.Equal(t, int64(100), q.Results()[0].Checked)
+	var none *Quality
+	none.Check([]string{"id"}, rows)
+	assert.Nil(t, none.Results())
+}
@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// RangeRepair is a range of databendTable replaced by the rows of the source.
+type RangeRepair struct {
+	Condition string
+	Deleted   int // rows of the range in the target before
+	Inserted  int // rows of the range read from the source
+}
+
+// repairQueries replace the rows of target in the range of condition by
+// those of staging.
+func repairQueries(target, staging, condition string) []string {
+	return []string{
+		fmt.Sprintf("DELETE FROM %s WHERE %s", target, condition),
+		// the staging table is created LIKE target, its columns are in the same order
+		fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", target, staging),
+	}
+}
+
+// RepairRanges reloads the ranges of conditions of the source of cfg into
+// databendTable. The rows of a range are read and loaded into a staging copy
+// of the target like the job does, then replace the rows of the range in
+// the target in one transaction, so the rows only in the target go too.
+func RepairRanges(ctx context.Context, cfg *config.Config, src source.Sourcer, conditions []string) ([]RangeRepair, error) {
+	target := cfg.DatabendTable
+	staging := target + "_repair_staging"
+	if err := ingester.Exec(cfg, fmt.Sprintf("DROP TABLE IF EXISTS %s", staging)); err != nil {
+		return nil, err
+	}
+	if err := ingester.Exec(cfg, fmt.Sprintf("CREATE TABLE %s LIKE %s", staging, target)); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := ingester.Exec(cfg, fmt.Sprintf("DROP TABLE IF EXISTS %s", staging)); err != nil {
+			logrus.Warnf("drop %s failed: %v", staging, err)
+		}
+	}()
+	if !ingester.CurrentFeatures().Transactions {
+		logrus.Warnf("Databend has no transactions, readers may see the repaired ranges of %s empty for a moment", target)
+	}
+
+	cfgCopy := *cfg
+	cfgCopy.DiffSync = false
+	cfgCopy.DatabendTable = staging
+	repairs := make([]RangeRepair, 0, len(conditions))
+	for _, condition := range conditions {
+		if err := ctx.Err(); err != nil {
+			return repairs, err
+		}
+		if err := ingester.Exec(cfg, "TRUNCATE TABLE "+staging); err != nil {
+			return repairs, err
+		}
+		w := NewWorker(&cfgCopy, target, ingester.NewDatabendIngester(&cfgCopy), src)
+		w.stepBatchWithCondition(ctx, 0, condition)
+		if err := w.Err(); err != nil {
+			return repairs, fmt.Errorf("reload %s: %w", condition, err)
+		}
+		r := RangeRepair{Condition: condition}
+		var err error
+		if r.Inserted, err = ingester.QueryCount(cfg, "SELECT count(*) FROM "+staging); err != nil {
+			return repairs, err
+		}
+		if r.Deleted, err = ingester.QueryCount(cfg, fmt.Sprintf("SELECT count(*) FROM %s WHERE %s", target, condition)); err != nil {
+			return repairs, err
+		}
+		if err := ingester.ExecAll(cfg, repairQueries(target, staging, condition)...); err != nil {
+			return repairs, fmt.Errorf("replace %s: %w", condition, err)
+		}
+		logrus.Infof("repaired %s of %s: deleted %d and inserted %d rows", condition, target, r.Deleted, r.Inserted)
+		repairs = append(repairs, r)
+	}
+	return repairs, nil
+}
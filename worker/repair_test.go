@@ -0,0 +1,14 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestRepairQueries(t *testing.T) {
+	assert.Equal(t, []string{
+		"DELETE FROM archive.orders WHERE (id >= 101 and id < 201)",
+		"INSERT INTO archive.orders SELECT * FROM archive.orders_repair_staging",
+	}, repairQueries("archive.orders", "archive.orders_repair_staging", "(id >= 101 and id < 201)"))
+}
@@ -0,0 +1,163 @@
+package worker
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+)
+
+// ReadArchiveManifest reads a manifest written by Finish, checking its
+// signature first when publicKey is set.
+func ReadArchiveManifest(path string, publicKey ed25519.PublicKey) (*ArchiveManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if publicKey != nil {
+		if err := VerifyArchiveManifest(data, publicKey); err != nil {
+			return nil, err
+		}
+	}
+	var m ArchiveManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrapf(err, "parse archive manifest %s failed", path)
+	}
+	return &m, nil
+}
+
+// LoadEd25519PublicKey reads a PKIX PEM public key, e.g. exported with
+// openssl pkey -pubout from the archiveManifestKey.
+func LoadEd25519PublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM key in %s", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse archive manifest public key failed")
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("archive manifest public key must be an ed25519 key, got %T", key)
+	}
+	return edKey, nil
+}
+
+// TargetBatches returns the batches archived into target, which may be empty
+// when the manifest has a single target table, along with that table.
+func (m *ArchiveManifest) TargetBatches(target string) (string, []ArchiveBatch, error) {
+	targets := make(map[string]bool)
+	for _, batch := range m.Batches {
+		targets[batch.Target] = true
+	}
+	if target == "" {
+		if len(targets) != 1 {
+			return "", nil, fmt.Errorf("manifest has %d target tables, choose the one to restore", len(targets))
+		}
+		for t := range targets {
+			target = t
+		}
+	}
+	var batches []ArchiveBatch
+	for _, batch := range m.Batches {
+		if batch.Target == target {
+			batches = append(batches, batch)
+		}
+	}
+	if len(batches) == 0 {
+		return "", nil, fmt.Errorf("manifest has no batches archived into %s", target)
+	}
+	return target, batches, nil
+}
+
+// Restore reloads the staged batches the manifest recorded for target into
+// table, a new table like target, and checks that table ends up with as
+// many rows as were archived. The staged files are only still there when
+// the job ran with copyPurge false.
+func (m *ArchiveManifest) Restore(cfg *config.Config, target, table string, createTable bool) error {
+	target, batches, err := m.TargetBatches(target)
+	if err != nil {
+		return err
+	}
+	var stages []string
+	rows := 0
+	for _, batch := range batches {
+		if strings.HasSuffix(batch.Stage, ".gpg") {
+			return fmt.Errorf("staged file %s is encrypted, decrypt it with the key of the job before restoring", batch.Stage)
+		}
+		stages = append(stages, batch.Stage)
+		rows += batch.Rows
+	}
+	if createTable {
+		if err := ingester.Exec(cfg, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s LIKE %s", table, target)); err != nil {
+			return err
+		}
+	}
+	logrus.Infof("restoring %d batches (%d rows) of %s into %s", len(stages), rows, target, table)
+	if err := ingester.CopyStagedFiles(cfg, table, stages); err != nil {
+		return err
+	}
+	return checkRestoredRows(cfg, table, rows)
+}
+
+// RestoreParquet reloads Parquet files exported to location into table, a
+// new table like target, and checks its rows against the manifest when there
+// is one.
+func RestoreParquet(cfg *config.Config, m *ArchiveManifest, location, target, table string, createTable bool) error {
+	rows := -1
+	if m != nil {
+		var batches []ArchiveBatch
+		var err error
+		target, batches, err = m.TargetBatches(target)
+		if err != nil {
+			return err
+		}
+		rows = 0
+		for _, batch := range batches {
+			rows += batch.Rows
+		}
+	}
+	if createTable {
+		if target == "" {
+			return errors.New("the table to restore is needed to create a table like it")
+		}
+		if err := ingester.Exec(cfg, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s LIKE %s", table, target)); err != nil {
+			return err
+		}
+	}
+	if err := ingester.CopyParquet(cfg, table, location); err != nil {
+		return err
+	}
+	if rows < 0 {
+		return nil
+	}
+	return checkRestoredRows(cfg, table, rows)
+}
+
+func checkRestoredRows(cfg *config.Config, table string, rows int) error {
+	cfgCopy := *cfg
+	cfgCopy.DatabendTable = table
+	restored, err := ingester.NewDatabendIngester(&cfgCopy).GetAllSyncedCount()
+	if err != nil {
+		return err
+	}
+	if restored != rows {
+		return fmt.Errorf("%s has %d rows after restore, the manifest recorded %d", table, restored, rows)
+	}
+	logrus.Infof("restored %d rows into %s", restored, table)
+	return nil
+}
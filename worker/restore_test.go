@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+)
+
+func TestReadArchiveManifest(t *testing.T) {
+	dir := t.TempDir()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	assert.NoError(t, err)
+	keyFile := filepath.Join(dir, "key.pem")
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	der, err = x509.MarshalPKIXPublicKey(public)
+	assert.NoError(t, err)
+	publicKeyFile := filepath.Join(dir, "key.pub")
+	assert.NoError(t, os.WriteFile(publicKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644))
+
+	m := NewArchiveManifest()
+	m.Record("db.a", "(id >= 0 and id < 10)", "archive.a", ingester.StagedBatch{Stage: "@~/a1", SHA256: "aa", Rows: 10})
+	m.Record("db.a", "(id >= 10 and id < 20)", "archive.a", ingester.StagedBatch{Stage: "@~/a2", SHA256: "ab", Rows: 5})
+	m.Record("db.b", "(id >= 0 and id < 10)", "archive.b", ingester.StagedBatch{Stage: "@~/b1", SHA256: "ba", Rows: 7})
+	m.SnapshotIDs["archive.a"] = "snap-a"
+	m.SnapshotIDs["archive.b"] = "snap-b"
+	cfg := &config.Config{ArchiveManifestFile: filepath.Join(dir, "manifest.json"), ArchiveManifestKey: keyFile}
+	assert.NoError(t, m.Finish(cfg))
+
+	publicKey, err := LoadEd25519PublicKey(publicKeyFile)
+	assert.NoError(t, err)
+	read, err := ReadArchiveManifest(cfg.ArchiveManifestFile, publicKey)
+	assert.NoError(t, err)
+
+	target, batches, err := read.TargetBatches("archive.a")
+	assert.NoError(t, err)
+	assert.Equal(t, "archive.a", target)
+	assert.Equal(t, 2, len(batches))
+	assert.Equal(t, "@~/a1", batches[0].Stage)
+	_, _, err = read.TargetBatches("")
+	assert.Error(t, err)
+	_, _, err = read.TargetBatches("archive.c")
+	assert.Error(t, err)
+
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	_, err = ReadArchiveManifest(cfg.ArchiveManifestFile, other)
+	assert.Error(t, err)
+}
@@ -0,0 +1,158 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+)
+
+// EnforceRetention deletes the rows, and drops the periodic tables, that are
+// older than the retention policies of cfg at now.
+func EnforceRetention(cfg *config.Config, now time.Time) error {
+	loc, err := databendLocation(cfg)
+	if err != nil {
+		return err
+	}
+	for _, p := range cfg.RetentionPolicies {
+		period, err := p.RetentionPeriod()
+		if err != nil {
+			return err
+		}
+		cutoff := now.Add(-period).In(loc)
+		if p.Table != "" {
+			query := fmt.Sprintf("DELETE FROM %s WHERE %s < '%s'", p.Table, p.TimeColumn, cutoff.Format("2006-01-02 15:04:05"))
+			logrus.Infof("retention: %s", query)
+			if err := ingester.Exec(cfg, query); err != nil {
+				return fmt.Errorf("retention of %s: %w", p.Table, err)
+			}
+			continue
+		}
+		tables, err := ingester.ListTables(cfg, p.Database)
+		if err != nil {
+			return fmt.Errorf("retention of %s: %w", p.Database, err)
+		}
+		for _, table := range expiredTables(p, tables, cutoff) {
+			logrus.Infof("retention: drop %s.%s", p.Database, table)
+			if err := ingester.Exec(cfg, fmt.Sprintf("DROP TABLE IF EXISTS %s.%s", p.Database, table)); err != nil {
+				return fmt.Errorf("retention of %s.%s: %w", p.Database, table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// databendLocation is the location of the time literals compared with the
+// columns of the target, its databendTimezone or UTC.
+func databendLocation(cfg *config.Config) (*time.Location, error) {
+	if cfg.DatabendTimezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(cfg.DatabendTimezone)
+}
+
+// expiredTables returns the tables matching the pattern of p whose time is
+// before cutoff, the time is parsed in the location of cutoff.
+func expiredTables(p config.RetentionPolicy, tables []string, cutoff time.Time) []string {
+	re := regexp.MustCompile(p.TablePattern)
+	var expired []string
+	for _, table := range tables {
+		m := re.FindStringSubmatch(table)
+		if m == nil {
+			continue
+		}
+		t, err := time.ParseInLocation(p.TableTimeLayout, m[1], cutoff.Location())
+		if err != nil {
+			logrus.Warnf("retention: skip %s, %v", table, err)
+			continue
+		}
+		if t.Before(cutoff) {
+			expired = append(expired, table)
+		}
+	}
+	return expired
+}
+
+// configPollInterval is how often RunRetention checks its config file.
+var configPollInterval = 10 * time.Second
+
+// RunRetention enforces the retention policies of cfg, then again every
+// RetentionInterval until ctx is done when it is set. Meanwhile it reloads
+// the schedule and the policies when configFile changes. interval, when set,
+// overrides RetentionInterval, also over the reloaded ones.
+func RunRetention(ctx context.Context, cfg *config.Config, configFile, interval string) error {
+	if interval != "" {
+		cfg.RetentionInterval = interval
+	}
+	if cfg.RetentionInterval == "" {
+		return EnforceRetention(cfg, time.Now())
+	}
+	var fw *config.FileWatcher
+	if configFile != "" {
+		fw = config.NewFileWatcher(configFile)
+	}
+	poll := time.NewTicker(configPollInterval)
+	defer poll.Stop()
+	for {
+		if err := EnforceRetention(cfg, time.Now()); err != nil {
+			// the next run retries what is left
+			logrus.Errorf("enforce retention failed: %v", err)
+		}
+		if cfg.RetentionInterval == "" {
+			// unscheduled by a reload
+			return nil
+		}
+		every, err := time.ParseDuration(cfg.RetentionInterval)
+		if err != nil {
+			return err
+		}
+		next := time.NewTimer(every)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				next.Stop()
+				return nil
+			case <-next.C:
+				break wait
+			case <-poll.C:
+				if fw == nil || !fw.Changed() || !reloadConfig(cfg, configFile, interval) || cfg.RetentionInterval == "" {
+					continue
+				}
+				if d, err := time.ParseDuration(cfg.RetentionInterval); err == nil && d != every {
+					// the new schedule starts from now
+					every = d
+					next.Reset(every)
+				}
+			}
+		}
+	}
+}
+
+// reloadConfig applies the reloadable settings of configFile to cfg but the
+// interval overriding retentionInterval, it reports whether any changed.
+func reloadConfig(cfg *config.Config, configFile, interval string) bool {
+	applied, rejected, err := config.Reload(cfg, configFile)
+	if err != nil {
+		logrus.Errorf("reload %s failed, keeping the current config: %v", configFile, err)
+		return false
+	}
+	if interval != "" {
+		cfg.RetentionInterval = interval
+		applied = slices.DeleteFunc(applied, func(name string) bool { return name == "retentionInterval" })
+	}
+	if len(rejected) > 0 {
+		logrus.Warnf("reload %s: %s can't change without a restart, keeping their current values", configFile, strings.Join(rejected, ", "))
+	}
+	if len(applied) > 0 {
+		logrus.Infof("reload %s: applied %s", configFile, strings.Join(applied, ", "))
+	}
+	return len(applied) > 0
+}
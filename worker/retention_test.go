@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestExpiredTables(t *testing.T) {
+	p := config.RetentionPolicy{Database: "archive", TablePattern: `^orders_(\d{6})$`, TableTimeLayout: "200601", Period: "365d"}
+	tables := []string{"orders_202301", "orders_202312", "orders_202401", "orders_2024xx", "orders", "customers_202001"}
+	cutoff := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []string{"orders_202301", "orders_202312"}, expiredTables(p, tables, cutoff))
+}
+
+func TestRunRetentionKeepsIntervalOverride(t *testing.T) {
+	defer func(poll time.Duration) { configPollInterval = poll }(configPollInterval)
+	configPollInterval = 10 * time.Millisecond
+
+	configFile := filepath.Join(t.TempDir(), "conf.json")
+	write := func(content string) {
+		assert.NoError(t, os.WriteFile(configFile, []byte(content), 0o644))
+	}
+	write(`{"databaseType": "mysql", "sourceSplitKey": "id", "sourceWhereCondition": "1=1", "databendTable": "archive.orders"}`)
+	cfg, err := config.LoadConfig(configFile)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- RunRetention(ctx, cfg, configFile, "1h") }()
+
+	// let RunRetention start watching the file
+	time.Sleep(50 * time.Millisecond)
+	// the reloaded file has no retentionInterval, the -interval stays
+	write(`{"databaseType": "mysql", "sourceSplitKey": "id", "sourceWhereCondition": "1=1", "databendTable": "archive.orders",
+		"retentionPolicies": [{"table": "archive.orders", "timeColumn": "created_at", "period": "90d"}]}`)
+	os.Chtimes(configFile, time.Now(), time.Now().Add(time.Second))
+	select {
+	case err := <-done:
+		t.Fatalf("RunRetention returned %v after the reload", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Equal(t, "1h", cfg.RetentionInterval)
+	assert.Len(t, cfg.RetentionPolicies, 1)
+}
@@ -0,0 +1,131 @@
+package worker
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/internal/faults"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// sourceRetryDelay is the first delay between two attempts of a batch read,
+// it doubles up to a minute.
+var sourceRetryDelay = time.Second
+
+// queryTableData reads a batch from the source, and reads it again after a
+// transient error such as a deadlock, a connection reset or "server has gone
+// away". The connection pools of the SQL sources drop broken connections, so
+// the next attempt reconnects. A batch being read when ctx is done is read
+// to its end within stopGracePeriod, like the batches being ingested, and
+// cancelled past it; every attempt gives up after sourceQueryTimeout.
+func (w *Worker) queryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+	var (
+		data    [][]interface{}
+		columns []string
+	)
+	reader, ok := w.Src.(source.SourceReader)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s sources are not read by condition", w.Cfg.DatabaseType)
+	}
+	attempts := w.Cfg.SourceRetryAttempts
+	if attempts < 1 {
+		// retry-go retries forever with 0 attempts
+		attempts = 1
+	}
+	timeout, _ := time.ParseDuration(w.Cfg.SourceQueryTimeout)
+	grace, _ := time.ParseDuration(w.Cfg.StopGracePeriod)
+	ctx, cancel := graceContext(ctx, grace)
+	defer cancel()
+	startTime := time.Now()
+	defer func() {
+		ingester.RecordPhase(ingester.PhaseRead, time.Since(startTime))
+		w.ReadLatency.Record(w.Name, conditionSql, time.Since(startTime), time.Now())
+	}()
+	var loop ingester.RetryLoop
+	err := retry.Do(
+		func() error {
+			attemptCtx := ctx
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				attemptCtx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			if err := faults.Inject(faults.Query); err != nil {
+				return err
+			}
+			var err error
+			data, columns, err = reader.QueryTableData(attemptCtx, threadNum, conditionSql)
+			return err
+		},
+		retry.RetryIf(func(err error) bool {
+			return isTransientSourceError(err) && loop.Retry()
+		}),
+		retry.OnRetry(func(n uint, err error) {
+			logrus.Warnf("thread-%d: attempt %d to read %s failed, retrying: %v", threadNum, n+1, conditionSql, err)
+		}),
+		retry.Attempts(uint(attempts)),
+		retry.Delay(sourceRetryDelay),
+		retry.MaxDelay(time.Minute),
+		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
+	)
+	return data, columns, loop.Done(err)
+}
+
+// transientSourceErrors are the messages of the errors worth retrying when
+// the driver doesn't return a typed error.
+var transientSourceErrors = []string{
+	"server has gone away",
+	"lost connection",
+	"connection reset",
+	"broken pipe",
+	"bad connection",
+	"deadlock",
+	"i/o timeout",
+	"connection refused",
+}
+
+func isTransientSourceError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, faults.ErrInjected) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// deadlock, lock wait timeout
+		return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// serialization failure, deadlock, connection exceptions, admin shutdown
+		return pqErr.Code == "40001" || pqErr.Code == "40P01" || pqErr.Code.Class() == "08" || pqErr.Code == "57P01"
+	}
+	message := strings.ToLower(err.Error())
+	for _, transient := range transientSourceErrors {
+		if strings.Contains(message, transient) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/archivertest"
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/errcode"
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/internal/faults"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+type flakySource struct {
+	source.TableSourcer
+	errs  []error
+	calls int
+}
+
+func (s *flakySource) QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+	s.calls++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return nil, nil, err
+	}
+	return [][]interface{}{{int64(1)}}, []string{"id"}, nil
+}
+
+func TestQueryTableDataRetriesTransientErrors(t *testing.T) {
+	defer func(delay time.Duration) { sourceRetryDelay = delay }(sourceRetryDelay)
+	sourceRetryDelay = 0
+	src := &flakySource{errs: []error{
+		mysql.ErrInvalidConn,
+		&mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"},
+		fmt.Errorf("read rows: %w", errors.New("Error 2006: MySQL server has gone away")),
+	}}
+	w := &Worker{Cfg: &config.Config{SourceRetryAttempts: 5}, Src: src}
+	data, columns, err := w.queryTableData(context.Background(), 1, "id >= 1 and id < 2")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, src.calls)
+	assert.Equal(t, []string{"id"}, columns)
+	assert.Equal(t, 1, len(data))
+
+	src = &flakySource{errs: []error{errors.New("Error 1146: Table 'db.orders' doesn't exist")}}
+	w.Src = src
+	_, _, err = w.queryTableData(context.Background(), 1, "id >= 1 and id < 2")
+	assert.Error(t, err)
+	assert.Equal(t, 1, src.calls)
+
+	src = &flakySource{errs: []error{mysql.ErrInvalidConn, mysql.ErrInvalidConn}}
+	w = &Worker{Cfg: &config.Config{SourceRetryAttempts: 2}, Src: src}
+	_, _, err = w.queryTableData(context.Background(), 1, "id >= 1 and id < 2")
+	assert.Equal(t, mysql.ErrInvalidConn, err)
+	assert.Equal(t, 2, src.calls)
+}
+
+func TestRetryBudget(t *testing.T) {
+	defer func(delay time.Duration) { sourceRetryDelay = delay }(sourceRetryDelay)
+	sourceRetryDelay = 0
+	defer ingester.ConfigureRetryBudget(&config.Config{})
+	cfg := &config.Config{SourceRetryAttempts: 5, RetryBudget: 3}
+	ingester.ConfigureRetryBudget(cfg)
+
+	src := &flakySource{errs: []error{mysql.ErrInvalidConn, mysql.ErrInvalidConn}}
+	w := &Worker{Cfg: cfg, Src: src}
+	_, _, err := w.queryTableData(context.Background(), 1, "id >= 1 and id < 2")
+	assert.NoError(t, err)
+
+	// one retry left for the whole job
+	src.errs = []error{mysql.ErrInvalidConn, mysql.ErrInvalidConn, mysql.ErrInvalidConn}
+	_, _, err = w.queryTableData(context.Background(), 1, "id >= 11 and id < 12")
+	assert.True(t, errors.Is(err, errcode.ErrRetryBudget))
+	assert.True(t, errors.Is(err, mysql.ErrInvalidConn))
+	assert.Equal(t, errcode.RetryBudget, errcode.CodeOf(sourceError(err)))
+	assert.Equal(t, 5, src.calls)
+}
+
+// contextSource reads a batch in delay, or until ctx is done.
+type contextSource struct {
+	source.TableSourcer
+	delay    time.Duration
+	deadline bool
+	err      error
+}
+
+func (s *contextSource) QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+	_, s.deadline = ctx.Deadline()
+	select {
+	case <-time.After(s.delay):
+		s.err = nil
+		return nil, []string{"id"}, nil
+	case <-ctx.Done():
+		s.err = ctx.Err()
+		return nil, nil, s.err
+	}
+}
+
+func TestQueryTableDataContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	src := &contextSource{}
+	w := &Worker{Cfg: &config.Config{SourceRetryAttempts: 1, SourceQueryTimeout: "1m", StopGracePeriod: "1m"}, Src: src}
+	_, _, err := w.queryTableData(ctx, 1, "id >= 1 and id < 2")
+	assert.NoError(t, err)
+	// a read in flight is finished when the job stops, within the timeout
+	assert.NoError(t, src.err)
+	assert.True(t, src.deadline)
+
+	// without a grace period the cancellation reaches the source
+	src = &contextSource{delay: time.Minute}
+	w = &Worker{Cfg: &config.Config{SourceRetryAttempts: 3}, Src: src}
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, _, err = w.queryTableData(ctx, 1, "id >= 1 and id < 2")
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, errors.Is(src.err, context.Canceled))
+	assert.False(t, src.deadline)
+
+	// a hung read is cancelled once the grace period is over
+	w.Cfg.StopGracePeriod = "20ms"
+	startTime := time.Now()
+	_, _, err = w.queryTableData(ctx, 1, "id >= 1 and id < 2")
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, errors.Is(src.err, context.Canceled))
+	assert.True(t, time.Since(startTime) >= 20*time.Millisecond)
+}
+
+func TestInjectedReadFaults(t *testing.T) {
+	defer func(delay time.Duration) { sourceRetryDelay = delay }(sourceRetryDelay)
+	sourceRetryDelay = 0
+	faults.Reset()
+	defer faults.Reset()
+	faults.FailCalls(faults.Query, 2, 5, 6)
+
+	src := &rangeSource{read: make(map[uint64]int)}
+	cfg := &config.Config{SourceSplitKey: "id", BatchSize: 10, MaxThread: 1, SourceRetryAttempts: 3}
+	checkpoint, err := LoadCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"))
+	assert.NoError(t, err)
+	ig := &archivertest.RecordingIngester{}
+	w := NewWorker(cfg, "shop.orders", ig, &slowRangeSource{rangeSource: src, min: 1, max: 50})
+	w.Checkpoint = checkpoint
+	w.Run(context.Background())
+	assert.NoError(t, w.Err())
+	// the failed reads are retried, every row is ingested once
+	assert.Equal(t, 50, ig.Rows())
+	assert.Equal(t, &TableCheckpoint{Next: 51}, checkpoint.Tables["shop.orders"])
+}
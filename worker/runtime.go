@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/databendcloud/bend-archiver/ingester"
+)
+
+// deadlineReached reports whether the maxRuntime of the job is over, or its
+// budget spent, no new range or file is started then.
+func (w *Worker) deadlineReached() bool {
+	return (!w.Deadline.IsZero() && time.Now().After(w.Deadline)) || w.budgetSpent()
+}
+
+func (w *Worker) budgetSpent() bool {
+	return w.Cfg.HasBudget() && ingester.BudgetSpent(w.Cfg) != nil
+}
+
+// stop records that the worker stopped at the deadline or because the job
+// was interrupted, the rows from
+// resumeKey on (all of them when empty) are left for the next run.
+func (w *Worker) stop(resumeKey string) {
+	w.stopMu.Lock()
+	defer w.stopMu.Unlock()
+	w.stopped, w.resumeKey = true, resumeKey
+}
+
+// Stopped reports whether the worker stopped at the deadline, or because the
+// job was interrupted, before reading all rows, and the split key the next run resumes from with startFromKey.
+func (w *Worker) Stopped() (string, bool) {
+	w.stopMu.Lock()
+	defer w.stopMu.Unlock()
+	return w.resumeKey, w.stopped
+}
+
+// graceContext is done grace after ctx is, so that the work in flight when
+// the job stops finishes within the notice of a preemption and is cancelled
+// past it. It is done with ctx when grace is 0.
+func graceContext(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	graceCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-graceCtx.Done():
+		}
+	})
+	return graceCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// conditionStart is the lower bound of a time split key condition, e.g.
+// 2024-01-01 00:00:00 of (t >= '2024-01-01 00:00:00' and t < '...').
+func conditionStart(condition string) string {
+	_, rest, ok := strings.Cut(condition, ">= '")
+	if !ok {
+		return ""
+	}
+	start, _, _ := strings.Cut(rest, "'")
+	return start
+}
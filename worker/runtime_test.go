@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/archivertest"
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+// slowRangeSource is a rangeSource over min..max taking a while per batch.
+type slowRangeSource struct {
+	*rangeSource
+	min, max uint64
+}
+
+func (s *slowRangeSource) GetMinMaxSplitKey(ctx context.Context) (uint64, uint64, error) {
+	return s.min, s.max, nil
+}
+
+func (s *slowRangeSource) QueryTableData(ctx context.Context, threadNum int, conditionSql string) ([][]interface{}, []string, error) {
+	time.Sleep(2 * time.Millisecond)
+	return s.rangeSource.QueryTableData(ctx, threadNum, conditionSql)
+}
+
+func TestMaxRuntime(t *testing.T) {
+	src := &slowRangeSource{rangeSource: &rangeSource{read: make(map[uint64]int)}, min: 1, max: 1000000}
+	cfg := &config.Config{SourceSplitKey: "id", BatchSize: 10, MaxThread: 2, SourceRetryAttempts: 1}
+	w := NewWorker(cfg, "shop.orders", &archivertest.RecordingIngester{}, src)
+	w.Deadline = time.Now().Add(30 * time.Millisecond)
+	w.Run(context.Background())
+
+	key, stopped := w.Stopped()
+	assert.True(t, stopped)
+	next, err := strconv.ParseUint(key, 10, 64)
+	assert.NoError(t, err)
+	assert.True(t, next > 1)
+	// every key below the resume key was read once, none from it on
+	assert.Equal(t, int(next-1), len(src.read))
+	for id := uint64(1); id < next; id++ {
+		assert.Equal(t, 1, src.read[id], "key %d", id)
+	}
+
+	src = &slowRangeSource{rangeSource: &rangeSource{read: make(map[uint64]int)}, min: 1, max: 25}
+	w = NewWorker(cfg, "shop.orders", &archivertest.RecordingIngester{}, src)
+	w.Deadline = time.Now().Add(time.Minute)
+	w.Run(context.Background())
+	_, stopped = w.Stopped()
+	assert.False(t, stopped)
+	assert.Equal(t, 25, len(src.read))
+
+	// a table whose turn comes after the deadline is not started
+	src = &slowRangeSource{rangeSource: &rangeSource{read: make(map[uint64]int)}, min: 1, max: 25}
+	w = NewWorker(cfg, "shop.orders", &archivertest.RecordingIngester{}, src)
+	w.Deadline = time.Now().Add(-time.Second)
+	w.Run(context.Background())
+	key, stopped = w.Stopped()
+	assert.True(t, stopped)
+	assert.Equal(t, "", key)
+	assert.Equal(t, 0, len(src.read))
+}
+
+func TestConditionStart(t *testing.T) {
+	assert.Equal(t, "2024-01-01 00:00:00", conditionStart("(t >= '2024-01-01 00:00:00' and t < '2024-01-02 00:00:00')"))
+	assert.Equal(t, "", conditionStart("(id >= 1 and id < 10)"))
+}
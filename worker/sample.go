@@ -0,0 +1,158 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/internal/sqllog"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// Sample reads the first n rows of the first ranges, or of the snapshot, of
+// the source table of cfg, converted like the job reads them and with the
+// metadataColumns it adds.
+func Sample(ctx context.Context, cfg *config.Config, src source.TableSourcer, n int) ([]string, [][]interface{}, error) {
+	w := NewWorker(cfg, fmt.Sprintf("%s.%s", cfg.SourceDB, cfg.SourceTable), nil, src)
+	if sr, ok := src.(source.SnapshotReader); ok && cfg.SourceSnapshot {
+		return w.sampleSnapshot(ctx, sr, n)
+	}
+	next, err := sampleRanges(ctx, cfg, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	var columns []string
+	var rows [][]interface{}
+	for len(rows) < n {
+		condition, ok := next()
+		if !ok {
+			break
+		}
+		data, cols, err := src.QueryTableData(ctx, 0, condition)
+		if err != nil {
+			return nil, nil, sourceError(err)
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if len(data) > n-len(rows) {
+			data = data[:n-len(rows)]
+		}
+		if len(cfg.MetadataColumns) > 0 {
+			if cols, data, err = w.addMetadata(condition, cols, data, time.Now()); err != nil {
+				return nil, nil, err
+			}
+		}
+		columns = cols
+		rows = append(rows, data...)
+	}
+	return columns, rows, nil
+}
+
+// errSampled stops the read of a snapshot once the sample is taken.
+var errSampled = errors.New("sampled")
+
+func (w *Worker) sampleSnapshot(ctx context.Context, sr source.SnapshotReader, n int) ([]string, [][]interface{}, error) {
+	var columns []string
+	var rows [][]interface{}
+	err := sr.ReadSnapshot(ctx, func(cols []string, data [][]interface{}) error {
+		if len(data) > n-len(rows) {
+			data = data[:n-len(rows)]
+		}
+		if len(w.Cfg.MetadataColumns) > 0 {
+			var err error
+			if cols, data, err = w.addMetadata("snapshot", cols, data, time.Now()); err != nil {
+				return err
+			}
+		}
+		columns = cols
+		rows = append(rows, data...)
+		if len(rows) >= n {
+			return errSampled
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errSampled) {
+		return nil, nil, sourceError(err)
+	}
+	return columns, rows, nil
+}
+
+// sampleRanges returns the ranges of the source in order, one per call.
+func sampleRanges(ctx context.Context, cfg *config.Config, src source.SourcePlanner) (func() (string, bool), error) {
+	if cfg.SourceSplitKey == "" {
+		if cfg.SourceSplitTimeKey == "" {
+			return nil, fmt.Errorf("sample needs sourceSplitKey, sourceSplitTimeKey or sourceSnapshot")
+		}
+		minKey, maxKey, err := src.GetMinMaxTimeSplitKey(ctx)
+		if err != nil || minKey == "" {
+			return func() (string, bool) { return "", false }, err
+		}
+		conditions, err := source.SplitConditionAccordingToTimeSplitKey(cfg, minKey, maxKey)
+		if err != nil {
+			return nil, err
+		}
+		return func() (string, bool) {
+			if len(conditions) == 0 {
+				return "", false
+			}
+			condition := conditions[0]
+			conditions = conditions[1:]
+			return condition, true
+		}, nil
+	}
+	minKey, maxKey, err := src.GetMinMaxSplitKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	batch := uint64(cfg.BatchSize)
+	done := minKey == 0 && maxKey == 0
+	return func() (string, bool) {
+		if done {
+			return "", false
+		}
+		// only the first range of what is left, the keys may go up to the max uint64
+		last := maxKey
+		if maxKey-minKey >= batch {
+			last = minKey + batch - 1
+		}
+		condition := source.SplitCondition(cfg.SourceSplitKey, batch, minKey, last)[0]
+		done = last == maxKey
+		minKey = last + 1
+		return condition, true
+	}, nil
+}
+
+// MaskRows replaces the values of the masked columns of rows, or of every
+// column with all, but the split keys, by ***. NULLs stay NULL and binary
+// values binary.
+func MaskRows(cfg *config.Config, columns []string, rows [][]interface{}, masked []string, all bool) {
+	for i, column := range columns {
+		mask := all && !strings.EqualFold(column, cfg.SourceSplitKey) && !strings.EqualFold(column, cfg.SourceSplitTimeKey)
+		for _, m := range masked {
+			mask = mask || strings.EqualFold(column, m)
+		}
+		if !mask {
+			continue
+		}
+		for _, row := range rows {
+			if len(row) > i {
+				row[i] = maskValue(row[i])
+			}
+		}
+	}
+}
+
+func maskValue(v interface{}) interface{} {
+	switch v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return []byte(sqllog.Redacted)
+	default:
+		return sqllog.Redacted
+	}
+}
@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestSample(t *testing.T) {
+	src := &slowRangeSource{rangeSource: &rangeSource{read: make(map[uint64]int)}, min: 1, max: 30}
+	cfg := &config.Config{SourceDB: "shop", SourceTable: "orders", SourceSplitKey: "id", BatchSize: 10,
+		MetadataColumns: []string{"source_table"}}
+	columns, rows, err := Sample(context.Background(), cfg, src, 12)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id", "source_table"}, columns)
+	assert.Len(t, rows, 12)
+	assert.Equal(t, []interface{}{uint64(12), "shop.orders"}, rows[11])
+	// the second range was read, not the third
+	assert.Equal(t, 20, len(src.read))
+
+	columns, rows, err = Sample(context.Background(), cfg, src, 100)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 30)
+
+	_, _, err = Sample(context.Background(), &config.Config{}, src, 1)
+	assert.Error(t, err)
+
+	cfg = &config.Config{SourceSnapshot: true}
+	columns, rows, err = Sample(context.Background(), cfg, &snapshotSource{rows: 25, batch: 10}, 12)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"name"}, columns)
+	assert.Len(t, rows, 12)
+}
+
+func TestMaskRows(t *testing.T) {
+	cfg := &config.Config{SourceSplitKey: "id"}
+	columns := []string{"id", "Email", "photo", "age"}
+	rows := [][]interface{}{{int64(1), "a@example.com", []byte{1, 2}, int64(30)}, {int64(2), nil, nil, nil}}
+	MaskRows(cfg, columns, rows, []string{"email"}, false)
+	assert.Equal(t, []interface{}{int64(1), "***", []byte{1, 2}, int64(30)}, rows[0])
+	assert.Equal(t, []interface{}{int64(2), nil, nil, nil}, rows[1])
+
+	MaskRows(cfg, columns, rows, nil, true)
+	assert.Equal(t, []interface{}{int64(1), "***", []byte("***"), "***"}, rows[0])
+}
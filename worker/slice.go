@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// readTimer times the reads of a source that calls back with every batch,
+// as the time from the end of one callback to the next.
+type readTimer struct {
+	last time.Time
+}
+
+func newReadTimer() *readTimer {
+	return &readTimer{last: time.Now()}
+}
+
+func (t *readTimer) read(latency *ReadLatency, table, batch string) {
+	ingester.RecordPhase(ingester.PhaseRead, time.Since(t.last))
+	latency.Record(table, batch, time.Since(t.last), time.Now())
+}
+
+func (t *readTimer) reset() {
+	t.last = time.Now()
+}
+
+// stepSlices reads one slice per thread and ingests the batches as they come.
+// The slices are read to their end when ctx is done, they can't be resumed.
+func (w *Worker) stepSlices(ctx context.Context, ss source.SliceSourcer) error {
+	ctx = context.WithoutCancel(ctx)
+	wg := &sync.WaitGroup{}
+	wg.Add(w.Cfg.MaxThread)
+	for i := 0; i < w.Cfg.MaxThread; i++ {
+		go func(idx int) {
+			defer wg.Done()
+			timer := newReadTimer()
+			part := 0
+			err := ss.ReadSlice(ctx, idx, w.Cfg.MaxThread, func(columns []string, data [][]interface{}) error {
+				slice := fmt.Sprintf("slice %d/%d", idx, w.Cfg.MaxThread)
+				timer.read(w.ReadLatency, w.Name, slice)
+				defer timer.reset()
+				part++
+				_, err := w.ingest(w.Ig, w.Cfg.DatabendTable, slice, part, idx, columns, data)
+				return err
+			})
+			if err != nil && !errors.Is(err, errLimitReached) {
+				logrus.Errorf("Thread %d, read slice of %s failed: %v", idx, w.Cfg.SourceTable, w.fail(err))
+			}
+		}(i)
+	}
+	wg.Wait()
+	return nil
+}
+
+// stepSnapshot reads the source in one query with sourceSnapshot and
+// ingests its batches as they come. The snapshot is read to its end when ctx
+// is done, it can't be resumed.
+func (w *Worker) stepSnapshot(ctx context.Context, sr source.SnapshotReader) error {
+	ctx = context.WithoutCancel(ctx)
+	timer := newReadTimer()
+	part := 0
+	err := sr.ReadSnapshot(ctx, func(columns []string, data [][]interface{}) error {
+		timer.read(w.ReadLatency, w.Name, "snapshot")
+		defer timer.reset()
+		part++
+		_, err := w.ingest(w.Ig, w.Cfg.DatabendTable, "snapshot", part, 0, columns, data)
+		return err
+	})
+	if errors.Is(err, errLimitReached) {
+		err = nil
+	}
+	if b, ok := sr.(source.Bookmarker); ok && err == nil && b.Bookmark() != nil {
+		logrus.Infof("%s: snapshot read at %s", w.Name, b.Bookmark())
+		if w.ArchiveManifest != nil {
+			w.ArchiveManifest.RecordBookmark(w.Name, *b.Bookmark())
+		}
+	}
+	return err
+}
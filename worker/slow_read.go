@@ -0,0 +1,143 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+const (
+	// slowReadWindow is the number of batches the mean read time is taken
+	// over, for the baseline of the first batches and for the last ones.
+	slowReadWindow = 20
+	// slowReadMinBatches is the number of batches read before alerting.
+	slowReadMinBatches = 5
+	// slowReadRealert is how often an ongoing slowdown is alerted again.
+	slowReadRealert = 10 * time.Minute
+)
+
+// SlowReadAlert is an alert, or a recovery, of the source read times, as
+// POSTed to slowReadAlertURL.
+type SlowReadAlert struct {
+	JobID      string    `json:"jobId,omitempty"`
+	RunID      string    `json:"runId,omitempty"`
+	Status     string    `json:"status"`               // slow or recovered
+	Table      string    `json:"table"`                // table of the last batch
+	Batch      string    `json:"batch"`                // range or file of the last batch
+	MeanMs     int64     `json:"meanMs"`               // mean read time of the last batches
+	BaselineMs int64     `json:"baselineMs,omitempty"` // mean read time of the first batches
+	Reason     string    `json:"reason,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// ReadLatency tracks the read times of the source batches of a job and
+// alerts when they degrade beyond slowReadThreshold or slowReadFactor.
+type ReadLatency struct {
+	cfg       *config.Config
+	threshold time.Duration
+	notify    func(SlowReadAlert)
+
+	mu        sync.Mutex
+	baseline  []time.Duration // the first batches
+	recent    []time.Duration // the last batches, a ring
+	next      int
+	slow      bool
+	lastAlert time.Time
+}
+
+// NewReadLatency returns the tracker of the read times of cfg, nil when no
+// slowdown is to be detected.
+func NewReadLatency(cfg *config.Config) *ReadLatency {
+	if cfg.SlowReadThreshold == "" && cfg.SlowReadFactor == 0 {
+		return nil
+	}
+	l := &ReadLatency{cfg: cfg}
+	l.threshold, _ = time.ParseDuration(cfg.SlowReadThreshold)
+	l.notify = l.post
+	return l
+}
+
+// Record adds the read time d of a batch of table, read at now.
+func (l *ReadLatency) Record(table, batch string, d time.Duration, now time.Time) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.baseline) < slowReadWindow {
+		l.baseline = append(l.baseline, d)
+	}
+	if len(l.recent) < slowReadWindow {
+		l.recent = append(l.recent, d)
+	} else {
+		l.recent[l.next] = d
+		l.next = (l.next + 1) % slowReadWindow
+	}
+	if len(l.recent) < slowReadMinBatches {
+		return
+	}
+	mean, baseline := meanDuration(l.recent), meanDuration(l.baseline)
+	var reason string
+	switch {
+	case l.threshold > 0 && mean > l.threshold:
+		reason = fmt.Sprintf("mean read time %v of the last %d batches is over slowReadThreshold %v", mean.Round(time.Millisecond), len(l.recent), l.threshold)
+	case l.cfg.SlowReadFactor > 0 && len(l.baseline) == slowReadWindow && float64(mean) > l.cfg.SlowReadFactor*float64(baseline):
+		reason = fmt.Sprintf("mean read time %v of the last %d batches is %.1f times the %v of the first ones", mean.Round(time.Millisecond), len(l.recent), float64(mean)/float64(baseline), baseline.Round(time.Millisecond))
+	}
+	alert := SlowReadAlert{JobID: l.cfg.JobID, RunID: l.cfg.RunID, Table: table, Batch: batch, MeanMs: mean.Milliseconds(), BaselineMs: baseline.Milliseconds(), Reason: reason, Time: now}
+	switch {
+	case reason != "" && (!l.slow || now.Sub(l.lastAlert) >= slowReadRealert):
+		l.slow, l.lastAlert = true, now
+		alert.Status = "slow"
+		logrus.Warnf("source reads are slow: %s, last batch %s of %s", reason, batch, table)
+		l.notify(alert)
+	case reason == "" && l.slow:
+		l.slow = false
+		alert.Status = "recovered"
+		logrus.Infof("source reads recovered: mean read time %v of the last %d batches", mean.Round(time.Millisecond), len(l.recent))
+		l.notify(alert)
+	}
+}
+
+// post POSTs alert to slowReadAlertURL in the background, a read doesn't
+// wait for the webhook.
+func (l *ReadLatency) post(alert SlowReadAlert) {
+	if l.cfg.SlowReadAlertURL == "" {
+		return
+	}
+	data, err := json.Marshal(alert)
+	if err != nil {
+		logrus.Errorf("encode slow read alert failed: %v", err)
+		return
+	}
+	go func() {
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(l.cfg.SlowReadAlertURL, "application/json", bytes.NewReader(data))
+		if err != nil {
+			logrus.Warnf("post slow read alert to %s failed: %v", l.cfg.SlowReadAlertURL, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			logrus.Warnf("post slow read alert to %s failed: status code %d", l.cfg.SlowReadAlertURL, resp.StatusCode)
+		}
+	}()
+}
+
+func meanDuration(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	return total / time.Duration(len(durations))
+}
@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestReadLatency(t *testing.T) {
+	assert.Nil(t, NewReadLatency(&config.Config{}))
+
+	l := NewReadLatency(&config.Config{SlowReadFactor: 3})
+	var alerts []SlowReadAlert
+	l.notify = func(alert SlowReadAlert) { alerts = append(alerts, alert) }
+	now := time.Now()
+	record := func(batches int, d time.Duration) {
+		for i := 0; i < batches; i++ {
+			now = now.Add(time.Second)
+			l.Record("shop.orders", "(id >= 1 and id < 11)", d, now)
+		}
+	}
+	record(30, 100*time.Millisecond)
+	assert.Empty(t, alerts)
+	// the mean of the last 20 batches passes 3 times the baseline
+	record(4, time.Second)
+	assert.Empty(t, alerts)
+	record(1, time.Second)
+	assert.Equal(t, 1, len(alerts))
+	assert.Equal(t, "slow", alerts[0].Status)
+	assert.Equal(t, int64(100), alerts[0].BaselineMs)
+	// not alerted again right away
+	record(5, time.Second)
+	assert.Equal(t, 1, len(alerts))
+	record(20, 100*time.Millisecond)
+	assert.Equal(t, 2, len(alerts))
+	assert.Equal(t, "recovered", alerts[1].Status)
+}
+
+func TestReadLatencyThreshold(t *testing.T) {
+	l := NewReadLatency(&config.Config{SlowReadThreshold: "1s"})
+	var alerts []SlowReadAlert
+	l.notify = func(alert SlowReadAlert) { alerts = append(alerts, alert) }
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		l.Record("shop.orders", "", 2*time.Second, now)
+	}
+	assert.Equal(t, 1, len(alerts))
+	// an ongoing slowdown is alerted again every 10 minutes
+	l.Record("shop.orders", "", 2*time.Second, now.Add(slowReadRealert))
+	assert.Equal(t, 2, len(alerts))
+}
@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/archivertest"
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// snapshotSource reads rows rows in batches of batch.
+type snapshotSource struct {
+	source.TableSourcer
+	rows, batch int
+}
+
+func (s *snapshotSource) ReadSnapshot(ctx context.Context, fn func(columns []string, rows [][]interface{}) error) error {
+	var data [][]interface{}
+	for i := 1; i <= s.rows; i++ {
+		data = append(data, []interface{}{"row"})
+		if len(data) == s.batch || i == s.rows {
+			if err := fn([]string{"name"}, data); err != nil {
+				return err
+			}
+			data = nil
+		}
+	}
+	return nil
+}
+
+// bookmarkedSource is a snapshotSource that tells where it was read.
+type bookmarkedSource struct {
+	snapshotSource
+	bookmark *source.Bookmark
+}
+
+func (s *bookmarkedSource) Bookmark() *source.Bookmark {
+	return s.bookmark
+}
+
+func TestStepSnapshot(t *testing.T) {
+	ig := &archivertest.RecordingIngester{}
+	cfg := &config.Config{SourceSnapshot: true, BatchSize: 10}
+	w := NewWorker(cfg, "shop.order_totals", ig, &snapshotSource{rows: 25, batch: 10})
+	w.Run(context.Background())
+	assert.NoError(t, w.Err())
+	assert.Equal(t, 25, ig.Rows())
+	batches := ig.Batches()
+	assert.Len(t, batches, 3)
+	assert.Equal(t, "snapshot", batches[2].Name.Source)
+	assert.Equal(t, 3, batches[2].Name.Part)
+
+	// the limit stops the snapshot
+	ig = &archivertest.RecordingIngester{}
+	cfg = &config.Config{SourceSnapshot: true, BatchSize: 10, MaxRows: 15}
+	w = NewWorker(cfg, "shop.order_totals", ig, &snapshotSource{rows: 25, batch: 10})
+	w.Run(context.Background())
+	assert.NoError(t, w.Err())
+	assert.Equal(t, 15, ig.Rows())
+
+	// a source without snapshots
+	w = NewWorker(&config.Config{SourceSnapshot: true}, "shop.orders", ig, &rangeSource{})
+	w.Run(context.Background())
+	assert.Error(t, w.Err())
+
+	// the bookmark of the snapshot is in the manifest
+	bookmark := &source.Bookmark{File: "binlog.000042", Position: 1234, Exact: true}
+	w = NewWorker(&config.Config{SourceSnapshot: true, BatchSize: 10}, "shop.order_totals", &archivertest.RecordingIngester{},
+		&bookmarkedSource{snapshotSource: snapshotSource{rows: 5, batch: 10}, bookmark: bookmark})
+	w.ArchiveManifest = NewArchiveManifest()
+	w.Run(context.Background())
+	assert.NoError(t, w.Err())
+	assert.Equal(t, *bookmark, w.ArchiveManifest.Bookmarks["shop.order_totals"])
+}
@@ -0,0 +1,136 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/ingester"
+	"github.com/databendcloud/bend-archiver/source"
+)
+
+// softDeleteChunk is the number of rows deleted by one statement.
+const softDeleteChunk = 1000
+
+// propagateSoftDeletes deletes the rows of data that are soft deleted in the
+// source from target, matching them by SoftDeleteKeys, retrying the deletes
+// with ig. It returns the rows left to ingest: the live ones, plus the soft
+// deleted ones in flag mode so that target keeps them with their flag.
+func (w *Worker) propagateSoftDeletes(ig ingester.DatabendIngester, target string, columns []string, data [][]interface{}) ([][]interface{}, error) {
+	flagIdx := columnIndex(columns, w.Cfg.SoftDeleteColumn)
+	if flagIdx < 0 {
+		return nil, fmt.Errorf("soft delete column %s is not a column of %s", w.Cfg.SoftDeleteColumn, w.Cfg.SourceTable)
+	}
+	keyIdx := make([]int, len(w.Cfg.SoftDeleteKeys))
+	for i, key := range w.Cfg.SoftDeleteKeys {
+		if keyIdx[i] = columnIndex(columns, key); keyIdx[i] < 0 {
+			return nil, fmt.Errorf("soft delete key %s is not a column of %s", key, w.Cfg.SourceTable)
+		}
+	}
+	var live, deleted [][]interface{}
+	for _, row := range data {
+		if isSoftDeleted(row[flagIdx]) {
+			deleted = append(deleted, row)
+		} else {
+			live = append(live, row)
+		}
+	}
+	if len(deleted) == 0 {
+		return data, nil
+	}
+	// keys of wide batches are deleted in several statements to stay below
+	// the query size limit
+	for start := 0; start < len(deleted); start += softDeleteChunk {
+		chunk := deleted[start:min(start+softDeleteChunk, len(deleted))]
+		query, err := softDeleteSQL(target, w.Cfg.SoftDeleteKeys, keyIdx, chunk)
+		if err != nil {
+			return nil, err
+		}
+		if err := ig.DoRetry(func() error { return ingester.Exec(w.Cfg, query) }); err != nil {
+			return nil, fmt.Errorf("delete %d soft deleted rows from %s failed: %w", len(deleted), target, err)
+		}
+	}
+	logrus.Infof("Worker %s: deleted %d soft deleted rows from %s", w.Name, len(deleted), target)
+	if w.Cfg.SoftDeleteMode == "flag" {
+		return data, nil
+	}
+	return live, nil
+}
+
+// softDeleteSQL deletes the rows of target with the keys of rows.
+func softDeleteSQL(target string, keys []string, keyIdx []int, rows [][]interface{}) (string, error) {
+	var conditions []string
+	for _, row := range rows {
+		var matches []string
+		for i, key := range keys {
+			literal, err := source.KeyLiteral(row[keyIdx[i]])
+			if err != nil {
+				return "", err
+			}
+			if len(keys) == 1 {
+				matches = append(matches, literal)
+			} else {
+				matches = append(matches, fmt.Sprintf("%s = %s", key, literal))
+			}
+		}
+		if len(keys) == 1 {
+			conditions = append(conditions, matches[0])
+		} else {
+			conditions = append(conditions, "("+strings.Join(matches, " AND ")+")")
+		}
+	}
+	if len(keys) == 1 {
+		return fmt.Sprintf("DELETE FROM %s WHERE %s IN (%s)", target, keys[0], strings.Join(conditions, ", ")), nil
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE %s", target, strings.Join(conditions, " OR ")), nil
+}
+
+// isSoftDeleted reports whether v, the soft delete column of a row, marks it
+// deleted: a true flag or any deleted_at time.
+func isSoftDeleted(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case int:
+		return v != 0
+	case int8:
+		return v != 0
+	case int16:
+		return v != 0
+	case int32:
+		return v != 0
+	case int64:
+		return v != 0
+	case uint8:
+		return v != 0
+	case uint64:
+		return v != 0
+	case float64:
+		return v != 0
+	case json.Number:
+		return v.String() != "0"
+	case []byte:
+		return isSoftDeleted(string(v))
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "", "0", "f", "false", "n", "no":
+			return false
+		}
+		return true
+	default:
+		return true
+	}
+}
+
+func columnIndex(columns []string, name string) int {
+	for i, column := range columns {
+		if strings.EqualFold(column, name) {
+			return i
+		}
+	}
+	return -1
+}
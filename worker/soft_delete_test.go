@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/archivertest"
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestIsSoftDeleted(t *testing.T) {
+	for _, v := range []interface{}{true, int64(1), "1", "true", []byte("Y"), time.Now(), "2024-01-01 00:00:00"} {
+		assert.True(t, isSoftDeleted(v), "%v", v)
+	}
+	for _, v := range []interface{}{nil, false, int64(0), "0", "false", []byte("f"), ""} {
+		assert.False(t, isSoftDeleted(v), "%v", v)
+	}
+}
+
+func TestSoftDeleteSQL(t *testing.T) {
+	rows := [][]interface{}{{int64(1), "us", true}, {int64(2), "o'hare", true}}
+	query, err := softDeleteSQL("archive.orders", []string{"id"}, []int{0}, rows)
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETE FROM archive.orders WHERE id IN (1, 2)", query)
+
+	query, err = softDeleteSQL("archive.orders", []string{"id", "region"}, []int{0, 1}, rows)
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETE FROM archive.orders WHERE (id = 1 AND region = 'us') OR (id = 2 AND region = 'o''hare')", query)
+}
+
+func TestIngestFailsOnSoftDeleteError(t *testing.T) {
+	var requests int
+	databend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, `{"error": {"code": 1006, "message": "unavailable"}}`, http.StatusBadRequest)
+	}))
+	defer databend.Close()
+
+	cfg := &config.Config{
+		DatabendDSN:      "databend://user:pass@" + strings.TrimPrefix(databend.URL, "http://") + "/default?sslmode=disable",
+		SoftDeleteColumn: "deleted",
+		SoftDeleteKeys:   []string{"id"},
+	}
+	ig := &archivertest.RecordingIngester{}
+	w := &Worker{Name: "shop.orders", Cfg: cfg}
+	batch := [][]interface{}{{int64(1), false}, {int64(2), true}}
+	_, err := w.ingest(ig, "archive.orders", "(id >= 1 and id < 3)", 0, 0, []string{"id", "deleted"}, batch)
+	assert.Error(t, err)
+	assert.Error(t, w.Err())
+	assert.True(t, requests > 0)
+	// the live rows of the batch are not ingested without the deletes
+	assert.Equal(t, 0, ig.Rows())
+}
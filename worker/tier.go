@@ -0,0 +1,90 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+)
+
+// tierQueries are the statements of a tier run moving the rows of the hot
+// table older than a cutoff.
+type tierQueries struct {
+	count  string // rows to move
+	export string
+	verify string // rows exported, by this run for a stage location
+	delete string
+}
+
+func newTierQueries(cfg *config.Config, cutoff time.Time) tierQueries {
+	where := fmt.Sprintf("%s < '%s'", cfg.TierTimeColumn, cutoff.Format("2006-01-02 15:04:05"))
+	q := tierQueries{
+		count:  fmt.Sprintf("SELECT count(*) FROM %s WHERE %s", cfg.TierTable, where),
+		delete: fmt.Sprintf("DELETE FROM %s WHERE %s", cfg.TierTable, where),
+	}
+	if strings.HasPrefix(cfg.TierTo, "@") {
+		// every run exports into its own directory, so that it only counts
+		// its own files
+		location := strings.TrimSuffix(cfg.TierTo, "/") + "/" + cutoff.UTC().Format("20060102T150405") + "/"
+		q.export = fmt.Sprintf("COPY INTO %s FROM (SELECT * FROM %s WHERE %s) FILE_FORMAT = (TYPE = PARQUET)",
+			location, cfg.TierTable, where)
+		q.verify = fmt.Sprintf("SELECT count(*) FROM %s (FILE_FORMAT => 'parquet', PATTERN => '.*[.]parquet')", location)
+	} else {
+		q.export = fmt.Sprintf("INSERT INTO %s SELECT * FROM %s WHERE %s", cfg.TierTo, cfg.TierTable, where)
+		q.verify = fmt.Sprintf("SELECT count(*) FROM %s WHERE %s", cfg.TierTo, where)
+	}
+	return q
+}
+
+// Tier moves the rows of cfg.TierTable older than TierAfter at now to
+// TierTo: they are exported, the exported rows are counted, and only when
+// the counts match they are deleted from the hot table. It returns the rows
+// moved.
+func Tier(cfg *config.Config, now time.Time) (int, error) {
+	after, err := config.ParsePeriod(cfg.TierAfter)
+	if err != nil {
+		return 0, err
+	}
+	loc, err := databendLocation(cfg)
+	if err != nil {
+		return 0, err
+	}
+	q := newTierQueries(cfg, now.Add(-after).In(loc))
+
+	rows, err := ingester.QueryCount(cfg, q.count)
+	if err != nil {
+		return 0, err
+	}
+	if rows == 0 {
+		logrus.Infof("tier: no rows of %s to move", cfg.TierTable)
+		return 0, nil
+	}
+	// rows already in an archive database, e.g. from earlier runs
+	before := 0
+	if !strings.HasPrefix(cfg.TierTo, "@") {
+		if before, err = ingester.QueryCount(cfg, q.verify); err != nil {
+			return 0, err
+		}
+	}
+	logrus.Infof("tier: %s", q.export)
+	if err := ingester.Exec(cfg, q.export); err != nil {
+		return 0, fmt.Errorf("export %s failed: %w", cfg.TierTable, err)
+	}
+	exported, err := ingester.QueryCount(cfg, q.verify)
+	if err != nil {
+		return 0, fmt.Errorf("verify the export of %s failed: %w", cfg.TierTable, err)
+	}
+	if exported-before != rows {
+		return 0, fmt.Errorf("exported %d rows of %s to %s, but %d are older than the cutoff, nothing is deleted",
+			exported-before, cfg.TierTable, cfg.TierTo, rows)
+	}
+	logrus.Infof("tier: %s", q.delete)
+	if err := ingester.Exec(cfg, q.delete); err != nil {
+		return 0, fmt.Errorf("%d rows exported to %s but not deleted, run %q by hand: %w", rows, cfg.TierTo, q.delete, err)
+	}
+	return rows, nil
+}
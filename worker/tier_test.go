@@ -0,0 +1,25 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/databendcloud/bend-archiver/config"
+)
+
+func TestTierQueries(t *testing.T) {
+	cutoff := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	cfg := &config.Config{TierTable: "hot.orders", TierTimeColumn: "created_at", TierTo: "@cold/orders/"}
+	q := newTierQueries(cfg, cutoff)
+	assert.Equal(t, "SELECT count(*) FROM hot.orders WHERE created_at < '2024-03-01 00:00:00'", q.count)
+	assert.Equal(t, "COPY INTO @cold/orders/20240301T000000/ FROM (SELECT * FROM hot.orders WHERE created_at < '2024-03-01 00:00:00') FILE_FORMAT = (TYPE = PARQUET)", q.export)
+	assert.Equal(t, "SELECT count(*) FROM @cold/orders/20240301T000000/ (FILE_FORMAT => 'parquet', PATTERN => '.*[.]parquet')", q.verify)
+	assert.Equal(t, "DELETE FROM hot.orders WHERE created_at < '2024-03-01 00:00:00'", q.delete)
+
+	cfg.TierTo = "cold.orders"
+	q = newTierQueries(cfg, cutoff)
+	assert.Equal(t, "INSERT INTO cold.orders SELECT * FROM hot.orders WHERE created_at < '2024-03-01 00:00:00'", q.export)
+	assert.Equal(t, "SELECT count(*) FROM cold.orders WHERE created_at < '2024-03-01 00:00:00'", q.verify)
+}
@@ -0,0 +1,45 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/databendcloud/bend-archiver/config"
+	"github.com/databendcloud/bend-archiver/ingester"
+)
+
+// Verify runs the verification queries of cfg on the target, it returns the
+// first one that failed with its result, or "" when they all passed.
+func Verify(cfg *config.Config) (string, error) {
+	for _, query := range cfg.VerificationQueries {
+		rows, err := ingester.Query(cfg, query)
+		if err != nil {
+			return "", fmt.Errorf("verification query %q: %w", query, err)
+		}
+		if !verificationPassed(rows) {
+			return fmt.Sprintf("%q returned %d rows, the first is %s", query, len(rows), formatRow(rows[0])), nil
+		}
+	}
+	return "", nil
+}
+
+// verificationPassed reports whether the rows of a verification query pass:
+// there are none, or a single true value. Any other rows are the offending
+// ones.
+func verificationPassed(rows [][]string) bool {
+	if len(rows) == 0 {
+		return true
+	}
+	if len(rows) != 1 || len(rows[0]) != 1 {
+		return false
+	}
+	switch strings.ToLower(rows[0][0]) {
+	case "1", "true":
+		return true
+	}
+	return false
+}
+
+func formatRow(row []string) string {
+	return "(" + strings.Join(row, ", ") + ")"
+}
@@ -0,0 +1,18 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestVerificationPassed(t *testing.T) {
+	assert.True(t, verificationPassed(nil))
+	assert.True(t, verificationPassed([][]string{{"true"}}))
+	assert.True(t, verificationPassed([][]string{{"1"}}))
+	assert.False(t, verificationPassed([][]string{{"false"}}))
+	assert.False(t, verificationPassed([][]string{{"0"}}))
+	// offending rows
+	assert.False(t, verificationPassed([][]string{{"1", "NULL"}}))
+	assert.False(t, verificationPassed([][]string{{"1"}, {"2"}}))
+}
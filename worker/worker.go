@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -22,6 +23,27 @@ type Worker struct {
 	Ig            ingester.DatabendIngester
 	Src           source.Sourcer
 	statsRecorder *DatabendWorkerStatsRecorder
+	// ArchiveManifest, when set, records every batch the worker staged
+	ArchiveManifest *ArchiveManifest
+	skippedRows     int64
+	limitedRows     int64
+	ingestedRows    int64
+	errMu           sync.Mutex
+	err             error
+	// Deadline, when set, is the end of the maxRuntime of the job
+	Deadline time.Time
+	// Checkpoint, when set, records the key ranges the worker archived
+	Checkpoint *Checkpoint
+	stopMu     sync.Mutex
+	stopped    bool
+	resumeKey  string
+	// Quality, when set, checks the quality rules on the rows ingested
+	Quality *Quality
+	// ReadLatency, when set, alerts when the source reads slow down
+	ReadLatency *ReadLatency
+	// pipeline ingests the batches read by stepBatch, nil ingests them on
+	// the reading threads
+	pipeline *pipeline
 }
 
 var (
@@ -42,20 +64,57 @@ func NewWorker(cfg *config.Config, name string, ig ingester.DatabendIngester, sr
 	}
 }
 
-func (w *Worker) stepBatchWithCondition(threadNum int, conditionSql string) error {
-	data, columns, err := w.Src.QueryTableData(threadNum, conditionSql)
+// planner returns the source as a SourcePlanner, the sources of files are
+// not counted or split.
+func (w *Worker) planner() (source.SourcePlanner, error) {
+	planner, ok := w.Src.(source.SourcePlanner)
+	if !ok {
+		return nil, fmt.Errorf("%s sources are not counted or split into ranges", w.Cfg.DatabaseType)
+	}
+	return planner, nil
+}
+
+func (w *Worker) stepBatchWithCondition(ctx context.Context, threadNum int, conditionSql string) error {
+	return w.readBatch(ctx, threadNum, conditionSql, nil)
+}
+
+// readBatch reads the batch of conditionSql and ingests it in the pipeline
+// of the worker. It returns the error of the read, done, when set, is
+// called with the error of the ingest once it is over.
+func (w *Worker) readBatch(ctx context.Context, threadNum int, conditionSql string, done func(error)) error {
+	if w.limitReached() {
+		if done != nil {
+			done(nil)
+		}
+		return nil
+	}
+	data, columns, err := w.queryTableData(ctx, threadNum, conditionSql)
 	if err != nil {
-		return err
+		err = sourceError(err)
+		w.batchFailed(conditionSql, err)
+		return w.fail(err)
 	}
+	w.pipeline.run(func() {
+		err := w.ingestRead(threadNum, conditionSql, columns, data)
+		if done != nil {
+			done(err)
+		}
+	})
+	return nil
+}
+
+// ingestRead ingests the batch read with conditionSql and logs the global
+// speed.
+func (w *Worker) ingestRead(threadNum int, conditionSql string, columns []string, data [][]interface{}) error {
 	if len(data) == 0 {
 		return nil
 	}
 	startTime := time.Now()
-	err = w.Ig.DoRetry(
-		func() error {
-			return w.Ig.IngestData(threadNum, columns, data)
-		})
-	AlreadyIngestRows += len(data)
+	rows, err := w.ingest(w.Ig, w.Cfg.DatabendTable, conditionSql, 0, threadNum, columns, data)
+	if err == errLimitReached {
+		err = nil
+	}
+	AlreadyIngestRows += rows
 	AlreadyIngestBytes += calculateBytesSize(data)
 	w.statsRecorder.RecordMetric(AlreadyIngestBytes, AlreadyIngestRows)
 	stats := w.statsRecorder.Stats(time.Since(startTime))
@@ -83,18 +142,62 @@ func (w *Worker) IsSplitAccordingMaxGoRoutine(minSplitKey, maxSplitKey, batchSiz
 	return (maxSplitKey-minSplitKey)/batchSize > uint64(w.Cfg.MaxThread)
 }
 
-func (w *Worker) stepBatch() error {
+func (w *Worker) stepBatch(ctx context.Context) error {
+	w.pipeline = w.newPipeline()
+	defer w.pipeline.close()
 	wg := &sync.WaitGroup{}
-	minSplitKey, maxSplitKey, err := w.Src.GetMinMaxSplitKey()
+	planner, err := w.planner()
+	if err != nil {
+		return err
+	}
+	minSplitKey, maxSplitKey, err := planner.GetMinMaxSplitKey(ctx)
 	if err != nil {
 		return err
 	}
 	if minSplitKey == 0 && maxSplitKey == 0 {
-		logrus.Infof("db.table is %s.%s, minSplitKey: %d, maxSplitKey : %d", w.Cfg.SourceDB, w.Cfg.SourceTable, minSplitKey, maxSplitKey)
+		w.skipEmpty()
 		return nil
 	}
 	logrus.Infof("db.table is %s.%s, minSplitKey: %d, maxSplitKey : %d", w.Cfg.SourceDB, w.Cfg.SourceTable, minSplitKey, maxSplitKey)
+	if w.Cfg.StartFromKey != "" {
+		startFromKey, err := strconv.ParseUint(w.Cfg.StartFromKey, 10, 64)
+		if err != nil {
+			return fmt.Errorf("startFromKey must be an unsigned integer with sourceSplitKey: %w", err)
+		}
+		if startFromKey > maxSplitKey {
+			return nil
+		}
+		if startFromKey > minSplitKey {
+			logrus.Infof("start from key %d", startFromKey)
+			minSplitKey = startFromKey
+		}
+	}
+	if w.Checkpoint != nil {
+		minSplitKey = w.Checkpoint.start(w.Name, minSplitKey)
+		if minSplitKey > maxSplitKey {
+			logrus.Infof("Worker %s: checkpoint has all keys up to %d archived", w.Name, maxSplitKey)
+			return nil
+		}
+	}
+	if w.Cfg.Autotune {
+		next, done, err := w.autotune(ctx, minSplitKey, maxSplitKey)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		minSplitKey = next
+	}
 
+	if !w.Deadline.IsZero() || w.Checkpoint != nil || w.Cfg.HasBudget() {
+		// ranges in key order, so that the rows left are those from next on
+		next, done := w.runRanges(ctx, w.Cfg.MaxThread, w.Cfg.BatchSize, minSplitKey, maxSplitKey, w.Deadline)
+		if !done {
+			w.stop(strconv.FormatUint(next, 10))
+		}
+		return nil
+	}
 	if w.IsSplitAccordingMaxGoRoutine(minSplitKey, maxSplitKey, uint64(w.Cfg.BatchSize)) {
 		fmt.Println("split according maxGoRoutine", w.Cfg.MaxThread)
 		slimedRange := source.SlimCondition(w.Cfg.MaxThread, minSplitKey, maxSplitKey)
@@ -110,7 +213,7 @@ func (w *Worker) stepBatch() error {
 				}
 				for condition := range conditions {
 					logrus.Infof("condition: %s", condition)
-					err := w.stepBatchWithCondition(idx, condition)
+					err := w.stepBatchWithCondition(ctx, idx, condition)
 					if err != nil {
 						logrus.Errorf("Thread %d, stepBatchWithCondition failed: %v", idx, err)
 					}
@@ -125,7 +228,7 @@ func (w *Worker) stepBatch() error {
 		wg.Add(1)
 		go func(condition string) {
 			defer wg.Done()
-			err := w.stepBatchWithCondition(1, condition)
+			err := w.stepBatchWithCondition(ctx, 1, condition)
 			if err != nil {
 				logrus.Errorf("stepBatchWithCondition failed: %v", err)
 			}
@@ -135,17 +238,45 @@ func (w *Worker) stepBatch() error {
 	return nil
 }
 
-func (w *Worker) StepBatchByTimeSplitKey() error {
-	// Time-based splitting uses LIMIT/OFFSET over a non-unique, mutable key,
-	// so running multiple goroutines risks duplicates/omissions.
+// skipEmpty records the table of the worker as archived when the source
+// has no rows for it, nothing is staged or copied then.
+func (w *Worker) skipEmpty() {
+	logrus.Infof("Worker %s: %s.%s has no rows to archive, skip it", w.Name, w.Cfg.SourceDB, w.Cfg.SourceTable)
+	if w.ArchiveManifest != nil {
+		w.ArchiveManifest.RecordEmpty(w.Name)
+	}
+}
+
+func (w *Worker) StepBatchByTimeSplitKey(ctx context.Context) error {
+	// Time-based splitting pages with LIMIT/OFFSET (or by the split key) over
+	// a non-unique, mutable key, so running multiple goroutines risks
+	// duplicates/omissions.
 	if w.Cfg.MaxThread > 1 {
 		return fmt.Errorf("time split does not support MaxThread > 1; use auto increment split key")
 	}
-	minSplitKey, maxSplitKey, err := w.Src.GetMinMaxTimeSplitKey()
+	planner, err := w.planner()
 	if err != nil {
 		return err
 	}
+	minSplitKey, maxSplitKey, err := planner.GetMinMaxTimeSplitKey(ctx)
+	if err != nil {
+		return err
+	}
+	if minSplitKey == "" || maxSplitKey == "" {
+		w.skipEmpty()
+		return nil
+	}
 	fmt.Println("minSplitKey", minSplitKey, "maxSplitKey", maxSplitKey)
+	if w.Cfg.StartFromKey != "" {
+		// ISO 8601 like timestamps compare as strings
+		if w.Cfg.StartFromKey > maxSplitKey {
+			return nil
+		}
+		if w.Cfg.StartFromKey > minSplitKey {
+			logrus.Infof("start from key %s", w.Cfg.StartFromKey)
+			minSplitKey = w.Cfg.StartFromKey
+		}
+	}
 
 	fmt.Println("split according time split key", w.Cfg.MaxThread)
 	allConditions, err := source.SplitConditionAccordingToTimeSplitKey(w.Cfg, minSplitKey, maxSplitKey)
@@ -156,14 +287,24 @@ func (w *Worker) StepBatchByTimeSplitKey() error {
 	fmt.Println("all split conditions", allConditions)
 
 	for _, condition := range allConditions {
+		if w.limitReached() {
+			break
+		}
+		if w.deadlineReached() {
+			w.stop(conditionStart(condition))
+			break
+		}
 		logrus.Infof("condition: %s", condition)
-		switch w.Cfg.DatabaseType {
-		case "mysql":
-			err = w.stepBatchWithTimeCondition(condition, w.Cfg.BatchSize)
-		case "mssql":
-			err = w.stepBatchWithTimeConditionMssql(condition, w.Cfg.BatchSize)
+		switch {
+		case w.Cfg.SourceSplitKey != "" && w.Cfg.DatabaseType == "mssql":
+			// the SQL Server source pages by the split key itself
+			err = w.stepBatchWithCondition(ctx, 1, condition)
+		case w.Cfg.SourceSplitKey != "":
+			err = w.stepBatchWithKeyset(ctx, condition, w.Cfg.BatchSize)
+		case w.Cfg.DatabaseType == "mssql":
+			err = w.stepBatchWithTimeConditionMssql(ctx, condition, w.Cfg.BatchSize)
 		default:
-			err = w.stepBatchWithTimeCondition(condition, w.Cfg.BatchSize)
+			err = w.stepBatchWithTimeCondition(ctx, condition, w.Cfg.BatchSize)
 		}
 		if err != nil {
 			logrus.Errorf("stepBatchWithCondition failed: %v", err)
@@ -173,21 +314,25 @@ func (w *Worker) StepBatchByTimeSplitKey() error {
 	return nil
 }
 
-func (w *Worker) stepBatchWithTimeCondition(conditionSql string, batchSize int64) error {
+func (w *Worker) stepBatchWithTimeCondition(ctx context.Context, conditionSql string, batchSize int64) error {
 	var offset int64 = 0
+	if w.Cfg.DeterministicOrder {
+		// LIMIT/OFFSET pages are only stable over an ordered query
+		conditionSql = fmt.Sprintf("%s ORDER BY %s", conditionSql, w.Cfg.SourceSplitTimeKey)
+	}
 	for {
 		batchSql := fmt.Sprintf("%s LIMIT %d OFFSET %d", conditionSql, batchSize, offset)
-		data, columns, err := w.Src.QueryTableData(1, batchSql)
+		data, columns, err := w.queryTableData(ctx, 1, batchSql)
 		if err != nil {
-			return err
+			return sourceError(err)
 		}
 		if len(data) == 0 {
 			break
 		}
-		err = w.Ig.DoRetry(
-			func() error {
-				return w.Ig.IngestData(1, columns, data)
-			})
+		_, err = w.ingest(w.Ig, w.Cfg.DatabendTable, batchSql, 0, 1, columns, data)
+		if err == errLimitReached {
+			break
+		}
 		if err != nil {
 			logrus.Errorf("Failed to ingest data between %s into Databend: %v", conditionSql, err)
 			return err
@@ -197,26 +342,62 @@ func (w *Worker) stepBatchWithTimeCondition(conditionSql string, batchSize int64
 	return nil
 }
 
-func (w *Worker) stepBatchWithTimeConditionMssql(conditionSql string, batchSize int64) error {
+// stepBatchWithKeyset reads the rows of conditionSql in pages of batchSize
+// rows ordered by the split key, each page starting after the last key of
+// the previous one, so the cost of a page does not grow with its offset.
+func (w *Worker) stepBatchWithKeyset(ctx context.Context, conditionSql string, batchSize int64) error {
+	lastKey := ""
+	for {
+		batchSql := source.KeysetPage(w.Cfg, conditionSql, lastKey, batchSize)
+		data, columns, err := w.queryTableData(ctx, 1, batchSql)
+		if err != nil {
+			return sourceError(err)
+		}
+		if len(data) == 0 {
+			break
+		}
+		lastKey, err = source.LastKey(w.Cfg, columns, data)
+		if err != nil {
+			return err
+		}
+		_, err = w.ingest(w.Ig, w.Cfg.DatabendTable, batchSql, 0, 1, columns, data)
+		if err == errLimitReached {
+			break
+		}
+		if err != nil {
+			logrus.Errorf("Failed to ingest data between %s into Databend: %v", conditionSql, err)
+			return err
+		}
+		if int64(len(data)) < batchSize {
+			break
+		}
+	}
+	return nil
+}
+
+func (w *Worker) stepBatchWithTimeConditionMssql(ctx context.Context, conditionSql string, batchSize int64) error {
 	var offset int64 = 0
+	if w.Cfg.DeterministicOrder {
+		conditionSql = fmt.Sprintf("%s ORDER BY %s", conditionSql, w.Cfg.SourceSplitTimeKey)
+	}
 	conditionSql = ensureOrderBy(conditionSql)
 	fmt.Println("conditionSql", conditionSql)
 	for {
 		batchSql := fmt.Sprintf("%s OFFSET %d ROWS FETCH NEXT %d ROWS ONLY", conditionSql, offset, batchSize)
 
-		data, columns, err := w.Src.QueryTableData(1, batchSql)
+		data, columns, err := w.queryTableData(ctx, 1, batchSql)
 		if err != nil {
-			return err
+			return sourceError(err)
 		}
 
 		if len(data) == 0 {
 			break
 		}
 
-		err = w.Ig.DoRetry(
-			func() error {
-				return w.Ig.IngestData(1, columns, data)
-			})
+		_, err = w.ingest(w.Ig, w.Cfg.DatabendTable, batchSql, 0, 1, columns, data)
+		if err == errLimitReached {
+			break
+		}
 		if err != nil {
 			logrus.Errorf("Failed to ingest data between %s into Databend: %v", conditionSql, err)
 			return err
@@ -227,13 +408,18 @@ func (w *Worker) stepBatchWithTimeConditionMssql(conditionSql string, batchSize
 	return nil
 }
 
-func (w *Worker) IsWorkerCorrect() (int, int, bool) {
+func (w *Worker) IsWorkerCorrect(ctx context.Context) (int, int, bool) {
 	syncedCount, err := w.Ig.GetAllSyncedCount()
 	if err != nil {
 		logrus.Errorf("GetAllSyncedCount failed: %v", err)
 		return 0, 0, false
 	}
-	sourceCount, err := w.Src.GetAllSourceReadRowsCount()
+	planner, err := w.planner()
+	if err != nil {
+		logrus.Errorf("GetAllSourceReadRowsCount failed: %v", err)
+		return 0, 0, false
+	}
+	sourceCount, err := planner.GetAllSourceReadRowsCount(ctx)
 	if err != nil {
 		logrus.Errorf("GetAllSourceReadRowsCount failed: %v", err)
 		return 0, 0, false
@@ -245,15 +431,51 @@ func (w *Worker) Run(ctx context.Context) {
 	logrus.Printf("Worker %s checking before start", w.Name)
 
 	logrus.Printf("Starting worker %s", w.Name)
-	if w.Cfg.SourceSplitTimeKey != "" {
-		err := w.StepBatchByTimeSplitKey()
+	defer w.tableComplete()
+	if w.deadlineReached() || ctx.Err() != nil {
+		w.stop("")
+		return
+	}
+	if w.Cfg.DiffSync {
+		if err := w.diffSync(ctx); err != nil {
+			logrus.Errorf("diffSync failed: %v", w.fail(err))
+		}
+		return
+	}
+	w.step(ctx)
+}
+
+// step reads the whole source in the way it supports and ingests it, a
+// failure is recorded as the error of the worker.
+func (w *Worker) step(ctx context.Context) {
+	if fs, ok := w.Src.(source.FileSourcer); ok {
+		err := w.stepFiles(ctx, fs)
+		if err != nil {
+			logrus.Errorf("stepFiles failed: %v", w.fail(err))
+		}
+	} else if w.Cfg.SourceSnapshot {
+		sr, ok := w.Src.(source.SnapshotReader)
+		if !ok {
+			logrus.Errorf("stepSnapshot failed: %v", w.fail(fmt.Errorf("%s sources can't read a snapshot", w.Cfg.DatabaseType)))
+			return
+		}
+		if err := w.stepSnapshot(ctx, sr); err != nil {
+			logrus.Errorf("stepSnapshot failed: %v", w.fail(err))
+		}
+	} else if ss, ok := w.Src.(source.SliceSourcer); ok {
+		err := w.stepSlices(ctx, ss)
+		if err != nil {
+			logrus.Errorf("stepSlices failed: %v", w.fail(err))
+		}
+	} else if w.Cfg.SourceSplitTimeKey != "" {
+		err := w.StepBatchByTimeSplitKey(ctx)
 		if err != nil {
-			logrus.Errorf("StepBatchByTimeSplitKey failed: %v", err)
+			logrus.Errorf("StepBatchByTimeSplitKey failed: %v", w.fail(err))
 		}
 	} else {
-		err := w.stepBatch()
+		err := w.stepBatch(ctx)
 		if err != nil {
-			logrus.Errorf("stepBatch failed: %v", err)
+			logrus.Errorf("stepBatch failed: %v", w.fail(err))
 		}
 	}
 }